@@ -0,0 +1,466 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/yandex-cloud/geesefs/core/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Orchestration software may manage the namespace through the Admin gRPC
+// service (core/pb/admin.proto) started with --admin-grpc instead of running
+// commands on the mountpoint. Changes go through the same inodes as FUSE
+// operations and are flushed to the backend like them.
+
+type AdminServer struct {
+	pb.UnimplementedAdminServer
+	fs *Goofys
+}
+
+// Methods which change the namespace, file data or the cache need the admin
+// role of --http-auth, others need the read role
+var adminGrpcMutating = map[string]bool{
+	"SetSymlink":  true,
+	"SetXattr":    true,
+	"RemoveXattr": true,
+	"Pin":         true,
+	"Unpin":       true,
+	"Invalidate":  true,
+}
+
+// StartAdminServer listens on a port, host:port or unix:/path/to/socket and
+// serves the Admin service in the background. Without auth, only unix
+// sockets are allowed and they are only accessible by the owner.
+func (fs *Goofys) StartAdminServer(addr string, auth *HTTPAuth) (*grpc.Server, error) {
+	var l net.Listener
+	var err error
+	if strings.HasPrefix(addr, "unix:") {
+		path := addr[len("unix:"):]
+		// Remove the socket left by a previous run
+		os.Remove(path)
+		if auth != nil {
+			l, err = net.Listen("unix", path)
+		} else {
+			l, err = listenPrivateUnix(path)
+			if err == nil {
+				err = os.Chmod(path, 0600)
+				if err != nil {
+					l.Close()
+					return nil, fmt.Errorf("failed to make %v private: %v", path, err)
+				}
+			}
+		}
+	} else {
+		if auth == nil {
+			return nil, fmt.Errorf("admin gRPC service on a TCP port requires --http-auth, use a unix socket otherwise")
+		}
+		if strings.Index(addr, ":") == -1 {
+			addr = "127.0.0.1:" + addr
+		}
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var opts []grpc.ServerOption
+	if auth != nil {
+		opts = append(opts, grpc.Creds(peerCredentials{}), grpc.UnaryInterceptor(auth.grpcInterceptor))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterAdminServer(srv, &AdminServer{fs: fs})
+	go func() {
+		err := srv.Serve(l)
+		if err != nil {
+			log.Errorf("Admin gRPC server on %v failed: %v", addr, err)
+		}
+	}()
+	log.Infof("Serving admin gRPC requests on %v", addr)
+	return srv, nil
+}
+
+// peerCredentials are insecure transport credentials which remember the peer
+// uid of unix socket connections for HTTPAuth
+type peerCredentials struct{}
+
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+	uid    uint32
+	hasUid bool
+}
+
+func (peerAuthInfo) AuthType() string {
+	return "peer"
+}
+
+func (peerCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("peer credentials are only used by the server")
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	info := peerAuthInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}
+	info.uid, info.hasUid = peerUid(conn)
+	return conn, info, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "insecure"}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// grpcInterceptor checks roles like Handler does for HTTP requests: by the
+// peer uid on unix sockets and by the bearer token of the "authorization"
+// metadata
+func (auth *HTTPAuth) grpcInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var uid uint32
+	var hasUid bool
+	if p, ok := peer.FromContext(ctx); ok {
+		if pi, ok := p.AuthInfo.(peerAuthInfo); ok {
+			uid, hasUid = pi.uid, pi.hasUid
+		}
+	}
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+	}
+	role := auth.grantedRole(uid, hasUid, authorization)
+	if role == "" {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	if adminGrpcMutating[method] && role != HTTPRoleAdmin {
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return handler(ctx, req)
+}
+
+// adminError converts errors of inode operations to gRPC status codes
+func adminError(err error) error {
+	if err == nil {
+		return nil
+	}
+	err = mapAwsError(err)
+	code := codes.Internal
+	switch err {
+	case syscall.ENOENT, ENOATTR:
+		code = codes.NotFound
+	case syscall.EEXIST:
+		code = codes.AlreadyExists
+	case syscall.EACCES, syscall.EPERM, syscall.EROFS:
+		code = codes.PermissionDenied
+	case syscall.EINVAL, syscall.ENOTDIR, syscall.EISDIR, syscall.ENAMETOOLONG:
+		code = codes.InvalidArgument
+	case syscall.ENOTSUP, syscall.ENOSYS:
+		code = codes.Unimplemented
+	case syscall.EDQUOT, syscall.ENOSPC, syscall.E2BIG:
+		code = codes.ResourceExhausted
+	case syscall.ESTALE, syscall.EBUSY:
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+func (srv *AdminServer) lookup(path string) (*Inode, error) {
+	return srv.fs.LookupPath(strings.Trim(path, "/"))
+}
+
+// isSymlink loads metadata of the inode if it's not known yet, listings don't
+// return it
+// LOCKS_EXCLUDED(inode.mu)
+func (inode *Inode) isSymlink() (bool, error) {
+	if inode.isDir() {
+		return false, nil
+	}
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	err := inode.fillXattr()
+	if err != nil {
+		return false, err
+	}
+	return inode.userMetadata[inode.fs.flags.SymlinkAttr] != nil, nil
+}
+
+// readDirAll returns all entries of the directory, loading its listing if
+// it's expired
+func readDirAll(dir *Inode) (children []*Inode, err error) {
+	dh := dir.OpenDir()
+	dh.mu.Lock()
+	for {
+		var en *Inode
+		en, err = dh.ReadDir()
+		if err != nil || en == nil {
+			break
+		}
+		if dh.lastInternalOffset >= 2 {
+			children = append(children, en)
+		}
+		dh.Next(en.Name)
+	}
+	dh.CloseDir()
+	dh.mu.Unlock()
+	return
+}
+
+func (srv *AdminServer) listSymlinks(dir *Inode, recursive bool, resp *pb.ListSymlinksResponse) error {
+	children, err := readDirAll(dir)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if child.isDir() {
+			if recursive {
+				err = srv.listSymlinks(child, recursive, resp)
+			}
+		} else {
+			var link bool
+			link, err = child.isSymlink()
+			if link {
+				var target string
+				target, err = child.ReadSymlink()
+				resp.Symlinks = append(resp.Symlinks, &pb.Symlink{Path: child.FullName(), Target: target})
+			}
+		}
+		if err != nil && mapAwsError(err) != syscall.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *AdminServer) ListSymlinks(ctx context.Context, req *pb.ListSymlinksRequest) (*pb.ListSymlinksResponse, error) {
+	dir, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	if !dir.isDir() {
+		return nil, adminError(syscall.ENOTDIR)
+	}
+	resp := &pb.ListSymlinksResponse{}
+	err = srv.listSymlinks(dir, req.Recursive, resp)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return resp, nil
+}
+
+func (srv *AdminServer) GetSymlink(ctx context.Context, req *pb.GetSymlinkRequest) (*pb.GetSymlinkResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	link, err := inode.isSymlink()
+	if err != nil {
+		return nil, adminError(err)
+	}
+	if !link {
+		return nil, status.Errorf(codes.InvalidArgument, "%v is not a symlink", req.Path)
+	}
+	target, err := inode.ReadSymlink()
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.GetSymlinkResponse{Target: target}, nil
+}
+
+func (srv *AdminServer) SetSymlink(ctx context.Context, req *pb.SetSymlinkRequest) (*pb.SetSymlinkResponse, error) {
+	parent, name, err := srv.fs.LookupParent(strings.Trim(req.Path, "/"))
+	if err != nil {
+		return nil, adminError(err)
+	}
+	if name == "" || req.Target == "" {
+		return nil, adminError(syscall.EINVAL)
+	}
+	old, err := parent.LookUpCached(name)
+	if err == nil {
+		if !req.Replace {
+			return nil, adminError(syscall.EEXIST)
+		}
+		if old.isDir() {
+			return nil, adminError(syscall.EISDIR)
+		}
+		err = parent.Unlink(name)
+	}
+	if err != nil && mapAwsError(err) != syscall.ENOENT {
+		return nil, adminError(err)
+	}
+	inode, err := parent.CreateSymlink(name, req.Target)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	// There is no kernel lookup to hold the reference
+	inode.mu.Lock()
+	inode.DeRef(1)
+	inode.mu.Unlock()
+	return &pb.SetSymlinkResponse{}, nil
+}
+
+func (srv *AdminServer) ListXattrs(ctx context.Context, req *pb.ListXattrsRequest) (*pb.ListXattrsResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	names, err := inode.ListXattr()
+	if err != nil {
+		return nil, adminError(err)
+	}
+	resp := &pb.ListXattrsResponse{}
+	for _, name := range names {
+		value, err := inode.GetXattr(name)
+		if err == nil {
+			resp.Xattrs = append(resp.Xattrs, &pb.Xattr{Name: name, Value: value})
+		}
+	}
+	return resp, nil
+}
+
+func (srv *AdminServer) GetXattr(ctx context.Context, req *pb.GetXattrRequest) (*pb.GetXattrResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	value, err := inode.GetXattr(req.Name)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.GetXattrResponse{Value: value}, nil
+}
+
+func (srv *AdminServer) SetXattr(ctx context.Context, req *pb.SetXattrRequest) (*pb.SetXattrResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	err = inode.SetXattr(req.Name, req.Value, 0)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.SetXattrResponse{}, nil
+}
+
+func (srv *AdminServer) RemoveXattr(ctx context.Context, req *pb.RemoveXattrRequest) (*pb.RemoveXattrResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	err = inode.RemoveXattr(req.Name)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.RemoveXattrResponse{}, nil
+}
+
+// Pin loads the file into the cache and keeps its data there until Unpin:
+// it's only evicted when nothing else is left, like data protected by
+// --cache-policy
+func (srv *AdminServer) Pin(ctx context.Context, req *pb.PinRequest) (*pb.PinResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	if inode.isDir() {
+		return nil, adminError(syscall.EISDIR)
+	}
+	inode.mu.Lock()
+	pinned := inode.pinned
+	if !pinned {
+		inode.pinned = true
+		inode.Ref()
+		atomic.AddInt32(&srv.fs.pinnedCount, 1)
+	}
+	inode.mu.Unlock()
+	size, _, err := srv.fs.prefillFile(inode.FullName(), "")
+	if err != nil {
+		if !pinned {
+			srv.fs.unpin(inode)
+		}
+		return nil, adminError(err)
+	}
+	return &pb.PinResponse{Bytes: size}, nil
+}
+
+// LOCKS_EXCLUDED(inode.mu)
+func (fs *Goofys) unpin(inode *Inode) {
+	inode.mu.Lock()
+	if inode.pinned {
+		inode.pinned = false
+		atomic.AddInt32(&fs.pinnedCount, -1)
+		inode.DeRef(1)
+	}
+	inode.mu.Unlock()
+}
+
+func (srv *AdminServer) Unpin(ctx context.Context, req *pb.UnpinRequest) (*pb.UnpinResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	srv.fs.unpin(inode)
+	return &pb.UnpinResponse{}, nil
+}
+
+func (srv *AdminServer) ListPins(ctx context.Context, req *pb.ListPinsRequest) (*pb.ListPinsResponse, error) {
+	var scan []*Inode
+	srv.fs.mu.RLock()
+	for _, inode := range srv.fs.inodes {
+		if inode.dir == nil {
+			scan = append(scan, inode)
+		}
+	}
+	srv.fs.mu.RUnlock()
+	resp := &pb.ListPinsResponse{}
+	for _, inode := range scan {
+		inode.mu.Lock()
+		if inode.pinned {
+			resp.Paths = append(resp.Paths, inode.FullName())
+		}
+		inode.mu.Unlock()
+	}
+	sort.Strings(resp.Paths)
+	return resp, nil
+}
+
+// Invalidate drops cached metadata and data of the path if it changed in the
+// backend, directories are listed again
+func (srv *AdminServer) Invalidate(ctx context.Context, req *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	inode, err := srv.lookup(req.Path)
+	if err == nil {
+		err = srv.fs.RefreshInodeCache(inode)
+	}
+	if err != nil && mapAwsError(err) != syscall.ENOENT {
+		return nil, adminError(err)
+	}
+	return &pb.InvalidateResponse{}, nil
+}
+
+func (srv *AdminServer) GetDirGeneration(ctx context.Context, req *pb.GetDirGenerationRequest) (*pb.GetDirGenerationResponse, error) {
+	dir, err := srv.lookup(req.Path)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	gen, err := dir.DirGeneration()
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.GetDirGenerationResponse{Generation: gen}, nil
+}
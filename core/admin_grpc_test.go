@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+	"github.com/yandex-cloud/geesefs/core/pb"
+)
+
+type AdminGrpcTest struct{}
+
+var _ = Suite(&AdminGrpcTest{})
+
+func (s *AdminGrpcTest) TestAdminGrpcNoCloud(t *C) {
+	sock := t.MkDir() + "/admin.sock"
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = time.Hour
+		if i == 0 {
+			flags.AdminGrpc = "unix:" + sock
+		}
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+
+	// Without --http-auth, the socket is only accessible by the owner
+	st, err := os.Stat(sock)
+	t.Assert(err, IsNil)
+	t.Assert(st.Mode().Perm(), Equals, os.FileMode(0600))
+
+	conn, err := grpc.NewClient("unix:"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	t.Assert(err, IsNil)
+	defer conn.Close()
+	admin := pb.NewAdminClient(conn)
+	ctx := context.Background()
+
+	// Symlinks created by the API are flushed like any other
+	c.Store.Put("dir/file", []byte("data"), nil)
+	_, err = admin.SetSymlink(ctx, &pb.SetSymlinkRequest{Path: "dir/link", Target: "file"})
+	t.Assert(err, IsNil)
+	_, err = admin.SetSymlink(ctx, &pb.SetSymlinkRequest{Path: "dir/link", Target: "other"})
+	t.Assert(status.Code(err), Equals, codes.AlreadyExists)
+	_, err = admin.SetSymlink(ctx, &pb.SetSymlinkRequest{Path: "/dir/link", Target: "../dir/file", Replace: true})
+	t.Assert(err, IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	link, err := b.fs.LookupPath("dir/link")
+	t.Assert(err, IsNil)
+	isLink, err := link.isSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(isLink, Equals, true)
+	target, err := link.ReadSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(target, Equals, "../dir/file")
+
+	list, err := admin.ListSymlinks(ctx, &pb.ListSymlinksRequest{Recursive: true})
+	t.Assert(err, IsNil)
+	t.Assert(len(list.Symlinks), Equals, 1)
+	t.Assert(list.Symlinks[0].Path, Equals, "dir/link")
+	t.Assert(list.Symlinks[0].Target, Equals, "../dir/file")
+	list, err = admin.ListSymlinks(ctx, &pb.ListSymlinksRequest{})
+	t.Assert(err, IsNil)
+	t.Assert(len(list.Symlinks), Equals, 0)
+	got, err := admin.GetSymlink(ctx, &pb.GetSymlinkRequest{Path: "dir/link"})
+	t.Assert(err, IsNil)
+	t.Assert(got.Target, Equals, "../dir/file")
+	_, err = admin.GetSymlink(ctx, &pb.GetSymlinkRequest{Path: "dir/file"})
+	t.Assert(status.Code(err), Equals, codes.InvalidArgument)
+
+	// Xattrs
+	_, err = admin.SetXattr(ctx, &pb.SetXattrRequest{Path: "dir/file", Name: "user.dataset", Value: []byte("run1")})
+	t.Assert(err, IsNil)
+	xattrs, err := admin.ListXattrs(ctx, &pb.ListXattrsRequest{Path: "dir/file"})
+	t.Assert(err, IsNil)
+	values := make(map[string]string)
+	for _, x := range xattrs.Xattrs {
+		values[x.Name] = string(x.Value)
+	}
+	t.Assert(values["user.dataset"], Equals, "run1")
+	_, err = admin.RemoveXattr(ctx, &pb.RemoveXattrRequest{Path: "dir/file", Name: "user.dataset"})
+	t.Assert(err, IsNil)
+	_, err = admin.GetXattr(ctx, &pb.GetXattrRequest{Path: "dir/file", Name: "user.dataset"})
+	t.Assert(status.Code(err), Equals, codes.NotFound)
+	_, err = admin.GetXattr(ctx, &pb.GetXattrRequest{Path: "missing", Name: "user.dataset"})
+	t.Assert(status.Code(err), Equals, codes.NotFound)
+
+	// Pins load data and protect it from eviction
+	pin, err := admin.Pin(ctx, &pb.PinRequest{Path: "dir/file"})
+	t.Assert(err, IsNil)
+	t.Assert(pin.Bytes, Equals, uint64(4))
+	pins, err := admin.ListPins(ctx, &pb.ListPinsRequest{})
+	t.Assert(err, IsNil)
+	t.Assert(pins.Paths, DeepEquals, []string{"dir/file"})
+	file, _ := a.fs.LookupPath("dir/file")
+	file.mu.Lock()
+	t.Assert(a.fs.cacheProtected(file), Equals, true)
+	t.Assert(file.buffers.Count() > 0, Equals, true)
+	file.mu.Unlock()
+	_, err = admin.Unpin(ctx, &pb.UnpinRequest{Path: "dir/file"})
+	t.Assert(err, IsNil)
+	pins, err = admin.ListPins(ctx, &pb.ListPinsRequest{})
+	t.Assert(err, IsNil)
+	t.Assert(len(pins.Paths), Equals, 0)
+	file.mu.Lock()
+	t.Assert(a.fs.cacheProtected(file), Equals, false)
+	file.mu.Unlock()
+
+	// Invalidation picks up changes made by other mounts
+	t.Assert(b.WriteAndSync("dir/file", []byte("changed")), IsNil)
+	data, err := a.ReadFile("dir/file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "data")
+	_, err = admin.Invalidate(ctx, &pb.InvalidateRequest{Path: "dir/file"})
+	t.Assert(err, IsNil)
+	data, err = a.ReadFile("dir/file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "changed")
+}
+
+func (s *AdminGrpcTest) TestAdminGrpcAuthNoCloud(t *C) {
+	dir := t.MkDir()
+	sock := dir + "/admin.sock"
+	err := os.WriteFile(dir+"/auth", []byte("read token:reader\nadmin token:root\n"), 0600)
+	t.Assert(err, IsNil)
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.AdminGrpc = "unix:" + sock
+		flags.HTTPAuth = dir + "/auth"
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	c.Store.Put("file", []byte("data"), nil)
+
+	// TCP ports are refused without authentication
+	_, err = c.Mounts[0].fs.StartAdminServer("0", nil)
+	t.Assert(err, NotNil)
+
+	conn, err := grpc.NewClient("unix:"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	t.Assert(err, IsNil)
+	defer conn.Close()
+	admin := pb.NewAdminClient(conn)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err = admin.ListXattrs(context.Background(), &pb.ListXattrsRequest{Path: "file"})
+	t.Assert(status.Code(err), Equals, codes.Unauthenticated)
+	_, err = admin.ListXattrs(withToken("wrong"), &pb.ListXattrsRequest{Path: "file"})
+	t.Assert(status.Code(err), Equals, codes.Unauthenticated)
+	_, err = admin.ListXattrs(withToken("reader"), &pb.ListXattrsRequest{Path: "file"})
+	t.Assert(err, IsNil)
+	set := &pb.SetXattrRequest{Path: "file", Name: "user.dataset", Value: []byte("run1")}
+	_, err = admin.SetXattr(withToken("reader"), set)
+	t.Assert(status.Code(err), Equals, codes.PermissionDenied)
+	_, err = admin.SetSymlink(withToken("reader"), &pb.SetSymlinkRequest{Path: "file", Target: "x", Replace: true})
+	t.Assert(status.Code(err), Equals, codes.PermissionDenied)
+	_, err = admin.SetXattr(withToken("root"), set)
+	t.Assert(err, IsNil)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package core
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivateUnix creates a unix socket which is only accessible by the
+// owner from the start: a chmod after creating it would leave a window when
+// others may connect
+func listenPrivateUnix(path string) (net.Listener, error) {
+	prev := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(prev)
+	return l, err
+}
@@ -0,0 +1,9 @@
+package core
+
+import (
+	"net"
+)
+
+func listenPrivateUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
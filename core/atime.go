@@ -0,0 +1,153 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Access time handling (--atime):
+//
+//   - off: atime is reported equal to ctime and nothing is tracked
+//   - relatime: atime is kept in memory and updated on reads if it's older
+//     than mtime or than a day, like Linux relatime does
+//   - lazy: like relatime, but atime is also stored in the atimes section
+//     of the .geesefs_meta object of the directory (--dir-meta-file), for
+//     retention tooling. Objects themselves are never changed. It's stored
+//     at most once a day per file, and updated files are stored in batches
+//     every --atime-flush-interval, with one update of .geesefs_meta per
+//     directory
+//
+// Reads through handles opened with O_NOATIME never update atime.
+
+const relatimeInterval = 24 * time.Hour
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) touchAtime(now time.Time) {
+	fs := inode.fs
+	if fs.flags.Atime == "off" || inode.isDir() {
+		return
+	}
+	lazy := fs.flags.Atime == "lazy"
+	// In lazy mode atime is stored separately from the object, so an
+	// atime older than mtime doesn't have to be refreshed
+	if !inode.atime.IsZero() && now.Sub(inode.atime) < relatimeInterval &&
+		(lazy || inode.atime.After(inode.Attributes.Mtime)) {
+		return
+	}
+	inode.atime = now
+	if !lazy || fs.flags.MetaReadOnly || inode.inTimeTravel() || inode.inClone() != nil {
+		return
+	}
+	fs.atimeMu.Lock()
+	fs.atimePending[inode] = true
+	fs.atimeMu.Unlock()
+}
+
+// applyAtime sets the atime of a child loaded from the .geesefs_meta of
+// parent if it's newer than the known one
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) applyAtime(child *Inode) {
+	meta := parent.dir.childMeta
+	if meta == nil || child.isDir() || parent.fs.flags.Atime != "lazy" {
+		return
+	}
+	unix, ok := meta.Atimes[child.Name]
+	if !ok {
+		return
+	}
+	child.mu.Lock()
+	if atime := time.Unix(unix, 0); atime.After(child.atime) {
+		child.atime = atime
+	}
+	child.atimeStored = true
+	child.mu.Unlock()
+}
+
+// dropAtime removes the stored atime of a file which is deleted or renamed
+// away from parent
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dropAtime(parent *Inode, name string) {
+	fs := inode.fs
+	if !inode.atimeStored || fs.flags.MetaReadOnly {
+		return
+	}
+	inode.atimeStored = false
+	if inode.CacheState != ST_DELETED && !inode.atime.IsZero() {
+		// Renamed, store it with the new name in the next batch
+		fs.atimeMu.Lock()
+		fs.atimePending[inode] = true
+		fs.atimeMu.Unlock()
+	}
+	cloud, key := parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	go func() {
+		err := updateDirMeta(cloud, key, setDirMetaEntry(metaAtimes, name, nil))
+		if err != nil {
+			log.Warnf("Failed to remove access time of %v from %v: %v", name, key, err)
+		}
+	}()
+}
+
+// flushAtimes stores pending access times, grouped by directory
+func (fs *Goofys) flushAtimes() {
+	fs.atimeMu.Lock()
+	pending := fs.atimePending
+	fs.atimePending = make(map[*Inode]bool)
+	fs.atimeMu.Unlock()
+
+	type atimeBatch struct {
+		cloud  StorageBackend
+		inodes []*Inode
+		merges []dirMetaMerge
+	}
+	batches := make(map[string]*atimeBatch)
+	for inode := range pending {
+		inode.mu.Lock()
+		parent := inode.Parent
+		if parent == nil || inode.CacheState == ST_DEAD || inode.CacheState == ST_DELETED {
+			inode.mu.Unlock()
+			continue
+		}
+		cloud, key := parent.cloud()
+		key = appendChildName(key, dirMetaName)
+		b := batches[key]
+		if b == nil {
+			b = &atimeBatch{cloud: cloud}
+			batches[key] = b
+		}
+		b.inodes = append(b.inodes, inode)
+		b.merges = append(b.merges, setDirMetaEntry(metaAtimes, inode.Name, inode.atime.Unix()))
+		inode.mu.Unlock()
+	}
+	for key, b := range batches {
+		err := updateDirMeta(b.cloud, key, b.merges...)
+		if err != nil {
+			log.Warnf("Failed to store access times of %v files in %v: %v", len(b.inodes), key, err)
+			// Retry with the next batch
+			fs.atimeMu.Lock()
+			for _, inode := range b.inodes {
+				fs.atimePending[inode] = true
+			}
+			fs.atimeMu.Unlock()
+			continue
+		}
+		fs.recordChange("put", key, "", "", 0)
+		for _, inode := range b.inodes {
+			inode.mu.Lock()
+			inode.atimeStored = true
+			inode.mu.Unlock()
+		}
+	}
+}
+
+// AtimeFlusher stores access time updates in batches
+func (fs *Goofys) AtimeFlusher() {
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(fs.flags.AtimeFlushInterval):
+		case <-fs.shutdownCh:
+			return
+		}
+		fs.flushAtimes()
+	}
+}
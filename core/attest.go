@@ -0,0 +1,269 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Attestation reports (geesefs attest) describe the objects under a prefix
+// for archival and compliance: keys, sizes, ETags, version IDs in versioned
+// buckets and SHA-256 of the contents of metadata objects (.geesefs_meta
+// and other .geesefs_* objects), or of all objects if requested. Nothing is
+// written to the bucket: the listing is repeated until two of them match.
+//
+// The report is JSON signed with an Ed25519 key in PEM format, as generated
+// by `openssl genpkey -algorithm ed25519`. The signature covers the compact
+// JSON of the report field, so reports may be reformatted:
+//
+//	{"report": {"bucket": ..., "prefix": ..., "time": ..., "objects": [...]},
+//	 "public_key": "<base64>", "signature": "<base64>"}
+//
+// Verification (geesefs attest --verify) checks the signature with the
+// public or private key and compares the report with the bucket, hashing
+// again the objects which have hashes in the report.
+
+const attestMetaPrefix = ".geesefs_"
+
+type AttestObject struct {
+	Key       string `json:"key"`
+	Size      uint64 `json:"size"`
+	ETag      string `json:"etag,omitempty"`
+	VersionId string `json:"version_id,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+type AttestReport struct {
+	Bucket  string         `json:"bucket"`
+	Prefix  string         `json:"prefix"`
+	Time    time.Time      `json:"time"`
+	Objects []AttestObject `json:"objects"`
+}
+
+type signedAttestReport struct {
+	Report    json.RawMessage `json:"report"`
+	PublicKey []byte          `json:"public_key"`
+	Signature []byte          `json:"signature"`
+}
+
+// LoadAttestKey loads an Ed25519 private or public key from a PEM file.
+// priv is nil for public keys
+func LoadAttestKey(file string) (priv ed25519.PrivateKey, pub ed25519.PublicKey, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%v: no PEM data", file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("%v: unexpected PEM block %v", file, block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", file, err)
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, k.Public().(ed25519.PublicKey), nil
+	case ed25519.PublicKey:
+		return nil, k, nil
+	}
+	return nil, nil, fmt.Errorf("%v: not an Ed25519 key", file)
+}
+
+func isAttestMeta(key string) bool {
+	return strings.HasPrefix(path.Base(key), attestMetaPrefix)
+}
+
+// hashObject returns the hex SHA-256 of the contents of an object version
+func (b *BulkOps) hashObject(key, versionId string) (string, error) {
+	get := &GetBlobInput{Key: key}
+	if versionId != "" {
+		get.VersionId = PString(versionId)
+	}
+	resp, err := b.cloud.GetBlob(get)
+	if err != nil {
+		return "", mapAwsError(err)
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Attest describes all objects under path, or in the whole bucket[:prefix]
+// if path is empty. Metadata objects are hashed, or all objects if hashAll
+func (b *BulkOps) Attest(path string, hashAll bool) (*AttestReport, error) {
+	prefix := b.prefix
+	if key := b.key(path); key != b.prefix {
+		prefix = key + "/"
+	}
+	var items []BlobItemOutput
+	for attempt := 1; ; attempt++ {
+		var err error
+		items, err = listAll(b.flags, b.cloud, prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		check, err := listAll(b.flags, b.cloud, prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		if sameListing(items, check) {
+			break
+		}
+		if attempt >= snapshotAttempts {
+			return nil, fmt.Errorf("%v changes too often to attest it", prefix)
+		}
+		log.Infof("%v changed during attestation, retrying (attempt %v)", prefix, attempt)
+	}
+	snap := &Snapshot{Prefix: prefix, Items: items}
+	if versions, ok := versionsOf(b.cloud); ok {
+		snap.pinVersions(b.flags, versions)
+	}
+	report := &AttestReport{
+		Bucket:  b.cloud.Bucket(),
+		Prefix:  prefix,
+		Time:    clock.Now().UTC(),
+		Objects: make([]AttestObject, len(items)),
+	}
+	for i, item := range items {
+		report.Objects[i] = AttestObject{
+			Key:       *item.Key,
+			Size:      item.Size,
+			ETag:      NilStr(item.ETag),
+			VersionId: snap.VersionIds[*item.Key],
+		}
+	}
+	err := b.parallel(len(items), func(i int) error {
+		obj := &report.Objects[i]
+		if !hashAll && !isAttestMeta(obj.Key) {
+			return nil
+		}
+		var err error
+		obj.SHA256, err = b.hashObject(obj.Key, obj.VersionId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Sign returns the signed report
+func (r *AttestReport) Sign(key ed25519.PrivateKey) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(&signedAttestReport{
+		Report:    data,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, data),
+	}, "", "  ")
+}
+
+// ReadAttestReport checks the signature of a report with key and parses it
+func ReadAttestReport(data []byte, key ed25519.PublicKey) (*AttestReport, error) {
+	var signed signedAttestReport
+	err := json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, err
+	}
+	var compact bytes.Buffer
+	err = json.Compact(&compact, signed.Report)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, compact.Bytes(), signed.Signature) {
+		return nil, fmt.Errorf("invalid signature of the report")
+	}
+	var report AttestReport
+	err = json.Unmarshal(signed.Report, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// VerifyAttestReport compares a report with the objects in the bucket now
+// and returns the differences
+func (b *BulkOps) VerifyAttestReport(report *AttestReport) ([]string, error) {
+	if report.Bucket != b.cloud.Bucket() {
+		return nil, fmt.Errorf("the report is for bucket %v, not %v", report.Bucket, b.cloud.Bucket())
+	}
+	items, err := listAll(b.flags, b.cloud, report.Prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]*BlobItemOutput, len(items))
+	for i := range items {
+		current[*items[i].Key] = &items[i]
+	}
+	var diffs []string
+	var hashed []int
+	for i, obj := range report.Objects {
+		item := current[obj.Key]
+		if item == nil {
+			diffs = append(diffs, fmt.Sprintf("missing: %v", obj.Key))
+			continue
+		}
+		delete(current, obj.Key)
+		if item.Size != obj.Size || NilStr(item.ETag) != obj.ETag {
+			diffs = append(diffs, fmt.Sprintf("changed: %v (size %v -> %v, etag %v -> %v)",
+				obj.Key, obj.Size, item.Size, obj.ETag, NilStr(item.ETag)))
+			if obj.VersionId == "" {
+				continue
+			}
+		}
+		if obj.SHA256 != "" {
+			hashed = append(hashed, i)
+		}
+	}
+	for _, item := range items {
+		if current[*item.Key] != nil {
+			diffs = append(diffs, fmt.Sprintf("added: %v", *item.Key))
+		}
+	}
+	// Objects are hashed as they are now, or their attested versions
+	hashes := make([]string, len(hashed))
+	err = b.parallel(len(hashed), func(i int) error {
+		obj := &report.Objects[hashed[i]]
+		var err error
+		hashes[i], err = b.hashObject(obj.Key, obj.VersionId)
+		if err != nil {
+			return fmt.Errorf("%v: %v", obj.Key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range hashes {
+		obj := &report.Objects[hashed[i]]
+		if h != obj.SHA256 {
+			diffs = append(diffs, fmt.Sprintf("checksum mismatch: %v", obj.Key))
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"crypto/ed25519"
+
+	. "gopkg.in/check.v1"
+)
+
+type AttestTest struct{}
+
+var _ = Suite(&AttestTest{})
+
+func (s *AttestTest) TestAttestNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, jobs: 1}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	t.Assert(err, IsNil)
+
+	c.Store.Put("run/a", []byte("a"), nil)
+	c.Store.Put("run/sub/b", []byte("bb"), nil)
+	c.Store.Put("run/.geesefs_meta", []byte("{}"), nil)
+	c.Store.Put("runs", []byte("c"), nil)
+
+	report, err := b.Attest("run", false)
+	t.Assert(err, IsNil)
+	t.Assert(report.Prefix, Equals, "run/")
+	t.Assert(len(report.Objects), Equals, 3)
+	// Only metadata objects are hashed by default
+	for _, obj := range report.Objects {
+		t.Assert(obj.VersionId, Not(Equals), "")
+		t.Assert(obj.SHA256 != "", Equals, obj.Key == "run/.geesefs_meta")
+	}
+	t.Assert(m.Conn.Calls("GetBlob"), Equals, 1)
+	t.Assert(m.Conn.Calls("PutBlob"), Equals, 0)
+
+	data, err := report.Sign(priv)
+	t.Assert(err, IsNil)
+	read, err := ReadAttestReport(data, pub)
+	t.Assert(err, IsNil)
+	t.Assert(read.Objects, DeepEquals, report.Objects)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	_, err = ReadAttestReport(data, otherPub)
+	t.Assert(err, ErrorMatches, "invalid signature.*")
+
+	diffs, err := b.VerifyAttestReport(read)
+	t.Assert(err, IsNil)
+	t.Assert(diffs, IsNil)
+
+	// Pinned versions are hashed even if objects are overwritten
+	c.Store.Put("run/.geesefs_meta", []byte("[]"), nil)
+	c.Store.Put("run/c", []byte("c"), nil)
+	_, err = m.Conn.DeleteBlob(&DeleteBlobInput{Key: "run/a"})
+	t.Assert(err, IsNil)
+	read.Objects[2].SHA256 = "0000"
+	diffs, err = b.VerifyAttestReport(read)
+	t.Assert(err, IsNil)
+	t.Assert(len(diffs), Equals, 4)
+	t.Assert(diffs[0], Matches, "changed: run/.geesefs_meta .*")
+	t.Assert(diffs[1:], DeepEquals, []string{
+		"missing: run/a",
+		"added: run/c",
+		"checksum mismatch: run/sub/b",
+	})
+}
@@ -15,10 +15,18 @@
 package core
 
 import (
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/yandex-cloud/geesefs/core/cfg"
 	. "gopkg.in/check.v1"
 
+	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -209,3 +217,439 @@ func (s *S3BucketEventualConsistency) RemoveBucket(param *RemoveBucketInput) (*R
 
 	return nil, syscall.ENXIO
 }
+
+func (s *AwsTest) TestPresignGetBlobNoCloud(t *C) {
+	s3, err := NewS3("bucket", &cfg.FlagStorage{}, &cfg.S3Config{
+		Region:    "us-east-1",
+		AccessKey: "key",
+		SecretKey: "secret",
+	})
+	t.Assert(err, IsNil)
+	presigned, err := s3.PresignGetBlob("dir/file", 10*time.Minute)
+	t.Assert(err, IsNil)
+	t.Assert(strings.Contains(presigned, "/bucket/dir/file?") || strings.Contains(presigned, "bucket.s3.amazonaws.com/dir/file?"), Equals, true)
+	t.Assert(strings.Contains(presigned, "X-Amz-Expires=600"), Equals, true)
+}
+
+func (s *AwsTest) TestClockSkewNoCloud(t *C) {
+	serverOffset := time.Hour
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverNow := time.Now().Add(serverOffset)
+		w.Header().Set("Date", serverNow.UTC().Format(http.TimeFormat))
+		reqTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil || reqTime.Sub(serverNow).Abs() > 15*time.Minute {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>RequestTimeTooSkewed</Code><Message>skewed</Message></Error>")
+			return
+		}
+		fmt.Fprint(w, "data")
+	}))
+	defer srv.Close()
+
+	s3, err := NewS3("bucket", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:           "us-east-1",
+		AccessKey:        "key",
+		SecretKey:        "secret",
+		SDKMaxRetries:    3,
+		SDKMinRetryDelay: time.Millisecond,
+		SDKMaxRetryDelay: time.Millisecond,
+	})
+	t.Assert(err, IsNil)
+	resp, err := s3.GetBlob(&GetBlobInput{Key: "file"})
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+	t.Assert((s3.now().Sub(time.Now())-serverOffset).Abs() < 2*time.Second, Equals, true)
+}
+
+func (s *AwsTest) TestExpressNoCloud(t *C) {
+	var mu sync.Mutex
+	sessions, expire := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, "/us-east-1/s3express/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Has("session") {
+			sessions++
+			fmt.Fprintf(w, "<CreateSessionResult><Credentials><SessionToken>token%v</SessionToken>"+
+				"<SecretAccessKey>secret</SecretAccessKey><AccessKeyId>session</AccessKeyId>"+
+				"<Expiration>%v</Expiration></Credentials></CreateSessionResult>",
+				sessions, time.Now().Add(5*time.Minute).UTC().Format(time.RFC3339))
+			return
+		}
+		if expire || r.Header.Get("X-Amz-S3session-Token") != fmt.Sprintf("token%v", sessions) ||
+			!strings.Contains(auth, "Credential=session/") {
+			expire = false
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>ExpiredToken</Code><Message>expired</Message></Error>")
+			return
+		}
+		if r.URL.Query().Get("prefix") != "dir/" || r.URL.Query().Has("start-after") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Unsorted pages
+		if r.URL.Query().Get("continuation-token") == "" {
+			fmt.Fprint(w, "<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>2</NextContinuationToken>"+
+				"<Contents><Key>dir/b</Key><Size>1</Size></Contents>"+
+				"<CommonPrefixes><Prefix>dir/c/</Prefix></CommonPrefixes></ListBucketResult>")
+		} else {
+			fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>"+
+				"<Contents><Key>dir/a</Key><Size>1</Size></Contents></ListBucketResult>")
+		}
+	}))
+	defer srv.Close()
+
+	s3, err := NewS3("data--use1-az4--x-s3", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		AccessKey:        "key",
+		SecretKey:        "secret",
+		StorageClass:     "STANDARD",
+		SDKMaxRetries:    3,
+		SDKMinRetryDelay: time.Millisecond,
+		SDKMaxRetryDelay: time.Millisecond,
+	})
+	t.Assert(err, IsNil)
+	t.Assert(s3.Capabilities().UnsortedList, Equals, true)
+	t.Assert(s3.Capabilities().Versioning, Equals, false)
+	t.Assert(s3.config.StorageClass, Equals, "EXPRESS_ONEZONE")
+
+	list := func(prefix, startAfter string) (keys []string) {
+		resp, err := s3.ListBlobs(&ListBlobsInput{
+			Prefix:     PString(prefix),
+			Delimiter:  PString("/"),
+			StartAfter: PString(startAfter),
+			MaxKeys:    PUInt32(1),
+		})
+		t.Assert(err, IsNil)
+		t.Assert(resp.IsTruncated, Equals, false)
+		for _, p := range resp.Prefixes {
+			keys = append(keys, *p.Prefix)
+		}
+		for _, item := range resp.Items {
+			keys = append(keys, *item.Key)
+		}
+		return
+	}
+	t.Assert(list("dir/", ""), DeepEquals, []string{"dir/c/", "dir/a", "dir/b"})
+	t.Assert(list("dir/", "dir/a"), DeepEquals, []string{"dir/c/", "dir/b"})
+	t.Assert(list("dir/b", ""), DeepEquals, []string{"dir/b"})
+	t.Assert(sessions, Equals, 1)
+
+	// Revoked sessions are created again
+	mu.Lock()
+	expire = true
+	mu.Unlock()
+	t.Assert(list("dir/", ""), HasLen, 3)
+	t.Assert(sessions, Equals, 2)
+}
+
+func (s *AwsTest) TestAnonymousNoCloud(t *C) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "" || strings.HasPrefix(r.URL.Path, "/private/") {
+			w.WriteHeader(http.StatusForbidden)
+		} else if r.URL.Path == "/bucket/file" {
+			w.Header().Set("ETag", `"1"`)
+			w.Header().Set("Content-Length", "4")
+			fmt.Fprint(w, "data")
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// No credentials are found
+	s3, err := NewS3("bucket", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:      "us-east-1",
+		RegionSet:   true,
+		Credentials: credentials.NewChainCredentials(nil),
+	})
+	t.Assert(err, IsNil)
+	t.Assert(s3.Init("probe"), IsNil)
+	t.Assert(s3.Anonymous(), Equals, true)
+	resp, err := s3.GetBlob(&GetBlobInput{Key: "file"})
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+
+	// Writes aren't sent
+	mu.Lock()
+	sent := len(requests)
+	mu.Unlock()
+	_, err = s3.PutBlob(&PutBlobInput{Key: "file", Body: strings.NewReader("new"), Size: PUInt64(3)})
+	t.Assert(err, Equals, syscall.EROFS)
+	_, err = s3.DeleteBlobs(&DeleteBlobsInput{Items: []string{"file"}})
+	t.Assert(err, Equals, syscall.EROFS)
+	mu.Lock()
+	t.Assert(requests, HasLen, sent)
+	mu.Unlock()
+
+	// Private buckets aren't accessed anonymously unless asked to
+	s3, err = NewS3("private", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:      "us-east-1",
+		RegionSet:   true,
+		Credentials: credentials.NewChainCredentials(nil),
+	})
+	t.Assert(err, IsNil)
+	t.Assert(s3.Init("probe"), ErrorMatches, ".*doesn't allow anonymous access")
+	s3, err = NewS3("private", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{NoSignRequest: true})
+	t.Assert(err, IsNil)
+	t.Assert(s3.Anonymous(), Equals, true)
+}
+
+func (s *AwsTest) TestAccessPointNoCloud(t *C) {
+	for spec, expected := range map[string]BucketSpec{
+		"arn:aws:s3:eu-west-1:123456789012:accesspoint/ap":               {"s3", "arn:aws:s3:eu-west-1:123456789012:accesspoint/ap", ""},
+		"arn:aws:s3:eu-west-1:123456789012:accesspoint/ap:dir/sub":       {"s3", "arn:aws:s3:eu-west-1:123456789012:accesspoint/ap", "dir/sub/"},
+		"arn:aws:s3:eu-west-1:123456789012:accesspoint:ap:dir":           {"s3", "arn:aws:s3:eu-west-1:123456789012:accesspoint:ap", "dir/"},
+		"ap-abcdefghijklmnopqrstuvwxyz0123-s3alias:dir":                  {"s3", "ap-abcdefghijklmnopqrstuvwxyz0123-s3alias", "dir/"},
+		"arn:aws:s3-object-lambda:eu-west-1:123456789012:accesspoint/ol": {"s3", "arn:aws:s3-object-lambda:eu-west-1:123456789012:accesspoint/ol", ""},
+	} {
+		parsed, err := ParseBucketSpec(spec)
+		t.Assert(err, IsNil)
+		t.Assert(parsed, DeepEquals, expected)
+	}
+
+	// ARNs are sent to access point endpoints in their regions
+	ap, err := NewS3("arn:aws:s3:eu-west-1:123456789012:accesspoint/ap", &cfg.FlagStorage{}, &cfg.S3Config{
+		Region:    "us-east-1",
+		AccessKey: "key",
+		SecretKey: "secret",
+	})
+	t.Assert(err, IsNil)
+	t.Assert(ap.ReadOnly(), Equals, "")
+	t.Assert(ap.Capabilities().ServerSideCopy, Equals, true)
+	req, _ := ap.S3.GetObjectRequest(&s3.GetObjectInput{Bucket: &ap.bucket, Key: PString("dir/file")})
+	t.Assert(req.Build(), IsNil)
+	t.Assert(req.HTTPRequest.URL.Host, Equals, "ap-123456789012.s3-accesspoint.eu-west-1.amazonaws.com")
+	t.Assert(req.HTTPRequest.URL.Path, Equals, "/dir/file")
+
+	// Object Lambda Access Points are read-only and listed with ListObjects v1
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.URL.Query().Has("list-type") {
+			w.WriteHeader(http.StatusNotImplemented)
+		} else if r.URL.Query().Has("prefix") {
+			fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>"+
+				"<Contents><Key>dir/a</Key><Size>1</Size></Contents></ListBucketResult>")
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ol, err := NewS3("ol-abcdefghijklmnopqrstuvwxyz0123--ol-s3", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:    "us-east-1",
+		RegionSet: true,
+		AccessKey: "key",
+		SecretKey: "secret",
+		ListV2:    true,
+	})
+	t.Assert(err, IsNil)
+	t.Assert(ol.Init("probe"), IsNil)
+	t.Assert(ol.ReadOnly(), Not(Equals), "")
+	t.Assert(ol.Capabilities().ServerSideCopy, Equals, false)
+	t.Assert(ol.Capabilities().ConditionalPut, Equals, false)
+	list, err := ol.ListBlobs(&ListBlobsInput{Prefix: PString("dir/")})
+	t.Assert(err, IsNil)
+	t.Assert(list.Items, HasLen, 1)
+	mu.Lock()
+	sent := len(requests)
+	mu.Unlock()
+	_, err = ol.PutBlob(&PutBlobInput{Key: "dir/b", Body: strings.NewReader("b"), Size: PUInt64(1)})
+	t.Assert(err, Equals, syscall.EROFS)
+	mu.Lock()
+	t.Assert(requests, HasLen, sent)
+	mu.Unlock()
+}
+
+type hostRecorder struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (r *hostRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.hosts = append(r.hosts, req.URL.Host)
+	r.mu.Unlock()
+	status := http.StatusNotFound
+	if strings.Contains(req.URL.Host, "s3-accelerate") {
+		// Acceleration isn't enabled for the bucket
+		status = http.StatusBadRequest
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func (s *AwsTest) TestAccelerateFallbackNoCloud(t *C) {
+	s3, err := NewS3("bucket", &cfg.FlagStorage{}, &cfg.S3Config{
+		Region:        "eu-west-1",
+		RegionSet:     true,
+		AccessKey:     "key",
+		SecretKey:     "secret",
+		UseAccelerate: true,
+		UseDualStack:  true,
+	})
+	t.Assert(err, IsNil)
+	rec := &hostRecorder{}
+	s3.awsConfig.HTTPClient = &http.Client{Transport: rec}
+	s3.newS3()
+	t.Assert(s3.Init("probe"), IsNil)
+	t.Assert(*s3.awsConfig.S3UseAccelerate, Equals, false)
+	t.Assert(*s3.awsConfig.UseDualStack, Equals, true)
+	t.Assert(rec.hosts[0], Equals, "bucket.s3-accelerate.dualstack.amazonaws.com")
+	t.Assert(rec.hosts[len(rec.hosts)-1], Equals, "bucket.s3.dualstack.eu-west-1.amazonaws.com")
+}
+
+func (s *AwsTest) TestChecksumNoCloud(t *C) {
+	// CRC64NVME check value
+	h := newS3ChecksumHash("CRC64NVME")
+	h.Write([]byte("123456789"))
+	t.Assert(fmt.Sprintf("%x", h.Sum(nil)), Equals, "ae8b14860a799888")
+
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	checksums := make(map[string]string)
+	var completeBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		q := r.URL.Query()
+		switch {
+		case r.Method == "POST" && q.Has("uploads"):
+			t.Check(r.Header.Get("x-amz-checksum-algorithm"), Equals, "CRC32C")
+			t.Check(r.Header.Get("x-amz-checksum-type"), Equals, "FULL_OBJECT")
+			fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>")
+		case r.Method == "POST":
+			completeBody = string(body)
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"2"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == "PUT":
+			sum, _ := computeChecksum("CRC32C", strings.NewReader(string(body)))
+			if r.Header.Get("x-amz-checksum-crc32c") != sum {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "<Error><Code>BadDigest</Code><Message>checksum</Message></Error>")
+				return
+			}
+			objects[r.URL.Path] = string(body)
+			checksums[r.URL.Path] = sum
+			w.Header().Set("ETag", `"1"`)
+			w.Header().Set("x-amz-checksum-crc32c", sum)
+		case r.URL.Path == "/bucket" && q.Has("uploads"):
+			fmt.Fprint(w, "<ListMultipartUploadsResult></ListMultipartUploadsResult>")
+		case r.URL.Path == "/bucket":
+			var keys []string
+			for path := range objects {
+				key := strings.TrimPrefix(path, "/bucket/")
+				if strings.HasPrefix(key, q.Get("prefix")) && key > q.Get("marker") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+			for _, key := range keys {
+				fmt.Fprintf(w, `<Contents><Key>%v</Key><ETag>"1"</ETag><Size>%v</Size></Contents>`,
+					key, len(objects["/bucket/"+key]))
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		default:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", `"1"`)
+			var first, last int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); err == nil {
+				// Like S3, ranged GETs return no checksums
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", first, last, len(data)))
+				w.WriteHeader(http.StatusPartialContent)
+				fmt.Fprint(w, data[first:last+1])
+				return
+			}
+			if r.Header.Get("x-amz-checksum-mode") == "ENABLED" {
+				w.Header().Set("x-amz-checksum-crc32c", checksums[r.URL.Path])
+				w.Header().Set("x-amz-checksum-type", "FULL_OBJECT")
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			if r.Method == "GET" {
+				fmt.Fprint(w, data)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	s3, err := NewS3("bucket", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:            "us-east-1",
+		AccessKey:         "key",
+		SecretKey:         "secret",
+		ChecksumAlgorithm: "CRC32C",
+		ChecksumType:      "FULL_OBJECT",
+		NoDetect:          true,
+	})
+	t.Assert(err, IsNil)
+	put, err := s3.PutBlob(&PutBlobInput{Key: "file", Body: strings.NewReader("data"), Size: PUInt64(4)})
+	t.Assert(err, IsNil)
+	t.Assert(*put.Checksum, DeepEquals, ObjectChecksum{Algorithm: "CRC32C", Type: "FULL_OBJECT", Value: checksums["/bucket/file"]})
+
+	read := func() (string, *ObjectChecksum, error) {
+		resp, err := s3.GetBlob(&GetBlobInput{Key: "file"})
+		t.Assert(err, IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return string(data), resp.Checksum, err
+	}
+	data, checksum, err := read()
+	t.Assert(err, IsNil)
+	t.Assert(data, Equals, "data")
+	t.Assert(checksum.Algorithm, Equals, "CRC32C")
+
+	// Corrupted data fails the read
+	mu.Lock()
+	objects["/bucket/file"] = "dada"
+	mu.Unlock()
+	_, _, err = read()
+	t.Assert(err, Equals, syscall.EIO)
+
+	// Reads of whole files through the filesystem are verified too
+	fs, err := newGoofys(context.Background(), "bucket", cfg.DefaultFlags(), func(string, *cfg.FlagStorage) (StorageBackend, error) {
+		return s3, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	m := &SimMount{fs: fs}
+	_, err = m.ReadFile("file")
+	t.Assert(err, Equals, syscall.EIO)
+	_, err = s3.PutBlob(&PutBlobInput{Key: "good", Body: strings.NewReader("good data"), Size: PUInt64(9)})
+	t.Assert(err, IsNil)
+	good, err := m.ReadFile("good")
+	t.Assert(err, IsNil)
+	t.Assert(string(good), Equals, "good data")
+
+	// Parts are uploaded with checksums, which are sent on completion
+	commit, err := s3.MultipartBlobBegin(&MultipartBlobBeginInput{Key: "multi"})
+	t.Assert(err, IsNil)
+	part, err := s3.MultipartBlobAdd(&MultipartBlobAddInput{Commit: commit, PartNumber: 1, Body: strings.NewReader("part")})
+	t.Assert(err, IsNil)
+	commit.Parts[0] = part.PartId
+	commit.NumParts = 1
+	done, err := s3.MultipartBlobCommit(commit)
+	t.Assert(err, IsNil)
+	t.Assert(done.Checksum.Type, Equals, "FULL_OBJECT")
+	partSum, _ := computeChecksum("CRC32C", strings.NewReader("part"))
+	t.Assert(strings.Contains(completeBody, "<ChecksumCRC32C>"+partSum+"</ChecksumCRC32C>"), Equals, true)
+}
@@ -25,15 +25,40 @@ import (
 	"time"
 )
 
+// Capabilities describe what a backend supports, so that callers choose how
+// to do things instead of trying and failing
 type Capabilities struct {
 	MaxMultipartSize uint64
 	// indicates that the blob store has native support for directories
 	DirBlob bool
 	Name    string
+	// total size of user metadata keys and values, 0 if unlimited
+	MaxMetadataSize int
+	// PutBlob supports IfMatch and IfNoneMatch
+	ConditionalPut bool
+	// CopyBlob copies objects on the server. Otherwise it may only update
+	// metadata in place, if at all
+	ServerSideCopy bool
+	// RenameBlob renames objects on the server
+	ServerSideRename bool
+	// PatchBlob updates ranges of objects in place
+	Patch bool
+	// the backend implements VersionedBackend
+	Versioning bool
+	// the backend implements SelectBackend
+	Select bool
+	// ListBlobs reads whole listings to sort them, so recursive listings
+	// of large prefixes are expensive
+	UnsortedList bool
+	// PUTs are fast enough to flush more files in parallel
+	LowLatencyPut bool
 }
 
 type HeadBlobInput struct {
 	Key string
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type BlobItemOutput struct {
@@ -44,6 +69,8 @@ type BlobItemOutput struct {
 	StorageClass *string
 	// may be nil in list responses for backends that don't return metadata in listings
 	Metadata map[string]*string
+	// Cache-Control header, nil when unknown (in list responses)
+	CacheControl *string
 }
 
 type HeadBlobOutput struct {
@@ -52,15 +79,33 @@ type HeadBlobOutput struct {
 	ContentType *string
 	IsDirBlob   bool
 
+	// Object lock (WORM) state, if the backend supports it
+	LockedUntil *time.Time
+	LegalHold   bool
+
+	// Additional checksum of the object, if it has one
+	Checksum *ObjectChecksum
+
 	RequestId string
 }
 
+// ObjectChecksum is an S3 additional checksum: its algorithm (CRC32C,
+// SHA256...), type (FULL_OBJECT or COMPOSITE) and base64 value
+type ObjectChecksum struct {
+	Algorithm string
+	Type      string
+	Value     string
+}
+
 type ListBlobsInput struct {
 	Prefix            *string
 	Delimiter         *string
 	MaxKeys           *uint32
 	StartAfter        *string // XXX: not supported by Azure
 	ContinuationToken *string
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type BlobPrefixOutput struct {
@@ -76,8 +121,43 @@ type ListBlobsOutput struct {
 	RequestId string
 }
 
+type ListBlobVersionsInput struct {
+	Prefix          *string
+	KeyMarker       *string
+	VersionIdMarker *string
+}
+
+type BlobVersionOutput struct {
+	BlobItemOutput
+	VersionId    string
+	DeleteMarker bool
+}
+
+type ListBlobVersionsOutput struct {
+	Versions            []BlobVersionOutput
+	NextKeyMarker       *string
+	NextVersionIdMarker *string
+	IsTruncated         bool
+
+	RequestId string
+}
+
+type SelectBlobInput struct {
+	Key         string
+	Expression  string
+	InputFormat string // csv, tsv, json, jsonl or parquet
+	Compression string // "", gzip or bzip2
+}
+
+type SelectBlobOutput struct {
+	Body io.ReadCloser
+}
+
 type DeleteBlobInput struct {
 	Key string
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type DeleteBlobOutput struct {
@@ -109,6 +189,9 @@ type CopyBlobInput struct {
 	ETag         *string            // if non-nil, do conditional copy
 	Metadata     map[string]*string // if nil, copy from Source
 	StorageClass *string            // if nil, copy from Source
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type CopyBlobOutput struct {
@@ -116,10 +199,14 @@ type CopyBlobOutput struct {
 }
 
 type GetBlobInput struct {
-	Key     string
-	Start   uint64
-	Count   uint64
-	IfMatch *string
+	Key       string
+	Start     uint64
+	Count     uint64
+	IfMatch   *string
+	VersionId *string // only for VersionedBackend
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type GetBlobOutput struct {
@@ -135,15 +222,25 @@ type PutBlobInput struct {
 	Metadata    map[string]*string
 	ContentType *string
 	DirBlob     bool
+	// Conditional write, fails with EBUSY if the condition isn't met.
+	// IfNoneMatch only supports "*", i.e. "the object doesn't exist"
+	IfMatch     *string
+	IfNoneMatch *string
+	// if nil, the default storage class of the backend is used
+	StorageClass *string
 
 	Body io.ReadSeeker
 	Size *uint64
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type PutBlobOutput struct {
 	ETag         *string
 	LastModified *time.Time
 	StorageClass *string
+	Checksum     *ObjectChecksum
 
 	RequestId string
 }
@@ -155,6 +252,9 @@ type PatchBlobInput struct {
 	AppendPartSize int64
 
 	Body io.ReadSeeker
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type PatchBlobOutput struct {
@@ -165,9 +265,13 @@ type PatchBlobOutput struct {
 }
 
 type MultipartBlobBeginInput struct {
-	Key         string
-	Metadata    map[string]*string
-	ContentType *string
+	Key          string
+	Metadata     map[string]*string
+	ContentType  *string
+	StorageClass *string
+
+	// --trace-ops ID of the operation which made the request
+	OpID string
 }
 
 type MultipartBlobCommitInput struct {
@@ -180,6 +284,10 @@ type MultipartBlobCommitInput struct {
 
 	// for GCS
 	backendData interface{}
+
+	// --trace-ops ID of the operation which made the upload, used by
+	// all its requests
+	OpID string
 }
 
 type MultipartBlobAddInput struct {
@@ -214,6 +322,7 @@ type MultipartBlobCommitOutput struct {
 	ETag         *string
 	LastModified *time.Time
 	StorageClass *string
+	Checksum     *ObjectChecksum
 
 	RequestId string
 }
@@ -275,6 +384,36 @@ type StorageBackend interface {
 	Delegate() interface{}
 }
 
+// VersionedBackend is implemented by backends which can list old versions
+// of objects and read them with GetBlobInput.VersionId
+type VersionedBackend interface {
+	ListBlobVersions(param *ListBlobVersionsInput) (*ListBlobVersionsOutput, error)
+}
+
+// SelectBackend is implemented by backends which can run S3 Select queries
+// and return only the matching rows of an object
+type SelectBackend interface {
+	SelectBlob(param *SelectBlobInput) (*SelectBlobOutput, error)
+}
+
+// versionsOf returns the VersionedBackend of cloud if it supports versions
+func versionsOf(cloud StorageBackend) (VersionedBackend, bool) {
+	if !cloud.Capabilities().Versioning {
+		return nil, false
+	}
+	versions, ok := cloud.Delegate().(VersionedBackend)
+	return versions, ok
+}
+
+// selectsOf returns the SelectBackend of cloud if it supports S3 Select
+func selectsOf(cloud StorageBackend) (SelectBackend, bool) {
+	if !cloud.Capabilities().Select {
+		return nil, false
+	}
+	selects, ok := cloud.Delegate().(SelectBackend)
+	return selects, ok
+}
+
 type Delegator interface {
 	Delegate() interface{}
 }
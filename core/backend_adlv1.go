@@ -141,7 +141,7 @@ func NewADLv1(bucket string, flags *cfg.FlagStorage, config *cfg.ADLv1Config) (*
 	adlClient.BaseClient.Client.RequestInspector = LogRequest
 	adlClient.BaseClient.Client.ResponseInspector = LogResponse
 	adlClient.BaseClient.AdlsFileSystemDNSSuffix = parts[1]
-	adlClient.BaseClient.Sender.(*http.Client).Transport = cfg.GetHTTPTransport()
+	adlClient.BaseClient.Sender.(*http.Client).Transport = cfg.GetHTTPPool()
 
 	b := &ADLv1{
 		flags:   flags,
@@ -151,8 +151,9 @@ func NewADLv1(bucket string, flags *cfg.FlagStorage, config *cfg.ADLv1Config) (*
 		bucket:  bucket,
 		cap: Capabilities{
 			//NoParallelMultipart: true,
-			DirBlob: true,
-			Name:    "adl",
+			DirBlob:          true,
+			Name:             "adl",
+			ServerSideRename: true,
 			// ADLv1 fails with 404 if we upload data
 			// larger than 30000000 bytes (28.6MB) (28MB
 			// also failed in at one point, but as of
@@ -487,6 +488,9 @@ func (b *ADLv1) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
 }
 
 func (b *ADLv1) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	if param.IfMatch != nil || param.IfNoneMatch != nil {
+		return nil, syscall.ENOTSUP
+	}
 	if param.DirBlob {
 		err := b.mkdir(param.Key)
 		if err != nil {
@@ -167,7 +167,7 @@ func NewADLv2(bucket string, flags *cfg.FlagStorage, config *cfg.ADLv2Config) (*
 	client.Authorizer = config.Authorizer
 	client.RequestInspector = LogRequest
 	client.ResponseInspector = LogResponse
-	client.Sender.(*http.Client).Transport = cfg.GetHTTPTransport()
+	client.Sender.(*http.Client).Transport = cfg.GetHTTPPool()
 
 	b := &ADLv2{
 		flags:  flags,
@@ -175,8 +175,9 @@ func NewADLv2(bucket string, flags *cfg.FlagStorage, config *cfg.ADLv2Config) (*
 		client: adl2PathClient{client},
 		bucket: bucket,
 		cap: Capabilities{
-			DirBlob: true,
-			Name:    "adl2",
+			DirBlob:          true,
+			Name:             "adl2",
+			ServerSideRename: true,
 			// tested on 2019-11-07, seems to have same
 			// limit as azblob
 			MaxMultipartSize: 100 * 1024 * 1024,
@@ -523,7 +524,7 @@ func (b *ADLv2) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 
 func (b *ADLv2) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
 	if strings.HasSuffix(param.Key, "/") {
-		return b.DeleteBlob(&DeleteBlobInput{param.Key[:len(param.Key)-1]})
+		return b.DeleteBlob(&DeleteBlobInput{Key: param.Key[:len(param.Key)-1]})
 	}
 
 	res, err := b.client.Delete(context.TODO(), b.bucket, param.Key, nil, "", "",
@@ -666,6 +667,9 @@ func (b *ADLv2) flush(key string, offset int64, contentType string, leaseId stri
 }
 
 func (b *ADLv2) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	if param.IfMatch != nil || param.IfNoneMatch != nil {
+		return nil, syscall.ENOTSUP
+	}
 	if param.DirBlob {
 		res, err := b.create(param.Key, adl2.Directory, param.ContentType,
 			param.Metadata, "")
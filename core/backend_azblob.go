@@ -59,7 +59,7 @@ var pipelineHTTPClient = newDefaultHTTPClient()
 // Clone of https://github.com/Azure/azure-pipeline-go/blob/master/pipeline/core.go#L202
 func newDefaultHTTPClient() *http.Client {
 	return &http.Client{
-		Transport: cfg.GetHTTPTransport(),
+		Transport: cfg.GetHTTPPool(),
 	}
 }
 
@@ -181,6 +181,9 @@ func NewAZBlob(container string, config *cfg.AZBlobConfig) (*AZBlob, error) {
 		cap: Capabilities{
 			MaxMultipartSize: 100 * 1024 * 1024,
 			Name:             "wasb",
+			MaxMetadataSize:  8 * 1024,
+			ConditionalPut:   true,
+			ServerSideCopy:   true,
 		},
 		pipeline:         p,
 		bucket:           container,
@@ -342,6 +345,17 @@ func (b *AZBlob) Init(key string) error {
 	return err
 }
 
+// azureETag returns the ETag in quotes, as in response headers. Listings
+// return them without quotes, and they must be equal for If-Match and for
+// checking if objects are changed
+func azureETag(etag azblob.ETag) *string {
+	s := string(etag)
+	if s != "" && !strings.HasPrefix(s, "\"") {
+		s = "\"" + s + "\""
+	}
+	return &s
+}
+
 func mapAZBError(err error) error {
 	if err == nil {
 		return nil
@@ -448,7 +462,7 @@ func (b *AZBlob) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
 	return &HeadBlobOutput{
 		BlobItemOutput: BlobItemOutput{
 			Key:          &param.Key,
-			ETag:         PString(string(resp.ETag())),
+			ETag:         azureETag(resp.ETag()),
 			LastModified: PTime(resp.LastModified()),
 			Size:         uint64(resp.ContentLength()),
 			StorageClass: PString(resp.AccessTier()),
@@ -587,7 +601,7 @@ func (b *AZBlob) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 		delete(pmeta, AzureDirBlobMetadataKey)
 		items = append(items, BlobItemOutput{
 			Key:          &i.Name,
-			ETag:         PString(string(p.Etag)),
+			ETag:         azureETag(p.Etag),
 			LastModified: PTime(p.LastModified),
 			Size:         uint64(*p.ContentLength),
 			StorageClass: PString(string(p.AccessTier)),
@@ -598,7 +612,7 @@ func (b *AZBlob) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 	if strings.HasSuffix(options.Prefix, "/") {
 		// because azure doesn't use dir/ blobs, dir/ would not show up
 		// so we make another request to fill that in
-		dirBlob, err := b.HeadBlob(&HeadBlobInput{options.Prefix})
+		dirBlob, err := b.HeadBlob(&HeadBlobInput{Key: options.Prefix})
 		if err == nil {
 			*dirBlob.Key += "/"
 			items = append(items, dirBlob.BlobItemOutput)
@@ -668,7 +682,7 @@ func (b *AZBlob) DeleteBlobs(param *DeleteBlobsInput) (ret *DeleteBlobsOutput, d
 				wg.Done()
 			}()
 
-			_, err := b.DeleteBlob(&DeleteBlobInput{key})
+			_, err := b.DeleteBlob(&DeleteBlobInput{Key: key})
 			if err != nil {
 				err = mapAZBError(err)
 				if err != syscall.ENOENT {
@@ -757,7 +771,7 @@ func (b *AZBlob) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
 		HeadBlobOutput: HeadBlobOutput{
 			BlobItemOutput: BlobItemOutput{
 				Key:          &param.Key,
-				ETag:         PString(string(resp.ETag())),
+				ETag:         azureETag(resp.ETag()),
 				LastModified: PTime(resp.LastModified()),
 				Size:         uint64(resp.ContentLength()),
 				Metadata:     metadata,
@@ -792,19 +806,32 @@ func (b *AZBlob) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
 		body = bytes.NewReader([]byte(""))
 	}
 
+	var cond azblob.ModifiedAccessConditions
+	if param.IfMatch != nil {
+		cond.IfMatch = azblob.ETag(*param.IfMatch)
+	}
+	if param.IfNoneMatch != nil {
+		cond.IfNoneMatch = azblob.ETag(*param.IfNoneMatch)
+	}
+
 	blob := c.NewBlobURL(param.Key).ToBlockBlobURL()
 	resp, err := blob.Upload(context.TODO(),
 		body,
 		azblob.BlobHTTPHeaders{
 			ContentType: NilStr(param.ContentType),
 		},
-		azblob.Metadata(nilMetadata(param.Metadata)), azblob.BlobAccessConditions{}, azblob.AccessTierNone, azblob.BlobTagsMap{}, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+		azblob.Metadata(nilMetadata(param.Metadata)), azblob.BlobAccessConditions{ModifiedAccessConditions: cond}, azblob.AccessTierNone, azblob.BlobTagsMap{}, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+	if stgErr, ok := err.(azblob.StorageError); ok && param.IfNoneMatch != nil &&
+		stgErr.ServiceCode() == azblob.ServiceCodeBlobAlreadyExists {
+		// If-None-Match: * fails with 409 instead of 412 for existing blobs
+		return nil, syscall.EBUSY
+	}
 	if err != nil {
 		return nil, mapAZBError(err)
 	}
 
 	return &PutBlobOutput{
-		ETag:         PString(string(resp.ETag())),
+		ETag:         azureETag(resp.ETag()),
 		LastModified: PTime(resp.LastModified()),
 	}, nil
 }
@@ -916,7 +943,7 @@ func (b *AZBlob) MultipartBlobCommit(param *MultipartBlobCommitInput) (*Multipar
 	}
 
 	return &MultipartBlobCommitOutput{
-		ETag:         PString(string(resp.ETag())),
+		ETag:         azureETag(resp.ETag()),
 		LastModified: PTime(resp.LastModified()),
 	}, nil
 }
@@ -39,7 +39,12 @@ func NewGCS3(bucket string, flags *cfg.FlagStorage, config *cfg.S3Config) (*GCS3
 	if err != nil {
 		return nil, err
 	}
-	s3Backend.Capabilities().Name = "gcs"
+	caps := s3Backend.Capabilities()
+	caps.Name = "gcs"
+	// The S3 API of GCS has no PATCH, object versions or S3 Select
+	caps.Patch = false
+	caps.Versioning = false
+	caps.Select = false
 	s := &GCS3{S3Backend: s3Backend}
 	s.S3Backend.gcs = true
 	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
@@ -0,0 +1,917 @@
+package core
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// LocalBackend stores objects as files under a local directory, for example
+// an NFS export shared by several hosts, or a temporary directory in tests.
+// Key "a/b" is the file a/b and "a/" is the directory a, so every directory
+// is also a directory object. Keys which aren't valid paths are rejected with
+// EINVAL, and a key can't be both a file and a prefix of other keys.
+//
+// ETags are MD5 hashes of contents. They're stored with metadata and content
+// types in .geesefs_local/meta and computed again when the file is changed by
+// someone else, i.e. when its size or mtime differ. Objects are written to
+// temporary files and renamed into place, so partial objects are never seen.
+// Writes check their conditions while holding a lock file of the key created
+// with O_EXCL, which is atomic on NFS too, so conditional PUTs work across
+// hosts.
+type LocalBackend struct {
+	bucket string
+	root   string
+	cap    Capabilities
+}
+
+var localLog = cfg.GetLogger("local")
+
+const localMetaDir = ".geesefs_local"
+const localLockTTL = 10 * time.Second
+const localMultipartAge = 48 * time.Hour
+
+// ETag of empty objects and directories
+const localEmptyETag = "\"d41d8cd98f00b204e9800998ecf8427e\""
+
+type localMeta struct {
+	ETag        string            `json:"etag"`
+	Size        int64             `json:"size"`
+	MTime       int64             `json:"mtime"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type localUpload struct {
+	Key         string            `json:"key"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func NewLocal(bucket string, flags *cfg.FlagStorage, config *cfg.LocalConfig) (*LocalBackend, error) {
+	root := config.Root
+	if root == "" {
+		return nil, fmt.Errorf("Local backend requires a root directory")
+	}
+	if bucket != "" {
+		root = filepath.Join(root, filepath.FromSlash(bucket))
+	}
+	return &LocalBackend{
+		bucket: bucket,
+		root:   root,
+		cap: Capabilities{
+			Name:             "file",
+			MaxMultipartSize: 5 * 1024 * 1024 * 1024,
+			ConditionalPut:   true,
+			ServerSideCopy:   true,
+			ServerSideRename: true,
+		},
+	}, nil
+}
+
+func localError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, iofs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, iofs.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return err
+}
+
+func mapToString(m map[string]*string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		if v != nil {
+			res[k] = *v
+		}
+	}
+	return res
+}
+
+func mapToPString(m map[string]string) map[string]*string {
+	res := make(map[string]*string, len(m))
+	for k, v := range m {
+		res[k] = PString(v)
+	}
+	return res
+}
+
+func (b *LocalBackend) internal(elem ...string) string {
+	return filepath.Join(append([]string{b.root, localMetaDir}, elem...)...)
+}
+
+// path returns the file of key
+func (b *LocalBackend) path(key string) (string, error) {
+	name := strings.TrimSuffix(key, "/")
+	if name == "" {
+		return b.root, nil
+	}
+	for i, c := range strings.Split(name, "/") {
+		if c == "" || c == "." || c == ".." || strings.ContainsRune(c, filepath.Separator) ||
+			i == 0 && c == localMetaDir {
+			return "", syscall.EINVAL
+		}
+	}
+	return filepath.Join(b.root, filepath.FromSlash(name)), nil
+}
+
+func (b *LocalBackend) metaPath(key string) string {
+	if strings.HasSuffix(key, "/") {
+		return b.internal("meta", filepath.FromSlash(key), ".dir")
+	}
+	return b.internal("meta", filepath.FromSlash(key)+".meta")
+}
+
+func (b *LocalBackend) readMeta(key string) *localMeta {
+	data, err := os.ReadFile(b.metaPath(key))
+	if err != nil {
+		return nil
+	}
+	var meta localMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return nil
+	}
+	return &meta
+}
+
+func (b *LocalBackend) writeMeta(key string, meta *localMeta) error {
+	data, _ := json.Marshal(meta)
+	file := b.metaPath(key)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return localError(err)
+	}
+	tmp := b.internal("tmp", RandStringBytesMaskImprSrc(16))
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return localError(err)
+}
+
+// lock takes the lock file of key, removing it if it's left for too long
+// by a crashed process
+func (b *LocalBackend) lock(key string) (unlock func(), err error) {
+	sum := md5.Sum([]byte(key))
+	file := b.internal("locks", hex.EncodeToString(sum[:]))
+	for {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(file) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, localError(err)
+		}
+		if fi, err := os.Stat(file); err == nil && time.Since(fi.ModTime()) > localLockTTL {
+			localLog.Warnf("Removing stale lock of %v", key)
+			os.Remove(file)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeTemp copies r to a temporary file and returns it with its ETag
+func (b *LocalBackend) writeTemp(r io.Reader) (tmp string, etag string, err error) {
+	tmp = b.internal("tmp", RandStringBytesMaskImprSrc(16))
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", "", localError(err)
+	}
+	h := md5.New()
+	if r != nil {
+		_, err = io.Copy(io.MultiWriter(f, h), r)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", "", localError(err)
+	}
+	return tmp, "\"" + hex.EncodeToString(h.Sum(nil)) + "\"", nil
+}
+
+// store renames a temporary file to key and saves its metadata.
+// LOCKS_REQUIRED(lock of key)
+func (b *LocalBackend) store(key, tmp string, meta *localMeta) (os.FileInfo, error) {
+	file, err := b.path(key)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if strings.HasSuffix(key, "/") {
+		err = os.MkdirAll(file, 0755)
+	} else {
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err == nil {
+			err = os.Rename(tmp, file)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, localError(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, localError(err)
+	}
+	meta.Size = fi.Size()
+	meta.MTime = fi.ModTime().UnixNano()
+	return fi, b.writeMeta(key, meta)
+}
+
+// etag returns the ETag of the file of key, from metadata if it's not
+// changed since it was stored
+func (b *LocalBackend) etag(key string, file string, fi os.FileInfo, meta *localMeta) string {
+	if fi.IsDir() || fi.Size() == 0 {
+		return localEmptyETag
+	}
+	if meta != nil && meta.ETag != "" && meta.Size == fi.Size() && meta.MTime == fi.ModTime().UnixNano() {
+		return meta.ETag
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return ""
+	}
+	etag := "\"" + hex.EncodeToString(h.Sum(nil)) + "\""
+	// Remember it for the next time, keeping metadata if there was any
+	if meta == nil {
+		meta = &localMeta{}
+	}
+	meta.ETag = etag
+	meta.Size = fi.Size()
+	meta.MTime = fi.ModTime().UnixNano()
+	err = b.writeMeta(key, meta)
+	if err != nil {
+		localLog.Debugf("Failed to store ETag of %v: %v", key, err)
+	}
+	return etag
+}
+
+// localMTime returns the modification time of a file with the precision of
+// S3, i.e. in seconds. Directory mtimes change with their entries, so finer
+// times of a directory object wouldn't be the same in successive requests
+func localMTime(fi os.FileInfo) *time.Time {
+	return PTime(fi.ModTime().Truncate(time.Second))
+}
+
+func (b *LocalBackend) item(key string, file string, fi os.FileInfo, meta *localMeta) BlobItemOutput {
+	item := BlobItemOutput{
+		Key:          PString(key),
+		ETag:         PString(b.etag(key, file, fi, meta)),
+		LastModified: localMTime(fi),
+	}
+	if !fi.IsDir() {
+		item.Size = uint64(fi.Size())
+	}
+	return item
+}
+
+func (b *LocalBackend) head(key string, file string, fi os.FileInfo) *HeadBlobOutput {
+	meta := b.readMeta(key)
+	head := &HeadBlobOutput{
+		BlobItemOutput: b.item(key, file, fi, meta),
+		IsDirBlob:      fi.IsDir(),
+	}
+	if meta != nil {
+		head.Metadata = mapToPString(meta.Metadata)
+		if meta.ContentType != "" {
+			head.ContentType = PString(meta.ContentType)
+		}
+	} else {
+		head.Metadata = make(map[string]*string)
+	}
+	return head
+}
+
+// stat returns the file of key if it exists and is of the right type
+func (b *LocalBackend) stat(key string) (string, os.FileInfo, error) {
+	file, err := b.path(key)
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		err = localError(err)
+		if err == syscall.ENOTDIR {
+			err = syscall.ENOENT
+		}
+		return "", nil, err
+	}
+	if fi.IsDir() != strings.HasSuffix(key, "/") {
+		return "", nil, syscall.ENOENT
+	}
+	return file, fi, nil
+}
+
+// checkConditions checks conditions of a write of key.
+// LOCKS_REQUIRED(lock of key)
+func (b *LocalBackend) checkConditions(key string, ifMatch, ifNoneMatch *string) error {
+	if ifMatch == nil && ifNoneMatch == nil {
+		return nil
+	}
+	file, fi, err := b.stat(key)
+	if err == syscall.ENOENT {
+		if ifMatch != nil {
+			return syscall.ENOENT
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ifNoneMatch != nil {
+		return syscall.EBUSY
+	}
+	if b.etag(key, file, fi, b.readMeta(key)) != *ifMatch {
+		return syscall.EBUSY
+	}
+	return nil
+}
+
+func (b *LocalBackend) Init(key string) error {
+	fi, err := os.Stat(b.root)
+	if err != nil {
+		return localError(err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", b.root)
+	}
+	for _, dir := range []string{"meta", "tmp", "locks", "uploads"} {
+		err = os.MkdirAll(b.internal(dir), 0755)
+		if err != nil {
+			return localError(err)
+		}
+	}
+	return nil
+}
+
+func (b *LocalBackend) Capabilities() *Capabilities {
+	return &b.cap
+}
+
+func (b *LocalBackend) Bucket() string {
+	return b.bucket
+}
+
+func (b *LocalBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	file, fi, err := b.stat(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	return b.head(param.Key, file, fi), nil
+}
+
+type localListEntry struct {
+	key    string
+	prefix bool
+	file   string
+	fi     os.FileInfo
+}
+
+// listDir adds entries of the directory dirKey starting with prefix, and
+// recursively of its subdirectories if recursive
+func (b *LocalBackend) listDir(dirKey, prefix string, recursive bool, entries []localListEntry) ([]localListEntry, error) {
+	dir, err := b.path(dirKey)
+	if err != nil {
+		return entries, nil
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		err = localError(err)
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return entries, nil
+		}
+		return entries, err
+	}
+	for _, f := range files {
+		if dirKey == "" && f.Name() == localMetaDir {
+			continue
+		}
+		key := dirKey + f.Name()
+		if f.IsDir() {
+			key += "/"
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		fi, err := f.Info()
+		if err != nil {
+			// Removed in the meantime
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		if !f.IsDir() {
+			entries = append(entries, localListEntry{key: key, file: file, fi: fi})
+		} else if !recursive {
+			entries = append(entries, localListEntry{key: key, prefix: true})
+		} else {
+			entries = append(entries, localListEntry{key: key, file: file, fi: fi})
+			entries, err = b.listDir(key, key, true, entries)
+			if err != nil {
+				return entries, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (b *LocalBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	prefix := NilStr(param.Prefix)
+	delim := NilStr(param.Delimiter)
+	if delim != "" && delim != "/" {
+		return nil, syscall.EINVAL
+	}
+	start := NilStr(param.StartAfter)
+	if token := NilStr(param.ContinuationToken); token > start {
+		start = token
+	}
+	maxKeys := 1000
+	if param.MaxKeys != nil && *param.MaxKeys > 0 {
+		maxKeys = int(*param.MaxKeys)
+	}
+	var entries []localListEntry
+	dirKey := prefix[0 : strings.LastIndex(prefix, "/")+1]
+	if dirKey != "" && dirKey == prefix {
+		// The directory object itself
+		if file, fi, err := b.stat(dirKey); err == nil {
+			entries = append(entries, localListEntry{key: dirKey, file: file, fi: fi})
+		}
+	}
+	entries, err := b.listDir(dirKey, prefix, delim == "", entries)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	res := &ListBlobsOutput{}
+	n := 0
+	for _, e := range entries {
+		if e.key <= start {
+			continue
+		}
+		if n == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = PString(start)
+			break
+		}
+		if e.prefix {
+			res.Prefixes = append(res.Prefixes, BlobPrefixOutput{Prefix: PString(e.key)})
+		} else {
+			res.Items = append(res.Items, b.item(e.key, e.file, e.fi, b.readMeta(e.key)))
+		}
+		start = e.key
+		n++
+	}
+	return res, nil
+}
+
+func (b *LocalBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	unlock, err := b.lock(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	file, fi, err := b.stat(param.Key)
+	if err == syscall.ENOENT || err == syscall.EINVAL {
+		return &DeleteBlobOutput{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = localError(os.Remove(file))
+	if fi.IsDir() && (err == syscall.ENOTEMPTY || err == syscall.EEXIST) || err == syscall.ENOENT {
+		// Like directory markers, directories with children stay
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(b.metaPath(param.Key))
+	return &DeleteBlobOutput{}, nil
+}
+
+func (b *LocalBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	for _, key := range param.Items {
+		_, err := b.DeleteBlob(&DeleteBlobInput{Key: key})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DeleteBlobsOutput{}, nil
+}
+
+func (b *LocalBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	if strings.HasSuffix(param.Source, "/") || strings.HasSuffix(param.Destination, "/") {
+		// Renaming the directory would move its children too
+		return nil, syscall.ENOTSUP
+	}
+	first, second := param.Source, param.Destination
+	if first > second {
+		first, second = second, first
+	}
+	unlock, err := b.lock(first)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if second != first {
+		unlock2, err := b.lock(second)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock2()
+	}
+	from, _, err := b.stat(param.Source)
+	if err != nil {
+		return nil, err
+	}
+	to, err := b.path(param.Destination)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(to), 0755)
+	if err == nil {
+		err = os.Rename(from, to)
+	}
+	if err != nil {
+		return nil, localError(err)
+	}
+	meta := b.metaPath(param.Destination)
+	if os.MkdirAll(filepath.Dir(meta), 0755) == nil {
+		os.Rename(b.metaPath(param.Source), meta)
+	}
+	return &RenameBlobOutput{}, nil
+}
+
+func (b *LocalBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	src, srcFi, err := b.stat(param.Source)
+	if err != nil {
+		return nil, err
+	}
+	srcMeta := b.readMeta(param.Source)
+	etag := b.etag(param.Source, src, srcFi, srcMeta)
+	if param.ETag != nil && *param.ETag != etag {
+		return nil, syscall.EBUSY
+	}
+	meta := &localMeta{ETag: etag}
+	if srcMeta != nil {
+		meta.ContentType = srcMeta.ContentType
+		meta.Metadata = srcMeta.Metadata
+	}
+	if param.Metadata != nil {
+		meta.Metadata = mapToString(param.Metadata)
+	}
+	var tmp string
+	if !srcFi.IsDir() {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, localError(err)
+		}
+		tmp, meta.ETag, err = b.writeTemp(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	unlock, err := b.lock(param.Destination)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	defer unlock()
+	_, err = b.store(param.Destination, tmp, meta)
+	if err != nil {
+		return nil, err
+	}
+	return &CopyBlobOutput{}, nil
+}
+
+type localBody struct {
+	io.Reader
+	io.Closer
+}
+
+func (b *LocalBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	if param.VersionId != nil {
+		return nil, syscall.ENOTSUP
+	}
+	file, err := b.path(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		err = localError(err)
+		if err == syscall.ENOTDIR {
+			err = syscall.ENOENT
+		}
+		return nil, err
+	}
+	// Stat the opened file, it may be replaced in the meantime
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() != strings.HasSuffix(param.Key, "/") {
+		err = syscall.ENOENT
+	}
+	if err != nil {
+		f.Close()
+		return nil, localError(err)
+	}
+	head := b.head(param.Key, file, fi)
+	if param.IfMatch != nil && *param.IfMatch != NilStr(head.ETag) {
+		f.Close()
+		return nil, syscall.EBUSY
+	}
+	size := uint64(0)
+	if !fi.IsDir() {
+		size = uint64(fi.Size())
+	}
+	start := param.Start
+	if start > size {
+		start = size
+	}
+	count := size - start
+	if param.Count != 0 && param.Count < count {
+		count = param.Count
+	}
+	var body io.Reader = io.NewSectionReader(f, int64(start), int64(count))
+	if fi.IsDir() {
+		body = strings.NewReader("")
+	}
+	return &GetBlobOutput{
+		HeadBlobOutput: *head,
+		Body:           localBody{body, f},
+	}, nil
+}
+
+func (b *LocalBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	key := param.Key
+	if param.DirBlob && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	meta := &localMeta{
+		ETag:        localEmptyETag,
+		ContentType: NilStr(param.ContentType),
+		Metadata:    mapToString(param.Metadata),
+	}
+	var tmp string
+	if !strings.HasSuffix(key, "/") {
+		var body io.Reader
+		if param.Body != nil {
+			body = param.Body
+		}
+		var err error
+		tmp, meta.ETag, err = b.writeTemp(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	unlock, err := b.lock(key)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	defer unlock()
+	err = b.checkConditions(key, param.IfMatch, param.IfNoneMatch)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	fi, err := b.store(key, tmp, meta)
+	if err != nil {
+		return nil, err
+	}
+	return &PutBlobOutput{
+		ETag:         PString(meta.ETag),
+		LastModified: localMTime(fi),
+	}, nil
+}
+
+func (b *LocalBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return nil, syscall.ENOTSUP
+}
+
+func (b *LocalBackend) uploadDir(commit *MultipartBlobCommitInput) (string, error) {
+	id := NilStr(commit.UploadId)
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		return "", syscall.EINVAL
+	}
+	return b.internal("uploads", id), nil
+}
+
+func (b *LocalBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	if _, err := b.path(param.Key); err != nil {
+		return nil, err
+	}
+	commit := &MultipartBlobCommitInput{
+		Key:      PString(param.Key),
+		Metadata: param.Metadata,
+		UploadId: PString(RandStringBytesMaskImprSrc(32)),
+		Parts:    make([]*string, 10000), // at most 10K parts
+	}
+	dir, _ := b.uploadDir(commit)
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, localError(err)
+	}
+	data, _ := json.Marshal(&localUpload{
+		Key:         param.Key,
+		ContentType: NilStr(param.ContentType),
+		Metadata:    mapToString(param.Metadata),
+	})
+	err = os.WriteFile(filepath.Join(dir, "upload.json"), data, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, localError(err)
+	}
+	return commit, nil
+}
+
+// addPart stores a part of an upload from r
+func (b *LocalBackend) addPart(commit *MultipartBlobCommitInput, partNumber uint32, r io.Reader) (*string, error) {
+	dir, err := b.uploadDir(commit)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(dir); err != nil {
+		return nil, localError(err)
+	}
+	tmp, etag, err := b.writeTemp(r)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmp, filepath.Join(dir, fmt.Sprintf("part.%v", partNumber)))
+	if err != nil {
+		os.Remove(tmp)
+		return nil, localError(err)
+	}
+	return PString(etag), nil
+}
+
+func (b *LocalBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	etag, err := b.addPart(param.Commit, param.PartNumber, param.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobAddOutput{PartId: etag}, nil
+}
+
+func (b *LocalBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	src, fi, err := b.stat(param.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	if param.Offset+param.Size > uint64(fi.Size()) {
+		return nil, syscall.ERANGE
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, localError(err)
+	}
+	defer f.Close()
+	etag, err := b.addPart(param.Commit, param.PartNumber, io.NewSectionReader(f, int64(param.Offset), int64(param.Size)))
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobCopyOutput{PartId: etag}, nil
+}
+
+func (b *LocalBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	dir, err := b.uploadDir(param)
+	if err != nil {
+		return nil, err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return nil, localError(err)
+	}
+	return &MultipartBlobAbortOutput{}, nil
+}
+
+func (b *LocalBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	dir, err := b.uploadDir(param)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return nil, localError(err)
+	}
+	var upload localUpload
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]io.Reader, 0, param.NumParts)
+	for i := uint32(1); i <= param.NumParts; i++ {
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("part.%v", i)))
+		if err != nil {
+			return nil, localError(err)
+		}
+		defer f.Close()
+		parts = append(parts, f)
+	}
+	tmp, etag, err := b.writeTemp(io.MultiReader(parts...))
+	if err != nil {
+		return nil, err
+	}
+	meta := &localMeta{
+		ETag:        etag,
+		ContentType: upload.ContentType,
+		Metadata:    upload.Metadata,
+	}
+	if param.Metadata != nil {
+		meta.Metadata = mapToString(param.Metadata)
+	}
+	unlock, err := b.lock(upload.Key)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	fi, err := b.store(upload.Key, tmp, meta)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	os.RemoveAll(dir)
+	return &MultipartBlobCommitOutput{
+		ETag:         PString(etag),
+		LastModified: localMTime(fi),
+	}, nil
+}
+
+func (b *LocalBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	uploads, err := os.ReadDir(b.internal("uploads"))
+	if err != nil {
+		return nil, localError(err)
+	}
+	for _, upload := range uploads {
+		fi, err := upload.Info()
+		if err == nil && time.Since(fi.ModTime()) > localMultipartAge {
+			localLog.Debugf("Removing expired upload %v", upload.Name())
+			os.RemoveAll(b.internal("uploads", upload.Name()))
+		}
+	}
+	return &MultipartExpireOutput{}, nil
+}
+
+func (b *LocalBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	err := os.RemoveAll(b.internal())
+	if err == nil {
+		err = os.Remove(b.root)
+	}
+	if err != nil {
+		return nil, localError(err)
+	}
+	return &RemoveBucketOutput{}, nil
+}
+
+func (b *LocalBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	err := os.Mkdir(b.root, 0755)
+	if err != nil {
+		return nil, localError(err)
+	}
+	err = b.Init("")
+	if err != nil {
+		return nil, err
+	}
+	return &MakeBucketOutput{}, nil
+}
+
+func (b *LocalBackend) Delegate() interface{} {
+	return b
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type LocalBackendTest struct{}
+
+var _ = Suite(&LocalBackendTest{})
+
+func (s *LocalBackendTest) TestLocalBackendNoCloud(t *C) {
+	root := t.MkDir()
+	b, err := NewLocal("bucket", cfg.DefaultFlags(), &cfg.LocalConfig{Root: root})
+	t.Assert(err, IsNil)
+	_, err = b.MakeBucket(&MakeBucketInput{})
+	t.Assert(err, IsNil)
+	t.Assert(b.Init(""), IsNil)
+
+	put := func(key, data string, ifMatch, ifNoneMatch *string) (*PutBlobOutput, error) {
+		return b.PutBlob(&PutBlobInput{
+			Key:         key,
+			Body:        bytes.NewReader([]byte(data)),
+			Size:        PUInt64(uint64(len(data))),
+			Metadata:    map[string]*string{"k": PString("v")},
+			IfMatch:     ifMatch,
+			IfNoneMatch: ifNoneMatch,
+		})
+	}
+	read := func(key string) string {
+		resp, err := b.GetBlob(&GetBlobInput{Key: key})
+		t.Assert(err, IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		t.Assert(err, IsNil)
+		return string(data)
+	}
+
+	// Conditional writes
+	resp, err := put("dir/a", "1", nil, PString("*"))
+	t.Assert(err, IsNil)
+	etag := *resp.ETag
+	_, err = put("dir/a", "2", nil, PString("*"))
+	t.Assert(err, Equals, syscall.EBUSY)
+	_, err = put("dir/a", "2", PString("\"other\""), nil)
+	t.Assert(err, Equals, syscall.EBUSY)
+	_, err = put("dir/b", "2", &etag, nil)
+	t.Assert(err, Equals, syscall.ENOENT)
+	resp, err = put("dir/a", "22", &etag, nil)
+	t.Assert(err, IsNil)
+	t.Assert(*resp.ETag, Not(Equals), etag)
+	t.Assert(read("dir/a"), Equals, "22")
+	head, err := b.HeadBlob(&HeadBlobInput{Key: "dir/a"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.ETag, Equals, *resp.ETag)
+	t.Assert(*head.Metadata["k"], Equals, "v")
+
+	// Files changed by others get new ETags
+	t.Assert(os.WriteFile(filepath.Join(root, "bucket", "dir", "a"), []byte("333"), 0644), IsNil)
+	head, err = b.HeadBlob(&HeadBlobInput{Key: "dir/a"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.ETag, Not(Equals), *resp.ETag)
+	t.Assert(head.Size, Equals, uint64(3))
+
+	// Directories are directory objects, internal files are hidden
+	_, err = put("dir/sub/c", "3", nil, nil)
+	t.Assert(err, IsNil)
+	_, err = b.PutBlob(&PutBlobInput{Key: "empty/", DirBlob: true})
+	t.Assert(err, IsNil)
+	_, err = put(localMetaDir+"/x", "", nil, nil)
+	t.Assert(err, Equals, syscall.EINVAL)
+	list, err := b.ListBlobs(&ListBlobsInput{Prefix: PString(""), Delimiter: PString("/")})
+	t.Assert(err, IsNil)
+	t.Assert(list.Items, HasLen, 0)
+	t.Assert(list.Prefixes, DeepEquals, []BlobPrefixOutput{{Prefix: PString("dir/")}, {Prefix: PString("empty/")}})
+	var keys []string
+	var token *string
+	for {
+		list, err = b.ListBlobs(&ListBlobsInput{MaxKeys: PUInt32(2), ContinuationToken: token})
+		t.Assert(err, IsNil)
+		for _, item := range list.Items {
+			keys = append(keys, *item.Key)
+		}
+		if !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	t.Assert(keys, DeepEquals, []string{"dir/", "dir/a", "dir/sub/", "dir/sub/c", "empty/"})
+
+	// Copies, renames and multipart uploads
+	_, err = b.CopyBlob(&CopyBlobInput{Source: "dir/a", Destination: "copy", ETag: PString("\"other\"")})
+	t.Assert(err, Equals, syscall.EBUSY)
+	_, err = b.CopyBlob(&CopyBlobInput{Source: "dir/a", Destination: "copy"})
+	t.Assert(err, IsNil)
+	_, err = b.RenameBlob(&RenameBlobInput{Source: "copy", Destination: "moved/copy"})
+	t.Assert(err, IsNil)
+	t.Assert(read("moved/copy"), Equals, "333")
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "copy"})
+	t.Assert(err, Equals, syscall.ENOENT)
+	commit, err := b.MultipartBlobBegin(&MultipartBlobBeginInput{Key: "big"})
+	t.Assert(err, IsNil)
+	_, err = b.MultipartBlobAdd(&MultipartBlobAddInput{Commit: commit, PartNumber: 1, Body: bytes.NewReader([]byte("ab"))})
+	t.Assert(err, IsNil)
+	_, err = b.MultipartBlobCopy(&MultipartBlobCopyInput{Commit: commit, PartNumber: 2, CopySource: "dir/a", Offset: 1, Size: 2})
+	t.Assert(err, IsNil)
+	commit.NumParts = 2
+	_, err = b.MultipartBlobCommit(commit)
+	t.Assert(err, IsNil)
+	t.Assert(read("big"), Equals, "ab33")
+
+	// Directories with children aren't removed
+	_, err = b.DeleteBlobs(&DeleteBlobsInput{Items: []string{"dir/sub/", "empty/", "missing"}})
+	t.Assert(err, IsNil)
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "dir/sub/"})
+	t.Assert(err, IsNil)
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "empty/"})
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	// Conditional writes of .geesefs_meta work through a mount
+	flags := cfg.DefaultFlags()
+	flags.EnablePerms = true
+	flags.DirMetaFile = true
+	flags.Backend = &cfg.LocalConfig{Root: root}
+	fs, err := NewGoofys(context.Background(), "bucket", flags)
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	testDirMetaWrite(t, fs, b)
+}
+
+// testDirMetaWrite changes the mode of dir/sub through the mount and waits
+// until it's stored in .geesefs_meta of the backend
+func testDirMetaWrite(t *C, fs *Goofys, b StorageBackend) {
+	dir, err := fs.LookupPath("dir/sub")
+	t.Assert(err, IsNil)
+	mode := os.FileMode(0700)
+	t.Assert(dir.SetAttributes(nil, &mode, nil, nil, nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		fs.WakeupFlusher()
+		meta, _, _, err := getDirMeta(b, "dir/"+dirMetaName)
+		return err == nil && meta.Dirs["sub"].Mode == 0700
+	}), Equals, true)
+}
@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -38,6 +39,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/corehandlers"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -58,6 +60,17 @@ type S3Backend struct {
 	iamToken           atomic.Value
 	iamTokenExpiration time.Time
 	iamRefreshTimer    *time.Timer
+
+	// set for S3 Express One Zone directory buckets
+	express *expressSession
+	// Object Lambda Access Points are read-only
+	objectLambda bool
+
+	tracer *OpTracer
+
+	// difference between server and local time in nanoseconds,
+	// detected from RequestTimeTooSkewed errors
+	clockOffset int64
 }
 
 func NewS3(bucket string, flags *cfg.FlagStorage, config *cfg.S3Config) (*S3Backend, error) {
@@ -82,13 +95,28 @@ func NewS3(bucket string, flags *cfg.FlagStorage, config *cfg.S3Config) (*S3Back
 		cap: Capabilities{
 			Name:             "s3",
 			MaxMultipartSize: 5 * 1024 * 1024 * 1024,
+			MaxMetadataSize:  2 * 1024,
+			ConditionalPut:   true,
+			ServerSideCopy:   true,
+			// PATCH is an extension of Yandex Object Storage
+			Patch:      flags.UsePatch,
+			Versioning: true,
+			Select:     true,
 		},
 	}
 
 	if flags.DebugS3 {
 		awsConfig.LogLevel = aws.LogLevel(aws.LogDebug | aws.LogDebugWithRequestErrors)
 	}
-	if config.UseIAM {
+	if kind := accessPointKind(bucket); kind != "" {
+		s.setupAccessPoint(kind)
+	}
+	if isExpressBucket(bucket) {
+		err = s.setupExpress()
+		if err != nil {
+			return nil, err
+		}
+	} else if config.UseIAM {
 		s.TryIAM()
 	}
 
@@ -250,23 +278,128 @@ func (s *S3Backend) setV2Signer(handlers *request.Handlers) {
 	handlers.Sign.PushBackNamed(corehandlers.BuildContentLengthHandler)
 }
 
+// Current time as seen by the server
+func (s *S3Backend) now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&s.clockOffset)))
+}
+
+// Sign requests with the corrected time
+func (s *S3Backend) setV4Signer(handlers *request.Handlers) {
+	handlers.Sign.Swap(v4.SignRequestHandler.Name, request.NamedHandler{
+		Name: v4.SignRequestHandler.Name,
+		Fn: func(req *request.Request) {
+			v4.SignSDKRequestWithCurrentTime(req, s.now, func(signer *v4.Signer) {
+				signer.DisableURIPathEscaping = true
+			})
+		},
+	})
+}
+
+// Hosts with drifting clocks get all requests rejected with RequestTimeTooSkewed.
+// Remember the offset from the server's Date header and retry
+func (s *S3Backend) correctClockSkew(req *request.Request) {
+	awsErr, ok := req.Error.(awserr.Error)
+	if !ok || awsErr.Code() != "RequestTimeTooSkewed" || req.HTTPResponse == nil {
+		return
+	}
+	serverTime := getDate(req.HTTPResponse)
+	if serverTime == nil {
+		return
+	}
+	offset := serverTime.Sub(time.Now())
+	old := time.Duration(atomic.SwapInt64(&s.clockOffset, int64(offset)))
+	if (old - offset).Abs() > time.Second {
+		s3Log.Warnf("Clock skew detected: server time differs from local time by %v, adjusting request time", offset)
+	}
+	req.Retryable = aws.Bool(true)
+}
+
 func (s *S3Backend) newS3() {
 	s.S3 = s3.New(s.config.Session, s.awsConfig)
 	if s.config.RequesterPays {
 		s.S3.Handlers.Build.PushBack(addRequestPayer)
 	}
-	if s.iam {
+	if s.express != nil {
+		s.S3.Handlers.Sign.Swap(v4.SignRequestHandler.Name, request.NamedHandler{
+			Name: v4.SignRequestHandler.Name,
+			Fn:   s.signExpress,
+		})
+		s.S3.Handlers.Retry.PushBack(s.correctClockSkew)
+		s.S3.Handlers.Retry.PushBack(s.retryExpiredSession)
+	} else if s.iam {
 		s.setIAMSigner(&s.S3.Handlers)
 	} else if s.v2Signer {
 		s.setV2Signer(&s.S3.Handlers)
+	} else {
+		s.setV4Signer(&s.S3.Handlers)
+		s.S3.Handlers.Retry.PushBack(s.correctClockSkew)
 	}
 	s.S3.Handlers.Sign.PushBack(addAcceptEncoding)
+	s.S3.Handlers.Validate.PushBack(s.rejectWrite)
 	s.S3.Handlers.Build.RemoveByName("core.SDKVersionUserAgentHandler")
 	s.S3.Handlers.Build.PushBackNamed(request.NamedHandler{
 		Name: "core.SDKVersionUserAgentHandler",
 		Fn: request.MakeAddToUserAgentHandler("GeeseFS", cfg.GEESEFS_VERSION,
 			runtime.Version(), runtime.GOOS, runtime.GOARCH),
 	})
+	if s.tracer != nil {
+		s.S3.Handlers.Complete.PushBack(s.tracer.LogRequest)
+	}
+}
+
+// Anonymous reports if requests are unsigned, for public buckets mounted
+// without credentials
+func (s *S3Backend) Anonymous() bool {
+	return s.awsConfig.Credentials == credentials.AnonymousCredentials
+}
+
+// ReadOnly returns why all writes to the bucket fail, if they do
+func (s *S3Backend) ReadOnly() string {
+	if s.Anonymous() {
+		// Public buckets are almost never writable by everyone
+		return "it's accessed without credentials"
+	}
+	if s.objectLambda {
+		return "it's an Object Lambda Access Point"
+	}
+	return ""
+}
+
+// rejectWrite fails modifying requests to read-only buckets before they're
+// sent
+func (s *S3Backend) rejectWrite(req *request.Request) {
+	reason := s.ReadOnly()
+	if reason == "" {
+		return
+	}
+	switch req.Operation.HTTPMethod {
+	case "GET", "HEAD":
+		return
+	case "POST":
+		if req.Operation.Name == "SelectObjectContent" {
+			return
+		}
+	}
+	s3Log.Errorf("%v failed: %v is read-only, %v", req.Operation.Name, s.bucket, reason)
+	req.Error = syscall.EROFS
+}
+
+// useAnonymous switches to unsigned requests if no credentials are found,
+// for public buckets
+func (s *S3Backend) useAnonymous(err error) bool {
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NoCredentialProviders" || s.Anonymous() {
+		return false
+	}
+	s3Log.Infof("No credentials found, trying to access bucket %v anonymously", s.bucket)
+	s.awsConfig.Credentials = credentials.AnonymousCredentials
+	s.newS3()
+	return true
+}
+
+// Log request IDs of all requests together with IDs of FUSE operations
+func (s *S3Backend) SetOpTracer(tracer *OpTracer) {
+	s.tracer = tracer
+	s.S3.Handlers.Complete.PushBack(tracer.LogRequest)
 }
 
 func (s *S3Backend) detectBucketLocationByHEAD() (err error, isAws bool) {
@@ -370,7 +503,8 @@ func (s *S3Backend) testBucket(key string) (err error) {
 		return err
 	})
 	if err != nil {
-		if mapAwsError(err) == syscall.ENOENT {
+		// Without the list permission S3 returns 403 for missing objects
+		if mapAwsError(err) == syscall.ENOENT || s.flags.NoList && mapAwsError(err) == syscall.EACCES {
 			err = nil
 		}
 	}
@@ -378,6 +512,30 @@ func (s *S3Backend) testBucket(key string) (err error) {
 	return
 }
 
+// probeEndpoints falls back from Transfer Acceleration and dual-stack
+// endpoints which don't work, for example if acceleration isn't enabled for
+// the bucket or IPv6 isn't routed. The bucket is then tested as usual
+func (s *S3Backend) probeEndpoints(key string) {
+	for aws.BoolValue(s.awsConfig.S3UseAccelerate) || aws.BoolValue(s.awsConfig.UseDualStack) {
+		_, err := s.HeadBlob(&HeadBlobInput{Key: key})
+		code := mapAwsError(err)
+		if err == nil || code == syscall.ENOENT || s.flags.NoList && code == syscall.EACCES {
+			return
+		}
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoCredentialProviders" {
+			return
+		}
+		if aws.BoolValue(s.awsConfig.S3UseAccelerate) {
+			s3Log.Warnf("Transfer Acceleration endpoint doesn't work for %v, not using it: %v", s.bucket, err)
+			s.awsConfig.S3UseAccelerate = aws.Bool(false)
+		} else {
+			s3Log.Warnf("Dual-stack endpoint doesn't work for %v, not using it: %v", s.bucket, err)
+			s.awsConfig.UseDualStack = aws.Bool(false)
+		}
+		s.newS3()
+	}
+}
+
 func (s *S3Backend) fallbackV2Signer() (err error) {
 	if s.v2Signer {
 		return syscall.EINVAL
@@ -393,6 +551,10 @@ func (s *S3Backend) Init(key string) error {
 	var isAws bool
 	var err error
 
+	if s.express != nil {
+		// Creates the first session
+		return s.testBucket(key)
+	}
 	if s.config.NoDetect {
 		return nil
 	}
@@ -414,8 +576,16 @@ func (s *S3Backend) Init(key string) error {
 		}
 	}
 
+	s.probeEndpoints(key)
+
 	// try again with the credential to make sure
 	err = s.testBucket(key)
+	if err != nil && s.useAnonymous(err) {
+		err = s.testBucket(key)
+		if mapAwsError(err) == syscall.EACCES {
+			return fmt.Errorf("no credentials found and bucket %v doesn't allow anonymous access", s.bucket)
+		}
+	}
 	if err != nil {
 		if !isAws {
 			// EMC returns 403 because it doesn't support v4 signing
@@ -439,17 +609,18 @@ func (s *S3Backend) Init(key string) error {
 	return nil
 }
 
-func (s *S3Backend) ListObjectsV2(params *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, string, error) {
+func (s *S3Backend) ListObjectsV2(params *s3.ListObjectsV2Input, opID string) (*s3.ListObjectsV2Output, string, error) {
 	if s.config.ListV1Ext {
 		in := s3.ListObjectsV1ExtInput(*params)
 		req, resp := s.S3.ListObjectsV1ExtRequest(&in)
+		traceRequest(req, opID)
 		err := req.Send()
 		if err != nil {
 			if awsErr, ok := err.(awserr.Error); ok {
 				if awsErr.Code() == "InvalidArgument" || awsErr.Code() == "NotImplemented" {
 					// Fallback to list v1
 					s.config.ListV1Ext = false
-					return s.ListObjectsV2(params)
+					return s.ListObjectsV2(params, opID)
 				}
 			}
 			return nil, "", err
@@ -464,6 +635,7 @@ func (s *S3Backend) ListObjectsV2(params *s3.ListObjectsV2Input) (*s3.ListObject
 		return &out, s.getRequestId(req), nil
 	} else if s.config.ListV2 {
 		req, resp := s.S3.ListObjectsV2Request(params)
+		traceRequest(req, opID)
 		err := req.Send()
 		if err != nil {
 			return nil, "", err
@@ -484,7 +656,9 @@ func (s *S3Backend) ListObjectsV2(params *s3.ListObjectsV2Input) (*s3.ListObject
 			v1.Marker = params.ContinuationToken
 		}
 
-		objs, err := s.S3.ListObjects(&v1)
+		req, objs := s.S3.ListObjectsRequest(&v1)
+		traceRequest(req, opID)
+		err := req.Send()
 		if err != nil {
 			return nil, "", err
 		}
@@ -542,6 +716,10 @@ func (s *S3Backend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
 	}
 
 	req, resp := s.S3.HeadObjectRequest(&head)
+	if s.config.ChecksumAlgorithm != "" {
+		req.HTTPRequest.Header.Set("x-amz-checksum-mode", "ENABLED")
+	}
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
@@ -554,14 +732,33 @@ func (s *S3Backend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
 			Size:         uint64(NilInt64(resp.ContentLength)),
 			StorageClass: resp.StorageClass,
 			Metadata:     metadataToLower(resp.Metadata),
+			CacheControl: PString(NilStr(resp.CacheControl)),
 		},
 		ContentType: resp.ContentType,
 		IsDirBlob:   strings.HasSuffix(param.Key, "/"),
+		LockedUntil: resp.ObjectLockRetainUntilDate,
+		LegalHold:   NilStr(resp.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn,
+		Checksum:    responseChecksum(req.HTTPResponse.Header),
 		RequestId:   s.getRequestId(req),
 	}, nil
 }
 
+func s3BlobItem(i *s3.Object) BlobItemOutput {
+	return BlobItemOutput{
+		Key:          i.Key,
+		ETag:         i.ETag,
+		LastModified: i.LastModified,
+		Size:         uint64(*i.Size),
+		StorageClass: i.StorageClass,
+		Metadata:     i.UserMetadata,
+	}
+}
+
 func (s *S3Backend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	if s.express != nil {
+		return s.listExpress(param)
+	}
+
 	var maxKeys *int64
 
 	if param.MaxKeys != nil {
@@ -575,7 +772,7 @@ func (s *S3Backend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 		MaxKeys:           maxKeys,
 		StartAfter:        param.StartAfter,
 		ContinuationToken: param.ContinuationToken,
-	})
+	}, param.OpID)
 	if err != nil {
 		return nil, err
 	}
@@ -587,14 +784,7 @@ func (s *S3Backend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 		prefixes = append(prefixes, BlobPrefixOutput{Prefix: p.Prefix})
 	}
 	for _, i := range resp.Contents {
-		items = append(items, BlobItemOutput{
-			Key:          i.Key,
-			ETag:         i.ETag,
-			LastModified: i.LastModified,
-			Size:         uint64(*i.Size),
-			StorageClass: i.StorageClass,
-			Metadata:     i.UserMetadata,
-		})
+		items = append(items, s3BlobItem(i))
 	}
 
 	return &ListBlobsOutput{
@@ -606,11 +796,132 @@ func (s *S3Backend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
 	}, nil
 }
 
+func (s *S3Backend) ListBlobVersions(param *ListBlobVersionsInput) (*ListBlobVersionsOutput, error) {
+	req, resp := s.ListObjectVersionsRequest(&s3.ListObjectVersionsInput{
+		Bucket:          &s.bucket,
+		Prefix:          param.Prefix,
+		KeyMarker:       param.KeyMarker,
+		VersionIdMarker: param.VersionIdMarker,
+	})
+	err := req.Send()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]BlobVersionOutput, 0, len(resp.Versions)+len(resp.DeleteMarkers))
+	for _, v := range resp.Versions {
+		versions = append(versions, BlobVersionOutput{
+			BlobItemOutput: BlobItemOutput{
+				Key:          v.Key,
+				ETag:         v.ETag,
+				LastModified: v.LastModified,
+				Size:         uint64(NilInt64(v.Size)),
+				StorageClass: v.StorageClass,
+			},
+			VersionId: NilStr(v.VersionId),
+		})
+	}
+	for _, d := range resp.DeleteMarkers {
+		versions = append(versions, BlobVersionOutput{
+			BlobItemOutput: BlobItemOutput{
+				Key:          d.Key,
+				LastModified: d.LastModified,
+			},
+			VersionId:    NilStr(d.VersionId),
+			DeleteMarker: true,
+		})
+	}
+
+	return &ListBlobVersionsOutput{
+		Versions:            versions,
+		NextKeyMarker:       resp.NextKeyMarker,
+		NextVersionIdMarker: resp.NextVersionIdMarker,
+		IsTruncated:         aws.BoolValue(resp.IsTruncated),
+		RequestId:           s.getRequestId(req),
+	}, nil
+}
+
+func (s *S3Backend) SelectBlob(param *SelectBlobInput) (*SelectBlobOutput, error) {
+	input := &s3.InputSerialization{}
+	output := &s3.OutputSerialization{JSON: &s3.JSONOutput{}}
+	switch param.InputFormat {
+	case "csv", "tsv":
+		input.CSV = &s3.CSVInput{FileHeaderInfo: PString(s3.FileHeaderInfoUse)}
+		output = &s3.OutputSerialization{CSV: &s3.CSVOutput{}}
+		if param.InputFormat == "tsv" {
+			input.CSV.FieldDelimiter = PString("\t")
+			output.CSV.FieldDelimiter = PString("\t")
+		}
+	case "json":
+		input.JSON = &s3.JSONInput{Type: PString(s3.JSONTypeDocument)}
+	case "jsonl":
+		input.JSON = &s3.JSONInput{Type: PString(s3.JSONTypeLines)}
+	case "parquet":
+		input.Parquet = &s3.ParquetInput{}
+	default:
+		return nil, syscall.EINVAL
+	}
+	switch param.Compression {
+	case "gzip":
+		input.CompressionType = PString(s3.CompressionTypeGzip)
+	case "bzip2":
+		input.CompressionType = PString(s3.CompressionTypeBzip2)
+	}
+	sel := s3.SelectObjectContentInput{
+		Bucket:              &s.bucket,
+		Key:                 &param.Key,
+		Expression:          &param.Expression,
+		ExpressionType:      PString(s3.ExpressionTypeSql),
+		InputSerialization:  input,
+		OutputSerialization: output,
+	}
+	if s.config.SseC != "" {
+		sel.SSECustomerAlgorithm = PString("AES256")
+		sel.SSECustomerKey = &s.config.SseC
+		sel.SSECustomerKeyMD5 = &s.config.SseCDigest
+	}
+	req, resp := s.SelectObjectContentRequest(&sel)
+	err := req.Send()
+	if err != nil {
+		return nil, err
+	}
+	return &SelectBlobOutput{Body: &selectReader{stream: resp.GetStream()}}, nil
+}
+
+// selectReader reads the records of an S3 Select response
+type selectReader struct {
+	stream *s3.SelectObjectContentEventStream
+	buf    []byte
+}
+
+func (r *selectReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		ev, ok := <-r.stream.Events()
+		if !ok {
+			if err := r.stream.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		if rec, ok := ev.(*s3.RecordsEvent); ok {
+			r.buf = rec.Payload
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *selectReader) Close() error {
+	return r.stream.Close()
+}
+
 func (s *S3Backend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
 	req, _ := s.DeleteObjectRequest(&s3.DeleteObjectInput{
 		Bucket: &s.bucket,
 		Key:    &param.Key,
 	})
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
@@ -889,6 +1200,7 @@ func (s *S3Backend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
 	c := *(req.Config.HTTPClient)
 	req.Config.HTTPClient = &c
 	req.Config.HTTPClient.Timeout = 15 * time.Minute
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		s3Log.Warnf("CopyObject %v = %v", params, err)
@@ -901,7 +1213,8 @@ func (s *S3Backend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
 func shouldRetry(err error) bool {
 	err = mapAwsError(err)
 	return err != syscall.ENOENT && err != syscall.EINVAL &&
-		err != syscall.EACCES && err != syscall.ENOTSUP && err != syscall.ERANGE
+		err != syscall.EACCES && err != syscall.ENOTSUP && err != syscall.ERANGE &&
+		err != syscall.ESTALE
 }
 
 func (s *S3Backend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
@@ -925,14 +1238,36 @@ func (s *S3Backend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
 		}
 		get.Range = &bytes
 	}
-	// TODO handle IfMatch
+	get.IfMatch = param.IfMatch
+	get.VersionId = param.VersionId
 
 	req, resp := s.GetObjectRequest(&get)
+	if s.config.ChecksumAlgorithm != "" {
+		req.HTTPRequest.Header.Set("x-amz-checksum-mode", "ENABLED")
+	}
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
 	}
 
+	body := resp.Body
+	checksum := responseChecksum(req.HTTPResponse.Header)
+	if checksum != nil && checksum.Type == "FULL_OBJECT" &&
+		(get.Range == nil || coversWholeObject(resp.ContentRange)) {
+		remaining := int64(-1)
+		if resp.ContentLength != nil {
+			remaining = *resp.ContentLength
+		}
+		body = &checksumReader{
+			ReadCloser: body,
+			key:        param.Key,
+			checksum:   checksum,
+			hash:       newS3ChecksumHash(checksum.Algorithm),
+			remaining:  remaining,
+		}
+	}
+
 	return &GetBlobOutput{
 		HeadBlobOutput: HeadBlobOutput{
 			BlobItemOutput: BlobItemOutput{
@@ -942,14 +1277,29 @@ func (s *S3Backend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
 				Size:         uint64(NilInt64(resp.ContentLength)),
 				StorageClass: resp.StorageClass,
 				Metadata:     metadataToLower(resp.Metadata),
+				CacheControl: PString(NilStr(resp.CacheControl)),
 			},
 			ContentType: resp.ContentType,
+			Checksum:    checksum,
 		},
-		Body:      resp.Body,
+		Body:      body,
 		RequestId: s.getRequestId(req),
 	}, nil
 }
 
+// PresignGetBlob returns a pre-signed GET URL for the object
+func (s *S3Backend) PresignGetBlob(key string, ttl time.Duration) (string, error) {
+	if s.config.SseC != "" {
+		// The URL would be useless without the SSE-C key headers
+		return "", syscall.ENOTSUP
+	}
+	req, _ := s.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	return req.Presign(ttl)
+}
+
 func getDate(resp *http.Response) *time.Time {
 	date := resp.Header.Get("Date")
 	if date != "" {
@@ -964,7 +1314,10 @@ func getDate(resp *http.Response) *time.Time {
 }
 
 func (s *S3Backend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
-	storageClass := s.selectStorageClass(param.Size)
+	storageClass := param.StorageClass
+	if storageClass == nil {
+		storageClass = s.selectStorageClass(param.Size)
+	}
 
 	put := &s3.PutObjectInput{
 		Bucket:       &s.bucket,
@@ -991,6 +1344,20 @@ func (s *S3Backend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
 	}
 
 	req, resp := s.PutObjectRequest(put)
+	// The SDK doesn't know conditional writes yet
+	if param.IfMatch != nil {
+		req.HTTPRequest.Header.Set("If-Match", *param.IfMatch)
+	}
+	if param.IfNoneMatch != nil {
+		req.HTTPRequest.Header.Set("If-None-Match", *param.IfNoneMatch)
+	}
+	if s.config.ChecksumAlgorithm != "" {
+		_, err := s.setChecksum(req, param.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
@@ -1000,6 +1367,7 @@ func (s *S3Backend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
 		ETag:         resp.ETag,
 		LastModified: getDate(req.HTTPResponse),
 		StorageClass: storageClass,
+		Checksum:     responseChecksum(req.HTTPResponse.Header),
 		RequestId:    s.getRequestId(req),
 	}, nil
 }
@@ -1024,6 +1392,7 @@ func (s *S3Backend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
 	}
 
 	req, resp := s.PatchObjectRequest(patch)
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -1048,6 +1417,9 @@ func (s *S3Backend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*Multipa
 		StorageClass: &s.config.StorageClass,
 		ContentType:  param.ContentType,
 	}
+	if param.StorageClass != nil {
+		mpu.StorageClass = param.StorageClass
+	}
 
 	if s.config.UseSSE {
 		mpu.ServerSideEncryption = &s.sseType
@@ -1066,18 +1438,29 @@ func (s *S3Backend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*Multipa
 
 	mpu.Metadata = metadataToLower(param.Metadata)
 
-	resp, err := s.CreateMultipartUpload(&mpu)
+	req, resp := s.CreateMultipartUploadRequest(&mpu)
+	if s.config.ChecksumAlgorithm != "" {
+		req.HTTPRequest.Header.Set("x-amz-checksum-algorithm", s.config.ChecksumAlgorithm)
+		req.HTTPRequest.Header.Set("x-amz-checksum-type", s.config.ChecksumType)
+	}
+	traceRequest(req, param.OpID)
+	err := req.Send()
 	if err != nil {
 		s3Log.Warnf("CreateMultipartUpload %v = %v", param.Key, err)
 		return nil, err
 	}
 
-	return &MultipartBlobCommitInput{
+	commit := &MultipartBlobCommitInput{
 		Key:      &param.Key,
 		Metadata: mpu.Metadata,
 		UploadId: resp.UploadId,
 		Parts:    make([]*string, 10000), // at most 10K parts
-	}, nil
+		OpID:     param.OpID,
+	}
+	if s.config.ChecksumAlgorithm != "" {
+		commit.backendData = &s3PartChecksums{parts: make(map[uint32]string)}
+	}
+	return commit, nil
 }
 
 func (s *S3Backend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
@@ -1096,10 +1479,23 @@ func (s *S3Backend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBl
 	s3Log.Debug(params)
 
 	req, resp := s.UploadPartRequest(&params)
+	checksums, _ := param.Commit.backendData.(*s3PartChecksums)
+	var checksum *string
+	if checksums != nil {
+		var err error
+		checksum, err = s.setChecksum(req, param.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	traceRequest(req, param.Commit.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
 	}
+	if checksums != nil {
+		checksums.set(param.PartNumber, checksum)
+	}
 
 	return &MultipartBlobAddOutput{
 		RequestId: s.getRequestId(req),
@@ -1127,10 +1523,14 @@ func (s *S3Backend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*Multipart
 	s3Log.Debug(params)
 
 	req, resp := s.UploadPartCopyRequest(&params)
+	traceRequest(req, param.Commit.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
 	}
+	if checksums, ok := param.Commit.backendData.(*s3PartChecksums); ok {
+		checksums.set(param.PartNumber, copyPartResultChecksum(resp.CopyPartResult, s.config.ChecksumAlgorithm))
+	}
 
 	return &MultipartBlobCopyOutput{
 		RequestId: s.getRequestId(req),
@@ -1139,14 +1539,19 @@ func (s *S3Backend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*Multipart
 }
 
 func (s *S3Backend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	checksums, _ := param.backendData.(*s3PartChecksums)
 	var parts []*s3.CompletedPart
 	for i := uint32(0); i < param.NumParts; i++ {
 		// Allow to skip some numbers
 		if param.Parts[i] != nil {
-			parts = append(parts, &s3.CompletedPart{
+			part := &s3.CompletedPart{
 				ETag:       param.Parts[i],
 				PartNumber: aws.Int64(int64(i + 1)),
-			})
+			}
+			if checksums != nil {
+				setCompletedPartChecksum(part, s.config.ChecksumAlgorithm, checksums.get(i+1))
+			}
+			parts = append(parts, part)
 		}
 	}
 
@@ -1162,6 +1567,7 @@ func (s *S3Backend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*Multi
 	s3Log.Debug(mpu)
 
 	req, resp := s.CompleteMultipartUploadRequest(&mpu)
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
@@ -1169,9 +1575,16 @@ func (s *S3Backend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*Multi
 
 	s3Log.Debug(resp)
 
+	var checksum *ObjectChecksum
+	if checksums != nil {
+		// The value is only returned in the response body
+		checksum = &ObjectChecksum{Algorithm: s.config.ChecksumAlgorithm, Type: s.config.ChecksumType}
+	}
+
 	return &MultipartBlobCommitOutput{
 		ETag:         resp.ETag,
 		LastModified: getDate(req.HTTPResponse),
+		Checksum:     checksum,
 		RequestId:    s.getRequestId(req),
 	}, nil
 }
@@ -1183,6 +1596,7 @@ func (s *S3Backend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*Multip
 		UploadId: param.UploadId,
 	}
 	req, _ := s.AbortMultipartUploadRequest(&mpu)
+	traceRequest(req, param.OpID)
 	err := req.Send()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,831 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// SimClock is a manually advanced clock used for object timestamps in SimStore.
+// Installed with SetClock, it also drives cache expiration, backoff and
+// background loops of mounts: Sleep advances it immediately, After and
+// AfterFunc fire when it's advanced past their deadline.
+type SimClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []simWaiter
+}
+
+type simWaiter struct {
+	at time.Time
+	ch chan time.Time
+	f  func()
+}
+
+func NewSimClock() *SimClock {
+	return &SimClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []simWaiter
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+		} else {
+			due = append(due, w)
+		}
+	}
+	c.waiters = waiting
+	now := c.now
+	c.mu.Unlock()
+	for _, w := range due {
+		if w.f != nil {
+			go w.f()
+		} else {
+			w.ch <- now
+		}
+	}
+}
+
+func (c *SimClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	c.waiters = append(c.waiters, simWaiter{at: c.now.Add(d), ch: ch})
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *SimClock) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	c.waiters = append(c.waiters, simWaiter{at: c.now.Add(d), f: f})
+	c.mu.Unlock()
+}
+
+type simObject struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+	metadata     map[string]*string
+	contentType  *string
+	storageClass *string
+}
+
+// simVersion is an old or current version of an object. obj is nil for
+// delete markers
+type simVersion struct {
+	id  string
+	obj *simObject
+	at  time.Time
+}
+
+type simUpload struct {
+	key         string
+	metadata    map[string]*string
+	contentType *string
+	parts       map[uint32][]byte
+}
+
+// SimStore is an in-memory bucket with S3 semantics shared between
+// several simulated mounts
+type SimStore struct {
+	mu      sync.Mutex
+	clock   *SimClock
+	objects map[string]*simObject
+	uploads map[string]*simUpload
+	nextId  uint64
+	// all versions of each key, oldest first
+	versions map[string][]simVersion
+	// listings show objects as they were listDelay ago
+	listDelay time.Duration
+}
+
+func NewSimStore(clock *SimClock) *SimStore {
+	return &SimStore{
+		clock:    clock,
+		objects:  make(map[string]*simObject),
+		uploads:  make(map[string]*simUpload),
+		versions: make(map[string][]simVersion),
+	}
+}
+
+func simETag(data []byte) string {
+	sum := md5.Sum(data)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func simMetadata(meta map[string]*string) map[string]*string {
+	res := make(map[string]*string, len(meta))
+	for k, v := range meta {
+		if v != nil {
+			res[strings.ToLower(k)] = PString(*v)
+		}
+	}
+	return res
+}
+
+// Put stores an object directly, bypassing any mount
+func (s *SimStore) Put(key string, data []byte, meta map[string]*string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putUnlocked(key, data, meta, nil)
+}
+
+// Get returns object contents directly, bypassing any mount
+func (s *SimStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// SetListDelay makes listings eventually consistent: they show objects as
+// they were d ago by the store's clock, like S3 before 2020 or some
+// S3-compatible stores with asynchronous indexes
+func (s *SimStore) SetListDelay(d time.Duration) {
+	s.mu.Lock()
+	s.listDelay = d
+	s.mu.Unlock()
+}
+
+// LOCKS_REQUIRED(s.mu)
+func (s *SimStore) objectsAtUnlocked(at time.Time) map[string]*simObject {
+	objects := make(map[string]*simObject)
+	for k, versions := range s.versions {
+		for i := len(versions) - 1; i >= 0; i-- {
+			if !versions[i].at.After(at) {
+				if versions[i].obj != nil {
+					objects[k] = versions[i].obj
+				}
+				break
+			}
+		}
+	}
+	return objects
+}
+
+// Keys returns all object keys in sorted order
+func (s *SimStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LOCKS_REQUIRED(s.mu)
+func (s *SimStore) putUnlocked(key string, data []byte, meta map[string]*string, contentType *string) *simObject {
+	obj := &simObject{
+		data:         data,
+		etag:         simETag(data),
+		lastModified: s.clock.Now(),
+		metadata:     simMetadata(meta),
+		contentType:  contentType,
+		storageClass: PString("STANDARD"),
+	}
+	s.objects[key] = obj
+	s.addVersionUnlocked(key, obj)
+	return obj
+}
+
+// LOCKS_REQUIRED(s.mu)
+func (s *SimStore) addVersionUnlocked(key string, obj *simObject) {
+	s.nextId++
+	s.versions[key] = append(s.versions[key], simVersion{
+		id:  fmt.Sprintf("v%v", s.nextId),
+		obj: obj,
+		at:  s.clock.Now(),
+	})
+}
+
+func (s *SimStore) listVersions(param *ListBlobVersionsInput) (*ListBlobVersionsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0)
+	for k := range s.versions {
+		if strings.HasPrefix(k, NilStr(param.Prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := &ListBlobVersionsOutput{}
+	for _, k := range keys {
+		versions := s.versions[k]
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := BlobVersionOutput{
+				BlobItemOutput: BlobItemOutput{Key: PString(k), LastModified: PTime(versions[i].at)},
+				VersionId:      versions[i].id,
+				DeleteMarker:   versions[i].obj == nil,
+			}
+			if versions[i].obj != nil {
+				v.BlobItemOutput = s.item(k, versions[i].obj)
+			}
+			res.Versions = append(res.Versions, v)
+		}
+	}
+	return res, nil
+}
+
+func (s *SimStore) item(key string, obj *simObject) BlobItemOutput {
+	return BlobItemOutput{
+		Key:          PString(key),
+		ETag:         PString(obj.etag),
+		LastModified: PTime(obj.lastModified),
+		Size:         uint64(len(obj.data)),
+		StorageClass: obj.storageClass,
+	}
+}
+
+func (s *SimStore) head(key string) (*HeadBlobOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	item := s.item(key, obj)
+	item.Metadata = simMetadata(obj.metadata)
+	return &HeadBlobOutput{
+		BlobItemOutput: item,
+		ContentType:    obj.contentType,
+		IsDirBlob:      strings.HasSuffix(key, "/"),
+	}, nil
+}
+
+func (s *SimStore) list(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	prefix, delim, after := NilStr(param.Prefix), NilStr(param.Delimiter), NilStr(param.StartAfter)
+	if param.ContinuationToken != nil && *param.ContinuationToken > after {
+		after = *param.ContinuationToken
+	}
+	maxKeys := 1000
+	if param.MaxKeys != nil && *param.MaxKeys < 1000 {
+		maxKeys = int(*param.MaxKeys)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := s.objects
+	if s.listDelay > 0 {
+		objects = s.objectsAtUnlocked(s.clock.Now().Add(-s.listDelay))
+	}
+	keys := make([]string, 0)
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := &ListBlobsOutput{}
+	last := ""
+	for _, k := range keys {
+		if k <= last {
+			// skip keys rolled up into the last common prefix
+			continue
+		}
+		if len(res.Items)+len(res.Prefixes) >= maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = PString(last)
+			break
+		}
+		if delim != "" {
+			if pos := strings.Index(k[len(prefix):], delim); pos >= 0 {
+				common := k[0 : len(prefix)+pos+len(delim)]
+				res.Prefixes = append(res.Prefixes, BlobPrefixOutput{Prefix: PString(common)})
+				// all keys starting with common are less than common + "\xFF"
+				last = common + "\xFF"
+				continue
+			}
+		}
+		res.Items = append(res.Items, s.item(k, objects[k]))
+		last = k
+	}
+	return res, nil
+}
+
+func (s *SimStore) get(param *GetBlobInput) (*GetBlobOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[param.Key]
+	if param.VersionId != nil {
+		obj, ok = nil, false
+		for _, v := range s.versions[param.Key] {
+			if v.id == *param.VersionId && v.obj != nil {
+				obj, ok = v.obj, true
+			}
+		}
+	}
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	if param.IfMatch != nil && *param.IfMatch != obj.etag {
+		return nil, syscall.EBUSY
+	}
+	start, end := param.Start, uint64(len(obj.data))
+	if start > end || start == end && start > 0 {
+		return nil, syscall.ERANGE
+	}
+	if param.Count != 0 && start+param.Count < end {
+		end = start + param.Count
+	}
+	item := s.item(param.Key, obj)
+	item.Metadata = simMetadata(obj.metadata)
+	return &GetBlobOutput{
+		HeadBlobOutput: HeadBlobOutput{
+			BlobItemOutput: item,
+			ContentType:    obj.contentType,
+		},
+		Body: ioutil.NopCloser(bytes.NewReader(append([]byte(nil), obj.data[start:end]...))),
+	}, nil
+}
+
+func (s *SimStore) copy(param *CopyBlobInput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.objects[param.Source]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if param.ETag != nil && *param.ETag != src.etag {
+		return syscall.EBUSY
+	}
+	meta := param.Metadata
+	if meta == nil {
+		meta = src.metadata
+	}
+	dst := s.putUnlocked(param.Destination, src.data, meta, src.contentType)
+	if param.StorageClass != nil {
+		dst.storageClass = PString(*param.StorageClass)
+	}
+	return nil
+}
+
+func (s *SimStore) delete(keys ...string) {
+	s.mu.Lock()
+	for _, k := range keys {
+		if _, ok := s.objects[k]; ok {
+			delete(s.objects, k)
+			s.addVersionUnlocked(k, nil)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *SimStore) beginUpload(param *MultipartBlobBeginInput) *MultipartBlobCommitInput {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	id := fmt.Sprintf("upload-%v", s.nextId)
+	s.uploads[id] = &simUpload{
+		key:         param.Key,
+		metadata:    simMetadata(param.Metadata),
+		contentType: param.ContentType,
+		parts:       make(map[uint32][]byte),
+	}
+	return &MultipartBlobCommitInput{
+		Key:      PString(param.Key),
+		Metadata: param.Metadata,
+		UploadId: PString(id),
+		Parts:    make([]*string, 10000),
+	}
+}
+
+func (s *SimStore) addPart(commit *MultipartBlobCommitInput, num uint32, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[NilStr(commit.UploadId)]
+	if !ok {
+		return "", syscall.ENOENT
+	}
+	upload.parts[num] = data
+	return simETag(data), nil
+}
+
+func (s *SimStore) copyPart(param *MultipartBlobCopyInput) (string, error) {
+	s.mu.Lock()
+	src, ok := s.objects[param.CopySource]
+	s.mu.Unlock()
+	if !ok {
+		return "", syscall.ENOENT
+	}
+	if param.Offset+param.Size > uint64(len(src.data)) {
+		return "", syscall.ERANGE
+	}
+	return s.addPart(param.Commit, param.PartNumber, src.data[param.Offset:param.Offset+param.Size])
+}
+
+func (s *SimStore) commitUpload(param *MultipartBlobCommitInput) (*simObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[NilStr(param.UploadId)]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	var data []byte
+	for i := uint32(1); i <= param.NumParts; i++ {
+		part, ok := upload.parts[i]
+		if !ok {
+			return nil, syscall.EINVAL
+		}
+		data = append(data, part...)
+	}
+	delete(s.uploads, NilStr(param.UploadId))
+	meta := upload.metadata
+	if param.Metadata != nil {
+		meta = param.Metadata
+	}
+	obj := s.putUnlocked(upload.key, data, meta, upload.contentType)
+	obj.etag = fmt.Sprintf("\"%v-%v\"", strings.Trim(simETag(data), "\""), param.NumParts)
+	return obj, nil
+}
+
+func (s *SimStore) abortUpload(param *MultipartBlobCommitInput) {
+	s.mu.Lock()
+	delete(s.uploads, NilStr(param.UploadId))
+	s.mu.Unlock()
+}
+
+type simFault struct {
+	op    string
+	count int
+	err   error
+}
+
+// SimRequest is a request recorded by SimConn
+type SimRequest struct {
+	Op  string
+	Key string
+	At  time.Time
+	Err error
+}
+
+// SimHTTPError returns an error like the ones S3 returns with HTTP status
+// and error code, for example SimHTTPError(412, "PreconditionFailed") or
+// SimHTTPError(503, "SlowDown"), so that it's handled as a real one
+func SimHTTPError(status int, code string) error {
+	return awserr.NewRequestFailure(awserr.New(code, http.StatusText(status), nil), status, "sim")
+}
+
+// SimConn is one mount's view of SimStore. It simulates network faults:
+// partitions, latency and injected errors for specific operations, and
+// records requests. It's a complete StorageBackend, so it may be used to
+// test code using geesefs as a library as well as geesefs itself.
+type SimConn struct {
+	store *SimStore
+	cap   Capabilities
+
+	mu          sync.Mutex
+	partitioned bool
+	latency     time.Duration
+	opLatency   map[string]time.Duration
+	faults      []simFault
+	calls       map[string]int
+	record      bool
+	requests    []SimRequest
+}
+
+func NewSimConn(store *SimStore) *SimConn {
+	return &SimConn{
+		store: store,
+		cap: Capabilities{
+			Name:             "sim",
+			MaxMultipartSize: 5 * 1024 * 1024 * 1024,
+			MaxMetadataSize:  2 * 1024,
+			ConditionalPut:   true,
+			ServerSideCopy:   true,
+			Versioning:       true,
+			Select:           true,
+		},
+		opLatency: make(map[string]time.Duration),
+		calls:     make(map[string]int),
+	}
+}
+
+// Partition makes all requests fail with ETIMEDOUT until Heal is called
+func (c *SimConn) Partition() {
+	c.mu.Lock()
+	c.partitioned = true
+	c.mu.Unlock()
+}
+
+func (c *SimConn) Heal() {
+	c.mu.Lock()
+	c.partitioned = false
+	c.mu.Unlock()
+}
+
+// SetLatency delays all requests by d of real time
+func (c *SimConn) SetLatency(d time.Duration) {
+	c.mu.Lock()
+	c.latency = d
+	c.mu.Unlock()
+}
+
+// SetOpLatency delays requests of type op by d instead of the common latency
+func (c *SimConn) SetOpLatency(op string, d time.Duration) {
+	c.mu.Lock()
+	c.opLatency[op] = d
+	c.mu.Unlock()
+}
+
+// FailNext makes next count requests of type op (for example, "PutBlob")
+// fail with err. Empty op matches any request.
+func (c *SimConn) FailNext(op string, count int, err error) {
+	c.mu.Lock()
+	c.faults = append(c.faults, simFault{op, count, err})
+	c.mu.Unlock()
+}
+
+// Calls returns the number of requests of type op made through this connection
+func (c *SimConn) Calls(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[op]
+}
+
+// Record starts or stops recording requests
+func (c *SimConn) Record(on bool) {
+	c.mu.Lock()
+	c.record = on
+	c.mu.Unlock()
+}
+
+// Requests returns and forgets the recorded requests
+func (c *SimConn) Requests() []SimRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := c.requests
+	c.requests = nil
+	return requests
+}
+
+func (c *SimConn) enter(op, key string) error {
+	c.mu.Lock()
+	c.calls[op]++
+	latency, ok := c.opLatency[op]
+	if !ok {
+		latency = c.latency
+	}
+	var err error
+	if c.partitioned {
+		err = syscall.ETIMEDOUT
+	} else {
+		for i := range c.faults {
+			f := &c.faults[i]
+			if f.count > 0 && (f.op == "" || f.op == op) {
+				f.count--
+				err = f.err
+				break
+			}
+		}
+	}
+	if c.record {
+		c.requests = append(c.requests, SimRequest{Op: op, Key: key, At: c.store.clock.Now(), Err: err})
+	}
+	c.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
+
+func (c *SimConn) Init(key string) error {
+	return nil
+}
+
+func (c *SimConn) Capabilities() *Capabilities {
+	return &c.cap
+}
+
+func (c *SimConn) Bucket() string {
+	return "sim"
+}
+
+func (c *SimConn) Delegate() interface{} {
+	return c
+}
+
+func (c *SimConn) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	if err := c.enter("HeadBlob", param.Key); err != nil {
+		return nil, err
+	}
+	return c.store.head(param.Key)
+}
+
+func (c *SimConn) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	if err := c.enter("ListBlobs", NilStr(param.Prefix)); err != nil {
+		return nil, err
+	}
+	return c.store.list(param)
+}
+
+func (c *SimConn) ListBlobVersions(param *ListBlobVersionsInput) (*ListBlobVersionsOutput, error) {
+	if err := c.enter("ListBlobVersions", NilStr(param.Prefix)); err != nil {
+		return nil, err
+	}
+	return c.store.listVersions(param)
+}
+
+// SelectBlob returns the lines of the object which contain the expression
+// after its header line, instead of running SQL
+func (c *SimConn) SelectBlob(param *SelectBlobInput) (*SelectBlobOutput, error) {
+	if err := c.enter("SelectBlob", param.Key); err != nil {
+		return nil, err
+	}
+	data, ok := c.store.Get(param.Key)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	var res []byte
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if i > 0 && strings.Contains(line, param.Expression) {
+			res = append(res, line...)
+		}
+	}
+	return &SelectBlobOutput{Body: io.NopCloser(bytes.NewReader(res))}, nil
+}
+
+func (c *SimConn) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	if err := c.enter("DeleteBlob", param.Key); err != nil {
+		return nil, err
+	}
+	c.store.delete(param.Key)
+	return &DeleteBlobOutput{}, nil
+}
+
+func (c *SimConn) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	if err := c.enter("DeleteBlobs", strings.Join(param.Items, ",")); err != nil {
+		return nil, err
+	}
+	c.store.delete(param.Items...)
+	return &DeleteBlobsOutput{}, nil
+}
+
+// RenameBlob is only supported if ServerSideRename is set in Capabilities()
+func (c *SimConn) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	if !c.cap.ServerSideRename {
+		return nil, syscall.ENOTSUP
+	}
+	if err := c.enter("RenameBlob", param.Source); err != nil {
+		return nil, err
+	}
+	err := c.store.copy(&CopyBlobInput{Source: param.Source, Destination: param.Destination})
+	if err != nil {
+		return nil, err
+	}
+	c.store.delete(param.Source)
+	return &RenameBlobOutput{}, nil
+}
+
+func (c *SimConn) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	if err := c.enter("CopyBlob", param.Destination); err != nil {
+		return nil, err
+	}
+	if err := c.store.copy(param); err != nil {
+		return nil, err
+	}
+	return &CopyBlobOutput{}, nil
+}
+
+func (c *SimConn) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	if err := c.enter("GetBlob", param.Key); err != nil {
+		return nil, err
+	}
+	return c.store.get(param)
+}
+
+func (c *SimConn) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	if err := c.enter("PutBlob", param.Key); err != nil {
+		return nil, err
+	}
+	var data []byte
+	if param.Body != nil {
+		var err error
+		data, err = io.ReadAll(param.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (param.IfMatch != nil || param.IfNoneMatch != nil) && !c.cap.ConditionalPut {
+		return nil, syscall.ENOTSUP
+	}
+	c.store.mu.Lock()
+	old, exists := c.store.objects[param.Key]
+	if param.IfNoneMatch != nil && exists ||
+		param.IfMatch != nil && exists && *param.IfMatch != old.etag {
+		c.store.mu.Unlock()
+		return nil, syscall.EBUSY
+	} else if param.IfMatch != nil && !exists {
+		c.store.mu.Unlock()
+		return nil, syscall.ENOENT
+	}
+	obj := c.store.putUnlocked(param.Key, data, param.Metadata, param.ContentType)
+	if param.StorageClass != nil {
+		obj.storageClass = PString(*param.StorageClass)
+	}
+	c.store.mu.Unlock()
+	return &PutBlobOutput{
+		ETag:         PString(obj.etag),
+		LastModified: PTime(obj.lastModified),
+		StorageClass: obj.storageClass,
+	}, nil
+}
+
+func (c *SimConn) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return nil, syscall.ENOSYS
+}
+
+func (c *SimConn) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	if err := c.enter("MultipartBlobBegin", param.Key); err != nil {
+		return nil, err
+	}
+	return c.store.beginUpload(param), nil
+}
+
+func (c *SimConn) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	if err := c.enter("MultipartBlobAdd", NilStr(param.Commit.Key)); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(param.Body)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := c.store.addPart(param.Commit, param.PartNumber, data)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobAddOutput{PartId: PString(etag)}, nil
+}
+
+func (c *SimConn) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	if err := c.enter("MultipartBlobCopy", NilStr(param.Commit.Key)); err != nil {
+		return nil, err
+	}
+	etag, err := c.store.copyPart(param)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobCopyOutput{PartId: PString(etag)}, nil
+}
+
+func (c *SimConn) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	if err := c.enter("MultipartBlobAbort", NilStr(param.Key)); err != nil {
+		return nil, err
+	}
+	c.store.abortUpload(param)
+	return &MultipartBlobAbortOutput{}, nil
+}
+
+func (c *SimConn) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	if err := c.enter("MultipartBlobCommit", NilStr(param.Key)); err != nil {
+		return nil, err
+	}
+	obj, err := c.store.commitUpload(param)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobCommitOutput{
+		ETag:         PString(obj.etag),
+		LastModified: PTime(obj.lastModified),
+		StorageClass: obj.storageClass,
+	}, nil
+}
+
+func (c *SimConn) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	return &MultipartExpireOutput{}, nil
+}
+
+func (c *SimConn) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	return nil, syscall.ENOTSUP
+}
+
+func (c *SimConn) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	return nil, syscall.ENOTSUP
+}
@@ -40,6 +40,8 @@ func (s *TestBackend) Capabilities() *Capabilities {
 			s.capabilities = &Capabilities{
 				Name:             "s3",
 				MaxMultipartSize: 5 * 1024 * 1024 * 1024,
+				ConditionalPut:   true,
+				ServerSideCopy:   true,
 			}
 		}
 		return s.capabilities
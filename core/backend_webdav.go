@@ -0,0 +1,1126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// WebDAVBackend stores objects as resources of a WebDAV server. Like with
+// LocalBackend, key "a/b" is the resource a/b and "a/" is the collection a,
+// so every collection is also a directory object, and a key can't be both a
+// resource and a prefix of other keys.
+//
+// Metadata and content types are kept as JSON in a dead property of every
+// resource, so they're written with a PROPPATCH after each PUT and read
+// with a PROPFIND before each GET. Conditional writes and reads send ETags
+// in If-Match and If-None-Match headers, which the server must support for
+// ConditionalPut to be safe. Multipart uploads store parts as resources in
+// .geesefs_webdav/uploads and concatenate them on commit, through this host.
+type WebDAVBackend struct {
+	bucket   string
+	endpoint string
+	// decoded path of the root collection, with a trailing slash
+	basePath string
+	config   *cfg.WebDAVConfig
+	client   *http.Client
+	cap      Capabilities
+}
+
+var webdavLog = cfg.GetLogger("webdav")
+
+const webdavNS = "https://github.com/yandex-cloud/geesefs"
+const webdavMetaDir = ".geesefs_webdav"
+const webdavMultipartAge = 48 * time.Hour
+
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getetag/><D:getcontentlength/>` +
+	`<D:getlastmodified/><D:getcontenttype/></D:prop></D:propfind>`
+
+const webdavPropfindMeta = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<D:propfind xmlns:D="DAV:" xmlns:G="` + webdavNS + `"><D:prop><D:resourcetype/><D:getetag/>` +
+	`<D:getcontentlength/><D:getlastmodified/><D:getcontenttype/><G:metadata/></D:prop></D:propfind>`
+
+// webdavMeta is stored in the metadata property
+type webdavMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ETag          string `xml:"DAV: getetag"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ContentType   string `xml:"DAV: getcontenttype"`
+				Metadata      string `xml:"https://github.com/yandex-cloud/geesefs metadata"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type webdavEntry struct {
+	key    string
+	dir    bool
+	prefix bool
+	etag   string
+	size   uint64
+	mtime  time.Time
+	meta   webdavMeta
+}
+
+func NewWebDAV(bucket string, flags *cfg.FlagStorage, config *cfg.WebDAVConfig) (*WebDAVBackend, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("WebDAV endpoint should be an http:// or https:// URL: %v", config.Endpoint)
+	}
+	path := strings.TrimSuffix(u.Path, "/") + "/"
+	if bucket = strings.Trim(bucket, "/"); bucket != "" {
+		path += bucket + "/"
+	}
+	u.Path, u.RawPath, u.RawQuery, u.Fragment, u.User = path, "", "", "", nil
+	return &WebDAVBackend{
+		bucket:   bucket,
+		endpoint: u.String(),
+		basePath: path,
+		config:   config,
+		client: &http.Client{
+			Transport: cfg.ConfigureHTTP(flags),
+			Timeout:   flags.HTTPTimeout,
+		},
+		cap: Capabilities{
+			Name:             "webdav",
+			MaxMultipartSize: 5 * 1024 * 1024 * 1024,
+			ConditionalPut:   true,
+			ServerSideCopy:   true,
+			ServerSideRename: true,
+			UnsortedList:     true,
+		},
+	}, nil
+}
+
+// strongETag checks if etag can be sent in If-Match, weak ETags never match
+func strongETag(etag string) bool {
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
+
+// webdavError maps an unsuccessful response and closes its body
+func webdavError(resp *http.Response) error {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		// The parent collection doesn't exist
+		return syscall.ENOENT
+	case http.StatusLocked:
+		return syscall.EAGAIN
+	case http.StatusInsufficientStorage:
+		return syscall.ENOSPC
+	}
+	if err := mapHttpError(resp.StatusCode); err != nil {
+		return err
+	}
+	return fmt.Errorf("WebDAV server responded with %v", resp.Status)
+}
+
+func closeResponse(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// check rejects keys which aren't valid resource paths
+func (b *WebDAVBackend) check(key string) error {
+	name := strings.TrimSuffix(key, "/")
+	if name == "" {
+		return nil
+	}
+	for i, c := range strings.Split(name, "/") {
+		if c == "" || c == "." || c == ".." || i == 0 && c == webdavMetaDir {
+			return syscall.EINVAL
+		}
+	}
+	return nil
+}
+
+func (b *WebDAVBackend) url(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return b.endpoint + strings.Join(parts, "/")
+}
+
+// do sends a request of key with a body of size bytes, -1 if unknown
+func (b *WebDAVBackend) do(method, key string, header map[string]string, body io.Reader, size int64) (*http.Response, error) {
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, b.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil && size > 0 {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if b.config.Username != "" || b.config.Password != "" {
+		req.SetBasicAuth(b.config.Username, b.config.Password)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		webdavLog.Warnf("%v %v: %v", method, key, err)
+		return nil, syscall.EAGAIN
+	}
+	webdavLog.Debugf("%v %v: %v", method, key, resp.Status)
+	return resp, nil
+}
+
+// key returns the key of href, ok is false if it's outside of the root
+func (b *WebDAVBackend) key(href string, dir bool) (key string, ok bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	path := u.Path
+	if dir && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	if !strings.HasPrefix(path, b.basePath) {
+		return "", false
+	}
+	return path[len(b.basePath):], true
+}
+
+// propfind returns key with depth "0" or its children too with depth "1"
+func (b *WebDAVBackend) propfind(key, depth string, withMeta bool) ([]webdavEntry, error) {
+	body := webdavPropfind
+	if withMeta {
+		body = webdavPropfindMeta
+	}
+	resp, err := b.do("PROPFIND", key, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, webdavError(resp)
+	}
+	defer closeResponse(resp)
+	var ms webdavMultistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		webdavLog.Errorf("Failed to parse PROPFIND response of %v: %v", key, err)
+		return nil, syscall.EIO
+	}
+	var entries []webdavEntry
+	for _, r := range ms.Responses {
+		var e webdavEntry
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			p := &ps.Prop
+			e.dir = e.dir || p.ResourceType.Collection != nil
+			if p.ETag != "" {
+				e.etag = p.ETag
+			}
+			if p.ContentLength != "" {
+				e.size, _ = strconv.ParseUint(p.ContentLength, 10, 64)
+			}
+			if p.LastModified != "" {
+				e.mtime, _ = http.ParseTime(p.LastModified)
+			}
+			if p.ContentType != "" && e.meta.ContentType == "" {
+				e.meta.ContentType = p.ContentType
+			}
+			if p.Metadata != "" {
+				var meta webdavMeta
+				if json.Unmarshal([]byte(p.Metadata), &meta) == nil {
+					if meta.ContentType == "" {
+						meta.ContentType = e.meta.ContentType
+					}
+					e.meta = meta
+				}
+			}
+		}
+		var ok bool
+		e.key, ok = b.key(r.Href, e.dir)
+		if !ok {
+			continue
+		}
+		if e.dir {
+			e.size = 0
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// stat returns key if it exists and is of the right type
+func (b *WebDAVBackend) stat(key string, withMeta bool) (*webdavEntry, error) {
+	entries, err := b.propfind(key, "0", withMeta)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].key == key {
+			return &entries[i], nil
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (b *WebDAVBackend) item(e *webdavEntry) BlobItemOutput {
+	return BlobItemOutput{
+		Key:          PString(e.key),
+		ETag:         PString(e.etag),
+		LastModified: PTime(e.mtime),
+		Size:         e.size,
+	}
+}
+
+func (b *WebDAVBackend) head(e *webdavEntry) *HeadBlobOutput {
+	head := &HeadBlobOutput{
+		BlobItemOutput: b.item(e),
+		IsDirBlob:      e.dir,
+	}
+	head.Metadata = mapToPString(e.meta.Metadata)
+	if e.meta.ContentType != "" {
+		head.ContentType = PString(e.meta.ContentType)
+	}
+	return head
+}
+
+// mkcolParents creates collections of all parents of key
+func (b *WebDAVBackend) mkcolParents(key string) error {
+	for i := 0; i < len(key)-1; i++ {
+		if key[i] != '/' {
+			continue
+		}
+		resp, err := b.do("MKCOL", key[0:i+1], nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return webdavError(resp)
+		}
+		closeResponse(resp)
+	}
+	return nil
+}
+
+// proppatch stores meta of key. Multistatus responses report each
+// property, so they're checked too
+func (b *WebDAVBackend) proppatch(key string, meta *webdavMeta, ifMatch string) error {
+	data, _ := json.Marshal(meta)
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<D:propertyupdate xmlns:D="DAV:" xmlns:G="` + webdavNS + `"><D:set><D:prop><G:metadata>`)
+	xml.EscapeText(&body, data)
+	body.WriteString(`</G:metadata></D:prop></D:set></D:propertyupdate>`)
+	header := map[string]string{"Content-Type": "application/xml; charset=utf-8"}
+	if strongETag(ifMatch) {
+		header["If-Match"] = ifMatch
+	}
+	resp, err := b.do("PROPPATCH", key, header, &body, int64(body.Len()))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webdavError(resp)
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode == http.StatusMultiStatus {
+		var ms webdavMultistatus
+		if xml.NewDecoder(resp.Body).Decode(&ms) == nil {
+			for _, r := range ms.Responses {
+				for _, ps := range r.Propstats {
+					if !strings.Contains(ps.Status, " 200") {
+						webdavLog.Errorf("Failed to store metadata of %v: %v", key, ps.Status)
+						return syscall.EACCES
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// get reads count bytes of key from start, all if count is 0
+func (b *WebDAVBackend) get(key string, start, count uint64, ifMatch string) (io.ReadCloser, error) {
+	header := make(map[string]string)
+	if start > 0 || count > 0 {
+		if count > 0 {
+			header["Range"] = fmt.Sprintf("bytes=%v-%v", start, start+count-1)
+		} else {
+			header["Range"] = fmt.Sprintf("bytes=%v-", start)
+		}
+	}
+	if strongETag(ifMatch) {
+		header["If-Match"] = ifMatch
+	}
+	resp, err := b.do("GET", key, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, webdavError(resp)
+	}
+	var body io.Reader = resp.Body
+	if resp.StatusCode == http.StatusOK {
+		// The server ignored the range
+		if start > 0 {
+			_, err = io.CopyN(io.Discard, resp.Body, int64(start))
+			if err != nil && err != io.EOF {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		if count > 0 {
+			body = io.LimitReader(resp.Body, int64(count))
+		}
+	}
+	return localBody{body, resp.Body}, nil
+}
+
+func (b *WebDAVBackend) Init(key string) error {
+	e, err := b.stat("", false)
+	if err != nil {
+		return err
+	}
+	if !e.dir {
+		return fmt.Errorf("%v is not a collection", b.endpoint)
+	}
+	return nil
+}
+
+func (b *WebDAVBackend) Capabilities() *Capabilities {
+	return &b.cap
+}
+
+func (b *WebDAVBackend) Bucket() string {
+	return b.bucket
+}
+
+func (b *WebDAVBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	if err := b.check(param.Key); err != nil {
+		return nil, syscall.ENOENT
+	}
+	e, err := b.stat(param.Key, true)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir != strings.HasSuffix(param.Key, "/") {
+		return nil, syscall.ENOENT
+	}
+	return b.head(e), nil
+}
+
+// listDir adds entries of the collection dirKey starting with prefix, and
+// recursively of its subcollections if recursive
+func (b *WebDAVBackend) listDir(dirKey, prefix string, recursive bool, entries []webdavEntry) ([]webdavEntry, error) {
+	children, err := b.propfind(dirKey, "1", false)
+	if err == syscall.ENOENT {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	for _, e := range children {
+		if e.key == dirKey || !strings.HasPrefix(e.key, dirKey) ||
+			dirKey == "" && e.key == webdavMetaDir+"/" || !strings.HasPrefix(e.key, prefix) {
+			continue
+		}
+		if !e.dir {
+			entries = append(entries, e)
+		} else if !recursive {
+			e.prefix = true
+			entries = append(entries, e)
+		} else {
+			entries = append(entries, e)
+			entries, err = b.listDir(e.key, e.key, true, entries)
+			if err != nil {
+				return entries, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (b *WebDAVBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	prefix := NilStr(param.Prefix)
+	delim := NilStr(param.Delimiter)
+	if delim != "" && delim != "/" {
+		return nil, syscall.EINVAL
+	}
+	start := NilStr(param.StartAfter)
+	if token := NilStr(param.ContinuationToken); token > start {
+		start = token
+	}
+	maxKeys := 1000
+	if param.MaxKeys != nil && *param.MaxKeys > 0 {
+		maxKeys = int(*param.MaxKeys)
+	}
+	var entries []webdavEntry
+	dirKey := prefix[0 : strings.LastIndex(prefix, "/")+1]
+	if b.check(dirKey) != nil {
+		return &ListBlobsOutput{}, nil
+	}
+	if dirKey != "" && dirKey == prefix {
+		// The collection itself
+		if e, err := b.stat(dirKey, false); err == nil && e.dir {
+			entries = append(entries, *e)
+		}
+	}
+	entries, err := b.listDir(dirKey, prefix, delim == "", entries)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	res := &ListBlobsOutput{}
+	n := 0
+	for i := range entries {
+		e := &entries[i]
+		if e.key <= start {
+			continue
+		}
+		if n == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = PString(start)
+			break
+		}
+		if e.prefix {
+			res.Prefixes = append(res.Prefixes, BlobPrefixOutput{Prefix: PString(e.key)})
+		} else {
+			res.Items = append(res.Items, b.item(e))
+		}
+		start = e.key
+		n++
+	}
+	return res, nil
+}
+
+func (b *WebDAVBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	if b.check(param.Key) != nil {
+		return &DeleteBlobOutput{}, nil
+	}
+	// DELETE removes collections with all their members, so check that
+	// the key is the object and not a parent of others first
+	entries, err := b.propfind(param.Key, "1", false)
+	if err == syscall.ENOENT {
+		return &DeleteBlobOutput{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dir := strings.HasSuffix(param.Key, "/")
+	etag := ""
+	for _, e := range entries {
+		if e.key != param.Key || e.dir != dir {
+			// Like directory markers, collections with members stay
+			return &DeleteBlobOutput{}, nil
+		}
+		etag = e.etag
+	}
+	header := make(map[string]string)
+	if strongETag(etag) {
+		header["If-Match"] = etag
+	}
+	resp, err := b.do("DELETE", param.Key, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	return &DeleteBlobOutput{}, nil
+}
+
+func (b *WebDAVBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	for _, key := range param.Items {
+		_, err := b.DeleteBlob(&DeleteBlobInput{Key: key})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DeleteBlobsOutput{}, nil
+}
+
+// transfer sends a MOVE or COPY of source to destination, creating parent
+// collections of destination if they're missing
+func (b *WebDAVBackend) transfer(method, source, destination string, header map[string]string) error {
+	header["Destination"] = b.url(destination)
+	header["Overwrite"] = "T"
+	for attempt := 0; ; attempt++ {
+		resp, err := b.do(method, source, header, nil, 0)
+		if err != nil {
+			return err
+		}
+		// Some servers respond with 403 instead of 409 Conflict
+		if (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusForbidden &&
+			strings.Contains(destination, "/")) && attempt == 0 {
+			closeResponse(resp)
+			err = b.mkcolParents(destination)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return webdavError(resp)
+		}
+		closeResponse(resp)
+		return nil
+	}
+}
+
+func (b *WebDAVBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	if strings.HasSuffix(param.Source, "/") || strings.HasSuffix(param.Destination, "/") {
+		// Moving the collection would move its members too
+		return nil, syscall.ENOTSUP
+	}
+	if err := b.check(param.Source); err != nil {
+		return nil, err
+	}
+	if err := b.check(param.Destination); err != nil {
+		return nil, err
+	}
+	e, err := b.stat(param.Source, false)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir {
+		return nil, syscall.ENOENT
+	}
+	header := make(map[string]string)
+	if strongETag(e.etag) {
+		header["If-Match"] = e.etag
+	}
+	err = b.transfer("MOVE", param.Source, param.Destination, header)
+	if err != nil {
+		return nil, err
+	}
+	return &RenameBlobOutput{}, nil
+}
+
+func (b *WebDAVBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	if err := b.check(param.Source); err != nil {
+		return nil, err
+	}
+	if err := b.check(param.Destination); err != nil {
+		return nil, err
+	}
+	e, err := b.stat(param.Source, param.Metadata != nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir != strings.HasSuffix(param.Source, "/") {
+		return nil, syscall.ENOENT
+	}
+	if param.ETag != nil && *param.ETag != e.etag {
+		return nil, syscall.EBUSY
+	}
+	if param.Source != param.Destination {
+		header := map[string]string{"Depth": "0"}
+		if strongETag(e.etag) {
+			header["If-Match"] = e.etag
+		}
+		err = b.transfer("COPY", param.Source, param.Destination, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if param.Metadata != nil {
+		// Copies onto themselves only replace metadata
+		ifMatch := ""
+		if param.Source == param.Destination {
+			ifMatch = e.etag
+		}
+		err = b.proppatch(param.Destination, &webdavMeta{
+			ContentType: e.meta.ContentType,
+			Metadata:    mapToString(param.Metadata),
+		}, ifMatch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &CopyBlobOutput{}, nil
+}
+
+func (b *WebDAVBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	if param.VersionId != nil {
+		return nil, syscall.ENOTSUP
+	}
+	if err := b.check(param.Key); err != nil {
+		return nil, syscall.ENOENT
+	}
+	e, err := b.stat(param.Key, true)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir != strings.HasSuffix(param.Key, "/") {
+		return nil, syscall.ENOENT
+	}
+	if param.IfMatch != nil && *param.IfMatch != e.etag {
+		return nil, syscall.EBUSY
+	}
+	var body io.ReadCloser = io.NopCloser(strings.NewReader(""))
+	if !e.dir && param.Start < e.size {
+		// Read what was described by PROPFIND, the object may be
+		// replaced in the meantime
+		body, err = b.get(param.Key, param.Start, param.Count, e.etag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &GetBlobOutput{
+		HeadBlobOutput: *b.head(e),
+		Body:           body,
+	}, nil
+}
+
+// put stores key from body, creating parent collections if they're missing
+func (b *WebDAVBackend) put(key string, header map[string]string, body io.ReadSeeker, size int64) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := b.do("PUT", key, header, body, size)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusConflict && attempt == 0 {
+			closeResponse(resp)
+			err = b.mkcolParents(key)
+			if err == nil {
+				_, err = body.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return nil, webdavError(resp)
+		}
+		return resp, nil
+	}
+}
+
+// mkcol creates the collection key
+func (b *WebDAVBackend) mkcol(key string, ifNoneMatch *string) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := b.do("MKCOL", key, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusConflict && attempt == 0 {
+			closeResponse(resp)
+			err = b.mkcolParents(key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			// Already exists
+			closeResponse(resp)
+			if ifNoneMatch != nil {
+				return syscall.EBUSY
+			}
+			return nil
+		}
+		if resp.StatusCode >= 300 {
+			return webdavError(resp)
+		}
+		closeResponse(resp)
+		return nil
+	}
+}
+
+// written returns the ETag and mtime of key after a write
+func (b *WebDAVBackend) written(key string, resp *http.Response) (etag string, mtime time.Time) {
+	etag = resp.Header.Get("ETag")
+	mtime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if etag == "" || err != nil {
+		if e, err := b.stat(key, false); err == nil {
+			return e.etag, e.mtime
+		}
+		mtime = time.Now()
+	}
+	return
+}
+
+func (b *WebDAVBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	key := param.Key
+	if param.DirBlob && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	if err := b.check(key); err != nil || key == "" {
+		return nil, syscall.EINVAL
+	}
+	meta := &webdavMeta{
+		ContentType: NilStr(param.ContentType),
+		Metadata:    mapToString(param.Metadata),
+	}
+	var etag string
+	var mtime time.Time
+	if strings.HasSuffix(key, "/") {
+		err := b.mkcol(key, param.IfNoneMatch)
+		if err != nil {
+			return nil, err
+		}
+		err = b.proppatch(key, meta, "")
+		if err != nil {
+			return nil, err
+		}
+		if e, err := b.stat(key, false); err == nil {
+			etag, mtime = e.etag, e.mtime
+		}
+	} else {
+		header := make(map[string]string)
+		if param.ContentType != nil {
+			header["Content-Type"] = *param.ContentType
+		}
+		if param.IfMatch != nil {
+			header["If-Match"] = *param.IfMatch
+		}
+		if param.IfNoneMatch != nil {
+			header["If-None-Match"] = *param.IfNoneMatch
+		}
+		body := param.Body
+		if body == nil {
+			body = bytes.NewReader(nil)
+		}
+		var size int64
+		if param.Size != nil {
+			size = int64(*param.Size)
+		} else {
+			var err error
+			size, err = body.Seek(0, io.SeekEnd)
+			if err == nil {
+				_, err = body.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		resp, err := b.put(key, header, body, size)
+		if err != nil {
+			return nil, err
+		}
+		closeResponse(resp)
+		etag, mtime = b.written(key, resp)
+		// Properties survive PUTs, so they're always replaced. Someone
+		// else's newer write wins if it happens in between
+		err = b.proppatch(key, meta, etag)
+		if err != nil && err != syscall.EBUSY {
+			return nil, err
+		}
+	}
+	return &PutBlobOutput{
+		ETag:         PString(etag),
+		LastModified: PTime(mtime),
+	}, nil
+}
+
+func (b *WebDAVBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return nil, syscall.ENOTSUP
+}
+
+func (b *WebDAVBackend) uploadKey(commit *MultipartBlobCommitInput, name string) (string, error) {
+	id := NilStr(commit.UploadId)
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		return "", syscall.EINVAL
+	}
+	return webdavMetaDir + "/uploads/" + id + "/" + name, nil
+}
+
+func (b *WebDAVBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	if err := b.check(param.Key); err != nil {
+		return nil, err
+	}
+	commit := &MultipartBlobCommitInput{
+		Key:      PString(param.Key),
+		Metadata: param.Metadata,
+		UploadId: PString(RandStringBytesMaskImprSrc(32)),
+		Parts:    make([]*string, 10000), // at most 10K parts
+	}
+	data, _ := json.Marshal(&localUpload{
+		Key:         param.Key,
+		ContentType: NilStr(param.ContentType),
+		Metadata:    mapToString(param.Metadata),
+	})
+	key, _ := b.uploadKey(commit, "upload.json")
+	resp, err := b.put(key, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	closeResponse(resp)
+	return commit, nil
+}
+
+// addPart stores a part of an upload from r
+func (b *WebDAVBackend) addPart(commit *MultipartBlobCommitInput, partNumber uint32, r io.Reader, size int64) (*string, error) {
+	key, err := b.uploadKey(commit, fmt.Sprintf("part.%v", partNumber))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do("PUT", key, nil, r, size)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	return PString(resp.Header.Get("ETag")), nil
+}
+
+func (b *WebDAVBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	size := int64(param.Size)
+	if size == 0 && param.Body != nil {
+		var err error
+		size, err = param.Body.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = param.Body.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	etag, err := b.addPart(param.Commit, param.PartNumber, param.Body, size)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobAddOutput{PartId: etag}, nil
+}
+
+func (b *WebDAVBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	e, err := b.stat(param.CopySource, false)
+	if err != nil {
+		return nil, err
+	}
+	if param.Offset+param.Size > e.size {
+		return nil, syscall.ERANGE
+	}
+	body, err := b.get(param.CopySource, param.Offset, param.Size, e.etag)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	etag, err := b.addPart(param.Commit, param.PartNumber, body, int64(param.Size))
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartBlobCopyOutput{PartId: etag}, nil
+}
+
+func (b *WebDAVBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	dir, err := b.uploadKey(param, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do("DELETE", dir, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	return &MultipartBlobAbortOutput{}, nil
+}
+
+// webdavParts reads parts one after another
+type webdavParts struct {
+	b    *WebDAVBackend
+	keys []string
+	cur  io.ReadCloser
+}
+
+func (p *webdavParts) Read(buf []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			body, err := p.b.get(p.keys[0], 0, 0, "")
+			if err != nil {
+				return 0, err
+			}
+			p.cur, p.keys = body, p.keys[1:]
+		}
+		n, err := p.cur.Read(buf)
+		if err == io.EOF {
+			p.cur.Close()
+			p.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *webdavParts) Close() error {
+	if p.cur != nil {
+		return p.cur.Close()
+	}
+	return nil
+}
+
+func (b *WebDAVBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	dir, err := b.uploadKey(param, "")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := b.propfind(dir, "1", false)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]uint64)
+	for _, e := range entries {
+		sizes[e.key] = e.size
+	}
+	body, err := b.get(dir+"upload.json", 0, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var upload localUpload
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return nil, err
+	}
+	parts := &webdavParts{b: b}
+	total := uint64(0)
+	for i := uint32(1); i <= param.NumParts; i++ {
+		key := fmt.Sprintf("%vpart.%v", dir, i)
+		size, ok := sizes[key]
+		if !ok {
+			return nil, syscall.ENOENT
+		}
+		parts.keys = append(parts.keys, key)
+		total += size
+	}
+	// The body can't be sent again, so create parents first
+	err = b.mkcolParents(upload.Key)
+	if err != nil {
+		return nil, err
+	}
+	header := make(map[string]string)
+	if upload.ContentType != "" {
+		header["Content-Type"] = upload.ContentType
+	}
+	resp, err := b.do("PUT", upload.Key, header, parts, int64(total))
+	parts.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	etag, mtime := b.written(upload.Key, resp)
+	meta := &webdavMeta{
+		ContentType: upload.ContentType,
+		Metadata:    upload.Metadata,
+	}
+	if param.Metadata != nil {
+		meta.Metadata = mapToString(param.Metadata)
+	}
+	err = b.proppatch(upload.Key, meta, etag)
+	if err != nil && err != syscall.EBUSY {
+		return nil, err
+	}
+	b.MultipartBlobAbort(param)
+	return &MultipartBlobCommitOutput{
+		ETag:         PString(etag),
+		LastModified: PTime(mtime),
+	}, nil
+}
+
+func (b *WebDAVBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	uploads, err := b.propfind(webdavMetaDir+"/uploads/", "1", false)
+	if err == syscall.ENOENT {
+		return &MultipartExpireOutput{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, upload := range uploads {
+		if upload.dir && upload.key != webdavMetaDir+"/uploads/" && time.Since(upload.mtime) > webdavMultipartAge {
+			webdavLog.Debugf("Removing expired upload %v", upload.key)
+			resp, err := b.do("DELETE", upload.key, nil, nil, 0)
+			if err == nil {
+				closeResponse(resp)
+			}
+		}
+	}
+	return &MultipartExpireOutput{}, nil
+}
+
+func (b *WebDAVBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	entries, err := b.propfind("", "1", false)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.key != "" && e.key != webdavMetaDir+"/" {
+			return nil, syscall.ENOTEMPTY
+		}
+	}
+	resp, err := b.do("DELETE", "", nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	return &RemoveBucketOutput{}, nil
+}
+
+func (b *WebDAVBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	resp, err := b.do("MKCOL", "", nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, webdavError(resp)
+	}
+	closeResponse(resp)
+	return &MakeBucketOutput{}, nil
+}
+
+func (b *WebDAVBackend) Delegate() interface{} {
+	return b
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/webdav"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type WebDAVTest struct{}
+
+var _ = Suite(&WebDAVTest{})
+
+// webdavServer serves an in-memory WebDAV tree. golang.org/x/net/webdav
+// ignores If-Match and If-None-Match, so they're checked here with its ETags
+func webdavServer() *httptest.Server {
+	fs := webdav.NewMemFS()
+	dav := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if ifMatch != "" || ifNoneMatch != "" {
+			mu.Lock()
+			defer mu.Unlock()
+			fi, err := fs.Stat(r.Context(), r.URL.Path)
+			etag := ""
+			if err == nil {
+				etag = fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+			}
+			if ifMatch != "" && ifMatch != etag || ifNoneMatch == "*" && etag != "" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			r.Header.Del("If-Match")
+			r.Header.Del("If-None-Match")
+		}
+		dav.ServeHTTP(w, r)
+	}))
+}
+
+func (s *WebDAVTest) TestWebDAVBackendNoCloud(t *C) {
+	srv := webdavServer()
+	defer srv.Close()
+	b, err := NewWebDAV("bucket", cfg.DefaultFlags(), &cfg.WebDAVConfig{Endpoint: srv.URL})
+	t.Assert(err, IsNil)
+	_, err = b.MakeBucket(&MakeBucketInput{})
+	t.Assert(err, IsNil)
+	t.Assert(b.Init(""), IsNil)
+
+	put := func(key, data string, ifMatch, ifNoneMatch *string) (*PutBlobOutput, error) {
+		return b.PutBlob(&PutBlobInput{
+			Key:         key,
+			Body:        bytes.NewReader([]byte(data)),
+			Size:        PUInt64(uint64(len(data))),
+			Metadata:    map[string]*string{"k": PString("v")},
+			ContentType: PString("text/plain"),
+			IfMatch:     ifMatch,
+			IfNoneMatch: ifNoneMatch,
+		})
+	}
+	read := func(key string) string {
+		resp, err := b.GetBlob(&GetBlobInput{Key: key})
+		t.Assert(err, IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		t.Assert(err, IsNil)
+		return string(data)
+	}
+
+	// Conditional writes, parent collections are created
+	resp, err := put("dir/a", "1", nil, PString("*"))
+	t.Assert(err, IsNil)
+	etag := *resp.ETag
+	_, err = put("dir/a", "2", nil, PString("*"))
+	t.Assert(err, Equals, syscall.EBUSY)
+	_, err = put("dir/a", "2", PString("\"other\""), nil)
+	t.Assert(err, Equals, syscall.EBUSY)
+	resp, err = put("dir/a", "333", &etag, nil)
+	t.Assert(err, IsNil)
+	t.Assert(*resp.ETag, Not(Equals), etag)
+	t.Assert(read("dir/a"), Equals, "333")
+	head, err := b.HeadBlob(&HeadBlobInput{Key: "dir/a"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.ETag, Equals, *resp.ETag)
+	t.Assert(head.Size, Equals, uint64(3))
+	t.Assert(*head.Metadata["k"], Equals, "v")
+	t.Assert(*head.ContentType, Equals, "text/plain")
+	get, err := b.GetBlob(&GetBlobInput{Key: "dir/a", Start: 1, Count: 1})
+	t.Assert(err, IsNil)
+	data, err := io.ReadAll(get.Body)
+	get.Body.Close()
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "3")
+	t.Assert(*get.Metadata["k"], Equals, "v")
+
+	// Metadata is replaced by PUTs and copies onto the same key
+	_, err = b.PutBlob(&PutBlobInput{Key: "dir/a", Body: bytes.NewReader([]byte("333"))})
+	t.Assert(err, IsNil)
+	head, err = b.HeadBlob(&HeadBlobInput{Key: "dir/a"})
+	t.Assert(err, IsNil)
+	t.Assert(head.Metadata, HasLen, 0)
+	_, err = b.CopyBlob(&CopyBlobInput{Source: "dir/a", Destination: "dir/a", ETag: head.ETag,
+		Metadata: map[string]*string{"k": PString("w")}})
+	t.Assert(err, IsNil)
+	head, err = b.HeadBlob(&HeadBlobInput{Key: "dir/a"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.Metadata["k"], Equals, "w")
+
+	// Collections are directory objects, internal resources are hidden
+	_, err = put("dir/sub/c", "3", nil, nil)
+	t.Assert(err, IsNil)
+	_, err = b.PutBlob(&PutBlobInput{Key: "empty/", DirBlob: true})
+	t.Assert(err, IsNil)
+	_, err = put(webdavMetaDir+"/x", "", nil, nil)
+	t.Assert(err, Equals, syscall.EINVAL)
+	commit, err := b.MultipartBlobBegin(&MultipartBlobBeginInput{Key: "big"})
+	t.Assert(err, IsNil)
+	list, err := b.ListBlobs(&ListBlobsInput{Prefix: PString(""), Delimiter: PString("/")})
+	t.Assert(err, IsNil)
+	t.Assert(list.Items, HasLen, 0)
+	t.Assert(list.Prefixes, DeepEquals, []BlobPrefixOutput{{Prefix: PString("dir/")}, {Prefix: PString("empty/")}})
+	var keys []string
+	var token *string
+	for {
+		list, err = b.ListBlobs(&ListBlobsInput{MaxKeys: PUInt32(2), ContinuationToken: token})
+		t.Assert(err, IsNil)
+		for _, item := range list.Items {
+			keys = append(keys, *item.Key)
+		}
+		if !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	t.Assert(keys, DeepEquals, []string{"dir/", "dir/a", "dir/sub/", "dir/sub/c", "empty/"})
+
+	// Copies, renames and multipart uploads
+	_, err = b.CopyBlob(&CopyBlobInput{Source: "dir/a", Destination: "copy", ETag: PString("\"other\"")})
+	t.Assert(err, Equals, syscall.EBUSY)
+	_, err = b.CopyBlob(&CopyBlobInput{Source: "dir/a", Destination: "copy"})
+	t.Assert(err, IsNil)
+	_, err = b.RenameBlob(&RenameBlobInput{Source: "copy", Destination: "moved/copy"})
+	t.Assert(err, IsNil)
+	t.Assert(read("moved/copy"), Equals, "333")
+	head, err = b.HeadBlob(&HeadBlobInput{Key: "moved/copy"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.Metadata["k"], Equals, "w")
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "copy"})
+	t.Assert(err, Equals, syscall.ENOENT)
+	_, err = b.MultipartBlobAdd(&MultipartBlobAddInput{Commit: commit, PartNumber: 1, Body: bytes.NewReader([]byte("ab"))})
+	t.Assert(err, IsNil)
+	_, err = b.MultipartBlobCopy(&MultipartBlobCopyInput{Commit: commit, PartNumber: 2, CopySource: "dir/a", Offset: 1, Size: 2})
+	t.Assert(err, IsNil)
+	commit.NumParts = 2
+	_, err = b.MultipartBlobCommit(commit)
+	t.Assert(err, IsNil)
+	t.Assert(read("big"), Equals, "ab33")
+
+	// Collections with members aren't removed
+	_, err = b.DeleteBlobs(&DeleteBlobsInput{Items: []string{"dir/sub/", "empty/", "missing"}})
+	t.Assert(err, IsNil)
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "dir/sub/"})
+	t.Assert(err, IsNil)
+	_, err = b.HeadBlob(&HeadBlobInput{Key: "empty/"})
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	// Conditional writes of .geesefs_meta work through a mount
+	flags := cfg.DefaultFlags()
+	flags.EnablePerms = true
+	flags.DirMetaFile = true
+	flags.Backend = nil
+	fs, err := NewGoofys(context.Background(), strings.Replace(srv.URL, "http://", "dav://", 1)+"/bucket", flags)
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	t.Assert(flags.Backend.(*cfg.WebDAVConfig).Endpoint, Equals, srv.URL+"/bucket")
+	testDirMetaWrite(t, fs, b)
+}
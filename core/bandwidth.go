@@ -0,0 +1,308 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Several geesefs processes on one host may share its NIC cooperatively with
+// --host-read-bandwidth: the process which holds the lock of
+// --bandwidth-socket becomes the arbiter and hands out read bandwidth to all
+// processes connected to the socket, itself included. The rate is shared in
+// proportion to --bandwidth-weight between processes which are reading at the
+// moment, so a bulk archive mount can't starve a latency-sensitive mount on
+// the same link, while any of them may use the whole rate alone. When the
+// arbiter exits, another process takes over.
+//
+// The protocol is one line per request: the client sends "<weight> <bytes>",
+// the arbiter replies with the number of bytes which may be read, at least
+// <weight> MB.
+
+const (
+	// bytes granted at once per unit of weight
+	bandwidthQuantum = 1024 * 1024
+	// largest grant, larger requests are cut
+	bandwidthMaxGrant = 64 * 1024 * 1024
+	// reads aren't limited for this long after the arbiter is lost
+	bandwidthRetry = time.Second
+)
+
+// BandwidthLimiter is the client side of the arbitration
+type BandwidthLimiter struct {
+	path   string
+	rate   uint64
+	weight int
+
+	// serializes requests to the arbiter, readers queue here
+	mu      sync.Mutex
+	tokens  int64
+	conn    net.Conn
+	reader  *bufio.Reader
+	retryAt time.Time
+	closed  bool
+	// set while this process is the arbiter
+	arbiter atomic.Pointer[bandwidthArbiter]
+}
+
+func NewBandwidthLimiter(path string, rate uint64, weight int) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		path:   path,
+		rate:   rate,
+		weight: weight,
+	}
+}
+
+// Wait blocks until n bytes may be read. Reads are not limited while the
+// arbiter can't be reached
+func (l *BandwidthLimiter) Wait(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.tokens < n {
+		granted, err := l.request(n - l.tokens)
+		if err != nil {
+			if !l.closed {
+				log.Warnf("Read bandwidth arbiter on %v is not available, reads are not limited for %v: %v",
+					l.path, bandwidthRetry, err)
+			}
+			l.retryAt = time.Now().Add(bandwidthRetry)
+			l.tokens = n
+			break
+		}
+		l.tokens += granted
+	}
+	l.tokens -= n
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *BandwidthLimiter) request(want int64) (granted int64, err error) {
+	// Reconnect once if the arbiter is gone, maybe taking over its role
+	for attempt := 0; attempt < 2; attempt++ {
+		if l.conn == nil {
+			if l.closed || time.Now().Before(l.retryAt) {
+				// Not limited for now
+				return want, nil
+			}
+			err = l.connect()
+			if err != nil {
+				return 0, err
+			}
+		}
+		_, err = fmt.Fprintf(l.conn, "%d %d\n", l.weight, want)
+		if err == nil {
+			var line string
+			line, err = l.reader.ReadString('\n')
+			if err == nil {
+				_, err = fmt.Sscanf(line, "%d", &granted)
+			}
+		}
+		if err == nil {
+			return granted, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+	}
+	return 0, err
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *BandwidthLimiter) connect() error {
+	if l.arbiter.Load() == nil {
+		l.tryArbiter()
+	}
+	conn, err := net.Dial("unix", l.path)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// tryArbiter starts the arbiter if no other process holds the lock
+// LOCKS_REQUIRED(l.mu)
+func (l *BandwidthLimiter) tryArbiter() {
+	f, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		log.Warnf("Unable to open %v.lock: %v", l.path, err)
+		return
+	}
+	if lockFile(f) != nil {
+		// Another process is the arbiter
+		f.Close()
+		return
+	}
+	// The socket may be left by a previous arbiter
+	os.Remove(l.path)
+	listener, err := net.Listen("unix", l.path)
+	if err != nil {
+		log.Warnf("Unable to listen on %v: %v", l.path, err)
+		f.Close()
+		return
+	}
+	// Mounts of other users also connect to the socket
+	os.Chmod(l.path, 0666)
+	a := newBandwidthArbiter(listener, f, float64(l.rate))
+	l.arbiter.Store(a)
+	go a.serve()
+	log.Infof("Arbitrating %v MB/s of read bandwidth between mounts on %v", l.rate/1024/1024, l.path)
+}
+
+func (l *BandwidthLimiter) Close() {
+	// Unblocks our own requests
+	if a := l.arbiter.Load(); a != nil {
+		a.close()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+type bandwidthRequest struct {
+	bytes   int64
+	granted chan struct{}
+}
+
+// bandwidthArbiter is a token bucket serving requests in turn. Every process
+// has at most one request at a time and gets grants proportional to its
+// weight, so processes reading at the same time get bandwidth in proportion
+// to their weights, like with deficit round robin
+type bandwidthArbiter struct {
+	listener net.Listener
+	// the lock of the socket
+	lock *os.File
+	rate float64
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue []*bandwidthRequest
+	stop  chan struct{}
+}
+
+func newBandwidthArbiter(listener net.Listener, lock *os.File, rate float64) *bandwidthArbiter {
+	a := &bandwidthArbiter{
+		listener: listener,
+		lock:     lock,
+		rate:     rate,
+		stop:     make(chan struct{}),
+	}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+func (a *bandwidthArbiter) serve() {
+	go a.schedule()
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			return
+		}
+		go a.handle(conn)
+	}
+}
+
+// grantSize returns the number of bytes granted for a request
+func grantSize(weight int, want int64) int64 {
+	if weight < 1 {
+		weight = 1
+	}
+	bytes := int64(weight) * bandwidthQuantum
+	if want > bytes {
+		bytes = want
+	}
+	if bytes > bandwidthMaxGrant {
+		bytes = bandwidthMaxGrant
+	}
+	return bytes
+}
+
+func (a *bandwidthArbiter) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var weight int
+		var want int64
+		_, err = fmt.Sscanf(line, "%d %d", &weight, &want)
+		if err != nil {
+			return
+		}
+		req := &bandwidthRequest{
+			bytes:   grantSize(weight, want),
+			granted: make(chan struct{}),
+		}
+		a.mu.Lock()
+		a.queue = append(a.queue, req)
+		a.cond.Signal()
+		a.mu.Unlock()
+		select {
+		case <-req.granted:
+		case <-a.stop:
+			return
+		}
+		_, err = fmt.Fprintf(conn, "%d\n", req.bytes)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *bandwidthArbiter) schedule() {
+	// Idle time allows bursts of at most 100ms
+	burst := a.rate / 10
+	if burst < bandwidthQuantum {
+		burst = bandwidthQuantum
+	}
+	tokens, last := 0.0, time.Now()
+	for {
+		a.mu.Lock()
+		for len(a.queue) == 0 {
+			select {
+			case <-a.stop:
+				a.mu.Unlock()
+				return
+			default:
+			}
+			a.cond.Wait()
+		}
+		req := a.queue[0]
+		a.queue = a.queue[1:]
+		a.mu.Unlock()
+		now := time.Now()
+		tokens += now.Sub(last).Seconds() * a.rate
+		last = now
+		if tokens > burst {
+			tokens = burst
+		}
+		tokens -= float64(req.bytes)
+		if tokens < 0 {
+			select {
+			case <-time.After(time.Duration(-tokens / a.rate * float64(time.Second))):
+			case <-a.stop:
+				return
+			}
+		}
+		close(req.granted)
+	}
+}
+
+func (a *bandwidthArbiter) close() {
+	close(a.stop)
+	a.listener.Close()
+	// Let another process take over
+	a.lock.Close()
+	a.mu.Lock()
+	a.cond.Broadcast()
+	a.mu.Unlock()
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type BandwidthTest struct{}
+
+var _ = Suite(&BandwidthTest{})
+
+func (s *BandwidthTest) TestBandwidthSharesNoCloud(t *C) {
+	sock := t.MkDir() + "/bw.sock"
+	const rate = 40 * 1024 * 1024
+	live := NewBandwidthLimiter(sock, rate, 3)
+	bulk := NewBandwidthLimiter(sock, rate, 1)
+	defer bulk.Close()
+
+	// Alone, a mount gets the whole rate
+	start := time.Now()
+	live.Wait(8 * 1024 * 1024)
+	elapsed := time.Since(start)
+	t.Assert(live.arbiter.Load(), NotNil)
+	t.Assert(elapsed > 150*time.Millisecond, Equals, true)
+	t.Assert(elapsed < time.Second, Equals, true)
+
+	// Together, in proportion to weights
+	var liveBytes, bulkBytes int64
+	var wg sync.WaitGroup
+	stop := time.Now().Add(time.Second)
+	for _, m := range []struct {
+		l     *BandwidthLimiter
+		bytes *int64
+	}{{live, &liveBytes}, {bulk, &bulkBytes}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				m.l.Wait(READ_BUF_SIZE)
+				atomic.AddInt64(m.bytes, READ_BUF_SIZE)
+			}
+		}()
+	}
+	wg.Wait()
+	t.Assert(bulk.arbiter.Load(), IsNil)
+	total := liveBytes + bulkBytes
+	t.Assert(total < rate*3/2, Equals, true, Commentf("%v bytes in 1s", total))
+	t.Assert(total > rate/2, Equals, true, Commentf("%v bytes in 1s", total))
+	t.Assert(liveBytes > bulkBytes*2, Equals, true, Commentf("live %v, bulk %v", liveBytes, bulkBytes))
+	t.Assert(liveBytes < bulkBytes*5, Equals, true, Commentf("live %v, bulk %v", liveBytes, bulkBytes))
+
+	// Another process takes over when the arbiter exits
+	live.Close()
+	bulk.Wait(2 * 1024 * 1024)
+	t.Assert(bulk.arbiter.Load(), NotNil)
+	start = time.Now()
+	bulk.Wait(4 * 1024 * 1024)
+	t.Assert(time.Since(start) > 50*time.Millisecond, Equals, true)
+}
@@ -0,0 +1,92 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type BtimeTest struct{}
+
+var _ = Suite(&BtimeTest{})
+
+// legalHoldConn reports a legal hold for some objects
+type legalHoldConn struct {
+	*SimConn
+	held map[string]bool
+}
+
+func (c *legalHoldConn) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	resp, err := c.SimConn.HeadBlob(param)
+	if err == nil {
+		resp.LegalHold = c.held[param.Key]
+	}
+	return resp, err
+}
+
+func getattr(t *C, fs *GoofysFuse, name string) *fuseops.GetInodeAttributesOp {
+	lookup := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+	t.Assert(fs.LookUpInode(nil, lookup), IsNil)
+	op := &fuseops.GetInodeAttributesOp{Inode: lookup.Entry.Child}
+	t.Assert(fs.GetInodeAttributes(nil, op), IsNil)
+	return op
+}
+
+func (s *BtimeTest) TestBtimeNoCloud(t *C) {
+	c, err := NewSimCluster(3, func(i int, flags *cfg.FlagStorage) {
+		flags.EnableBtime = i != 1
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	created := c.Clock.Now()
+	t.Assert(c.Mounts[0].WriteAndSync("a", []byte("1")), IsNil)
+	t.Assert(c.Mounts[1].WriteAndSync("b", []byte("1")), IsNil)
+	inode, err := c.Mounts[0].fs.LookupPath("a")
+	t.Assert(err, IsNil)
+	t.Assert(inode.GetAttributes().Crtime.Equal(created), Equals, true)
+
+	// Birth time stored in metadata survives rewrites of the object,
+	// otherwise it's the creation date of the object
+	c.Clock.Advance(time.Hour)
+	t.Assert(c.Mounts[0].WriteAndSync("a", []byte("2")), IsNil)
+	t.Assert(c.Mounts[1].WriteAndSync("b", []byte("2")), IsNil)
+	fs := NewGoofysFuse(c.Mounts[2].fs)
+	op := getattr(t, fs, "a")
+	t.Assert(op.Attributes.Crtime.Equal(created), Equals, true)
+	t.Assert(op.Attributes.Mtime.Equal(c.Clock.Now()), Equals, true)
+	op = getattr(t, fs, "b")
+	t.Assert(op.Attributes.Crtime.Equal(c.Clock.Now()), Equals, true)
+}
+
+func (s *BtimeTest) TestImmutableNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	store.Put("locked", []byte("data"), nil)
+	store.Put("free", []byte("data"), nil)
+	conn := &legalHoldConn{SimConn: NewSimConn(store), held: map[string]bool{"locked": true}}
+	flags := cfg.DefaultFlags()
+	flags.CheckObjectLock = true
+	goofys, err := newGoofys(context.Background(), "sim", flags, func(string, *cfg.FlagStorage) (StorageBackend, error) {
+		return conn, nil
+	})
+	t.Assert(err, IsNil)
+	defer goofys.Shutdown()
+	fs := NewGoofysFuse(goofys)
+
+	// Only HEAD returns the object lock state, opening for writing loads it
+	op := getattr(t, fs, "locked")
+	t.Assert(fs.OpenFile(nil, &fuseops.OpenFileOp{Inode: op.Inode, OpenFlags: syscall.O_WRONLY}), Equals, syscall.EPERM)
+	op = getattr(t, fs, "locked")
+	t.Assert(op.Attributes.Mode&0222, Equals, os.FileMode(0))
+
+	op = getattr(t, fs, "free")
+	t.Assert(op.Attributes.Mode&0222, Not(Equals), os.FileMode(0))
+}
@@ -17,12 +17,16 @@ package core
 import (
 	"errors"
 	"fmt"
+	"hash/crc32"
 
 	"github.com/tidwall/btree"
 )
 
 var ErrBufferIsMissing = errors.New("tried to read from a missing buffer")
 var ErrBufferIsLoading = errors.New("tried to read from a loading buffer")
+var ErrChecksumMismatch = errors.New("disk cache data doesn't match its checksum")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
 
 type BufferState int16
 
@@ -89,6 +93,8 @@ type FileBuffer struct {
 	onDisk bool
 	// Chunk only contains zeroes, data and ptr are nil
 	zero bool
+	// Checksum of dirty data spilled to the disk cache
+	diskSum *diskChecksum
 	// Unmodified chunks (equal to the current server-side object state) have dirtyID = 0.
 	// Every write or split assigns a new unique chunk ID.
 	// Flusher tracks IDs that are currently being flushed to the server,
@@ -99,6 +105,21 @@ type FileBuffer struct {
 	ptr  *BufferPointer
 }
 
+// diskChecksum is only valid while the buffer keeps the same offset and
+// length, splitting or appending to a buffer invalidates it
+type diskChecksum struct {
+	offset uint64
+	length uint64
+	crc    uint32
+}
+
+func (buf *FileBuffer) checksum() *diskChecksum {
+	if buf.diskSum != nil && buf.diskSum.offset == buf.offset && buf.diskSum.length == buf.length {
+		return buf.diskSum
+	}
+	return nil
+}
+
 type Range struct {
 	Start, End uint64
 }
@@ -191,7 +212,8 @@ func (l *BufferList) EvictFromMemory(buf *FileBuffer) (allocated int64, deleted
 		if prev != nil && prev.offset+prev.length == buf.offset &&
 			prev.state == buf.state &&
 			prev.ptr == nil &&
-			prev.onDisk == buf.onDisk {
+			prev.onDisk == buf.onDisk &&
+			prev.checksum() == nil && buf.checksum() == nil {
 			l.unqueue(buf)
 			l.unqueue(prev)
 			l.at.Delete(prev.offset + prev.length)
@@ -588,11 +610,12 @@ func (l *BufferList) AddLoadingFromDisk(offset, size uint64) (readRanges []Range
 			return
 		}
 		if b.data == nil && b.onDisk && !b.loading {
-			if b.offset < offset {
+			// Checksummed buffers are always loaded whole
+			if b.offset < offset && b.checksum() == nil {
 				_, b = l.split(b, offset)
 				changed = true
 			}
-			if b.offset+b.length > endOffset {
+			if b.offset+b.length > endOffset && b.checksum() == nil {
 				b, _ = l.split(b, endOffset)
 				changed = true
 			}
@@ -605,9 +628,16 @@ func (l *BufferList) AddLoadingFromDisk(offset, size uint64) (readRanges []Range
 	return
 }
 
-func (l *BufferList) ReviveFromDisk(offset uint64, data []byte) {
+// ReviveFromDisk returns ErrChecksumMismatch if data read from the disk
+// cache doesn't match the checksum of spilled dirty data
+func (l *BufferList) ReviveFromDisk(offset uint64, data []byte) (err error) {
 	l.at.Ascend(offset+1, func(end uint64, b *FileBuffer) bool {
 		if b.offset == offset && b.length == uint64(len(data)) && b.loading && b.onDisk {
+			if sum := b.checksum(); sum != nil && crc32.Checksum(data, crcTable) != sum.crc {
+				b.loading = false
+				err = ErrChecksumMismatch
+				return false
+			}
 			b.data = data
 			b.ptr = &BufferPointer{
 				mem:  data,
@@ -622,6 +652,18 @@ func (l *BufferList) ReviveFromDisk(offset uint64, data []byte) {
 		}
 		return false
 	})
+	return
+}
+
+// AbortLoadingFromDisk marks the buffer at offset which failed to be read
+// from the disk cache as not loading, so that it's read again later
+func (l *BufferList) AbortLoadingFromDisk(offset uint64) {
+	l.at.Ascend(offset+1, func(end uint64, b *FileBuffer) bool {
+		if b.offset == offset && b.loading && b.onDisk {
+			b.loading = false
+		}
+		return false
+	})
 }
 
 func (l *BufferList) RemoveLoading(offset, size uint64) {
@@ -775,6 +817,11 @@ func (l *BufferList) GetData(offset, size uint64, returnIds bool) (data [][]byte
 			return false
 		} else if b.zero {
 			data = appendZero(data, curEnd-curOffset)
+		} else if b.data == nil {
+			// evicted to the disk cache and not loaded back
+			data = nil
+			err = ErrBufferIsMissing
+			return false
 		} else {
 			data = append(data, b.data[curOffset-b.offset:curEnd-b.offset])
 		}
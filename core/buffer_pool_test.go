@@ -161,6 +161,36 @@ func (s *BufferTest) TestMultiReader(t *C) {
 	t.Assert(err, Equals, io.EOF)
 }
 
+func (s *BufferTest) TestMultiReaderFile(t *C) {
+	file := bytes.NewReader([]byte("0123456789"))
+	r := NewMultiReader()
+	r.AddBuffer([]byte("ab"))
+	r.AddFile(file, 3, 4)
+	r.AddZero(2)
+	t.Assert(r.Len(), Equals, uint64(8))
+
+	buf := make([]byte, 5)
+	b, err := r.Read(buf)
+	t.Assert(b, Equals, 5)
+	t.Assert(err, IsNil)
+	t.Assert(string(buf), Equals, "ab345")
+
+	pos, err := r.Seek(3, 0)
+	t.Assert(pos, Equals, int64(3))
+	t.Assert(err, IsNil)
+	buf = make([]byte, 16)
+	b, err = r.Read(buf)
+	t.Assert(b, Equals, 5)
+	t.Assert(err, IsNil)
+	t.Assert(string(buf[0:5]), Equals, "456\x00\x00")
+
+	// Missing file data is reported
+	r = NewMultiReader()
+	r.AddFile(file, 8, 4)
+	_, err = r.Read(buf)
+	t.Assert(err, Equals, io.EOF)
+}
+
 func (s *BufferTest) TestCGroupMemory(t *C) {
 	//test getMemoryCgroupPath()
 	test_input := `11:hugetlb:/
@@ -15,6 +15,7 @@
 package core
 
 import (
+	"hash/crc32"
 	"io"
 )
 
@@ -22,6 +23,11 @@ type BufferOrZero struct {
 	data []byte
 	zero bool
 	size uint64
+	// data may also be read from a file lazily
+	file       io.ReaderAt
+	fileOffset uint64
+	// expected checksum of the file range, if set
+	crc *uint32
 }
 
 type MultiReader struct {
@@ -30,6 +36,9 @@ type MultiReader struct {
 	pos     uint64
 	bufPos  uint64
 	size    uint64
+	// checksum of the first crcPos bytes of the current buffer
+	crc    uint32
+	crcPos uint64
 }
 
 func NewMultiReader() *MultiReader {
@@ -52,6 +61,25 @@ func (r *MultiReader) AddZero(size uint64) {
 	r.size += size
 }
 
+// AddFile adds a file range which is only read when the reader reaches it.
+// Sequential reads are served from the kernel's readahead, so reading
+// from the disk is pipelined with sending the data.
+func (r *MultiReader) AddFile(file io.ReaderAt, offset, size uint64) {
+	r.buffers = append(r.buffers, BufferOrZero{
+		file:       file,
+		fileOffset: offset,
+		size:       size,
+	})
+	r.size += size
+}
+
+// AddCheckedFile is like AddFile, but reading fails with ErrChecksumMismatch
+// at the end of the range if the file data doesn't match crc
+func (r *MultiReader) AddCheckedFile(file io.ReaderAt, offset, size uint64, crc uint32) {
+	r.AddFile(file, offset, size)
+	r.buffers[len(r.buffers)-1].crc = &crc
+}
+
 func memzero(buf []byte) {
 	for j := 0; j < len(buf); j++ {
 		buf[j] = 0
@@ -73,6 +101,21 @@ func (r *MultiReader) Read(buf []byte) (n int, err error) {
 		}
 		if r.buffers[r.idx].zero {
 			memzero(buf[outPos : outPos+l])
+		} else if r.buffers[r.idx].file != nil {
+			_, err = r.buffers[r.idx].file.ReadAt(buf[outPos:outPos+l], int64(r.buffers[r.idx].fileOffset+r.bufPos))
+			if err != nil {
+				n = int(outPos)
+				return
+			}
+			if r.buffers[r.idx].crc != nil && r.crcPos == r.bufPos {
+				r.crc = crc32.Update(r.crc, crcTable, buf[outPos:outPos+l])
+				r.crcPos += l
+				if r.crcPos == r.buffers[r.idx].size && r.crc != *r.buffers[r.idx].crc {
+					n = int(outPos)
+					err = ErrChecksumMismatch
+					return
+				}
+			}
 		} else {
 			copy(buf[outPos:outPos+l], r.buffers[r.idx].data[r.bufPos:r.bufPos+l])
 		}
@@ -83,6 +126,7 @@ func (r *MultiReader) Read(buf []byte) (n int, err error) {
 		if r.bufPos >= r.buffers[r.idx].size {
 			r.idx++
 			r.bufPos = 0
+			r.crc, r.crcPos = 0, 0
 		}
 	}
 	n = int(outPos)
@@ -105,6 +149,7 @@ func (r *MultiReader) Seek(offset int64, whence int) (newOffset int64, err error
 	r.idx = 0
 	r.pos = 0
 	r.bufPos = 0
+	r.crc, r.crcPos = 0, 0
 	for r.pos < uOffset {
 		end := r.pos + r.buffers[r.idx].size
 		if end <= uOffset {
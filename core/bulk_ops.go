@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// BulkOps copies, moves and removes many objects directly through the
+// backend with high parallelism, bypassing FUSE. It's meant for
+// administrative bulk operations (geesefs cp/mv/rm). Paths are relative to
+// the bucket[:prefix], just like in a mount of the same bucket[:prefix].
+type BulkOps struct {
+	flags  *cfg.FlagStorage
+	cloud  StorageBackend
+	prefix string
+	jobs   int
+}
+
+func NewBulkOps(bucket string, flags *cfg.FlagStorage, jobs int) (*BulkOps, error) {
+	var prefix string
+	colon := strings.Index(bucket, ":")
+	if colon != -1 {
+		prefix = strings.Trim(bucket[colon+1:], "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		bucket = bucket[0:colon]
+	}
+	cloud, err := NewBackend(bucket, flags)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to setup backend: %v", err)
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &BulkOps{
+		flags:  flags,
+		cloud:  cloud,
+		prefix: prefix,
+		jobs:   jobs,
+	}, nil
+}
+
+func (b *BulkOps) key(path string) string {
+	return b.prefix + strings.Trim(path, "/")
+}
+
+// list returns the object itself, or everything under it if recursive
+func (b *BulkOps) list(path string, recursive bool) ([]BlobItemOutput, error) {
+	key := b.key(path)
+	if !recursive {
+		resp, err := b.cloud.HeadBlob(&HeadBlobInput{Key: key})
+		if err != nil {
+			return nil, err
+		}
+		return []BlobItemOutput{resp.BlobItemOutput}, nil
+	}
+	var items []BlobItemOutput
+	var startAfter *string
+	for {
+		resp, err := RetryListBlobs(b.flags, b.cloud, &ListBlobsInput{
+			Prefix:     PString(key + "/"),
+			StartAfter: startAfter,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Items...)
+		if !resp.IsTruncated || len(resp.Items) == 0 {
+			break
+		}
+		// NextContinuationToken is not returned when delimiter is empty
+		startAfter = resp.Items[len(resp.Items)-1].Key
+	}
+	return items, nil
+}
+
+// parallel runs fn for each of n items with at most b.jobs goroutines and
+// returns the first error
+func (b *BulkOps) parallel(n int, fn func(i int) error) error {
+	var next int64 = -1
+	var firstErr error
+	var errMu sync.Mutex
+	var wg sync.WaitGroup
+	for j := 0; j < b.jobs && j < n; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				err := fn(i)
+				if err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (b *BulkOps) deleteKeys(keys []string) error {
+	// S3 accepts at most 1000 keys in a DeleteObjects request
+	batch := 1000
+	return b.parallel((len(keys)+batch-1)/batch, func(i int) error {
+		end := (i + 1) * batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		_, err := b.cloud.DeleteBlobs(&DeleteBlobsInput{Items: keys[i*batch : end]})
+		if mapAwsError(err) == syscall.ENOENT {
+			err = nil
+		}
+		return err
+	})
+}
+
+// Remove deletes path, or everything under it if recursive
+func (b *BulkOps) Remove(path string, recursive bool) (int, error) {
+	items, err := b.list(path, recursive)
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, len(items))
+	for i := range items {
+		keys[i] = *items[i].Key
+	}
+	return len(keys), b.deleteKeys(keys)
+}
+
+// Copy copies src to dst with server-side copies, or everything under src if
+// recursive. If move is true, sources are deleted after all copies succeed.
+func (b *BulkOps) Copy(src, dst string, recursive bool, move bool) (int, error) {
+	srcKey, dstKey := b.key(src), b.key(dst)
+	if srcKey == dstKey || recursive && strings.HasPrefix(dstKey+"/", srcKey+"/") {
+		return 0, fmt.Errorf("Can't copy %v into itself", src)
+	}
+	if caps := b.cloud.Capabilities(); !caps.ServerSideCopy {
+		return 0, fmt.Errorf("%v doesn't support server-side copies", caps.Name)
+	}
+	items, err := b.list(src, recursive)
+	if err != nil {
+		return 0, err
+	}
+	err = b.parallel(len(items), func(i int) error {
+		item := &items[i]
+		_, err := b.cloud.CopyBlob(&CopyBlobInput{
+			Source:      *item.Key,
+			Destination: dstKey + (*item.Key)[len(srcKey):],
+			Size:        &item.Size,
+			ETag:        item.ETag,
+		})
+		return err
+	})
+	if err != nil || !move {
+		return len(items), err
+	}
+	keys := make([]string, len(items))
+	for i := range items {
+		keys[i] = *items[i].Key
+	}
+	return len(items), b.deleteKeys(keys)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type BulkOpsTest struct{}
+
+var _ = Suite(&BulkOpsTest{})
+
+func (s *BulkOpsTest) TestBulkOpsNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, prefix: "pre/", jobs: 4}
+
+	c.Store.Put("pre/src/", nil, nil)
+	c.Store.Put("pre/src/a", []byte("a"), nil)
+	c.Store.Put("pre/src/sub/b", []byte("b"), nil)
+	c.Store.Put("pre/srcfile", []byte("c"), nil)
+
+	_, err = b.Copy("src", "src/sub", true, false)
+	t.Assert(err, NotNil)
+
+	n, err := b.Copy("src", "dst", true, false)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 3)
+	data, ok := c.Store.Get("pre/dst/sub/b")
+	t.Assert(ok, Equals, true)
+	t.Assert(string(data), Equals, "b")
+	_, ok = c.Store.Get("pre/dst/")
+	t.Assert(ok, Equals, true)
+
+	n, err = b.Copy("dst", "moved", true, true)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 3)
+	_, ok = c.Store.Get("pre/dst/a")
+	t.Assert(ok, Equals, false)
+	_, ok = c.Store.Get("pre/moved/a")
+	t.Assert(ok, Equals, true)
+
+	// Removing srcfile leaves src/ alone
+	n, err = b.Remove("/srcfile", false)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 1)
+	n, err = b.Remove("src", true)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 3)
+	n, err = b.Remove("moved", true)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 3)
+	t.Assert(len(c.Store.Keys()), Equals, 0)
+}
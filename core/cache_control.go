@@ -0,0 +1,56 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Caching directives from the Cache-Control header of an object,
+// only used with --honor-cache-control
+type cacheControl struct {
+	set     bool
+	maxAge  time.Duration
+	noStore bool
+}
+
+func parseCacheControl(header string) (cc cacheControl) {
+	noCache := false
+	for _, d := range strings.Split(header, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "no-store" {
+			cc.noStore = true
+		} else if d == "no-cache" {
+			noCache = true
+		} else if strings.HasPrefix(d, "max-age=") {
+			secs, err := strconv.ParseUint(strings.Trim(d[8:], "\""), 10, 32)
+			if err == nil {
+				cc.set = true
+				cc.maxAge = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if noCache || cc.noStore {
+		cc.set = true
+		cc.maxAge = 0
+	}
+	return
+}
+
+// statTTL returns how long metadata of the inode may be cached
+func (inode *Inode) statTTL() time.Duration {
+	if inode.cacheControl.set {
+		return inode.cacheControl.maxAge
+	}
+	return inode.fs.flags.StatCacheTTL
+}
+
+// Drop cached data of no-store objects when they're closed
+func (inode *Inode) releaseNoStore() {
+	inode.mu.Lock()
+	if inode.cacheControl.noStore && atomic.LoadInt32(&inode.fileHandles) == 0 {
+		inode.dropCleanData()
+	}
+	inode.mu.Unlock()
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CacheControlTest struct{}
+
+var _ = Suite(&CacheControlTest{})
+
+func (s *CacheControlTest) TestParseCacheControl(t *C) {
+	t.Assert(parseCacheControl(""), Equals, cacheControl{})
+	t.Assert(parseCacheControl("public"), Equals, cacheControl{})
+	t.Assert(parseCacheControl("public, max-age=300"), Equals, cacheControl{set: true, maxAge: 300 * time.Second})
+	t.Assert(parseCacheControl("Max-Age=\"5\""), Equals, cacheControl{set: true, maxAge: 5 * time.Second})
+	t.Assert(parseCacheControl("max-age=bad"), Equals, cacheControl{})
+	t.Assert(parseCacheControl("no-cache, max-age=300"), Equals, cacheControl{set: true})
+	t.Assert(parseCacheControl("no-store"), Equals, cacheControl{set: true, noStore: true})
+}
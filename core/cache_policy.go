@@ -0,0 +1,102 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// cachePolicy returns the first eviction policy matching the inode's path
+func (fs *Goofys) cachePolicy(inode *Inode) *cfg.CachePolicy {
+	if len(fs.flags.CachePolicies) == 0 {
+		return nil
+	}
+	path := inode.FullName()
+	for i := range fs.flags.CachePolicies {
+		if fs.flags.CachePolicies[i].Match(path) {
+			return &fs.flags.CachePolicies[i]
+		}
+	}
+	return nil
+}
+
+// Data of files with an unexpired policy or pinned through the admin API is
+// evicted after data of other files
+// LOCKS_REQUIRED(inode.mu)
+func (fs *Goofys) cacheProtected(inode *Inode) bool {
+	if inode.pinned {
+		return true
+	}
+	policy := fs.cachePolicy(inode)
+	return policy != nil && !expired(inode.accessTime, policy.MaxAge)
+}
+
+func cacheEvictInterval(policies []cfg.CachePolicy) time.Duration {
+	interval := time.Minute
+	for _, p := range policies {
+		if p.MaxAge/2 < interval {
+			interval = p.MaxAge / 2
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// CacheEvictor drops cached data of files which weren't accessed for longer
+// than their policy allows, both from memory and from the disk cache
+func (fs *Goofys) CacheEvictor() {
+	interval := cacheEvictInterval(fs.flags.CachePolicies)
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(interval):
+		case <-fs.shutdownCh:
+			return
+		}
+		fs.evictByPolicy()
+	}
+}
+
+// dropCleanData removes cached data of the inode from memory and from the disk
+// cache. Only completely clean files are dropped, everything else belongs to
+// the flusher
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dropCleanData() bool {
+	if inode.CacheState != ST_CACHED || inode.IsFlushing != 0 || inode.isStillDirty() ||
+		len(inode.readRanges) != 0 {
+		return false
+	}
+	allocated := inode.buffers.RemoveRange(0, 0xffffffffffffffff, nil)
+	inode.fs.bufferPool.Use(allocated, true)
+	inode.removeDiskCache()
+	return true
+}
+
+func (fs *Goofys) evictByPolicy() {
+	var scan []*Inode
+	fs.mu.RLock()
+	for _, inode := range fs.inodes {
+		if inode.dir == nil {
+			scan = append(scan, inode)
+		}
+	}
+	fs.mu.RUnlock()
+	evicted := 0
+	for _, inode := range scan {
+		inode.mu.Lock()
+		if inode.buffers.Count() == 0 && !inode.OnDisk {
+			inode.mu.Unlock()
+			continue
+		}
+		policy := fs.cachePolicy(inode)
+		if policy != nil && !inode.pinned && expired(inode.accessTime, policy.MaxAge) && inode.dropCleanData() {
+			evicted++
+		}
+		inode.mu.Unlock()
+	}
+	if evicted > 0 {
+		log.Debugf("cache policies: evicted data of %v files", evicted)
+	}
+}
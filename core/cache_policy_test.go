@@ -0,0 +1,58 @@
+package core
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type CachePolicyTest struct{}
+
+var _ = Suite(&CachePolicyTest{})
+
+func (s *CachePolicyTest) TestCachePolicyMatch(t *C) {
+	raw := cfg.NewCachePolicy("/raw/**", time.Minute)
+	t.Assert(raw.Match("raw/a/b/chunk_001.h5"), Equals, true)
+	t.Assert(raw.Match("calib/raw/x"), Equals, false)
+	calib := cfg.NewCachePolicy("**/*.cal", 7*24*time.Hour)
+	t.Assert(calib.Match("exp1/det/gain.cal"), Equals, true)
+	t.Assert(calib.Match("exp1/det/gain.cal.bak"), Equals, false)
+	single := cfg.NewCachePolicy("*.cal", time.Hour)
+	t.Assert(single.Match("gain.cal"), Equals, true)
+	t.Assert(single.Match("det/gain.cal"), Equals, false)
+}
+
+func (s *CachePolicyTest) TestCachePolicyEvictNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.CachePolicies = []cfg.CachePolicy{
+			cfg.NewCachePolicy("raw/**", 0),
+			cfg.NewCachePolicy("**", time.Hour),
+		}
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("raw/", nil, nil)
+	c.Store.Put("raw/chunk", []byte("chunk data"), nil)
+	c.Store.Put("gain.cal", []byte("calibration"), nil)
+	for _, path := range []string{"raw/chunk", "gain.cal"} {
+		_, err = m.ReadFile(path)
+		t.Assert(err, IsNil)
+	}
+	chunk, err := m.fs.LookupPath("raw/chunk")
+	t.Assert(err, IsNil)
+	cal, err := m.fs.LookupPath("gain.cal")
+	t.Assert(err, IsNil)
+
+	m.fs.evictByPolicy()
+	t.Assert(chunk.buffers.Count(), Equals, 0)
+	t.Assert(cal.buffers.Count() > 0, Equals, true)
+
+	data, err := m.ReadFile("raw/chunk")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "chunk data")
+	t.Assert(m.Conn.Calls("GetBlob"), Equals, 3)
+}
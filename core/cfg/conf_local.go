@@ -0,0 +1,7 @@
+package cfg
+
+// LocalConfig is the configuration of file:// mounts. Buckets are
+// subdirectories of Root, so file:///mnt/share mounts /mnt/share itself
+type LocalConfig struct {
+	Root string
+}
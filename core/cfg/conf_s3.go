@@ -17,7 +17,6 @@ package cfg
 
 import (
 	"crypto/md5"
-	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net/http"
@@ -70,7 +69,14 @@ type S3Config struct {
 	ListV2     bool
 	ListV1Ext  bool
 
-	Subdomain bool
+	// S3 names, for example CRC32C and FULL_OBJECT
+	ChecksumAlgorithm string
+	ChecksumType      string
+
+	Subdomain     bool
+	NoSignRequest bool
+	UseAccelerate bool
+	UseDualStack  bool
 
 	UseIAM    bool
 	IAMFlavor string
@@ -105,19 +111,11 @@ func (c *S3Config) Init() *S3Config {
 }
 
 func (c *S3Config) ToAwsConfig(flags *FlagStorage) (*aws.Config, error) {
-	tr := &defaultHTTPTransport
-	if flags.NoVerifySSL {
-		if tr.TLSClientConfig != nil {
-			tr.TLSClientConfig.InsecureSkipVerify = true
-		} else {
-			tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		}
-	}
 	awsConfig := (&aws.Config{
 		Region: &c.Region,
 		Logger: GetLogger("s3"),
 	}).WithHTTPClient(&http.Client{
-		Transport: tr,
+		Transport: ConfigureHTTP(flags),
 		Timeout:   flags.HTTPTimeout,
 	})
 	if flags.DebugS3 {
@@ -137,6 +135,14 @@ func (c *S3Config) ToAwsConfig(flags *FlagStorage) (*aws.Config, error) {
 	}
 
 	awsConfig.S3ForcePathStyle = aws.Bool(!c.Subdomain)
+	if c.UseAccelerate {
+		// Accelerate endpoints only support virtual-hosted-style requests
+		awsConfig.S3UseAccelerate = aws.Bool(true)
+		awsConfig.S3ForcePathStyle = aws.Bool(false)
+	}
+	if c.UseDualStack {
+		awsConfig.UseDualStack = aws.Bool(true)
+	}
 
 	awsConfig.Retryer = client.DefaultRetryer{
 		NumMaxRetries:    c.SDKMaxRetries,
@@ -176,11 +182,14 @@ func (c *S3Config) ToAwsConfig(flags *FlagStorage) (*aws.Config, error) {
 			})
 	}
 
-	if c.Credentials != nil {
+	if c.NoSignRequest {
+		awsConfig.Credentials = credentials.AnonymousCredentials
+	} else if c.Credentials != nil {
 		awsConfig.Credentials = c.Credentials
 	}
 
-	if c.SseC != "" {
+	// Already decoded in a config copied with ForPrefix
+	if c.SseC != "" && c.SseCDigest == "" {
 		key, err := base64.StdEncoding.DecodeString(c.SseC)
 		if err != nil {
 			return nil, fmt.Errorf("sse-c is not base64-encoded: %v", err)
@@ -194,6 +203,56 @@ func (c *S3Config) ToAwsConfig(flags *FlagStorage) (*aws.Config, error) {
 	return awsConfig, nil
 }
 
+// ForPrefix returns a copy of the config which uses another profile or
+// assumes another role, for --prefix-credentials. Roles are assumed with
+// the credentials of this config
+func (c *S3Config) ForPrefix(pc PrefixCredentials) (*S3Config, error) {
+	copy := *c
+	copy.UseIAM = false
+	copy.RoleArn = ""
+	copy.AccessKey = ""
+	copy.SecretKey = ""
+	if pc.Profile != "" {
+		cfg := c.SharedConfig
+		if len(cfg) == 0 {
+			cfg = nil
+		}
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Profile:           pc.Profile,
+			SharedConfigFiles: cfg,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, err
+		}
+		copy.Profile = pc.Profile
+		copy.Session = sess
+		copy.Credentials = nil
+	}
+	if pc.RoleArn != "" {
+		sess := copy.Session
+		if sess == nil {
+			var err error
+			sess, err = session.NewSession()
+			if err != nil {
+				return nil, err
+			}
+		}
+		stsConfig := &aws.Config{}
+		if copy.Credentials != nil {
+			stsConfig.Credentials = copy.Credentials
+		}
+		if c.StsEndpoint != "" {
+			stsConfig.Endpoint = &c.StsEndpoint
+		}
+		copy.Credentials = stscreds.NewCredentials(sess.Copy(stsConfig), pc.RoleArn,
+			func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = c.RoleSessionName
+			})
+	}
+	return &copy, nil
+}
+
 type stsConfigProvider struct {
 	*S3Config
 }
@@ -0,0 +1,11 @@
+package cfg
+
+// WebDAVConfig is the configuration of dav:// and davs:// mounts. Buckets
+// are collections under Endpoint, so davs://host/share mounts /share itself
+type WebDAVConfig struct {
+	// URL of the root collection, http:// or https://
+	Endpoint string
+	// Basic authentication, from the URL or WEBDAV_USER and WEBDAV_PASSWORD
+	Username string
+	Password string
+}
@@ -22,6 +22,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -31,6 +32,130 @@ type PartSizeConfig struct {
 	PartCount uint64
 }
 
+// Latency SLO: Quantile of Op latencies should be below Max
+type SLOConfig struct {
+	Op       string
+	Quantile float64
+	Max      time.Duration
+}
+
+// Cache eviction policy: clean data of files matching Pattern is evicted
+// after not being accessed for MaxAge
+type CachePolicy struct {
+	Pattern string
+	MaxAge  time.Duration
+	re      *regexp.Regexp
+}
+
+func NewCachePolicy(pattern string, maxAge time.Duration) CachePolicy {
+	pattern = strings.Trim(pattern, "/")
+	return CachePolicy{
+		Pattern: pattern,
+		MaxAge:  maxAge,
+		re:      regexp.MustCompile(globToRegexp(pattern)),
+	}
+}
+
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// Match checks if the path relative to the mount root matches the policy
+func (p *CachePolicy) Match(path string) bool {
+	return p.re.MatchString(path)
+}
+
+// FlushPolicy overrides when changes of matching files are flushed.
+// Delay = 0 flushes them immediately, even while files are open.
+// Delay > 0 holds them back for up to Delay to coalesce writes.
+type FlushPolicy struct {
+	Pattern string
+	Delay   time.Duration
+	re      *regexp.Regexp
+}
+
+func NewFlushPolicy(pattern string, delay time.Duration) FlushPolicy {
+	pattern = strings.Trim(pattern, "/")
+	return FlushPolicy{
+		Pattern: pattern,
+		Delay:   delay,
+		re:      regexp.MustCompile(globToRegexp(pattern)),
+	}
+}
+
+// Match checks if the path relative to the mount root matches the policy
+func (p *FlushPolicy) Match(path string) bool {
+	return p.re.MatchString(path)
+}
+
+// RoutingRule stores objects of matching files in another bucket or
+// endpoint, or writes them with another storage class (--route)
+type RoutingRule struct {
+	Pattern      string
+	Bucket       string
+	Endpoint     string
+	StorageClass string
+	re           *regexp.Regexp
+}
+
+func NewRoutingRule(pattern string) RoutingRule {
+	pattern = strings.Trim(pattern, "/")
+	return RoutingRule{
+		Pattern: pattern,
+		re:      regexp.MustCompile(globToRegexp(pattern)),
+	}
+}
+
+// Match checks if the path relative to the mount root matches the rule
+func (r *RoutingRule) Match(path string) bool {
+	return r.re.MatchString(path)
+}
+
+// ReadReplica is a replica of the bucket objects are read from when it has
+// them in the same version (--read-replica)
+type ReadReplica struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+}
+
+// PrefixCredentials makes a directory of the mount use another AWS profile
+// or assume another role (--prefix-credentials)
+type PrefixCredentials struct {
+	Path    string
+	Profile string
+	RoleArn string
+}
+
+// BucketMount mounts another bucket or prefix at a directory of the mount
+// (--mount-bucket). It uses the main credentials unless Profile or RoleArn
+// is set
+type BucketMount struct {
+	Path    string
+	Bucket  string
+	Prefix  string
+	Profile string
+	RoleArn string
+}
+
 type NodeConfig struct {
 	Id      uint64
 	Address string
@@ -50,6 +175,10 @@ type FlagStorage struct {
 	Setuid   int
 	Setgid   int
 
+	AllowUsers []uint32
+	// FUSE mount methods to try in order, nil for the default
+	MountMethods []string
+
 	IgnoreSettingAttrsForRootDirErrors bool
 
 	// Common Backend Config
@@ -65,15 +194,40 @@ type FlagStorage struct {
 	Cheap               bool
 	ExplicitDir         bool
 	NoDirObject         bool
+	FolderMarkers       bool
+	KeepMarkers         bool
+	NoList              bool
+	KeyManifest         string
+	InodeMap            string
+	SingleObject        bool
 	MaxFlushers         int64
 	MaxParallelParts    int
 	MaxParallelCopy     int
+	MaxOpenFiles        int
+	MaxOpenFilesPerPid  int
+	HandleLeakAge       time.Duration
+	DirtyAgeAlert       time.Duration
+	MaxDirtyAge         time.Duration
 	StatCacheTTL        time.Duration
+	HonorCacheControl   bool
+	PresignMaxTTL       time.Duration
+	ChangeJournal       string
+	ChangeJournalMB     uint64
+	UsageSnapshot       string
+	UsageInterval       time.Duration
+	UsageDepth          int
 	HTTPTimeout         time.Duration
+	ResponseTimeout     time.Duration
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	TLSSessionCache     int
+	HTTP2               bool
 	ReadRetryInterval   time.Duration
 	ReadRetryMultiplier float64
 	ReadRetryMax        time.Duration
 	ReadRetryAttempts   int
+	PinETag             bool
+	StaleHandle         string
 	RetryInterval       time.Duration
 	ReadAheadKB         uint64
 	SmallReadCount      uint64
@@ -83,13 +237,26 @@ type FlagStorage struct {
 	ReadAheadLargeKB    uint64
 	ReadAheadParallelKB uint64
 	ReadMergeKB         uint64
+	HostReadBandwidthMB uint64
+	BandwidthSocket     string
+	BandwidthWeight     int
 	SinglePartMB        uint64
 	MaxMergeCopyMB      uint64
 	IgnoreFsync         bool
 	FsyncOnClose        bool
 	EnablePerms         bool
+	DirMetaFile         bool
+	DirMetaSpecials     bool
+	DirMetaMigrate      bool
+	MetaReadOnly        bool
+	TimeTravel          bool
+	Clones              bool
+	S3Select            bool
+	S3SelectCacheMB     uint64
 	EnableSpecials      bool
 	EnableMtime         bool
+	EnableBtime         bool
+	CheckObjectLock     bool
 	EmulateHardlinks    bool
 	DisableXattr        bool
 	UidAttr             string
@@ -97,14 +264,45 @@ type FlagStorage struct {
 	FileModeAttr        string
 	RdevAttr            string
 	MtimeAttr           string
+	BtimeAttr           string
+	Atime               string
+	AtimeFlushInterval  time.Duration
+	ContentHash         string
+	ContentHashAttr     string
+	WriterStamp         bool
+	MountName           string
+	ChecksumManifests   []string
+	ChecksumSample      float64
 	SymlinkAttr         string
+	SymlinkRootAttr     string
+	MaxSymlinksPerDir   int
 	RefreshAttr         string
 	RefreshFilename     string
 	FlushFilename       string
+	TempPrefix          string
+	TempCleanupAge      time.Duration
+	RenameJournal       bool
+	InvalidationLog     bool
+	InvalidationPoll    time.Duration
+	WriteIntent         string
+	WriteIntentTTL      time.Duration
+	SQSQueue            string
 	CachePath           string
 	MaxDiskCacheFD      int64
 	CacheFileMode       os.FileMode
+	SpillDirty          bool
+	CachePolicies       []CachePolicy
+	FlushPolicies       []FlushPolicy
+	PrefixCredentials   []PrefixCredentials
+	BucketMounts        []BucketMount
+	Routes              []RoutingRule
+	ReadReplicas        []ReadReplica
 	PartSizes           []PartSizeConfig
+	ProbeTuning         bool
+	PartSizesSet        bool
+	ReadAheadSet        bool
+	MaxPartsSet         bool
+	MaxFlushersSet      bool
 	UsePatch            bool
 	DropPatchConflicts  bool
 	PreferPatchUploads  bool
@@ -117,11 +315,20 @@ type FlagStorage struct {
 	DebugFuse  bool
 	DebugS3    bool
 	PProf      string
+	HTTPAuth   string
 	Foreground bool
 	LogFile    string
 	DebugGrpc  bool
+	TraceOps   bool
+	ServeCache bool
+	AdminGrpc  string
+
+	ErrorLog     string
+	ErrorLogSize int
 
 	StatsInterval time.Duration
+	SLOs          []SLOConfig
+	SLOWindow     time.Duration
 
 	// Cluster Mode
 	ClusterMode           bool
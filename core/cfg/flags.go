@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -53,6 +54,7 @@ func NewApp() (app *cli.App) {
 
 USAGE:
    {{.Name}} {{if .Flags}}[global options]{{end}} bucket[:prefix] mountpoint
+   {{.Name}} --bulk {{if .Flags}}[global options]{{end}} command [command options] bucket[:prefix] [arguments...]
    {{if .Version}}
 VERSION:
    {{.Version}}
@@ -141,6 +143,20 @@ MISC OPTIONS:
 			Usage: "Drop root group and change to this group ID (defaults to --gid).",
 		},
 
+		cli.StringFlag{
+			Name: "allow-users",
+			Usage: "Comma-separated list of UIDs allowed to access the mount besides its owner. Checked by" +
+				" geesefs itself, the mount uses allow_other which requires user_allow_other in /etc/fuse.conf" +
+				" unless mounted by root.",
+		},
+
+		cli.StringFlag{
+			Name: "mount-methods",
+			Usage: "Comma-separated list of ways to mount the file system, tried in order until one succeeds." +
+				" Linux: direct (mount(2), as root, with CAP_SYS_ADMIN or in a user namespace), fusermount3," +
+				" fusermount (default: all of them in this order). macOS: macfuse, fuse-t (default: both).",
+		},
+
 		cli.BoolFlag{
 			Name:  "refresh-dirs",
 			Usage: "Automatically refresh open directories using notifications under Windows",
@@ -228,6 +244,42 @@ MISC OPTIONS:
 			Usage: "Use different shared configuration file(s) instead of $HOME/.aws/credentials and $HOME/.aws/config",
 		},
 
+		cli.StringSliceFlag{
+			Name: "prefix-credentials",
+			Usage: "Use other credentials for a directory of the mount, as <path>:<profile or role ARN>." +
+				" The path is relative to the mount root. A value starting with arn: is a role ARN which is assumed" +
+				" with the main credentials, anything else is a profile name from the shared configuration files." +
+				" May be repeated. Only supported with S3",
+		},
+
+		cli.StringSliceFlag{
+			Name: "route",
+			Usage: "Store objects of files matching a pattern elsewhere, as <pattern>:<key>=<value>[,<key>=<value>...]." +
+				" Keys are bucket, endpoint and storage-class, for example **.tmp:bucket=scratch or" +
+				" results/**:storage-class=GLACIER_IR. Patterns are matched against paths relative to the mount root," +
+				" * doesn't match /, ** does. The first matching rule applies. Objects routed to other buckets keep" +
+				" their keys, are hidden in the main bucket and can't be renamed to paths with other routes, mv" +
+				" copies them. May be repeated",
+		},
+
+		cli.StringSliceFlag{
+			Name: "read-replica",
+			Usage: "Read objects from a replica of the bucket, for example one in a nearer region kept up to date" +
+				" with S3 Replication Time Control, as <bucket>[,region=<region>][,endpoint=<url>]. Objects are read" +
+				" from the replica only if it has them with the same ETag, otherwise from the bucket itself." +
+				" Writes and listings always go to the bucket. May be repeated, replicas are tried in order",
+		},
+
+		cli.StringSliceFlag{
+			Name: "mount-bucket",
+			Usage: "Mount another bucket or prefix at a directory of the mount, as" +
+				" <path>=<bucket>[:<prefix>][,<profile or role ARN>], for example /scratch=tmp-bucket:user1." +
+				" The path is relative to the mount root and hides objects of the main bucket under it." +
+				" The bucket uses the same storage type and endpoint as the main one and the main credentials," +
+				" or a profile or role like in --prefix-credentials (S3 only). Files can't be renamed between" +
+				" buckets, mv copies them. May be repeated",
+		},
+
 		cli.BoolFlag{
 			Name:  "use-content-type",
 			Usage: "Set Content-Type according to file extension and /etc/mime.types (default: off)",
@@ -257,6 +309,19 @@ MISC OPTIONS:
 			Usage: "Disable content MD5 and SHA256 checksums for performance (default: off)",
 		},
 
+		cli.StringFlag{
+			Name: "checksum-algorithm",
+			Usage: "Upload objects with an S3 checksum of this algorithm: crc32, crc32c, crc64nvme, sha1 or sha256," +
+				" and verify checksums of objects read completely. The algorithm is shown in the s3.checksum-algorithm" +
+				" xattr (default: off)",
+		},
+
+		cli.StringFlag{
+			Name: "checksum-type",
+			Usage: "Checksum of multipart uploads: full-object (a checksum of the whole object, only for CRC algorithms)" +
+				" or composite (a checksum of checksums of parts) (default: full-object for CRC algorithms, composite for SHA)",
+		},
+
 		cli.StringFlag{
 			Name:  "list-type",
 			Usage: "Listing type to use: ext-v1 (yandex only), 2 or 1 (default: ext-v1 for yandex, 1 for others)",
@@ -297,6 +362,24 @@ MISC OPTIONS:
 			Usage: "Enable subdomain mode of S3",
 		},
 
+		cli.BoolFlag{
+			Name: "transfer-acceleration",
+			Usage: "Use the S3 Transfer Acceleration endpoint of AWS S3 for faster transfers over long distances." +
+				" Falls back to the regular endpoint at mount time if acceleration isn't enabled for the bucket.",
+		},
+
+		cli.BoolFlag{
+			Name: "dualstack",
+			Usage: "Use the dual-stack (IPv4 and IPv6) endpoint of AWS S3." +
+				" Falls back to the regular endpoint at mount time if it doesn't work.",
+		},
+
+		cli.BoolFlag{
+			Name: "no-sign-request",
+			Usage: "Access a public bucket anonymously, without credentials, and mount it read-only." +
+				" It's also done automatically when no credentials are found.",
+		},
+
 		cli.IntFlag{
 			Name:  "sdk-max-retries",
 			Value: 3,
@@ -385,10 +468,45 @@ MISC OPTIONS:
 			Usage: "Do not create and check directory objects (\"dir/\") (default: off)",
 		},
 
+		cli.BoolFlag{
+			Name: "no-list",
+			Usage: "Credentials allow reading objects but not listing the bucket: look files up with HEAD" +
+				" requests and only show known or opened entries in directories. Access errors for missing" +
+				" objects are reported as ENOENT because S3 returns them without the list permission (default: off)",
+		},
+
+		cli.StringFlag{
+			Name: "key-manifest",
+			Usage: "Mount only objects listed in this file, without ever listing the bucket. Each line is a key," +
+				" a size and optionally an ETag and an RFC 3339 mtime, separated with tabs. Implies --no-list",
+		},
+
+		cli.BoolFlag{
+			Name: "single-object",
+			Usage: "Mount bucket:key as a read-only directory containing only this object, for example a huge" +
+				" disk image. Nothing is listed, and small random reads still load --read-ahead chunks" +
+				" unless --read-ahead-small is set. Implies --no-list and -o ro",
+		},
+
+		cli.StringFlag{
+			Name: "inode-map",
+			Usage: "Local file to keep inode numbers in, so that files and directories keep their st_ino" +
+				" across remounts. Only deletions and renames made through this mount are tracked," +
+				" the file is compacted on mount",
+		},
+
+		cli.StringFlag{
+			Name: "dir-markers",
+			Usage: "Also recognize directory markers created by other tools, comma-separated:" +
+				" \"folder\" for Hadoop-style \"dir_$folder$\" objects, \"keep\" for \"dir/.keep\" objects." +
+				" Markers are hidden from listings and removed with their directories (default: none)",
+		},
+
 		cli.IntFlag{
 			Name:  "max-flushers",
 			Value: 16,
-			Usage: "How much parallel requests should be used for flushing changes to server",
+			Usage: "How much parallel requests should be used for flushing changes to server." +
+				" Multiplied by 4 for S3 Express One Zone directory buckets unless set explicitly",
 		},
 
 		cli.IntFlag{
@@ -405,6 +523,35 @@ MISC OPTIONS:
 				" This limit is separate from max-flushers",
 		},
 
+		cli.DurationFlag{
+			Name: "dirty-age-alert",
+			Usage: "Log a warning when a file or directory has unflushed changes for longer than this." +
+				" Age of the oldest unflushed change is exported in /metrics. (default: off)",
+		},
+
+		cli.DurationFlag{
+			Name: "max-dirty-age",
+			Usage: "Flush changes unflushed for longer than this as if fsync was called, even if the file" +
+				" is still open, so that durability lag is bounded (default: off)",
+		},
+
+		cli.IntFlag{
+			Name:  "max-open-files",
+			Usage: "Maximum number of open files in the mount, opening more returns EMFILE (default: unlimited)",
+		},
+
+		cli.IntFlag{
+			Name:  "max-open-files-per-process",
+			Usage: "Maximum number of files opened by one process, opening more returns EMFILE (default: unlimited)",
+		},
+
+		cli.DurationFlag{
+			Name:  "handle-leak-age",
+			Value: 72 * time.Hour,
+			Usage: "Report files open for this long without reads or writes as suspected handle leaks in the log." +
+				" All open files with their processes are also returned by GET /handles on the --pprof port. 0 disables reporting.",
+		},
+
 		cli.IntFlag{
 			Name:  "read-ahead",
 			Value: 5 * 1024,
@@ -454,6 +601,26 @@ MISC OPTIONS:
 				" if they're at most this number of KB away",
 		},
 
+		cli.IntFlag{
+			Name: "host-read-bandwidth",
+			Usage: "Share this read bandwidth in MB/s between all geesefs processes on the host started with" +
+				" this option, in proportion to their --bandwidth-weight. Processes which don't read at the" +
+				" moment don't take their share. One of them arbitrates the rate through --bandwidth-socket.",
+		},
+
+		cli.StringFlag{
+			Name:  "bandwidth-socket",
+			Value: "/tmp/geesefs-bandwidth.sock",
+			Usage: "Unix socket of the --host-read-bandwidth arbiter, the same for all processes on the host.",
+		},
+
+		cli.IntFlag{
+			Name:  "bandwidth-weight",
+			Value: 1,
+			Usage: "Share of --host-read-bandwidth of this mount relative to other mounts reading at the same time." +
+				" For example, give a latency-sensitive mount 10 and a bulk archive mount 1.",
+		},
+
 		cli.IntFlag{
 			Name:  "single-part",
 			Value: 5,
@@ -461,6 +628,14 @@ MISC OPTIONS:
 				" Can't be less than 5 MB",
 		},
 
+		cli.BoolFlag{
+			Name: "probe-tuning",
+			Usage: "Measure latency and throughput to the storage on mount with a few requests" +
+				" to temporary objects and derive --part-sizes, --read-ahead and --max-parallel-parts" +
+				" from them, unless they're set explicitly. --read-ahead and --max-parallel-parts" +
+				" are only increased",
+		},
+
 		cli.StringFlag{
 			Name:  "part-sizes",
 			Value: "5:1000,25:1000,125",
@@ -510,6 +685,63 @@ MISC OPTIONS:
 				" Only works correctly if your S3 returns UserMetadata in listings (default: off)",
 		},
 
+		cli.BoolFlag{
+			Name: "dir-meta-file",
+			Usage: "Store mode, user and group ID of directories, and xattrs of directories with --no-dir-object," +
+				" in a .geesefs_meta JSON object in their parent directory instead of directory objects, so that" +
+				" they are kept for implicit directories. Metadata of symlinks and special files is also repeated" +
+				" there to get it without HEAD requests. Concurrent changes from several mounts are merged, the last" +
+				" change of each entry wins. On stores without conditional PUTs (Ceph RGW, older MinIO) changes are" +
+				" serialized with a .geesefs_meta.lock object instead. Changes are applied when the parent directory" +
+				" is listed. Requires --enable-perms",
+		},
+
+		cli.BoolFlag{
+			Name: "dir-meta-specials",
+			Usage: "Store special files created with mknod (named pipes, sockets, devices) only as entries of" +
+				" .geesefs_meta instead of objects. Named pipes are local kernel pipes, so they only connect processes" +
+				" on the same host. Other mounts see new special files when they list the directory. Requires --dir-meta-file",
+		},
+
+		cli.BoolFlag{
+			Name: "dir-meta-migrate",
+			Usage: "Rewrite .geesefs_meta and .geesefs_dirmeta objects of older formats in background as soon as they're" +
+				" loaded, instead of on their next change. Requires --dir-meta-file",
+		},
+
+		cli.BoolFlag{
+			Name: "meta-readonly",
+			Usage: "Allow data writes, but never change .geesefs_meta objects, for example while a maintenance job" +
+				" rewrites them. Changes which would have to be stored there, like chmod and chown of directories," +
+				" fail with EROFS. Requires --dir-meta-file",
+		},
+
+		cli.BoolFlag{
+			Name: "time-travel",
+			Usage: "Show every directory as it was at the given time in its hidden read-only .geesefs/@<time>" +
+				" subdirectory. <time> is RFC 3339, local YYYY-MM-DD[THH:MM:SS] or Unix time. Views are resolved" +
+				" with a listing of all object versions under the directory. Requires a versioned S3 bucket (default: off)",
+		},
+
+		cli.BoolFlag{
+			Name: "clones",
+			Usage: "Show directories created with `geesefs clone` as their snapshots, read-only or copy-on-write." +
+				" Costs one extra GET request per directory (default: off)",
+		},
+
+		cli.BoolFlag{
+			Name: "s3-select",
+			Usage: "Show the result of an S3 Select SQL query on a CSV, JSON or Parquet file as" +
+				" .geesefs_select/<file>/<query> in its directory, so that only matching rows are downloaded." +
+				" Slashes in queries are written as %2F (default: off)",
+		},
+
+		cli.IntFlag{
+			Name:  "s3-select-cache",
+			Value: 256,
+			Usage: "Memory in MB to keep S3 Select query results in, per queried file. Larger results fail with EFBIG",
+		},
+
 		cli.BoolFlag{
 			Name: "enable-specials",
 			Usage: "Enable special file support (sockets, devices, named pipes)." +
@@ -527,6 +759,19 @@ MISC OPTIONS:
 				" Only works correctly if your S3 returns UserMetadata in listings (default: off)",
 		},
 
+		cli.BoolFlag{
+			Name: "enable-btime",
+			Usage: "Store birth time of files created through GeeseFS in object metadata and report it on macOS and Windows." +
+				" Without it, birth time of existing objects is reported as their creation date in S3 (default: off)",
+		},
+
+		cli.BoolFlag{
+			Name: "check-object-lock",
+			Usage: "Check object lock (retention and legal hold) of existing files with a HEAD request" +
+				" before opening them for writing, refuse writes to locked files and report them" +
+				" without write permissions. Only useful for buckets with object lock enabled (default: off)",
+		},
+
 		cli.BoolFlag{
 			Name: "emulate-hardlinks-as-symlinks",
 			Usage: "Emulate hardlinks as symlinks. Useful for mirroring repositories with rsync" +
@@ -568,6 +813,107 @@ MISC OPTIONS:
 			Usage: "File modification time (UNIX time) metadata attribute name",
 		},
 
+		cli.StringFlag{
+			Name:  "btime-attr",
+			Value: "btime",
+			Usage: "File birth time (UNIX time) metadata attribute name",
+		},
+
+		cli.StringFlag{
+			Name:  "atime",
+			Value: "off",
+			Usage: "Access time handling: off (atime is reported equal to ctime), relatime (atime is kept in memory" +
+				" and updated on reads like Linux relatime does) or lazy (like relatime, but atime is also stored" +
+				" in .geesefs_meta of the directory once a day at most, in batches, requires --dir-meta-file)",
+		},
+
+		cli.DurationFlag{
+			Name:  "atime-flush-interval",
+			Value: time.Minute,
+			Usage: "Interval between batches of access time updates for --atime lazy",
+		},
+
+		cli.DurationFlag{
+			Name: "presign-max-ttl",
+			Usage: "Allow to get pre-signed GET URLs of files by reading the virtual 'user.geesefs.presign' or" +
+				" 'user.geesefs.presign?ttl=<seconds or duration>' extended attribute, valid for at most this long." +
+				" The default TTL is 1 hour or this value, whichever is less. Note that URLs are signed with the mount's" +
+				" credentials, so anyone allowed to read xattrs on the mount may share its files. (default: 0, disabled)",
+		},
+
+		cli.StringFlag{
+			Name: "change-journal",
+			Usage: "Record changes made to the bucket by this mount (uploads, copies and deletions, with sequence" +
+				" numbers) in this local file. Sync tools may then query them over HTTP on the --pprof port:" +
+				" GET /changes?since=<seq>&limit=<n> returns events as JSON lines. (default: off)",
+		},
+
+		cli.IntFlag{
+			Name:  "change-journal-size",
+			Value: 64,
+			Usage: "Maximum size of the change journal file in MB. A full journal is rotated to <path>.1, so up to 2x this size is kept.",
+		},
+
+		cli.StringFlag{
+			Name: "usage-snapshot",
+			Usage: "Periodically list the whole bucket (or the mounted prefix) and append object counts and byte" +
+				" totals per prefix to this local file as JSON lines. The last snapshot is also exported in" +
+				" /metrics on the --pprof port. (default: off)",
+		},
+
+		cli.DurationFlag{
+			Name:  "usage-snapshot-interval",
+			Value: 24 * time.Hour,
+			Usage: "Interval between usage snapshots",
+		},
+
+		cli.IntFlag{
+			Name:  "usage-snapshot-depth",
+			Value: 1,
+			Usage: "Number of directory levels to aggregate usage snapshots by. Objects in shallower directories are counted in their own directory.",
+		},
+
+		cli.StringFlag{
+			Name: "content-hash",
+			Usage: "Store md5 or sha1 of the full file content in user metadata on upload, so that" +
+				" checksum comparisons (rclone and similar tools) work even for multipart objects" +
+				" whose ETag isn't an MD5. md5 is stored base64-encoded like rclone's md5chksum, sha1 is hex-encoded." +
+				" The hash is only known for files written sequentially from the beginning. If a multipart" +
+				" upload starts before the file is completely written, the hash is added with an extra COPY" +
+				" request after the upload. (default: off)",
+		},
+
+		cli.StringFlag{
+			Name:  "content-hash-attr",
+			Usage: "Metadata attribute name for --content-hash (default: md5chksum for md5, sha1 for sha1)",
+		},
+
+		cli.BoolFlag{
+			Name: "writer-stamp",
+			Usage: "Stamp uploaded objects with x-amz-meta-geesefs-writer: <mount name>:<mount UUID>:<pid>" +
+				" to trace which mount wrote them. The last writer of a file is shown in the user.geesefs.writer xattr.",
+		},
+
+		cli.StringFlag{
+			Name:  "mount-name",
+			Usage: "Name of the mount in --writer-stamp (default: host name)",
+		},
+
+		cli.StringSliceFlag{
+			Name: "checksum-manifest",
+			Usage: "Validate reads against checksums from this local manifest file in md5sum/sha1sum/sha256sum" +
+				" format (\"<hex> <path>\", paths relative to the mount root). Files read sequentially from" +
+				" the beginning to the end are hashed on the fly and compared with the manifest. Result is" +
+				" returned in the user.geesefs.checksum xattr (\"ok\" or \"mismatch\") and counted in" +
+				" /metrics. May be repeated. (default: off)",
+		},
+
+		cli.Float64Flag{
+			Name:  "checksum-sample",
+			Value: 1,
+			Usage: "Fraction of opened files listed in --checksum-manifest to validate, from 0 to 1",
+		},
+
 		cli.StringFlag{
 			Name:  "symlink-attr",
 			Value: "--symlink-target",
@@ -575,6 +921,20 @@ MISC OPTIONS:
 				" Only works correctly if your S3 returns UserMetadata in listings",
 		},
 
+		cli.StringFlag{
+			Name: "symlink-root-attr",
+			Usage: "Also store absolute symbolic link targets pointing inside the mountpoint relative to the" +
+				" bucket root in this metadata attribute, and translate them to the current mountpoint and" +
+				" prefix when reading links, so that they keep working when the bucket is mounted elsewhere" +
+				" or with another prefix (default: off)",
+		},
+
+		cli.IntFlag{
+			Name: "max-symlinks-per-dir",
+			Usage: "Fail symlink() with EDQUOT when a directory already contains this many symbolic links," +
+				" to protect against runaway scripts (default: 0, unlimited)",
+		},
+
 		cli.StringFlag{
 			Name:  "refresh-attr",
 			Value: ".invalidate",
@@ -588,12 +948,47 @@ MISC OPTIONS:
 			Usage: "How long to cache file metadata.",
 		},
 
+		cli.BoolFlag{
+			Name: "honor-cache-control",
+			Usage: "Honor Cache-Control headers returned by HEAD and GET requests. max-age overrides --stat-cache-ttl" +
+				" for the object, no-cache disables metadata caching for it, and no-store also drops its cached data" +
+				" when the file is closed. Useful when the backend is an HTTP gateway to a data service rather than S3.",
+		},
+
 		cli.DurationFlag{
 			Name:  "http-timeout",
 			Value: 30 * time.Second,
 			Usage: "Set the timeout on HTTP requests to S3",
 		},
 
+		cli.DurationFlag{
+			Name: "response-timeout",
+			Usage: "Fail HTTP requests which get no response headers in this time after sending the request." +
+				" Unlike --http-timeout, it doesn't limit transfers of large bodies (default: off)",
+		},
+
+		cli.IntFlag{
+			Name:  "max-idle-conns-per-host",
+			Value: 1000,
+			Usage: "Keep at most this many idle HTTP connections to each host. Connections of requests beyond" +
+				" this number are closed after use, which is reported as pool saturation.",
+		},
+
+		cli.IntFlag{
+			Name:  "max-conns-per-host",
+			Usage: "Open at most this many HTTP connections to each host, other requests wait for them (default: unlimited)",
+		},
+
+		cli.IntFlag{
+			Name:  "tls-session-cache",
+			Usage: "Remember this many TLS sessions to resume them in new connections without a full handshake (default: off)",
+		},
+
+		cli.BoolFlag{
+			Name:  "http2",
+			Usage: "Use HTTP/2 with servers that support it. Requests are multiplexed over fewer connections.",
+		},
+
 		cli.DurationFlag{
 			Name:  "retry-interval",
 			Value: 30 * time.Second,
@@ -624,11 +1019,103 @@ MISC OPTIONS:
 			Usage: "Maximum read retry attempts (minimum: 1)",
 		},
 
+		cli.BoolFlag{
+			Name: "pin-etag",
+			Usage: "Read objects with If-Match on the ETag they had when the file was opened. When the object" +
+				" is replaced remotely, reads from handles opened before that fail with ESTALE instead of" +
+				" returning a mix of old and new data.",
+		},
+
+		cli.StringFlag{
+			Name:  "stale-handle",
+			Value: "estale",
+			Usage: "What reads from --pin-etag handles of replaced objects do: estale (fail with ESTALE once, then" +
+				" read the new version) or version (keep reading the opened version, requires a versioned bucket).",
+		},
+
 		cli.IntFlag{
 			Name:  "max-disk-cache-fd",
 			Value: 512,
 			Usage: "Simultaneously opened cache file descriptor limit",
 		},
+
+		cli.BoolFlag{
+			Name: "spill-dirty",
+			Usage: "When the memory limit is reached, write dirty buffers to the --cache directory" +
+				" instead of flushing files early, and upload them from there. Spilled buffers are" +
+				" checksummed and a part is not uploaded if its data is corrupted on disk",
+		},
+
+		cli.StringSliceFlag{
+			Name: "cache-policy",
+			Usage: "Cache eviction policy in the form <pattern>:<age>, for example 'raw/**:1m' or '**/*.cal:168h'." +
+				" Clean data of files matching <pattern> is evicted from memory and from the disk cache" +
+				" after not being read or written for <age>. Under memory pressure, data of such files is only evicted" +
+				" after data of other files. <pattern> is matched against the path relative to the mount root," +
+				" '*' doesn't match '/', '**' matches anything. The first matching policy is used. May be repeated.",
+		},
+
+		cli.StringSliceFlag{
+			Name: "flush-policy",
+			Usage: "Flush timing policy in the form <pattern>:<delay>, for example '**/*.done:0' or '**/*.log:30s'." +
+				" Changes of files matching <pattern> with zero delay are flushed immediately, even while the file" +
+				" is open. With a non-zero delay, changes are held back for up to <delay> after the first unflushed" +
+				" write to coalesce them, even after the file is closed, unless fsync is called or memory is low." +
+				" <pattern> syntax is the same as in --cache-policy. The first matching policy is used. May be repeated.",
+		},
+
+		cli.StringFlag{
+			Name:  "temp-prefix",
+			Value: ".geesefs_tmp/",
+			Usage: "Key prefix, relative to the mount root, for internal temporary objects." +
+				" Objects under this prefix are hidden from listings and lookups, a warning is logged" +
+				" on mount if there are objects not created by GeeseFS.",
+		},
+
+		cli.DurationFlag{
+			Name: "temp-cleanup-age",
+			Usage: "Remove temporary objects older than this on mount, for example 24h. They are left over by crashed mounts." +
+				" Disabled by default.",
+		},
+
+		cli.BoolFlag{
+			Name: "rename-journal",
+			Usage: "Store a journal object under --temp-prefix for every directory rename until all its objects" +
+				" are moved, so that renames interrupted by a crash can be finished or rolled back with" +
+				" geesefs repair-renames. Interrupted renames are reported on mount.",
+		},
+
+		cli.BoolFlag{
+			Name: "invalidation-log",
+			Usage: "Share changes with other mounts of the bucket through a log under --temp-prefix, so that" +
+				" their caches are invalidated in near real time instead of after --stat-cache-ttl.",
+		},
+
+		cli.StringFlag{
+			Name: "sqs-queue",
+			Usage: "URL of an SQS queue with S3 event notifications (s3:ObjectCreated:*, s3:ObjectRemoved:*) of" +
+				" the bucket, directly or through SNS, to invalidate caches of changed objects. Every mount needs" +
+				" its own queue, messages are removed after they are applied.",
+		},
+
+		cli.DurationFlag{
+			Name:  "invalidation-poll",
+			Value: time.Second,
+			Usage: "How often to append changes to the --invalidation-log and check changes of other mounts.",
+		},
+
+		cli.StringFlag{
+			Name: "write-intent",
+			Usage: "Write a short-lived marker under --temp-prefix before flushing a modified file to detect" +
+				" other mounts writing the same object at the same time. warn: log the conflict," +
+				" ebusy: postpone the flush and return EBUSY from fsync until the other write completes.",
+		},
+
+		cli.DurationFlag{
+			Name:  "write-intent-ttl",
+			Value: time.Minute,
+			Usage: "Markers of --write-intent older than this are ignored. Should be longer than the flush of the largest file.",
+		},
 	}
 
 	if runtime.GOOS == "windows" {
@@ -665,11 +1152,34 @@ MISC OPTIONS:
 		},
 
 		cli.StringFlag{
-			Name:  "pprof",
-			Usage: "Specify port or host:port to enable pprof HTTP profiler on that port.",
+			Name: "pprof",
+			Usage: "Specify port, host:port or unix:/path/to/socket to enable pprof HTTP profiler" +
+				" and geesefs HTTP endpoints (/metrics, /changes, /handles, /prefill, /cache) there.",
 			Value: "",
 		},
 
+		cli.StringFlag{
+			Name: "http-auth",
+			Usage: "Require authentication on the --pprof listener. Each line of the file grants a role to a" +
+				" bearer token or, for unix sockets, a peer uid: \"read token:<secret>\", \"admin uid:0\"." +
+				" read allows statistics, admin also allows /prefill, /cache, /changes and /handles",
+		},
+
+		cli.BoolFlag{
+			Name: "serve-cache",
+			Usage: "Serve cached file contents to other geesefs nodes prefilling their cache from this one" +
+				" (/cache on the --pprof port). Without --http-auth, anyone who can reach the port can read" +
+				" cached files, with it the admin role is required.",
+		},
+
+		cli.StringFlag{
+			Name: "admin-grpc",
+			Usage: "Specify port, host:port or unix:/path/to/socket to serve the Admin gRPC service" +
+				" (core/pb/admin.proto) there: symlinks, xattrs, cache pins and invalidation." +
+				" With --http-auth, requests are authenticated like on the --pprof listener and changes" +
+				" need the admin role. Without it, only unix sockets accessible by the owner are allowed.",
+		},
+
 		cli.BoolFlag{
 			Name:  "f",
 			Usage: "Run geesefs in foreground.",
@@ -687,10 +1197,40 @@ MISC OPTIONS:
 			Usage: "I/O statistics printing interval. Set to 0 to disable.",
 		},
 
+		cli.StringSliceFlag{
+			Name: "slo",
+			Usage: "Latency SLO in the form <op>:p<quantile><<duration>, for example getattr:p99<50ms." +
+				" A warning is logged when it's violated. May be specified multiple times.",
+		},
+
+		cli.DurationFlag{
+			Name:  "slo-window",
+			Value: time.Minute,
+			Usage: "Time window for checking latency SLOs.",
+		},
+
 		cli.BoolFlag{
 			Name:  "debug_grpc",
 			Usage: "Enable grpc logging in cluster mode.",
 		},
+
+		cli.BoolFlag{
+			Name: "trace-ops",
+			Usage: "Log a generated ID for every FUSE operation and the S3 request IDs" +
+				" (x-amz-request-id, x-amz-id-2) of backend requests related to it.",
+		},
+
+		cli.StringFlag{
+			Name: "error-log",
+			Usage: "Keep recent errors of FUSE operations and S3 requests in this file, one JSON object" +
+				" per line (time, op, path, code, status, request_id, error), for monitoring agents to tail.",
+		},
+
+		cli.IntFlag{
+			Name:  "error-log-size",
+			Value: 1000,
+			Usage: "Number of recent entries to keep in --error-log.",
+		},
 	}
 
 	clusterFlags := []cli.Flag{
@@ -726,6 +1266,11 @@ MISC OPTIONS:
 				Name:  "help, h",
 				Usage: "Print this help text and exit successfully.",
 			},
+			cli.BoolFlag{
+				Name: "bulk",
+				Usage: "Run a bulk command instead of mounting: cp, mv, rm, snapshot, clone, repair-renames" +
+					" or attest. Without it, the first argument is always the bucket, even if it's named like a command.",
+			},
 		}, fsFlags...), s3Flags...), tuningFlags...), debugFlags...), clusterFlags...),
 	}
 
@@ -737,6 +1282,7 @@ MISC OPTIONS:
 	flagCategories = map[string]string{}
 	flagCategories["help"] = "misc"
 	flagCategories["h"] = "misc"
+	flagCategories["bulk"] = "misc"
 
 	for _, f := range s3Flags {
 		for _, n := range strings.Split(f.GetName(), ",") {
@@ -803,6 +1349,184 @@ func parsePartSizes(s string) (result []PartSizeConfig) {
 	return
 }
 
+func parseSLO(s string) SLOConfig {
+	colon := strings.Index(s, ":")
+	lt := strings.Index(s, "<")
+	if colon <= 0 || lt < colon || len(s) < colon+2 || s[colon+1] != 'p' {
+		panic("Incorrect syntax for --slo, should be: <op>:p<quantile><<duration>")
+	}
+	q, err := strconv.ParseFloat(s[colon+2:lt], 64)
+	if err != nil || q <= 0 || q > 100 {
+		panic("Incorrect quantile in --slo " + s)
+	}
+	max, err := time.ParseDuration(s[lt+1:])
+	if err != nil {
+		panic("Incorrect duration in --slo " + s)
+	}
+	return SLOConfig{
+		Op:       s[0:colon],
+		Quantile: q / 100,
+		Max:      max,
+	}
+}
+
+func parseFlushPolicy(s string) FlushPolicy {
+	colon := strings.LastIndex(s, ":")
+	if colon <= 0 {
+		panic("Incorrect syntax for --flush-policy, should be: <pattern>:<delay>")
+	}
+	delay, err := time.ParseDuration(s[colon+1:])
+	if err != nil || delay < 0 {
+		panic("Incorrect delay in --flush-policy " + s)
+	}
+	return NewFlushPolicy(s[0:colon], delay)
+}
+
+func parsePrefixCredentials(s string) PrefixCredentials {
+	colon := strings.Index(s, ":")
+	if colon <= 0 || colon == len(s)-1 {
+		panic("Incorrect syntax for --prefix-credentials, should be: <path>:<profile or role ARN>")
+	}
+	pc := PrefixCredentials{Path: strings.Trim(s[0:colon], "/")}
+	if pc.Path == "" {
+		panic("Incorrect path in --prefix-credentials " + s)
+	}
+	if strings.HasPrefix(s[colon+1:], "arn:") {
+		pc.RoleArn = s[colon+1:]
+	} else {
+		pc.Profile = s[colon+1:]
+	}
+	return pc
+}
+
+func parseBucketMount(s string) BucketMount {
+	eq := strings.Index(s, "=")
+	if eq <= 0 || eq == len(s)-1 {
+		panic("Incorrect syntax for --mount-bucket, should be: <path>=<bucket>[:<prefix>][,<profile or role ARN>]")
+	}
+	bm := BucketMount{Path: strings.Trim(s[0:eq], "/")}
+	if bm.Path == "" {
+		panic("Incorrect path in --mount-bucket " + s)
+	}
+	bucket := s[eq+1:]
+	if comma := strings.Index(bucket, ","); comma >= 0 {
+		creds := bucket[comma+1:]
+		bucket = bucket[0:comma]
+		if strings.HasPrefix(creds, "arn:") {
+			bm.RoleArn = creds
+		} else {
+			bm.Profile = creds
+		}
+	}
+	if colon := strings.Index(bucket, ":"); colon >= 0 {
+		bm.Prefix = strings.Trim(bucket[colon+1:], "/")
+		if bm.Prefix != "" {
+			bm.Prefix += "/"
+		}
+		bucket = bucket[0:colon]
+	}
+	if bucket == "" {
+		panic("Incorrect bucket in --mount-bucket " + s)
+	}
+	bm.Bucket = bucket
+	return bm
+}
+
+// parseChecksum returns S3 names of the checksum algorithm and type
+func parseChecksum(algorithm, checksumType string) (string, string) {
+	algorithm = strings.ToUpper(algorithm)
+	switch algorithm {
+	case "":
+		if checksumType != "" {
+			panic("--checksum-type requires --checksum-algorithm")
+		}
+		return "", ""
+	case "CRC32", "CRC32C", "CRC64NVME":
+		if checksumType == "" {
+			checksumType = "full-object"
+		}
+	case "SHA1", "SHA256":
+		if checksumType == "" {
+			checksumType = "composite"
+		}
+	default:
+		panic("Unknown --checksum-algorithm: " + algorithm)
+	}
+	switch checksumType {
+	case "full-object":
+		if strings.HasPrefix(algorithm, "SHA") {
+			panic("SHA checksums can't be full-object, use --checksum-type composite")
+		}
+		return algorithm, "FULL_OBJECT"
+	case "composite":
+		if algorithm == "CRC64NVME" {
+			panic("CRC64NVME checksums can't be composite, use --checksum-type full-object")
+		}
+		return algorithm, "COMPOSITE"
+	}
+	panic("Unknown --checksum-type, should be full-object or composite: " + checksumType)
+}
+
+func parseReadReplica(s string) ReadReplica {
+	opts := strings.Split(s, ",")
+	if opts[0] == "" {
+		panic("Incorrect syntax for --read-replica, should be: <bucket>[,region=<region>][,endpoint=<url>]")
+	}
+	replica := ReadReplica{Bucket: opts[0]}
+	for _, opt := range opts[1:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			panic("Incorrect option in --read-replica " + s + ": " + opt)
+		}
+		switch key {
+		case "region":
+			replica.Region = value
+		case "endpoint":
+			replica.Endpoint = value
+		default:
+			panic("Unknown option in --read-replica " + s + ": " + key)
+		}
+	}
+	return replica
+}
+
+func parseRoutingRule(s string) RoutingRule {
+	colon := strings.Index(s, ":")
+	if colon <= 0 || colon == len(s)-1 {
+		panic("Incorrect syntax for --route, should be: <pattern>:<key>=<value>[,<key>=<value>...]")
+	}
+	rule := NewRoutingRule(s[0:colon])
+	for _, opt := range strings.Split(s[colon+1:], ",") {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			panic("Incorrect option in --route " + s + ": " + opt)
+		}
+		switch key {
+		case "bucket":
+			rule.Bucket = value
+		case "endpoint":
+			rule.Endpoint = value
+		case "storage-class":
+			rule.StorageClass = value
+		default:
+			panic("Unknown option in --route " + s + ": " + key)
+		}
+	}
+	return rule
+}
+
+func parseCachePolicy(s string) CachePolicy {
+	colon := strings.LastIndex(s, ":")
+	if colon <= 0 {
+		panic("Incorrect syntax for --cache-policy, should be: <pattern>:<age>")
+	}
+	age, err := time.ParseDuration(s[colon+1:])
+	if err != nil || age < 0 {
+		panic("Incorrect age in --cache-policy " + s)
+	}
+	return NewCachePolicy(s[0:colon], age)
+}
+
 func parseNode(s string) *NodeConfig {
 	parts := strings.SplitN(s, ":", 2)
 	if len(parts) != 2 {
@@ -820,6 +1544,40 @@ func parseNode(s string) *NodeConfig {
 
 // PopulateFlags adds the flags accepted by run to the supplied flag set, returning the
 // variables into which the flags will parse.
+func parseAllowUsers(s string) (uids []uint32) {
+	if s == "" {
+		return nil
+	}
+	for _, u := range strings.Split(s, ",") {
+		uid, err := strconv.ParseUint(strings.TrimSpace(u), 10, 32)
+		if err != nil {
+			panic("Incorrect --allow-users, should be a comma-separated list of UIDs: " + s)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return
+}
+
+var mountMethodNames = map[string][]string{
+	"linux":  {"direct", "fusermount3", "fusermount"},
+	"darwin": {"macfuse", "fuse-t"},
+}
+
+func parseMountMethods(s string) (methods []string) {
+	if s == "" {
+		return nil
+	}
+	for _, m := range strings.Split(s, ",") {
+		m = strings.TrimSpace(m)
+		if !slices.Contains(mountMethodNames[runtime.GOOS], m) {
+			panic("Unknown mount method in --mount-methods: " + m + ", supported on " + runtime.GOOS +
+				": " + strings.Join(mountMethodNames[runtime.GOOS], ", "))
+		}
+		methods = append(methods, m)
+	}
+	return
+}
+
 func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 	singlePart := c.Int("single-part")
 	if singlePart < 5 {
@@ -840,6 +1598,8 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		Gid:                                uint32(c.Int("gid")),
 		Setuid:                             c.Int("setuid"),
 		Setgid:                             c.Int("setgid"),
+		AllowUsers:                         parseAllowUsers(c.String("allow-users")),
+		MountMethods:                       parseMountMethods(c.String("mount-methods")),
 		WinRefreshDirs:                     c.Bool("refresh-dirs"),
 		IgnoreSettingAttrsForRootDirErrors: c.Bool("ignore-setting-attrs-for-root-dir-erros"),
 
@@ -851,16 +1611,39 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		Cheap:               c.Bool("cheap"),
 		ExplicitDir:         c.Bool("no-implicit-dir"),
 		NoDirObject:         c.Bool("no-dir-object"),
+		NoList:              c.Bool("no-list") || c.IsSet("key-manifest") || c.Bool("single-object"),
+		KeyManifest:         c.String("key-manifest"),
+		InodeMap:            c.String("inode-map"),
+		SingleObject:        c.Bool("single-object"),
 		MaxFlushers:         int64(c.Int("max-flushers")),
 		MaxParallelParts:    c.Int("max-parallel-parts"),
 		MaxParallelCopy:     c.Int("max-parallel-copy"),
+		MaxOpenFiles:        c.Int("max-open-files"),
+		MaxOpenFilesPerPid:  c.Int("max-open-files-per-process"),
+		HandleLeakAge:       c.Duration("handle-leak-age"),
+		DirtyAgeAlert:       c.Duration("dirty-age-alert"),
+		MaxDirtyAge:         c.Duration("max-dirty-age"),
 		StatCacheTTL:        c.Duration("stat-cache-ttl"),
+		HonorCacheControl:   c.Bool("honor-cache-control"),
+		PresignMaxTTL:       c.Duration("presign-max-ttl"),
+		ChangeJournal:       c.String("change-journal"),
+		ChangeJournalMB:     uint64(c.Int("change-journal-size")),
+		UsageSnapshot:       c.String("usage-snapshot"),
+		UsageInterval:       c.Duration("usage-snapshot-interval"),
+		UsageDepth:          c.Int("usage-snapshot-depth"),
 		HTTPTimeout:         c.Duration("http-timeout"),
+		ResponseTimeout:     c.Duration("response-timeout"),
+		MaxIdleConnsPerHost: c.Int("max-idle-conns-per-host"),
+		MaxConnsPerHost:     c.Int("max-conns-per-host"),
+		TLSSessionCache:     c.Int("tls-session-cache"),
+		HTTP2:               c.Bool("http2"),
 		RetryInterval:       c.Duration("retry-interval"),
 		ReadRetryInterval:   c.Duration("read-retry-interval"),
 		ReadRetryMultiplier: c.Float64("read-retry-mul"),
 		ReadRetryMax:        c.Duration("read-retry-max-interval"),
 		ReadRetryAttempts:   readRetryAttempts,
+		PinETag:             c.Bool("pin-etag"),
+		StaleHandle:         c.String("stale-handle"),
 		ReadAheadKB:         uint64(c.Int("read-ahead")),
 		SmallReadCount:      uint64(c.Int("small-read-count")),
 		SmallReadCutoffKB:   uint64(c.Int("small-read-cutoff")),
@@ -869,13 +1652,26 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		ReadAheadLargeKB:    uint64(c.Int("read-ahead-large")),
 		ReadAheadParallelKB: uint64(c.Int("read-ahead-parallel")),
 		ReadMergeKB:         uint64(c.Int("read-merge")),
+		HostReadBandwidthMB: uint64(c.Int("host-read-bandwidth")),
+		BandwidthSocket:     c.String("bandwidth-socket"),
+		BandwidthWeight:     c.Int("bandwidth-weight"),
 		SinglePartMB:        uint64(singlePart),
 		MaxMergeCopyMB:      uint64(c.Int("max-merge-copy")),
 		IgnoreFsync:         c.Bool("ignore-fsync"),
 		FsyncOnClose:        c.Bool("fsync-on-close"),
 		EnablePerms:         c.Bool("enable-perms"),
+		DirMetaFile:         c.Bool("dir-meta-file"),
+		DirMetaSpecials:     c.Bool("dir-meta-specials"),
+		DirMetaMigrate:      c.Bool("dir-meta-migrate"),
+		MetaReadOnly:        c.Bool("meta-readonly"),
+		TimeTravel:          c.Bool("time-travel"),
+		Clones:              c.Bool("clones"),
+		S3Select:            c.Bool("s3-select"),
+		S3SelectCacheMB:     uint64(c.Int("s3-select-cache")),
 		EnableSpecials:      c.Bool("enable-specials"),
 		EnableMtime:         c.Bool("enable-mtime"),
+		EnableBtime:         c.Bool("enable-btime"),
+		CheckObjectLock:     c.Bool("check-object-lock"),
 		EmulateHardlinks:    c.Bool("emulate-hardlinks-as-symlinks"),
 		DisableXattr:        c.Bool("disable-xattr"),
 		UidAttr:             c.String("uid-attr"),
@@ -883,16 +1679,30 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		FileModeAttr:        c.String("mode-attr"),
 		RdevAttr:            c.String("rdev-attr"),
 		MtimeAttr:           c.String("mtime-attr"),
+		BtimeAttr:           c.String("btime-attr"),
+		Atime:               strings.ToLower(c.String("atime")),
+		AtimeFlushInterval:  c.Duration("atime-flush-interval"),
 		SymlinkAttr:         c.String("symlink-attr"),
+		SymlinkRootAttr:     c.String("symlink-root-attr"),
+		MaxSymlinksPerDir:   c.Int("max-symlinks-per-dir"),
 		RefreshAttr:         c.String("refresh-attr"),
 		CachePath:           c.String("cache"),
 		MaxDiskCacheFD:      int64(c.Int("max-disk-cache-fd")),
 		CacheFileMode:       os.FileMode(c.Int("cache-file-mode")),
+		SpillDirty:          c.Bool("spill-dirty"),
 		UsePatch:            c.Bool("enable-patch"),
 		DropPatchConflicts:  c.Bool("drop-patch-conflicts"),
 		PreferPatchUploads:  c.Bool("prefer-patch-uploads"),
 		NoPreloadDir:        c.Bool("no-preload-dir"),
 		NoVerifySSL:         c.Bool("no-verify-ssl"),
+		TempPrefix:          strings.TrimLeft(c.String("temp-prefix"), "/"),
+		TempCleanupAge:      c.Duration("temp-cleanup-age"),
+		RenameJournal:       c.Bool("rename-journal"),
+		InvalidationLog:     c.Bool("invalidation-log"),
+		InvalidationPoll:    c.Duration("invalidation-poll"),
+		WriteIntent:         c.String("write-intent"),
+		WriteIntentTTL:      c.Duration("write-intent-ttl"),
+		SQSQueue:            c.String("sqs-queue"),
 
 		// Common Backend Config
 		Endpoint:       c.String("endpoint"),
@@ -906,7 +1716,13 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		LogFile:       c.String("log-file"),
 		StatsInterval: c.Duration("print-stats"),
 		PProf:         c.String("pprof"),
+		HTTPAuth:      c.String("http-auth"),
 		DebugGrpc:     c.Bool("debug_grpc"),
+		TraceOps:      c.Bool("trace-ops"),
+		ErrorLog:      c.String("error-log"),
+		ErrorLogSize:  c.Int("error-log-size"),
+		ServeCache:    c.Bool("serve-cache"),
+		AdminGrpc:     c.String("admin-grpc"),
 
 		// Cluster Mode
 		ClusterMode:           c.Bool("cluster"),
@@ -919,8 +1735,131 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 	}
 
 	flags.PartSizes = parsePartSizes(c.String("part-sizes"))
+	flags.ProbeTuning = c.Bool("probe-tuning")
+	// Explicitly set options are not changed by the probe
+	flags.PartSizesSet = c.IsSet("part-sizes")
+	flags.ReadAheadSet = c.IsSet("read-ahead")
+	flags.MaxPartsSet = c.IsSet("max-parallel-parts")
+	flags.MaxFlushersSet = c.IsSet("max-flushers")
+
+	flags.ContentHash = strings.ToLower(c.String("content-hash"))
+	flags.ContentHashAttr = c.String("content-hash-attr")
+	flags.WriterStamp = c.Bool("writer-stamp")
+	flags.MountName = c.String("mount-name")
+	switch flags.ContentHash {
+	case "":
+	case "md5":
+		if flags.ContentHashAttr == "" {
+			flags.ContentHashAttr = "md5chksum"
+		}
+	case "sha1":
+		if flags.ContentHashAttr == "" {
+			flags.ContentHashAttr = "sha1"
+		}
+	default:
+		panic("Incorrect --content-hash, should be md5 or sha1: " + flags.ContentHash)
+	}
+	switch flags.Atime {
+	case "off", "relatime":
+	case "lazy":
+		if !flags.DirMetaFile {
+			panic("--atime lazy requires --dir-meta-file")
+		}
+	default:
+		panic("Incorrect --atime, should be off, relatime or lazy: " + flags.Atime)
+	}
+	flags.ChecksumManifests = c.StringSlice("checksum-manifest")
+	flags.ChecksumSample = c.Float64("checksum-sample")
+	if flags.ChecksumSample < 0 || flags.ChecksumSample > 1 {
+		panic("Incorrect --checksum-sample, should be from 0 to 1: " + c.String("checksum-sample"))
+	}
+	for _, policy := range c.StringSlice("cache-policy") {
+		flags.CachePolicies = append(flags.CachePolicies, parseCachePolicy(policy))
+	}
+	for _, policy := range c.StringSlice("flush-policy") {
+		flags.FlushPolicies = append(flags.FlushPolicies, parseFlushPolicy(policy))
+	}
+	for _, pc := range c.StringSlice("prefix-credentials") {
+		flags.PrefixCredentials = append(flags.PrefixCredentials, parsePrefixCredentials(pc))
+	}
+	for _, bm := range c.StringSlice("mount-bucket") {
+		flags.BucketMounts = append(flags.BucketMounts, parseBucketMount(bm))
+	}
+	for _, route := range c.StringSlice("route") {
+		flags.Routes = append(flags.Routes, parseRoutingRule(route))
+	}
+	for _, replica := range c.StringSlice("read-replica") {
+		flags.ReadReplicas = append(flags.ReadReplicas, parseReadReplica(replica))
+	}
+	if flags.SpillDirty && flags.CachePath == "" {
+		panic("--spill-dirty requires --cache")
+	}
+	if flags.DirMetaFile && !flags.EnablePerms {
+		panic("--dir-meta-file requires --enable-perms")
+	}
+	if flags.DirMetaSpecials && !flags.DirMetaFile {
+		panic("--dir-meta-specials requires --dir-meta-file")
+	}
+	if flags.DirMetaMigrate && !flags.DirMetaFile {
+		panic("--dir-meta-migrate requires --dir-meta-file")
+	}
+	if flags.MetaReadOnly && !flags.DirMetaFile {
+		panic("--meta-readonly requires --dir-meta-file")
+	}
+	if flags.RenameJournal && flags.TempPrefix == "" {
+		panic("--rename-journal requires --temp-prefix")
+	}
+	if flags.InvalidationLog && flags.TempPrefix == "" {
+		panic("--invalidation-log requires --temp-prefix")
+	}
+	if flags.WriteIntent != "" && flags.WriteIntent != "warn" && flags.WriteIntent != "ebusy" {
+		panic("Incorrect --write-intent, should be warn or ebusy: " + flags.WriteIntent)
+	}
+	if flags.WriteIntent != "" && flags.TempPrefix == "" {
+		panic("--write-intent requires --temp-prefix")
+	}
+	if flags.StaleHandle != "estale" && flags.StaleHandle != "version" {
+		panic("Incorrect --stale-handle, should be estale or version: " + flags.StaleHandle)
+	}
+	if flags.MaxIdleConnsPerHost < 1 {
+		panic("--max-idle-conns-per-host must be at least 1")
+	}
+	if flags.MaxConnsPerHost < 0 || flags.TLSSessionCache < 0 {
+		panic("--max-conns-per-host and --tls-session-cache can't be negative")
+	}
+	if flags.ErrorLog != "" && flags.ErrorLogSize < 1 {
+		panic("--error-log-size must be at least 1")
+	}
+	if flags.HostReadBandwidthMB > 0 && flags.BandwidthWeight < 1 {
+		panic("--bandwidth-weight must be at least 1")
+	}
+	if flags.SingleObject {
+		flags.MountOptions = append(flags.MountOptions, "ro")
+		// Disk images and HDF5 files are read randomly in small blocks
+		if !c.IsSet("read-ahead-small") {
+			flags.ReadAheadSmallKB = flags.ReadAheadKB
+		}
+	}
+	for _, marker := range strings.Split(c.String("dir-markers"), ",") {
+		switch strings.TrimSpace(marker) {
+		case "":
+		case "folder":
+			flags.FolderMarkers = true
+		case "keep":
+			flags.KeepMarkers = true
+		default:
+			panic("Unknown directory marker type in --dir-markers: " + marker)
+		}
+	}
+	for _, slo := range c.StringSlice("slo") {
+		flags.SLOs = append(flags.SLOs, parseSLO(slo))
+	}
+	flags.SLOWindow = c.Duration("slo-window")
 
 	if flags.ClusterMode {
+		if flags.InodeMap != "" {
+			panic("--inode-map can't be used with --cluster")
+		}
 		flags.ClusterMe = parseNode(c.String("cluster-me"))
 
 		for _, peer := range c.StringSlice("cluster-peer") {
@@ -946,7 +1885,11 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		config.SseC = c.String("sse-c")
 		config.ACL = c.String("acl")
 		config.Subdomain = c.Bool("subdomain")
+		config.NoSignRequest = c.Bool("no-sign-request")
+		config.UseAccelerate = c.Bool("transfer-acceleration")
+		config.UseDualStack = c.Bool("dualstack")
 		config.NoChecksum = c.Bool("no-checksum")
+		config.ChecksumAlgorithm, config.ChecksumType = parseChecksum(c.String("checksum-algorithm"), c.String("checksum-type"))
 		config.UseIAM = c.Bool("iam")
 		config.IAMHeader = c.String("iam-header")
 		config.IAMFlavor = c.String("iam-flavor")
@@ -955,6 +1898,13 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		if config.IAMFlavor != "gcp" && config.IAMFlavor != "imdsv1" {
 			panic("Unknown --iam-flavor: " + config.IAMFlavor)
 		}
+		if config.UseAccelerate || config.UseDualStack {
+			if c.IsSet("endpoint") && !strings.Contains(flags.Endpoint, ".amazonaws.com") {
+				panic("--transfer-acceleration and --dualstack are only supported with AWS S3")
+			}
+			// The SDK chooses the endpoint
+			flags.Endpoint = ""
+		}
 		listType := c.String("list-type")
 		isYandex := strings.Contains(flags.Endpoint, "yandex")
 		if isYandex && !c.IsSet("no-specials") {
@@ -1020,6 +1970,8 @@ func PopulateFlags(c *cli.Context) (ret *FlagStorage) {
 		return nil
 	}
 
+	ConfigureHTTP(flags)
+
 	return flags
 }
 
@@ -1080,22 +2032,34 @@ func DefaultFlags() *FlagStorage {
 		ReadAheadLargeKB:    100 * 1024,
 		ReadAheadParallelKB: 20 * 1024,
 		ReadMergeKB:         512,
+		BandwidthSocket:     "/tmp/geesefs-bandwidth.sock",
+		BandwidthWeight:     1,
 		SinglePartMB:        5,
+		S3SelectCacheMB:     256,
 		MaxMergeCopyMB:      0,
 		UidAttr:             "uid",
 		GidAttr:             "gid",
 		FileModeAttr:        "mode",
 		RdevAttr:            "rdev",
 		MtimeAttr:           "mtime",
+		BtimeAttr:           "btime",
+		Atime:               "off",
+		AtimeFlushInterval:  time.Minute,
 		SymlinkAttr:         "--symlink-target",
 		RefreshAttr:         ".invalidate",
 		StatCacheTTL:        30 * time.Second,
 		HTTPTimeout:         30 * time.Second,
+		MaxIdleConnsPerHost: 1000,
 		RetryInterval:       30 * time.Second,
 		ReadRetryAttempts:   10,
 		MaxDiskCacheFD:      512,
 		RefreshFilename:     ".invalidate",
 		FlushFilename:       ".fsyncdir",
+		TempPrefix:          ".geesefs_tmp/",
+		InvalidationPoll:    time.Second,
+		WriteIntentTTL:      time.Minute,
+		StaleHandle:         "estale",
+		ChecksumSample:      1,
 		PartSizes: []PartSizeConfig{
 			{PartSize: 5 * 1024 * 1024, PartCount: 1000},
 			{PartSize: 25 * 1024 * 1024, PartCount: 1000},
@@ -0,0 +1,174 @@
+package cfg
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPPool is the pool of HTTP connections shared by all backends. It sends
+// requests with the shared http.Transport and counts them and connections,
+// so that a pool too small for the load is visible: requests beyond
+// --max-idle-conns-per-host to one host make connections which are closed
+// after use, and requests beyond --max-conns-per-host wait for connections.
+// Such requests are counted as saturated and logged at most once a minute.
+type HTTPPool struct {
+	transport  *http.Transport
+	configured sync.Once
+
+	mu sync.Mutex
+	// Requests in flight by host
+	hosts map[string]int
+	peak  int
+
+	newConns    int64
+	reusedConns int64
+	saturated   int64
+	lastWarning int64
+}
+
+// HTTPPoolStats are counters of HTTPPool since the previous TakeStats
+type HTTPPoolStats struct {
+	InFlight    int
+	Peak        int
+	NewConns    int64
+	ReusedConns int64
+	Saturated   int64
+}
+
+const httpPoolWarningInterval = time.Minute
+
+var httpPool = &HTTPPool{
+	transport: &defaultHTTPTransport,
+	hosts:     make(map[string]int),
+}
+
+// GetHTTPPool returns the shared pool
+func GetHTTPPool() *HTTPPool {
+	return httpPool
+}
+
+// ConfigureHTTP applies HTTP flags to the shared transport and returns the
+// pool. The transport is only configured once, by PopulateFlags before any
+// request is sent: backends created later (routes, replicas, mounted buckets)
+// share the flags of the mount, and changing the transport while requests are
+// in flight would race with it
+func ConfigureHTTP(flags *FlagStorage) *HTTPPool {
+	p := httpPool
+	p.configured.Do(func() {
+		configureTransport(p.transport, flags)
+	})
+	return p
+}
+
+// NewHTTPPool returns a separate pool with its own connections, configured
+// with flags
+func NewHTTPPool(flags *FlagStorage) *HTTPPool {
+	p := &HTTPPool{
+		transport: defaultHTTPTransport.Clone(),
+		hosts:     make(map[string]int),
+	}
+	p.configured.Do(func() {
+		configureTransport(p.transport, flags)
+	})
+	return p
+}
+
+func configureTransport(tr *http.Transport, flags *FlagStorage) {
+	if flags.NoVerifySSL {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = true
+	}
+	if flags.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = flags.MaxIdleConnsPerHost
+		if tr.MaxIdleConns < flags.MaxIdleConnsPerHost {
+			tr.MaxIdleConns = flags.MaxIdleConnsPerHost
+		}
+	}
+	tr.MaxConnsPerHost = flags.MaxConnsPerHost
+	tr.ResponseHeaderTimeout = flags.ResponseTimeout
+	if flags.TLSSessionCache > 0 {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		if tr.TLSClientConfig.ClientSessionCache == nil {
+			tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(flags.TLSSessionCache)
+		}
+	}
+	// The transport has its own dialer and TLS config, so HTTP/2 is only
+	// used when forced
+	tr.ForceAttemptHTTP2 = flags.HTTP2
+}
+
+func (p *HTTPPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	p.mu.Lock()
+	inFlight := p.hosts[host]
+	p.hosts[host] = inFlight + 1
+	total := 0
+	for _, n := range p.hosts {
+		total += n
+	}
+	if total > p.peak {
+		p.peak = total
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if p.hosts[host] <= 1 {
+			delete(p.hosts, host)
+		} else {
+			p.hosts[host]--
+		}
+		p.mu.Unlock()
+	}()
+
+	if limit := p.transport.MaxConnsPerHost; limit > 0 && inFlight >= limit {
+		p.saturate("%v HTTP requests to %v wait for connections, --max-conns-per-host is %v",
+			inFlight+1-limit, host, limit)
+	} else if limit := p.transport.MaxIdleConnsPerHost; inFlight >= limit {
+		p.saturate("%v HTTP requests in flight to %v, connections beyond --max-idle-conns-per-host %v"+
+			" are closed after use", inFlight+1, host, limit)
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&p.reusedConns, 1)
+			} else {
+				atomic.AddInt64(&p.newConns, 1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return p.transport.RoundTrip(req)
+}
+
+func (p *HTTPPool) saturate(format string, args ...interface{}) {
+	atomic.AddInt64(&p.saturated, 1)
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&p.lastWarning)
+	if now-last >= int64(httpPoolWarningInterval) && atomic.CompareAndSwapInt64(&p.lastWarning, last, now) {
+		log.Warnf("HTTP connection pool is saturated: "+format, args...)
+	}
+}
+
+// TakeStats returns the counters and resets them
+func (p *HTTPPool) TakeStats() HTTPPoolStats {
+	p.mu.Lock()
+	stats := HTTPPoolStats{Peak: p.peak}
+	for _, n := range p.hosts {
+		stats.InFlight += n
+	}
+	p.peak = stats.InFlight
+	p.mu.Unlock()
+	stats.NewConns = atomic.SwapInt64(&p.newConns, 0)
+	stats.ReusedConns = atomic.SwapInt64(&p.reusedConns, 0)
+	stats.Saturated = atomic.SwapInt64(&p.saturated, 0)
+	return stats
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ChangeJournal is a local append-only log of changes made to the bucket by
+// this mount, so that incremental sync tools and indexers may ask for changes
+// since a sequence number instead of listing the bucket. Events are recorded
+// when changes reach the server, so the listed keys are already visible in
+// the bucket. The journal is a file with one JSON event per line; when it
+// exceeds the maximum size, it's rotated to <path>.1 and the previous .1 is
+// removed.
+//
+// Events are recorded by flushes, so readers don't hold the lock while they
+// read the files. A sparse index of sequence numbers and offsets allows them
+// to skip events they don't need.
+type ChangeJournal struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	seq     uint64
+	// index of the current file and of <path>.1, and the size of <path>.1
+	index    []journalMark
+	oldIndex []journalMark
+	oldSize  int64
+}
+
+// journalMark is the sequence number and the offset of an event. Marks are
+// added at least changeIndexStep bytes apart.
+type journalMark struct {
+	seq    uint64
+	offset int64
+}
+
+const changeIndexStep = 64 * 1024
+
+type ChangeEvent struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// "put" or "delete"
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	// Source key if the object was copied (renamed)
+	From string `json:"from,omitempty"`
+	ETag string `json:"etag,omitempty"`
+	Size uint64 `json:"size,omitempty"`
+}
+
+func OpenChangeJournal(path string, maxSize int64) (*ChangeJournal, error) {
+	j := &ChangeJournal{
+		path:    path,
+		maxSize: maxSize,
+	}
+	// Continue numbering from the last recorded event and build the index
+	var err error
+	j.oldIndex, j.oldSize, err = j.indexChanges(path + ".1")
+	if err != nil {
+		return nil, err
+	}
+	j.index, _, err = j.indexChanges(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	j.file = file
+	j.size = st.Size()
+	return j, nil
+}
+
+func (j *ChangeJournal) indexChanges(path string) (index []journalMark, size int64, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	err = scanChanges(file, 0, -1, func(ev *ChangeEvent, offset int64) bool {
+		j.seq = ev.Seq
+		if len(index) == 0 || offset >= index[len(index)-1].offset+changeIndexStep {
+			index = append(index, journalMark{ev.Seq, offset})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	return index, st.Size(), nil
+}
+
+// scanChanges reads events from the file starting at offset from, up to
+// offset to or, if to is negative, up to the end
+func scanChanges(file *os.File, from, to int64, fn func(ev *ChangeEvent, offset int64) bool) error {
+	if to < 0 {
+		to = math.MaxInt64
+	}
+	scanner := bufio.NewScanner(io.NewSectionReader(file, from, to-from))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	offset := from
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var ev ChangeEvent
+		// Skip a partially written last line after a crash
+		if json.Unmarshal(line, &ev) == nil {
+			if !fn(&ev, offset) {
+				break
+			}
+		}
+		offset += int64(len(line)) + 1
+	}
+	return scanner.Err()
+}
+
+// seekChanges returns the offset to start reading events after seq from
+func seekChanges(index []journalMark, seq uint64) int64 {
+	i := sort.Search(len(index), func(i int) bool {
+		return index[i].seq > seq+1
+	})
+	if i == 0 {
+		return 0
+	}
+	return index[i-1].offset
+}
+
+func (j *ChangeJournal) Record(op, key, from string, etag string, size uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return
+	}
+	j.seq++
+	line, _ := json.Marshal(&ChangeEvent{
+		Seq:  j.seq,
+		Time: clock.Now().UTC(),
+		Op:   op,
+		Key:  key,
+		From: from,
+		ETag: etag,
+		Size: size,
+	})
+	line = append(line, '\n')
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(len(line)) > j.maxSize {
+		j.rotate()
+		if j.file == nil {
+			return
+		}
+	}
+	if len(j.index) == 0 || j.size >= j.index[len(j.index)-1].offset+changeIndexStep {
+		j.index = append(j.index, journalMark{j.seq, j.size})
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		log.Errorf("Failed to write change journal %v: %v", j.path, err)
+	}
+}
+
+// LOCKS_REQUIRED(j.mu)
+func (j *ChangeJournal) rotate() {
+	j.file.Close()
+	j.file = nil
+	err := os.Rename(j.path, j.path+".1")
+	if err != nil {
+		log.Errorf("Failed to rotate change journal %v: %v", j.path, err)
+		j.oldIndex, j.oldSize = nil, 0
+	} else {
+		j.oldIndex, j.oldSize = j.index, j.size
+	}
+	j.index = nil
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Errorf("Failed to reopen change journal %v, changes are not recorded anymore: %v", j.path, err)
+		return
+	}
+	j.file = file
+	j.size = 0
+}
+
+// Since returns at most limit events with sequence numbers greater than seq,
+// and the sequence number of the last recorded event. If seq is older than
+// the oldest event kept, events are returned from the oldest one, so callers
+// should check for a gap and do a full resync in that case.
+func (j *ChangeJournal) Since(seq uint64, limit int) (events []ChangeEvent, last uint64, err error) {
+	type journalPart struct {
+		file     *os.File
+		from, to int64
+	}
+	var parts []journalPart
+	defer func() {
+		for _, part := range parts {
+			part.file.Close()
+		}
+	}()
+	// Open the files and remember what to read under the lock, and read
+	// them without it. Open files stay readable if they're rotated meanwhile.
+	j.mu.Lock()
+	last = j.seq
+	if seq >= last {
+		j.mu.Unlock()
+		return
+	}
+	if len(j.index) == 0 || j.index[0].seq > seq+1 {
+		file, err := os.Open(j.path + ".1")
+		if err == nil {
+			parts = append(parts, journalPart{file, seekChanges(j.oldIndex, seq), j.oldSize})
+		} else if !os.IsNotExist(err) {
+			j.mu.Unlock()
+			return nil, 0, err
+		}
+	}
+	file, err := os.Open(j.path)
+	if err == nil {
+		parts = append(parts, journalPart{file, seekChanges(j.index, seq), j.size})
+	} else if !os.IsNotExist(err) {
+		j.mu.Unlock()
+		return nil, 0, err
+	}
+	j.mu.Unlock()
+	for _, part := range parts {
+		err = scanChanges(part.file, part.from, part.to, func(ev *ChangeEvent, offset int64) bool {
+			if ev.Seq > seq {
+				events = append(events, *ev)
+			}
+			return limit <= 0 || len(events) < limit
+		})
+		if err != nil || limit > 0 && len(events) >= limit {
+			return
+		}
+	}
+	return
+}
+
+func (j *ChangeJournal) Close() {
+	j.mu.Lock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	j.mu.Unlock()
+}
+
+// Handler serves GET ?since=<seq>&limit=<n> with events as JSON lines.
+// The last sequence number is returned in the Geesefs-Last-Seq header.
+func (j *ChangeJournal) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var since uint64
+		limit := 1000
+		var err error
+		if s := r.URL.Query().Get("since"); s != "" {
+			since, err = strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				http.Error(w, "bad since", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.URL.Query().Get("limit"); s != "" {
+			limit, err = strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "bad limit", http.StatusBadRequest)
+				return
+			}
+		}
+		events, last, err := j.Since(since, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Geesefs-Last-Seq", fmt.Sprintf("%v", last))
+		enc := json.NewEncoder(w)
+		for i := range events {
+			enc.Encode(&events[i])
+		}
+	})
+}
+
+func (fs *Goofys) recordChange(op, key, from string, etag string, size uint64) {
+	if fs.changes != nil {
+		fs.changes.Record(op, key, from, etag, size)
+	}
+	if fs.invalLog != nil {
+		fs.invalLog.Add(ChangeEvent{Op: op, Key: key, From: from, ETag: etag, Size: size})
+	}
+}
+
+// ChangesHandler serves the change journal, if it's enabled
+func (fs *Goofys) ChangesHandler() http.Handler {
+	if fs.changes == nil {
+		return http.NotFoundHandler()
+	}
+	return fs.changes.Handler()
+}
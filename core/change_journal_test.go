@@ -0,0 +1,133 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type ChangeJournalTest struct{}
+
+var _ = Suite(&ChangeJournalTest{})
+
+func (s *ChangeJournalTest) TestChangeJournalRotateNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "changes")
+	j, err := OpenChangeJournal(path, 300)
+	t.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		j.Record("put", "file", "", "", uint64(i))
+	}
+	j.Close()
+	_, err = os.Stat(path + ".1")
+	t.Assert(err, IsNil)
+
+	// Numbering continues after reopening
+	j, err = OpenChangeJournal(path, 300)
+	t.Assert(err, IsNil)
+	defer j.Close()
+	j.Record("delete", "file", "", "", 0)
+	events, last, err := j.Since(9, 0)
+	t.Assert(err, IsNil)
+	t.Assert(last, Equals, uint64(11))
+	t.Assert(len(events), Equals, 2)
+	t.Assert(events[0].Seq, Equals, uint64(10))
+	t.Assert(events[1].Op, Equals, "delete")
+
+	events, _, err = j.Since(11, 0)
+	t.Assert(err, IsNil)
+	t.Assert(len(events), Equals, 0)
+}
+
+func (s *ChangeJournalTest) TestChangeJournalMountNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "changes")
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.ChangeJournal = path
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	err = m.WriteAndSync("file", []byte("data"))
+	t.Assert(err, IsNil)
+	root := m.fs.getInodeOrDie(1)
+	err = root.Unlink("file")
+	t.Assert(err, IsNil)
+	err = m.fs.SyncTree(nil)
+	t.Assert(err, IsNil)
+
+	events, last, err := m.fs.changes.Since(0, 0)
+	t.Assert(err, IsNil)
+	t.Assert(last, Equals, uint64(2))
+	t.Assert(events[0].Op, Equals, "put")
+	t.Assert(events[0].Key, Equals, "file")
+	t.Assert(events[0].Size, Equals, uint64(4))
+	t.Assert(events[1].Op, Equals, "delete")
+	t.Assert(events[1].Key, Equals, "file")
+}
+
+func (s *ChangeJournalTest) TestChangeJournalIndexNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "changes")
+	key := strings.Repeat("k", 200)
+	j, err := OpenChangeJournal(path, 512*1024)
+	t.Assert(err, IsNil)
+	for i := 0; i < 3000; i++ {
+		j.Record("put", key, "", "", uint64(i))
+	}
+	t.Assert(len(j.oldIndex) > 1, Equals, true)
+	t.Assert(len(j.index) > 1, Equals, true)
+	check := func(j *ChangeJournal) {
+		// Reads start from the closest indexed event in either file
+		for _, seq := range []uint64{0, 1, 700, j.oldIndex[1].seq - 1, j.index[0].seq - 1, j.index[1].seq, 2999} {
+			events, last, err := j.Since(seq, 3)
+			t.Assert(err, IsNil)
+			t.Assert(last, Equals, uint64(3000))
+			if seq < j.oldIndex[0].seq {
+				seq = j.oldIndex[0].seq - 1
+			}
+			t.Assert(len(events) > 0, Equals, true)
+			for i, ev := range events {
+				t.Assert(ev.Seq, Equals, seq+uint64(i)+1)
+			}
+		}
+	}
+	check(j)
+	index, oldIndex := j.index, j.oldIndex
+	j.Close()
+
+	// The index is rebuilt after reopening
+	j, err = OpenChangeJournal(path, 512*1024)
+	t.Assert(err, IsNil)
+	defer j.Close()
+	t.Assert(j.index, DeepEquals, index)
+	t.Assert(j.oldIndex, DeepEquals, oldIndex)
+	check(j)
+}
+
+func (s *ChangeJournalTest) TestChangeJournalShutdownNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "changes")
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.ChangeJournal = path
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	m.Conn.SetOpLatency("PutBlob", 200*time.Millisecond)
+
+	// A flush in progress at unmount is still recorded
+	_, err = m.WriteFile("file", []byte("data"))
+	t.Assert(err, IsNil)
+	t.Assert(waitUntil(func() bool { return m.Conn.Calls("PutBlob") > 0 }), Equals, true)
+	c.Shutdown()
+	j, err := OpenChangeJournal(path, 0)
+	t.Assert(err, IsNil)
+	defer j.Close()
+	events, _, err := j.Since(0, 0)
+	t.Assert(err, IsNil)
+	t.Assert(len(events), Equals, 1)
+	t.Assert(events[0].Key, Equals, "file")
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Reads may be validated against checksum manifests produced outside of the
+// filesystem (--checksum-manifest), for example by the acquisition system
+// that wrote the data. Validation is opportunistic: a file handle hashes
+// data returned to the application while it's read sequentially from the
+// beginning, and the hash is compared with the manifest when the end of the
+// file is reached. Nothing is downloaded just for validation.
+
+// Reading this virtual xattr returns the result of the last validation
+const checksumXattr = "user.geesefs.checksum"
+
+type checksumManifest struct {
+	// path relative to the mount root => lowercase hex checksum
+	sums map[string]string
+}
+
+// loadChecksumManifests reads files in md5sum/sha1sum/sha256sum format.
+// Algorithm is determined by the checksum length.
+func loadChecksumManifests(paths []string) (*checksumManifest, error) {
+	m := &checksumManifest{sums: make(map[string]string)}
+	for _, path := range paths {
+		err := m.load(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *checksumManifest) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		sep := strings.IndexAny(line, " \t")
+		if sep < 0 {
+			return fmt.Errorf("%v:%v: expected \"<checksum> <path>\"", path, lineNum)
+		}
+		sum := strings.ToLower(line[0:sep])
+		if _, err := hex.DecodeString(sum); err != nil || newChecksumHash(sum) == nil {
+			return fmt.Errorf("%v:%v: unknown checksum %v", path, lineNum, line[0:sep])
+		}
+		// "*" marks binary mode in md5sum output
+		name := strings.TrimLeft(line[sep:], " \t")
+		name = strings.TrimPrefix(name, "*")
+		name = strings.TrimPrefix(name, "./")
+		name = strings.TrimLeft(name, "/")
+		m.sums[name] = sum
+	}
+	return scanner.Err()
+}
+
+func newChecksumHash(sum string) hash.Hash {
+	switch len(sum) {
+	case 2 * md5.Size:
+		return md5.New()
+	case 2 * sha1.Size:
+		return sha1.New()
+	case 2 * sha256.Size:
+		return sha256.New()
+	}
+	return nil
+}
+
+type checksumVerifier struct {
+	expected string
+	etag     string
+	hash     hash.Hash
+	hashed   uint64
+}
+
+// verifyRead feeds data returned by a read into the file handle's hash
+// LOCKS_REQUIRED(fh.inode.mu)
+func (fh *FileHandle) verifyRead(offset uint64, data [][]byte) {
+	inode := fh.inode
+	fs := inode.fs
+	if fs.checksums == nil {
+		return
+	}
+	if offset == 0 && fh.verifier == nil && !fh.verifyStarted {
+		fh.verifyStarted = true
+		expected, ok := fs.checksums.sums[inode.FullName()]
+		if !ok || inode.CacheState != ST_CACHED || rand.Float64() >= fs.flags.ChecksumSample {
+			return
+		}
+		fh.verifier = &checksumVerifier{
+			expected: expected,
+			etag:     inode.knownETag,
+			hash:     newChecksumHash(expected),
+		}
+	}
+	v := fh.verifier
+	if v == nil {
+		return
+	}
+	if offset != v.hashed || inode.CacheState != ST_CACHED || inode.knownETag != v.etag {
+		// Not sequential or modified
+		fh.verifier = nil
+		return
+	}
+	for _, b := range data {
+		v.hash.Write(b)
+		v.hashed += uint64(len(b))
+	}
+	if v.hashed < inode.Attributes.Size {
+		return
+	}
+	fh.verifier = nil
+	actual := hex.EncodeToString(v.hash.Sum(nil))
+	atomic.AddUint64(&fs.checksumsVerified, 1)
+	if actual == v.expected {
+		inode.checksumResult = "ok"
+	} else {
+		atomic.AddUint64(&fs.checksumMismatches, 1)
+		inode.checksumResult = "mismatch"
+		log.Errorf("Checksum mismatch for %v: expected %v, read %v", inode.FullName(), v.expected, actual)
+	}
+	inode.checksumETag = v.etag
+}
+
+func (inode *Inode) getChecksumResult() ([]byte, error) {
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	// Result is stale if the file was changed since validation
+	if inode.checksumResult == "" || inode.CacheState != ST_CACHED || inode.knownETag != inode.checksumETag {
+		return nil, ENOATTR
+	}
+	return []byte(inode.checksumResult), nil
+}
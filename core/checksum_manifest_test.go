@@ -0,0 +1,79 @@
+package core
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type ChecksumManifestTest struct{}
+
+var _ = Suite(&ChecksumManifestTest{})
+
+func readWhole(t *C, fh *FileHandle, chunk int64) {
+	for offset := int64(0); ; offset += chunk {
+		_, n, err := fh.ReadFile(offset, chunk)
+		t.Assert(err, IsNil)
+		if n == 0 {
+			break
+		}
+	}
+}
+
+func (s *ChecksumManifestTest) TestChecksumManifestNoCloud(t *C) {
+	good := []byte("good data")
+	goodSum := md5.Sum(good)
+	manifest := filepath.Join(t.MkDir(), "MD5SUMS")
+	err := os.WriteFile(manifest, []byte(
+		hex.EncodeToString(goodSum[:])+"  ./good\n"+
+			"00112233445566778899aabbccddeeff *bad\n"), 0600)
+	t.Assert(err, IsNil)
+
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.ChecksumManifests = []string{manifest}
+		flags.ChecksumSample = 1
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	for _, name := range []string{"good", "bad"} {
+		err = m.WriteAndSync(name, good)
+		t.Assert(err, IsNil)
+		inode, err := m.fs.LookupPath(name)
+		t.Assert(err, IsNil)
+		_, err = inode.GetXattr(checksumXattr)
+		t.Assert(err, Equals, ENOATTR)
+		fh, err := inode.OpenFile()
+		t.Assert(err, IsNil)
+		readWhole(t, fh, 4)
+		fh.Release()
+	}
+
+	inode, err := m.fs.LookupPath("good")
+	t.Assert(err, IsNil)
+	res, err := inode.GetXattr(checksumXattr)
+	t.Assert(err, IsNil)
+	t.Assert(string(res), Equals, "ok")
+	inode, err = m.fs.LookupPath("bad")
+	t.Assert(err, IsNil)
+	res, err = inode.GetXattr(checksumXattr)
+	t.Assert(err, IsNil)
+	t.Assert(string(res), Equals, "mismatch")
+	t.Assert(m.fs.checksumsVerified, Equals, uint64(2))
+	t.Assert(m.fs.checksumMismatches, Equals, uint64(1))
+
+	// Modification invalidates the result
+	fh, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(0, []byte("x"), true)
+	t.Assert(err, IsNil)
+	fh.Release()
+	_, err = inode.GetXattr(checksumXattr)
+	t.Assert(err, Equals, ENOATTR)
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the time source of the file system: cache expiration, retry
+// backoff, timestamps of changed files and background loops use it instead of
+// the time package, so tests may replace it with a simulated clock and check
+// time-dependent logic deterministically, running minutes of file system time
+// instantly. Expiration times passed to the kernel, latency measurements and
+// the backends' own HTTP timing always use real time.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+// sharedClock forwards to the current time source. It's swapped atomically
+// because background loops of previous mounts may still read it
+type sharedClock struct {
+	v atomic.Value
+}
+
+// atomic.Value requires the same concrete type in every Store()
+type clockBox struct {
+	Clock
+}
+
+func (c *sharedClock) load() Clock {
+	return c.v.Load().(clockBox).Clock
+}
+
+func (c *sharedClock) Now() time.Time {
+	return c.load().Now()
+}
+
+func (c *sharedClock) Sleep(d time.Duration) {
+	c.load().Sleep(d)
+}
+
+func (c *sharedClock) After(d time.Duration) <-chan time.Time {
+	return c.load().After(d)
+}
+
+func (c *sharedClock) AfterFunc(d time.Duration, f func()) {
+	c.load().AfterFunc(d, f)
+}
+
+// clock is shared by all mounts of the process
+var clock = newSharedClock(realClock{})
+
+func newSharedClock(c Clock) *sharedClock {
+	s := &sharedClock{}
+	s.v.Store(clockBox{c})
+	return s
+}
+
+// SetClock replaces the time source and returns a function restoring the
+// previous one. It should be called before mounts are started
+func SetClock(c Clock) (restore func()) {
+	prev := clock.v.Swap(clockBox{c})
+	return func() {
+		clock.v.Store(prev)
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type ClockTest struct{}
+
+var _ = Suite(&ClockTest{})
+
+func (s *ClockTest) TestSimClockBackoffNoCloud(t *C) {
+	sim := NewSimClock()
+	defer SetClock(sim)()
+	start := sim.Now()
+	t.Assert(expired(start, time.Minute), Equals, false)
+	sim.Advance(time.Minute)
+	t.Assert(expired(start, time.Minute), Equals, true)
+
+	// Backoff doesn't take real time
+	flags := cfg.DefaultFlags()
+	flags.ReadRetryInterval = time.Second
+	flags.ReadRetryMultiplier = 2
+	flags.ReadRetryMax = 5 * time.Second
+	flags.ReadRetryAttempts = 5
+	realStart, start := time.Now(), sim.Now()
+	attempts := 0
+	err := ReadBackoff(flags, func(attempt int) error {
+		attempts = attempt
+		return syscall.EAGAIN
+	})
+	t.Assert(err, Equals, syscall.EAGAIN)
+	t.Assert(attempts, Equals, 5)
+	t.Assert(sim.Now().Sub(start), Equals, (1+2+4+5)*time.Second)
+	t.Assert(time.Since(realStart) < time.Second, Equals, true)
+
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Hour, func() { close(fired) })
+	after := clock.After(time.Minute)
+	sim.Advance(time.Minute)
+	<-after
+	select {
+	case <-fired:
+		t.Fatal("fired too early")
+	default:
+	}
+	sim.Advance(time.Hour)
+	<-fired
+}
+
+func (s *ClockTest) TestSimClockStatCacheNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = time.Hour
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(a.WriteAndSync("file", []byte("old")), IsNil)
+	data, err := b.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "old")
+
+	// The cached attributes of b only expire after the TTL
+	t.Assert(a.WriteAndSync("file", []byte("new!")), IsNil)
+	c.Clock.Advance(30 * time.Minute)
+	inode, err := b.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	inode.mu.Lock()
+	t.Assert(inode.Attributes.Size, Equals, uint64(3))
+	inode.mu.Unlock()
+	c.Clock.Advance(31 * time.Minute)
+	_, err = b.fs.getInodeOrDie(1).LookUp("file", false)
+	t.Assert(err, IsNil)
+	inode.mu.Lock()
+	t.Assert(inode.Attributes.Size, Equals, uint64(4))
+	inode.mu.Unlock()
+
+	// Timestamps of changed files come from the clock
+	inode, err = a.WriteFile("file", []byte("newer"))
+	t.Assert(err, IsNil)
+	inode.mu.Lock()
+	t.Assert(inode.Attributes.Mtime.Equal(c.Clock.Now()), Equals, true)
+	inode.mu.Unlock()
+}
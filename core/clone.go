@@ -0,0 +1,436 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// `geesefs clone` exposes a snapshot (see snapshot.go) under a new
+// directory without copying any data: it only writes the manifest into the
+// .geesefs_clone marker object of the directory. Mounts with --clones
+// check every directory for the marker when it's first looked into and show
+// a marked directory as the snapshot, reading objects from their original
+// keys and pinned versions.
+//
+// Read-only clones reject all changes. Copy-on-write clones store new and
+// changed files under the clone directory itself, they take precedence over
+// the snapshot. Files of the snapshot can't be removed or renamed in a
+// clone, and server-side copies of them (metadata changes and partial
+// rewrites of large files) fail if the original object was changed since the
+// snapshot.
+
+const cloneMarkerName = ".geesefs_clone"
+
+// cloneBackend shows a snapshot under prefix, with copy-on-write it forwards
+// changes to the underlying backend
+type cloneBackend struct {
+	StorageBackend
+	flags  *cfg.FlagStorage
+	prefix string
+	marker string
+	cow    bool
+
+	// items of the snapshot with keys under prefix, sorted
+	items []BlobItemOutput
+	// original keys and versions of items
+	sources    map[string]string
+	versionIds map[string]string
+
+	mu sync.Mutex
+	// keys which were written in the clone and hide the snapshot
+	overridden map[string]bool
+	// objects written in the clone, listed at the start of each listing
+	writtenPrefix string
+	written       []BlobItemOutput
+}
+
+func newCloneBackend(flags *cfg.FlagStorage, cloud StorageBackend, prefix string, snap *Snapshot, cow bool) *cloneBackend {
+	c := &cloneBackend{
+		StorageBackend: cloud,
+		flags:          flags,
+		prefix:         prefix,
+		marker:         prefix + cloneMarkerName,
+		cow:            cow,
+		sources:        make(map[string]string),
+		versionIds:     make(map[string]string),
+		overridden:     make(map[string]bool),
+	}
+	for _, item := range snap.Items {
+		if !strings.HasPrefix(*item.Key, snap.Prefix) || len(*item.Key) == len(snap.Prefix) {
+			continue
+		}
+		key := prefix + (*item.Key)[len(snap.Prefix):]
+		if key == c.marker {
+			// nested clones aren't supported
+			continue
+		}
+		c.sources[key] = *item.Key
+		if version := snap.VersionIds[*item.Key]; version != "" {
+			c.versionIds[key] = version
+		}
+		item.Key = PString(key)
+		c.items = append(c.items, item)
+	}
+	sort.Sort(sortBlobItemOutput(c.items))
+	return c
+}
+
+// readCloneMarker parses the mode line and the manifest of a clone marker
+func readCloneMarker(data []byte, name string) (snap *Snapshot, cow bool, err error) {
+	line, rest, _ := bytes.Cut(data, []byte("\n"))
+	switch string(bytes.TrimRight(line, "\r")) {
+	case "# clone cow":
+		cow = true
+	case "# clone ro":
+	default:
+		return nil, false, fmt.Errorf("%v is not a clone marker", name)
+	}
+	snap, err = ReadSnapshot(bytes.NewReader(rest), name)
+	return snap, cow, err
+}
+
+func (c *cloneBackend) find(key string) *BlobItemOutput {
+	i := sort.Search(len(c.items), func(i int) bool { return *c.items[i].Key >= key })
+	if i >= len(c.items) || *c.items[i].Key != key {
+		return nil
+	}
+	return &c.items[i]
+}
+
+// inSnapshot reports if key is an object of the snapshot or a directory
+// with objects of the snapshot
+func (c *cloneBackend) inSnapshot(key string) bool {
+	key = strings.TrimSuffix(key, "/")
+	i := sort.Search(len(c.items), func(i int) bool { return *c.items[i].Key >= key })
+	return i < len(c.items) && (*c.items[i].Key == key || strings.HasPrefix(*c.items[i].Key, key+"/"))
+}
+
+// fromSnapshot reports if key should be read from the snapshot
+func (c *cloneBackend) fromSnapshot(key string) bool {
+	if c.find(key) == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.overridden[key]
+}
+
+func (c *cloneBackend) override(key string) {
+	c.mu.Lock()
+	c.overridden[key] = true
+	c.mu.Unlock()
+}
+
+// copySource returns the key to copy from instead of key if it's in the
+// snapshot. Objects can't be copied by version, so it has to be unchanged
+func (c *cloneBackend) copySource(key string) (string, error) {
+	if !c.fromSnapshot(key) {
+		return key, nil
+	}
+	source := c.sources[key]
+	if c.versionIds[key] != "" {
+		head, err := c.StorageBackend.HeadBlob(&HeadBlobInput{Key: source})
+		if err != nil || NilStr(head.ETag) != NilStr(c.find(key).ETag) {
+			log.Warnf("Can't copy %v: %v was changed since the snapshot", key, source)
+			return "", syscall.EROFS
+		}
+	}
+	return source, nil
+}
+
+func (c *cloneBackend) Init(key string) error {
+	return nil
+}
+
+func (c *cloneBackend) Delegate() interface{} {
+	return c
+}
+
+func (c *cloneBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	if param.Key == c.marker {
+		return nil, syscall.ENOENT
+	}
+	if c.cow {
+		resp, err := c.StorageBackend.HeadBlob(param)
+		if err == nil {
+			c.override(param.Key)
+			return resp, nil
+		}
+		if mapAwsError(err) != syscall.ENOENT {
+			return nil, err
+		}
+	}
+	item := c.find(param.Key)
+	if item == nil {
+		return nil, syscall.ENOENT
+	}
+	return &HeadBlobOutput{
+		BlobItemOutput: *item,
+		IsDirBlob:      strings.HasSuffix(param.Key, "/"),
+	}, nil
+}
+
+func (c *cloneBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	if !c.cow {
+		return listSortedItems(c.items, param), nil
+	}
+	prefix := NilStr(param.Prefix)
+	c.mu.Lock()
+	written := c.written
+	if param.StartAfter == nil && param.ContinuationToken == nil || c.writtenPrefix != prefix {
+		c.mu.Unlock()
+		var err error
+		written, err = listAll(c.flags, c.StorageBackend, prefix, c.marker)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.writtenPrefix = prefix
+		c.written = written
+	}
+	for _, item := range written {
+		c.overridden[*item.Key] = true
+	}
+	c.mu.Unlock()
+	merged := make([]BlobItemOutput, 0, len(written))
+	merged = append(merged, written...)
+	for _, item := range c.items {
+		if strings.HasPrefix(*item.Key, prefix) && c.fromSnapshot(*item.Key) {
+			merged = append(merged, item)
+		}
+	}
+	sort.Sort(sortBlobItemOutput(merged))
+	return listSortedItems(merged, param), nil
+}
+
+func (c *cloneBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	if param.Key == c.marker {
+		return nil, syscall.ENOENT
+	}
+	if c.cow && !c.fromSnapshot(param.Key) {
+		return c.StorageBackend.GetBlob(param)
+	}
+	item := c.find(param.Key)
+	if item == nil {
+		return nil, syscall.ENOENT
+	}
+	get := *param
+	get.Key = c.sources[param.Key]
+	if version := c.versionIds[param.Key]; version != "" {
+		get.VersionId = PString(version)
+	}
+	resp, err := c.StorageBackend.GetBlob(&get)
+	if err == nil {
+		resp.Key = item.Key
+	}
+	return resp, err
+}
+
+func (c *cloneBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	if !c.cow || c.inSnapshot(param.Key) || param.Key == c.marker {
+		return nil, syscall.EROFS
+	}
+	return c.StorageBackend.DeleteBlob(param)
+}
+
+func (c *cloneBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	if !c.cow {
+		return nil, syscall.EROFS
+	}
+	for _, key := range param.Items {
+		if c.inSnapshot(key) || key == c.marker {
+			return nil, syscall.EROFS
+		}
+	}
+	return c.StorageBackend.DeleteBlobs(param)
+}
+
+func (c *cloneBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	if !c.cow || c.inSnapshot(param.Source) {
+		return nil, syscall.EROFS
+	}
+	c.override(param.Destination)
+	return c.StorageBackend.RenameBlob(param)
+}
+
+func (c *cloneBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	if !c.cow {
+		return nil, syscall.EROFS
+	}
+	source, err := c.copySource(param.Source)
+	if err != nil {
+		return nil, err
+	}
+	copyIn := *param
+	copyIn.Source = source
+	resp, err := c.StorageBackend.CopyBlob(&copyIn)
+	if err == nil {
+		c.override(param.Destination)
+	}
+	return resp, err
+}
+
+func (c *cloneBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	if !c.cow || param.Key == c.marker {
+		return nil, syscall.EROFS
+	}
+	resp, err := c.StorageBackend.PutBlob(param)
+	if err == nil {
+		c.override(param.Key)
+	}
+	return resp, err
+}
+
+func (c *cloneBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	if !c.cow || c.fromSnapshot(param.Key) {
+		return nil, syscall.EROFS
+	}
+	return c.StorageBackend.PatchBlob(param)
+}
+
+func (c *cloneBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	if !c.cow {
+		return nil, syscall.EROFS
+	}
+	return c.StorageBackend.MultipartBlobBegin(param)
+}
+
+func (c *cloneBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	source, err := c.copySource(param.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	copyIn := *param
+	copyIn.CopySource = source
+	return c.StorageBackend.MultipartBlobCopy(&copyIn)
+}
+
+func (c *cloneBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	resp, err := c.StorageBackend.MultipartBlobCommit(param)
+	if err == nil {
+		c.override(*param.Key)
+	}
+	return resp, err
+}
+
+func (c *cloneBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (c *cloneBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+// inClone returns the backend of the clone the inode is in
+func (inode *Inode) inClone() *cloneBackend {
+	if !inode.fs.flags.Clones {
+		return nil
+	}
+	cloud, _ := inode.cloud()
+	c, _ := cloud.(*cloneBackend)
+	return c
+}
+
+// readOnlyView reports if the inode is inside a time travel view, an S3
+// Select view or a read-only clone
+func (inode *Inode) readOnlyView() bool {
+	if inode.inTimeTravel() || inode.inSelect() {
+		return true
+	}
+	c := inode.inClone()
+	return c != nil && !c.cow
+}
+
+// isCloneEntry reports if the child is a file of a snapshot, or a directory
+// with such files, which can't be removed or renamed
+func (parent *Inode) isCloneEntry(name string) bool {
+	c := parent.inClone()
+	if c == nil {
+		return false
+	}
+	_, key := parent.cloud()
+	return c.inSnapshot(appendChildName(key, name))
+}
+
+// checkClone mounts the clone if the directory has a clone marker. Every
+// directory is only checked once
+// LOCKS_EXCLUDED(dir.mu)
+func (dir *Inode) checkClone() {
+	dir.mu.Lock()
+	if dir.dir.cloneChecked || dir.Parent == nil || dir.dir.cloud != nil || dir.dir.timeTravel {
+		dir.mu.Unlock()
+		return
+	}
+	dir.dir.cloneChecked = true
+	dir.mu.Unlock()
+	cloud, key := dir.cloud()
+	switch cloud.(type) {
+	case *cloneBackend, *timeTravelBackend:
+		return
+	}
+	marker := key + "/" + cloneMarkerName
+	resp, err := cloud.GetBlob(&GetBlobInput{Key: marker})
+	if err != nil {
+		if mapAwsError(err) != syscall.ENOENT {
+			log.Warnf("Failed to check %v for a clone marker: %v", dir.FullName(), err)
+		}
+		return
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		log.Warnf("Failed to read clone marker %v: %v", marker, err)
+		return
+	}
+	snap, cow, err := readCloneMarker(data, marker)
+	if err != nil {
+		log.Warnf("Invalid clone marker: %v", err)
+		return
+	}
+	dir.fs.mount(dir.Parent, &Mount{
+		name:   dir.Name,
+		cloud:  newCloneBackend(dir.fs.flags, cloud, key+"/", snap, cow),
+		prefix: key + "/",
+	})
+}
+
+// Clone exposes the snapshot under path, which must not exist yet
+func (b *BulkOps) Clone(snap *Snapshot, path string, cow bool) error {
+	key := b.key(path)
+	if key == b.prefix {
+		return fmt.Errorf("clone needs a new directory")
+	}
+	existing, err := b.cloud.ListBlobs(&ListBlobsInput{
+		Prefix:  PString(key + "/"),
+		MaxKeys: PUInt32(1),
+	})
+	if err != nil {
+		return mapAwsError(err)
+	}
+	if len(existing.Items) > 0 || len(existing.Prefixes) > 0 {
+		return fmt.Errorf("%v already exists", path)
+	}
+	var buf bytes.Buffer
+	if cow {
+		buf.WriteString("# clone cow\n")
+	} else {
+		buf.WriteString("# clone ro\n")
+	}
+	err = snap.WriteManifest(&buf)
+	if err != nil {
+		return err
+	}
+	_, err = b.cloud.PutBlob(&PutBlobInput{
+		Key:  key + "/" + cloneMarkerName,
+		Body: bytes.NewReader(buf.Bytes()),
+		Size: PUInt64(uint64(buf.Len())),
+	})
+	return mapAwsError(err)
+}
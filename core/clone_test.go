@@ -0,0 +1,66 @@
+package core
+
+import (
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type CloneTest struct{}
+
+var _ = Suite(&CloneTest{})
+
+func (s *CloneTest) TestCloneNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.Clones = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, jobs: 1}
+
+	c.Store.Put("run/a", []byte("v1"), nil)
+	c.Store.Put("run/sub/b", []byte("b"), nil)
+	snap, err := b.Snapshot("run")
+	t.Assert(err, IsNil)
+	t.Assert(len(snap.VersionIds), Equals, 2)
+	c.Store.Put("run/a", []byte("v2"), nil)
+
+	t.Assert(b.Clone(snap, "ro", false), IsNil)
+	t.Assert(b.Clone(snap, "cow", true), IsNil)
+	t.Assert(b.Clone(snap, "run", true), ErrorMatches, "run already exists")
+
+	// Clones show pinned versions without copying them
+	data, err := m.ReadFile("ro/a")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "v1")
+	dir, err := m.fs.LookupPath("ro")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"a", "sub"})
+	data, err = m.ReadFile("cow/sub/b")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "b")
+	_, err = m.WriteFile("ro/new", []byte("x"))
+	t.Assert(err, Equals, syscall.EROFS)
+
+	// Changes in copy-on-write clones are stored under the clone
+	t.Assert(m.WriteAndSync("cow/a", []byte("mine")), IsNil)
+	t.Assert(m.WriteAndSync("cow/new", []byte("new")), IsNil)
+	data, _ = c.Store.Get("cow/a")
+	t.Assert(string(data), Equals, "mine")
+	data, err = m.ReadFile("ro/a")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "v1")
+	dir, err = m.fs.LookupPath("cow")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"a", "new", "sub"})
+	t.Assert(dir.Unlink("new"), IsNil)
+	sub, err := m.fs.LookupPath("cow/sub")
+	t.Assert(err, IsNil)
+	t.Assert(sub.Unlink("b"), Equals, syscall.EROFS)
+	t.Assert(dir.Rename("sub", dir, "sub2"), Equals, syscall.EROFS)
+	_, ok := c.Store.Get("cow/sub/b")
+	t.Assert(ok, Equals, false)
+}
@@ -273,11 +273,11 @@ func (fs *ClusterFs) mkDir(parent *Inode, name string, mode os.FileMode) (
 
 				// time
 				child.touch()
-				child.Attributes.Ctime = time.Now()
+				child.Attributes.Ctime = clock.Now()
 				if parent.Attributes.Ctime.Before(child.Attributes.Ctime) {
 					parent.Attributes.Ctime = child.Attributes.Ctime
 				}
-				child.Attributes.Mtime = time.Now()
+				child.Attributes.Mtime = clock.Now()
 				if parent.Attributes.Mtime.Before(child.Attributes.Mtime) {
 					parent.Attributes.Mtime = child.Attributes.Mtime
 				}
@@ -425,14 +425,14 @@ func (fs *ClusterFs) readDir(handleId fuseops.HandleID, offset fuseops.DirOffset
 			break
 		}
 
-		n := fuseutil.WriteDirent(dst[*bytesRead:], makeDirEntry(e, dh.lastExternalOffset))
+		n := fuseutil.WriteDirent(dst[*bytesRead:], makeDirEntry(e, dh.readName, dh.readCookie))
 		if n == 0 {
 			break
 		}
 
 		*bytesRead += n
 		// We have to modify it here because WriteDirent MAY not send the entry
-		dh.Next(e.Name)
+		dh.Next(dh.readName)
 	}
 
 	return nil
@@ -678,7 +678,7 @@ func (fs *ClusterFs) steal(inode *Inode) error {
 		if stolen {
 			return nil
 		}
-		time.Sleep(STEAL_INODE_BACKOFF)
+		clock.Sleep(STEAL_INODE_BACKOFF)
 	}
 }
 
@@ -775,7 +775,7 @@ type Stat struct {
 
 func (fs *ClusterFs) StatPrinter() {
 	for {
-		time.Sleep(STAT_PRINT_INTERVAL)
+		clock.Sleep(STAT_PRINT_INTERVAL)
 		statLog.Infof(
 			"tryStealCnt=%v successTryStealCnt=%v createInodeCnt=%v removeInodeCnt=%v forgetInodeCnt=%v resurectionCnt=%v",
 			atomic.LoadUint64(&fs.stat.tryStealCnt),
@@ -985,7 +985,7 @@ func (fs *ClusterFs) route(
 			} else {
 				inode.KeepOwnerUnlock()
 				fuseLog.Debugf("this fs is owner of inode %v, but it is not ready", inode.info())
-				time.Sleep(READY_OWNER_BACKOFF)
+				clock.Sleep(READY_OWNER_BACKOFF)
 				continue
 			}
 		case inode.owner != fs.Conns.id:
@@ -953,7 +953,8 @@ func MountCluster(
 		}
 	}()
 
-	mfs, err := fuse.Mount(
+	mfs, err := fuseMount(
+		flags.MountMethods,
 		flags.MountPoint,
 		fuseutil.NewFileSystemServer(&ClusterFsFuse{ClusterFs: fs}),
 		mountConfig,
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+)
+
+// Full content hash (--content-hash) is stored in user metadata so that
+// tools like rclone may compare checksums of multipart objects whose ETag
+// isn't an MD5 of the content. The hash is calculated on the fly for files
+// written sequentially from the beginning, and from the upload buffer for
+// small files. In all other cases it's just dropped.
+
+func (fs *Goofys) newContentHash() hash.Hash {
+	switch fs.flags.ContentHash {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	}
+	return nil
+}
+
+// md5 is base64-encoded like rclone's md5chksum, sha1 is hex-encoded
+func (fs *Goofys) encodeContentHash(sum []byte) []byte {
+	if fs.flags.ContentHash == "md5" {
+		return []byte(base64.StdEncoding.EncodeToString(sum))
+	}
+	return []byte(hex.EncodeToString(sum))
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dropContentHash() {
+	attr := inode.fs.flags.ContentHashAttr
+	if inode.userMetadata != nil && inode.userMetadata[attr] != nil {
+		delete(inode.userMetadata, attr)
+		inode.userMetadataDirty = 2
+	}
+}
+
+// Must be called before the write extends the file
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) hashWrite(offset uint64, data []byte) {
+	if inode.fs.flags.ContentHash == "" {
+		return
+	}
+	if inode.contentHash == nil && offset == 0 && inode.Attributes.Size == 0 {
+		inode.contentHash = inode.fs.newContentHash()
+		inode.contentHashed = 0
+	}
+	if inode.contentHash != nil {
+		if offset == inode.contentHashed {
+			inode.contentHash.Write(data)
+			inode.contentHashed += uint64(len(data))
+		} else {
+			inode.contentHash = nil
+		}
+	}
+	inode.dropContentHash()
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) hashResize(newSize uint64) {
+	if inode.fs.flags.ContentHash == "" {
+		return
+	}
+	if newSize < inode.contentHashed {
+		inode.contentHash = nil
+	}
+	inode.dropContentHash()
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) hashInvalidate() {
+	if inode.fs.flags.ContentHash == "" {
+		return
+	}
+	inode.contentHash = nil
+	inode.dropContentHash()
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) storeContentHash(sum []byte) {
+	if inode.userMetadata == nil {
+		inode.userMetadata = make(map[string][]byte)
+	}
+	inode.userMetadata[inode.fs.flags.ContentHashAttr] = sum
+	inode.userMetadataDirty = 2
+}
+
+// contentHashSum returns the encoded hash if it's known for the whole file
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) contentHashSum() []byte {
+	if inode.contentHash == nil || inode.contentHashed != inode.Attributes.Size {
+		return nil
+	}
+	return inode.fs.encodeContentHash(inode.contentHash.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type ContentHashTest struct{}
+
+var _ = Suite(&ContentHashTest{})
+
+func (s *ContentHashTest) TestContentHashNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.ContentHash = "md5"
+		flags.ContentHashAttr = "md5chksum"
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	small := []byte("hello world")
+	large := make([]byte, 12*1024*1024)
+	for i := range large {
+		large[i] = byte(i * 7)
+	}
+	for _, data := range [][]byte{small, large} {
+		err = m.WriteAndSync("file", data)
+		t.Assert(err, IsNil)
+		head, err := m.Conn.HeadBlob(&HeadBlobInput{Key: "file"})
+		t.Assert(err, IsNil)
+		sum := md5.Sum(data)
+		t.Assert(head.Metadata["md5chksum"], NotNil)
+		t.Assert(*head.Metadata["md5chksum"], Equals, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	// Non-sequential modification drops the hash
+	inode, err := m.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	fh, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(100, []byte("x"), true)
+	t.Assert(err, IsNil)
+	fh.Release()
+	err = inode.SyncFile()
+	t.Assert(err, IsNil)
+	head, err := m.Conn.HeadBlob(&HeadBlobInput{Key: "file"})
+	t.Assert(err, IsNil)
+	t.Assert(head.Metadata["md5chksum"], IsNil)
+}
@@ -17,6 +17,7 @@ package core
 
 import (
 	"fmt"
+	"hash/fnv"
 	"os"
 	"sort"
 	"strings"
@@ -50,16 +51,42 @@ type DirInodeData struct {
 	lastFromCloud    *string
 	listDone         bool
 	forgetDuringList bool
+	// Children of a frozen directory come from --key-manifest, other names
+	// don't exist and are never looked up in the backend. Set before the
+	// mount is served and inherited by new subdirectories
+	frozen bool
 	// Time at which we started fetching child entries
 	// from cloud for this handle.
 	refreshStartTime time.Time
 
 	ModifiedChildren int64
 
+	// --dir-meta-file state of this directory: metaDirty means that its
+	// attributes are not stored yet, metaStored is the stored entry
+	metaDirty  bool
+	metaStored *dirMetaEntry
+	// and of its children, loaded from the .geesefs_meta object
+	childMeta       *dirMetaSections
+	childMetaETag   string
+	childMetaLoad   bool
+	childMetaLegacy bool
+	childMetaTime   time.Time
+
+	// virtual .geesefs directory with --time-travel views
+	timeTravel bool
+	// virtual .geesefs_select directory with --s3-select views
+	selectDir bool
+	// the directory was checked for a --clones marker
+	cloneChecked bool
+
 	Children        []*Inode
 	DeletedChildren map[string]*Inode
 	Gaps            []*SlurpGap
 	handles         []*DirHandle
+
+	// Readdir continuation cookies, see nameCookie()
+	cookieNames map[fuseops.DirOffset]string
+	nameCookies map[string]fuseops.DirOffset
 }
 
 // Returns the position of first char < '/' in `inp` after prefixLen + any continued '/' characters.
@@ -80,14 +107,96 @@ func locateLtSlash(inp string, prefixLen int) int {
 	return -1
 }
 
+// Directory offsets reported to the kernel are not positions in Children,
+// but cookies derived from entry names. Entries added or removed by another
+// mount during a long readdir then never shift the continuation point: we
+// always resume right after the name the cookie refers to, even if that name
+// has been deleted in the meantime.
+const (
+	dirCookieDot    fuseops.DirOffset = 1
+	dirCookieDotDot fuseops.DirOffset = 2
+	dirCookieFirst  fuseops.DirOffset = 3
+	dirCookieMask   fuseops.DirOffset = 1<<62 - 1
+)
+
+func hashDirCookie(name string) fuseops.DirOffset {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	c := fuseops.DirOffset(h.Sum64()) & dirCookieMask
+	if c < dirCookieFirst {
+		c += dirCookieFirst
+	}
+	return c
+}
+
+// Returns the continuation cookie for a name. Cookies are stable for the
+// lifetime of the directory inode and are derived from the name hash, so
+// that they stay the same even after the inode is evicted. Colliding names
+// get the next free cookie in the order they're listed, i.e. sorted by name,
+// so cookieName() can find them again in a new incarnation of the inode.
+// LOCKS_REQUIRED(parent.mu)
+func (dir *DirInodeData) nameCookie(name string) fuseops.DirOffset {
+	if c, ok := dir.nameCookies[name]; ok {
+		return c
+	}
+	if dir.nameCookies == nil {
+		dir.nameCookies = make(map[string]fuseops.DirOffset)
+		dir.cookieNames = make(map[fuseops.DirOffset]string)
+	}
+	c := hashDirCookie(name)
+	for {
+		if _, busy := dir.cookieNames[c]; !busy {
+			break
+		}
+		// Hash collision
+		c = (c + 1) & dirCookieMask
+		if c < dirCookieFirst {
+			c = dirCookieFirst
+		}
+	}
+	dir.nameCookies[name] = c
+	dir.cookieNames[c] = name
+	return c
+}
+
+// LOCKS_REQUIRED(parent.mu)
+func (dir *DirInodeData) cookieName(c fuseops.DirOffset) (string, bool) {
+	if name, ok := dir.cookieNames[c]; ok {
+		return name, true
+	}
+	// The cookie may come from a previous incarnation of this directory
+	// inode (nfs-kernel-server reopens directories between pages). Assign
+	// cookies in listing order like it did to also find shifted ones
+	for _, child := range dir.Children {
+		if dir.nameCookie(child.Name) == c {
+			return child.Name, true
+		}
+	}
+	return "", false
+}
+
+// Forget cookies of removed names when nobody reads the directory anymore
+// and the table grows too large compared to the directory itself.
+// LOCKS_REQUIRED(parent.mu)
+func (dir *DirInodeData) trimCookies() {
+	if len(dir.handles) == 0 && len(dir.nameCookies) > 2*len(dir.Children)+1024 {
+		dir.nameCookies = nil
+		dir.cookieNames = nil
+	}
+}
+
 type DirHandle struct {
 	inode *Inode
 	mu    sync.Mutex // everything below is protected by mu
 	// readdir() is allowed either at zero (restart from the beginning)
-	// or from the previous offset
+	// or from any cookie previously returned by this or another handle
 	lastExternalOffset fuseops.DirOffset
 	lastInternalOffset int
 	lastName           string
+	// name and cookie of the entry last returned by ReadDir(). The name is
+	// copied under the directory lock because renames change Inode.Name
+	readName   string
+	readCookie fuseops.DirOffset
 }
 
 func NewDirHandle(inode *Inode) (dh *DirHandle) {
@@ -98,6 +207,10 @@ func NewDirHandle(inode *Inode) (dh *DirHandle) {
 func (inode *Inode) OpenDir() (dh *DirHandle) {
 	var isS3 bool
 
+	if inode.fs.flags.Clones {
+		inode.checkClone()
+	}
+
 	parent := inode.Parent
 	cloud, _ := inode.cloud()
 
@@ -217,8 +330,11 @@ func (inode *Inode) slurpOnce(lock bool) (done bool, err error) {
 	return next == "", err
 }
 
+// Object keys are never normalized, so names which would refer to the current
+// or the parent directory are skipped. Otherwise paths could escape the mount
+// prefix if an intermediate proxy normalizes URLs
 func isInvalidName(name string) bool {
-	return name == "" || name[0] == '/' ||
+	return name == "" || name == "." || name == ".." || name[0] == '/' ||
 		len(name) >= 2 && (name[0:2] == "./" || name[len(name)-2:] == "/.") ||
 		len(name) >= 3 && (name[0:3] == "../" || name[len(name)-3:] == "/..") ||
 		strings.Index(name, "//") >= 0 ||
@@ -226,6 +342,12 @@ func isInvalidName(name string) bool {
 		strings.Index(name, "/../") >= 0
 }
 
+// Names of new entries must be a single valid path component, so that
+// generated keys always stay under the parent's key
+func isInvalidChildName(name string) bool {
+	return isInvalidName(name) || strings.IndexByte(name, '/') >= 0
+}
+
 func RetryListBlobs(flags *cfg.FlagStorage, cloud StorageBackend, req *ListBlobsInput) (resp *ListBlobsOutput, err error) {
 	ReadBackoff(flags, func(attempt int) error {
 		resp, err = cloud.ListBlobs(req)
@@ -258,6 +380,7 @@ func (parent *Inode) listObjectsSlurp(inode *Inode, startAfter string, sealEnd b
 	params := &ListBlobsInput{
 		Prefix:     &prefix,
 		StartAfter: startWith,
+		OpID:       inode.lastOp(),
 	}
 	resp, err := RetryListBlobs(parent.fs.flags, cloud, params)
 	if err != nil {
@@ -272,7 +395,7 @@ func (parent *Inode) listObjectsSlurp(inode *Inode, startAfter string, sealEnd b
 	skipListing := parent.fs.completeInflightListing(myList)
 	dirs := make(map[*Inode]bool)
 	for _, obj := range resp.Items {
-		if skipListing != nil && skipListing[*obj.Key] {
+		if skipListing != nil && skipListing[*obj.Key] || parent.fs.isTempKey(*obj.Key) {
 			continue
 		}
 		baseName := (*obj.Key)[len(prefix):]
@@ -363,7 +486,7 @@ func (dir *DirInodeData) markGapLoaded(start, end string) {
 	dir.Gaps[pos] = &SlurpGap{
 		start:    start,
 		end:      end,
-		loadTime: time.Now(),
+		loadTime: clock.Now(),
 	}
 	dir.Gaps = dir.Gaps[0:l]
 }
@@ -389,7 +512,8 @@ func (inode *Inode) sealDir() {
 	inode.dir.listMarker = ""
 	inode.dir.listDone = true
 	inode.dir.lastFromCloud = nil
-	inode.dir.DirTime = time.Now()
+	inode.dir.DirTime = clock.Now()
+	inode.noteNoDirMeta()
 	if inode.fs.flags.EnableMtime && inode.userMetadata != nil &&
 		inode.userMetadata[inode.fs.flags.MtimeAttr] != nil {
 		_, inode.Attributes.Ctime = inode.findChildMaxTime()
@@ -406,7 +530,7 @@ func (dh *DirHandle) handleListResult(resp *ListBlobsOutput, prefix string, skip
 	fs := parent.fs
 
 	for _, dir := range resp.Prefixes {
-		if skipListing != nil && skipListing[*dir.Prefix] {
+		if skipListing != nil && skipListing[*dir.Prefix] || fs.isTempKey(*dir.Prefix) {
 			continue
 		}
 		// strip trailing /
@@ -417,19 +541,7 @@ func (dh *DirHandle) handleListResult(resp *ListBlobsOutput, prefix string, skip
 			continue
 		}
 
-		if inode := parent.findChildUnlocked(dirName); inode != nil {
-			now := time.Now()
-			// don't want to update time if this
-			// inode is setup to never expire
-			if inode.AttrTime.Before(now) {
-				inode.SetAttrTime(now)
-			}
-		} else if _, deleted := parent.dir.DeletedChildren[dirName]; !deleted {
-			// don't revive deleted items
-			inode := NewInode(fs, parent, dirName)
-			inode.ToDir()
-			fs.insertInode(parent, inode)
-		}
+		parent.touchDirChild(dirName)
 
 		if dh.inode.dir.lastFromCloud == nil ||
 			strings.Compare(*dh.inode.dir.lastFromCloud, dirName) < 0 {
@@ -438,7 +550,7 @@ func (dh *DirHandle) handleListResult(resp *ListBlobsOutput, prefix string, skip
 	}
 
 	for _, obj := range resp.Items {
-		if skipListing != nil && skipListing[*obj.Key] {
+		if skipListing != nil && skipListing[*obj.Key] || fs.isTempKey(*obj.Key) {
 			continue
 		}
 		baseName := (*obj.Key)[len(prefix):]
@@ -448,9 +560,19 @@ func (dh *DirHandle) handleListResult(resp *ListBlobsOutput, prefix string, skip
 
 		slash := strings.Index(baseName, "/")
 		if slash == -1 {
+			dirName, isMarker := fs.dirMarker(baseName)
 			inode := parent.findChildUnlocked(baseName)
-			if inode != nil {
+			if fs.isDirMeta(baseName) {
+				parent.noteDirMeta(&obj, baseName)
+			} else if isMarker {
+				if dirName != "" && !isInvalidName(dirName) {
+					parent.touchDirChild(dirName)
+				}
+			} else if inode != nil {
 				inode.SetFromBlobItem(&obj)
+				parent.applyObjectMeta(inode)
+				parent.applyAtime(inode)
+				parent.applyMetaXattrs(inode)
 			} else {
 				// don't revive deleted items
 				_, deleted := parent.dir.DeletedChildren[baseName]
@@ -458,6 +580,9 @@ func (dh *DirHandle) handleListResult(resp *ListBlobsOutput, prefix string, skip
 					inode = NewInode(fs, parent, baseName)
 					fs.insertInode(parent, inode)
 					inode.SetFromBlobItem(&obj)
+					parent.applyObjectMeta(inode)
+					parent.applyAtime(inode)
+					parent.applyMetaXattrs(inode)
 				}
 			}
 		} else {
@@ -588,6 +713,7 @@ func (dh *DirHandle) listObjectsFlat() (start string, err error) {
 		Delimiter:  PString("/"),
 		StartAfter: PString(dh.inode.dir.listMarker),
 		Prefix:     &prefix,
+		OpID:       dh.inode.lastOp(),
 	}
 	dh.inode.mu.Unlock()
 
@@ -659,7 +785,7 @@ func (dh *DirHandle) loadListing() error {
 		// listMarker is nil => We just started refreshing this directory
 		parent.dir.listDone = false
 		parent.dir.lastFromCloud = nil
-		parent.dir.refreshStartTime = time.Now()
+		parent.dir.refreshStartTime = clock.Now()
 		parent.dir.Gaps = nil
 		parent.dir.forgetDuringList = false
 	}
@@ -671,7 +797,11 @@ func (dh *DirHandle) loadListing() error {
 	// we immediately switch to regular listings.
 	// Original implementation in Goofys in fact was similar in this aspect
 	// but it was ugly in several places, so ... sorry, it's reworked. O:-)
-	useSlurp := parent.dir.listMarker == "" && parent.fs.flags.StatCacheTTL != 0
+	// Slurp lists the parent recursively, which reads whole subtrees with
+	// backends that can't return listings sorted
+	cloud, _ := parent.cloud()
+	useSlurp := parent.dir.listMarker == "" && parent.fs.flags.StatCacheTTL != 0 &&
+		cloud != nil && !cloud.Capabilities().UnsortedList
 
 	// the dir expired, so we need to fetch from the cloud. there
 	// may be static directories that we want to keep, so cloud
@@ -726,25 +856,25 @@ func (dh *DirHandle) loadListing() error {
 // LOCKS_REQUIRED(dh.mu)
 func (dh *DirHandle) Seek(newOffset fuseops.DirOffset) {
 	if newOffset != 0 && newOffset != dh.lastExternalOffset {
-		// Do our best to support directory seeks even though we can't guarantee
-		// consistent listings in this case (i.e. files may be duplicated or skipped on changes)
-		// 'Normal' software doesn't seek within the directory.
-		// nfs-kernel-server, though, does: it closes the dir between paged listing calls.
-		fuseLog.Debugf("Directory seek from %v to %v in %v", newOffset, dh.lastExternalOffset, dh.inode.FullName())
+		// Continue after the name referred to by the cookie. This is
+		// required for nfs-kernel-server which closes the directory
+		// between paged listing calls. Names are used instead of positions
+		// so that concurrent changes never cause skipped or duplicated entries.
+		fuseLog.Debugf("Directory seek from %v to %v in %v", dh.lastExternalOffset, newOffset, dh.inode.FullName())
 		dh.inode.mu.Lock()
 		dh.lastExternalOffset = newOffset
-		dh.lastInternalOffset = int(newOffset)
-		if dh.lastInternalOffset > 2+len(dh.inode.dir.Children) {
-			dh.lastInternalOffset = 2 + len(dh.inode.dir.Children)
-		}
-		if dh.lastInternalOffset == 1 {
+		dh.lastInternalOffset = -1
+		if newOffset == dirCookieDot {
 			dh.lastName = "."
-		} else if dh.lastInternalOffset == 2 {
+		} else if newOffset == dirCookieDotDot {
 			dh.lastName = ".."
+		} else if name, ok := dh.inode.dir.cookieName(newOffset); ok {
+			dh.lastName = name
 		} else {
-			dh.inode.dir.Children[dh.lastInternalOffset-3].mu.Lock()
-			dh.lastName = dh.inode.dir.Children[dh.lastInternalOffset-3].Name
-			dh.inode.dir.Children[dh.lastInternalOffset-3].mu.Unlock()
+			// Unknown cookie. Prefer duplicates to skipped entries
+			// and restart right after ".."
+			fuseLog.Warnf("Unknown directory cookie %v in %v, restarting listing", newOffset, dh.inode.FullName())
+			dh.lastName = ".."
 		}
 		dh.inode.mu.Unlock()
 	} else if newOffset == 0 {
@@ -759,7 +889,7 @@ func (dh *DirHandle) Next(name string) {
 	if dh.lastInternalOffset >= 0 {
 		dh.lastInternalOffset++
 	}
-	dh.lastExternalOffset++
+	dh.lastExternalOffset = dh.readCookie
 	dh.lastName = name
 }
 
@@ -776,7 +906,7 @@ func (parent *Inode) removeExpired(from string) {
 		if parent.dir.lastFromCloud != nil && childTmp.Name >= *parent.dir.lastFromCloud {
 			break
 		}
-		if childTmp.AttrTime.Before(parent.dir.refreshStartTime) &&
+		if childTmp.AttrTime.Before(parent.dir.refreshStartTime) && !childTmp.metaNode &&
 			atomic.LoadInt32(&childTmp.fileHandles) == 0 &&
 			atomic.LoadInt32(&childTmp.CacheState) <= ST_DEAD &&
 			(!childTmp.isDir() || atomic.LoadInt64(&childTmp.dir.ModifiedChildren) == 0) {
@@ -796,8 +926,11 @@ func (parent *Inode) removeExpired(from string) {
 			i--
 		}
 	}
-	if len(notifications) > 0 && parent.fs.NotifyCallback != nil {
-		parent.fs.NotifyCallback(notifications)
+	if len(notifications) > 0 {
+		parent.wakePollers()
+		if parent.fs.NotifyCallback != nil {
+			parent.fs.NotifyCallback(notifications)
+		}
 	}
 }
 
@@ -815,9 +948,13 @@ func (dh *DirHandle) ReadDir() (inode *Inode, err error) {
 	dh.checkDirPosition()
 	if dh.lastInternalOffset == 0 {
 		// "."
+		dh.readName = "."
+		dh.readCookie = dirCookieDot
 		return parent, nil
 	} else if dh.lastInternalOffset == 1 {
 		// ".."
+		dh.readName = ".."
+		dh.readCookie = dirCookieDotDot
 		if parent.Parent != nil {
 			return parent.Parent, nil
 		} else {
@@ -825,31 +962,44 @@ func (dh *DirHandle) ReadDir() (inode *Inode, err error) {
 		}
 	}
 
-	if expired(dh.inode.dir.DirTime, dh.inode.fs.flags.StatCacheTTL) {
+	// With --no-list, only known entries are returned
+	if expired(dh.inode.dir.DirTime, dh.inode.fs.flags.StatCacheTTL) && !dh.inode.fs.flags.NoList {
 		err = dh.loadListing()
 		if err != nil {
+			if mapAwsError(err) == syscall.EACCES && atomic.CompareAndSwapInt32(&dh.inode.fs.listDenied, 0, 1) {
+				log.Errorf("Listing /%v is denied. If credentials only allow reading objects, use --no-list", dh.inode.FullName())
+			}
 			return nil, err
 		}
 		// May be -1 if we remove inodes in loadListing
 		dh.checkDirPosition()
 	}
 
-	if dh.lastInternalOffset-2 >= len(dh.inode.dir.Children) {
-		// we've reached the end
-		parent.dir.listDone = false
-		if parent.dir.forgetDuringList {
-			parent.dir.DirTime = time.Time{}
-			parent.dir.Gaps = nil
+	for {
+		if dh.lastInternalOffset-2 >= len(dh.inode.dir.Children) {
+			// we've reached the end
+			parent.dir.listDone = false
+			if parent.dir.forgetDuringList {
+				parent.dir.DirTime = time.Time{}
+				parent.dir.Gaps = nil
+			}
+			return
 		}
-		return
-	}
 
-	child := dh.inode.dir.Children[dh.lastInternalOffset-2]
-	if dh.inode.dir.lastFromCloud != nil && child.Name == *dh.inode.dir.lastFromCloud {
-		dh.inode.dir.lastFromCloud = nil
-	}
+		child := dh.inode.dir.Children[dh.lastInternalOffset-2]
+		if dh.inode.dir.lastFromCloud != nil && child.Name == *dh.inode.dir.lastFromCloud {
+			dh.inode.dir.lastFromCloud = nil
+		}
+		if child.dir != nil && (child.dir.timeTravel || child.dir.selectDir) {
+			// --time-travel and --s3-select views are only accessible by name
+			dh.lastInternalOffset++
+			continue
+		}
+		dh.readName = child.Name
+		dh.readCookie = dh.inode.dir.nameCookie(child.Name)
 
-	return child, nil
+		return child, nil
+	}
 }
 
 func (dh *DirHandle) CloseDir() error {
@@ -864,6 +1014,7 @@ func (dh *DirHandle) CloseDir() error {
 			dh.inode.Parent.addModified(-1)
 		}
 	}
+	dh.inode.dir.trimCookies()
 	dh.inode.mu.Unlock()
 	return nil
 }
@@ -1112,10 +1263,16 @@ func (parent *Inode) getChildName(name string) string {
 }
 
 func (parent *Inode) Unlink(name string) (err error) {
+	if parent.readOnlyView() || parent.isCloneEntry(name) {
+		return syscall.EROFS
+	}
 	parent.mu.Lock()
 	defer parent.mu.Unlock()
 
 	inode := parent.findChildUnlocked(name)
+	if inode != nil && inode.metaNode {
+		return parent.unlinkNode(inode)
+	}
 	if inode != nil {
 		fuseLog.Debugf("Unlink %v", inode.FullName())
 		inode.mu.Lock()
@@ -1138,6 +1295,20 @@ func (inode *Inode) SendDelete() {
 		inode.oldParent = nil
 		inode.oldName = ""
 	}
+	// Also remove the entries from --dir-meta-file
+	var metaKey string
+	metaParent, metaName := inode.Parent, inode.Name
+	if oldParent != nil {
+		metaParent, metaName = oldParent, oldName
+	}
+	if inode.isDir() && inode.dir.metaStored != nil && !inode.fs.flags.MetaReadOnly {
+		_, metaKey = metaParent.cloud()
+		metaKey = appendChildName(metaKey, dirMetaName)
+	} else if !inode.isDir() {
+		inode.dropObjectMeta(metaParent, metaName)
+		inode.dropAtime(metaParent, metaName)
+		inode.dropMetaXattrs()
+	}
 	implicit := false
 	if inode.isDir() {
 		implicit = inode.dir.ImplicitDir
@@ -1153,10 +1324,23 @@ func (inode *Inode) SendDelete() {
 		if !implicit {
 			inode.fs.addInflightChange(key)
 			_, err = cloud.DeleteBlob(&DeleteBlobInput{
-				Key: key,
+				Key:  key,
+				OpID: inode.changeOp(),
 			})
 			inode.fs.completeInflightChange(key)
 		}
+		if (err == nil || mapAwsError(err) == syscall.ENOENT) && inode.isDir() && !cloud.Capabilities().DirBlob {
+			if markerErr := inode.fs.deleteDirMarkers(cloud, key); markerErr != nil {
+				err = markerErr
+			}
+		}
+		if (err == nil || mapAwsError(err) == syscall.ENOENT) && metaKey != "" {
+			metaErr := updateDirMeta(cloud, metaKey,
+				setDirMetaEntry(metaDirs, metaName, nil), setDirMetaEntry(metaXattrs, metaName, nil))
+			if metaErr != nil {
+				err = metaErr
+			}
+		}
 		inode.mu.Lock()
 		atomic.AddInt64(&inode.Parent.fs.activeFlushers, -1)
 		inode.IsFlushing -= inode.fs.flags.MaxParallelParts
@@ -1171,6 +1355,9 @@ func (inode *Inode) SendDelete() {
 			inode.fs.WakeupFlusher()
 			return
 		}
+		if !implicit {
+			inode.fs.recordChange("delete", key, "", "", 0)
+		}
 		forget := false
 		if inode.CacheState == ST_DELETED {
 			inode.resetCache()
@@ -1210,6 +1397,13 @@ func (parent *Inode) CreateOrOpen(name string, open bool) (inode *Inode, fh *Fil
 
 	parent.logFuse("Create", name, open)
 
+	if isInvalidChildName(name) {
+		return nil, nil, syscall.EINVAL
+	}
+	if parent.readOnlyView() {
+		return nil, nil, syscall.EROFS
+	}
+
 	fs := parent.fs
 
 	parent.mu.Lock()
@@ -1224,7 +1418,7 @@ func (parent *Inode) CreateOrOpen(name string, open bool) (inode *Inode, fh *Fil
 		return nil, nil, syscall.EEXIST
 	}
 
-	now := time.Now()
+	now := clock.Now()
 	inode = NewInode(fs, parent, name)
 	inode.userMetadata = make(map[string][]byte)
 	inode.mu.Lock()
@@ -1237,6 +1431,7 @@ func (parent *Inode) CreateOrOpen(name string, open bool) (inode *Inode, fh *Fil
 		Gid:   fs.flags.Gid,
 		Mode:  fs.flags.FileMode,
 	}
+	inode.setBtime(now)
 	// one ref is for lookup
 	inode.Ref()
 	// another ref is for being in Children
@@ -1259,6 +1454,13 @@ func (parent *Inode) MkDir(
 
 	parent.logFuse("MkDir", name)
 
+	if isInvalidChildName(name) {
+		return nil, syscall.EINVAL
+	}
+	if parent.readOnlyView() {
+		return nil, syscall.EROFS
+	}
+
 	parent.mu.Lock()
 	defer parent.mu.Unlock()
 
@@ -1313,11 +1515,11 @@ func (parent *Inode) doMkDir(name string) (inode *Inode) {
 				oldInode.refcnt = 0
 				oldInode.Ref()
 				oldInode.SetCacheState(ST_MODIFIED)
-				oldInode.Attributes.Ctime = time.Now()
+				oldInode.Attributes.Ctime = clock.Now()
 				if parent.Attributes.Ctime.Before(oldInode.Attributes.Ctime) {
 					parent.Attributes.Ctime = oldInode.Attributes.Ctime
 				}
-				oldInode.Attributes.Mtime = time.Now()
+				oldInode.Attributes.Mtime = clock.Now()
 				if parent.Attributes.Mtime.Before(oldInode.Attributes.Mtime) {
 					parent.Attributes.Mtime = oldInode.Attributes.Mtime
 				}
@@ -1348,7 +1550,7 @@ func (parent *Inode) doMkDir(name string) (inode *Inode) {
 	} else {
 		inode.dir.ImplicitDir = true
 	}
-	inode.SetAttrTime(time.Now())
+	inode.SetAttrTime(clock.Now())
 	return
 }
 
@@ -1357,6 +1559,13 @@ func (parent *Inode) CreateSymlink(
 
 	parent.logFuse("CreateSymlink", name)
 
+	if isInvalidChildName(name) {
+		return nil, syscall.EINVAL
+	}
+	if parent.readOnlyView() {
+		return nil, syscall.EROFS
+	}
+
 	fs := parent.fs
 
 	parent.mu.Lock()
@@ -1367,10 +1576,23 @@ func (parent *Inode) CreateSymlink(
 		return nil, syscall.EEXIST
 	}
 
-	now := time.Now()
+	if fs.flags.MaxSymlinksPerDir > 0 {
+		if n := parent.countSymlinksUnlocked(); n >= fs.flags.MaxSymlinksPerDir {
+			log.Warnf("Refusing to create symlink %v: directory already has %v symlinks (--max-symlinks-per-dir)",
+				parent.getChildName(name), n)
+			return nil, syscall.EDQUOT
+		}
+	}
+
+	now := clock.Now()
 	inode = NewInode(fs, parent, name)
 	inode.userMetadata = make(map[string][]byte)
 	inode.userMetadata[inode.fs.flags.SymlinkAttr] = []byte(target)
+	if fs.flags.SymlinkRootAttr != "" {
+		if rootPath := fs.symlinkRootPath(target); rootPath != "" {
+			inode.userMetadata[fs.flags.SymlinkRootAttr] = []byte(rootPath)
+		}
+	}
 	inode.userMetadataDirty = 2
 	inode.mu.Lock()
 	defer inode.mu.Unlock()
@@ -1382,6 +1604,7 @@ func (parent *Inode) CreateSymlink(
 		Gid:   fs.flags.Gid,
 		Mode:  fs.flags.FileMode,
 	}
+	inode.setBtime(now)
 	// one ref is for lookup
 	inode.Ref()
 	// another ref is for being in Children
@@ -1394,6 +1617,20 @@ func (parent *Inode) CreateSymlink(
 	return inode, nil
 }
 
+// countSymlinksUnlocked counts symlinks among known children of the directory
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) countSymlinksUnlocked() int {
+	n := 0
+	for _, child := range parent.dir.Children {
+		child.mu.Lock()
+		if child.userMetadata[parent.fs.flags.SymlinkAttr] != nil {
+			n++
+		}
+		child.mu.Unlock()
+	}
+	return n
+}
+
 func (inode *Inode) ReadSymlink() (target string, err error) {
 	inode.mu.Lock()
 	defer inode.mu.Unlock()
@@ -1402,6 +1639,12 @@ func (inode *Inode) ReadSymlink() (target string, err error) {
 		return "", syscall.EIO
 	}
 
+	if attr := inode.fs.flags.SymlinkRootAttr; attr != "" && inode.userMetadata[attr] != nil {
+		if target, ok := inode.fs.translateSymlinkRoot(string(inode.userMetadata[attr])); ok {
+			return target, nil
+		}
+	}
+
 	return string(inode.userMetadata[inode.fs.flags.SymlinkAttr]), nil
 }
 
@@ -1415,7 +1658,8 @@ func (dir *Inode) SendMkDir() {
 		Key:      key,
 		Body:     nil,
 		DirBlob:  true,
-		Metadata: escapeMetadata(dir.userMetadata),
+		Metadata: dir.fs.stampWriter(escapeMetadata(dir.userMetadata)),
+		OpID:     dir.changeOp(),
 	}
 	dir.dir.ImplicitDir = false
 	dir.IsFlushing += dir.fs.flags.MaxParallelParts
@@ -1432,9 +1676,10 @@ func (dir *Inode) SendMkDir() {
 			dir.fs.WakeupFlusher()
 			return
 		}
+		dir.fs.recordChange("put", key, "", "", 0)
 		if dir.CacheState == ST_CREATED || dir.CacheState == ST_MODIFIED {
 			dir.SetCacheState(ST_CACHED)
-			dir.SetAttrTime(time.Now())
+			dir.SetAttrTime(clock.Now())
 		}
 		dir.fs.WakeupFlusher()
 	}()
@@ -1483,11 +1728,17 @@ func (inode *Inode) doUnlink() {
 	}
 	inode.Attributes.Size = 0
 
+	if inode.fs.inodeMap != nil {
+		inode.fs.inodeMap.Delete(inode.mapPath())
+	}
 	parent.removeChildUnlocked(inode)
 }
 
 func (parent *Inode) RmDir(name string) (err error) {
 	parent.logFuse("Rmdir", name)
+	if parent.readOnlyView() || parent.isCloneEntry(name) {
+		return syscall.EROFS
+	}
 
 	// we know this entry is gone
 	parent.mu.Lock()
@@ -1537,8 +1788,13 @@ func (inode *Inode) SetCacheState(state int32) {
 		if wasModified {
 			inc = -1
 			inode.fs.inodeQueue.Delete(inode.dirtyQueueId)
+			inode.dirtySince = time.Time{}
+			inode.dirtyAlerted = false
+			// Reset flushes forced by --max-dirty-age
+			inode.forceFlush = false
 		} else {
 			inode.dirtyQueueId = inode.fs.inodeQueue.Add(uint64(inode.Id))
+			inode.dirtySince = clock.Now()
 		}
 		inode.Parent.addModified(inc)
 	}
@@ -1565,6 +1821,25 @@ func (parent *Inode) addModified(inc int64) {
 // LOCKS_EXCLUDED(parent.mu)
 // LOCKS_EXCLUDED(newParent.mu)
 func (parent *Inode) Rename(from string, newParent *Inode, to string) (err error) {
+	if isInvalidChildName(to) {
+		return syscall.EINVAL
+	}
+	if parent.readOnlyView() || newParent.readOnlyView() || parent.isCloneEntry(from) {
+		return syscall.EROFS
+	}
+	// The journal is stored before taking the locks, so that directories
+	// aren't blocked during the request. It's removed if the rename fails
+	journal, err := parent.prepareRenameJournal(from, newParent, to)
+	if err != nil {
+		return err
+	}
+	if journal != nil {
+		defer func() {
+			if err != nil || !journal.used {
+				go parent.fs.abortRenameJournal(journal)
+			}
+		}()
+	}
 	if parent == newParent {
 		parent.mu.Lock()
 		defer parent.mu.Unlock()
@@ -1584,8 +1859,8 @@ func (parent *Inode) Rename(from string, newParent *Inode, to string) (err error
 	fromCloud, fromPath := parent.cloud()
 	toCloud, toPath := newParent.cloud()
 	if fromCloud != toCloud {
-		// cannot rename across cloud backend
-		err = syscall.EINVAL
+		// cannot rename across cloud backend, let mv copy files
+		err = syscall.EXDEV
 		return
 	}
 
@@ -1613,10 +1888,25 @@ func (parent *Inode) Rename(from string, newParent *Inode, to string) (err error
 			return syscall.EISDIR
 		}
 	}
+	if r, ok := fromCloud.(*routeBackend); ok &&
+		!r.sameRoutes(appendChildName(fromPath, from), appendChildName(toPath, to), fromInode.isDir()) {
+		// --route would move objects to another backend, let mv copy them
+		return syscall.EXDEV
+	}
+	if fromInode.metaNode {
+		return parent.renameNode(fromInode, newParent, to, toInode)
+	}
+	if toInode != nil && toInode.metaNode {
+		err = newParent.storeNode(to, nil)
+		if err != nil {
+			return err
+		}
+	}
 
 	fromFullName := appendChildName(fromPath, from)
 	toFullName := appendChildName(toPath, to)
 
+	replacedXattrs := false
 	if toInode != nil {
 		// this file's been overwritten, it's been detached but we can't delete
 		// it just yet, because the kernel will still send forget ops to us
@@ -1624,6 +1914,7 @@ func (parent *Inode) Rename(from string, newParent *Inode, to string) (err error
 		if toInode.isDir() {
 			toInode.doUnlink()
 		} else {
+			replacedXattrs = !toInode.metaXattrsLoaded || len(toInode.metaXattrs) > 0
 			// Do not unlink target file if it's a file to make situation where the old
 			// file is already deleted, but the new one is not uploaded yet, impossible
 			newParent.removeChildUnlocked(toInode)
@@ -1646,9 +1937,49 @@ func (parent *Inode) Rename(from string, newParent *Inode, to string) (err error
 				return mapAwsError(err)
 			}
 		}
+		if fromInode.fs.flags.DirMetaFile {
+			txn := NewMetaTxn(fromCloud)
+			fromInode.stageDirMetaMoves(txn, strings.TrimSuffix(fromFullName, "/"), strings.TrimSuffix(toFullName, "/"))
+			if fromInode.fs.flags.DirMetaSpecials {
+				fromInode.stageNodeMoves(txn, strings.TrimSuffix(toFullName, "/"))
+			}
+			if fromInode.fs.flags.MetaReadOnly && len(txn.objects) > 0 {
+				return syscall.EROFS
+			}
+			err = txn.Commit()
+			if err != nil {
+				log.Warnf("Failed to move metadata of %v: %v", fromFullName, err)
+				return err
+			}
+		}
+		if fromInode.fs.flags.RenameJournal && (journal == nil || journal.From != fromFullName || journal.To != toFullName) {
+			// The source was changed after the journal was prepared, store
+			// it now. It must be stored before any object is copied
+			if journal != nil {
+				go fromInode.fs.abortRenameJournal(journal)
+			}
+			journal, err = fromInode.fs.startRenameJournal(fromCloud, fromFullName, toFullName)
+			if err != nil {
+				log.Warnf("Failed to store rename journal of %v: %v", fromFullName, err)
+				return err
+			}
+		}
+		if journal != nil {
+			journal.used = true
+		}
 		renameRecursive(fromInode, newParent, to)
+		if journal != nil {
+			toDir := newParent.findChildUnlocked(to)
+			toDir.mu.Lock()
+			pending := toDir.countRenaming()
+			toDir.mu.Unlock()
+			fromInode.fs.renameJournalReady(fromCloud, journal, pending)
+		}
 	} else {
+		fromInode.dropObjectMeta(parent, from)
+		fromInode.dropAtime(parent, from)
 		renameInCache(fromInode, newParent, to)
+		fromInode.moveMetaXattrs(parent, from, replacedXattrs)
 	}
 
 	fromInode.fs.WakeupFlusher()
@@ -1660,6 +1991,11 @@ func renameRecursive(fromInode *Inode, newParent *Inode, to string) {
 	toDir := newParent.doMkDir(to)
 	toDir.userMetadata = fromInode.userMetadata
 	toDir.dir.ImplicitDir = fromInode.dir.ImplicitDir
+	// Entries in --dir-meta-file are already moved
+	toDir.Attributes.Mode = fromInode.Attributes.Mode
+	toDir.Attributes.Uid = fromInode.Attributes.Uid
+	toDir.Attributes.Gid = fromInode.Attributes.Gid
+	toDir.dir.metaStored = fromInode.dir.metaStored
 	fromInode.doUnlink()
 	// Trick IDs
 	// TODO: Fix potential race condition when Flusher goroutine retrieves an ID from
@@ -1674,16 +2010,26 @@ func renameRecursive(fromInode *Inode, newParent *Inode, to string) {
 	fs.inodes[newId] = fromInode
 	fs.inodes[oldId] = toDir
 	fs.mu.Unlock()
-	oldQId := fromInode.dirtyQueueId
-	fromInode.dirtyQueueId = toDir.dirtyQueueId
-	toDir.dirtyQueueId = oldQId
+	// Queue entries hold inode IDs, so dirty inodes are queued again with
+	// their new IDs. With --no-dir-object toDir is created clean while
+	// fromInode is deleted, so swapping the entries would lose fromInode
+	for _, inode := range []*Inode{fromInode, toDir} {
+		fs.inodeQueue.Delete(inode.dirtyQueueId)
+		if inode.CacheState == ST_CREATED || inode.CacheState == ST_DELETED || inode.CacheState == ST_MODIFIED {
+			inode.dirtyQueueId = fs.inodeQueue.Add(uint64(inode.Id))
+		}
+	}
 	// Swap reference counts - the kernel will still send forget ops for the new inode
 	fromInode.refcnt, toDir.refcnt = toDir.refcnt, fromInode.refcnt
+	// After dirty queue IDs are swapped
+	toDir.checkDirMeta()
 	for len(fromInode.dir.Children) > 0 {
 		child := fromInode.dir.Children[0]
 		child.mu.Lock()
 		if child.isDir() {
 			renameRecursive(child, toDir, child.Name)
+		} else if child.metaNode {
+			moveNodeInCache(child, toDir, child.Name)
 		} else {
 			renameInCache(child, toDir, child.Name)
 		}
@@ -1766,6 +2112,14 @@ func renameInCache(fromInode *Inode, newParent *Inode, to string) {
 			}
 		}
 	}
+	if fromInode.fs.inodeMap != nil {
+		from := fromInode.mapPath()
+		to := newParent.getChildName(to)
+		if fromInode.isDir() {
+			to += "/"
+		}
+		fromInode.fs.inodeMap.Rename(from, to)
+	}
 	fromInode.Ref()
 	parent.removeChildUnlocked(fromInode)
 	if fromInode.fileHandles > 0 {
@@ -1807,7 +2161,15 @@ func sealPastDirs(dirs map[*Inode]bool, d *Inode) {
 func (parent *Inode) insertSubTree(path string, obj *BlobItemOutput, dirs map[*Inode]bool) {
 	fs := parent.fs
 	slash := strings.Index(path, "/")
-	if slash == -1 {
+	if dirName, isMarker := fs.dirMarker(path); slash == -1 && isMarker {
+		if dirName != "" && !isInvalidName(dirName) {
+			parent.touchDirChild(dirName)
+		}
+		sealPastDirs(dirs, parent)
+	} else if slash == -1 && fs.isDirMeta(path) {
+		parent.noteDirMeta(obj, path)
+		sealPastDirs(dirs, parent)
+	} else if slash == -1 {
 		inode := parent.findChildUnlocked(path)
 		if inode == nil {
 			// don't revive deleted items
@@ -1817,9 +2179,15 @@ func (parent *Inode) insertSubTree(path string, obj *BlobItemOutput, dirs map[*I
 				// our locking order is parent before child, inode before fs. try to respect it
 				fs.insertInode(parent, inode)
 				inode.SetFromBlobItem(obj)
+				parent.applyObjectMeta(inode)
+				parent.applyAtime(inode)
+				parent.applyMetaXattrs(inode)
 			}
 		} else {
 			inode.SetFromBlobItem(obj)
+			parent.applyObjectMeta(inode)
+			parent.applyAtime(inode)
+			parent.applyMetaXattrs(inode)
 		}
 		sealPastDirs(dirs, parent)
 	} else {
@@ -1859,7 +2227,7 @@ func (parent *Inode) insertSubTree(path string, obj *BlobItemOutput, dirs map[*I
 				inode.SetFromBlobItem(obj)
 				sealPastDirs(dirs, inode)
 			} else {
-				now := time.Now()
+				now := clock.Now()
 				if inode.AttrTime.Before(now) {
 					inode.SetAttrTime(now)
 				}
@@ -1895,12 +2263,28 @@ func (parent *Inode) findChildMaxTime() (maxMtime, maxCtime time.Time) {
 }
 
 func (parent *Inode) LookUpCached(name string) (inode *Inode, err error) {
+	if isInvalidChildName(name) {
+		return nil, syscall.ENOENT
+	}
+	if parent.fs.flags.TimeTravel {
+		if inode, ok, err := parent.lookUpTimeTravel(name); ok {
+			return inode, err
+		}
+	}
+	if parent.fs.flags.S3Select {
+		if inode, ok, err := parent.lookUpSelect(name); ok {
+			return inode, err
+		}
+	}
+	if parent.fs.flags.Clones {
+		parent.checkClone()
+	}
 	parent.mu.Lock()
 	ok := false
 	inode = parent.findChildUnlocked(name)
 	if inode != nil {
 		ok = true
-		if expired(inode.AttrTime, parent.fs.flags.StatCacheTTL) {
+		if !inode.metaNode && expired(inode.AttrTime, inode.statTTL()) {
 			ok = false
 			if inode.CacheState != ST_CACHED ||
 				inode.isDir() && atomic.LoadInt64(&inode.dir.ModifiedChildren) > 0 {
@@ -1923,7 +2307,7 @@ func (parent *Inode) LookUpCached(name string) (inode *Inode, err error) {
 				return nil, syscall.ENOENT
 			}
 		}
-		if !expired(parent.dir.DirTime, parent.fs.flags.StatCacheTTL) {
+		if parent.dir.frozen || !expired(parent.dir.DirTime, parent.fs.flags.StatCacheTTL) {
 			// Don't recheck from the server if directory cache is actual
 			parent.mu.Unlock()
 			return nil, syscall.ENOENT
@@ -1957,11 +2341,20 @@ func (parent *Inode) recheckInode(inode *Inode, name string) (newInode *Inode, e
 func (parent *Inode) LookUp(name string, doSlurp bool) (*Inode, error) {
 	_, parentKey := parent.cloud()
 	key := appendChildName(parentKey, name)
+	if parent.fs.isTempKey(key) || parent.fs.isTempKey(key+"/") {
+		return nil, nil
+	}
+	if parent.dir.frozen {
+		parent.mu.Lock()
+		inode := parent.findChildUnlocked(name)
+		parent.mu.Unlock()
+		return inode, nil
+	}
 	root := parent
 	for root != nil && root.dir.cloud == nil {
 		root = root.Parent
 	}
-	expire := time.Now().Add(-parent.fs.flags.StatCacheTTL)
+	expire := clock.Now().Add(-parent.fs.flags.StatCacheTTL)
 	root.mu.Lock()
 	loaded := root.dir.checkGapLoaded(key, expire) && root.dir.checkGapLoaded(key+"/", expire)
 	root.mu.Unlock()
@@ -1971,7 +2364,7 @@ func (parent *Inode) LookUp(name string, doSlurp bool) (*Inode, error) {
 		parent.mu.Unlock()
 		return inode, nil
 	}
-	if doSlurp {
+	if doSlurp && !parent.fs.flags.NoList && !root.dir.cloud.Capabilities().UnsortedList {
 		// 99% of time it's impractical to do 2 HEAD requests per file when looking it up
 		// So we first try to preload a whole batch of files starting with our key
 		// If the file/directory is there, the listing result will highly likely contain it
@@ -2020,17 +2413,23 @@ func (parent *Inode) LookUpInodeMaybeDir(name string) (*BlobItemOutput, error) {
 	}
 	key := appendChildName(parentKey, name)
 	parent.logFuse("Inode.LookUp", key)
+	opID := parent.lastOp()
 
-	var object, dirObject *HeadBlobOutput
+	var object, dirObject, folderMarker *HeadBlobOutput
 	var prefixList *ListBlobsOutput
-	var objectError, dirError, prefixError error
-	results := make(chan int, 3)
+	var objectError, dirError, folderError, prefixError error
+	results := make(chan int, 4)
 	n := 0
+	// Without the list permission S3 returns 403 for missing objects
+	notFound := func(err error) bool {
+		code := mapAwsError(err)
+		return code == syscall.ENOENT || code == syscall.EACCES && parent.fs.flags.NoList
+	}
 
 	for {
 		n++
 		go func() {
-			object, objectError = cloud.HeadBlob(&HeadBlobInput{Key: key})
+			object, objectError = cloud.HeadBlob(&HeadBlobInput{Key: key, OpID: opID})
 			results <- 1
 		}()
 		if cloud.Capabilities().DirBlob {
@@ -2039,7 +2438,7 @@ func (parent *Inode) LookUpInodeMaybeDir(name string) (*BlobItemOutput, error) {
 		}
 		if parent.fs.flags.Cheap {
 			<-results
-			if mapAwsError(objectError) != syscall.ENOENT {
+			if !notFound(objectError) {
 				break
 			}
 		}
@@ -2047,24 +2446,39 @@ func (parent *Inode) LookUpInodeMaybeDir(name string) (*BlobItemOutput, error) {
 		if !parent.fs.flags.NoDirObject {
 			n++
 			go func() {
-				dirObject, dirError = cloud.HeadBlob(&HeadBlobInput{Key: key + "/"})
+				dirObject, dirError = cloud.HeadBlob(&HeadBlobInput{Key: key + "/", OpID: opID})
 				results <- 2
 			}()
 			if parent.fs.flags.Cheap {
 				<-results
-				if mapAwsError(dirError) != syscall.ENOENT {
+				if !notFound(dirError) {
 					break
 				}
 			}
 		}
 
-		if !parent.fs.flags.ExplicitDir {
+		if parent.fs.flags.FolderMarkers {
+			n++
+			go func() {
+				folderMarker, folderError = cloud.HeadBlob(&HeadBlobInput{Key: key + folderMarkerSuffix, OpID: opID})
+				results <- 4
+			}()
+			if parent.fs.flags.Cheap {
+				<-results
+				if !notFound(folderError) {
+					break
+				}
+			}
+		}
+
+		if !parent.fs.flags.ExplicitDir && !parent.fs.flags.NoList {
 			n++
 			go func() {
 				prefixList, prefixError = RetryListBlobs(parent.fs.flags, cloud, &ListBlobsInput{
 					Delimiter: PString("/"),
 					MaxKeys:   PUInt32(1),
 					Prefix:    PString(key + "/"),
+					OpID:      opID,
 				})
 				results <- 3
 			}()
@@ -2087,6 +2501,12 @@ func (parent *Inode) LookUpInodeMaybeDir(name string) (*BlobItemOutput, error) {
 		if dirObject != nil {
 			return &dirObject.BlobItemOutput, nil
 		}
+		if folderMarker != nil {
+			return &BlobItemOutput{
+				Key:          PString(key + "/"),
+				LastModified: folderMarker.LastModified,
+			}, nil
+		}
 		if prefixList != nil && (len(prefixList.Prefixes) != 0 || len(prefixList.Items) != 0) {
 			if len(prefixList.Items) != 0 && (*prefixList.Items[0].Key == key ||
 				(*prefixList.Items[0].Key)[0:len(key)+1] == key+"/") {
@@ -2098,13 +2518,16 @@ func (parent *Inode) LookUpInodeMaybeDir(name string) (*BlobItemOutput, error) {
 		}
 	}
 
-	if objectError != nil && mapAwsError(objectError) != syscall.ENOENT {
+	if objectError != nil && !notFound(objectError) {
 		return nil, objectError
 	}
-	if dirError != nil && mapAwsError(dirError) != syscall.ENOENT {
+	if dirError != nil && !notFound(dirError) {
 		return nil, dirError
 	}
-	if prefixError != nil && mapAwsError(prefixError) != syscall.ENOENT {
+	if folderError != nil && !notFound(folderError) {
+		return nil, folderError
+	}
+	if prefixError != nil && !notFound(prefixError) {
 		return nil, prefixError
 	}
 	return nil, syscall.ENOENT
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// Applications scanning large directories may check whether a directory
+// changed since their last scan without listing it themselves: its
+// generation is a hash of names, ETags and sizes of its entries and of the
+// ETag of its directory object, as stored in the bucket. It changes whenever
+// an entry is added, removed or replaced, or the directory's metadata
+// changes, and is the same on every mount. Changes of subdirectories don't
+// change the generation of their parent.
+
+const generationXattr = "user.geesefs.generation"
+
+// DirGeneration lists the directory in the backend, bypassing and not
+// changing the cache, and returns its generation
+// LOCKS_EXCLUDED(inode.mu)
+func (inode *Inode) DirGeneration() (uint64, error) {
+	if !inode.isDir() {
+		return 0, syscall.ENOTDIR
+	}
+	inode.mu.Lock()
+	cloud, prefix := inode.cloud()
+	inode.mu.Unlock()
+	if cloud == nil {
+		// Stale inode
+		return 0, syscall.ESTALE
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	fs := inode.fs
+	// name => ETag and size, prefixes may be returned again on the next page
+	entries := make(map[string]string)
+	req := &ListBlobsInput{
+		Prefix:    PString(prefix),
+		Delimiter: PString("/"),
+	}
+	for {
+		resp, err := RetryListBlobs(fs.flags, cloud, req)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range resp.Prefixes {
+			if !fs.isTempKey(*p.Prefix) {
+				entries[(*p.Prefix)[len(prefix):]] = ""
+			}
+		}
+		for _, item := range resp.Items {
+			if !fs.isTempKey(*item.Key) {
+				entries[(*item.Key)[len(prefix):]] = NilStr(item.ETag) + " " + strconv.FormatUint(item.Size, 10)
+			}
+		}
+		if !resp.IsTruncated {
+			break
+		}
+		if resp.NextContinuationToken != nil {
+			req.ContinuationToken = resp.NextContinuationToken
+			req.StartAfter = nil
+		} else {
+			last, _ := maxName(resp, len(resp.Items), len(resp.Prefixes))
+			if last == "" || last <= NilStr(req.StartAfter) {
+				break
+			}
+			req.StartAfter = PString(last)
+		}
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(entries[name]))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil)), nil
+}
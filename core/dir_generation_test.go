@@ -0,0 +1,61 @@
+package core
+
+import (
+	"strconv"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type DirGenerationTest struct{}
+
+var _ = Suite(&DirGenerationTest{})
+
+func (s *DirGenerationTest) TestDirGenerationNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = time.Hour
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	c.Store.Put("dir/file", []byte("1"), nil)
+	c.Store.Put("dir/sub/file", []byte("1"), nil)
+	dir, err := a.fs.LookupPath("dir")
+	t.Assert(err, IsNil)
+	gen, err := dir.DirGeneration()
+	t.Assert(err, IsNil)
+
+	// Same on every mount
+	dirB, err := b.fs.LookupPath("dir")
+	t.Assert(err, IsNil)
+	genB, err := dirB.DirGeneration()
+	t.Assert(err, IsNil)
+	t.Assert(genB, Equals, gen)
+	value, err := dir.GetXattr(generationXattr)
+	t.Assert(err, IsNil)
+	t.Assert(string(value), Equals, strconv.FormatUint(gen, 10))
+
+	// Changes of subdirectories don't count
+	c.Store.Put("dir/sub/other", []byte("2"), nil)
+	next, err := dir.DirGeneration()
+	t.Assert(err, IsNil)
+	t.Assert(next, Equals, gen)
+
+	// Replaced and new entries do, even if they aren't known to the mount
+	c.Store.Put("dir/file", []byte("2"), nil)
+	next, err = dir.DirGeneration()
+	t.Assert(err, IsNil)
+	t.Assert(next, Not(Equals), gen)
+	gen = next
+	t.Assert(b.WriteAndSync("dir/new", []byte("3")), IsNil)
+	next, err = dir.DirGeneration()
+	t.Assert(err, IsNil)
+	t.Assert(next, Not(Equals), gen)
+
+	file, err := a.fs.LookupPath("dir/file")
+	t.Assert(err, IsNil)
+	_, err = file.DirGeneration()
+	t.Assert(err, NotNil)
+}
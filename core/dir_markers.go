@@ -0,0 +1,80 @@
+package core
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Other tools mark directories differently: Hadoop and old versions of the
+// AWS console create "dir_$folder$" objects next to the directory, and some
+// tools keep empty directories with "dir/.keep" objects. With --dir-markers,
+// such markers show up as directories or are hidden inside them, and are
+// removed together with their directories.
+
+const (
+	folderMarkerSuffix = "_$folder$"
+	keepMarkerName     = ".keep"
+)
+
+// dirMarker checks if name is a directory marker object. dir is the name of
+// the marked directory, or "" if it's the directory containing the marker.
+func (fs *Goofys) dirMarker(name string) (dir string, isMarker bool) {
+	if fs.flags.KeepMarkers && name == keepMarkerName {
+		return "", true
+	}
+	if fs.flags.FolderMarkers && len(name) > len(folderMarkerSuffix) &&
+		strings.HasSuffix(name, folderMarkerSuffix) {
+		return name[0 : len(name)-len(folderMarkerSuffix)], true
+	}
+	return "", false
+}
+
+// dirMarkerKeys returns keys of possible markers of the directory key,
+// including its --dir-meta-file object which would revive it too
+func (fs *Goofys) dirMarkerKeys(key string) (keys []string) {
+	key = strings.TrimSuffix(key, "/")
+	if fs.flags.FolderMarkers {
+		keys = append(keys, key+folderMarkerSuffix)
+	}
+	if fs.flags.KeepMarkers {
+		keys = append(keys, key+"/"+keepMarkerName)
+	}
+	if fs.flags.DirMetaFile {
+		keys = append(keys, key+"/"+dirMetaName, key+"/"+legacyDirMetaName)
+	}
+	return
+}
+
+// deleteDirMarkers removes markers of a removed or renamed directory
+func (fs *Goofys) deleteDirMarkers(cloud StorageBackend, key string) error {
+	for _, markerKey := range fs.dirMarkerKeys(key) {
+		fs.addInflightChange(markerKey)
+		_, err := cloud.DeleteBlob(&DeleteBlobInput{
+			Key: markerKey,
+		})
+		fs.completeInflightChange(markerKey)
+		if err != nil && mapAwsError(err) != syscall.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchDirChild adds a directory found in a listing or refreshes it
+// LOCKS_REQUIRED(parent.mu)
+// LOCKS_EXCLUDED(parent.fs.mu)
+func (parent *Inode) touchDirChild(dirName string) {
+	if inode := parent.findChildUnlocked(dirName); inode != nil {
+		now := clock.Now()
+		// don't want to update time if this
+		// inode is setup to never expire
+		if inode.AttrTime.Before(now) {
+			inode.SetAttrTime(now)
+		}
+	} else if _, deleted := parent.dir.DeletedChildren[dirName]; !deleted {
+		// don't revive deleted items
+		inode := NewInode(parent.fs, parent, dirName)
+		inode.ToDir()
+		parent.fs.insertInode(parent, inode)
+	}
+}
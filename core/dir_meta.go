@@ -0,0 +1,832 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// With --dir-meta-file, attributes which can't be stored in objects or are
+// expensive to get from them are stored in a .geesefs_meta JSON object in
+// every directory. The object is versioned and divided into sections which
+// map names of children to their entries:
+//
+//	{"version": 1,
+//	 "dirs": {"raw": {"mode": 488, "uid": 1000, "gid": 1000}},
+//	 "xattrs": {"raw": {"project": "x"}},
+//	 "symlinks": {"latest": {"etag": "\"d41d8...\"", "meta": {"--symlink-target": "run42"}}},
+//	 "specials": {"fifo": {"etag": "\"d41d8...\"", "meta": {"--mode": "4516"}}},
+//	 "nodes": {"pipe": {"mode": 4516, "uid": 1000, "gid": 1000, "mtime": 1714564800}},
+//	 "atimes": {"data.h5": 1714564800}}
+//
+// dirs holds mode, uid and gid of subdirectories and, with --no-dir-object,
+// xattrs holds their user metadata. Implicit directories have no objects, so
+// these would be lost otherwise when the inode is evicted. xattrs also holds
+// user xattrs of files which don't fit into the metadata of their objects,
+// see setMetaXattr. symlinks and
+// specials repeat the metadata of symlinks and special files, so that they
+// are known from a listing without a HEAD request for each of them. Such an
+// entry is only used while the object has the ETag it had when it was stored.
+// nodes holds special files which have no objects, see meta_nodes.go.
+// atimes holds access times of files with --atime lazy, see atime.go.
+//
+// The object is updated with a read-modify-write cycle using conditional PUTs
+// which is retried on conflicts. Backends without conditional PUTs use a lock
+// object instead, see meta_lock.go. Every change is a merge function applied to
+// one entry of one section of the latest version, so that concurrent changes
+// of different entries and features from several mounts are merged, and the
+// last change of each entry wins. Sections unknown to this version are kept as
+// is, and objects of newer versions are never overwritten. Objects of older
+// versions are upgraded with migrations, see meta_migrate.go.
+//
+// With --meta-readonly, mounts only read these objects. Changes which would
+// have to be stored in them fail with EROFS: attributes of directories, xattrs
+// of directories without objects and special files without objects. Entries
+// which only repeat the metadata of objects (symlinks and specials) and
+// entries of removed directories are left as they are.
+//
+// The object is hidden from listings and loaded in background when a listing
+// of the directory returns it with a new ETag. Entries are removed with their
+// children. Nodes, entries of directories and xattrs of files are moved on
+// rename with a MetaTxn, other entries of files are removed and stored again
+// with the new name.
+// The .geesefs_dirmeta object of older versions, which only held the
+// dirs section, is read if there's no .geesefs_meta yet and replaced by it on
+// the first change.
+
+const dirMetaName = ".geesefs_meta"
+const legacyDirMetaName = ".geesefs_dirmeta"
+const dirMetaRetries = 20
+
+// dirMetaVersion is the version of objects written by this version. It's
+// raised by registered migrations, see meta_migrate.go
+var dirMetaVersion = 1
+
+const (
+	metaDirs     = "dirs"
+	metaXattrs   = "xattrs"
+	metaSymlinks = "symlinks"
+	metaSpecials = "specials"
+	metaNodes    = "nodes"
+	metaAtimes   = "atimes"
+)
+
+type dirMetaEntry struct {
+	Mode uint32 `json:"mode"`
+	Uid  uint32 `json:"uid"`
+	Gid  uint32 `json:"gid"`
+}
+
+// objectMetaEntry is the escaped user metadata of an object with the ETag
+type objectMetaEntry struct {
+	ETag string             `json:"etag"`
+	Meta map[string]*string `json:"meta"`
+}
+
+// dirMetaSections are the sections of a .geesefs_meta object known to this
+// version
+type dirMetaSections struct {
+	Dirs     map[string]dirMetaEntry       `json:"dirs"`
+	Xattrs   map[string]map[string]*string `json:"xattrs"`
+	Symlinks map[string]objectMetaEntry    `json:"symlinks"`
+	Specials map[string]objectMetaEntry    `json:"specials"`
+	Nodes    map[string]nodeMetaEntry      `json:"nodes"`
+	Atimes   map[string]int64              `json:"atimes"`
+}
+
+// dirMetaObject is a .geesefs_meta object as stored. Sections are only
+// decoded by merge functions which change them
+type dirMetaObject map[string]json.RawMessage
+
+// dirMetaMerge changes a section of the latest version of the object. It
+// returns false if the section is already up to date
+type dirMetaMerge func(obj dirMetaObject) (bool, error)
+
+// setDirMetaEntry returns a merge which sets or, if entry is nil, removes
+// the entry of child name in a section
+func setDirMetaEntry(section, name string, entry interface{}) dirMetaMerge {
+	return func(obj dirMetaObject) (bool, error) {
+		var data json.RawMessage
+		if entry != nil {
+			var err error
+			data, err = json.Marshal(entry)
+			if err != nil {
+				return false, err
+			}
+		}
+		_, changed, err := obj.setEntry(section, name, data)
+		return changed, err
+	}
+}
+
+// setEntry sets or, if data is nil, removes the raw entry of child name in a
+// section. It returns the previous entry
+func (obj dirMetaObject) setEntry(section, name string, data json.RawMessage) (prev json.RawMessage, changed bool, err error) {
+	var entries map[string]json.RawMessage
+	if raw, ok := obj[section]; ok {
+		err := json.Unmarshal(raw, &entries)
+		if err != nil {
+			log.Warnf("Replacing invalid %v section of directory metadata: %v", section, err)
+			entries = nil
+		}
+	}
+	prev = entries[name]
+	if data == nil {
+		if prev == nil {
+			return nil, false, nil
+		}
+		delete(entries, name)
+	} else {
+		if bytes.Equal(prev, data) {
+			return prev, false, nil
+		}
+		if entries == nil {
+			entries = make(map[string]json.RawMessage)
+		}
+		entries[name] = data
+	}
+	if len(entries) == 0 {
+		delete(obj, section)
+		return prev, true, nil
+	}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return nil, false, err
+	}
+	obj[section] = raw
+	return prev, true, nil
+}
+
+func (fs *Goofys) isDirMeta(name string) bool {
+	return fs.flags.DirMetaFile && (name == dirMetaName || name == legacyDirMetaName || name == dirMetaLockName)
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dirMeta() dirMetaEntry {
+	return dirMetaEntry{
+		Mode: uint32(inode.Attributes.Mode & os.ModePerm),
+		Uid:  inode.Attributes.Uid,
+		Gid:  inode.Attributes.Gid,
+	}
+}
+
+// dirMetaReadOnly reports if attributes of the directory are stored in the
+// .geesefs_meta of its parent, which can't be changed with --meta-readonly
+func (inode *Inode) dirMetaReadOnly() bool {
+	flags := inode.fs.flags
+	return flags.MetaReadOnly && flags.DirMetaFile && inode.isDir() && inode.Parent != nil
+}
+
+// checkDirMeta schedules a flush of directory attributes if they differ
+// from the stored ones
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) checkDirMeta() {
+	fs := inode.fs
+	if !fs.flags.DirMetaFile || fs.flags.MetaReadOnly || !inode.isDir() || inode.Parent == nil {
+		return
+	}
+	stored := dirMetaEntry{
+		Mode: uint32(fs.flags.DirMode & os.ModePerm),
+		Uid:  fs.flags.Uid,
+		Gid:  fs.flags.Gid,
+	}
+	if inode.dir.metaStored != nil {
+		stored = *inode.dir.metaStored
+	}
+	inode.dir.metaDirty = inode.dirMeta() != stored ||
+		fs.flags.NoDirObject && inode.userMetadataDirty != 0
+	if inode.dir.metaDirty && inode.CacheState == ST_CACHED {
+		inode.SetCacheState(ST_MODIFIED)
+		fs.WakeupFlusher()
+	}
+}
+
+// applyDirMeta sets attributes loaded from the parent, unless there are
+// local changes which aren't stored yet
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) applyDirMeta(meta *dirMetaSections) {
+	if inode.dir.metaDirty {
+		return
+	}
+	if xattrs, ok := meta.Xattrs[inode.Name]; ok && inode.userMetadataDirty == 0 {
+		inode.setMetadata(xattrs)
+	}
+	if entry, ok := meta.Dirs[inode.Name]; ok {
+		inode.Attributes.Mode = os.ModeDir | os.FileMode(entry.Mode)&os.ModePerm
+		inode.Attributes.Uid = entry.Uid
+		inode.Attributes.Gid = entry.Gid
+		inode.dir.metaStored = &entry
+	}
+}
+
+// stageDirMetaMoves stages the moves of the dirs and xattrs entries of dir,
+// which is renamed from fromKey to toKey, of its subdirectories and of the
+// files in them in a MetaTxn. The old entry of dir is removed even if it isn't loaded, so that
+// it isn't applied to a new directory with the old name
+// LOCKS_REQUIRED(dir.mu)
+func (dir *Inode) stageDirMetaMoves(txn *MetaTxn, fromKey, toKey string) {
+	fs := dir.fs
+	type dirMetaMove struct {
+		from, to, name, newName string
+		entry                   *dirMetaEntry
+		xattrs                  map[string]*string
+		file                    bool
+	}
+	var moves []dirMetaMove
+	var walk func(d *Inode, fromKey, toKey string, top bool)
+	walk = func(d *Inode, fromKey, toKey string, top bool) {
+		fromDir, name := splitParentKey(fromKey)
+		toDir, newName := splitParentKey(toKey)
+		if d.dir.metaStored != nil || top && !fs.flags.MetaReadOnly {
+			m := dirMetaMove{
+				from:    appendChildName(fromDir, dirMetaName),
+				to:      appendChildName(toDir, dirMetaName),
+				name:    name,
+				newName: newName,
+				entry:   d.dir.metaStored,
+			}
+			if fs.flags.NoDirObject {
+				m.xattrs = escapeMetadata(d.userMetadata)
+			}
+			moves = append(moves, m)
+		}
+		for _, child := range d.dir.Children {
+			if child.isDir() {
+				child.mu.Lock()
+				walk(child, appendChildName(fromKey, child.Name), appendChildName(toKey, child.Name), false)
+				child.mu.Unlock()
+			} else if !child.metaNode {
+				child.mu.Lock()
+				xattrs := escapeMetadata(child.metaXattrs)
+				if !child.metaXattrsLoaded && d.dir.childMeta != nil {
+					xattrs = d.dir.childMeta.Xattrs[child.Name]
+				}
+				child.mu.Unlock()
+				if len(xattrs) > 0 {
+					moves = append(moves, dirMetaMove{
+						from:    appendChildName(fromKey, dirMetaName),
+						to:      appendChildName(toKey, dirMetaName),
+						name:    child.Name,
+						newName: child.Name,
+						xattrs:  xattrs,
+						file:    true,
+					})
+				}
+			}
+		}
+	}
+	walk(dir, fromKey, toKey, true)
+	for _, m := range moves {
+		if m.entry != nil {
+			txn.Set(m.to, metaDirs, m.newName, *m.entry)
+		}
+		if len(m.xattrs) > 0 {
+			txn.Set(m.to, metaXattrs, m.newName, m.xattrs)
+		}
+	}
+	for _, m := range moves {
+		if m.file {
+			txn.Set(m.from, metaXattrs, m.name, nil)
+			continue
+		}
+		txn.Set(m.from, metaDirs, m.name, nil)
+		if fs.flags.NoDirObject {
+			txn.Set(m.from, metaXattrs, m.name, nil)
+		}
+	}
+}
+
+// splitParentKey splits a key into the key of its parent and the name
+func splitParentKey(key string) (parent, name string) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
+
+// objectMetaSection returns the section which holds the metadata of the
+// inode if it's a symlink or a special file
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) objectMetaSection() string {
+	if !inode.fs.flags.DirMetaFile || inode.isDir() || inode.userMetadata == nil {
+		return ""
+	}
+	if inode.userMetadata[inode.fs.flags.SymlinkAttr] != nil {
+		return metaSymlinks
+	}
+	if inode.Attributes.Mode&os.ModeType != 0 {
+		return metaSpecials
+	}
+	return ""
+}
+
+// applyObjectMeta sets the metadata of a listed symlink or special file from
+// the .geesefs_meta of parent if the object wasn't changed since
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) applyObjectMeta(child *Inode) {
+	meta := parent.dir.childMeta
+	if meta == nil || child.isDir() {
+		return
+	}
+	entry, ok := meta.Symlinks[child.Name]
+	if !ok {
+		entry, ok = meta.Specials[child.Name]
+	}
+	if !ok {
+		return
+	}
+	child.mu.Lock()
+	if child.userMetadata == nil && child.CacheState == ST_CACHED && child.knownETag == entry.ETag {
+		child.setMetadata(entry.Meta)
+	}
+	child.mu.Unlock()
+}
+
+// storeObjectMeta repeats the metadata of a flushed symlink or special file
+// in the .geesefs_meta of its directory
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) storeObjectMeta(etag *string) {
+	section := inode.objectMetaSection()
+	if section == "" || etag == nil || inode.fs.flags.MetaReadOnly {
+		return
+	}
+	cloud, key := inode.Parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	name := inode.Name
+	entry := objectMetaEntry{ETag: *etag, Meta: escapeMetadata(inode.userMetadata)}
+	go func() {
+		err := updateDirMeta(cloud, key, setDirMetaEntry(section, name, entry))
+		if err != nil {
+			log.Warnf("Failed to store metadata of %v in %v: %v", name, key, err)
+			return
+		}
+		inode.fs.recordChange("put", key, "", "", 0)
+	}()
+}
+
+// dropObjectMeta removes the entry of a symlink or special file which is
+// deleted or renamed away from parent
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dropObjectMeta(parent *Inode, name string) {
+	section := inode.objectMetaSection()
+	if section == "" || inode.fs.flags.MetaReadOnly {
+		return
+	}
+	cloud, key := parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	go func() {
+		err := updateDirMeta(cloud, key, setDirMetaEntry(section, name, nil))
+		if err != nil {
+			log.Warnf("Failed to remove metadata of %v from %v: %v", name, key, err)
+		}
+	}()
+}
+
+// useMetaXattrs returns true if user xattrs of the inode which don't fit into
+// the object metadata may be stored in the .geesefs_meta of its directory
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) useMetaXattrs() bool {
+	return inode.fs.flags.DirMetaFile && !inode.isDir() && !inode.metaNode && inode.Parent != nil
+}
+
+// fillMetaXattrs loads the xattrs of a file stored in the .geesefs_meta of
+// its directory if they aren't known from a listing yet
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) fillMetaXattrs() error {
+	if !inode.useMetaXattrs() || inode.metaXattrsLoaded {
+		return nil
+	}
+	if inode.CacheState == ST_CREATED && inode.oldParent == nil {
+		inode.metaXattrsLoaded = true
+		return nil
+	}
+	cloud, key := inode.Parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	meta, _, _, err := getDirMeta(cloud, key)
+	if err == syscall.ENOENT {
+		inode.metaXattrsLoaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	inode.metaXattrs = nil
+	if xattrs, ok := meta.Xattrs[inode.Name]; ok {
+		inode.metaXattrs = unescapeMetadata(xattrs)
+	}
+	inode.metaXattrsLoaded = true
+	return nil
+}
+
+// applyMetaXattrs sets the xattrs of a file from the .geesefs_meta of parent
+// unless they were changed after it was loaded
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) applyMetaXattrs(child *Inode) {
+	meta := parent.dir.childMeta
+	if meta == nil || child.isDir() {
+		return
+	}
+	child.mu.Lock()
+	if child.metaXattrsTime.Before(parent.dir.childMetaTime) {
+		child.metaXattrs = nil
+		if xattrs, ok := meta.Xattrs[child.Name]; ok {
+			child.metaXattrs = unescapeMetadata(xattrs)
+		}
+		child.metaXattrsLoaded = true
+	}
+	child.mu.Unlock()
+}
+
+// noteNoDirMeta marks xattrs of all files as loaded after a full listing of
+// parent which didn't return a .geesefs_meta object, so that they aren't
+// loaded one by one
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) noteNoDirMeta() {
+	if !parent.fs.flags.DirMetaFile || parent.dir.childMeta != nil ||
+		parent.dir.childMetaLoad || parent.dir.childMetaETag != "" {
+		return
+	}
+	for _, child := range parent.dir.Children {
+		if !child.isDir() {
+			child.mu.Lock()
+			child.metaXattrsLoaded = true
+			child.mu.Unlock()
+		}
+	}
+}
+
+// setMetaXattr sets or, if value is nil, removes a user xattr of a file in
+// the .geesefs_meta of its directory. It's used for xattrs which don't fit
+// into the metadata of the object, and for xattrs already stored there. The
+// object isn't changed, unless the xattr moves from its metadata
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) setMetaXattr(name string, value []byte) error {
+	if inode.fs.flags.MetaReadOnly {
+		return syscall.EROFS
+	}
+	xattrs := make(map[string][]byte)
+	for k, v := range inode.metaXattrs {
+		if k != name {
+			xattrs[k] = v
+		}
+	}
+	if value != nil {
+		xattrs[name] = value
+	}
+	var entry interface{}
+	if len(xattrs) > 0 {
+		entry = escapeMetadata(xattrs)
+	}
+	cloud, key := inode.Parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	err := updateDirMeta(cloud, key, setDirMetaEntry(metaXattrs, inode.Name, entry))
+	if err != nil {
+		return mapAwsError(err)
+	}
+	inode.fs.recordChange("put", key, "", "", 0)
+	inode.metaXattrs = xattrs
+	inode.metaXattrsTime = clock.Now()
+	if _, ok := inode.userMetadata[name]; ok && value != nil {
+		delete(inode.userMetadata, name)
+		inode.userMetadataDirty = 2
+		if inode.CacheState == ST_CACHED {
+			inode.SetCacheState(ST_MODIFIED)
+			inode.fs.WakeupFlusher()
+		}
+	}
+	return nil
+}
+
+// dropMetaXattrs removes the xattrs entry of a deleted file
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) dropMetaXattrs() {
+	if !inode.useMetaXattrs() || inode.fs.flags.MetaReadOnly || inode.CacheState != ST_DELETED ||
+		inode.metaXattrsLoaded && len(inode.metaXattrs) == 0 {
+		return
+	}
+	inode.metaXattrs = nil
+	cloud, key := inode.Parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	name := inode.Name
+	go func() {
+		err := updateDirMeta(cloud, key, setDirMetaEntry(metaXattrs, name, nil))
+		if err != nil {
+			log.Warnf("Failed to remove xattrs of %v from %v: %v", name, key, err)
+		}
+	}()
+}
+
+// moveMetaXattrs moves the xattrs entry of a file renamed from name in
+// parent to its new name, replacing the entry of the overwritten file if
+// replaced is true. Unknown xattrs are looked up in background
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) moveMetaXattrs(parent *Inode, name string, replaced bool) {
+	fs := inode.fs
+	loaded := inode.metaXattrsLoaded
+	if !inode.useMetaXattrs() || fs.flags.MetaReadOnly || loaded && len(inode.metaXattrs) == 0 && !replaced {
+		return
+	}
+	cloud, fromKey := parent.cloud()
+	fromKey = appendChildName(fromKey, dirMetaName)
+	_, toKey := inode.Parent.cloud()
+	toKey = appendChildName(toKey, dirMetaName)
+	newName := inode.Name
+	xattrs := escapeMetadata(inode.metaXattrs)
+	inode.metaXattrsTime = clock.Now()
+	go func() {
+		if !loaded {
+			meta, _, _, err := getDirMeta(cloud, fromKey)
+			if err != nil && err != syscall.ENOENT {
+				log.Warnf("Failed to load xattrs of %v from %v: %v", name, fromKey, err)
+				return
+			}
+			if err == nil {
+				xattrs = meta.Xattrs[name]
+			}
+			if len(xattrs) == 0 && !replaced {
+				return
+			}
+		}
+		txn := NewMetaTxn(cloud)
+		if len(xattrs) > 0 {
+			txn.Set(toKey, metaXattrs, newName, xattrs)
+		} else {
+			txn.Set(toKey, metaXattrs, newName, nil)
+		}
+		txn.Set(fromKey, metaXattrs, name, nil)
+		err := txn.Commit()
+		if err != nil {
+			log.Warnf("Failed to move xattrs of %v to %v: %v", name, newName, err)
+			return
+		}
+		inode.mu.Lock()
+		inode.metaXattrsTime = clock.Now()
+		inode.mu.Unlock()
+	}()
+}
+
+func getBlobData(cloud StorageBackend, key string) (data []byte, etag string, err error) {
+	resp, err := cloud.GetBlob(&GetBlobInput{Key: key})
+	if err != nil {
+		return nil, "", mapAwsError(err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, NilStr(resp.ETag), nil
+}
+
+func legacyDirMetaKey(key string) string {
+	return strings.TrimSuffix(key, dirMetaName) + legacyDirMetaName
+}
+
+// readDirMetaObject loads the .geesefs_meta object key, or the
+// .geesefs_dirmeta object next to it as version 0, and upgrades it to the
+// current version. It returns the version as stored
+func readDirMetaObject(cloud StorageBackend, key string) (obj dirMetaObject, etag string, version int, err error) {
+	legacy := false
+	data, etag, err := getBlobData(cloud, key)
+	if err == syscall.ENOENT {
+		legacy = true
+		data, etag, err = getBlobData(cloud, legacyDirMetaKey(key))
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if legacy {
+		obj = make(dirMetaObject)
+		if json.Valid(data) {
+			obj[metaDirs] = data
+		}
+		obj["version"], _ = json.Marshal(0)
+	} else {
+		err = json.Unmarshal(data, &obj)
+		if err != nil || obj == nil {
+			log.Warnf("Ignoring invalid directory metadata in %v: %v", key, err)
+			obj = make(dirMetaObject)
+		}
+	}
+	version, err = obj.upgrade(key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return obj, etag, version, nil
+}
+
+// getDirMeta loads the .geesefs_meta object key, or the .geesefs_dirmeta
+// object next to it
+func getDirMeta(cloud StorageBackend, key string) (meta *dirMetaSections, etag string, version int, err error) {
+	obj, etag, version, err := readDirMetaObject(cloud, key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	meta = &dirMetaSections{}
+	data, _ := json.Marshal(obj)
+	err = json.Unmarshal(data, meta)
+	if err != nil {
+		log.Warnf("Ignoring invalid directory metadata in %v: %v", key, err)
+		meta = &dirMetaSections{}
+	}
+	return meta, etag, version, nil
+}
+
+// getDirMetaObject loads the .geesefs_meta object key for a change. If it
+// doesn't exist, the object is started from the .geesefs_dirmeta object
+// next to it, with an empty ETag
+func getDirMetaObject(cloud StorageBackend, key string) (obj dirMetaObject, etag string, version int, err error) {
+	obj, etag, version, err = readDirMetaObject(cloud, key)
+	if err == syscall.ENOENT {
+		return make(dirMetaObject), "", dirMetaVersion, nil
+	} else if err != nil {
+		return nil, "", 0, err
+	}
+	if version > dirMetaVersion {
+		log.Warnf("%v is stored by a newer version (%v), not changing it", key, version)
+		return nil, "", 0, syscall.ENOTSUP
+	}
+	if version == 0 {
+		etag = ""
+	}
+	return obj, etag, version, nil
+}
+
+// mergeDirMeta applies merges to obj loaded with version and returns its
+// new contents, or nil if nothing is changed
+func mergeDirMeta(obj dirMetaObject, version int, merges []dirMetaMerge) ([]byte, error) {
+	// Upgraded objects are stored even without other changes
+	changed := version < dirMetaVersion
+	for _, merge := range merges {
+		c, err := merge(obj)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil, nil
+	}
+	obj["version"], _ = json.Marshal(dirMetaVersion)
+	body, _ := json.Marshal(obj)
+	return body, nil
+}
+
+// dropLegacyDirMeta removes the .geesefs_dirmeta object replaced by the
+// .geesefs_meta object key
+func dropLegacyDirMeta(cloud StorageBackend, key string) {
+	legacyKey := legacyDirMetaKey(key)
+	_, err := cloud.DeleteBlob(&DeleteBlobInput{Key: legacyKey})
+	if err != nil {
+		log.Warnf("Failed to remove %v replaced by %v: %v", legacyKey, key, err)
+	}
+}
+
+// updateDirMeta applies merges to the latest version of the .geesefs_meta
+// object key
+func updateDirMeta(cloud StorageBackend, key string, merges ...dirMetaMerge) error {
+	if !cloud.Capabilities().ConditionalPut {
+		return updateDirMetaLocked(cloud, key, merges...)
+	}
+	for attempt := 1; ; attempt++ {
+		obj, etag, version, err := getDirMetaObject(cloud, key)
+		if err != nil {
+			return err
+		}
+		body, err := mergeDirMeta(obj, version, merges)
+		if err != nil || body == nil {
+			return err
+		}
+		put := &PutBlobInput{
+			Key:         key,
+			ContentType: PString("application/json"),
+			Body:        bytes.NewReader(body),
+			Size:        PUInt64(uint64(len(body))),
+		}
+		if etag == "" {
+			put.IfNoneMatch = PString("*")
+		} else {
+			put.IfMatch = PString(etag)
+		}
+		_, err = cloud.PutBlob(put)
+		err = mapAwsError(err)
+		if (err == syscall.EBUSY || err == syscall.ENOENT) && attempt < dirMetaRetries {
+			// Changed by someone else, retry with the new version
+			s3Log.Debugf("Conflict updating %v, retrying (attempt %v)", key, attempt)
+			clock.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+			continue
+		}
+		if err == nil && version == 0 {
+			dropLegacyDirMeta(cloud, key)
+		}
+		return err
+	}
+}
+
+// LOCKS_REQUIRED(dir.mu)
+func (dir *Inode) sendDirMeta() {
+	cloud, key := dir.Parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	name := dir.Name
+	entry := dir.dirMeta()
+	merges := []dirMetaMerge{setDirMetaEntry(metaDirs, name, entry)}
+	xattrsDirty := dir.fs.flags.NoDirObject && dir.userMetadataDirty != 0
+	if xattrsDirty {
+		if xattrs := escapeMetadata(dir.userMetadata); len(xattrs) > 0 {
+			merges = append(merges, setDirMetaEntry(metaXattrs, name, xattrs))
+		} else {
+			merges = append(merges, setDirMetaEntry(metaXattrs, name, nil))
+		}
+		dir.userMetadataDirty = 0
+	}
+	dir.dir.metaDirty = false
+	dir.IsFlushing += dir.fs.flags.MaxParallelParts
+	atomic.AddInt64(&dir.fs.activeFlushers, 1)
+	go func() {
+		err := updateDirMeta(cloud, key, merges...)
+		dir.mu.Lock()
+		defer dir.mu.Unlock()
+		atomic.AddInt64(&dir.fs.activeFlushers, -1)
+		dir.IsFlushing -= dir.fs.flags.MaxParallelParts
+		dir.recordFlushError(err)
+		if err != nil {
+			log.Warnf("Failed to store attributes of directory %v in %v: %v", name, key, err)
+			if xattrsDirty && dir.userMetadataDirty == 0 {
+				dir.userMetadataDirty = 2
+			}
+			dir.checkDirMeta()
+			dir.fs.WakeupFlusher()
+			return
+		}
+		dir.fs.recordChange("put", key, "", "", 0)
+		dir.dir.metaStored = &entry
+		// Without directory objects, that's all. Otherwise create or update
+		// the directory object as usual
+		if dir.fs.flags.NoDirObject && dir.CacheState == ST_MODIFIED && !dir.dir.metaDirty {
+			dir.SetCacheState(ST_CACHED)
+			dir.SetAttrTime(clock.Now())
+		}
+		dir.fs.WakeupFlusher()
+	}()
+}
+
+// noteDirMeta loads the metadata object found in a listing of parent in
+// background if it has changed
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) noteDirMeta(obj *BlobItemOutput, name string) {
+	if name == legacyDirMetaName && parent.dir.childMeta != nil && !parent.dir.childMetaLegacy {
+		// Left over after it was replaced
+		return
+	}
+	if parent.dir.childMetaLoad {
+		return
+	}
+	if NilStr(obj.ETag) == parent.dir.childMetaETag {
+		// Nodes may have been evicted from the cache
+		parent.applyNodes()
+		return
+	}
+	parent.dir.childMetaLoad = true
+	go parent.loadDirMeta()
+}
+
+func (parent *Inode) loadDirMeta() {
+	cloud, key := parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	start := clock.Now()
+	meta, etag, version, err := getDirMeta(cloud, key)
+	if err == nil {
+		parent.fs.noteDirMetaVersion(cloud, key, version)
+	}
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	parent.dir.childMetaLoad = false
+	if err != nil {
+		if err != syscall.ENOENT {
+			log.Warnf("Failed to load directory attributes from %v: %v", key, err)
+		}
+		return
+	}
+	parent.dir.childMeta = meta
+	parent.dir.childMetaETag = etag
+	parent.dir.childMetaLegacy = version == 0
+	parent.dir.childMetaTime = start
+	parent.applyNodes()
+	for _, child := range parent.dir.Children {
+		if child.isDir() {
+			child.mu.Lock()
+			child.applyDirMeta(meta)
+			child.mu.Unlock()
+		} else {
+			parent.applyObjectMeta(child)
+			parent.applyAtime(child)
+			parent.applyMetaXattrs(child)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type DirMetaTest struct{}
+
+var _ = Suite(&DirMetaTest{})
+
+func listDir(t *C, dir *Inode) (names []string) {
+	dh := NewDirHandle(dir)
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	dh.Seek(2)
+	for {
+		en, err := dh.ReadDir()
+		t.Assert(err, IsNil)
+		if en == nil {
+			return
+		}
+		names = append(names, en.Name)
+		dh.Next(en.Name)
+	}
+}
+
+func (s *DirMetaTest) TestDirMetaNoCloud(t *C) {
+	c, err := NewSimCluster(3, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+		flags.NoDirObject = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	c.Store.Put("d1/file", []byte("1"), nil)
+	c.Store.Put("d2/file", []byte("2"), nil)
+
+	// Concurrent changes of different directories are merged
+	var wg sync.WaitGroup
+	for i, m := range []*SimMount{a, b} {
+		wg.Add(1)
+		go func(i int, m *SimMount) {
+			defer wg.Done()
+			dir, err := m.fs.LookupPath([]string{"d1", "d2"}[i])
+			t.Check(err, IsNil)
+			uid := uint32(1000 + i)
+			mode := os.ModeDir | 0700
+			err = dir.SetAttributes(nil, &mode, nil, &uid, nil)
+			t.Check(err, IsNil)
+			err = m.fs.SyncTree(nil)
+			t.Check(err, IsNil)
+		}(i, m)
+	}
+	wg.Wait()
+	data, ok := c.Store.Get(dirMetaName)
+	t.Assert(ok, Equals, true)
+	var meta dirMetaSections
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	t.Assert(meta.Dirs, DeepEquals, map[string]dirMetaEntry{
+		"d1": {Mode: 0700, Uid: 1000, Gid: a.fs.flags.Gid},
+		"d2": {Mode: 0700, Uid: 1001, Gid: a.fs.flags.Gid},
+	})
+	t.Assert(c.Store.Keys(), DeepEquals, []string{dirMetaName, "d1/file", "d2/file"})
+
+	// Another mount sees the attributes after listing the parent
+	m := c.Mounts[2]
+	root := m.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d1", "d2"})
+	for i := 0; i < 100; i++ {
+		root.mu.Lock()
+		loaded := root.dir.childMeta != nil
+		root.mu.Unlock()
+		if loaded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dir, err := m.fs.LookupPath("d2")
+	t.Assert(err, IsNil)
+	dir.mu.Lock()
+	t.Assert(dir.Attributes.Mode, Equals, os.ModeDir|0700)
+	t.Assert(dir.Attributes.Uid, Equals, uint32(1001))
+	dir.mu.Unlock()
+
+	// Entries are removed with their directories
+	dir, err = m.fs.LookupPath("d2")
+	t.Assert(err, IsNil)
+	t.Assert(dir.Unlink("file"), IsNil)
+	t.Assert(root.RmDir("d2"), IsNil)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+	data, _ = c.Store.Get(dirMetaName)
+	meta = dirMetaSections{}
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	_, ok = meta.Dirs["d2"]
+	t.Assert(ok, Equals, false)
+	t.Assert(meta.Dirs["d1"].Uid, Equals, uint32(1000))
+
+	// Entries are moved on rename with the entries of subdirectories
+	root = a.fs.getInodeOrDie(1)
+	dir, err = a.fs.LookupPath("d1")
+	t.Assert(err, IsNil)
+	sub, err := dir.MkDir("sub")
+	t.Assert(err, IsNil)
+	mode := os.ModeDir | 0750
+	t.Assert(sub.SetAttributes(nil, &mode, nil, nil, nil), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(root.Rename("d1", root, "d3"), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	data, _ = c.Store.Get(dirMetaName)
+	meta = dirMetaSections{}
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	t.Assert(meta.Dirs, DeepEquals, map[string]dirMetaEntry{
+		"d3": {Mode: 0700, Uid: 1000, Gid: a.fs.flags.Gid},
+	})
+	data, _ = c.Store.Get("d3/" + dirMetaName)
+	meta = dirMetaSections{}
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	t.Assert(meta.Dirs["sub"].Mode, Equals, uint32(0750))
+	data, _ = c.Store.Get("d1/" + dirMetaName)
+	meta = dirMetaSections{}
+	json.Unmarshal(data, &meta)
+	t.Assert(meta.Dirs, HasLen, 0)
+	dir, err = a.fs.LookupPath("d3")
+	t.Assert(err, IsNil)
+	dir.mu.Lock()
+	t.Assert(dir.Attributes.Mode, Equals, os.ModeDir|0700)
+	t.Assert(dir.Attributes.Uid, Equals, uint32(1000))
+	dir.mu.Unlock()
+
+	// A new directory with the old name doesn't get them
+	dir, err = root.MkDir("d1")
+	t.Assert(err, IsNil)
+	dir.mu.Lock()
+	t.Assert(dir.Attributes.Mode, Equals, os.ModeDir|a.fs.flags.DirMode&os.ModePerm)
+	dir.mu.Unlock()
+}
+
+func (s *DirMetaTest) TestDirMetaFileXattrsNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.DirMetaFile = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	c.Store.Put("dir/file", []byte("1"), nil)
+	fileXattrs := func(name string) map[string]*string {
+		data, _ := c.Store.Get("dir/" + dirMetaName)
+		var meta dirMetaSections
+		json.Unmarshal(data, &meta)
+		return meta.Xattrs[name]
+	}
+
+	// xattrs which don't fit into the object are stored in .geesefs_meta
+	file, err := a.fs.LookupPath("dir/file")
+	t.Assert(err, IsNil)
+	big := bytes.Repeat([]byte("x"), 1500)
+	t.Assert(file.SetXattr("user.a", big, 0), IsNil)
+	t.Assert(file.SetXattr("user.b", big, 0), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(fileXattrs("file"), DeepEquals, map[string]*string{"b": PString(string(big))})
+	cloud, _ := file.cloud()
+	head, err := cloud.HeadBlob(&HeadBlobInput{Key: "dir/file"})
+	t.Assert(err, IsNil)
+	_, ok := head.Metadata["b"]
+	t.Assert(ok, Equals, false)
+	t.Assert(head.Metadata["a"], NotNil)
+	names, err := file.ListXattr()
+	t.Assert(err, IsNil)
+	t.Assert(names, DeepEquals, []string{"sim.etag", "sim.storage-class", "user.a", "user.b"})
+	t.Assert(file.SetXattr("user.b", []byte("1"), XATTR_CREATE), Equals, syscall.EEXIST)
+
+	// Another mount sees them
+	other, err := b.fs.LookupPath("dir/file")
+	t.Assert(err, IsNil)
+	value, err := other.GetXattr("user.b")
+	t.Assert(err, IsNil)
+	t.Assert(value, DeepEquals, big)
+
+	// A stored xattr stays there even if it's small now
+	t.Assert(file.SetXattr("user.b", []byte("1"), 0), IsNil)
+	t.Assert(fileXattrs("file"), DeepEquals, map[string]*string{"b": PString("1")})
+
+	// They're moved on rename
+	dir, err := a.fs.LookupPath("dir")
+	t.Assert(err, IsNil)
+	t.Assert(dir.Rename("file", dir, "moved"), IsNil)
+	t.Assert(waitUntil(func() bool {
+		return fileXattrs("file") == nil && fileXattrs("moved") != nil
+	}), Equals, true)
+	value, err = file.GetXattr("user.b")
+	t.Assert(err, IsNil)
+	t.Assert(value, DeepEquals, []byte("1"))
+
+	// ...and removed with the file
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(dir.Unlink("moved"), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		return fileXattrs("moved") == nil
+	}), Equals, true)
+
+	// ...and moved with their directory
+	file, _, err = dir.Create("new")
+	t.Assert(err, IsNil)
+	t.Assert(file.SetXattr("user.b", append(big, big...), 0), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	root := a.fs.getInodeOrDie(1)
+	t.Assert(root.Rename("dir", root, "dir2"), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(fileXattrs("new"), IsNil)
+	data, _ := c.Store.Get("dir2/" + dirMetaName)
+	var moved dirMetaSections
+	t.Assert(json.Unmarshal(data, &moved), IsNil)
+	t.Assert(moved.Xattrs["new"], NotNil)
+}
+
+func (s *DirMetaTest) TestDirMetaSectionsNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+		flags.NoDirObject = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	c.Store.Put(legacyDirMetaName, []byte(`{"d1": {"mode": 448, "uid": 1000, "gid": 1000}}`), nil)
+	c.Store.Put("d1/file", []byte("1"), nil)
+	c.Store.Put("d2/file", []byte("2"), nil)
+
+	// Xattrs of directories without objects and symlinks are stored in
+	// their sections, the old object is replaced
+	dir, err := a.fs.LookupPath("d2")
+	t.Assert(err, IsNil)
+	t.Assert(dir.SetXattr("user.project", []byte("x"), 0), IsNil)
+	root := a.fs.getInodeOrDie(1)
+	_, err = root.CreateSymlink("link", "d1/file")
+	t.Assert(err, IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		data, _ := c.Store.Get(dirMetaName)
+		var meta dirMetaSections
+		json.Unmarshal(data, &meta)
+		return meta.Symlinks["link"].ETag != ""
+	}), Equals, true)
+	data, _ := c.Store.Get(dirMetaName)
+	var obj map[string]json.RawMessage
+	t.Assert(json.Unmarshal(data, &obj), IsNil)
+	t.Assert(string(obj["version"]), Equals, "1")
+	var meta dirMetaSections
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	t.Assert(meta.Dirs["d1"].Uid, Equals, uint32(1000))
+	t.Assert(*meta.Xattrs["d2"]["project"], Equals, "x")
+	_, ok := c.Store.Get(legacyDirMetaName)
+	t.Assert(ok, Equals, false)
+
+	// Another mount gets them from a listing, without HEAD requests
+	root = b.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d1", "d2", "link"})
+	t.Assert(waitUntil(func() bool {
+		root.mu.Lock()
+		defer root.mu.Unlock()
+		return root.dir.childMeta != nil
+	}), Equals, true)
+	heads := b.Conn.Calls("HeadBlob")
+	link, err := b.fs.LookupPath("link")
+	t.Assert(err, IsNil)
+	target, err := link.ReadSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(target, Equals, "d1/file")
+	dir, err = b.fs.LookupPath("d2")
+	t.Assert(err, IsNil)
+	value, err := dir.GetXattr("user.project")
+	t.Assert(err, IsNil)
+	t.Assert(string(value), Equals, "x")
+	t.Assert(b.Conn.Calls("HeadBlob"), Equals, heads)
+
+	// Unknown sections are kept
+	obj["future"] = json.RawMessage(`{"x": 1}`)
+	data, _ = json.Marshal(obj)
+	c.Store.Put(dirMetaName, data, nil)
+	t.Assert(root.Unlink("link"), IsNil)
+	t.Assert(b.fs.SyncTree(nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		data, _ := c.Store.Get(dirMetaName)
+		var meta dirMetaSections
+		json.Unmarshal(data, &meta)
+		return meta.Symlinks == nil
+	}), Equals, true)
+	data, _ = c.Store.Get(dirMetaName)
+	obj = nil
+	t.Assert(json.Unmarshal(data, &obj), IsNil)
+	t.Assert(string(obj["future"]), Equals, `{"x":1}`)
+}
+
+func (s *DirMetaTest) TestDirMetaNodesNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+		flags.DirMetaSpecials = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	a, b := c.Mounts[0], c.Mounts[1]
+	c.Store.Put("d/file", []byte("1"), nil)
+
+	// Special files have no objects
+	dir, err := a.fs.LookupPath("d")
+	t.Assert(err, IsNil)
+	_, err = dir.MkNodeMeta("pipe", os.ModeNamedPipe|0640, 0, 1000, 1000)
+	t.Assert(err, IsNil)
+	_, err = dir.MkNodeMeta("tty", os.ModeDevice|os.ModeCharDevice|0600, 0x401, 0, 0)
+	t.Assert(err, IsNil)
+	_, err = dir.MkNodeMeta("pipe", os.ModeNamedPipe|0640, 0, 1000, 1000)
+	t.Assert(err, Equals, syscall.EEXIST)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"d/" + dirMetaName, "d/file"})
+
+	// Other mounts show them after listing the directory
+	other, err := b.fs.LookupPath("d")
+	t.Assert(err, IsNil)
+	t.Assert(waitUntil(func() bool {
+		return len(listDir(t, other)) == 3
+	}), Equals, true)
+	tty, err := b.fs.LookupPath("d/tty")
+	t.Assert(err, IsNil)
+	t.Assert(tty.Attributes.Mode, Equals, os.ModeDevice|os.ModeCharDevice|0600)
+	t.Assert(tty.Attributes.Rdev, Equals, uint32(0x401))
+
+	// Changes are stored immediately
+	pipe, err := a.fs.LookupPath("d/pipe")
+	t.Assert(err, IsNil)
+	mode := os.FileMode(0600)
+	t.Assert(pipe.SetAttributes(nil, &mode, nil, nil, nil), IsNil)
+	t.Assert(pipe.SetXattr("user.x", []byte("x"), 0), Equals, syscall.ENOTSUP)
+	t.Assert(dir.Rename("pipe", dir, "fifo"), IsNil)
+	t.Assert(dir.Unlink("tty"), IsNil)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"d/" + dirMetaName, "d/file"})
+
+	// Other mounts see the changes when the listing is refreshed, and nodes
+	// aren't lost by refreshes
+	c.Clock.Advance(time.Hour)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"fifo", "file"})
+	t.Assert(waitUntil(func() bool {
+		return len(listDir(t, other)) == 2 && other.findChild("fifo") != nil
+	}), Equals, true)
+	fifo, err := b.fs.LookupPath("d/fifo")
+	t.Assert(err, IsNil)
+	t.Assert(fifo.Attributes.Mode, Equals, os.ModeNamedPipe|0600)
+
+	// Renamed directories take their nodes along
+	root := a.fs.getInodeOrDie(1)
+	t.Assert(root.Rename("d", root, "e"), IsNil)
+	t.Assert(a.fs.SyncTree(nil), IsNil)
+	data, _ := c.Store.Get("e/" + dirMetaName)
+	var meta dirMetaSections
+	t.Assert(json.Unmarshal(data, &meta), IsNil)
+	_, ok := meta.Nodes["fifo"]
+	t.Assert(ok, Equals, true)
+	data, _ = c.Store.Get("d/" + dirMetaName)
+	meta = dirMetaSections{}
+	json.Unmarshal(data, &meta)
+	t.Assert(meta.Nodes, HasLen, 0)
+	fifo, err = a.fs.LookupPath("e/fifo")
+	t.Assert(err, IsNil)
+	t.Assert(fifo.metaNode, Equals, true)
+}
+
+func (s *DirMetaTest) TestMetaTxnNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	conn := c.Mounts[0].Conn
+	entry := nodeMetaEntry{Mode: 4516, Uid: 1000, Gid: 1000}
+	nodes := func(key string) map[string]nodeMetaEntry {
+		data, _ := c.Store.Get(key)
+		var meta dirMetaSections
+		json.Unmarshal(data, &meta)
+		return meta.Nodes
+	}
+
+	// Entries are moved between objects
+	c.Store.Put("a/"+dirMetaName, []byte(`{"version": 1, "nodes": {"x": {"mode": 4516}}}`), nil)
+	txn := NewMetaTxn(conn)
+	txn.Set("b/"+dirMetaName, metaNodes, "x", entry)
+	txn.Set("a/"+dirMetaName, metaNodes, "x", nil)
+	t.Assert(txn.Commit(), IsNil)
+	t.Assert(nodes("a/"+dirMetaName), HasLen, 0)
+	t.Assert(nodes("b/"+dirMetaName), DeepEquals, map[string]nodeMetaEntry{"x": entry})
+
+	// Objects already updated are restored if a later one fails
+	c.Store.Put("c/"+dirMetaName, []byte(`{"version": 2}`), nil)
+	txn = NewMetaTxn(conn)
+	txn.Set("b/"+dirMetaName, metaNodes, "x", nil)
+	txn.Set("b/"+dirMetaName, metaNodes, "y", entry)
+	txn.Set("c/"+dirMetaName, metaNodes, "x", entry)
+	t.Assert(txn.Commit(), Equals, syscall.ENOTSUP)
+	t.Assert(nodes("b/"+dirMetaName), DeepEquals, map[string]nodeMetaEntry{"x": entry})
+}
+
+func (s *DirMetaTest) TestDirMetaMigrateNoCloud(t *C) {
+	defer func(migrations []dirMetaMigration, version int) {
+		dirMetaMigrations, dirMetaVersion = migrations, version
+	}(dirMetaMigrations, dirMetaVersion)
+	// Version 2 renames the xattrs section
+	registerDirMetaMigration(dirMetaMigration{From: 1, Name: "attrs", Upgrade: func(obj dirMetaObject) error {
+		if raw, ok := obj["attrs"]; ok {
+			obj[metaXattrs] = raw
+			delete(obj, "attrs")
+		}
+		return nil
+	}})
+	t.Assert(func() {
+		registerDirMetaMigration(dirMetaMigration{From: 1, Name: "again"})
+	}, PanicMatches, "migration again is from version 1, but the current version is 2")
+
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+		flags.DirMetaMigrate = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	c.Store.Put(dirMetaName, []byte(`{"version": 1, "attrs": {"d1": {"project": "x"}}}`), nil)
+	c.Store.Put("d1/file", []byte("1"), nil)
+	c.Store.Put("new/"+dirMetaName, []byte(`{"version": 3, "dirs": {}}`), nil)
+	c.Store.Put("new/d2/file", []byte("2"), nil)
+
+	// Old objects are upgraded at load and rewritten in background
+	root := m.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d1", "new"})
+	t.Assert(waitUntil(func() bool {
+		return m.fs.MetaMigrationStatus().Rewritten == 1
+	}), Equals, true)
+	dir, err := m.fs.LookupPath("d1")
+	t.Assert(err, IsNil)
+	value, err := dir.GetXattr("user.project")
+	t.Assert(err, IsNil)
+	t.Assert(string(value), Equals, "x")
+	data, _ := c.Store.Get(dirMetaName)
+	var obj map[string]json.RawMessage
+	t.Assert(json.Unmarshal(data, &obj), IsNil)
+	t.Assert(string(obj["version"]), Equals, "2")
+	_, ok := obj["attrs"]
+	t.Assert(ok, Equals, false)
+
+	// Newer objects are read, but never downgraded
+	dir, err = m.fs.LookupPath("new")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"d2"})
+	t.Assert(waitUntil(func() bool {
+		return m.fs.MetaMigrationStatus().Newer == 1
+	}), Equals, true)
+	err = updateDirMeta(m.Conn, "new/"+dirMetaName, setDirMetaEntry(metaDirs, "d2", dirMetaEntry{Mode: 0700}))
+	t.Assert(err, Equals, syscall.ENOTSUP)
+
+	status := m.fs.MetaMigrationStatus()
+	t.Assert(status.Version, Equals, 2)
+	t.Assert(status.Migrations, DeepEquals, []string{"1 -> 2: attrs"})
+	t.Assert(status.Loaded, DeepEquals, map[int]int64{1: 1, 3: 1})
+	t.Assert(status.Upgraded, Equals, int64(1))
+	t.Assert(status.Pending, Equals, 0)
+}
+
+func (s *DirMetaTest) TestMetaReadOnlyNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+		flags.DirMetaSpecials = true
+		flags.NoDirObject = true
+		flags.MetaReadOnly = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	meta := []byte(`{"version": 1, "dirs": {"d": {"mode": 448, "uid": 1000, "gid": 1000}},` +
+		` "nodes": {"pipe": {"mode": 4516, "uid": 1000, "gid": 1000, "mtime": 1714564800}}}`)
+	c.Store.Put("d/file", []byte("1"), nil)
+	c.Store.Put("d/"+dirMetaName, meta, nil)
+	c.Store.Put(dirMetaName, meta, nil)
+
+	// Stored metadata is used
+	root := m.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d"})
+	dir, err := m.fs.LookupPath("d")
+	t.Assert(err, IsNil)
+	t.Assert(waitUntil(func() bool {
+		dir.mu.Lock()
+		defer dir.mu.Unlock()
+		return dir.Attributes.Uid == 1000
+	}), Equals, true)
+	t.Assert(waitUntil(func() bool {
+		return len(listDir(t, dir)) == 2
+	}), Equals, true)
+
+	// Data can be written, metadata can't
+	_, err = m.WriteFile("d/new", []byte("2"))
+	t.Assert(err, IsNil)
+	mode := os.FileMode(0755)
+	t.Assert(dir.SetAttributes(nil, &mode, nil, nil, nil), Equals, syscall.EROFS)
+	t.Assert(dir.SetXattr("user.x", []byte("x"), 0), Equals, syscall.EROFS)
+	_, err = dir.MkNodeMeta("fifo", os.ModeNamedPipe|0640, 0, 0, 0)
+	t.Assert(err, Equals, syscall.EROFS)
+	t.Assert(dir.Unlink("pipe"), Equals, syscall.EROFS)
+	t.Assert(dir.Rename("pipe", dir, "pipe2"), Equals, syscall.EROFS)
+	t.Assert(root.Rename("d", root, "e"), Equals, syscall.EROFS)
+	_, err = root.CreateSymlink("link", "d/file")
+	t.Assert(err, IsNil)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+
+	data, _ := c.Store.Get(dirMetaName)
+	t.Assert(string(data), Equals, string(meta))
+	data, _ = c.Store.Get("d/" + dirMetaName)
+	t.Assert(string(data), Equals, string(meta))
+	data, _ = c.Store.Get("d/new")
+	t.Assert(string(data), Equals, "2")
+}
+
+func (s *DirMetaTest) TestDirMetaLockNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.EnablePerms = true
+		flags.DirMetaFile = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	m := c.Mounts[0]
+	// Conditional PUTs fail on such backends, so only the lock is used
+	m.Conn.cap.ConditionalPut = false
+	c.Store.Put("d/file", []byte("1"), nil)
+	c.Store.Put("e/file", []byte("2"), nil)
+
+	root := m.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d", "e"})
+	dir, err := m.fs.LookupPath("d")
+	t.Assert(err, IsNil)
+	mode := os.FileMode(0700)
+	t.Assert(dir.SetAttributes(nil, &mode, nil, nil, nil), IsNil)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{dirMetaName, "d/", "d/file", "e/file"})
+
+	// A lock left by a crashed client is taken over after its TTL
+	c.Store.Put(dirMetaLockName, []byte(`{"owner": "crashed"}`), nil)
+	t.Assert(listDir(t, root), DeepEquals, []string{"d", "e"})
+	start := c.Clock.Now()
+	err = updateDirMeta(m.Conn, dirMetaName, setDirMetaEntry(metaDirs, "e", dirMetaEntry{Mode: 0750}))
+	t.Assert(err, IsNil)
+	t.Assert(c.Clock.Now().Sub(start) >= dirMetaLockTTL, Equals, true)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{dirMetaName, "d/", "d/file", "e/file"})
+	meta, _, _, err := getDirMeta(m.Conn, dirMetaName)
+	t.Assert(err, IsNil)
+	t.Assert(meta.Dirs["d"].Mode, Equals, uint32(0700))
+	t.Assert(meta.Dirs["e"].Mode, Equals, uint32(0750))
+}
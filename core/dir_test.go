@@ -1,6 +1,9 @@
 package core
 
 import (
+	"bytes"
+	"syscall"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/yandex-cloud/geesefs/core/cfg"
@@ -189,3 +192,249 @@ func (s *DirTest) TestIntelligentListCut(t *C) {
 	t.Assert(err, IsNil)
 	t.Assert(listCalled, Equals, 1)
 }
+
+func (s *DirTest) TestDirCookies(t *C) {
+	children := func(names ...string) (res []*Inode) {
+		for _, n := range names {
+			res = append(res, &Inode{Name: n})
+		}
+		return
+	}
+	dirInode := &Inode{dir: &DirInodeData{Children: children("a", "b", "c")}}
+	dir := dirInode.dir
+
+	ca, cb := dir.nameCookie("a"), dir.nameCookie("b")
+	t.Assert(ca >= dirCookieFirst, Equals, true)
+	t.Assert(cb >= dirCookieFirst, Equals, true)
+	t.Assert(ca != cb, Equals, true)
+	t.Assert(dir.nameCookie("a"), Equals, ca)
+	name, ok := dir.cookieName(cb)
+	t.Assert(ok, Equals, true)
+	t.Assert(name, Equals, "b")
+
+	// Cookies survive re-creation of the directory inode
+	fresh := &DirInodeData{Children: children("a", "b", "c")}
+	name, ok = fresh.cookieName(cb)
+	t.Assert(ok, Equals, true)
+	t.Assert(name, Equals, "b")
+	t.Assert(fresh.nameCookie("c"), Equals, dir.nameCookie("c"))
+	_, ok = fresh.cookieName(hashDirCookie("d"))
+	t.Assert(ok, Equals, false)
+
+	// Listing continues after "b" even if it's removed and "ab" is added
+	dh := NewDirHandle(dirInode)
+	dh.Seek(cb)
+	dir.Children = children("a", "ab", "c")
+	dh.checkDirPosition()
+	t.Assert(dir.Children[dh.lastInternalOffset-2].Name, Equals, "c")
+
+	// Unknown cookies restart the listing after ".."
+	dh.Seek(12345)
+	dh.checkDirPosition()
+	t.Assert(dh.lastInternalOffset, Equals, 2)
+}
+
+func (s *DirTest) TestInvalidNames(t *C) {
+	for _, name := range []string{"", ".", "..", "/a", "./a", "../a", "a/.", "a/..", "a//b", "a/./b", "a/../b"} {
+		t.Assert(isInvalidName(name), Equals, true, Commentf("%q", name))
+	}
+	for _, name := range []string{"a", "..a", "a..", ".a", "a/b", "a/.b/c"} {
+		t.Assert(isInvalidName(name), Equals, false, Commentf("%q", name))
+	}
+	t.Assert(isInvalidChildName("a/b"), Equals, true)
+	t.Assert(isInvalidChildName("..."), Equals, false)
+}
+
+func (s *DirTest) TestJailedNamesNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("..", []byte("x"), nil)
+	c.Store.Put("dir/../escape", []byte("x"), nil)
+	c.Store.Put("dir/file", []byte("x"), nil)
+
+	root := m.fs.getInodeOrDie(1)
+	_, err = root.LookUpCached("..")
+	t.Assert(err, NotNil)
+	dir, err := root.LookUpCached("dir")
+	t.Assert(err, IsNil)
+	_, err = dir.LookUpCached("file")
+	t.Assert(err, IsNil)
+	_, err = dir.LookUpCached("..")
+	t.Assert(err, NotNil)
+
+	_, _, err = root.Create("..")
+	t.Assert(err, NotNil)
+	_, err = root.MkDir("a/b")
+	t.Assert(err, NotNil)
+	err = dir.Rename("file", root, "..")
+	t.Assert(err, NotNil)
+}
+
+func (s *DirTest) TestMaxSymlinksPerDirNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.MaxSymlinksPerDir = 2
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	root := c.Mounts[0].fs.getInodeOrDie(1)
+
+	_, _, err = root.Create("file")
+	t.Assert(err, IsNil)
+	_, err = root.CreateSymlink("a", "file")
+	t.Assert(err, IsNil)
+	_, err = root.CreateSymlink("b", "file")
+	t.Assert(err, IsNil)
+	_, err = root.CreateSymlink("c", "file")
+	t.Assert(err, Equals, syscall.EDQUOT)
+
+	// Limit is per directory
+	dir, err := root.MkDir("dir")
+	t.Assert(err, IsNil)
+	_, err = dir.CreateSymlink("c", "../file")
+	t.Assert(err, IsNil)
+}
+
+func (s *DirTest) TestXattrSizeLimitNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	root := c.Mounts[0].fs.getInodeOrDie(1)
+
+	file, _, err := root.Create("file")
+	t.Assert(err, IsNil)
+	big := bytes.Repeat([]byte("x"), 1500)
+	err = file.SetXattr("user.a", big, 0)
+	t.Assert(err, IsNil)
+	err = file.SetXattr("user.b", big, 0)
+	t.Assert(err, Equals, syscall.E2BIG)
+	_, err = file.GetXattr("user.b")
+	t.Assert(err, Equals, ENOATTR)
+
+	// Replacing a value only counts the new one
+	err = file.SetXattr("user.a", append(big, big[:500]...), 0)
+	t.Assert(err, IsNil)
+	err = file.SetXattr("user.a", append(big, big...), 0)
+	t.Assert(err, Equals, syscall.E2BIG)
+}
+
+func (s *DirTest) TestDirMarkersNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.FolderMarkers = true
+		flags.KeepMarkers = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("hadoop_$folder$", nil, nil)
+	c.Store.Put("hadoop2_$folder$", nil, nil)
+	c.Store.Put("kept/.keep", nil, nil)
+	c.Store.Put("file", []byte("x"), nil)
+	c.Store.Put("old_$folder$", nil, nil)
+	c.Store.Put("old/f", []byte("f"), nil)
+
+	// Lookup without a listing
+	root := m.fs.getInodeOrDie(1)
+	dir, err := root.LookUpCached("hadoop2")
+	t.Assert(err, IsNil)
+	t.Assert(dir.isDir(), Equals, true)
+
+	var names []string
+	dh := root.OpenDir()
+	dh.mu.Lock()
+	dh.Seek(2)
+	for {
+		en, err := dh.ReadDir()
+		t.Assert(err, IsNil)
+		if en == nil {
+			break
+		}
+		t.Assert(en.isDir(), Equals, en.Name != "file")
+		names = append(names, en.Name)
+		dh.Next(en.Name)
+	}
+	dh.mu.Unlock()
+	dh.CloseDir()
+	t.Assert(names, DeepEquals, []string{"file", "hadoop", "hadoop2", "kept", "old"})
+
+	kept, err := root.LookUpCached("kept")
+	t.Assert(err, IsNil)
+	empty, err := kept.isEmptyDir()
+	t.Assert(err, IsNil)
+	t.Assert(empty, Equals, true)
+
+	// rmdir removes markers
+	for _, name := range []string{"hadoop", "hadoop2", "kept"} {
+		err = root.RmDir(name)
+		t.Assert(err, IsNil)
+	}
+	err = m.fs.SyncTree(nil)
+	t.Assert(err, IsNil)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"file", "old/f", "old_$folder$"})
+
+	// Markers don't revive renamed directories
+	err = root.Rename("old", root, "new")
+	t.Assert(err, IsNil)
+	err = m.fs.SyncTree(nil)
+	t.Assert(err, IsNil)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"file", "new/", "new/f"})
+}
+
+func (s *DirTest) TestNoListNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.NoList = i == 0
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	c.Store.Put("file", []byte("x"), nil)
+	c.Store.Put("dir/", nil, nil)
+	c.Store.Put("dir/nested", []byte("y"), nil)
+	c.Store.Put("unknown", []byte("z"), nil)
+	for _, m := range c.Mounts {
+		m.Conn.FailNext("ListBlobs", 1000, syscall.EACCES)
+	}
+
+	m := c.Mounts[0]
+	root := m.fs.getInodeOrDie(1)
+	_, err = root.LookUpCached("file")
+	t.Assert(err, IsNil)
+	dir, err := root.LookUpCached("dir")
+	t.Assert(err, IsNil)
+	_, err = dir.LookUpCached("nested")
+	t.Assert(err, IsNil)
+	// S3 returns 403 for missing objects without the list permission
+	m.Conn.FailNext("HeadBlob", 2, syscall.EACCES)
+	_, err = root.LookUpCached("missing")
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	var names []string
+	dh := root.OpenDir()
+	dh.mu.Lock()
+	dh.Seek(2)
+	for {
+		en, err := dh.ReadDir()
+		t.Assert(err, IsNil)
+		if en == nil {
+			break
+		}
+		names = append(names, en.Name)
+		dh.Next(en.Name)
+	}
+	dh.mu.Unlock()
+	dh.CloseDir()
+	t.Assert(names, DeepEquals, []string{"dir", "file"})
+	t.Assert(m.Conn.Calls("ListBlobs"), Equals, 0)
+
+	// Without --no-list, readdir fails
+	root = c.Mounts[1].fs.getInodeOrDie(1)
+	dh = root.OpenDir()
+	dh.mu.Lock()
+	dh.Seek(2)
+	_, err = dh.ReadDir()
+	dh.mu.Unlock()
+	dh.CloseDir()
+	t.Assert(err, NotNil)
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Dirty data age watermarks bound how long changes may stay unflushed, for
+// example while an acquisition process keeps a file open: a warning is
+// logged when an inode stays dirty longer than --dirty-age-alert and it's
+// flushed as if fsync was called when it stays dirty longer than
+// --max-dirty-age. The age of the oldest dirty inode is exported in /metrics.
+
+type dirtyAgeStats struct {
+	// age of the oldest dirty inode during the last check, nanoseconds
+	maxAge  int64
+	alerts  uint64
+	flushes uint64
+}
+
+func (fs *Goofys) dirtyAgeCheckInterval() time.Duration {
+	interval := time.Second
+	for _, limit := range []time.Duration{fs.flags.DirtyAgeAlert, fs.flags.MaxDirtyAge} {
+		if limit > 0 && limit/4 < interval {
+			interval = limit / 4
+		}
+	}
+	return interval
+}
+
+// checkDirtyAge walks dirty inodes from the oldest one
+func (fs *Goofys) checkDirtyAge() {
+	now := clock.Now()
+	var maxAge time.Duration
+	forced := false
+	var queueID uint64
+	for {
+		var inodeID uint64
+		inodeID, queueID = fs.inodeQueue.Next(queueID)
+		if inodeID == 0 {
+			break
+		}
+		fs.mu.RLock()
+		inode := fs.inodes[fuseops.InodeID(inodeID)]
+		fs.mu.RUnlock()
+		if inode == nil {
+			continue
+		}
+		inode.mu.Lock()
+		if inode.dirtySince.IsZero() {
+			inode.mu.Unlock()
+			continue
+		}
+		age := now.Sub(inode.dirtySince)
+		if age > maxAge {
+			maxAge = age
+		}
+		if fs.flags.DirtyAgeAlert > 0 && age > fs.flags.DirtyAgeAlert && !inode.dirtyAlerted {
+			inode.dirtyAlerted = true
+			atomic.AddUint64(&fs.dirtyAge.alerts, 1)
+			log.Warnf("%v has unflushed changes for %v (--dirty-age-alert is %v)",
+				inode.FullName(), age.Truncate(time.Millisecond), fs.flags.DirtyAgeAlert)
+		}
+		if fs.flags.MaxDirtyAge > 0 && age > fs.flags.MaxDirtyAge && !inode.forceFlush {
+			inode.forceFlush = true
+			forced = true
+			atomic.AddUint64(&fs.dirtyAge.flushes, 1)
+			log.Infof("Forcing flush of %v: unflushed for %v", inode.FullName(), age.Truncate(time.Millisecond))
+		}
+		inode.mu.Unlock()
+		// Queue is ordered by the time inodes became dirty
+		if (fs.flags.DirtyAgeAlert == 0 || age < fs.flags.DirtyAgeAlert) &&
+			(fs.flags.MaxDirtyAge == 0 || age < fs.flags.MaxDirtyAge) {
+			break
+		}
+	}
+	atomic.StoreInt64(&fs.dirtyAge.maxAge, int64(maxAge))
+	if forced {
+		fs.WakeupFlusher()
+	}
+}
+
+func (fs *Goofys) DirtyAgeMonitor() {
+	interval := fs.dirtyAgeCheckInterval()
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(interval):
+		case <-fs.shutdownCh:
+			return
+		}
+		fs.checkDirtyAge()
+	}
+}
+
+func (fs *Goofys) writeDirtyAgeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE geesefs_dirty_age_max_seconds gauge\n")
+	fmt.Fprintf(w, "geesefs_dirty_age_max_seconds %g\n", time.Duration(atomic.LoadInt64(&fs.dirtyAge.maxAge)).Seconds())
+	fmt.Fprintf(w, "# TYPE geesefs_dirty_age_alerts_total counter\n")
+	fmt.Fprintf(w, "geesefs_dirty_age_alerts_total %v\n", atomic.LoadUint64(&fs.dirtyAge.alerts))
+	fmt.Fprintf(w, "# TYPE geesefs_dirty_age_forced_flushes_total counter\n")
+	fmt.Fprintf(w, "geesefs_dirty_age_forced_flushes_total %v\n", atomic.LoadUint64(&fs.dirtyAge.flushes))
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type DirtyAgeTest struct{}
+
+var _ = Suite(&DirtyAgeTest{})
+
+func (s *DirtyAgeTest) TestMaxDirtyAgeNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.DirtyAgeAlert = 20 * time.Millisecond
+		flags.MaxDirtyAge = 100 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	// Small files aren't flushed while they're open
+	root := m.fs.getInodeOrDie(1)
+	inode, fh, err := root.Create("open")
+	t.Assert(err, IsNil)
+	defer fh.Release()
+	err = fh.WriteFile(0, []byte("data"), true)
+	t.Assert(err, IsNil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if atomic.LoadInt32(&inode.CacheState) == ST_CACHED || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, ok := c.Store.Get("open")
+	t.Assert(ok, Equals, true)
+	t.Assert(string(data), Equals, "data")
+	t.Assert(m.fs.dirtyAge.alerts > 0, Equals, true)
+	t.Assert(m.fs.dirtyAge.flushes > 0, Equals, true)
+
+	inode.mu.Lock()
+	t.Assert(inode.forceFlush, Equals, false)
+	t.Assert(inode.dirtySince.IsZero(), Equals, true)
+	inode.mu.Unlock()
+}
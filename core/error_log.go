@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// ErrorLog (--error-log) keeps recent errors of FUSE operations and backend
+// requests in a file with one JSON object per line, so that monitoring
+// agents can tail it and support can find the exact failure behind an
+// "I/O error" with its S3 error code and request ID. The file is bounded:
+// when it reaches twice --error-log-size lines, it's atomically replaced
+// with the last --error-log-size entries, which `tail -F` follows.
+//
+// Errors which are a normal part of operation (ENOENT on lookup, 404 on
+// HEAD, failed conditional requests and so on) are not recorded.
+
+type ErrorLogEntry struct {
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path,omitempty"`
+	Code      string    `json:"code"`
+	Status    int       `json:"status,omitempty"`
+	RequestId string    `json:"request_id,omitempty"`
+	Error     string    `json:"error"`
+}
+
+type ErrorLog struct {
+	mu      sync.Mutex
+	path    string
+	size    int
+	file    *os.File
+	lines   int
+	entries []ErrorLogEntry
+}
+
+func NewErrorLog(path string, size int) (*ErrorLog, error) {
+	if size < 1 {
+		size = 1
+	}
+	l := &ErrorLog{path: path, size: size}
+	// Keep entries of the previous run
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e ErrorLogEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				l.add(e)
+			}
+		}
+		f.Close()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *ErrorLog) add(e ErrorLogEntry) {
+	if len(l.entries) >= l.size {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-l.size+1:]...)
+	}
+	l.entries = append(l.entries, e)
+}
+
+// rewrite replaces the file with the current entries
+// LOCKS_REQUIRED(l.mu)
+func (l *ErrorLog) rewrite() error {
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range l.entries {
+		line, _ := json.Marshal(&e)
+		w.Write(append(line, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = f
+	l.lines = len(l.entries)
+	return nil
+}
+
+func (l *ErrorLog) Record(e ErrorLogEntry) {
+	if e.Time.IsZero() {
+		e.Time = clock.Now().UTC()
+	}
+	line, _ := json.Marshal(&e)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(e)
+	if l.file == nil {
+		return
+	}
+	var err error
+	if l.lines+1 >= 2*l.size {
+		err = l.rewrite()
+	} else {
+		_, err = l.file.Write(append(line, '\n'))
+		l.lines++
+	}
+	if err != nil {
+		log.Warnf("Failed to write error log %v: %v", l.path, err)
+	}
+}
+
+// Entries returns the last --error-log-size entries
+func (l *ErrorLog) Entries() []ErrorLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ErrorLogEntry(nil), l.entries...)
+}
+
+func (l *ErrorLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// RecordOp records a failed FUSE operation
+func (l *ErrorLog) RecordOp(op, path string, err error) {
+	errno, ok := err.(syscall.Errno)
+	if ok {
+		switch errno {
+		case syscall.ENOENT, syscall.EEXIST, syscall.ENOTEMPTY, syscall.ENOTDIR, syscall.EISDIR,
+			syscall.ERANGE, syscall.EINTR, ENOATTR:
+			return
+		}
+	}
+	e := ErrorLogEntry{Op: op, Path: path, Error: err.Error()}
+	if ok {
+		e.Code = errnoName(errno)
+	}
+	l.Record(e)
+}
+
+// LogRequest is a Complete handler for AWS SDK requests
+func (l *ErrorLog) LogRequest(r *request.Request) {
+	if r.Error == nil {
+		return
+	}
+	e := ErrorLogEntry{
+		Op:    r.Operation.Name,
+		Path:  requestParam(r.Params, "Key"),
+		Error: r.Error.Error(),
+	}
+	if e.Path == "" {
+		e.Path = requestParam(r.Params, "Prefix")
+	}
+	if r.HTTPResponse != nil {
+		e.Status = r.HTTPResponse.StatusCode
+		e.RequestId = r.HTTPResponse.Header.Get("x-amz-request-id")
+	}
+	if e.Status == 404 || e.Status == 412 || e.Status == 304 {
+		return
+	}
+	if awsErr, ok := r.Error.(awserr.Error); ok {
+		e.Code = awsErr.Code()
+	}
+	if reqErr, ok := r.Error.(awserr.RequestFailure); ok && e.RequestId == "" {
+		e.RequestId = reqErr.RequestID()
+	}
+	l.Record(e)
+}
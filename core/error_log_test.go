@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "gopkg.in/check.v1"
+)
+
+type ErrorLogTest struct{}
+
+var _ = Suite(&ErrorLogTest{})
+
+func countLines(t *C, path string) int {
+	f, err := os.Open(path)
+	t.Assert(err, IsNil)
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func (s *ErrorLogTest) TestErrorLogNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "errors.json")
+	l, err := NewErrorLog(path, 3)
+	t.Assert(err, IsNil)
+
+	// Expected errors are skipped
+	l.RecordOp("LookUpInode", "a/b", syscall.ENOENT)
+	t.Assert(l.Entries(), HasLen, 0)
+
+	for i := 0; i < 10; i++ {
+		l.RecordOp("FlushFile", fmt.Sprintf("f%v", i), syscall.EIO)
+		t.Assert(countLines(t, path) < 6, Equals, true)
+	}
+	entries := l.Entries()
+	t.Assert(entries, HasLen, 3)
+	t.Assert(entries[2].Path, Equals, "f9")
+	t.Assert(entries[2].Code, Equals, "EIO")
+
+	// Backend errors keep the S3 code and request ID
+	r := &request.Request{
+		Operation: &request.Operation{Name: "PutObject"},
+		Params:    &s3.PutObjectInput{Key: PString("dir/file")},
+		HTTPResponse: &http.Response{
+			StatusCode: 403,
+			Header:     http.Header{"X-Amz-Request-Id": []string{"REQ1"}},
+		},
+		Error: awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "REQ1"),
+	}
+	l.LogRequest(r)
+	r.HTTPResponse.StatusCode = 404
+	l.LogRequest(r)
+	l.Close()
+
+	// Entries are kept across restarts
+	l, err = NewErrorLog(path, 3)
+	t.Assert(err, IsNil)
+	defer l.Close()
+	entries = l.Entries()
+	t.Assert(entries, HasLen, 3)
+	t.Assert(entries[2], DeepEquals, ErrorLogEntry{
+		Time:      entries[2].Time,
+		Op:        "PutObject",
+		Path:      "dir/file",
+		Code:      "AccessDenied",
+		Status:    403,
+		RequestId: "REQ1",
+		Error:     entries[2].Error,
+	})
+	t.Assert(countLines(t, path), Equals, 3)
+}
@@ -35,6 +35,37 @@ type FileHandle struct {
 	lastReadTotal uint64
 	lastReadSizes []uint64
 	lastReadIdx   int
+
+	// --checksum-manifest validation state
+	verifyStarted bool
+	verifier      *checksumVerifier
+
+	// process that opened the handle, for --max-open-files-per-process
+	pid    uint32
+	opened time.Time
+	// unix nanoseconds of the last read or write, atomic
+	lastIO int64
+
+	// O_SYNC or O_DSYNC: every write is flushed before it returns
+	syncWrites bool
+	// O_NOATIME: reads don't update the access time
+	noAtime bool
+
+	// --pin-etag: generation and ETag of the object when the handle was
+	// opened, and its version for --stale-handle version
+	pinned      bool
+	remoteGen   uint64
+	etag        string
+	versionId   *string
+	versionSize uint64
+}
+
+// setOpenFlags applies per-handle hints from open(2) flags. They only come
+// with OpenFileOp: fusego's CreateFileOp doesn't carry them, so the handle
+// returned by create, like handles opened on Windows, uses the defaults.
+func (fh *FileHandle) setOpenFlags(flags uint32) {
+	fh.syncWrites = flags&(O_SYNC|O_DSYNC) != 0
+	fh.noAtime = O_NOATIME != 0 && flags&O_NOATIME != 0
 }
 
 // On Linux and MacOS, IOV_MAX = 1024
@@ -119,6 +150,9 @@ func (inode *Inode) ResizeUnlocked(newSize uint64, finalizeFlushed bool) {
 		_, allocated = inode.buffers.ZeroRange(inode.Attributes.Size, newSize-inode.Attributes.Size)
 	}
 	inode.fs.bufferPool.Use(allocated, true)
+	if inode.Attributes.Size != newSize {
+		inode.hashResize(newSize)
+	}
 	inode.Attributes.Size = newSize
 }
 
@@ -133,6 +167,10 @@ func (inode *Inode) checkPauseWriters() {
 
 func (fh *FileHandle) WriteFile(offset int64, data []byte, copyData bool) (err error) {
 	fh.inode.logFuse("WriteFile", offset, len(data))
+	fh.touchIO()
+	if fh.inode.readOnlyView() {
+		return syscall.EROFS
+	}
 
 	end := uint64(offset) + uint64(len(data))
 
@@ -166,6 +204,8 @@ func (fh *FileHandle) WriteFile(offset int64, data []byte, copyData bool) (err e
 
 	fh.inode.checkPauseWriters()
 
+	fh.inode.hashWrite(uint64(offset), data)
+
 	if fh.inode.Attributes.Size < end {
 		// Extend and zero fill
 		fh.inode.ResizeUnlocked(end, false)
@@ -175,18 +215,24 @@ func (fh *FileHandle) WriteFile(offset int64, data []byte, copyData bool) (err e
 	atomic.StoreUint64(&fh.inode.fs.hasNewWrites, 1)
 
 	fh.inode.lastWriteEnd = end
+	fh.inode.accessTime = clock.Now()
 	if fh.inode.CacheState == ST_CACHED {
 		fh.inode.SetCacheState(ST_MODIFIED)
 	}
 	// FIXME: Don't activate the flusher immediately for small writes
 	fh.inode.fs.WakeupFlusher()
-	fh.inode.Attributes.Mtime = time.Now()
+	fh.inode.Attributes.Mtime = clock.Now()
 	fh.inode.Attributes.Ctime = fh.inode.Attributes.Mtime
 	if fh.inode.fs.flags.EnableMtime && fh.inode.userMetadata != nil &&
 		fh.inode.userMetadata[fh.inode.fs.flags.MtimeAttr] != nil {
 		delete(fh.inode.userMetadata, fh.inode.fs.flags.MtimeAttr)
 		fh.inode.userMetadataDirty = 2
 	}
+	if fh.inode.fs.flags.EnableBtime && fh.inode.userMetadata != nil && !fh.inode.Attributes.Btime.IsZero() &&
+		(fh.inode.userMetadataDirty == 0 || fh.inode.userMetadata[fh.inode.fs.flags.BtimeAttr] == nil) {
+		// The object will be rewritten, keep the birth time of the old one
+		fh.inode.setBtime(fh.inode.Attributes.Btime)
+	}
 
 	fh.inode.mu.Unlock()
 
@@ -197,6 +243,10 @@ func (fh *FileHandle) WriteFile(offset int64, data []byte, copyData bool) (err e
 		err = fh.inode.fs.bufferPool.Use(allocated-int64(len(data)), true)
 	}
 
+	if err == nil && fh.syncWrites {
+		err = fh.inode.SyncFile()
+	}
+
 	return
 }
 
@@ -261,9 +311,15 @@ func (inode *Inode) loadFromDisk(diskRanges []Range) (allocated int64, err error
 	for _, rr := range diskRanges {
 		readSize := rr.End - rr.Start
 		data := make([]byte, readSize)
-		_, err = inode.DiskCacheFD.ReadAt(data, int64(rr.Start))
-		if err == nil {
-			inode.buffers.ReviveFromDisk(rr.Start, data)
+		_, readErr := inode.DiskCacheFD.ReadAt(data, int64(rr.Start))
+		if readErr != nil {
+			inode.buffers.AbortLoadingFromDisk(rr.Start)
+			err = readErr
+		} else if inode.buffers.ReviveFromDisk(rr.Start, data) != nil {
+			log.Errorf("Spilled data of %v at %v+%v is corrupted in the disk cache", inode.FullName(), rr.Start, readSize)
+			err = syscall.EIO
+		} else {
+			allocated += int64(readSize)
 		}
 	}
 	return
@@ -358,6 +414,7 @@ func (inode *Inode) retryRead(cloud StorageBackend, key string, offset, size uin
 	}
 	inode.mu.Lock()
 	inode.LockRange(offset, size, false)
+	etag, gen := inode.knownETag, inode.remoteGen
 	inode.mu.Unlock()
 	// We want to retry all errors and sometimes even OK states because S3 may
 	// sometimes return 200 or 206 and then drop the connection if some data
@@ -365,7 +422,14 @@ func (inode *Inode) retryRead(cloud StorageBackend, key string, offset, size uin
 	allocated := int64(0)
 	curOffset, curSize := offset, size
 	err := ReadBackoff(inode.fs.flags, func(attempt int) error {
-		alloc, done, err := inode.sendRead(cloud, key, curOffset, curSize)
+		var ifMatch *string
+		if inode.fs.flags.PinETag && etag != "" {
+			ifMatch = &etag
+		}
+		alloc, done, err := inode.sendRead(cloud, key, curOffset, curSize, ifMatch)
+		if ifMatch != nil && mapAwsError(err) == syscall.EBUSY {
+			err = inode.readETagChanged(cloud, key, &etag, gen)
+		}
 		if err != nil && shouldRetry(err) {
 			s3Log.Warnf("Error reading %v +%v of %v (attempt %v): %v", curOffset, curSize, key, attempt, err)
 		}
@@ -389,11 +453,46 @@ func (inode *Inode) retryRead(cloud StorageBackend, key string, offset, size uin
 	}
 }
 
-func (inode *Inode) sendRead(cloud StorageBackend, key string, offset, size uint64) (allocated int64, totalDone uint64, err error) {
+// readETagChanged handles a failed If-Match of a --pin-etag read. The ETag
+// may be changed by our own flush, then the read is retried with the new one.
+// Otherwise the object is replaced remotely: the inode is refreshed, which
+// drops the cache, and handles opened before get ESTALE
+func (inode *Inode) readETagChanged(cloud StorageBackend, key string, etag *string, gen uint64) error {
+	inode.mu.Lock()
+	if inode.remoteGen != gen {
+		inode.mu.Unlock()
+		return syscall.ESTALE
+	}
+	if inode.knownETag != *etag {
+		*etag = inode.knownETag
+		inode.mu.Unlock()
+		return syscall.EAGAIN
+	}
+	inode.mu.Unlock()
+	resp, err := cloud.HeadBlob(&HeadBlobInput{Key: key, OpID: inode.lastOp()})
+	if err != nil {
+		return mapAwsError(err)
+	}
+	s3Log.Warnf("%v is replaced remotely during read", key)
+	inode.SetFromBlobItem(&resp.BlobItemOutput)
+	return syscall.ESTALE
+}
+
+func (inode *Inode) sendRead(cloud StorageBackend, key string, offset, size uint64, ifMatch *string) (allocated int64, totalDone uint64, err error) {
+	count := size
+	inode.mu.Lock()
+	if offset == 0 && size == inode.knownSize {
+		// Read the whole object without a Range, so that its checksum
+		// can be verified
+		count = 0
+	}
+	inode.mu.Unlock()
 	resp, err := cloud.GetBlob(&GetBlobInput{
-		Key:   key,
-		Start: offset,
-		Count: size,
+		Key:     key,
+		Start:   offset,
+		Count:   count,
+		IfMatch: ifMatch,
+		OpID:    inode.lastOp(),
 	})
 	if err != nil {
 		return 0, 0, err
@@ -404,6 +503,9 @@ func (inode *Inode) sendRead(cloud StorageBackend, key string, offset, size uint
 		if bs > READ_BUF_SIZE {
 			bs = READ_BUF_SIZE
 		}
+		if inode.fs.readLimiter != nil {
+			inode.fs.readLimiter.Wait(int64(bs))
+		}
 		buf := make([]byte, bs)
 		done := uint64(0)
 		for done < bs {
@@ -541,6 +643,7 @@ func (fh *FileHandle) ReadFile(sOffset int64, sLen int64) (data [][]byte, bytesR
 	size := uint64(sLen)
 
 	fh.inode.logFuse("ReadFile", offset, size)
+	fh.touchIO()
 	defer func() {
 		fh.inode.logFuse("< ReadFile", bytesRead, err)
 		if err != nil {
@@ -554,6 +657,11 @@ func (fh *FileHandle) ReadFile(sOffset int64, sLen int64) (data [][]byte, bytesR
 	fh.inode.mu.Lock()
 	defer fh.inode.mu.Unlock()
 
+	if fh.pinned && fh.remoteGen != fh.inode.remoteGen {
+		// The object was replaced after open
+		return fh.staleRead(offset, size)
+	}
+
 	if offset >= fh.inode.Attributes.Size {
 		// nothing to read
 		err = io.EOF
@@ -562,6 +670,10 @@ func (fh *FileHandle) ReadFile(sOffset int64, sLen int64) (data [][]byte, bytesR
 	if offset+size > fh.inode.Attributes.Size {
 		size = fh.inode.Attributes.Size - offset
 	}
+	if !fh.noAtime {
+		fh.inode.accessTime = clock.Now()
+		fh.inode.touchAtime(fh.inode.accessTime)
+	}
 
 	// Guard buffers against eviction
 	fh.inode.LockRange(offset, size, false)
@@ -574,6 +686,10 @@ func (fh *FileHandle) ReadFile(sOffset int64, sLen int64) (data [][]byte, bytesR
 	if !miss {
 		atomic.AddInt64(&fh.inode.fs.stats.readHits, 1)
 	}
+	if fh.pinned && fh.remoteGen != fh.inode.remoteGen {
+		// Replaced while loading
+		return fh.staleRead(offset, size)
+	}
 	mappedErr := mapAwsError(requestErr)
 	if requestErr != nil {
 		err = requestErr
@@ -603,6 +719,7 @@ func (fh *FileHandle) ReadFile(sOffset int64, sLen int64) (data [][]byte, bytesR
 	}
 
 	bytesRead = int(size)
+	fh.verifyRead(offset, data)
 
 	return
 }
@@ -615,6 +732,10 @@ func (fh *FileHandle) Release() {
 	}
 	if n == 0 {
 		fh.inode.Parent.addModified(-1)
+		if fh.inode.fs.flags.HonorCacheControl {
+			// Release may be called with fs.mu held, so don't take inode.mu here
+			go fh.inode.releaseNoStore()
+		}
 	}
 	fh.inode.fs.WakeupFlusher()
 }
@@ -633,9 +754,61 @@ func (inode *Inode) getMultiReader(offset, size uint64) (reader *MultiReader, id
 	return reader, ids, err
 }
 
+// getDiskMultiReader is like getMultiReader, but also accepts buffers which
+// are only present in the disk cache. They are read lazily by the returned
+// reader, so the caller must close the returned file after using the reader.
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) getDiskMultiReader(offset, size uint64) (reader *MultiReader, ids map[uint64]bool, file *os.File, err error) {
+	holes, loading, flushCleared := inode.buffers.GetHoles(offset, size)
+	if len(holes) > 0 || loading || flushCleared {
+		return nil, nil, nil, ErrBufferIsMissing
+	}
+	inode.buffers.SplitAt(offset)
+	inode.buffers.SplitAt(offset + size)
+	reader = NewMultiReader()
+	ids = make(map[uint64]bool)
+	inode.buffers.Ascend(offset+1, func(end uint64, b *FileBuffer) (cont bool, changed bool) {
+		if b.offset >= offset+size {
+			return false, false
+		}
+		if b.dirtyID != 0 {
+			ids[b.dirtyID] = true
+		}
+		if b.zero {
+			reader.AddZero(b.length)
+		} else if b.data != nil {
+			reader.AddBuffer(b.data)
+		} else if !b.onDisk {
+			err = ErrBufferIsMissing
+			return false, false
+		} else {
+			if file == nil {
+				// Use a separate descriptor, DiskCacheFD may be closed by FDCloser
+				file, err = os.Open(inode.fs.flags.CachePath + "/" + inode.FullName())
+				if err != nil {
+					return false, false
+				}
+			}
+			if sum := b.checksum(); sum != nil {
+				reader.AddCheckedFile(file, b.offset, b.length, sum.crc)
+			} else {
+				reader.AddFile(file, b.offset, b.length)
+			}
+		}
+		return true, false
+	})
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, nil, nil, err
+	}
+	return
+}
+
 func (inode *Inode) recordFlushError(err error) {
 	inode.flushError = err
-	inode.flushErrorTime = time.Now()
+	inode.flushErrorTime = clock.Now()
 	// The original idea was to schedule retry only if err != nil
 	// However, current version unblocks flushing in case of bugs, so... okay. Let it be
 	inode.fs.ScheduleRetryFlush()
@@ -656,7 +829,7 @@ func (inode *Inode) TryFlush(priority int) bool {
 	if inode.Parent != parent {
 		return false
 	}
-	if inode.flushError != nil && time.Now().Sub(inode.flushErrorTime) < inode.fs.flags.RetryInterval {
+	if inode.flushError != nil && clock.Now().Sub(inode.flushErrorTime) < inode.fs.flags.RetryInterval {
 		inode.fs.ScheduleRetryFlush()
 		return false
 	}
@@ -667,11 +840,15 @@ func (inode *Inode) TryFlush(priority int) bool {
 		}
 	} else if (inode.CacheState == ST_CREATED || inode.CacheState == ST_MODIFIED) && inode.isDir() {
 		if inode.IsFlushing == 0 && !overDeleted {
-			inode.SendMkDir()
+			if inode.dir.metaDirty {
+				inode.sendDirMeta()
+			} else {
+				inode.SendMkDir()
+			}
 			return true
 		}
 	} else if inode.CacheState == ST_CREATED || inode.CacheState == ST_MODIFIED {
-		if overDeleted {
+		if overDeleted || inode.flushPostponed() {
 			return false
 		}
 		return inode.sendUpload(priority)
@@ -679,6 +856,16 @@ func (inode *Inode) TryFlush(priority int) bool {
 	return false
 }
 
+// usePatch reports if the object is updated in place with --enable-patch,
+// which only works if the backend supports it
+func (inode *Inode) usePatch() bool {
+	if !inode.fs.flags.UsePatch {
+		return false
+	}
+	cloud, _ := inode.cloud()
+	return cloud.Capabilities().Patch
+}
+
 func (inode *Inode) sendUpload(priority int) bool {
 	if inode.oldParent != nil && inode.IsFlushing == 0 && inode.mpu == nil {
 		// Rename file
@@ -702,7 +889,7 @@ func (inode *Inode) sendUpload(priority int) bool {
 	}
 
 	smallFile := inode.Attributes.Size <= inode.fs.flags.SinglePartMB*1024*1024
-	canPatch := inode.fs.flags.UsePatch &&
+	canPatch := inode.usePatch() &&
 		// Can only patch modified inodes with completed MPUs.
 		inode.CacheState == ST_MODIFIED && inode.mpu == nil &&
 		// In current implemetation we should not patch big simple objects. Reupload them as multiparts first.
@@ -736,7 +923,7 @@ func (inode *Inode) sendUpload(priority int) bool {
 		if inode.IsFlushing > 0 {
 			return false
 		}
-		if inode.fs.flags.UsePatch && inode.fs.flags.PreferPatchUploads {
+		if inode.fs.flags.PreferPatchUploads && inode.usePatch() {
 			inode.uploadMinMultipart()
 		} else {
 			inode.sendStartMultipart()
@@ -791,18 +978,29 @@ func (inode *Inode) sendRename() {
 		from += "/"
 		skipRename = true
 	}
+	// Backends without server-side copies (ADL) rename files at once
+	caps := cloud.Capabilities()
+	moved := !inode.isDir() && !caps.ServerSideCopy && caps.ServerSideRename
 	go func() {
 		var err error
 		if !inode.isDir() || !inode.fs.flags.NoDirObject {
-			// We don't use RenameBlob here even for hypothetical clouds that support it (not S3),
-			// because if we used it we'd have to do it under the inode lock. Because otherwise
-			// a parallel read could hit a non-existing name. So, with S3, we do it in 2 passes.
-			// First we copy the object, change the inode name, and then we delete the old copy.
+			// We don't use RenameBlob here if we can copy, because if we used it we'd have
+			// to do it under the inode lock. Because otherwise a parallel read could hit a
+			// non-existing name. So, with S3, we do it in 2 passes. First we copy the
+			// object, change the inode name, and then we delete the old copy.
 			inode.fs.addInflightChange(key)
-			_, err = cloud.CopyBlob(&CopyBlobInput{
-				Source:      from,
-				Destination: key,
-			})
+			if moved {
+				_, err = cloud.RenameBlob(&RenameBlobInput{
+					Source:      from,
+					Destination: key,
+				})
+			} else {
+				_, err = cloud.CopyBlob(&CopyBlobInput{
+					Source:      from,
+					Destination: key,
+					OpID:        inode.changeOp(),
+				})
+			}
 			inode.fs.completeInflightChange(key)
 			notFoundIgnore := false
 			if err != nil {
@@ -833,6 +1031,7 @@ func (inode *Inode) sendRename() {
 						}
 						oldParent.mu.Unlock()
 					}
+					inode.fs.renameMoved(cloud, from)
 				} else {
 					log.Warnf("Failed to copy %v to %v (rename): %v", from, key, err)
 					inode.mu.Lock()
@@ -847,7 +1046,7 @@ func (inode *Inode) sendRename() {
 						if (inode.CacheState == ST_MODIFIED || inode.CacheState == ST_CREATED) &&
 							!inode.isStillDirty() {
 							inode.SetCacheState(ST_CACHED)
-							inode.SetAttrTime(time.Now())
+							inode.SetAttrTime(clock.Now())
 						}
 					}
 					inode.mu.Unlock()
@@ -855,6 +1054,9 @@ func (inode *Inode) sendRename() {
 			}
 			if err == nil {
 				log.Debugf("Copied %v to %v (rename)", from, key)
+				if !notFoundIgnore {
+					inode.fs.recordChange("put", key, from, "", 0)
+				}
 				delKey := from
 				delParent := oldParent
 				delName := oldName
@@ -864,7 +1066,7 @@ func (inode *Inode) sendRename() {
 					// Just clear the old path
 					inode.oldParent = nil
 					inode.oldName = ""
-				} else if inode.Parent == oldParent && inode.Name == oldName {
+				} else if inode.Parent == oldParent && inode.Name == oldName && !moved {
 					// Someone renamed the inode back to the original name(!)
 					inode.oldParent = nil
 					inode.oldName = ""
@@ -873,22 +1075,24 @@ func (inode *Inode) sendRename() {
 					delParent = newParent
 					delName = newName
 				} else {
-					// Someone renamed the inode again(!)
+					// Someone renamed the inode again(!), or back after the object
+					// is renamed, then it's renamed back with the next flush
 					inode.oldParent = newParent
 					inode.oldName = newName
 				}
 				if (inode.CacheState == ST_MODIFIED || inode.CacheState == ST_CREATED) &&
 					!inode.isStillDirty() {
 					inode.SetCacheState(ST_CACHED)
-					inode.SetAttrTime(time.Now())
+					inode.SetAttrTime(clock.Now())
 				}
 				inode.renamingTo = false
 				inode.mu.Unlock()
 				// Now delete the old key
-				if !notFoundIgnore {
+				if !notFoundIgnore && !moved {
 					inode.fs.addInflightChange(delKey)
 					_, err = cloud.DeleteBlob(&DeleteBlobInput{
-						Key: delKey,
+						Key:  delKey,
+						OpID: inode.changeOp(),
 					})
 					inode.fs.completeInflightChange(delKey)
 				}
@@ -908,12 +1112,22 @@ func (inode *Inode) sendRename() {
 					delParent.mu.Unlock()
 				} else {
 					log.Debugf("Deleted %v - rename completed", from)
+					if !notFoundIgnore {
+						inode.fs.recordChange("delete", delKey, "", "", 0)
+					}
+					if inode.isDir() {
+						// Markers of other tools would revive the old directory
+						if err := inode.fs.deleteDirMarkers(cloud, delKey); err != nil {
+							log.Warnf("Failed to delete directory markers of %v: %v", delKey, err)
+						}
+					}
 					// Remove from DeletedChildren of the old parent
 					delParent.mu.Lock()
 					delete(delParent.dir.DeletedChildren, delName)
 					delParent.mu.Unlock()
 					// And track ModifiedChildren because rename is special - it takes two parents
 					delParent.addModified(-1)
+					inode.fs.renameMoved(cloud, from)
 				}
 			}
 		}
@@ -941,7 +1155,8 @@ func (inode *Inode) sendUpdateMeta() {
 		Destination: key,
 		Size:        PUInt64(inode.knownSize),
 		ETag:        PString(inode.knownETag),
-		Metadata:    escapeMetadata(inode.userMetadata),
+		Metadata:    inode.fs.stampWriter(escapeMetadata(inode.userMetadata)),
+		OpID:        inode.changeOp(),
 	}
 	go func() {
 		inode.fs.addInflightChange(key)
@@ -958,9 +1173,12 @@ func (inode *Inode) sendUpdateMeta() {
 				inode.resetCache()
 			}
 			log.Warnf("Error flushing metadata using COPY for %v: %v", key, err)
-		} else if inode.CacheState == ST_MODIFIED && !inode.isStillDirty() {
-			inode.SetCacheState(ST_CACHED)
-			inode.SetAttrTime(time.Now())
+		} else {
+			inode.fs.recordChange("put", key, "", inode.knownETag, inode.knownSize)
+			if inode.CacheState == ST_MODIFIED && !inode.isStillDirty() {
+				inode.SetCacheState(ST_CACHED)
+				inode.SetAttrTime(clock.Now())
+			}
 		}
 		inode.IsFlushing -= inode.fs.flags.MaxParallelParts
 		atomic.AddInt64(&inode.fs.activeFlushers, -1)
@@ -974,11 +1192,12 @@ func (inode *Inode) sendStartMultipart() {
 	if inode.isDir() {
 		key += "/"
 	}
+	params := inode.multipartBeginInput(key)
 	inode.IsFlushing += inode.fs.flags.MaxParallelParts
 	atomic.AddInt64(&inode.fs.stats.flushes, 1)
 	atomic.AddInt64(&inode.fs.activeFlushers, 1)
 	go func() {
-		inode.beginMultipartUpload(cloud, key)
+		inode.beginMultipartUpload(cloud, params)
 		inode.IsFlushing -= inode.fs.flags.MaxParallelParts
 		atomic.AddInt64(&inode.fs.activeFlushers, -1)
 		inode.fs.WakeupFlusher()
@@ -986,10 +1205,21 @@ func (inode *Inode) sendStartMultipart() {
 	}()
 }
 
-func (inode *Inode) beginMultipartUpload(cloud StorageBackend, key string) {
+// multipartBeginInput takes the metadata and the content hash for a new
+// multipart upload. It's called before the upload goroutine is started
+// because other flushes change them under the lock
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) multipartBeginInput(key string) *MultipartBlobBeginInput {
 	params := &MultipartBlobBeginInput{
 		Key:         key,
 		ContentType: inode.fs.flags.GetMimeType(key),
+		OpID:        inode.changeOp(),
+	}
+	if inode.fileHandles == 0 {
+		// The file is probably complete, so the hash is probably final
+		if sum := inode.contentHashSum(); sum != nil {
+			inode.storeContentHash(sum)
+		}
 	}
 	if inode.userMetadataDirty != 0 {
 		params.Metadata = escapeMetadata(inode.userMetadata)
@@ -997,7 +1227,21 @@ func (inode *Inode) beginMultipartUpload(cloud StorageBackend, key string) {
 		// since the multipart upload was initiated
 		inode.userMetadataDirty = 1
 	}
-	resp, err := cloud.MultipartBlobBegin(params)
+	params.Metadata = inode.fs.stampWriter(params.Metadata)
+	return params
+}
+
+// beginMultipartUpload returns with inode.mu locked
+func (inode *Inode) beginMultipartUpload(cloud StorageBackend, params *MultipartBlobBeginInput) {
+	key := params.Key
+	var resp *MultipartBlobCommitInput
+	err := inode.fs.writeIntents.Begin(key)
+	if err == nil {
+		resp, err = cloud.MultipartBlobBegin(params)
+		if err != nil {
+			inode.fs.writeIntents.End(key)
+		}
+	}
 	inode.mu.Lock()
 	inode.recordFlushError(err)
 	if err != nil {
@@ -1126,6 +1370,7 @@ func (inode *Inode) uploadMinMultipart() {
 	if inode.isDir() {
 		key += "/"
 	}
+	params := inode.multipartBeginInput(key)
 
 	go func() {
 		defer func() {
@@ -1136,7 +1381,7 @@ func (inode *Inode) uploadMinMultipart() {
 		}()
 
 		atomic.AddInt64(&inode.fs.stats.flushes, 1)
-		inode.beginMultipartUpload(cloud, key)
+		inode.beginMultipartUpload(cloud, params)
 		if inode.mpu == nil {
 			return
 		}
@@ -1368,6 +1613,7 @@ func (inode *Inode) sendPatch(offset, size uint64, r io.ReadSeeker, partSize uin
 		Size:           size,
 		AppendPartSize: int64(partSize),
 		Body:           r,
+		OpID:           inode.changeOp(),
 	})
 	inode.fs.completeInflightChange(key)
 	inode.mu.Lock()
@@ -1395,7 +1641,7 @@ func (inode *Inode) sendPatch(offset, size uint64, r io.ReadSeeker, partSize uin
 	}
 
 	log.Debugf("Succesfully patched range %d-%d of file %s (inode %d), etag: %s", offset, offset+size, key, inode.Id, NilStr(resp.ETag))
-	inode.updateFromFlush(MaxUInt64(inode.knownSize, offset+size), resp.ETag, resp.LastModified, nil)
+	inode.updateFromFlush(key, MaxUInt64(inode.knownSize, offset+size), resp.ETag, resp.LastModified, nil, nil)
 	return true
 }
 
@@ -1411,11 +1657,8 @@ func (inode *Inode) isStillDirty() bool {
 	return inode.buffers.AnyUnclean()
 }
 
-func (inode *Inode) resetCache() {
-	// Drop all buffers including dirty ones
-	allocated := inode.buffers.RemoveRange(0, 0xffffffffffffffff, nil)
-	inode.fs.bufferPool.Use(allocated, true)
-	// Also remove the cache file from disk, if present
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) removeDiskCache() {
 	if inode.OnDisk {
 		if inode.DiskCacheFD != nil {
 			inode.DiskCacheFD.Close()
@@ -1430,6 +1673,14 @@ func (inode *Inode) resetCache() {
 			inode.OnDisk = false
 		}
 	}
+}
+
+func (inode *Inode) resetCache() {
+	// Drop all buffers including dirty ones
+	allocated := inode.buffers.RemoveRange(0, 0xffffffffffffffff, nil)
+	inode.fs.bufferPool.Use(allocated, true)
+	// Also remove the cache file from disk, if present
+	inode.removeDiskCache()
 	// And abort multipart upload, too
 	if inode.mpu != nil {
 		inode.abortMultipart()
@@ -1443,6 +1694,8 @@ func (inode *Inode) resetCache() {
 func (inode *Inode) abortMultipart() {
 	cloud, key := inode.cloud()
 	go func(mpu *MultipartBlobCommitInput) {
+		// Don't make other mounts wait for an upload which won't complete
+		defer inode.fs.writeIntents.End(NilStr(mpu.Key))
 		_, abortErr := cloud.MultipartBlobAbort(mpu)
 		if abortErr != nil {
 			log.Warnf("Failed to abort multi-part upload of object %v: %v", key, abortErr)
@@ -1479,6 +1732,27 @@ func (inode *Inode) flushSmallObject() {
 			inode.mu.Unlock()
 			return
 		}
+	} else if inode.fs.flags.SpillDirty {
+		// Load spilled dirty buffers back, the object is small
+		diskRanges := inode.buffers.AddLoadingFromDisk(0, sz)
+		if len(diskRanges) > 0 {
+			allocated, err := inode.loadFromDisk(diskRanges)
+			// Correct memory usage without the inode lock
+			inode.mu.Unlock()
+			inode.fs.bufferPool.Use(allocated, true)
+			inode.mu.Lock()
+			if err != nil {
+				// Retrying won't help, return EIO from fsync
+				log.Errorf("Failed to load spilled data of %v from the disk cache: %v", inode.FullName(), err)
+				inode.recordFlushError(syscall.EIO)
+				inode.UnlockRange(0, sz, true)
+				inode.IsFlushing -= inode.fs.flags.MaxParallelParts
+				atomic.AddInt64(&inode.fs.activeFlushers, -1)
+				inode.fs.WakeupFlusher()
+				inode.mu.Unlock()
+				return
+			}
+		}
 	}
 
 	// Key may have been changed in between (if it was moved)
@@ -1504,11 +1778,27 @@ func (inode *Inode) flushSmallObject() {
 		Body:        bufReader,
 		Size:        PUInt64(uint64(bufReader.Len())),
 		ContentType: inode.fs.flags.GetMimeType(inode.FullName()),
+		OpID:        inode.changeOp(),
 	}
-	if inode.userMetadataDirty != 0 {
+	if inode.fs.flags.ContentHash != "" {
+		h := inode.fs.newContentHash()
+		_, err = io.Copy(h, bufReader)
+		if err == nil {
+			_, err = bufReader.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			inode.storeContentHash(inode.fs.encodeContentHash(h.Sum(nil)))
+		} else {
+			log.Warnf("Failed to calculate content hash of %v: %v", key, err)
+			inode.dropContentHash()
+		}
+	}
+	metaDirty := inode.userMetadataDirty != 0
+	if metaDirty {
 		params.Metadata = escapeMetadata(inode.userMetadata)
 		inode.userMetadataDirty = 0
 	}
+	params.Metadata = inode.fs.stampWriter(params.Metadata)
 
 	if inode.mpu != nil {
 		// Abort and forget abort multipart upload, because otherwise we may
@@ -1516,21 +1806,26 @@ func (inode *Inode) flushSmallObject() {
 		inode.abortMultipart()
 	}
 	inode.mu.Unlock()
-	inode.fs.addInflightChange(key)
-	resp, err := cloud.PutBlob(params)
-	inode.fs.completeInflightChange(key)
+	var resp *PutBlobOutput
+	err = inode.fs.writeIntents.Begin(key)
+	if err == nil {
+		inode.fs.addInflightChange(key)
+		resp, err = cloud.PutBlob(params)
+		inode.fs.completeInflightChange(key)
+		inode.fs.writeIntents.End(key)
+	}
 	inode.mu.Lock()
 
 	inode.recordFlushError(err)
 	if err != nil {
 		log.Warnf("Failed to flush small file %v: %v", key, err)
-		if params.Metadata != nil {
+		if metaDirty {
 			inode.userMetadataDirty = 2
 		}
 	} else {
 		log.Debugf("Flushed small file %v (inode %v): etag=%v, size=%v", key, inode.Id, NilStr(resp.ETag), sz)
 		inode.buffers.SetState(0, sz, bufIds, BUF_CLEAN)
-		inode.updateFromFlush(sz, resp.ETag, resp.LastModified, resp.StorageClass)
+		inode.updateFromFlush(key, sz, resp.ETag, resp.LastModified, resp.StorageClass, resp.Checksum)
 		if inode.CacheState == ST_CREATED || inode.CacheState == ST_MODIFIED {
 			if !inode.isStillDirty() {
 				inode.SetCacheState(ST_CACHED)
@@ -1648,8 +1943,21 @@ func (inode *Inode) flushPart(part uint64) {
 		partSize = inode.Attributes.Size - partOffset
 	}
 
+	var bufReader *MultiReader
+	var bufIds map[uint64]bool
+	var diskFile *os.File
+	var err error
+	if (inode.CacheState == ST_MODIFIED || inode.fs.flags.SpillDirty) && inode.fs.flags.CachePath != "" {
+		// Don't load parts which partly live in the disk cache into memory,
+		// stream them from the disk while uploading
+		bufReader, bufIds, diskFile, err = inode.getDiskMultiReader(partOffset, partSize)
+		if err != nil && err != ErrBufferIsMissing {
+			log.Warnf("Failed to open disk cache of object %v to flush part %v: %v", key, part, err)
+		}
+	}
+
 	// Load part from the server if we have to read-modify-write it
-	if inode.CacheState == ST_MODIFIED {
+	if bufReader == nil && inode.CacheState == ST_MODIFIED {
 		// Ignore memory limit to not produce a deadlock when we need to free some memory
 		// by flushing objects, but we can't flush a part without allocating more memory
 		// for read-modify-write...
@@ -1682,14 +1990,19 @@ func (inode *Inode) flushPart(part uint64) {
 
 	if inode.mpu == nil {
 		// Multipart upload was canceled in the meantime => don't flush
+		if diskFile != nil {
+			diskFile.Close()
+		}
 		return
 	}
 
 	// Finally upload it
-	bufReader, bufIds, err := inode.getMultiReader(partOffset, partSize)
-	if err != nil {
-		log.Errorf("BUG: Failed to get MultiReader for flushed part %v (%v-%v) of object %v: %v", part, partOffset, partSize, key, err)
-		return
+	if bufReader == nil {
+		bufReader, bufIds, err = inode.getMultiReader(partOffset, partSize)
+		if err != nil {
+			log.Errorf("BUG: Failed to get MultiReader for flushed part %v (%v-%v) of object %v: %v", part, partOffset, partSize, key, err)
+			return
+		}
 	}
 	bufLen := bufReader.Len()
 	partInput := MultipartBlobAddInput{
@@ -1699,9 +2012,17 @@ func (inode *Inode) flushPart(part uint64) {
 		Size:       bufLen,
 		Offset:     partOffset,
 	}
+	// Spilled buffers are streamed from the disk cache, so guard the part
+	// against spills and evictions which rewrite it until it's uploaded.
+	// Not all callers lock it already
+	inode.LockRange(partOffset, partSize, true)
 	inode.mu.Unlock()
 	resp, err := cloud.MultipartBlobAdd(&partInput)
+	if diskFile != nil {
+		diskFile.Close()
+	}
 	inode.mu.Lock()
+	inode.UnlockRange(partOffset, partSize, true)
 
 	if inode.CacheState == ST_DELETED {
 		// File was deleted while we were flushing it
@@ -1779,6 +2100,7 @@ func (inode *Inode) commitMultipartUpload(numParts, finalSize uint64) {
 	inode.fs.addInflightChange(key)
 	resp, err := cloud.MultipartBlobCommit(mpu)
 	inode.fs.completeInflightChange(key)
+	inode.fs.writeIntents.End(key)
 	inode.mu.Lock()
 	if inode.mpu != mpu || inode.CacheState != ST_CREATED && inode.CacheState != ST_MODIFIED {
 		// Already flushed or conflict => do not complete
@@ -1795,9 +2117,19 @@ func (inode *Inode) commitMultipartUpload(numParts, finalSize uint64) {
 		if inode.userMetadataDirty == 1 {
 			inode.userMetadataDirty = 0
 		}
+		if inode.Attributes.Size == finalSize {
+			// Hash wasn't known or was incomplete when the upload was started,
+			// store it with an additional metadata update
+			if sum := inode.contentHashSum(); sum != nil {
+				sent := mpu.Metadata[strings.ToLower(inode.fs.flags.ContentHashAttr)]
+				if sent == nil || *sent != string(sum) {
+					inode.storeContentHash(sum)
+				}
+			}
+		}
 		inode.mpu = nil
 		inode.buffers.SetFlushedClean()
-		inode.updateFromFlush(finalSize, resp.ETag, resp.LastModified, resp.StorageClass)
+		inode.updateFromFlush(key, finalSize, resp.ETag, resp.LastModified, resp.StorageClass, resp.Checksum)
 		if inode.CacheState == ST_CREATED || inode.CacheState == ST_MODIFIED {
 			if !inode.isStillDirty() {
 				inode.SetCacheState(ST_CACHED)
@@ -1808,24 +2140,37 @@ func (inode *Inode) commitMultipartUpload(numParts, finalSize uint64) {
 	}
 }
 
-func (inode *Inode) updateFromFlush(size uint64, etag *string, lastModified *time.Time, storageClass *string) {
+func (inode *Inode) updateFromFlush(key string, size uint64, etag *string, lastModified *time.Time, storageClass *string,
+	checksum *ObjectChecksum) {
+	inode.fs.recordChange("put", key, "", NilStr(etag), size)
 	if etag != nil {
 		inode.s3Metadata["etag"] = []byte(*etag)
 	}
 	if storageClass != nil {
 		inode.s3Metadata["storage-class"] = []byte(*storageClass)
 	}
+	// The object is replaced, so the old checksum is gone
+	inode.setChecksumXattr(checksum)
 	if lastModified != nil {
 		inode.Attributes.Ctime = *lastModified
 	}
+	if inode.fs.writer != "" && inode.userMetadata != nil {
+		inode.userMetadata[writerMetaKey] = []byte(inode.fs.writer)
+	}
 	inode.knownSize = size
 	inode.knownETag = *etag
-	inode.SetAttrTime(time.Now())
+	inode.SetAttrTime(clock.Now())
+	inode.storeObjectMeta(etag)
 }
 
 func (inode *Inode) SyncFile() (err error) {
 	inode.logFuse("SyncFile")
 	for {
+		// Taken before the state is checked, so that a flush which finishes
+		// in between isn't missed
+		inode.fs.flusherMu.Lock()
+		wakeups := inode.fs.flushWakeups
+		inode.fs.flusherMu.Unlock()
 		inode.mu.Lock()
 		inode.forceFlush = false
 		if inode.CacheState <= ST_DEAD {
@@ -1842,7 +2187,7 @@ func (inode *Inode) SyncFile() (err error) {
 		inode.mu.Unlock()
 		inode.TryFlush(MAX_FLUSH_PRIORITY)
 		inode.fs.flusherMu.Lock()
-		if inode.fs.flushPending == 0 {
+		if inode.fs.flushWakeups == wakeups {
 			inode.fs.flusherCond.Wait()
 		}
 		inode.fs.flusherMu.Unlock()
@@ -1854,6 +2199,15 @@ func (inode *Inode) SyncFile() (err error) {
 func (inode *Inode) SetAttributes(size *uint64, mode *os.FileMode,
 	mtime *time.Time, uid *uint32, gid *uint32) (err error) {
 
+	if inode.readOnlyView() {
+		return syscall.EROFS
+	}
+	if inode.metaNode {
+		return inode.setNodeAttributes(mode, mtime, uid, gid)
+	}
+	if (mode != nil || uid != nil || gid != nil) && inode.dirMetaReadOnly() {
+		return syscall.EROFS
+	}
 	if inode.Parent == nil {
 		// chmod/chown on the root directory of mountpoint is not supported
 		if inode.fs.flags.IgnoreSettingAttrsForRootDirErrors {
@@ -1943,6 +2297,10 @@ func (inode *Inode) SetAttributes(size *uint64, mode *os.FileMode,
 		inode.fs.WakeupFlusher()
 	}
 
+	if mode != nil || uid != nil || gid != nil {
+		inode.checkDirMeta()
+	}
+
 	if size != nil || mode != nil || mtime != nil || uid != nil || gid != nil {
 		inode.mu.Unlock()
 	}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"sync/atomic"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// flushPolicy returns the first flush policy matching the inode's path
+func (fs *Goofys) flushPolicy(inode *Inode) *cfg.FlushPolicy {
+	if len(fs.flags.FlushPolicies) == 0 {
+		return nil
+	}
+	path := inode.FullName()
+	for i := range fs.flags.FlushPolicies {
+		if fs.flags.FlushPolicies[i].Match(path) {
+			return &fs.flags.FlushPolicies[i]
+		}
+	}
+	return nil
+}
+
+// flushPostponed applies --flush-policy to a file about to be flushed.
+// Zero delay makes the file flush as if fsync was called. Non-zero delay
+// postpones the flush until the delay passes since the file became dirty,
+// unless it's fsynced or memory is low, and wakes up the flusher after that.
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) flushPostponed() bool {
+	policy := inode.fs.flushPolicy(inode)
+	if policy == nil {
+		return false
+	}
+	if policy.Delay == 0 {
+		inode.forceFlush = true
+		return false
+	}
+	if inode.forceFlush || inode.dirtySince.IsZero() || atomic.LoadInt32(&inode.fs.wantFree) > 0 {
+		return false
+	}
+	wait := policy.Delay - clock.Now().Sub(inode.dirtySince)
+	if wait <= 0 {
+		return false
+	}
+	if !inode.flushTimer {
+		inode.flushTimer = true
+		clock.AfterFunc(wait, func() {
+			inode.mu.Lock()
+			inode.flushTimer = false
+			inode.mu.Unlock()
+			inode.fs.WakeupFlusher()
+		})
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type FlushPolicyTest struct{}
+
+var _ = Suite(&FlushPolicyTest{})
+
+func waitForKey(store *SimStore, key string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, ok := store.Get(key); ok {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *FlushPolicyTest) TestFlushPolicyNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.FlushPolicies = []cfg.FlushPolicy{
+			cfg.NewFlushPolicy("*.done", 0),
+			cfg.NewFlushPolicy("*.log", 300*time.Millisecond),
+		}
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	root := m.fs.getInodeOrDie(1)
+
+	// Zero delay flushes even while the file is open
+	_, fh, err := root.Create("run.done")
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(0, []byte("ok"), true)
+	t.Assert(err, IsNil)
+	t.Assert(waitForKey(c.Store, "run.done", 2*time.Second), Equals, true)
+	fh.Release()
+
+	// Delayed files are held back after close
+	start := time.Now()
+	_, fh, err = root.Create("run.log")
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(0, []byte("line\n"), true)
+	t.Assert(err, IsNil)
+	fh.Release()
+	m.fs.WakeupFlusher()
+	t.Assert(waitForKey(c.Store, "run.log", 100*time.Millisecond), Equals, false)
+	t.Assert(waitForKey(c.Store, "run.log", 2*time.Second), Equals, true)
+	t.Assert(time.Since(start) >= 300*time.Millisecond, Equals, true)
+
+	// ...unless fsynced
+	inode, fh, err := root.Create("other.log")
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(0, []byte("line\n"), true)
+	t.Assert(err, IsNil)
+	fh.Release()
+	err = inode.SyncFile()
+	t.Assert(err, IsNil)
+	_, ok := c.Store.Get("other.log")
+	t.Assert(ok, Equals, true)
+}
+
+func (s *FlushPolicyTest) TestFlushPolicySimClockNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.FlushPolicies = []cfg.FlushPolicy{
+			cfg.NewFlushPolicy("*.log", time.Hour),
+		}
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	m := c.Mounts[0]
+
+	// Both the delay and the wakeup follow the file system clock
+	_, err = m.WriteFile("run.log", []byte("line\n"))
+	t.Assert(err, IsNil)
+	m.fs.WakeupFlusher()
+	t.Assert(waitForKey(c.Store, "run.log", 100*time.Millisecond), Equals, false)
+	c.Clock.Advance(59 * time.Minute)
+	m.fs.WakeupFlusher()
+	t.Assert(waitForKey(c.Store, "run.log", 100*time.Millisecond), Equals, false)
+	c.Clock.Advance(time.Minute)
+	t.Assert(waitForKey(c.Store, "run.log", 2*time.Second), Equals, true)
+}
@@ -0,0 +1,109 @@
+//go:build !windows
+
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// Access of other users to the mount. By default, only the mount owner can
+// access it. With -o allow_other, the kernel lets everyone in, and file
+// modes are only checked if -o default_permissions is also given.
+//
+// -o allow_root and --allow-users are implemented as allow_other with
+// a list of UIDs checked by geesefs itself on every operation, the same way
+// libfuse 3 does for allow_root, because the kernel doesn't support
+// allow_root and fusermount3 rejects it. The mount owner is always allowed.
+// Like in libfuse, operations on open handles (read, write, fsync, flush,
+// readdir and poll) aren't checked, so that file descriptors passed to other
+// processes keep working.
+//
+// Unprivileged mounts with allow_other require user_allow_other in
+// /etc/fuse.conf, so we check it before mounting to report a clear error
+// instead of failing in fusermount.
+
+const fuseConfPath = "/etc/fuse.conf"
+
+func fuseConfAllowsOther(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "user_allow_other" {
+			return true
+		}
+	}
+	return false
+}
+
+// fuseAccess adjusts FUSE mount options for the requested access mode and
+// returns the UIDs to check, or nil if access is left to the kernel, and
+// a description of the mode for the log
+func fuseAccess(flags *cfg.FlagStorage, options map[string]string, fuseConf string, euid int) (allowUids map[uint32]bool, mode string, err error) {
+	_, allowOther := options["allow_other"]
+	_, allowRoot := options["allow_root"]
+	if allowOther && allowRoot {
+		return nil, "", errors.New("allow_other and allow_root can't be used together")
+	}
+	if allowOther && len(flags.AllowUsers) > 0 {
+		return nil, "", errors.New("--allow-users can't be used with allow_other")
+	}
+	owner := uint32(euid)
+	if flags.Setuid > 0 {
+		owner = uint32(flags.Setuid)
+	}
+	if !allowOther && !allowRoot && len(flags.AllowUsers) == 0 {
+		return nil, fmt.Sprintf("mount owner only (UID %v)", owner), nil
+	}
+	if euid != 0 && runtime.GOOS == "linux" && !fuseConfAllowsOther(fuseConf) {
+		return nil, "", fmt.Errorf("access of other users requires user_allow_other in %v or mounting as root", fuseConf)
+	}
+	if allowRoot || len(flags.AllowUsers) > 0 {
+		allowUids = map[uint32]bool{owner: true}
+		if allowRoot {
+			allowUids[0] = true
+		}
+		for _, uid := range flags.AllowUsers {
+			allowUids[uid] = true
+		}
+		uids := make([]int, 0, len(allowUids))
+		for uid := range allowUids {
+			uids = append(uids, int(uid))
+		}
+		sort.Ints(uids)
+		mode = fmt.Sprintf("UIDs %v (allow_other checked by geesefs)", strings.Trim(fmt.Sprint(uids), "[]"))
+		delete(options, "allow_root")
+		options["allow_other"] = ""
+	} else {
+		mode = "all users (allow_other)"
+		if _, ok := options["default_permissions"]; !ok {
+			mode += ", file modes are not checked without default_permissions"
+		}
+	}
+	return allowUids, mode, nil
+}
+
+func (fs *GoofysFuse) checkAccess(ctx *fuseops.OpContext) error {
+	if fs.allowUids != nil && !fs.allowUids[ctx.Uid] {
+		return syscall.EACCES
+	}
+	return nil
+}
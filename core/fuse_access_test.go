@@ -0,0 +1,90 @@
+//go:build !windows
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type FuseAccessTest struct{}
+
+var _ = Suite(&FuseAccessTest{})
+
+func (s *FuseAccessTest) TestFuseAccessNoCloud(t *C) {
+	conf := filepath.Join(t.MkDir(), "fuse.conf")
+	flags := &cfg.FlagStorage{}
+
+	// Owner only by default
+	opts := map[string]string{}
+	allow, mode, err := fuseAccess(flags, opts, conf, 1000)
+	t.Assert(err, IsNil)
+	t.Assert(allow, IsNil)
+	t.Assert(mode, Equals, "mount owner only (UID 1000)")
+
+	// allow_root is checked by us, root doesn't need fuse.conf
+	opts = map[string]string{"allow_root": ""}
+	allow, _, err = fuseAccess(flags, opts, conf, 0)
+	t.Assert(err, IsNil)
+	t.Assert(allow, DeepEquals, map[uint32]bool{0: true})
+	t.Assert(opts, DeepEquals, map[string]string{"allow_other": ""})
+
+	// Unprivileged users need user_allow_other
+	flags.AllowUsers = []uint32{1001, 1002}
+	opts = map[string]string{}
+	_, _, err = fuseAccess(flags, opts, conf, 1000)
+	t.Assert(err, NotNil)
+	t.Assert(os.WriteFile(conf, []byte("# mount_max = 1000\n user_allow_other # comment\n"), 0644), IsNil)
+	allow, mode, err = fuseAccess(flags, opts, conf, 1000)
+	t.Assert(err, IsNil)
+	t.Assert(allow, DeepEquals, map[uint32]bool{1000: true, 1001: true, 1002: true})
+	t.Assert(mode, Equals, "UIDs 1000 1001 1002 (allow_other checked by geesefs)")
+
+	opts = map[string]string{"allow_other": ""}
+	_, _, err = fuseAccess(flags, opts, conf, 1000)
+	t.Assert(err, NotNil)
+
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	fs := NewGoofysFuse(c.Mounts[0].fs)
+	fs.allowUids = allow
+	err = fs.LookUpInode(nil, &fuseops.LookUpInodeOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      "x",
+		OpContext: fuseops.OpContext{Uid: 1003},
+	})
+	t.Assert(err, Equals, syscall.EACCES)
+	err = fs.LookUpInode(nil, &fuseops.LookUpInodeOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      "x",
+		OpContext: fuseops.OpContext{Uid: 1001},
+	})
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	// Open handles may be used by anyone, like passed file descriptors
+	c.Store.Put("file", []byte("data"), nil)
+	inode, err := c.Mounts[0].fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	open := &fuseops.OpenFileOp{
+		Inode:     inode.Id,
+		OpContext: fuseops.OpContext{Uid: 1003},
+	}
+	t.Assert(fs.OpenFile(nil, open), Equals, syscall.EACCES)
+	open.OpContext.Uid = 1001
+	t.Assert(fs.OpenFile(nil, open), IsNil)
+	read := &fuseops.ReadFileOp{
+		Inode:     inode.Id,
+		Handle:    open.Handle,
+		Size:      4,
+		OpContext: fuseops.OpContext{Uid: 1003},
+	}
+	t.Assert(fs.ReadFile(nil, read), IsNil)
+	t.Assert(read.BytesRead, Equals, 4)
+}
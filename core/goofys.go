@@ -23,6 +23,7 @@ import (
 	"math/rand"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -37,6 +38,7 @@ import (
 	"net/http"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 // goofys is a Filey System written in Go. All the backend data is
@@ -50,6 +52,16 @@ import (
 
 type Goofys struct {
 	bucket string
+	// full key prefix of internal temporary objects, including the mount prefix
+	tempPrefix string
+	// absolute mountpoint for --symlink-root-attr
+	mountPoint string
+	changes    *ChangeJournal
+	checksums  *checksumManifest
+	inodeMap   *InodeMap
+
+	// identity stamped on uploads with --writer-stamp
+	writer string
 
 	flags *cfg.FlagStorage
 
@@ -70,6 +82,9 @@ type Goofys struct {
 	flusherMu    sync.Mutex
 	flusherCond  *sync.Cond
 	flushPending int32
+	// counts wakeups, so that waiters don't miss ones which happen before
+	// they start waiting
+	flushWakeups uint64
 
 	// The next inode ID to hand out. We assume that this will never overflow,
 	// since even if we were handing out inode IDs at 4 GHz, it would still take
@@ -101,13 +116,24 @@ type Goofys struct {
 	dirHandles   map[fuseops.HandleID]*DirHandle
 
 	fileHandles map[fuseops.HandleID]*FileHandle
+	// open file handles per process
+	handlesByPid map[uint32]int
+	// handles counted by reserveHandle, but not yet in fileHandles
+	reservedHandles int
 
 	activeFlushers  int64
 	flushRetrySet   int32
 	hasNewWrites    uint64
 	flushPriorities []int64
+	// --atime lazy updates waiting for the next batch
+	atimeMu      sync.Mutex
+	atimePending map[*Inode]bool
 
 	forgotCnt uint32
+	// a denied listing was already reported
+	listDenied int32
+	// number of inodes pinned through the admin API, atomic
+	pinnedCount int32
 
 	cleanQueue BufferQueue
 	inodeQueue InodeQueue
@@ -118,6 +144,24 @@ type Goofys struct {
 
 	stats OpStats
 
+	tracer        *OpTracer
+	invalLog      *InvalidationLog
+	writeIntents  *WriteIntents
+	errorLog      *ErrorLog
+	adminServer   *grpc.Server
+	readLimiter   *BandwidthLimiter
+	latency       OpLatencies
+	sloViolations uint64
+
+	checksumsVerified  uint64
+	checksumMismatches uint64
+
+	usage    usageSnapshots
+	dirtyAge dirtyAgeStats
+	renames  renameJournals
+
+	metaMigrations metaMigrationState
+
 	NotifyCallback func(notifications []interface{})
 }
 
@@ -148,6 +192,10 @@ func NewBackend(bucket string, flags *cfg.FlagStorage) (cloud StorageBackend, er
 		cloud, err = NewADLv1(bucket, flags, config)
 	} else if config, ok := flags.Backend.(*cfg.ADLv2Config); ok {
 		cloud, err = NewADLv2(bucket, flags, config)
+	} else if config, ok := flags.Backend.(*cfg.LocalConfig); ok {
+		cloud, err = NewLocal(bucket, flags, config)
+	} else if config, ok := flags.Backend.(*cfg.WebDAVConfig); ok {
+		cloud, err = NewWebDAV(bucket, flags, config)
 	} else if config, ok := flags.Backend.(*cfg.S3Config); ok {
 		if strings.HasSuffix(flags.Endpoint, "/storage.googleapis.com") {
 			cloud, err = NewGCS3(bucket, flags, config)
@@ -157,6 +205,9 @@ func NewBackend(bucket string, flags *cfg.FlagStorage) (cloud StorageBackend, er
 	} else {
 		err = fmt.Errorf("Unknown backend config: %T", flags.Backend)
 	}
+	if err == nil {
+		cloud = withMiddlewares(cloud)
+	}
 
 	return
 }
@@ -187,7 +238,9 @@ func ParseBucketSpec(bucket string) (spec BucketSpec, err error) {
 		spec.Scheme = "s3"
 
 		colon := strings.Index(bucket, ":")
-		if colon != -1 {
+		if strings.HasPrefix(bucket, "arn:") {
+			spec.Bucket, spec.Prefix = splitAccessPointARN(bucket)
+		} else if colon != -1 {
 			spec.Prefix = bucket[colon+1:]
 			spec.Bucket = bucket[0:colon]
 		} else {
@@ -278,6 +331,44 @@ func NewGoofys(ctx context.Context, bucketName string, flags *cfg.FlagStorage) (
 				if spec.Prefix != "" {
 					bucketName += ":" + spec.Prefix
 				}
+			case "file":
+				// file:///path, the whole path is the root directory
+				if spec.Bucket != "" && spec.Bucket != "localhost" {
+					return nil, fmt.Errorf("file:// URLs of other hosts aren't supported: %v", bucketName)
+				}
+				root := strings.TrimSuffix(spec.Prefix, "/")
+				if len(root) < 2 || root[1] != ':' {
+					// Not a Windows drive
+					root = "/" + root
+				}
+				flags.Backend = &cfg.LocalConfig{
+					Root: filepath.FromSlash(root),
+				}
+				bucketName = ""
+			case "dav", "davs":
+				// dav://[user@]host/path, the whole path is the root collection
+				u, err := url.Parse(bucketName)
+				if err != nil {
+					return nil, err
+				}
+				config := &cfg.WebDAVConfig{
+					Username: os.Getenv("WEBDAV_USER"),
+					Password: os.Getenv("WEBDAV_PASSWORD"),
+				}
+				if u.User != nil {
+					config.Username = u.User.Username()
+					if password, ok := u.User.Password(); ok {
+						config.Password = password
+					}
+					u.User = nil
+				}
+				u.Scheme = "http"
+				if spec.Scheme == "davs" {
+					u.Scheme = "https"
+				}
+				config.Endpoint = u.String()
+				flags.Backend = config
+				bucketName = ""
 			}
 		}
 	}
@@ -292,11 +383,12 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 		flags:            flags,
 		umask:            0122,
 		shutdownCh:       make(chan struct{}),
+		atimePending:     make(map[*Inode]bool),
 		zeroBuf:          make([]byte, 1048576),
 		inflightChanges:  make(map[string]int),
 		inflightListings: make(map[int]map[string]bool),
 		stats: OpStats{
-			ts: time.Now(),
+			ts: clock.Now(),
 		},
 		flushPriorities: make([]int64, MAX_FLUSH_PRIORITY+1),
 	}
@@ -329,8 +421,72 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 		return nil, fmt.Errorf("Unable to access '%v': %v", bucket, err)
 	}
 	cloud.MultipartExpire(&MultipartExpireInput{})
+	caps := cloud.Capabilities()
+	if flags.TimeTravel && !caps.Versioning {
+		return nil, fmt.Errorf("--time-travel is only supported with S3")
+	}
+	if flags.StaleHandle == "version" && !caps.Versioning {
+		return nil, fmt.Errorf("--stale-handle version is only supported with S3")
+	}
+	if flags.S3Select && !caps.Select {
+		return nil, fmt.Errorf("--s3-select is only supported with S3")
+	}
+	if flags.DirMetaFile && !caps.ConditionalPut {
+		log.Infof("%v doesn't support conditional writes, locking %v objects instead", caps.Name, dirMetaName)
+	}
+	if flags.UsePatch && !caps.Patch {
+		log.Warnf("%v doesn't support PATCH, ignoring --enable-patch", caps.Name)
+	}
+	if caps.LowLatencyPut && !flags.MaxFlushersSet {
+		// Small files are flushed faster, keep more of them in flight
+		flags.MaxFlushers *= 4
+		log.Infof("%v has low latency writes, using %v flushers", caps.Name, flags.MaxFlushers)
+	}
+	if _, ok := cloud.Delegate().(*S3Backend); flags.SQSQueue != "" && !ok {
+		return nil, fmt.Errorf("--sqs-queue is only supported with S3")
+	}
+	if s3, ok := cloud.Delegate().(*S3Backend); ok && s3.ReadOnly() != "" {
+		// Writes would only fail when flushed
+		log.Infof("Mounting %v read-only, %v", bucket, s3.ReadOnly())
+		flags.MountOptions = append(flags.MountOptions, "ro")
+	}
+	if flags.ProbeTuning {
+		tuneByProbe(cloud, prefix+flags.TempPrefix+"probe."+RandStringBytesMaskImprSrc(16), flags)
+	}
+	if len(flags.ReadReplicas) > 0 {
+		cloud, err = newMirrorBackend(cloud, prefix, flags, newBackend)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to set up --read-replica: %v", err)
+		}
+	}
+	if len(flags.Routes) > 0 {
+		cloud, err = newRouteBackend(cloud, bucket, prefix, flags, newBackend)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to set up --route: %v", err)
+		}
+	}
 
-	now := time.Now()
+	if flags.ChangeJournal != "" {
+		fs.changes, err = OpenChangeJournal(flags.ChangeJournal, int64(flags.ChangeJournalMB)*1024*1024)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open change journal: %v", err)
+		}
+	}
+	if flags.SymlinkRootAttr != "" && flags.MountPoint != "" {
+		mnt, err := filepath.Abs(flags.MountPoint)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve mountpoint: %v", err)
+		}
+		fs.mountPoint = filepath.ToSlash(mnt)
+	}
+	if len(flags.ChecksumManifests) > 0 {
+		fs.checksums, err = loadChecksumManifests(flags.ChecksumManifests)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load checksum manifest: %v", err)
+		}
+	}
+
+	now := clock.Now()
 	fs.rootAttrs = InodeAttributes{
 		Size:  4096,
 		Ctime: now,
@@ -348,6 +504,15 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 	}
 
 	fs.nextInodeID = fuseops.RootInodeID + 1
+	if flags.InodeMap != "" {
+		fs.inodeMap, err = OpenInodeMap(flags.InodeMap)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open inode map: %v", err)
+		}
+		if fs.inodeMap.MaxId() >= fs.nextInodeID {
+			fs.nextInodeID = fs.inodeMap.MaxId() + 1
+		}
+	}
 	fs.inodes = make(map[fuseops.InodeID]*Inode)
 	fs.inodesByTime = make(map[int64]map[fuseops.InodeID]bool)
 	root := NewInode(fs, nil, "")
@@ -362,6 +527,52 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 
 	fs.inodes[fuseops.RootInodeID] = root
 
+	if flags.KeyManifest != "" {
+		err = fs.loadKeyManifest(flags.KeyManifest)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load key manifest: %v", err)
+		}
+	}
+	if flags.SingleObject {
+		if prefix == "" {
+			return nil, fmt.Errorf("--single-object requires bucket:key")
+		}
+		err = fs.mountSingleObject(cloud, strings.TrimSuffix(prefix, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to access '%v': %v", strings.TrimSuffix(prefix, "/"), err)
+		}
+	}
+
+	for _, pc := range flags.PrefixCredentials {
+		err = fs.mountPrefixCredentials(root, bucket, prefix, pc, newBackend)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to set up credentials for '%v': %v", pc.Path, err)
+		}
+	}
+	for _, bm := range flags.BucketMounts {
+		err = fs.mountBucket(root, bm, newBackend)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to mount '%v' at '%v': %v", bm.Bucket, bm.Path, err)
+		}
+	}
+
+	if flags.TraceOps {
+		fs.tracer = NewOpTracer()
+		if s3, ok := cloud.Delegate().(*S3Backend); ok {
+			s3.SetOpTracer(fs.tracer)
+		}
+	}
+
+	if flags.ErrorLog != "" {
+		fs.errorLog, err = NewErrorLog(flags.ErrorLog, flags.ErrorLogSize)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open error log: %v", err)
+		}
+		if s3, ok := cloud.Delegate().(*S3Backend); ok {
+			s3.S3.Handlers.Complete.PushBack(fs.errorLog.LogRequest)
+		}
+	}
+
 	fs.nextHandleID = 1
 	fs.dirHandles = make(map[fuseops.HandleID]*DirHandle)
 
@@ -372,6 +583,9 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 	if fs.flags.StatsInterval > 0 {
 		go fs.StatPrinter()
 	}
+	if len(fs.flags.SLOs) > 0 && fs.flags.SLOWindow > 0 {
+		go fs.SLOMonitor()
+	}
 
 	if fs.flags.CachePath != "" {
 		fs.diskFdQueue = NewFDQueue(int(fs.flags.MaxDiskCacheFD))
@@ -381,6 +595,65 @@ func newGoofys(ctx context.Context, bucket string, flags *cfg.FlagStorage,
 	}
 
 	go fs.MetaEvictor()
+	if len(fs.flags.CachePolicies) > 0 {
+		go fs.CacheEvictor()
+	}
+	if flags.WriterStamp {
+		fs.writer = mountIdentity(flags)
+		log.Infof("Stamping uploads with writer %v", fs.writer)
+	}
+	if flags.TempPrefix != "" {
+		fs.tempPrefix = prefix + flags.TempPrefix
+		// Abandoned temporary objects can only be found with a listing
+		if !flags.NoList {
+			go fs.checkTempKeys(cloud)
+		}
+		if flags.TempCleanupAge > 0 && !flags.NoList {
+			go fs.cleanupTempKeys(cloud)
+		}
+		if flags.RenameJournal {
+			go fs.checkRenameJournals(cloud)
+		}
+		if flags.InvalidationLog {
+			fs.invalLog = fs.newInvalidationLog(cloud)
+			go fs.InvalidationLogger()
+		}
+		if flags.WriteIntent != "" {
+			fs.writeIntents = fs.newWriteIntents(cloud)
+		}
+	}
+	if flags.DirtyAgeAlert > 0 || flags.MaxDirtyAge > 0 {
+		go fs.DirtyAgeMonitor()
+	}
+	if flags.Atime == "lazy" {
+		go fs.AtimeFlusher()
+	}
+	if flags.HandleLeakAge > 0 {
+		go fs.HandleLeakDetector()
+	}
+	if flags.UsageSnapshot != "" && flags.UsageInterval > 0 {
+		go fs.UsageSnapshotter(cloud, prefix)
+	}
+	if flags.SQSQueue != "" {
+		s3 := cloud.Delegate().(*S3Backend)
+		go fs.S3EventListener(s3.newSQSClient(flags.SQSQueue))
+	}
+	if flags.HostReadBandwidthMB > 0 {
+		fs.readLimiter = NewBandwidthLimiter(flags.BandwidthSocket, flags.HostReadBandwidthMB*1024*1024, flags.BandwidthWeight)
+	}
+	if flags.AdminGrpc != "" {
+		var auth *HTTPAuth
+		if flags.HTTPAuth != "" {
+			auth, err = LoadHTTPAuth(flags.HTTPAuth)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to load --http-auth: %v", err)
+			}
+		}
+		fs.adminServer, err = fs.StartAdminServer(flags.AdminGrpc, auth)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to start admin gRPC server: %v", err)
+		}
+	}
 
 	return fs, nil
 }
@@ -389,11 +662,39 @@ func (fs *Goofys) Shutdown() {
 	atomic.StoreInt32(&fs.shutdown, 1)
 	close(fs.shutdownCh)
 	fs.WakeupFlusher()
+	if fs.flags.Atime == "lazy" {
+		fs.flushAtimes()
+	}
+	if fs.changes != nil {
+		// Flushes in progress record changes when they complete
+		fs.waitFlushers()
+		fs.changes.Close()
+	}
+	if fs.inodeMap != nil {
+		fs.inodeMap.Close()
+	}
+	if fs.errorLog != nil {
+		fs.errorLog.Close()
+	}
+	if fs.adminServer != nil {
+		fs.adminServer.Stop()
+	}
+	if fs.readLimiter != nil {
+		fs.readLimiter.Close()
+	}
 	if fs.diskFdQueue != nil {
 		fs.diskFdQueue.cond.Broadcast()
 	}
 }
 
+// waitFlushers waits until flushes in progress complete. New ones aren't
+// started after shutdown.
+func (fs *Goofys) waitFlushers() {
+	for atomic.LoadInt64(&fs.activeFlushers) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // from https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-golang
 func RandStringBytesMaskImprSrc(n int) string {
 	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -457,6 +758,7 @@ func (fs *Goofys) AddFileHandle(fh *FileHandle) fuseops.HandleID {
 	handleID := fs.nextHandleID
 	fs.nextHandleID++
 	fs.fileHandles[handleID] = fh
+	fs.countHandle(fh, 1)
 	fs.mu.Unlock()
 	return handleID
 }
@@ -464,11 +766,11 @@ func (fs *Goofys) AddFileHandle(fh *FileHandle) fuseops.HandleID {
 func (fs *Goofys) StatPrinter() {
 	for atomic.LoadInt32(&fs.shutdown) == 0 {
 		select {
-		case <-time.After(fs.flags.StatsInterval):
+		case <-clock.After(fs.flags.StatsInterval):
 		case <-fs.shutdownCh:
 			return
 		}
-		now := time.Now()
+		now := clock.Now()
 		d := now.Sub(fs.stats.ts).Seconds()
 		reads := atomic.SwapInt64(&fs.stats.reads, 0)
 		readHits := atomic.SwapInt64(&fs.stats.readHits, 0)
@@ -486,8 +788,10 @@ func (fs *Goofys) StatPrinter() {
 		if reads == 0 {
 			readsOr1 = 1
 		}
+		pool := cfg.GetHTTPPool().TakeStats()
 		log.Infof(
-			"I/O: %.2f read/s, %.2f %% hits, %.2f write/s; metadata: %.2f read/s, %.2f write/s, %.2f noop/s, %v alive, %.2f evict/s; %.2f flush/s",
+			"I/O: %.2f read/s, %.2f %% hits, %.2f write/s; metadata: %.2f read/s, %.2f write/s, %.2f noop/s, %v alive, %.2f evict/s; %.2f flush/s;"+
+				" HTTP: %v in flight, %v peak, %.2f new conn/s, %.2f reused conn/s, %v saturated",
 			float64(reads)/d,
 			float64(readHits)/readsOr1*100,
 			float64(writes)/d,
@@ -497,6 +801,11 @@ func (fs *Goofys) StatPrinter() {
 			inodeCount,
 			float64(evicts)/d,
 			float64(flushes)/d,
+			pool.InFlight,
+			pool.Peak,
+			float64(pool.NewConns)/d,
+			float64(pool.ReusedConns)/d,
+			pool.Saturated,
 		)
 	}
 }
@@ -518,12 +827,23 @@ func (fs *Goofys) FreeSomeCleanBuffers(origSize int64) (int64, bool) {
 	}
 	var inode *Inode
 	var cleanEnd, cleanQueueID uint64
+	// Data protected by cache policies or pinned is only evicted when nothing else is left
+	protect, skipped := len(fs.flags.CachePolicies) > 0 || atomic.LoadInt32(&fs.pinnedCount) > 0, false
 	for freed < size {
 		inode, cleanEnd, cleanQueueID = fs.cleanQueue.NextClean(cleanQueueID)
 		if cleanQueueID == 0 {
+			if protect && skipped {
+				protect = false
+				continue
+			}
 			break
 		}
 		inode.mu.Lock()
+		if protect && fs.cacheProtected(inode) {
+			inode.mu.Unlock()
+			skipped = true
+			continue
+		}
 		toFs := -1
 		buf := inode.buffers.Get(cleanEnd)
 		// Never evict buffers flushed in an incomplete (last) part
@@ -541,6 +861,9 @@ func (fs *Goofys) FreeSomeCleanBuffers(origSize int64) (int64, bool) {
 			break
 		}
 	}
+	if freed < size && fs.flags.SpillDirty {
+		freed += fs.spillDirtyBuffers(size - freed)
+	}
 	haveDirty := fs.inodeQueue.Size() > 0
 	if freed < origSize && haveDirty {
 		fs.bufferPool.mu.Unlock()
@@ -580,10 +903,10 @@ func (fs *Goofys) tryEvictToDisk(inode *Inode, buf *FileBuffer, toFs *int) {
 
 func (fs *Goofys) WakeupFlusherAndWait(wait bool) {
 	fs.flusherMu.Lock()
-	if fs.flushPending == 0 {
-		fs.flushPending = 1
-		fs.flusherCond.Broadcast()
-	}
+	fs.flushWakeups++
+	// Waiters in SyncFile are woken up even if the flusher is already pending
+	fs.flushPending = 1
+	fs.flusherCond.Broadcast()
 	if wait {
 		// Wait for any result
 		fs.flusherCond.Wait()
@@ -597,7 +920,7 @@ func (fs *Goofys) WakeupFlusher() {
 
 func (fs *Goofys) ScheduleRetryFlush() {
 	if atomic.CompareAndSwapInt32(&fs.flushRetrySet, 0, 1) {
-		time.AfterFunc(fs.flags.RetryInterval, func() {
+		clock.AfterFunc(fs.flags.RetryInterval, func() {
 			atomic.StoreInt32(&fs.flushRetrySet, 0)
 			// Wakeup flusher after retry interval
 			fs.WakeupFlusher()
@@ -618,7 +941,7 @@ func (fs *Goofys) ScheduleRetryFlush() {
 //     Also we can't update less than 5 MB because it's the minimal part size
 //  3. Fsync triggered => intermediate full flush (same algorithm)
 //  4. Dirty memory limit reached => without on-disk cache we have to flush the whole object.
-//     With on-disk cache we can unload some dirty buffers to disk.
+//     With on-disk cache and --spill-dirty we unload some dirty buffers to disk.
 func (fs *Goofys) Flusher() {
 	var inodeID, nextQueueID uint64
 	priority := 1
@@ -637,7 +960,8 @@ func (fs *Goofys) Flusher() {
 		for i := 1; i <= priority; i++ {
 			curPriorityOk = curPriorityOk || atomic.LoadInt64(&fs.flushPriorities[priority]) > 0
 		}
-		for attempts > 0 && atomic.LoadInt64(&fs.activeFlushers) < fs.flags.MaxFlushers {
+		for attempts > 0 && atomic.LoadInt64(&fs.activeFlushers) < fs.flags.MaxFlushers &&
+			atomic.LoadInt32(&fs.shutdown) == 0 {
 			inodeID, nextQueueID = fs.inodeQueue.Next(nextQueueID)
 			if inodeID == 0 {
 				if curPriorityOk {
@@ -689,7 +1013,7 @@ func (fs *Goofys) EvictEntry(id fuseops.InodeID) bool {
 	}
 	// We CAN evict inodes which are still referenced by the kernel,
 	// but only if they're expired!
-	if childTmp.ExpireTime.After(time.Now()) {
+	if childTmp.ExpireTime.After(clock.Now()) {
 		childTmp.mu.Unlock()
 		return false
 	}
@@ -735,7 +1059,7 @@ func (fs *Goofys) MetaEvictor() {
 	for atomic.LoadInt32(&fs.shutdown) == 0 {
 		if !retry {
 			select {
-			case <-time.After(1 * time.Second):
+			case <-clock.After(1 * time.Second):
 			case <-fs.shutdownCh:
 				return
 			}
@@ -756,7 +1080,7 @@ func (fs *Goofys) MetaEvictor() {
 		if toEvict < 10 {
 			toEvict = 10
 		}
-		expireUnix := time.Now().Add(-fs.flags.StatCacheTTL).Unix()
+		expireUnix := clock.Now().Add(-fs.flags.StatCacheTTL).Unix()
 		var scan []fuseops.InodeID
 		for tm, inodes := range fs.inodesByTime {
 			if tm < expireUnix {
@@ -790,6 +1114,65 @@ func (fs *Goofys) MetaEvictor() {
 	}
 }
 
+// mountPrefixCredentials mounts a directory with a separate backend using
+// another profile or role (--prefix-credentials)
+func (fs *Goofys) mountPrefixCredentials(root *Inode, bucket, prefix string, pc cfg.PrefixCredentials,
+	newBackend func(string, *cfg.FlagStorage) (StorageBackend, error)) error {
+	s3, ok := fs.flags.Backend.(*cfg.S3Config)
+	if !ok {
+		return fmt.Errorf("--prefix-credentials is only supported with S3")
+	}
+	config, err := s3.ForPrefix(pc)
+	if err != nil {
+		return err
+	}
+	flags := *fs.flags
+	flags.Backend = config
+	cloud, err := newBackend(bucket, &flags)
+	if err != nil {
+		return err
+	}
+	mountPrefix := prefix + pc.Path + "/"
+	err = cloud.Init(mountPrefix + RandStringBytesMaskImprSrc(32))
+	if err != nil {
+		return err
+	}
+	fs.mount(root, &Mount{name: pc.Path, cloud: cloud, prefix: mountPrefix})
+	return nil
+}
+
+// mountBucket mounts another bucket or prefix with its own backend at a
+// directory (--mount-bucket)
+func (fs *Goofys) mountBucket(root *Inode, bm cfg.BucketMount,
+	newBackend func(string, *cfg.FlagStorage) (StorageBackend, error)) error {
+	flags := *fs.flags
+	if s3, ok := fs.flags.Backend.(*cfg.S3Config); ok {
+		// Backends change their config, for example after region detection
+		config := *s3
+		if bm.Profile != "" || bm.RoleArn != "" {
+			pc, err := s3.ForPrefix(cfg.PrefixCredentials{Path: bm.Path, Profile: bm.Profile, RoleArn: bm.RoleArn})
+			if err != nil {
+				return err
+			}
+			config = *pc
+		}
+		flags.Backend = &config
+	} else if bm.Profile != "" || bm.RoleArn != "" {
+		return fmt.Errorf("credentials in --mount-bucket are only supported with S3")
+	}
+	cloud, err := newBackend(bm.Bucket, &flags)
+	if err != nil {
+		return err
+	}
+	err = cloud.Init(bm.Prefix + RandStringBytesMaskImprSrc(32))
+	if err != nil {
+		return err
+	}
+	cloud.MultipartExpire(&MultipartExpireInput{})
+	fs.mount(root, &Mount{name: bm.Path, cloud: cloud, prefix: bm.Prefix})
+	return nil
+}
+
 type Mount struct {
 	// Mount Point relative to goofys's root mount.
 	name    string
@@ -981,7 +1364,7 @@ func ReadBackoff(flags *cfg.FlagStorage, try func(attempt int) error) (err error
 		if err != nil {
 			if shouldRetry(err) && (flags.ReadRetryAttempts < 1 || attempt < flags.ReadRetryAttempts) {
 				attempt++
-				time.Sleep(interval)
+				clock.Sleep(interval)
 				interval = time.Duration(flags.ReadRetryMultiplier * float64(interval))
 				if interval > flags.ReadRetryMax {
 					interval = flags.ReadRetryMax
@@ -1010,6 +1393,9 @@ func mapHttpError(status int) error {
 		return syscall.ENOTSUP
 	case http.StatusConflict:
 		return syscall.EINTR
+	case http.StatusPreconditionFailed:
+		// If-Match or If-None-Match failed: changed by someone else
+		return syscall.EBUSY
 	case http.StatusRequestedRangeNotSatisfiable:
 		return syscall.ERANGE
 	case 429:
@@ -1039,6 +1425,8 @@ func mapAwsError(err error) error {
 			return syscall.EEXIST
 		case "ConcurrentUpdatesPatchConflict", "ObjectVersionPatchConflict":
 			return syscall.EBUSY
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return syscall.EBUSY
 		}
 
 		if reqErr, ok := err.(awserr.RequestFailure); ok {
@@ -1077,7 +1465,7 @@ func (fs *Goofys) allocateInodeId() (id fuseops.InodeID) {
 }
 
 func expired(cache time.Time, ttl time.Duration) bool {
-	now := time.Now()
+	now := clock.Now()
 	if cache.After(now) {
 		return false
 	}
@@ -1090,11 +1478,19 @@ func (fs *Goofys) insertInode(parent *Inode, inode *Inode) {
 	if inode.Id != 0 {
 		panic(fmt.Sprintf("inode id is set: %v %v", inode.Name, inode.Id))
 	}
+	if inode.dir != nil && parent.dir.childMeta != nil {
+		inode.applyDirMeta(parent.dir.childMeta)
+	}
 	fs.mu.Lock()
-	inode.Id = fs.allocateInodeId()
+	if fs.inodeMap != nil {
+		inode.Id = fs.mapInodeId(parent.getChildName(inode.Name))
+	} else {
+		inode.Id = fs.allocateInodeId()
+	}
 	parent.insertChildUnlocked(inode)
 	fs.inodes[inode.Id] = inode
 	fs.mu.Unlock()
+	parent.wakePollers()
 }
 
 // LOCKS_EXCLUDED(fs.mu)
@@ -137,7 +137,7 @@ func (t *GoofysTest) deleteBlobsParallelly(cloud StorageBackend, blobs []string)
 		sem.V(1)
 		go func(blob string) {
 			defer sem.P(1)
-			_, localerr := cloud.DeleteBlob(&DeleteBlobInput{blob})
+			_, localerr := cloud.DeleteBlob(&DeleteBlobInput{Key: blob})
 			if localerr != nil && localerr != syscall.ENOENT {
 				err = localerr
 			}
@@ -646,6 +646,19 @@ func (s *GoofysTest) SetUpTest(t *C) {
 		s.cloud, err = NewADLv2(bucket, flags, &config)
 		t.Assert(err, IsNil)
 		t.Assert(s.cloud, NotNil)
+	} else if cloud == "file" {
+		config := cfg.LocalConfig{
+			Root: os.Getenv("ROOT"),
+		}
+		if config.Root == "" {
+			config.Root = os.TempDir()
+		}
+		flags.Backend = &config
+
+		var err error
+		s.cloud, err = NewLocal(bucket, flags, &config)
+		t.Assert(err, IsNil)
+		t.Assert(s.cloud, NotNil)
 	} else {
 		t.Fatal("Unsupported backend")
 	}
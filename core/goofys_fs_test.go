@@ -94,7 +94,7 @@ func (s *GoofysTest) TestWriteAnonymousFuse(t *C) {
 	t.Assert(err, NotNil)
 	pathErr, ok := err.(*os.PathError)
 	t.Assert(ok, Equals, true)
-	t.Assert(IsAccessDenied(pathErr.Err), Equals, true)
+	t.Assert(IsReadOnly(pathErr.Err), Equals, true)
 
 	err = file.Close()
 	t.Assert(err, IsNil)
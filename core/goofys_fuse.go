@@ -51,6 +51,9 @@ type GoofysFuse struct {
 	fuseutil.NotImplementedFileSystem
 	*Goofys
 	connection *fuse.Connection
+	// UIDs allowed to access the mount when it's checked by us, nil if
+	// it's left to the kernel
+	allowUids map[uint32]bool
 }
 
 func NewGoofysFuse(fs *Goofys) *GoofysFuse {
@@ -93,6 +96,10 @@ func (fs *GoofysFuse) StatFS(
 func (fs *GoofysFuse) GetInodeAttributes(
 	ctx context.Context,
 	op *fuseops.GetInodeAttributesOp) (err error) {
+	defer fs.beginOp("GetInodeAttributes", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataReads, 1)
 
@@ -105,7 +112,7 @@ func (fs *GoofysFuse) GetInodeAttributes(
 
 	attr := inode.GetAttributes()
 	op.Attributes = *attr
-	op.AttributesExpiration = time.Now().Add(fs.flags.StatCacheTTL)
+	op.AttributesExpiration = time.Now().Add(inode.statTTL())
 	inode.SetExpireLocked(op.AttributesExpiration)
 
 	return
@@ -113,6 +120,10 @@ func (fs *GoofysFuse) GetInodeAttributes(
 
 func (fs *GoofysFuse) GetXattr(ctx context.Context,
 	op *fuseops.GetXattrOp) (err error) {
+	defer fs.beginOp("GetXattr", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	if fs.flags.DisableXattr {
 		return syscall.ENOSYS
 	}
@@ -146,6 +157,10 @@ func (fs *GoofysFuse) GetXattr(ctx context.Context,
 
 func (fs *GoofysFuse) ListXattr(ctx context.Context,
 	op *fuseops.ListXattrOp) (err error) {
+	defer fs.beginOp("ListXattr", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	if fs.flags.DisableXattr {
 		return syscall.ENOSYS
 	}
@@ -186,6 +201,10 @@ func (fs *GoofysFuse) ListXattr(ctx context.Context,
 
 func (fs *GoofysFuse) RemoveXattr(ctx context.Context,
 	op *fuseops.RemoveXattrOp) (err error) {
+	defer fs.beginOp("RemoveXattr", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	if fs.flags.DisableXattr {
 		return syscall.ENOSYS
 	}
@@ -205,6 +224,10 @@ func (fs *GoofysFuse) RemoveXattr(ctx context.Context,
 
 func (fs *GoofysFuse) SetXattr(ctx context.Context,
 	op *fuseops.SetXattrOp) (err error) {
+	defer fs.beginOp("SetXattr", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	if fs.flags.DisableXattr {
 		return syscall.ENOSYS
 	}
@@ -229,6 +252,10 @@ func (fs *GoofysFuse) SetXattr(ctx context.Context,
 
 func (fs *GoofysFuse) CreateSymlink(ctx context.Context,
 	op *fuseops.CreateSymlinkOp) (err error) {
+	defer fs.beginOp("CreateSymlink", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	parent := fs.getInodeOrDie(op.Parent)
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
@@ -252,6 +279,10 @@ func (fs *GoofysFuse) CreateSymlink(ctx context.Context,
 
 func (fs *GoofysFuse) ReadSymlink(ctx context.Context,
 	op *fuseops.ReadSymlinkOp) (err error) {
+	defer fs.beginOp("ReadSymlink", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	inode := fs.getInodeOrDie(op.Inode)
 
 	atomic.AddInt64(&fs.stats.metadataReads, 1)
@@ -268,6 +299,10 @@ func (fs *GoofysFuse) ReadSymlink(ctx context.Context,
 
 func (fs *GoofysFuse) CreateLink(ctx context.Context,
 	op *fuseops.CreateLinkOp) (err error) {
+	defer fs.beginOp("CreateLink", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	if !fs.flags.EmulateHardlinks {
 		return syscall.ENOTSUP
@@ -318,6 +353,10 @@ func (fs *GoofysFuse) CreateLink(ctx context.Context,
 func (fs *GoofysFuse) LookUpInode(
 	ctx context.Context,
 	op *fuseops.LookUpInodeOp) (err error) {
+	defer fs.beginOp("LookUpInode", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataReads, 1)
 
@@ -333,7 +372,7 @@ func (fs *GoofysFuse) LookUpInode(
 	inode.Ref()
 	op.Entry.Child = inode.Id
 	op.Entry.Attributes = inode.InflateAttributes()
-	op.Entry.AttributesExpiration = time.Now().Add(fs.flags.StatCacheTTL)
+	op.Entry.AttributesExpiration = time.Now().Add(inode.statTTL())
 	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
 	inode.SetExpireLocked(op.Entry.AttributesExpiration)
 
@@ -363,6 +402,10 @@ func (fs *GoofysFuse) ForgetInode(
 func (fs *GoofysFuse) OpenDir(
 	ctx context.Context,
 	op *fuseops.OpenDirOp) (err error) {
+	defer fs.beginOp("OpenDir", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.noops, 1)
 
@@ -378,28 +421,87 @@ func (fs *GoofysFuse) OpenDir(
 	return
 }
 
-func makeDirEntry(inode *Inode, offset fuseops.DirOffset) fuseutil.Dirent {
+// Assigns an ID to the operation when --trace-ops is enabled. The returned
+// function must be called with the result of the operation when it completes
+// to track its latency and record the error with --error-log.
+func (fs *GoofysFuse) beginOp(op string, id fuseops.InodeID, name string) func(err *error) {
+	start := time.Now()
+	opID := ""
+	if fs.tracer != nil {
+		if path, ok := fs.opPath(id, name); ok {
+			opID = fs.tracer.Begin(op, path)
+			fs.traceInodes(opID, changeOps[op], id, name)
+		}
+	}
+	return func(err *error) {
+		if opID != "" && name != "" && *err == nil {
+			// A new child only exists now
+			fs.traceInodes(opID, changeOps[op], id, name)
+		}
+		fs.latency.Observe(op, time.Since(start))
+		if *err != nil && fs.errorLog != nil {
+			path, _ := fs.opPath(id, name)
+			fs.errorLog.RecordOp(op, path, *err)
+		}
+	}
+}
+
+// traceInodes sets the --trace-ops ID of an operation on its inode or, if
+// the operation is on a child name, on the parent and the child
+func (fs *GoofysFuse) traceInodes(opID string, change bool, id fuseops.InodeID, name string) {
+	fs.mu.RLock()
+	inode := fs.inodes[id]
+	fs.mu.RUnlock()
+	if inode == nil {
+		return
+	}
+	if name == "" {
+		inode.traceOp(opID, change)
+		return
+	}
+	inode.traceOp(opID, false)
+	if !inode.isDir() {
+		return
+	}
+	inode.mu.Lock()
+	child := inode.findChildUnlocked(name)
+	inode.mu.Unlock()
+	if child != nil {
+		child.traceOp(opID, change)
+	}
+}
+
+func (fs *GoofysFuse) opPath(id fuseops.InodeID, name string) (string, bool) {
+	fs.mu.RLock()
+	inode := fs.inodes[id]
+	fs.mu.RUnlock()
+	if inode == nil {
+		return "", false
+	}
+	path := inode.FullName()
+	if name != "" {
+		path = appendChildName(path, name)
+	}
+	return path, true
+}
+
+func makeDirEntry(inode *Inode, name string, cookie fuseops.DirOffset) fuseutil.Dirent {
 	dt := fuseutil.DT_File
 	if inode.isDir() {
 		dt = fuseutil.DT_Directory
 	}
-	name := inode.Name
-	if offset == 0 {
-		name = "."
-	} else if offset == 1 {
-		name = ".."
-	}
 	return fuseutil.Dirent{
 		Name:   name,
 		Type:   dt,
 		Inode:  inode.Id,
-		Offset: offset + 1,
+		Offset: cookie,
 	}
 }
 
 func (fs *GoofysFuse) ReadDir(
 	ctx context.Context,
 	op *fuseops.ReadDirOp) (err error) {
+	defer fs.beginOp("ReadDir", op.Inode, "")(&err)
 
 	atomic.AddInt64(&fs.stats.metadataReads, 1)
 
@@ -437,10 +539,10 @@ func (fs *GoofysFuse) ReadDir(
 			e.mu.Lock()
 			inodeEntry.Child = e.Id
 			inodeEntry.Attributes = e.InflateAttributes()
-			inodeEntry.AttributesExpiration = time.Now().Add(fs.flags.StatCacheTTL)
+			inodeEntry.AttributesExpiration = time.Now().Add(e.statTTL())
 			inodeEntry.EntryExpiration = inodeEntry.AttributesExpiration
 			e.SetExpireTime(inodeEntry.AttributesExpiration)
-			dirent = makeDirEntry(e, dh.lastExternalOffset)
+			dirent = makeDirEntry(e, dh.readName, dh.readCookie)
 			e.mu.Unlock()
 			n = fuseutil.WriteDirentPlus(op.Dst[op.BytesRead:], &inodeEntry, dirent)
 			if n == 0 {
@@ -452,7 +554,7 @@ func (fs *GoofysFuse) ReadDir(
 			}
 		} else {
 			e.mu.Lock()
-			dirent = makeDirEntry(e, dh.lastExternalOffset)
+			dirent = makeDirEntry(e, dh.readName, dh.readCookie)
 			e.mu.Unlock()
 			n = fuseutil.WriteDirent(op.Dst[op.BytesRead:], dirent)
 			if n == 0 {
@@ -495,6 +597,10 @@ func (fs *GoofysFuse) ReleaseDirHandle(
 func (fs *GoofysFuse) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) (err error) {
+	defer fs.beginOp("OpenFile", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 	in := fs.getInodeOrDie(op.Inode)
 
 	atomic.AddInt64(&fs.stats.noops, 1)
@@ -504,13 +610,36 @@ func (fs *GoofysFuse) OpenFile(
 		return syscall.ESTALE
 	}
 
+	if !op.OpenFlags.IsReadOnly() {
+		in.mu.Lock()
+		if fs.flags.CheckObjectLock {
+			err = in.fillLockState()
+		}
+		immutable := in.isImmutable()
+		in.mu.Unlock()
+		if err != nil {
+			return
+		}
+		if immutable {
+			return syscall.EPERM
+		}
+	}
+
+	err = fs.reserveHandle(op.OpContext.Pid)
+	if err != nil {
+		return
+	}
+
 	fh, err := in.OpenFile()
 	if err != nil {
+		fs.unreserveHandle(op.OpContext.Pid)
 		err = mapAwsError(err)
 		return
 	}
 
-	op.Handle = fs.AddFileHandle(fh)
+	fh.setOwner(op.OpContext.Pid)
+	fh.setOpenFlags(uint32(op.OpenFlags))
+	op.Handle = fs.addReservedHandle(fh)
 
 	// this flag appears to tell the kernel if this open should
 	// use the page cache or not. "use" here means:
@@ -527,6 +656,7 @@ func (fs *GoofysFuse) OpenFile(
 func (fs *GoofysFuse) ReadFile(
 	ctx context.Context,
 	op *fuseops.ReadFileOp) (err error) {
+	defer fs.beginOp("ReadFile", op.Inode, "")(&err)
 
 	atomic.AddInt64(&fs.stats.reads, 1)
 
@@ -543,6 +673,7 @@ func (fs *GoofysFuse) ReadFile(
 func (fs *GoofysFuse) SyncFile(
 	ctx context.Context,
 	op *fuseops.SyncFileOp) (err error) {
+	defer fs.beginOp("SyncFile", op.Inode, "")(&err)
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -579,6 +710,7 @@ func (fs *GoofysFuse) SyncFS(
 func (fs *GoofysFuse) FlushFile(
 	ctx context.Context,
 	op *fuseops.FlushFileOp) (err error) {
+	defer fs.beginOp("FlushFile", op.Inode, "")(&err)
 
 	// FlushFile is a no-op because we flush changes to the server asynchronously
 	// If the user really wants to persist a file to the server he should call fsync()
@@ -588,6 +720,26 @@ func (fs *GoofysFuse) FlushFile(
 	return
 }
 
+func (fs *GoofysFuse) Poll(
+	ctx context.Context,
+	op *fuseops.PollOp) (err error) {
+	defer fs.beginOp("Poll", op.Inode, "")(&err)
+
+	atomic.AddInt64(&fs.stats.noops, 1)
+
+	inode := fs.getInodeOrDie(op.Inode)
+	if op.Flags&pollScheduleNotify != 0 {
+		inode.mu.Lock()
+		inode.addPoller(op.Kh)
+		inode.mu.Unlock()
+	}
+
+	// Data is always available, poll is only used to wait for remote changes
+	op.Revents = op.Events & (pollIn | pollOut | pollRdNorm | pollWrNorm)
+
+	return
+}
+
 func (fs *GoofysFuse) ReleaseFileHandle(
 	ctx context.Context,
 	op *fuseops.ReleaseFileHandleOp) (err error) {
@@ -595,6 +747,7 @@ func (fs *GoofysFuse) ReleaseFileHandle(
 	fs.mu.Lock()
 	fh := fs.fileHandles[op.Handle]
 	fh.Release()
+	fs.countHandle(fh, -1)
 	atomic.AddInt64(&fs.stats.noops, 1)
 	fuseLog.Debugln("ReleaseFileHandle", fh.inode.FullName(), op.Handle, fh.inode.Id)
 	delete(fs.fileHandles, op.Handle)
@@ -610,6 +763,10 @@ func (fs *GoofysFuse) ReleaseFileHandle(
 func (fs *GoofysFuse) CreateFile(
 	ctx context.Context,
 	op *fuseops.CreateFileOp) (err error) {
+	defer fs.beginOp("CreateFile", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -620,8 +777,14 @@ func (fs *GoofysFuse) CreateFile(
 		return syscall.ESTALE
 	}
 
+	err = fs.reserveHandle(op.OpContext.Pid)
+	if err != nil {
+		return
+	}
+
 	inode, fh, err := parent.Create(op.Name)
 	if err != nil {
+		fs.unreserveHandle(op.OpContext.Pid)
 		return err
 	}
 
@@ -633,7 +796,8 @@ func (fs *GoofysFuse) CreateFile(
 	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
 	inode.SetExpireLocked(op.Entry.AttributesExpiration)
 
-	op.Handle = fs.AddFileHandle(fh)
+	fh.setOwner(op.OpContext.Pid)
+	op.Handle = fs.addReservedHandle(fh)
 
 	inode.logFuse("<-- CreateFile")
 
@@ -646,12 +810,15 @@ func (fs *GoofysFuse) CreateFile(
 func (fs *GoofysFuse) MkNode(
 	ctx context.Context,
 	op *fuseops.MkNodeOp) (err error) {
+	defer fs.beginOp("MkNode", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
-	if (op.Mode&os.ModeType) != os.ModeDir &&
-		(op.Mode&os.ModeType) != 0 &&
-		!fs.flags.EnableSpecials {
+	special := (op.Mode&os.ModeType) != os.ModeDir && (op.Mode&os.ModeType) != 0
+	if special && !fs.flags.EnableSpecials && !fs.flags.DirMetaSpecials {
 		return syscall.ENOTSUP
 	}
 
@@ -663,7 +830,12 @@ func (fs *GoofysFuse) MkNode(
 	}
 
 	var inode *Inode
-	if (op.Mode & os.ModeDir) != 0 {
+	if special && fs.flags.DirMetaSpecials {
+		inode, err = parent.MkNodeMeta(op.Name, op.Mode, op.Rdev, op.OpContext.Uid, op.OpContext.Gid)
+		if err != nil {
+			return mapAwsError(err)
+		}
+	} else if (op.Mode & os.ModeDir) != 0 {
 		inode, err = parent.MkDir(op.Name)
 		if err != nil {
 			return mapAwsError(err)
@@ -676,8 +848,10 @@ func (fs *GoofysFuse) MkNode(
 		}
 		fh.Release()
 	}
-	inode.Attributes.Rdev = op.Rdev
-	inode.SetAttributes(nil, &op.Mode, nil, &op.OpContext.Uid, &op.OpContext.Gid)
+	if !inode.metaNode {
+		inode.Attributes.Rdev = op.Rdev
+		inode.SetAttributes(nil, &op.Mode, nil, &op.OpContext.Uid, &op.OpContext.Gid)
+	}
 
 	op.Entry.Child = inode.Id
 	op.Entry.Attributes = inode.InflateAttributes()
@@ -698,6 +872,10 @@ func (fs *GoofysFuse) MkNode(
 func (fs *GoofysFuse) MkDir(
 	ctx context.Context,
 	op *fuseops.MkDirOp) (err error) {
+	defer fs.beginOp("MkDir", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -733,6 +911,10 @@ func (fs *GoofysFuse) MkDir(
 func (fs *GoofysFuse) RmDir(
 	ctx context.Context,
 	op *fuseops.RmDirOp) (err error) {
+	defer fs.beginOp("RmDir", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -752,6 +934,10 @@ func (fs *GoofysFuse) RmDir(
 func (fs *GoofysFuse) SetInodeAttributes(
 	ctx context.Context,
 	op *fuseops.SetInodeAttributesOp) (err error) {
+	defer fs.beginOp("SetInodeAttributes", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -769,7 +955,7 @@ func (fs *GoofysFuse) SetInodeAttributes(
 
 	attr := inode.GetAttributes()
 	op.Attributes = *attr
-	op.AttributesExpiration = time.Now().Add(fs.flags.StatCacheTTL)
+	op.AttributesExpiration = time.Now().Add(inode.statTTL())
 	inode.SetExpireLocked(op.AttributesExpiration)
 
 	return
@@ -778,6 +964,7 @@ func (fs *GoofysFuse) SetInodeAttributes(
 func (fs *GoofysFuse) WriteFile(
 	ctx context.Context,
 	op *fuseops.WriteFileOp) (err error) {
+	defer fs.beginOp("WriteFile", op.Inode, "")(&err)
 
 	atomic.AddInt64(&fs.stats.writes, 1)
 
@@ -802,6 +989,10 @@ func (fs *GoofysFuse) WriteFile(
 func (fs *GoofysFuse) Unlink(
 	ctx context.Context,
 	op *fuseops.UnlinkOp) (err error) {
+	defer fs.beginOp("Unlink", op.Parent, op.Name)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -822,6 +1013,10 @@ func (fs *GoofysFuse) Unlink(
 func (fs *GoofysFuse) Rename(
 	ctx context.Context,
 	op *fuseops.RenameOp) (err error) {
+	defer fs.beginOp("Rename", op.OldParent, op.OldName)(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -851,6 +1046,10 @@ const (
 func (fs *GoofysFuse) Fallocate(
 	ctx context.Context,
 	op *fuseops.FallocateOp) (err error) {
+	defer fs.beginOp("Fallocate", op.Inode, "")(&err)
+	if err = fs.checkAccess(&op.OpContext); err != nil {
+		return
+	}
 
 	atomic.AddInt64(&fs.stats.metadataWrites, 1)
 
@@ -908,6 +1107,9 @@ func (fs *GoofysFuse) Fallocate(
 		// Zero fill
 		mod, _ := inode.buffers.ZeroRange(op.Offset, op.Length)
 		modified = modified || mod
+		if mod {
+			inode.hashInvalidate()
+		}
 	}
 
 	if modified && inode.CacheState == ST_CACHED {
@@ -983,11 +1185,19 @@ func MountFuse(
 }
 
 func mountFuseFS(fs *Goofys) (mfs MountedFS, err error) {
+	options := convertFuseOptions(fs.flags)
+	allowUids, mode, err := fuseAccess(fs.flags, options, fuseConfPath, os.Geteuid())
+	if err != nil {
+		err = fmt.Errorf("Mount: %v", err)
+		return
+	}
+	log.Infof("Access to the mount: %v", mode)
+
 	// Mount the file system.
 	mountCfg := &fuse.MountConfig{
 		FSName:                  fs.bucket,
 		Subtype:                 "geesefs",
-		Options:                 convertFuseOptions(fs.flags),
+		Options:                 options,
 		ErrorLogger:             cfg.GetStdLogger(cfg.NewLogger("fuse"), logrus.ErrorLevel),
 		DisableWritebackCaching: true,
 		UseVectoredRead:         true,
@@ -1001,9 +1211,10 @@ func mountFuseFS(fs *Goofys) (mfs MountedFS, err error) {
 	}
 
 	fsint := NewGoofysFuse(fs)
+	fsint.allowUids = allowUids
 	server := fuseutil.NewFileSystemServer(fsint)
 
-	fuseMfs, err := fuse.Mount(fs.flags.MountPoint, server, mountCfg)
+	fuseMfs, err := fuseMount(fs.flags.MountMethods, fs.flags.MountPoint, server, mountCfg)
 	if err != nil {
 		err = fmt.Errorf("Mount: %v", err)
 		return
@@ -1020,7 +1231,7 @@ func mountFuseFS(fs *Goofys) (mfs MountedFS, err error) {
 
 func TryUnmount(mountPoint string) (err error) {
 	for i := 0; i < 20; i++ {
-		err = fuse.Unmount(mountPoint)
+		err = fuseUnmount(mountPoint)
 		if err != nil {
 			time.Sleep(time.Second)
 		} else {
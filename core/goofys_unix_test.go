@@ -109,6 +109,10 @@ func IsAccessDenied(err error) bool {
 	return err == syscall.EACCES
 }
 
+func IsReadOnly(err error) bool {
+	return err == syscall.EROFS
+}
+
 func (s *GoofysTest) SetUpSuite(t *C) {
 	s.tmp = os.Getenv("TMPDIR")
 	if s.tmp == "" {
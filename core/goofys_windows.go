@@ -573,8 +573,8 @@ func makeFuseAttributes(attr *fuseops.InodeAttributes, stat *fuse.Stat_t) {
 	stat.Ctim.Nsec = int64(attr.Ctime.Nanosecond())
 	stat.Blksize = 4096
 	stat.Blocks = int64(attr.Size) / stat.Blksize
-	stat.Birthtim.Sec = attr.Mtime.Unix()
-	stat.Birthtim.Nsec = int64(attr.Mtime.Nanosecond())
+	stat.Birthtim.Sec = attr.Crtime.Unix()
+	stat.Birthtim.Nsec = int64(attr.Crtime.Nanosecond())
 }
 
 // Truncate changes the size of a file.
@@ -799,16 +799,11 @@ func (fs *GoofysWin) Readdir(path string,
 			break
 		}
 		st := &fuse.Stat_t{}
+		name := dh.readName
 		inode.mu.Lock()
-		name := inode.Name
 		attr := inode.InflateAttributes()
 		makeFuseAttributes(&attr, st)
 		inode.mu.Unlock()
-		if dh.lastExternalOffset == 0 {
-			name = "."
-		} else if dh.lastExternalOffset == 1 {
-			name = ".."
-		}
 		if !fill(name, st, int64(dh.lastExternalOffset)) {
 			break
 		}
@@ -1001,7 +996,7 @@ func (fs *GoofysWin) Notify(notifications []interface{}) {
 func (fs *GoofysWin) WinDirRefresher() {
 	for atomic.LoadInt32(&fs.shutdown) == 0 {
 		select {
-		case <-time.After(1 * time.Second):
+		case <-clock.After(1 * time.Second):
 		case <-fs.shutdownCh:
 			return
 		}
@@ -1011,7 +1006,7 @@ func (fs *GoofysWin) WinDirRefresher() {
 			dirs = append(dirs, dh.inode)
 		}
 		fs.mu.Unlock()
-		expireUnix := time.Now().Add(-fs.flags.StatCacheTTL)
+		expireUnix := clock.Now().Add(-fs.flags.StatCacheTTL)
 		notifications := make(map[string]struct{})
 		for _, dir := range dirs {
 			dir.mu.Lock()
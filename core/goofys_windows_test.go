@@ -71,3 +71,8 @@ func FsyncDir(dir string) error {
 func IsAccessDenied(err error) bool {
 	return err == syscall.EACCES || err == syscall.ERROR_ACCESS_DENIED
 }
+
+func IsReadOnly(err error) bool {
+	// ERROR_WRITE_PROTECT
+	return err == syscall.EROFS || err == syscall.Errno(19)
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Open file handles are attributed to the process that opened them, so that
+// a leaky application can't exhaust the daemon's memory: --max-open-files
+// and --max-open-files-per-process return EMFILE above the limit, and
+// handles without any IO for --handle-leak-age are reported as suspected
+// leaks in the log and over HTTP (/handles on the --pprof port).
+
+// reserveHandle returns EMFILE if pid may not open another file. Otherwise
+// it counts a handle for pid until addReservedHandle or unreserveHandle, so
+// that concurrent opens can't all pass the check and exceed the limits.
+func (fs *Goofys) reserveHandle(pid uint32) error {
+	maxTotal := fs.flags.MaxOpenFiles
+	maxPerPid := fs.flags.MaxOpenFilesPerPid
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	total := len(fs.fileHandles) + fs.reservedHandles
+	perPid := fs.handlesByPid[pid]
+	if maxTotal > 0 && total >= maxTotal {
+		log.Warnf("Refusing to open a file for pid %v: %v files are open (--max-open-files)", pid, total)
+		return syscall.EMFILE
+	}
+	if maxPerPid > 0 && pid != 0 && perPid >= maxPerPid {
+		log.Warnf("Refusing to open a file for pid %v: it has %v open files (--max-open-files-per-process)", pid, perPid)
+		return syscall.EMFILE
+	}
+	fs.reservedHandles++
+	fs.countPid(pid, 1)
+	return nil
+}
+
+// unreserveHandle drops a reservation of a handle that wasn't opened
+func (fs *Goofys) unreserveHandle(pid uint32) {
+	fs.mu.Lock()
+	fs.reservedHandles--
+	fs.countPid(pid, -1)
+	fs.mu.Unlock()
+}
+
+// addReservedHandle is AddFileHandle for a handle reserved by reserveHandle.
+// fh must already be attributed to the same pid.
+func (fs *Goofys) addReservedHandle(fh *FileHandle) fuseops.HandleID {
+	fs.mu.Lock()
+	handleID := fs.nextHandleID
+	fs.nextHandleID++
+	fs.fileHandles[handleID] = fh
+	fs.reservedHandles--
+	fs.mu.Unlock()
+	return handleID
+}
+
+// setOwner attributes fh to the process pid
+func (fh *FileHandle) setOwner(pid uint32) {
+	fh.pid = pid
+	fh.opened = clock.Now()
+	atomic.StoreInt64(&fh.lastIO, fh.opened.UnixNano())
+}
+
+func (fh *FileHandle) touchIO() {
+	atomic.StoreInt64(&fh.lastIO, clock.Now().UnixNano())
+}
+
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Goofys) countHandle(fh *FileHandle, delta int) {
+	fs.countPid(fh.pid, delta)
+}
+
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Goofys) countPid(pid uint32, delta int) {
+	if pid == 0 {
+		return
+	}
+	if fs.handlesByPid == nil {
+		fs.handlesByPid = make(map[uint32]int)
+	}
+	fs.handlesByPid[pid] += delta
+	if fs.handlesByPid[pid] <= 0 {
+		delete(fs.handlesByPid, pid)
+	}
+}
+
+type HandleInfo struct {
+	Handle uint64    `json:"handle"`
+	Pid    uint32    `json:"pid"`
+	Comm   string    `json:"comm,omitempty"`
+	Path   string    `json:"path"`
+	Opened time.Time `json:"opened"`
+	LastIO time.Time `json:"last_io"`
+	// seconds since the last read or write
+	Idle float64 `json:"idle"`
+}
+
+// processName returns the command name of a process if it's still running
+func processName(pid uint32) string {
+	comm, err := os.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// OpenHandles returns attributed open file handles, the longest idle first
+func (fs *Goofys) OpenHandles() []HandleInfo {
+	now := clock.Now()
+	var res []HandleInfo
+	fs.mu.RLock()
+	for id, fh := range fs.fileHandles {
+		if fh.pid == 0 {
+			continue
+		}
+		lastIO := time.Unix(0, atomic.LoadInt64(&fh.lastIO))
+		res = append(res, HandleInfo{
+			Handle: uint64(id),
+			Pid:    fh.pid,
+			Path:   fh.inode.FullName(),
+			Opened: fh.opened,
+			LastIO: lastIO,
+			Idle:   now.Sub(lastIO).Seconds(),
+		})
+	}
+	fs.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Idle > res[j].Idle
+	})
+	for i := range res {
+		res[i].Comm = processName(res[i].Pid)
+	}
+	return res
+}
+
+// HandleLeakDetector periodically logs handles idle for more than --handle-leak-age
+func (fs *Goofys) HandleLeakDetector() {
+	interval := fs.flags.HandleLeakAge / 4
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	reported := make(map[uint64]bool)
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(interval):
+		case <-fs.shutdownCh:
+			return
+		}
+		seen := make(map[uint64]bool)
+		for _, h := range fs.OpenHandles() {
+			if h.Idle < fs.flags.HandleLeakAge.Seconds() {
+				break
+			}
+			seen[h.Handle] = true
+			if !reported[h.Handle] {
+				log.Warnf("Suspected file handle leak: %v opened by pid %v (%v) at %v, no IO since %v",
+					h.Path, h.Pid, h.Comm, h.Opened.Format(time.RFC3339), h.LastIO.Format(time.RFC3339))
+			}
+		}
+		reported = seen
+	}
+}
+
+// HandlesHandler serves open file handles with their owners as JSON.
+// ?idle=<duration> only returns handles without IO for at least that long.
+func (fs *Goofys) HandlesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var minIdle time.Duration
+		if s := r.URL.Query().Get("idle"); s != "" {
+			var err error
+			minIdle, err = time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "bad idle", http.StatusBadRequest)
+				return
+			}
+		}
+		handles := []HandleInfo{}
+		for _, h := range fs.OpenHandles() {
+			if h.Idle < minIdle.Seconds() {
+				break
+			}
+			handles = append(handles, h)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handles)
+	})
+}
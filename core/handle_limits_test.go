@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type HandleLimitsTest struct{}
+
+var _ = Suite(&HandleLimitsTest{})
+
+func (s *HandleLimitsTest) TestHandleLimitsNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.MaxOpenFiles = 3
+		flags.MaxOpenFilesPerPid = 2
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	fsint := NewGoofysFuse(m.fs)
+
+	err = m.WriteAndSync("file", []byte("data"))
+	t.Assert(err, IsNil)
+	inode, err := m.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+
+	open := func(pid uint32) (fuseops.HandleID, error) {
+		op := &fuseops.OpenFileOp{Inode: inode.Id, OpContext: fuseops.OpContext{Pid: pid}}
+		err := fsint.OpenFile(context.Background(), op)
+		return op.Handle, err
+	}
+	h1, err := open(100)
+	t.Assert(err, IsNil)
+	_, err = open(100)
+	t.Assert(err, IsNil)
+	_, err = open(100)
+	t.Assert(err, Equals, syscall.EMFILE)
+	_, err = open(200)
+	t.Assert(err, IsNil)
+	_, err = open(300)
+	t.Assert(err, Equals, syscall.EMFILE)
+
+	handles := m.fs.OpenHandles()
+	t.Assert(len(handles), Equals, 3)
+	t.Assert(handles[0].Path, Equals, "file")
+
+	// Reads keep the handle from looking idle
+	time.Sleep(10 * time.Millisecond)
+	_, _, err = m.fs.fileHandles[h1].ReadFile(0, 4)
+	t.Assert(err, IsNil)
+	handles = m.fs.OpenHandles()
+	t.Assert(handles[2].Handle, Equals, uint64(h1))
+
+	err = fsint.ReleaseFileHandle(context.Background(), &fuseops.ReleaseFileHandleOp{Handle: h1})
+	t.Assert(err, IsNil)
+	t.Assert(m.fs.handlesByPid[100], Equals, 1)
+	_, err = open(100)
+	t.Assert(err, IsNil)
+}
+
+func (s *HandleLimitsTest) TestConcurrentHandleLimitsNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.MaxOpenFiles = 10
+		flags.MaxOpenFilesPerPid = 4
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	fsint := NewGoofysFuse(m.fs)
+
+	err = m.WriteAndSync("file", []byte("data"))
+	t.Assert(err, IsNil)
+	inode, err := m.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+
+	// Opens racing each other can't all pass the check before any of them
+	// is counted
+	var wg sync.WaitGroup
+	var opened int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(pid uint32) {
+			defer wg.Done()
+			op := &fuseops.OpenFileOp{Inode: inode.Id, OpContext: fuseops.OpContext{Pid: pid}}
+			if fsint.OpenFile(context.Background(), op) == nil {
+				atomic.AddInt32(&opened, 1)
+			}
+		}(uint32(100 + i%2))
+	}
+	wg.Wait()
+	t.Assert(opened, Equals, int32(8))
+	t.Assert(m.fs.handlesByPid[100], Equals, 4)
+	t.Assert(m.fs.handlesByPid[101], Equals, 4)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			op := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: fmt.Sprintf("new%v", i),
+				Mode: 0644, OpContext: fuseops.OpContext{Pid: uint32(200 + i)}}
+			if fsint.CreateFile(context.Background(), op) == nil {
+				atomic.AddInt32(&opened, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	t.Assert(opened, Equals, int32(10))
+	t.Assert(len(m.fs.fileHandles), Equals, 10)
+	t.Assert(m.fs.reservedHandles, Equals, 0)
+}
+
+func (s *HandleLimitsTest) TestOpenFlagsNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	fsint := NewGoofysFuse(m.fs)
+
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "file", Mode: 0644}
+	t.Assert(fsint.CreateFile(context.Background(), create), IsNil)
+	t.Assert(fsint.ReleaseFileHandle(context.Background(), &fuseops.ReleaseFileHandleOp{Handle: create.Handle}), IsNil)
+
+	// O_SYNC writes are in the bucket when they return
+	syncOpen := &fuseops.OpenFileOp{Inode: create.Entry.Child, OpenFlags: O_SYNC}
+	t.Assert(fsint.OpenFile(context.Background(), syncOpen), IsNil)
+	t.Assert(fsint.WriteFile(context.Background(), &fuseops.WriteFileOp{
+		Inode:  create.Entry.Child,
+		Handle: syncOpen.Handle,
+		Data:   []byte("hello"),
+	}), IsNil)
+	data, ok := c.Store.Get("file")
+	t.Assert(ok, Equals, true)
+	t.Assert(string(data), Equals, "hello")
+
+	if O_NOATIME == 0 {
+		return
+	}
+	open := &fuseops.OpenFileOp{Inode: create.Entry.Child, OpenFlags: O_NOATIME}
+	t.Assert(fsint.OpenFile(context.Background(), open), IsNil)
+	inode := m.fs.getInodeOrDie(create.Entry.Child)
+	inode.mu.Lock()
+	accessed := inode.accessTime
+	inode.mu.Unlock()
+	_, _, err = m.fs.fileHandles[open.Handle].ReadFile(0, 5)
+	t.Assert(err, IsNil)
+	inode.mu.Lock()
+	t.Assert(inode.accessTime.Equal(accessed), Equals, true)
+	inode.mu.Unlock()
+}
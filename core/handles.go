@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"net/url"
 	"os"
 	"sort"
@@ -60,6 +61,9 @@ type InodeAttributes struct {
 	Size  uint64
 	Mtime time.Time
 	Ctime time.Time
+	// Birth time: local creation time or the object creation date
+	// (LastModified when the object was first seen)
+	Btime time.Time
 	Uid   uint32
 	Gid   uint32
 	Rdev  uint32
@@ -122,6 +126,30 @@ type Inode struct {
 	flushError     error
 	flushErrorTime time.Time
 	readError      error
+	// when the inode became modified, and if it was reported by --dirty-age-alert
+	dirtySince   time.Time
+	dirtyAlerted bool
+	// wakeup for a flush postponed by --flush-policy is scheduled
+	flushTimer bool
+	// FUSE kernel handles waiting in poll() for a remote change
+	pollKh []uint64
+	// last read or write, used by cache eviction policies
+	accessTime time.Time
+	// data is pinned in the cache through the admin API, holds a reference
+	pinned bool
+	// --atime access time, zero if unknown
+	atime time.Time
+	// --atime lazy access time is stored in .geesefs_meta of the parent
+	atimeStored bool
+	// user xattrs of a file stored in .geesefs_meta of the parent because
+	// they don't fit into the object, if loaded, and when they were changed
+	metaXattrs       map[string][]byte
+	metaXattrsLoaded bool
+	metaXattrsTime   time.Time
+	// special file which only exists in .geesefs_meta (--dir-meta-specials)
+	metaNode bool
+	// --trace-ops IDs of the last operations
+	trace opTrace
 	// renamed from: parent, name
 	oldParent *Inode
 	oldName   string
@@ -134,10 +162,26 @@ type Inode struct {
 	userMetadataDirty int
 	userMetadata      map[string][]byte
 	s3Metadata        map[string][]byte
+	// object lock state in s3Metadata is loaded, only HEAD returns it
+	lockStateLoaded bool
+
+	// caching directives from the server (--honor-cache-control)
+	cacheControl cacheControl
+
+	// running hash of sequentially written content for --content-hash
+	contentHash   hash.Hash
+	contentHashed uint64
+
+	// result of --checksum-manifest validation and the etag it applies to
+	checksumResult string
+	checksumETag   string
 
 	// last known size and etag from the cloud
 	knownSize uint64
 	knownETag string
+	// incremented when the object is found to be replaced remotely, checked
+	// by handles opened with --pin-etag
+	remoteGen uint64
 
 	// the refcnt is an exception, it's protected with atomic access
 	// being part of parent.dir.Children increases refcnt by 1
@@ -164,7 +208,7 @@ func NewInode(fs *Goofys, parent *Inode, name string) (inode *Inode) {
 			Gid:  fs.flags.Gid,
 			Mode: fs.flags.FileMode,
 		},
-		AttrTime:   time.Now(),
+		AttrTime:   clock.Now(),
 		Parent:     parent,
 		s3Metadata: make(map[string][]byte),
 		refcnt:     0,
@@ -201,7 +245,7 @@ func (inode *Inode) SetFromBlobItem(item *BlobItemOutput) {
 
 	// If ongoing patch requests exist, then concurrent etag changes is normal. In current implementation
 	// it is hard to reliably distinguish actual data conflicts from concurrent patch updates.
-	patchInProgress := inode.fs.flags.UsePatch && inode.mpu == nil && inode.CacheState == ST_MODIFIED && inode.IsFlushing > 0
+	patchInProgress := inode.usePatch() && inode.mpu == nil && inode.CacheState == ST_MODIFIED && inode.IsFlushing > 0
 
 	// If a file is renamed from a different file then we also don't know its server-side
 	// ETag or Size for sure, so the simplest fix is to also ignore this check
@@ -214,12 +258,19 @@ func (inode *Inode) SetFromBlobItem(item *BlobItemOutput) {
 				" (%v, %v) differs from local (%v, %v). File is changed remotely, dropping cache",
 				inode.Id, inode.FullName(), NilStr(item.ETag), item.Size, inode.knownETag, inode.knownSize)
 		}
+		if inode.knownETag != "" || inode.knownSize > 0 {
+			inode.remoteGen++
+		}
 		inode.resetCache()
+		inode.lockStateLoaded = false
 		inode.Attributes.Size = item.Size
 		inode.knownSize = item.Size
 		if item.LastModified != nil {
 			inode.Attributes.Mtime = *item.LastModified
 			inode.Attributes.Ctime = *item.LastModified
+			if inode.Attributes.Btime.IsZero() {
+				inode.Attributes.Btime = *item.LastModified
+			}
 		} else {
 			inode.Attributes.Mtime = inode.fs.rootAttrs.Ctime
 			inode.Attributes.Ctime = inode.fs.rootAttrs.Ctime
@@ -228,6 +279,7 @@ func (inode *Inode) SetFromBlobItem(item *BlobItemOutput) {
 			inode.setMetadata(item.Metadata)
 			inode.userMetadataDirty = 0
 		}
+		inode.wakePollers()
 	}
 	if item.ETag != nil {
 		inode.s3Metadata["etag"] = []byte(*item.ETag)
@@ -240,7 +292,10 @@ func (inode *Inode) SetFromBlobItem(item *BlobItemOutput) {
 	} else {
 		delete(inode.s3Metadata, "storage-class")
 	}
-	now := time.Now()
+	if item.CacheControl != nil && inode.fs.flags.HonorCacheControl {
+		inode.cacheControl = parseCacheControl(*item.CacheControl)
+	}
+	now := clock.Now()
 	// don't want to update time if this inode is setup to never expire
 	if inode.AttrTime.Before(now) {
 		inode.SetAttrTime(now)
@@ -307,8 +362,23 @@ func (inode *Inode) FullName() string {
 }
 
 func (inode *Inode) touch() {
-	inode.Attributes.Mtime = time.Now()
-	inode.Attributes.Ctime = time.Now()
+	inode.Attributes.Mtime = clock.Now()
+	inode.Attributes.Ctime = clock.Now()
+}
+
+// Object-locked files (retention period or legal hold) can't be changed
+// or removed, so report them as immutable.
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) isImmutable() bool {
+	if string(inode.s3Metadata["object-lock-legal-hold"]) == "ON" {
+		return true
+	}
+	until := inode.s3Metadata["object-lock-retain-until-date"]
+	if until != nil {
+		t, err := time.Parse(time.RFC3339, string(until))
+		return err == nil && t.After(clock.Now())
+	}
+	return false
 }
 
 func (inode *Inode) InflateAttributes() (attr fuseops.InodeAttributes) {
@@ -316,13 +386,22 @@ func (inode *Inode) InflateAttributes() (attr fuseops.InodeAttributes) {
 	if mtime.IsZero() {
 		mtime = inode.fs.rootAttrs.Mtime
 	}
+	btime := inode.Attributes.Btime
+	if btime.IsZero() {
+		btime = mtime
+	}
+
+	atime := inode.atime
+	if atime.IsZero() {
+		atime = inode.Attributes.Ctime
+	}
 
 	attr = fuseops.InodeAttributes{
 		Size:   inode.Attributes.Size,
-		Atime:  inode.Attributes.Ctime,
+		Atime:  atime,
 		Mtime:  mtime,
 		Ctime:  inode.Attributes.Ctime,
-		Crtime: mtime,
+		Crtime: btime,
 		Uid:    inode.Attributes.Uid,
 		Gid:    inode.Attributes.Gid,
 		Mode:   inode.Attributes.Mode,
@@ -338,6 +417,9 @@ func (inode *Inode) InflateAttributes() (attr fuseops.InodeAttributes) {
 	} else {
 		attr.Nlink = 1
 	}
+	if inode.isImmutable() {
+		attr.Mode &^= 0222
+	}
 
 	return
 }
@@ -364,6 +446,9 @@ func (inode *Inode) ToDir() {
 		inode.dir = &DirInodeData{
 			lastOpenDirIdx: -1,
 		}
+		if inode.Parent != nil {
+			inode.dir.frozen = inode.Parent.dir.frozen
+		}
 	}
 }
 
@@ -401,7 +486,7 @@ func (inode *Inode) SetAttrTime(tm time.Time) {
 	inode.AttrTime = tm
 	// Expire when at least both AttrTime+TTL & ExpireTime pass
 	// AttrTime is required for Windows where we don't use SetExpireTime()
-	inode.SetExpireTime(tm.Add(inode.fs.flags.StatCacheTTL))
+	inode.SetExpireTime(tm.Add(inode.statTTL()))
 }
 
 // LOCKS_REQUIRED(inode.mu)
@@ -488,10 +573,38 @@ func (inode *Inode) fillXattrFromHead(resp *HeadBlobOutput) {
 	} else {
 		inode.s3Metadata["storage-class"] = []byte("STANDARD")
 	}
+	inode.setLockState(resp)
+	inode.setChecksumXattr(resp.Checksum)
 
 	inode.setMetadata(resp.Metadata)
 }
 
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) setLockState(resp *HeadBlobOutput) {
+	inode.lockStateLoaded = true
+	if resp.LockedUntil != nil {
+		inode.s3Metadata["object-lock-retain-until-date"] = []byte(resp.LockedUntil.UTC().Format(time.RFC3339))
+	} else {
+		delete(inode.s3Metadata, "object-lock-retain-until-date")
+	}
+	if resp.LegalHold {
+		inode.s3Metadata["object-lock-legal-hold"] = []byte("ON")
+	} else {
+		delete(inode.s3Metadata, "object-lock-legal-hold")
+	}
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) setChecksumXattr(checksum *ObjectChecksum) {
+	if checksum != nil {
+		inode.s3Metadata["checksum-algorithm"] = []byte(checksum.Algorithm)
+		inode.s3Metadata["checksum-type"] = []byte(checksum.Type)
+	} else {
+		delete(inode.s3Metadata, "checksum-algorithm")
+		delete(inode.s3Metadata, "checksum-type")
+	}
+}
+
 // LOCKS_REQUIRED(inode.mu)
 func (inode *Inode) setUserMeta(key string, value []byte) error {
 	if inode.userMetadata == nil {
@@ -519,6 +632,17 @@ func (inode *Inode) setUserMeta(key string, value []byte) error {
 	return nil
 }
 
+// setBtime sets the birth time of the inode and, with --enable-btime,
+// stores it in metadata
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) setBtime(btime time.Time) {
+	inode.Attributes.Btime = btime
+	if inode.fs.flags.EnableBtime && inode.userMetadata != nil {
+		inode.userMetadata[inode.fs.flags.BtimeAttr] = []byte(fmt.Sprintf("%d", btime.Unix()))
+		inode.userMetadataDirty = 2
+	}
+}
+
 // LOCKS_REQUIRED(inode.mu)
 func (inode *Inode) setMetadata(metadata map[string]*string) {
 	inode.userMetadata = unescapeMetadata(metadata)
@@ -532,6 +656,15 @@ func (inode *Inode) setMetadata(metadata map[string]*string) {
 				}
 			}
 		}
+		if inode.fs.flags.EnableBtime {
+			btimeStr := inode.userMetadata[inode.fs.flags.BtimeAttr]
+			if btimeStr != nil {
+				i, err := strconv.ParseUint(string(btimeStr), 0, 64)
+				if err == nil {
+					inode.Attributes.Btime = time.Unix(int64(i), 0)
+				}
+			}
+		}
 		if inode.fs.flags.EnablePerms {
 			uidStr := inode.userMetadata[inode.fs.flags.UidAttr]
 			if uidStr != nil {
@@ -611,15 +744,9 @@ func (inode *Inode) setFileMode(newMode os.FileMode) (changed bool, err error) {
 
 // FIXME: Move all these xattr-related functions to file.go
 
+// headObject requests metadata of the object, inode.mu is released meanwhile
 // LOCKS_REQUIRED(inode.mu)
-func (inode *Inode) fillXattr() (err error) {
-	if inode.userMetadata != nil {
-		return nil
-	}
-	if inode.dir != nil && inode.dir.ImplicitDir {
-		inode.userMetadata = make(map[string][]byte)
-		return nil
-	}
+func (inode *Inode) headObject() (*HeadBlobOutput, error) {
 	cloud, key := inode.cloud()
 	if inode.oldParent != nil {
 		_, key = inode.oldParent.cloud()
@@ -629,8 +756,42 @@ func (inode *Inode) fillXattr() (err error) {
 		key += "/"
 	}
 	inode.mu.Unlock()
-	resp, err := RetryHeadBlob(inode.fs.flags, cloud, &HeadBlobInput{Key: key})
+	resp, err := RetryHeadBlob(inode.fs.flags, cloud, &HeadBlobInput{Key: key, OpID: inode.lastOp()})
 	inode.mu.Lock()
+	return resp, err
+}
+
+// fillLockState loads the object lock state of a file if user metadata came
+// from a listing or a lookup
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) fillLockState() error {
+	if inode.lockStateLoaded || inode.isDir() || inode.CacheState == ST_CREATED {
+		return nil
+	}
+	resp, err := inode.headObject()
+	if err != nil {
+		err = mapAwsError(err)
+		if err == syscall.ENOENT {
+			// No object, no lock
+			inode.lockStateLoaded = true
+			err = nil
+		}
+		return err
+	}
+	inode.setLockState(resp)
+	return nil
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) fillXattr() (err error) {
+	if inode.userMetadata != nil {
+		return nil
+	}
+	if inode.dir != nil && inode.dir.ImplicitDir {
+		inode.userMetadata = make(map[string][]byte)
+		return nil
+	}
+	resp, err := inode.headObject()
 	if err != nil {
 		err = mapAwsError(err)
 		if err == syscall.ENOENT {
@@ -647,6 +808,12 @@ func (inode *Inode) fillXattr() (err error) {
 	return
 }
 
+// The --symlink-root-attr value is derived from the symlink target, so it's
+// not exposed as a user xattr
+func (inode *Inode) isSymlinkRootAttr(key string) bool {
+	return inode.fs.flags.SymlinkRootAttr != "" && key == inode.fs.flags.SymlinkRootAttr
+}
+
 // LOCKS_REQUIRED(inode.mu)
 func (inode *Inode) getXattrMap(name string, userOnly bool) (
 	meta map[string][]byte, newName string, err error) {
@@ -661,8 +828,12 @@ func (inode *Inode) getXattrMap(name string, userOnly bool) (
 
 		newName = name[len(xattrPrefix):]
 		meta = inode.s3Metadata
-	} else if strings.HasPrefix(name, "user.") && name != "user."+inode.fs.flags.SymlinkAttr {
+	} else if strings.HasPrefix(name, "user.") && name != "user."+inode.fs.flags.SymlinkAttr &&
+		!inode.isSymlinkRootAttr(name[5:]) {
 		err = inode.fillXattr()
+		if err == nil {
+			err = inode.fillMetaXattrs()
+		}
 		if err != nil {
 			return nil, "", err
 		}
@@ -710,6 +881,17 @@ func unescapeMetadata(meta map[string]*string) map[string][]byte {
 	return unescaped
 }
 
+// metadataSize returns the size of escaped user metadata after setting name to value
+func metadataSize(meta map[string][]byte, name string, value []byte) int {
+	size := len(xattrEscape(name)) + len(xattrEscape(string(value)))
+	for k, v := range meta {
+		if k != name {
+			size += len(xattrEscape(k)) + len(xattrEscape(string(v)))
+		}
+	}
+	return size
+}
+
 func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 	inode.logFuse("SetXattr", name)
 
@@ -717,6 +899,16 @@ func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 		inode.DumpTree(string(value) == "buffers")
 		return nil
 	}
+	if inode.readOnlyView() {
+		return syscall.EROFS
+	}
+	if inode.metaNode {
+		// Nodes only have the attributes of stat()
+		return syscall.ENOTSUP
+	}
+	if inode.fs.flags.NoDirObject && inode.dirMetaReadOnly() {
+		return syscall.EROFS
+	}
 
 	inode.mu.Lock()
 	defer inode.mu.Unlock()
@@ -726,6 +918,7 @@ func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 		return syscall.ENOENT
 	}
 
+	isUser := strings.HasPrefix(name, "user.")
 	meta, name, err := inode.getXattrMap(name, true)
 	if err == syscall.EPERM {
 		// Silently ignore forbidden xattr operations
@@ -737,6 +930,9 @@ func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 
 	if flags != 0x0 {
 		_, ok := meta[name]
+		if !ok && isUser {
+			_, ok = inode.metaXattrs[name]
+		}
 		if flags == XATTR_CREATE {
 			if ok {
 				return syscall.EEXIST
@@ -748,8 +944,22 @@ func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 		}
 	}
 
+	if isUser {
+		cloud, _ := inode.cloud()
+		limit := cloud.Capabilities().MaxMetadataSize
+		_, stored := inode.metaXattrs[name]
+		if stored || limit > 0 && metadataSize(meta, name, value) > limit {
+			if !inode.useMetaXattrs() {
+				// Fail here instead of failing to flush the object later
+				return syscall.E2BIG
+			}
+			return inode.setMetaXattr(name, Dup(value))
+		}
+	}
+
 	meta[name] = Dup(value)
 	inode.userMetadataDirty = 2
+	inode.checkDirMeta()
 	if inode.CacheState == ST_CACHED {
 		inode.SetCacheState(ST_MODIFIED)
 		inode.fs.WakeupFlusher()
@@ -759,6 +969,16 @@ func (inode *Inode) SetXattr(name string, value []byte, flags uint32) error {
 
 func (inode *Inode) RemoveXattr(name string) error {
 	inode.logFuse("RemoveXattr", name)
+	if inode.readOnlyView() {
+		return syscall.EROFS
+	}
+	if inode.metaNode {
+		// Nodes only have the attributes of stat()
+		return syscall.ENOTSUP
+	}
+	if inode.fs.flags.NoDirObject && inode.dirMetaReadOnly() {
+		return syscall.EROFS
+	}
 
 	inode.mu.Lock()
 	defer inode.mu.Unlock()
@@ -768,6 +988,7 @@ func (inode *Inode) RemoveXattr(name string) error {
 		return syscall.ENOENT
 	}
 
+	isUser := strings.HasPrefix(name, "user.")
 	meta, name, err := inode.getXattrMap(name, true)
 	if err == syscall.EPERM {
 		// Silently ignore forbidden xattr operations
@@ -777,9 +998,13 @@ func (inode *Inode) RemoveXattr(name string) error {
 		return err
 	}
 
+	if _, ok := inode.metaXattrs[name]; ok && isUser {
+		return inode.setMetaXattr(name, nil)
+	}
 	if _, ok := meta[name]; ok {
 		delete(meta, name)
 		inode.userMetadataDirty = 2
+		inode.checkDirMeta()
 		if inode.CacheState == ST_CACHED {
 			inode.SetCacheState(ST_MODIFIED)
 			inode.fs.WakeupFlusher()
@@ -795,15 +1020,36 @@ func (inode *Inode) GetXattr(name string) ([]byte, error) {
 	if name == "geesefs" {
 		return []byte(cfg.GEESEFS_VERSION), nil
 	}
+	if isPresignXattr(name) {
+		return inode.presign(name)
+	}
+	if name == checksumXattr && inode.fs.checksums != nil {
+		return inode.getChecksumResult()
+	}
+	if name == generationXattr && inode.isDir() {
+		gen, err := inode.DirGeneration()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatUint(gen, 10)), nil
+	}
 
 	inode.mu.Lock()
 	defer inode.mu.Unlock()
 
+	if name == writerXattr {
+		return inode.lastWriter()
+	}
+
+	isUser := strings.HasPrefix(name, "user.")
 	meta, name, err := inode.getXattrMap(name, false)
 	if err != nil {
 		return nil, err
 	}
 
+	if value, ok := inode.metaXattrs[name]; ok && isUser {
+		return value, nil
+	}
 	value, ok := meta[name]
 	if ok {
 		return value, nil
@@ -819,6 +1065,9 @@ func (inode *Inode) ListXattr() ([]string, error) {
 	var xattrs []string
 
 	err := inode.fillXattr()
+	if err == nil {
+		err = inode.fillMetaXattrs()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -831,6 +1080,12 @@ func (inode *Inode) ListXattr() ([]string, error) {
 	}
 
 	for k, _ := range inode.userMetadata {
+		if !inode.isSymlinkRootAttr(k) {
+			xattrs = append(xattrs, "user."+k)
+		}
+	}
+
+	for k := range inode.metaXattrs {
 		xattrs = append(xattrs, "user."+k)
 	}
 
@@ -844,6 +1099,11 @@ func (inode *Inode) OpenFile() (fh *FileHandle, err error) {
 	defer inode.mu.Unlock()
 
 	fh = NewFileHandle(inode)
+	if inode.fs.flags.PinETag {
+		fh.pinned = true
+		fh.remoteGen = inode.remoteGen
+		fh.etag = inode.knownETag
+	}
 
 	n := atomic.AddInt32(&inode.fileHandles, 1)
 	if n == 1 {
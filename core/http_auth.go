@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// HTTPAuth protects HTTP endpoints served on the --pprof listener so that
+// they can be enabled on shared hosts. The --http-auth file grants roles to
+// bearer tokens and, for unix socket listeners, to peer uids:
+//
+//	read token:<secret>
+//	admin uid:0
+//
+// The read role allows statistics (/metrics, pprof), the admin role also
+// allows HTTPAdminPaths, which load or expose file data or key names.
+type HTTPAuth struct {
+	tokens map[string]string
+	uids   map[uint32]string
+}
+
+const (
+	HTTPRoleRead  = "read"
+	HTTPRoleAdmin = "admin"
+)
+
+// HTTPAdminPaths require the admin role. /handles shows paths and processes
+// of open files
+var HTTPAdminPaths = []string{"/prefill", "/cache", "/changes", "/handles"}
+
+type peerUidKey struct{}
+
+func LoadHTTPAuth(path string) (*HTTPAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	auth := &HTTPAuth{
+		tokens: make(map[string]string),
+		uids:   make(map[uint32]string),
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0][0] == '#' {
+			continue
+		}
+		if len(fields) != 2 || fields[0] != HTTPRoleRead && fields[0] != HTTPRoleAdmin {
+			return nil, fmt.Errorf("%v:%v: expected \"read|admin token:<secret>|uid:<uid>\"", path, n)
+		}
+		role, cred := fields[0], fields[1]
+		switch {
+		case strings.HasPrefix(cred, "token:") && len(cred) > len("token:"):
+			auth.tokens[cred[len("token:"):]] = role
+		case strings.HasPrefix(cred, "uid:"):
+			uid, err := strconv.ParseUint(cred[len("uid:"):], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: invalid uid %v", path, n, cred)
+			}
+			auth.uids[uint32(uid)] = role
+		default:
+			return nil, fmt.Errorf("%v:%v: invalid credential %v", path, n, cred)
+		}
+	}
+	return auth, scanner.Err()
+}
+
+// ConnContext remembers the peer uid of unix socket connections, use it as http.Server.ConnContext
+func (auth *HTTPAuth) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if uid, ok := peerUid(c); ok {
+		ctx = context.WithValue(ctx, peerUidKey{}, uid)
+	}
+	return ctx
+}
+
+// role returns the best role granted to the request, or ""
+func (auth *HTTPAuth) role(r *http.Request) string {
+	uid, hasUid := r.Context().Value(peerUidKey{}).(uint32)
+	return auth.grantedRole(uid, hasUid, r.Header.Get("Authorization"))
+}
+
+// grantedRole returns the best role granted to the peer uid or to the
+// bearer token of the Authorization header, or ""
+func (auth *HTTPAuth) grantedRole(uid uint32, hasUid bool, authorization string) string {
+	role := ""
+	if hasUid {
+		role = auth.uids[uid]
+	}
+	if role != HTTPRoleAdmin {
+		if bearer := strings.TrimPrefix(authorization, "Bearer "); bearer != "" {
+			for token, tokenRole := range auth.tokens {
+				if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 &&
+					(role == "" || tokenRole == HTTPRoleAdmin) {
+					role = tokenRole
+				}
+			}
+		}
+	}
+	return role
+}
+
+// Handler requires the admin role for adminPaths and their subpaths and the
+// read role for everything else. A nil HTTPAuth allows everything.
+func (auth *HTTPAuth) Handler(next http.Handler, adminPaths ...string) http.Handler {
+	if auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := HTTPRoleRead
+		reqPath := path.Clean("/" + r.URL.Path)
+		for _, p := range adminPaths {
+			if reqPath == p || strings.HasPrefix(reqPath, p+"/") {
+				need = HTTPRoleAdmin
+			}
+		}
+		role := auth.role(r)
+		if role == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if need == HTTPRoleAdmin && role != HTTPRoleAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
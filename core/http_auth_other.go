@@ -0,0 +1,12 @@
+//go:build !linux
+
+package core
+
+import (
+	"net"
+)
+
+// Peer credentials of unix sockets are only checked on Linux
+func peerUid(c net.Conn) (uint32, bool) {
+	return 0, false
+}
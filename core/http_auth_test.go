@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	. "gopkg.in/check.v1"
+)
+
+type HTTPAuthTest struct{}
+
+var _ = Suite(&HTTPAuthTest{})
+
+func (s *HTTPAuthTest) TestHTTPAuth(t *C) {
+	dir := t.MkDir()
+	path := filepath.Join(dir, "auth")
+	err := os.WriteFile(path, []byte("# roles\nread token:reader\nadmin token:root\n"+
+		"admin uid:"+strconv.Itoa(os.Getuid())+"\n"), 0600)
+	t.Assert(err, IsNil)
+	auth, err := LoadHTTPAuth(path)
+	t.Assert(err, IsNil)
+
+	err = os.WriteFile(path+".bad", []byte("write token:x\n"), 0600)
+	t.Assert(err, IsNil)
+	_, err = LoadHTTPAuth(path + ".bad")
+	t.Assert(err, NotNil)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := auth.Handler(ok, HTTPAdminPaths...)
+	status := func(path, token string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	t.Assert(status("/metrics", ""), Equals, http.StatusUnauthorized)
+	t.Assert(status("/metrics", "wrong"), Equals, http.StatusUnauthorized)
+	t.Assert(status("/metrics", "reader"), Equals, http.StatusOK)
+	t.Assert(status("/prefill", "reader"), Equals, http.StatusForbidden)
+	t.Assert(status("/prefill", "root"), Equals, http.StatusOK)
+	t.Assert(status("/cache/", "reader"), Equals, http.StatusForbidden)
+	t.Assert(status("/cache/file", "reader"), Equals, http.StatusForbidden)
+	t.Assert(status("/x/../cache", "reader"), Equals, http.StatusForbidden)
+	t.Assert(status("/cachefile", "reader"), Equals, http.StatusOK)
+	t.Assert(status("/handles", "reader"), Equals, http.StatusForbidden)
+	t.Assert(status("/handles", "root"), Equals, http.StatusOK)
+	t.Assert(status("/changes", "reader"), Equals, http.StatusForbidden)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	// Peer uid of unix socket connections
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	t.Assert(err, IsNil)
+	srv := &http.Server{Handler: h, ConnContext: auth.ConnContext}
+	go srv.Serve(l)
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://unix/prefill")
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+	t.Assert(resp.StatusCode, Equals, http.StatusOK)
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type HTTPPoolTest struct{}
+
+var _ = Suite(&HTTPPoolTest{})
+
+func (s *HTTPPoolTest) TestHTTPPoolNoCloud(t *C) {
+	release := make(chan struct{})
+	var arrived sync.WaitGroup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/wait" {
+			arrived.Done()
+			<-release
+		} else if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	flags := cfg.DefaultFlags()
+	flags.MaxIdleConnsPerHost = 1
+	flags.ResponseTimeout = 100 * time.Millisecond
+	pool := cfg.NewHTTPPool(flags)
+	client := &http.Client{Transport: pool}
+	get := func(path string) error {
+		resp, err := client.Get(srv.URL + path)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Requests beyond the idle connection limit saturate the pool
+	var done sync.WaitGroup
+	arrived.Add(3)
+	for i := 0; i < 3; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			t.Check(get("/wait"), IsNil)
+		}()
+	}
+	arrived.Wait()
+	stats := pool.TakeStats()
+	t.Assert(stats.InFlight, Equals, 3)
+	t.Assert(stats.Peak, Equals, 3)
+	t.Assert(stats.NewConns, Equals, int64(3))
+	t.Assert(stats.Saturated, Equals, int64(2))
+	close(release)
+	done.Wait()
+
+	// One connection is kept
+	t.Assert(get("/"), IsNil)
+	stats = pool.TakeStats()
+	t.Assert(stats.InFlight, Equals, 0)
+	t.Assert(stats.ReusedConns, Equals, int64(1))
+	t.Assert(stats.Saturated, Equals, int64(0))
+
+	// Responses have to start in --response-timeout
+	t.Assert(get("/slow"), NotNil)
+}
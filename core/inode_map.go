@@ -0,0 +1,275 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/tidwall/btree"
+)
+
+// InodeMap keeps inode numbers of paths across remounts (--inode-map), so
+// that tools relying on st_ino, like incremental backups, see the same
+// numbers. It's an append-only log of tab-separated records:
+//
+//	<id> <path>       path has inode number id
+//	-    <path>       path is deleted
+//	>    <from> <to>  path is renamed
+//
+// Directory paths in deletions and renames end with "/" and also apply to
+// everything under the directory.
+//
+// Only changes made through this mount are recorded, so paths deleted by
+// other clients stay in the map. The log is compacted on mount when it has
+// twice as many records as live paths. Records are buffered and written
+// within inodeMapFlushInterval, so a crash may lose the numbers of recently
+// looked up paths, but never makes two paths share a number.
+//
+// Paths are kept sorted, so that directory deletions and renames only visit
+// the paths under the directory.
+type InodeMap struct {
+	mu           sync.Mutex
+	path         string
+	file         *os.File
+	w            *bufio.Writer
+	flushPending bool
+	ids          btree.Map[string, fuseops.InodeID]
+	maxId        fuseops.InodeID
+	records      int
+}
+
+const inodeMapFlushInterval = time.Second
+
+func OpenInodeMap(path string) (*InodeMap, error) {
+	m := &InodeMap{
+		path: path,
+	}
+	f, err := os.Open(path)
+	if err == nil {
+		err = m.load(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if m.records > 2*m.ids.Len() {
+		err = m.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m.w = bufio.NewWriter(m.file)
+	return m, nil
+}
+
+func (m *InodeMap) load(f *os.File) error {
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			if line == "" {
+				continue
+			}
+			return fmt.Errorf("%v:%v: invalid record", m.path, n)
+		}
+		switch {
+		case fields[0] == "-":
+			m.deleteUnlocked(fields[1])
+		case fields[0] == ">" && len(fields) == 3:
+			m.renameUnlocked(fields[1], fields[2])
+		default:
+			id, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil || id <= fuseops.RootInodeID {
+				return fmt.Errorf("%v:%v: invalid record", m.path, n)
+			}
+			m.ids.Set(fields[1], fuseops.InodeID(id))
+			if fuseops.InodeID(id) > m.maxId {
+				m.maxId = fuseops.InodeID(id)
+			}
+		}
+		m.records++
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log with live paths only
+func (m *InodeMap) compact() error {
+	tmp := m.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	m.ids.Scan(func(path string, id fuseops.InodeID) bool {
+		fmt.Fprintf(w, "%v\t%v\n", id, path)
+		return true
+	})
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	m.records = m.ids.Len()
+	return nil
+}
+
+// MaxId returns the largest inode number in the map
+func (m *InodeMap) MaxId() fuseops.InodeID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxId
+}
+
+func (m *InodeMap) Get(path string) (fuseops.InodeID, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ids.Get(path)
+}
+
+func (m *InodeMap) Set(path string, id fuseops.InodeID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ids.Set(path, id)
+	if id > m.maxId {
+		m.maxId = id
+	}
+	m.append(id, path)
+}
+
+func (m *InodeMap) Delete(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteUnlocked(path)
+	m.append("-", path)
+}
+
+func (m *InodeMap) Rename(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renameUnlocked(from, to)
+	m.append(">", from, to)
+}
+
+// under calls fn for paths under the directory prefix
+func (m *InodeMap) under(prefix string, fn func(path string, id fuseops.InodeID)) {
+	m.ids.Ascend(prefix, func(p string, id fuseops.InodeID) bool {
+		if !strings.HasPrefix(p, prefix) {
+			return false
+		}
+		fn(p, id)
+		return true
+	})
+}
+
+// Directory paths end with "/" and include everything under them
+func (m *InodeMap) deleteUnlocked(path string) {
+	m.ids.Delete(strings.TrimSuffix(path, "/"))
+	if strings.HasSuffix(path, "/") {
+		var under []string
+		m.under(path, func(p string, id fuseops.InodeID) {
+			under = append(under, p)
+		})
+		for _, p := range under {
+			m.ids.Delete(p)
+		}
+	}
+}
+
+func (m *InodeMap) renameUnlocked(from, to string) {
+	moved := make(map[string]fuseops.InodeID)
+	if id, ok := m.ids.Get(strings.TrimSuffix(from, "/")); ok {
+		moved[strings.TrimSuffix(to, "/")] = id
+	}
+	if strings.HasSuffix(from, "/") {
+		m.under(from, func(p string, id fuseops.InodeID) {
+			moved[to+p[len(from):]] = id
+		})
+	}
+	m.deleteUnlocked(from)
+	m.deleteUnlocked(to)
+	for p, id := range moved {
+		m.ids.Set(p, id)
+	}
+}
+
+func (m *InodeMap) append(fields ...interface{}) {
+	if m.w == nil {
+		return
+	}
+	for i, f := range fields {
+		if i > 0 {
+			m.w.WriteByte('\t')
+		}
+		fmt.Fprint(m.w, f)
+	}
+	m.w.WriteByte('\n')
+	m.records++
+	if !m.flushPending {
+		m.flushPending = true
+		clock.AfterFunc(inodeMapFlushInterval, m.flush)
+	}
+}
+
+func (m *InodeMap) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushPending = false
+	if m.w == nil {
+		return
+	}
+	err := m.w.Flush()
+	if err != nil {
+		log.Errorf("Failed to write inode map %v: %v", m.path, err)
+	}
+}
+
+func (m *InodeMap) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.w == nil {
+		return nil
+	}
+	err := m.w.Flush()
+	m.file.Close()
+	m.w = nil
+	return err
+}
+
+// mapInodeId returns the inode number of path from the map, unless another
+// live inode has it, for example after inode numbers are swapped on rename
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Goofys) mapInodeId(path string) fuseops.InodeID {
+	if id, ok := fs.inodeMap.Get(path); ok && fs.inodes[id] == nil {
+		return id
+	}
+	id := fs.allocateInodeId()
+	fs.inodeMap.Set(path, id)
+	return id
+}
+
+// mapPath returns the path of inode in the map
+func (inode *Inode) mapPath() string {
+	if inode.isDir() {
+		return inode.FullName() + "/"
+	}
+	return inode.FullName()
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type InodeMapTest struct{}
+
+var _ = Suite(&InodeMapTest{})
+
+func (s *InodeMapTest) TestInodeMapLogNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "inodes")
+	m, err := OpenInodeMap(path)
+	t.Assert(err, IsNil)
+	m.Set("a", 5)
+	m.Set("dir", 6)
+	m.Set("dir/x", 7)
+	m.Set("dirx", 8)
+	m.Rename("dir/", "new/")
+	m.Delete("a")
+	t.Assert(m.Close(), IsNil)
+
+	m, err = OpenInodeMap(path)
+	t.Assert(err, IsNil)
+	defer m.Close()
+	id, ok := m.Get("new/x")
+	t.Assert(ok, Equals, true)
+	t.Assert(id, Equals, fuseops.InodeID(7))
+	id, _ = m.Get("new")
+	t.Assert(id, Equals, fuseops.InodeID(6))
+	id, _ = m.Get("dirx")
+	t.Assert(id, Equals, fuseops.InodeID(8))
+	_, ok = m.Get("a")
+	t.Assert(ok, Equals, false)
+	_, ok = m.Get("dir/x")
+	t.Assert(ok, Equals, false)
+	t.Assert(m.MaxId(), Equals, fuseops.InodeID(8))
+
+	// 6 records for 3 live paths aren't compacted yet, 7 are
+	m.Delete("dirx")
+	t.Assert(m.Close(), IsNil)
+	m, err = OpenInodeMap(path)
+	t.Assert(err, IsNil)
+	data, err := os.ReadFile(path)
+	t.Assert(err, IsNil)
+	t.Assert(strings.Count(string(data), "\n"), Equals, 2)
+}
+
+func (s *InodeMapTest) TestInodeMapRemountNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	store.Put("dir/file", []byte("data"), nil)
+	store.Put("other", []byte("data"), nil)
+	flags := cfg.DefaultFlags()
+	flags.InodeMap = filepath.Join(t.MkDir(), "inodes")
+	mount := func() *Goofys {
+		conn := NewSimConn(store)
+		fs, err := newGoofys(context.Background(), "sim", flags, func(string, *cfg.FlagStorage) (StorageBackend, error) {
+			return conn, nil
+		})
+		t.Assert(err, IsNil)
+		return fs
+	}
+
+	fs := mount()
+	// Look up in another order on the second mount
+	other, err := fs.LookupPath("other")
+	t.Assert(err, IsNil)
+	file, err := fs.LookupPath("dir/file")
+	t.Assert(err, IsNil)
+	otherId, fileId := other.Id, file.Id
+	fs.Shutdown()
+
+	fs = mount()
+	defer fs.Shutdown()
+	file, err = fs.LookupPath("dir/file")
+	t.Assert(err, IsNil)
+	t.Assert(file.Id, Equals, fileId)
+	other, err = fs.LookupPath("other")
+	t.Assert(err, IsNil)
+	t.Assert(other.Id, Equals, otherId)
+
+	// New paths get new numbers
+	root := fs.getInodeOrDie(fuseops.RootInodeID)
+	created, _, err := root.Create("new")
+	t.Assert(err, IsNil)
+	t.Assert(created.Id > fileId && created.Id > otherId, Equals, true)
+}
+
+func (s *InodeMapTest) TestInodeMapFlushNoCloud(t *C) {
+	sim := NewSimClock()
+	defer SetClock(sim)()
+	path := filepath.Join(t.MkDir(), "inodes")
+	m, err := OpenInodeMap(path)
+	t.Assert(err, IsNil)
+	defer m.Close()
+	m.Set("dir", 5)
+	m.Set("dir.x", 6)
+	m.Set("dir/a", 7)
+	m.Set("dir/b/c", 8)
+	m.Set("dir0", 9)
+
+	// Renames only move paths under the directory
+	m.Rename("dir/", "new/")
+	for path, want := range map[string]fuseops.InodeID{"new": 5, "dir.x": 6, "new/a": 7, "new/b/c": 8, "dir0": 9} {
+		id, ok := m.Get(path)
+		t.Assert(ok, Equals, true)
+		t.Assert(id, Equals, want)
+	}
+	_, ok := m.Get("dir/a")
+	t.Assert(ok, Equals, false)
+
+	// Buffered records are written after the interval
+	data, err := os.ReadFile(path)
+	t.Assert(err, IsNil)
+	t.Assert(len(data), Equals, 0)
+	sim.Advance(inodeMapFlushInterval)
+	t.Assert(waitUntil(func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n") == 6
+	}), Equals, true)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// With --invalidation-log, mounts of the same bucket tell each other about
+// their changes, so that caches are invalidated in near real time instead of
+// after --stat-cache-ttl. Every mount collects its changes as they reach the
+// server and appends them to a shared log under --temp-prefix every
+// --invalidation-poll as a small chunk object:
+//
+//	<temp-prefix>inval.<unix nanoseconds in hex>.<mount id>
+//
+// and tails the log by listing chunks after the last one it has seen.
+// Listings start a bit earlier than that to tolerate clock skew between
+// clients. Changed inodes found in the cache are rechecked from the server,
+// and missing ones are forgotten from cached listings.
+//
+// Each mount removes its own chunks after invalLogRetention, the ones of
+// crashed mounts are removed by --temp-cleanup-age.
+
+const invalLogName = "inval."
+const invalLogWindow = 30 * time.Second
+const invalLogRetention = 10 * time.Minute
+
+type invalChunk struct {
+	Mount  string        `json:"mount"`
+	Events []ChangeEvent `json:"events"`
+}
+
+type InvalidationLog struct {
+	fs      *Goofys
+	cloud   StorageBackend
+	prefix  string
+	mountId string
+
+	mu      sync.Mutex
+	pending []ChangeEvent
+	// chunks already applied and when they were seen
+	seen map[string]time.Time
+	// listing position
+	lastList time.Time
+	// our chunks to remove after the retention period
+	own []string
+}
+
+func (fs *Goofys) newInvalidationLog(cloud StorageBackend) *InvalidationLog {
+	return &InvalidationLog{
+		fs:       fs,
+		cloud:    cloud,
+		prefix:   fs.tempPrefix + invalLogName,
+		mountId:  RandStringBytesMaskImprSrc(8),
+		seen:     make(map[string]time.Time),
+		lastList: clock.Now(),
+	}
+}
+
+func (l *InvalidationLog) Add(ev ChangeEvent) {
+	if l.fs.isTempKey(ev.Key) {
+		return
+	}
+	l.mu.Lock()
+	l.pending = append(l.pending, ev)
+	l.mu.Unlock()
+}
+
+func (l *InvalidationLog) chunkKey(tm time.Time, mountId string) string {
+	return fmt.Sprintf("%v%016x.%v", l.prefix, tm.UnixNano(), mountId)
+}
+
+// flush appends pending events to the shared log
+func (l *InvalidationLog) flush() error {
+	l.mu.Lock()
+	events := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	body, _ := json.Marshal(&invalChunk{Mount: l.mountId, Events: events})
+	key := l.chunkKey(clock.Now(), l.mountId)
+	_, err := l.cloud.PutBlob(&PutBlobInput{
+		Key:         key,
+		ContentType: PString("application/json"),
+		Body:        bytes.NewReader(body),
+		Size:        PUInt64(uint64(len(body))),
+	})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		// Retry with the next batch
+		l.pending = append(events, l.pending...)
+		return err
+	}
+	l.own = append(l.own, key)
+	l.seen[key] = clock.Now()
+	return nil
+}
+
+// tail applies chunks written by other mounts since the last call
+func (l *InvalidationLog) tail() error {
+	now := clock.Now()
+	startAfter := l.chunkKey(l.lastList.Add(-invalLogWindow), "")
+	var keys []string
+	for {
+		resp, err := l.cloud.ListBlobs(&ListBlobsInput{
+			Prefix:     PString(l.prefix),
+			StartAfter: PString(startAfter),
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range resp.Items {
+			l.mu.Lock()
+			_, seen := l.seen[*item.Key]
+			l.mu.Unlock()
+			if !seen {
+				keys = append(keys, *item.Key)
+			}
+		}
+		if !resp.IsTruncated || len(resp.Items) == 0 {
+			break
+		}
+		startAfter = *resp.Items[len(resp.Items)-1].Key
+	}
+	for _, key := range keys {
+		err := l.apply(key)
+		if err != nil && mapAwsError(err) != syscall.ENOENT {
+			return err
+		}
+		l.mu.Lock()
+		l.seen[key] = now
+		l.mu.Unlock()
+	}
+	l.mu.Lock()
+	l.lastList = now
+	for key, tm := range l.seen {
+		if now.Sub(tm) > 2*invalLogWindow {
+			delete(l.seen, key)
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *InvalidationLog) apply(key string) error {
+	resp, err := l.cloud.GetBlob(&GetBlobInput{Key: key})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var chunk invalChunk
+	if json.Unmarshal(data, &chunk) != nil || chunk.Mount == l.mountId {
+		return nil
+	}
+	for _, ev := range chunk.Events {
+		l.fs.invalidateKey(ev.Key, ev.ETag, ev.Op == "delete")
+		if ev.From != "" {
+			l.fs.invalidateKey(ev.From, "", true)
+		}
+	}
+	return nil
+}
+
+// cleanup removes our chunks older than the retention period
+func (l *InvalidationLog) cleanup() {
+	cutoff := l.chunkKey(clock.Now().Add(-invalLogRetention), "")
+	l.mu.Lock()
+	n := 0
+	for n < len(l.own) && l.own[n] < cutoff {
+		n++
+	}
+	old := l.own[0:n]
+	l.mu.Unlock()
+	if len(old) == 0 {
+		return
+	}
+	_, err := l.cloud.DeleteBlobs(&DeleteBlobsInput{Items: old})
+	if err != nil {
+		log.Warnf("Failed to remove old invalidation log chunks: %v", err)
+		return
+	}
+	l.mu.Lock()
+	l.own = l.own[n:]
+	l.mu.Unlock()
+}
+
+// InvalidationLogger appends our changes to the shared invalidation log and
+// applies changes of other mounts every --invalidation-poll
+func (fs *Goofys) InvalidationLogger() {
+	l := fs.invalLog
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(fs.flags.InvalidationPoll):
+		case <-fs.shutdownCh:
+			l.flush()
+			return
+		}
+		err := l.flush()
+		if err != nil {
+			log.Warnf("Failed to append to the invalidation log: %v", err)
+		}
+		err = l.tail()
+		if err != nil {
+			log.Warnf("Failed to read the invalidation log: %v", err)
+		}
+		l.cleanup()
+	}
+}
+
+// invalidateKey drops cached information about key changed by another
+// mount or reported by an S3 event notification. Cached inodes are rechecked from the server, missing ones are
+// forgotten from cached listings of their parent
+func (fs *Goofys) invalidateKey(key, etag string, deleted bool) {
+	root := fs.getInodeOrDie(fuseops.RootInodeID)
+	_, prefix := root.cloud()
+	if !strings.HasPrefix(key, prefix) {
+		return
+	}
+	path := strings.TrimSuffix(key[len(prefix):], "/")
+	if path == "" {
+		return
+	}
+	parent := root
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		child := parent.findChild(name)
+		if child != nil && i < len(names)-1 && child.isDir() {
+			parent = child
+			continue
+		}
+		if child != nil && i == len(names)-1 {
+			child.mu.Lock()
+			// S3 event notifications have ETags without quotes
+			same := !deleted && etag != "" && strings.Trim(child.knownETag, "\"") == strings.Trim(etag, "\"")
+			child.mu.Unlock()
+			if !same {
+				fs.RefreshInodeCache(child)
+			}
+			return
+		}
+		// Not cached: forget it from the listing of the parent
+		cloudRoot := parent
+		for cloudRoot.dir.cloud == nil {
+			cloudRoot = cloudRoot.Parent
+		}
+		childKey := prefix + strings.Join(names[0:i+1], "/")
+		cloudRoot.mu.Lock()
+		cloudRoot.dir.checkGapLoaded(childKey, TIME_MAX)
+		cloudRoot.dir.checkGapLoaded(childKey+"/", TIME_MAX)
+		cloudRoot.mu.Unlock()
+		parent.mu.Lock()
+		parent.dir.DirTime = time.Time{}
+		parent.mu.Unlock()
+		if fs.NotifyCallback != nil {
+			fs.NotifyCallback([]interface{}{&fuseops.NotifyInvalEntry{
+				Parent: parent.Id,
+				Name:   name,
+			}})
+		}
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type InvalidationLogTest struct{}
+
+var _ = Suite(&InvalidationLogTest{})
+
+func waitUntil(cond func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *InvalidationLogTest) TestInvalidationLogNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = time.Hour
+		flags.InvalidationLog = true
+		flags.InvalidationPoll = 20 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(b.WriteAndSync("file", []byte("old")), IsNil)
+	data, err := a.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "old")
+	root := a.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"file"})
+
+	// Changed and new files are seen without waiting for the cache TTL
+	t.Assert(b.WriteAndSync("file", []byte("new")), IsNil)
+	t.Assert(b.WriteAndSync("file2", []byte("2")), IsNil)
+	t.Assert(waitUntil(func() bool {
+		data, err := a.ReadFile("file")
+		return err == nil && string(data) == "new"
+	}), Equals, true)
+	t.Assert(waitUntil(func() bool {
+		return len(listDir(t, root)) == 2
+	}), Equals, true)
+	data, err = a.ReadFile("file2")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "2")
+
+	// Removed files disappear
+	dir := b.fs.getInodeOrDie(1)
+	t.Assert(dir.Unlink("file"), IsNil)
+	t.Assert(b.fs.SyncTree(nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		_, err := a.fs.LookupPath("file")
+		return err != nil
+	}), Equals, true)
+
+	// Chunks are stored under the temp prefix and hidden
+	found := false
+	for _, key := range c.Store.Keys() {
+		found = found || strings.HasPrefix(key, ".geesefs_tmp/inval.")
+	}
+	t.Assert(found, Equals, true)
+	t.Assert(listDir(t, root), DeepEquals, []string{"file2"})
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"strings"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// With --key-manifest, the mounted namespace is defined by a manifest file
+// instead of listings: a frozen set of objects for reproducible processing,
+// or a bucket where listing is too slow or expensive. Each line contains a
+// key, its size and optionally its ETag, RFC 3339 modification time and
+// version ID (only used by clones), separated with tabs. Keys outside of the
+// mounted prefix are ignored. Entries never expire and LIST is never called
+// (--key-manifest implies --no-list).
+
+func readKeyManifest(path string) ([]BlobItemOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	snap, err := ReadSnapshot(f, path)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Items, nil
+}
+
+// loadKeyManifest fills the inode tree from the manifest and makes it permanent
+func (fs *Goofys) loadKeyManifest(path string) error {
+	items, err := readKeyManifest(path)
+	if err != nil {
+		return err
+	}
+	root := fs.getInodeOrDie(fuseops.RootInodeID)
+	prefix := root.dir.mountPrefix
+	dirs := make(map[*Inode]bool)
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	loaded := 0
+	for i := range items {
+		key := *items[i].Key
+		if !strings.HasPrefix(key, prefix) || len(key) == len(prefix) || fs.isTempKey(key) {
+			continue
+		}
+		root.insertSubTree(key[len(prefix):], &items[i], dirs)
+		loaded++
+	}
+	root.freezeTree()
+	log.Infof("Loaded %v objects from %v", loaded, path)
+	return nil
+}
+
+// freezeTree marks the subtree as fully known so that it's never refreshed
+// and names missing in it are never looked up
+// LOCKS_REQUIRED(dir.mu)
+func (dir *Inode) freezeTree() {
+	dir.dir.frozen = true
+	dir.dir.listDone = false
+	dir.dir.lastFromCloud = nil
+	dir.dir.DirTime = TIME_MAX
+	for _, child := range dir.dir.Children {
+		child.mu.Lock()
+		child.SetAttrTime(TIME_MAX)
+		if child.isDir() {
+			child.freezeTree()
+		}
+		child.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type KeyManifestTest struct{}
+
+var _ = Suite(&KeyManifestTest{})
+
+func (s *KeyManifestTest) TestKeyManifestNoCloud(t *C) {
+	manifest := filepath.Join(t.MkDir(), "manifest")
+	err := os.WriteFile(manifest, []byte("# frozen set\n"+
+		"data/run1/a.h5\t5\n"+
+		"data/run1/b.h5\t6\t\"etag\"\t2024-01-02T03:04:05Z\n"+
+		"data/master.h5\t3\n"+
+		"other/x\t1\n"), 0600)
+	t.Assert(err, IsNil)
+	_, err = readKeyManifest(manifest + ".missing")
+	t.Assert(err, NotNil)
+
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.KeyManifest = manifest
+		flags.NoList = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	defer SetClock(c.Clock)()
+	m := c.Mounts[0]
+	c.Store.Put("data/run1/a.h5", []byte("aaaaa"), nil)
+	c.Store.Put("data/unlisted", []byte("u"), nil)
+
+	b, err := m.fs.LookupPath("data/run1/b.h5")
+	t.Assert(err, IsNil)
+	t.Assert(b.Attributes.Size, Equals, uint64(6))
+	t.Assert(b.knownETag, Equals, "\"etag\"")
+	t.Assert(b.Attributes.Mtime.Year(), Equals, 2024)
+
+	// Objects outside of the manifest don't exist
+	_, err = m.fs.LookupPath("data/unlisted")
+	t.Assert(err, Equals, syscall.ENOENT)
+	t.Assert(m.Conn.Calls("HeadBlob"), Equals, 0)
+	// even when the directory cache expires, in refreshes and in new directories
+	c.Store.Put("unlisted", []byte("u"), nil)
+	c.Store.Put("data/new/unlisted", []byte("u"), nil)
+	root := m.fs.getInodeOrDie(fuseops.RootInodeID)
+	inode, err := root.LookUp("unlisted", true)
+	t.Assert(err, IsNil)
+	t.Assert(inode, IsNil)
+	run1, err := m.fs.LookupPath("data/run1")
+	t.Assert(err, IsNil)
+	run1.mu.Lock()
+	run1.sealDir()
+	run1.mu.Unlock()
+	c.Clock.Advance(2 * m.fs.flags.StatCacheTTL)
+	_, err = m.fs.LookupPath("data/run1/unlisted")
+	t.Assert(err, Equals, syscall.ENOENT)
+	data, err := m.fs.LookupPath("data")
+	t.Assert(err, IsNil)
+	_, err = data.MkDir("new")
+	t.Assert(err, IsNil)
+	_, err = m.fs.LookupPath("data/new/unlisted")
+	t.Assert(err, Equals, syscall.ENOENT)
+	t.Assert(m.Conn.Calls("HeadBlob"), Equals, 0)
+
+	content, err := m.ReadFile("data/run1/a.h5")
+	t.Assert(err, IsNil)
+	t.Assert(string(content), Equals, "aaaaa")
+
+	empty, err := data.isEmptyDir()
+	t.Assert(err, IsNil)
+	t.Assert(empty, Equals, false)
+	t.Assert(m.Conn.Calls("ListBlobs"), Equals, 0)
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Latency histogram buckets are powers of 2 starting from 32 microseconds,
+// the last one is +Inf (everything above ~34 seconds)
+const latencyBuckets = 22
+const latencyFirstBucket = 32 * time.Microsecond
+
+type LatencyHistogram struct {
+	counts [latencyBuckets]uint64
+	sumNs  uint64
+}
+
+func latencyBucketLimit(i int) time.Duration {
+	return latencyFirstBucket << i
+}
+
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	i := 0
+	for i < latencyBuckets-1 && d > latencyBucketLimit(i) {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sumNs, uint64(d))
+}
+
+func (h *LatencyHistogram) Snapshot() (s LatencyHistogram) {
+	for i := 0; i < latencyBuckets; i++ {
+		s.counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	s.sumNs = atomic.LoadUint64(&h.sumNs)
+	return
+}
+
+// Sub returns the histogram of observations made between prev and h
+func (h LatencyHistogram) Sub(prev LatencyHistogram) (s LatencyHistogram) {
+	for i := 0; i < latencyBuckets; i++ {
+		s.counts[i] = h.counts[i] - prev.counts[i]
+	}
+	s.sumNs = h.sumNs - prev.sumNs
+	return
+}
+
+func (h *LatencyHistogram) Count() (n uint64) {
+	for i := 0; i < latencyBuckets; i++ {
+		n += h.counts[i]
+	}
+	return
+}
+
+// Quantile estimates the q-th quantile by linear interpolation within the
+// bucket containing it, like histogram_quantile() of Prometheus. Quantiles in
+// the overflow bucket are reported as the maximum duration
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	seen := uint64(0)
+	for i := 0; i < latencyBuckets-1; i++ {
+		n := h.counts[i]
+		if n > 0 && float64(seen+n) >= rank {
+			lower := time.Duration(0)
+			if i > 0 {
+				lower = latencyBucketLimit(i - 1)
+			}
+			upper := latencyBucketLimit(i)
+			return lower + time.Duration(float64(upper-lower)*(rank-float64(seen))/float64(n))
+		}
+		seen += n
+	}
+	// Overflow bucket
+	return time.Duration(1<<63 - 1)
+}
+
+type OpLatencies struct {
+	mu  sync.RWMutex
+	ops map[string]*LatencyHistogram
+}
+
+func (l *OpLatencies) get(op string) *LatencyHistogram {
+	l.mu.RLock()
+	h := l.ops[op]
+	l.mu.RUnlock()
+	if h == nil {
+		l.mu.Lock()
+		if l.ops == nil {
+			l.ops = make(map[string]*LatencyHistogram)
+		}
+		h = l.ops[op]
+		if h == nil {
+			h = &LatencyHistogram{}
+			l.ops[op] = h
+		}
+		l.mu.Unlock()
+	}
+	return h
+}
+
+func (l *OpLatencies) Observe(op string, d time.Duration) {
+	l.get(op).Observe(d)
+}
+
+func (l *OpLatencies) Snapshot() map[string]LatencyHistogram {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	res := make(map[string]LatencyHistogram, len(l.ops))
+	for op, h := range l.ops {
+		res[op] = h.Snapshot()
+	}
+	return res
+}
+
+// Short operation names accepted in --slo
+var sloOpAliases = map[string]string{
+	"getattr": "GetInodeAttributes",
+	"setattr": "SetInodeAttributes",
+	"lookup":  "LookUpInode",
+	"readdir": "ReadDir",
+	"open":    "OpenFile",
+	"read":    "ReadFile",
+	"write":   "WriteFile",
+	"flush":   "FlushFile",
+	"fsync":   "SyncFile",
+	"create":  "CreateFile",
+	"mkdir":   "MkDir",
+	"rmdir":   "RmDir",
+	"unlink":  "Unlink",
+	"rename":  "Rename",
+}
+
+func sloOpName(op string) string {
+	if full, ok := sloOpAliases[strings.ToLower(op)]; ok {
+		return full
+	}
+	return op
+}
+
+// SLOMonitor checks latency SLOs every window and emits alarms on violation
+func (fs *Goofys) SLOMonitor() {
+	prev := fs.latency.Snapshot()
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		select {
+		case <-clock.After(fs.flags.SLOWindow):
+		case <-fs.shutdownCh:
+			return
+		}
+		cur := fs.latency.Snapshot()
+		for _, slo := range fs.flags.SLOs {
+			op := sloOpName(slo.Op)
+			var found bool
+			var window LatencyHistogram
+			for name, h := range cur {
+				if strings.EqualFold(name, op) {
+					window, found = h.Sub(prev[name]), true
+					break
+				}
+			}
+			if !found || window.Count() == 0 {
+				continue
+			}
+			q := window.Quantile(slo.Quantile)
+			if q > slo.Max {
+				atomic.AddUint64(&fs.sloViolations, 1)
+				log.Warnf("SLO violated: %v p%v = %v > %v over the last %v (%v ops)",
+					op, slo.Quantile*100, q, slo.Max, fs.flags.SLOWindow, window.Count())
+			}
+		}
+		prev = cur
+	}
+}
+
+func writeLatencyMetrics(w io.Writer, ops map[string]LatencyHistogram, violations uint64) {
+	names := make([]string, 0, len(ops))
+	for op := range ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# TYPE geesefs_op_latency_seconds histogram\n")
+	for _, op := range names {
+		h := ops[op]
+		cum := uint64(0)
+		for i := 0; i < latencyBuckets; i++ {
+			cum += h.counts[i]
+			le := "+Inf"
+			if i < latencyBuckets-1 {
+				le = fmt.Sprintf("%g", latencyBucketLimit(i).Seconds())
+			}
+			fmt.Fprintf(w, "geesefs_op_latency_seconds_bucket{op=%q,le=%q} %v\n", op, le, cum)
+		}
+		fmt.Fprintf(w, "geesefs_op_latency_seconds_sum{op=%q} %g\n", op, time.Duration(h.sumNs).Seconds())
+		fmt.Fprintf(w, "geesefs_op_latency_seconds_count{op=%q} %v\n", op, cum)
+	}
+	fmt.Fprintf(w, "# TYPE geesefs_slo_violations_total counter\n")
+	fmt.Fprintf(w, "geesefs_slo_violations_total %v\n", violations)
+}
+
+// LatencyHandler exports operation latency histograms in Prometheus text format
+func (fs *Goofys) LatencyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeLatencyMetrics(w, fs.latency.Snapshot(), atomic.LoadUint64(&fs.sloViolations))
+		if fs.checksums != nil {
+			fmt.Fprintf(w, "# TYPE geesefs_checksum_verified_total counter\n")
+			fmt.Fprintf(w, "geesefs_checksum_verified_total %v\n", atomic.LoadUint64(&fs.checksumsVerified))
+			fmt.Fprintf(w, "# TYPE geesefs_checksum_mismatches_total counter\n")
+			fmt.Fprintf(w, "geesefs_checksum_mismatches_total %v\n", atomic.LoadUint64(&fs.checksumMismatches))
+		}
+		if fs.flags.DirtyAgeAlert > 0 || fs.flags.MaxDirtyAge > 0 {
+			fs.writeDirtyAgeMetrics(w)
+		}
+		if fs.flags.UsageSnapshot != "" {
+			fs.usage.mu.Lock()
+			usage := fs.usage.last
+			fs.usage.mu.Unlock()
+			writeUsageMetrics(w, usage)
+		}
+	})
+}
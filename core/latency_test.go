@@ -0,0 +1,50 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type LatencyTest struct{}
+
+var _ = Suite(&LatencyTest{})
+
+func (s *LatencyTest) TestQuantile(t *C) {
+	var h LatencyHistogram
+	t.Assert(h.Quantile(0.99), Equals, time.Duration(0))
+	for i := 0; i < 98; i++ {
+		h.Observe(100 * time.Microsecond)
+	}
+	h.Observe(10 * time.Millisecond)
+	h.Observe(time.Hour)
+	t.Assert(h.Count(), Equals, uint64(100))
+	// Interpolated within (64us, 128us], so an SLO of 100us isn't violated
+	// just because the bucket extends beyond it
+	t.Assert(h.Quantile(0.5), Equals, 64*time.Microsecond+64*time.Microsecond*50/98)
+	t.Assert(h.Quantile(0.5) < 100*time.Microsecond, Equals, true)
+	t.Assert(h.Quantile(0.99), Equals, 16384*time.Microsecond)
+	t.Assert(h.Quantile(1) > time.Hour, Equals, true)
+
+	prev := h.Snapshot()
+	h.Observe(time.Second)
+	window := h.Snapshot().Sub(prev)
+	t.Assert(window.Count(), Equals, uint64(1))
+	t.Assert(window.Quantile(0.99), Equals, 1043333120*time.Nanosecond)
+}
+
+func (s *LatencyTest) TestMetrics(t *C) {
+	var l OpLatencies
+	l.Observe("GetInodeAttributes", time.Millisecond)
+	l.Observe("GetInodeAttributes", 2*time.Millisecond)
+	var buf bytes.Buffer
+	writeLatencyMetrics(&buf, l.Snapshot(), 3)
+	out := buf.String()
+	t.Assert(strings.Contains(out, `geesefs_op_latency_seconds_bucket{op="GetInodeAttributes",le="0.001024"} 1`), Equals, true)
+	t.Assert(strings.Contains(out, `geesefs_op_latency_seconds_bucket{op="GetInodeAttributes",le="+Inf"} 2`), Equals, true)
+	t.Assert(strings.Contains(out, `geesefs_op_latency_seconds_count{op="GetInodeAttributes"} 2`), Equals, true)
+	t.Assert(strings.Contains(out, "geesefs_slo_violations_total 3"), Equals, true)
+	t.Assert(sloOpName("GetAttr"), Equals, "GetInodeAttributes")
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Lease is a named lock shared by all clients of the bucket, for example to
+// let only one mount write to a directory. It's stored as a small object
+// under --temp-prefix and relies on conditional PUTs: the lease is created
+// with If-None-Match: * and renewed, taken over and released with If-Match
+// on its ETag, so only one client at a time can create, renew or take over
+// the lease.
+//
+// The holder must renew the lease more often than its TTL. Other clients
+// consider the lease expired when its ETag doesn't change for the TTL since
+// they first saw it, so clocks of different clients don't have to agree.
+// A holder which is paused for longer than the TTL keeps believing it holds
+// the lease until its next renewal fails with ErrLeaseLost, so the lease
+// doesn't protect against such a holder by itself.
+type Lease struct {
+	mu    sync.Mutex
+	cloud StorageBackend
+	key   string
+	ttl   time.Duration
+	owner string
+	// changes the lease object on every renewal, its ETag would stay the
+	// same otherwise
+	seq uint64
+	// ETag of the lease object while we hold it
+	etag *string
+	// the last seen ETag of the lease held by someone else, and when it was
+	// first seen
+	seenETag string
+	seenAt   time.Time
+}
+
+type leaseBody struct {
+	Owner    string        `json:"owner"`
+	TTL      time.Duration `json:"ttl"`
+	Seq      uint64        `json:"seq"`
+	Released bool          `json:"released,omitempty"`
+}
+
+var ErrLeaseLost = errors.New("lease lost")
+
+// LeaseHeldError is returned by Acquire when the lease is held by another
+// client and not expired yet
+type LeaseHeldError struct {
+	Owner string
+}
+
+func (e *LeaseHeldError) Error() string {
+	return fmt.Sprintf("lease held by %v", e.Owner)
+}
+
+// NewLease returns a lease which isn't acquired yet. Leases are stored under
+// --temp-prefix, so they can't be used when it's empty
+func (fs *Goofys) NewLease(name string, ttl time.Duration) (*Lease, error) {
+	if fs.tempPrefix == "" {
+		return nil, syscall.ENOTSUP
+	}
+	cloud, _ := fs.getInodeOrDie(1).cloud()
+	host, _ := os.Hostname()
+	return &Lease{
+		cloud: cloud,
+		key:   fs.tempPrefix + "lease." + name,
+		ttl:   ttl,
+		owner: fmt.Sprintf("%v:%v:%v", host, os.Getpid(), RandStringBytesMaskImprSrc(8)),
+	}, nil
+}
+
+func (l *Lease) Owner() string {
+	return l.owner
+}
+
+// Held reports if we think we hold the lease. It may be already taken over
+// if it wasn't renewed in time
+func (l *Lease) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.etag != nil
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *Lease) put(released bool, ifMatch *string) error {
+	if !l.cloud.Capabilities().ConditionalPut {
+		return syscall.ENOTSUP
+	}
+	l.seq++
+	body, _ := json.Marshal(&leaseBody{Owner: l.owner, TTL: l.ttl, Seq: l.seq, Released: released})
+	put := &PutBlobInput{
+		Key:         l.key,
+		ContentType: PString("application/json"),
+		Body:        bytes.NewReader(body),
+		Size:        PUInt64(uint64(len(body))),
+		IfMatch:     ifMatch,
+	}
+	if ifMatch == nil {
+		put.IfNoneMatch = PString("*")
+	}
+	resp, err := l.cloud.PutBlob(put)
+	if err != nil {
+		return mapAwsError(err)
+	}
+	if released || resp.ETag == nil {
+		l.etag = nil
+	} else {
+		l.etag = resp.ETag
+	}
+	return nil
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *Lease) get() (*leaseBody, string, error) {
+	resp, err := l.cloud.GetBlob(&GetBlobInput{Key: l.key})
+	if err != nil {
+		return nil, "", mapAwsError(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var body leaseBody
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		// Treat garbage as a released lease so that it can be taken over
+		body.Released = true
+	}
+	return &body, NilStr(resp.ETag), nil
+}
+
+// Acquire acquires the lease if it's free, released or expired, or renews
+// it if we already hold it. Returns *LeaseHeldError if it's held by someone
+// else
+func (l *Lease) Acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.etag != nil {
+		return l.renewUnlocked()
+	}
+	err := l.put(false, nil)
+	if err != syscall.EBUSY {
+		return err
+	}
+	body, etag, err := l.get()
+	if err == syscall.ENOENT {
+		// Released and removed in the meantime, retry once
+		return l.put(false, nil)
+	} else if err != nil {
+		return err
+	}
+	if etag != l.seenETag {
+		l.seenETag = etag
+		l.seenAt = clock.Now()
+	}
+	ttl := body.TTL
+	if ttl <= 0 {
+		ttl = l.ttl
+	}
+	if body.Owner != l.owner && !body.Released && clock.Now().Sub(l.seenAt) < ttl {
+		return &LeaseHeldError{Owner: body.Owner}
+	}
+	err = l.put(false, &etag)
+	if err == syscall.EBUSY || err == syscall.ENOENT {
+		// Someone was faster
+		return &LeaseHeldError{Owner: body.Owner}
+	}
+	return err
+}
+
+// Renew extends the lease. Returns ErrLeaseLost if it's taken over by
+// someone else
+func (l *Lease) Renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.renewUnlocked()
+}
+
+// LOCKS_REQUIRED(l.mu)
+func (l *Lease) renewUnlocked() error {
+	if l.etag == nil {
+		return ErrLeaseLost
+	}
+	err := l.put(false, l.etag)
+	if err == syscall.EBUSY || err == syscall.ENOENT {
+		l.etag = nil
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// Release marks the lease as released so that others can acquire it
+// without waiting for the TTL
+func (l *Lease) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.etag == nil {
+		return nil
+	}
+	err := l.put(true, l.etag)
+	if err == syscall.EBUSY || err == syscall.ENOENT {
+		l.etag = nil
+		return ErrLeaseLost
+	}
+	return err
+}
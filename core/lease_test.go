@@ -0,0 +1,62 @@
+package core
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type LeaseTest struct{}
+
+var _ = Suite(&LeaseTest{})
+
+func (s *LeaseTest) TestLeaseNoCloud(t *C) {
+	c, err := NewSimCluster(2, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, err := c.Mounts[0].fs.NewLease("dir", time.Hour)
+	t.Assert(err, IsNil)
+	b, err := c.Mounts[1].fs.NewLease("dir", time.Hour)
+	t.Assert(err, IsNil)
+
+	t.Assert(a.Acquire(), IsNil)
+	t.Assert(a.Held(), Equals, true)
+	err = b.Acquire()
+	t.Assert(err, FitsTypeOf, &LeaseHeldError{})
+	t.Assert(err.(*LeaseHeldError).Owner, Equals, a.Owner())
+	t.Assert(a.Renew(), IsNil)
+	t.Assert(a.Acquire(), IsNil)
+
+	// Released leases are acquired without waiting
+	t.Assert(a.Release(), IsNil)
+	t.Assert(a.Held(), Equals, false)
+	t.Assert(b.Acquire(), IsNil)
+	t.Assert(a.Acquire(), FitsTypeOf, &LeaseHeldError{})
+	t.Assert(b.Release(), IsNil)
+	t.Assert(a.Acquire(), IsNil)
+}
+
+func (s *LeaseTest) TestLeaseExpireNoCloud(t *C) {
+	c, err := NewSimCluster(2, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, err := c.Mounts[0].fs.NewLease("dir", 50*time.Millisecond)
+	t.Assert(err, IsNil)
+	b, err := c.Mounts[1].fs.NewLease("dir", 50*time.Millisecond)
+	t.Assert(err, IsNil)
+
+	t.Assert(a.Acquire(), IsNil)
+	t.Assert(b.Acquire(), FitsTypeOf, &LeaseHeldError{})
+	// Renewals keep the lease even after the TTL since it was first seen
+	time.Sleep(60 * time.Millisecond)
+	t.Assert(a.Renew(), IsNil)
+	t.Assert(b.Acquire(), FitsTypeOf, &LeaseHeldError{})
+
+	// Not renewed in time
+	time.Sleep(60 * time.Millisecond)
+	t.Assert(b.Acquire(), IsNil)
+	t.Assert(a.Renew(), Equals, ErrLeaseLost)
+	t.Assert(a.Held(), Equals, false)
+	t.Assert(a.Release(), IsNil)
+	t.Assert(b.Held(), Equals, true)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package core
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on the file without waiting. It's released
+// when the file is closed, also when the process dies
+func lockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Some S3-compatible stores (Ceph RGW, older MinIO) reject If-Match and
+// If-None-Match on PUT. On them .geesefs_meta objects are changed under a
+// lock object, .geesefs_meta.lock next to them, and every write is verified
+// afterwards:
+//
+//   - The lock is taken by writing it with a random owner unconditionally,
+//     waiting for concurrent writes to settle and reading it back. Whoever
+//     reads their own owner holds it, others wait for it to be removed.
+//   - A lock whose ETag doesn't change for dirMetaLockTTL is considered left
+//     by a crashed client and taken over, so clocks don't have to agree.
+//   - After writing the .geesefs_meta object its ETag is checked with a HEAD
+//     request. If it's different, the object was overwritten by someone who
+//     didn't see the lock, and the change is merged into it again.
+//
+// This is weaker than conditional PUTs on stores without read-after-write
+// consistency, but still keeps changes of several mounts from being lost.
+
+const dirMetaLockName = ".geesefs_meta.lock"
+const dirMetaLockTTL = 30 * time.Second
+const dirMetaLockSettle = 100 * time.Millisecond
+const dirMetaLockPoll = 50 * time.Millisecond
+
+type dirMetaLock struct {
+	cloud StorageBackend
+	key   string
+	owner string
+}
+
+type dirMetaLockBody struct {
+	Owner string    `json:"owner"`
+	Time  time.Time `json:"time"`
+}
+
+// readOwner returns the owner of the lock and the ETag of the lock object
+func (l *dirMetaLock) readOwner() (owner string, etag string, err error) {
+	data, etag, err := getBlobData(l.cloud, l.key)
+	if err != nil {
+		return "", "", err
+	}
+	var body dirMetaLockBody
+	json.Unmarshal(data, &body)
+	return body.Owner, etag, nil
+}
+
+// lockDirMeta takes the lock of the .geesefs_meta object key. It returns
+// EBUSY if the lock is held by others for too long
+func lockDirMeta(cloud StorageBackend, key string) (*dirMetaLock, error) {
+	host, _ := os.Hostname()
+	l := &dirMetaLock{
+		cloud: cloud,
+		key:   strings.TrimSuffix(key, dirMetaName) + dirMetaLockName,
+		owner: fmt.Sprintf("%v:%v:%v", host, os.Getpid(), RandStringBytesMaskImprSrc(8)),
+	}
+	start := clock.Now()
+	var seenETag string
+	var seenAt time.Time
+	for {
+		owner, etag, err := l.readOwner()
+		if err == nil {
+			if etag != seenETag {
+				seenETag = etag
+				seenAt = clock.Now()
+			}
+			if clock.Now().Sub(seenAt) < dirMetaLockTTL {
+				if clock.Now().Sub(start) >= 2*dirMetaLockTTL {
+					log.Warnf("%v is held by %v for too long", l.key, owner)
+					return nil, syscall.EBUSY
+				}
+				clock.Sleep(dirMetaLockPoll)
+				continue
+			}
+			log.Warnf("Taking over %v left by %v", l.key, owner)
+		} else if err != syscall.ENOENT {
+			return nil, err
+		}
+		body, _ := json.Marshal(&dirMetaLockBody{Owner: l.owner, Time: clock.Now().UTC()})
+		_, err = cloud.PutBlob(&PutBlobInput{
+			Key:         l.key,
+			ContentType: PString("application/json"),
+			Body:        bytes.NewReader(body),
+			Size:        PUInt64(uint64(len(body))),
+		})
+		if err != nil {
+			return nil, mapAwsError(err)
+		}
+		// Let a concurrent writer's PUT land before checking who won
+		clock.Sleep(dirMetaLockSettle)
+		owner, _, err = l.readOwner()
+		if err == nil && owner == l.owner {
+			return l, nil
+		} else if err != nil && err != syscall.ENOENT {
+			return nil, err
+		}
+		s3Log.Debugf("Lost %v to %v, waiting", l.key, owner)
+	}
+}
+
+// unlock removes the lock object if it's still ours
+func (l *dirMetaLock) unlock() {
+	owner, _, err := l.readOwner()
+	if err != nil {
+		if err != syscall.ENOENT {
+			log.Warnf("Failed to release %v: %v", l.key, err)
+		}
+		return
+	}
+	if owner != l.owner {
+		log.Warnf("%v was taken over by %v before it was released", l.key, owner)
+		return
+	}
+	_, err = l.cloud.DeleteBlob(&DeleteBlobInput{Key: l.key})
+	if err != nil {
+		log.Warnf("Failed to release %v: %v", l.key, err)
+	}
+}
+
+// updateDirMetaLocked is updateDirMeta for backends without conditional PUTs
+func updateDirMetaLocked(cloud StorageBackend, key string, merges ...dirMetaMerge) error {
+	lock, err := lockDirMeta(cloud, key)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	for attempt := 1; ; attempt++ {
+		obj, _, version, err := getDirMetaObject(cloud, key)
+		if err != nil {
+			return err
+		}
+		body, err := mergeDirMeta(obj, version, merges)
+		if err != nil || body == nil {
+			return err
+		}
+		resp, err := cloud.PutBlob(&PutBlobInput{
+			Key:         key,
+			ContentType: PString("application/json"),
+			Body:        bytes.NewReader(body),
+			Size:        PUInt64(uint64(len(body))),
+		})
+		if err != nil {
+			return mapAwsError(err)
+		}
+		clock.Sleep(dirMetaLockSettle)
+		head, err := cloud.HeadBlob(&HeadBlobInput{Key: key})
+		err = mapAwsError(err)
+		if err != nil && err != syscall.ENOENT {
+			return err
+		}
+		if err == nil && (resp.ETag == nil || NilStr(head.ETag) == *resp.ETag) {
+			if version == 0 {
+				dropLegacyDirMeta(cloud, key)
+			}
+			return nil
+		}
+		if attempt >= dirMetaRetries {
+			return syscall.EBUSY
+		}
+		// Overwritten or removed by someone else, merge into the new version
+		s3Log.Debugf("Conflict updating %v, retrying (attempt %v)", key, attempt)
+	}
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// .geesefs_meta objects are upgraded when their format changes: every format
+// change registers a migration from the previous version with
+// registerDirMetaMigration, which also raises dirMetaVersion. Objects of
+// older versions are upgraded in memory when they're loaded, and rewritten in
+// the new format on the next change of any entry or, with --dir-meta-migrate,
+// in background as soon as they're loaded. Objects of newer versions are read
+// as far as their sections are known, but never overwritten, so they're never
+// downgraded.
+//
+// Version 0 is the .geesefs_dirmeta object of older versions. It's converted
+// when it's loaded and replaced by a .geesefs_meta object when it's
+// rewritten. The status of migrations is served as JSON on /migrations of
+// the --pprof port.
+
+// dirMetaMigration upgrades the raw sections of an object from version From
+// to From+1
+type dirMetaMigration struct {
+	From    int
+	Name    string
+	Upgrade func(obj dirMetaObject) error
+}
+
+var dirMetaMigrations []dirMetaMigration
+
+// registerDirMetaMigration adds a migration from the current version. It's
+// called from init functions
+func registerDirMetaMigration(m dirMetaMigration) {
+	if m.From != dirMetaVersion {
+		panic(fmt.Sprintf("migration %v is from version %v, but the current version is %v",
+			m.Name, m.From, dirMetaVersion))
+	}
+	dirMetaMigrations = append(dirMetaMigrations, m)
+	dirMetaVersion++
+}
+
+// version returns the version of the object as stored
+func (obj dirMetaObject) version() int {
+	version := 1
+	if raw, ok := obj["version"]; ok {
+		json.Unmarshal(raw, &version)
+	}
+	return version
+}
+
+// upgrade applies migrations to an object of an older version, key is used
+// in errors. It returns the version the object had
+func (obj dirMetaObject) upgrade(key string) (int, error) {
+	from := obj.version()
+	for _, m := range dirMetaMigrations {
+		if m.From < from {
+			continue
+		}
+		err := m.Upgrade(obj)
+		if err != nil {
+			return from, fmt.Errorf("%v: migration %v from version %v: %v", key, m.Name, m.From, err)
+		}
+	}
+	if from < dirMetaVersion {
+		obj["version"], _ = json.Marshal(dirMetaVersion)
+	}
+	return from, nil
+}
+
+type metaMigrationState struct {
+	mu sync.Mutex
+	// objects loaded by their stored version, 0 is .geesefs_dirmeta
+	loaded    map[int]int64
+	upgraded  int64
+	newer     int64
+	rewritten int64
+	failed    int64
+	// keys of objects being rewritten in background
+	pending map[string]bool
+}
+
+type MetaMigrationStatus struct {
+	Version    int           `json:"version"`
+	Migrations []string      `json:"migrations"`
+	Loaded     map[int]int64 `json:"loaded"`
+	Upgraded   int64         `json:"upgraded"`
+	Newer      int64         `json:"newer"`
+	Rewritten  int64         `json:"rewritten"`
+	Failed     int64         `json:"failed"`
+	Pending    int           `json:"pending"`
+}
+
+// noteDirMetaVersion records a loaded .geesefs_meta or .geesefs_dirmeta
+// object and rewrites it in background with --dir-meta-migrate if it's old
+func (fs *Goofys) noteDirMetaVersion(cloud StorageBackend, key string, version int) {
+	m := &fs.metaMigrations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.loaded == nil {
+		m.loaded = make(map[int]int64)
+	}
+	m.loaded[version]++
+	if version > dirMetaVersion {
+		m.newer++
+		return
+	}
+	if version == dirMetaVersion {
+		return
+	}
+	m.upgraded++
+	if !fs.flags.DirMetaMigrate || fs.flags.MetaReadOnly || m.pending[key] {
+		return
+	}
+	if m.pending == nil {
+		m.pending = make(map[string]bool)
+	}
+	m.pending[key] = true
+	go func() {
+		// Without changes, only the upgrade is stored
+		err := updateDirMeta(cloud, key)
+		m.mu.Lock()
+		delete(m.pending, key)
+		if err != nil {
+			m.failed++
+		} else {
+			m.rewritten++
+		}
+		m.mu.Unlock()
+		if err != nil {
+			log.Warnf("Failed to rewrite %v from version %v: %v", key, version, err)
+			return
+		}
+		log.Infof("Rewrote %v from version %v to %v", key, version, dirMetaVersion)
+		fs.recordChange("put", key, "", "", 0)
+	}()
+}
+
+func (fs *Goofys) MetaMigrationStatus() MetaMigrationStatus {
+	m := &fs.metaMigrations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := MetaMigrationStatus{
+		Version:    dirMetaVersion,
+		Migrations: []string{},
+		Loaded:     make(map[int]int64, len(m.loaded)),
+		Upgraded:   m.upgraded,
+		Newer:      m.newer,
+		Rewritten:  m.rewritten,
+		Failed:     m.failed,
+		Pending:    len(m.pending),
+	}
+	for _, mig := range dirMetaMigrations {
+		status.Migrations = append(status.Migrations, fmt.Sprintf("%v -> %v: %v", mig.From, mig.From+1, mig.Name))
+	}
+	for version, n := range m.loaded {
+		status.Loaded[version] = n
+	}
+	return status
+}
+
+func (fs *Goofys) MetaMigrationsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fs.MetaMigrationStatus())
+	})
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// With --dir-meta-specials, special files created with mknod (named pipes,
+// sockets and devices) have no objects. They are only stored as entries of
+// the nodes section of .geesefs_meta in their directory, with their mode,
+// owner, device number and modification time. Opening such files is handled
+// by the kernel, so named pipes are backed by local kernel pipes and only
+// connect processes on the same host.
+//
+// Changes are stored synchronously. Other mounts show the nodes when they
+// list the directory and load its .geesefs_meta.
+
+// nodeMetaEntry is a special file which is only stored in .geesefs_meta
+type nodeMetaEntry struct {
+	Mode  uint32 `json:"mode"`
+	Uid   uint32 `json:"uid"`
+	Gid   uint32 `json:"gid"`
+	Rdev  uint32 `json:"rdev,omitempty"`
+	Mtime int64  `json:"mtime"`
+	Btime int64  `json:"btime,omitempty"`
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) nodeMeta() nodeMetaEntry {
+	entry := nodeMetaEntry{
+		Mode:  fuseops.ConvertGoMode(inode.Attributes.Mode),
+		Uid:   inode.Attributes.Uid,
+		Gid:   inode.Attributes.Gid,
+		Rdev:  inode.Attributes.Rdev,
+		Mtime: inode.Attributes.Mtime.Unix(),
+	}
+	if !inode.Attributes.Btime.IsZero() {
+		entry.Btime = inode.Attributes.Btime.Unix()
+	}
+	return entry
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) setNodeMeta(entry nodeMetaEntry) {
+	inode.Attributes.Mode = fuseops.ConvertFileMode(entry.Mode)
+	inode.Attributes.Uid = entry.Uid
+	inode.Attributes.Gid = entry.Gid
+	inode.Attributes.Rdev = entry.Rdev
+	inode.Attributes.Mtime = time.Unix(entry.Mtime, 0)
+	inode.Attributes.Ctime = inode.Attributes.Mtime
+	if entry.Btime != 0 {
+		inode.Attributes.Btime = time.Unix(entry.Btime, 0)
+	}
+}
+
+// storeNode sets or, if entry is nil, removes a node in the .geesefs_meta
+// of parent
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) storeNode(name string, entry *nodeMetaEntry) error {
+	if parent.fs.flags.MetaReadOnly {
+		return syscall.EROFS
+	}
+	cloud, key := parent.cloud()
+	key = appendChildName(key, dirMetaName)
+	merge := setDirMetaEntry(metaNodes, name, nil)
+	if entry != nil {
+		merge = setDirMetaEntry(metaNodes, name, *entry)
+	}
+	err := updateDirMeta(cloud, key, merge)
+	if err != nil {
+		return err
+	}
+	parent.fs.recordChange("put", key, "", "", 0)
+	parent.noteNode(name, entry)
+	return nil
+}
+
+// noteNode sets or removes a node in the loaded .geesefs_meta of parent
+// after it's stored
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) noteNode(name string, entry *nodeMetaEntry) {
+	meta := parent.dir.childMeta
+	if meta == nil {
+		meta = &dirMetaSections{}
+		parent.dir.childMeta = meta
+	}
+	if entry == nil {
+		delete(meta.Nodes, name)
+	} else {
+		if meta.Nodes == nil {
+			meta.Nodes = make(map[string]nodeMetaEntry)
+		}
+		meta.Nodes[name] = *entry
+	}
+}
+
+// MkNodeMeta creates a special file which is only stored in .geesefs_meta
+// LOCKS_EXCLUDED(parent.mu)
+func (parent *Inode) MkNodeMeta(name string, mode os.FileMode, rdev, uid, gid uint32) (*Inode, error) {
+	parent.logFuse("MkNodeMeta", name, mode)
+
+	if isInvalidChildName(name) || parent.fs.isDirMeta(name) {
+		return nil, syscall.EINVAL
+	}
+	if parent.readOnlyView() {
+		return nil, syscall.EROFS
+	}
+
+	fs := parent.fs
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+
+	if parent.findChildUnlocked(name) != nil {
+		return nil, syscall.EEXIST
+	}
+
+	now := clock.Now()
+	inode := NewInode(fs, parent, name)
+	inode.Attributes = InodeAttributes{
+		Ctime: now,
+		Mtime: now,
+		Btime: now,
+		Uid:   uid,
+		Gid:   gid,
+		Mode:  mode & (os.ModeType | os.ModePerm),
+		Rdev:  rdev,
+	}
+	entry := inode.nodeMeta()
+	err := parent.storeNode(name, &entry)
+	if err != nil {
+		return nil, err
+	}
+	inode.metaNode = true
+	inode.userMetadata = make(map[string][]byte)
+	inode.SetAttrTime(now)
+	// one ref is for lookup
+	inode.Ref()
+	fs.insertInode(parent, inode)
+	parent.touch()
+	return inode, nil
+}
+
+// setNodeAttributes changes the mode, owner or modification time of a node
+// LOCKS_EXCLUDED(inode.Parent.mu)
+// LOCKS_EXCLUDED(inode.mu)
+func (inode *Inode) setNodeAttributes(mode *os.FileMode, mtime *time.Time, uid *uint32, gid *uint32) error {
+	parent := inode.Parent
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	if inode.CacheState == ST_DEAD {
+		return syscall.ENOENT
+	}
+	attrs := inode.Attributes
+	if mode != nil {
+		inode.Attributes.Mode = inode.Attributes.Mode&os.ModeType | *mode&os.ModePerm
+	}
+	if mtime != nil {
+		inode.Attributes.Mtime = *mtime
+	}
+	if uid != nil {
+		inode.Attributes.Uid = *uid
+	}
+	if gid != nil {
+		inode.Attributes.Gid = *gid
+	}
+	if inode.Attributes == attrs {
+		return nil
+	}
+	entry := inode.nodeMeta()
+	err := parent.storeNode(inode.Name, &entry)
+	if err != nil {
+		inode.Attributes = attrs
+		return err
+	}
+	inode.Attributes.Ctime = clock.Now()
+	inode.SetAttrTime(clock.Now())
+	return nil
+}
+
+// unlinkNode removes a node
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) unlinkNode(inode *Inode) error {
+	err := parent.storeNode(inode.Name, nil)
+	if err != nil {
+		return err
+	}
+	inode.mu.Lock()
+	inode.resetCache()
+	inode.SetCacheState(ST_DEAD)
+	if inode.fs.inodeMap != nil {
+		inode.fs.inodeMap.Delete(inode.mapPath())
+	}
+	parent.removeChildUnlocked(inode)
+	inode.mu.Unlock()
+	parent.touch()
+	return nil
+}
+
+// renameNode moves a node, replacing the target
+// LOCKS_REQUIRED(parent.mu)
+// LOCKS_REQUIRED(newParent.mu)
+// LOCKS_REQUIRED(inode.mu)
+func (parent *Inode) renameNode(inode *Inode, newParent *Inode, to string, toInode *Inode) error {
+	if parent.fs.flags.MetaReadOnly {
+		return syscall.EROFS
+	}
+	cloud, fromKey := parent.cloud()
+	_, toKey := newParent.cloud()
+	txn := NewMetaTxn(cloud)
+	txn.Set(appendChildName(toKey, dirMetaName), metaNodes, to, inode.nodeMeta())
+	txn.Set(appendChildName(fromKey, dirMetaName), metaNodes, inode.Name, nil)
+	err := txn.Commit()
+	if err != nil {
+		return err
+	}
+	parent.fs.recordChange("put", appendChildName(fromKey, dirMetaName), "", "", 0)
+	if newParent != parent {
+		parent.fs.recordChange("put", appendChildName(toKey, dirMetaName), "", "", 0)
+	}
+	if toInode != nil {
+		toInode.mu.Lock()
+		if toInode.metaNode {
+			newParent.removeChildUnlocked(toInode)
+			toInode.resetCache()
+			toInode.SetCacheState(ST_DEAD)
+		} else {
+			toInode.doUnlink()
+		}
+		toInode.mu.Unlock()
+	}
+	moveNodeInCache(inode, newParent, to)
+	return nil
+}
+
+// stageNodeMoves stages the moves of all nodes under dir, which is renamed
+// to the directory key, in a MetaTxn. All nodes are added to their new
+// directories before they are removed from the old ones
+// LOCKS_REQUIRED(dir.mu)
+func (dir *Inode) stageNodeMoves(txn *MetaTxn, key string) {
+	type nodeMove struct {
+		from, to, name string
+		entry          nodeMetaEntry
+	}
+	var moves []nodeMove
+	var walk func(dir *Inode, key string)
+	walk = func(dir *Inode, key string) {
+		_, fromKey := dir.cloud()
+		for _, child := range dir.dir.Children {
+			if child.metaNode {
+				child.mu.Lock()
+				moves = append(moves, nodeMove{
+					from:  appendChildName(fromKey, dirMetaName),
+					to:    appendChildName(key, dirMetaName),
+					name:  child.Name,
+					entry: child.nodeMeta(),
+				})
+				child.mu.Unlock()
+			} else if child.isDir() {
+				child.mu.Lock()
+				walk(child, appendChildName(key, child.Name))
+				child.mu.Unlock()
+			}
+		}
+	}
+	walk(dir, key)
+	for _, m := range moves {
+		txn.Set(m.to, metaNodes, m.name, m.entry)
+	}
+	for _, m := range moves {
+		txn.Set(m.from, metaNodes, m.name, nil)
+	}
+}
+
+// moveNodeInCache moves a node which is already moved in .geesefs_meta
+// LOCKS_REQUIRED(inode.Parent.mu)
+// LOCKS_REQUIRED(newParent.mu)
+// LOCKS_REQUIRED(inode.mu)
+func moveNodeInCache(inode *Inode, newParent *Inode, to string) {
+	parent := inode.Parent
+	entry := inode.nodeMeta()
+	parent.noteNode(inode.Name, nil)
+	newParent.noteNode(to, &entry)
+	if inode.fs.inodeMap != nil {
+		inode.fs.inodeMap.Rename(inode.mapPath(), newParent.getChildName(to))
+	}
+	inode.Ref()
+	parent.removeChildUnlocked(inode)
+	inode.Name = to
+	inode.Parent = newParent
+	newParent.insertChildUnlocked(inode)
+	inode.DeRef(1)
+	inode.SetAttrTime(clock.Now())
+}
+
+// applyNodes adds, changes and removes nodes of parent as in its loaded
+// .geesefs_meta. Nodes changed after it was loaded are kept as is
+// LOCKS_REQUIRED(parent.mu)
+func (parent *Inode) applyNodes() {
+	fs := parent.fs
+	meta := parent.dir.childMeta
+	if !fs.flags.DirMetaSpecials || meta == nil {
+		return
+	}
+	loaded := parent.dir.childMetaTime
+	for name, entry := range meta.Nodes {
+		if isInvalidChildName(name) || fs.isDirMeta(name) {
+			continue
+		}
+		child := parent.findChildUnlocked(name)
+		if child == nil {
+			// don't revive deleted items
+			if _, deleted := parent.dir.DeletedChildren[name]; deleted {
+				continue
+			}
+			child = NewInode(fs, parent, name)
+			child.metaNode = true
+			child.userMetadata = make(map[string][]byte)
+			child.setNodeMeta(entry)
+			child.SetAttrTime(loaded)
+			fs.insertInode(parent, child)
+		} else if child.metaNode {
+			child.mu.Lock()
+			if child.AttrTime.Before(loaded) {
+				child.setNodeMeta(entry)
+				child.SetAttrTime(loaded)
+			}
+			child.mu.Unlock()
+		}
+	}
+	for i := 0; i < len(parent.dir.Children); i++ {
+		child := parent.dir.Children[i]
+		if !child.metaNode || !child.AttrTime.Before(loaded) {
+			continue
+		}
+		if _, ok := meta.Nodes[child.Name]; ok {
+			continue
+		}
+		child.mu.Lock()
+		child.resetCache()
+		child.SetCacheState(ST_DEAD)
+		parent.removeChildUnlocked(child)
+		child.mu.Unlock()
+		i--
+	}
+}
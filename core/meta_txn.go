@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MetaTxn changes entries of several .geesefs_meta objects, for example of
+// the source and the destination directory of a rename. S3 has no
+// transactions across objects, so a MetaTxn is committed object by object:
+//
+//  1. Objects are updated in the order in which their first change was
+//     staged, all changes of one object at once with updateDirMeta, so they
+//     are merged with concurrent changes of other entries. Callers stage
+//     additions before removals, so that an interrupted commit leaves an
+//     entry in both places rather than in neither.
+//  2. If an object can't be updated, the objects already updated are
+//     compensated in reverse order: every entry is restored as it was before
+//     the commit, unless it was changed again by someone else meanwhile.
+//     Compensation is best effort, its failures are only logged.
+//
+// Changes of a single object don't need a MetaTxn: updateDirMeta already
+// applies them atomically.
+type MetaTxn struct {
+	cloud   StorageBackend
+	objects []*metaTxnObject
+	err     error
+}
+
+type metaTxnObject struct {
+	key     string
+	changes []*metaTxnChange
+}
+
+// metaTxnChange sets or, if entry is nil, removes the entry of child name in a
+// section. prev is the entry it replaced
+type metaTxnChange struct {
+	section string
+	name    string
+	entry   json.RawMessage
+	prev    json.RawMessage
+}
+
+func NewMetaTxn(cloud StorageBackend) *MetaTxn {
+	return &MetaTxn{cloud: cloud}
+}
+
+// Set stages a change of the .geesefs_meta object key which sets or, if
+// entry is nil, removes the entry of child name in a section
+func (t *MetaTxn) Set(key, section, name string, entry interface{}) {
+	change := &metaTxnChange{section: section, name: name}
+	if entry != nil {
+		var err error
+		change.entry, err = json.Marshal(entry)
+		if err != nil && t.err == nil {
+			t.err = err
+		}
+	}
+	for _, obj := range t.objects {
+		if obj.key == key {
+			obj.changes = append(obj.changes, change)
+			return
+		}
+	}
+	t.objects = append(t.objects, &metaTxnObject{key: key, changes: []*metaTxnChange{change}})
+}
+
+// Commit applies the staged changes, see MetaTxn
+func (t *MetaTxn) Commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	for i, obj := range t.objects {
+		err := updateDirMeta(t.cloud, obj.key, obj.apply)
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				done := t.objects[j]
+				undoErr := updateDirMeta(t.cloud, done.key, done.undo)
+				if undoErr != nil {
+					log.Warnf("Failed to restore %v after a failed update of %v: %v", done.key, obj.key, undoErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// apply is the merge of all changes of the object. It may run several times
+// if the object is changed concurrently, the last run remembers the previous
+// entries
+func (m *metaTxnObject) apply(obj dirMetaObject) (bool, error) {
+	changed := false
+	for _, c := range m.changes {
+		prev, ch, err := obj.setEntry(c.section, c.name, c.entry)
+		if err != nil {
+			return false, err
+		}
+		c.prev = prev
+		changed = changed || ch
+	}
+	return changed, nil
+}
+
+// undo restores the previous entries which weren't changed since apply
+func (m *metaTxnObject) undo(obj dirMetaObject) (bool, error) {
+	changed := false
+	for i := len(m.changes) - 1; i >= 0; i-- {
+		c := m.changes[i]
+		var cur json.RawMessage
+		if raw, ok := obj[c.section]; ok {
+			var entries map[string]json.RawMessage
+			json.Unmarshal(raw, &entries)
+			cur = entries[c.name]
+		}
+		if !bytes.Equal(cur, c.entry) {
+			continue
+		}
+		_, ch, err := obj.setEntry(c.section, c.name, c.prev)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || ch
+	}
+	return changed, nil
+}
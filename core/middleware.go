@@ -0,0 +1,242 @@
+package core
+
+import (
+	"sync"
+)
+
+// Middlewares wrap calls of storage backends, so that programs embedding
+// geesefs can log, change, delay or fail them without changing backends.
+// They see backend calls, not HTTP requests: S3 requests are reachable
+// through the handlers of Delegate().(*S3Backend).S3, for example to add
+// headers or change signing.
+
+// BackendCall is a StorageBackend method call passed to middlewares
+type BackendCall struct {
+	// Op is the name of the method, for example "PutBlob"
+	Op string
+	// Bucket is the bucket of the backend, as returned by Bucket()
+	Bucket string
+	// Key is the object key or the listed prefix, "" if the call has none
+	Key string
+	// Input is the parameter of the method, for example *PutBlobInput.
+	// Middlewares may change it or replace it with one of the same type.
+	// Init calls have an opaque input and Key is the key to check
+	Input interface{}
+}
+
+// BackendHandler performs the call and returns the output of the method,
+// for example *PutBlobOutput
+type BackendHandler func(call *BackendCall) (interface{}, error)
+
+// Middleware handles a call with next or instead of it. Without an error
+// it must return an output of the type the method returns
+type Middleware func(call *BackendCall, next BackendHandler) (interface{}, error)
+
+var middlewaresMu sync.Mutex
+var middlewares []Middleware
+
+// UseMiddleware adds middlewares to backends created by NewBackend after
+// the call, the first added middleware is the outermost one
+func UseMiddleware(m ...Middleware) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	middlewares = append(middlewares, m...)
+}
+
+// WithMiddleware wraps cloud with middlewares, the first one is outermost
+func WithMiddleware(cloud StorageBackend, m ...Middleware) StorageBackend {
+	if len(m) == 0 {
+		return cloud
+	}
+	b := &middlewareBackend{StorageBackend: cloud}
+	b.handler = b.direct
+	for i := len(m) - 1; i >= 0; i-- {
+		next, mw := b.handler, m[i]
+		b.handler = func(call *BackendCall) (interface{}, error) {
+			return mw(call, next)
+		}
+	}
+	return b
+}
+
+func withMiddlewares(cloud StorageBackend) StorageBackend {
+	middlewaresMu.Lock()
+	m := append([]Middleware(nil), middlewares...)
+	middlewaresMu.Unlock()
+	return WithMiddleware(cloud, m...)
+}
+
+type middlewareBackend struct {
+	StorageBackend
+	handler BackendHandler
+}
+
+func (b *middlewareBackend) call(op, key string, input interface{}) (interface{}, error) {
+	return b.handler(&BackendCall{
+		Op:     op,
+		Bucket: b.StorageBackend.Bucket(),
+		Key:    key,
+		Input:  input,
+	})
+}
+
+// direct performs the call with the wrapped backend
+func (b *middlewareBackend) direct(call *BackendCall) (interface{}, error) {
+	cloud := b.StorageBackend
+	switch in := call.Input.(type) {
+	case *initInput:
+		return nil, cloud.Init(in.key)
+	case *HeadBlobInput:
+		return cloud.HeadBlob(in)
+	case *ListBlobsInput:
+		return cloud.ListBlobs(in)
+	case *DeleteBlobInput:
+		return cloud.DeleteBlob(in)
+	case *DeleteBlobsInput:
+		return cloud.DeleteBlobs(in)
+	case *RenameBlobInput:
+		return cloud.RenameBlob(in)
+	case *CopyBlobInput:
+		return cloud.CopyBlob(in)
+	case *GetBlobInput:
+		return cloud.GetBlob(in)
+	case *PutBlobInput:
+		return cloud.PutBlob(in)
+	case *PatchBlobInput:
+		return cloud.PatchBlob(in)
+	case *MultipartBlobBeginInput:
+		return cloud.MultipartBlobBegin(in)
+	case *MultipartBlobAddInput:
+		return cloud.MultipartBlobAdd(in)
+	case *MultipartBlobCopyInput:
+		return cloud.MultipartBlobCopy(in)
+	case *MultipartBlobCommitInput:
+		if call.Op == "MultipartBlobAbort" {
+			return cloud.MultipartBlobAbort(in)
+		}
+		return cloud.MultipartBlobCommit(in)
+	case *MultipartExpireInput:
+		return cloud.MultipartExpire(in)
+	case *RemoveBucketInput:
+		return cloud.RemoveBucket(in)
+	case *MakeBucketInput:
+		return cloud.MakeBucket(in)
+	}
+	panic("Unknown backend call " + call.Op)
+}
+
+// initInput is the input of Init calls, which only have a key
+type initInput struct {
+	key string
+}
+
+func (b *middlewareBackend) Init(key string) error {
+	_, err := b.call("Init", key, &initInput{key})
+	return err
+}
+
+func (b *middlewareBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	res, err := b.call("HeadBlob", param.Key, param)
+	out, _ := res.(*HeadBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	res, err := b.call("ListBlobs", NilStr(param.Prefix), param)
+	out, _ := res.(*ListBlobsOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	res, err := b.call("DeleteBlob", param.Key, param)
+	out, _ := res.(*DeleteBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	key := ""
+	if len(param.Items) > 0 {
+		key = param.Items[0]
+	}
+	res, err := b.call("DeleteBlobs", key, param)
+	out, _ := res.(*DeleteBlobsOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	res, err := b.call("RenameBlob", param.Destination, param)
+	out, _ := res.(*RenameBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	res, err := b.call("CopyBlob", param.Destination, param)
+	out, _ := res.(*CopyBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	res, err := b.call("GetBlob", param.Key, param)
+	out, _ := res.(*GetBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	res, err := b.call("PutBlob", param.Key, param)
+	out, _ := res.(*PutBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	res, err := b.call("PatchBlob", param.Key, param)
+	out, _ := res.(*PatchBlobOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	res, err := b.call("MultipartBlobBegin", param.Key, param)
+	out, _ := res.(*MultipartBlobCommitInput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	res, err := b.call("MultipartBlobAdd", NilStr(param.Commit.Key), param)
+	out, _ := res.(*MultipartBlobAddOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	res, err := b.call("MultipartBlobCopy", NilStr(param.Commit.Key), param)
+	out, _ := res.(*MultipartBlobCopyOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	res, err := b.call("MultipartBlobAbort", NilStr(param.Key), param)
+	out, _ := res.(*MultipartBlobAbortOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	res, err := b.call("MultipartBlobCommit", NilStr(param.Key), param)
+	out, _ := res.(*MultipartBlobCommitOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	res, err := b.call("MultipartExpire", "", param)
+	out, _ := res.(*MultipartExpireOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	res, err := b.call("RemoveBucket", "", param)
+	out, _ := res.(*RemoveBucketOutput)
+	return out, err
+}
+
+func (b *middlewareBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	res, err := b.call("MakeBucket", "", param)
+	out, _ := res.(*MakeBucketOutput)
+	return out, err
+}
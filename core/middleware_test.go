@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type MiddlewareTest struct{}
+
+var _ = Suite(&MiddlewareTest{})
+
+func (s *MiddlewareTest) TestMiddlewareNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	conn := NewSimConn(store)
+
+	var mu sync.Mutex
+	var audit []string
+	cloud := WithMiddleware(conn,
+		func(call *BackendCall, next BackendHandler) (interface{}, error) {
+			out, err := next(call)
+			if call.Op == "PutBlob" {
+				mu.Lock()
+				audit = append(audit, call.Op+" "+call.Key)
+				mu.Unlock()
+			}
+			return out, err
+		},
+		func(call *BackendCall, next BackendHandler) (interface{}, error) {
+			if put, ok := call.Input.(*PutBlobInput); ok {
+				if put.Key == "denied" {
+					return nil, SimHTTPError(403, "AccessDenied")
+				}
+				put.Metadata = map[string]*string{"audited": PString("yes")}
+			}
+			return next(call)
+		},
+	)
+	fs, err := newGoofys(context.Background(), "sim", cfg.DefaultFlags(), func(bucket string, flags *cfg.FlagStorage) (StorageBackend, error) {
+		return cloud, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	m := &SimMount{fs: fs, Conn: conn}
+
+	t.Assert(m.WriteAndSync("a", []byte("1")), IsNil)
+	t.Assert(m.WriteAndSync("denied", []byte("2")), NotNil)
+	t.Assert(conn.Calls("PutBlob"), Equals, 1)
+	t.Assert(store.Keys(), DeepEquals, []string{"a"})
+	mu.Lock()
+	t.Assert(audit[:2], DeepEquals, []string{"PutBlob a", "PutBlob denied"})
+	mu.Unlock()
+	head, err := conn.HeadBlob(&HeadBlobInput{Key: "a"})
+	t.Assert(err, IsNil)
+	t.Assert(NilStr(head.Metadata["audited"]), Equals, "yes")
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// --read-replica reads object data from replicas of the bucket, for example
+// ones in nearer regions kept up to date with S3 Replication Time Control.
+// Everything else, including listings and HEADs, goes to the primary bucket.
+//
+// A replica may lag behind, so reads are only sent to it with the ETag the
+// object has in the primary bucket in If-Match. ETags are learned from
+// listings, HEADs and writes through this backend. Objects with unknown ETags
+// and reads the replica fails, for example because it doesn't have the
+// object or has another version of it, go to the primary bucket.
+
+// mirrorETagLimit bounds remembered ETags, they're forgotten all at once
+const mirrorETagLimit = 100000
+
+type mirrorBackend struct {
+	StorageBackend
+	replicas []StorageBackend
+
+	mu sync.Mutex
+	// ETags of objects in the primary bucket
+	etags map[string]string
+}
+
+func newMirrorBackend(cloud StorageBackend, prefix string, flags *cfg.FlagStorage,
+	newBackend func(string, *cfg.FlagStorage) (StorageBackend, error)) (*mirrorBackend, error) {
+	m := &mirrorBackend{
+		StorageBackend: cloud,
+		etags:          make(map[string]string),
+	}
+	for _, r := range flags.ReadReplicas {
+		replicaFlags := *flags
+		if r.Endpoint != "" {
+			replicaFlags.Endpoint = r.Endpoint
+		}
+		if s3, ok := flags.Backend.(*cfg.S3Config); ok {
+			config := *s3
+			if r.Region != "" {
+				config.Region = r.Region
+				config.RegionSet = true
+			}
+			replicaFlags.Backend = &config
+		}
+		replica, err := newBackend(r.Bucket, &replicaFlags)
+		if err != nil {
+			return nil, err
+		}
+		err = replica.Init(prefix + RandStringBytesMaskImprSrc(32))
+		if err != nil {
+			return nil, err
+		}
+		m.replicas = append(m.replicas, replica)
+	}
+	return m, nil
+}
+
+func (m *mirrorBackend) remember(key string, etag *string) {
+	m.mu.Lock()
+	if etag == nil {
+		delete(m.etags, key)
+	} else {
+		if len(m.etags) >= mirrorETagLimit {
+			m.etags = make(map[string]string)
+		}
+		m.etags[key] = *etag
+	}
+	m.mu.Unlock()
+}
+
+func (m *mirrorBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	resp, err := m.StorageBackend.HeadBlob(param)
+	if err == nil {
+		m.remember(param.Key, resp.ETag)
+	} else if mapAwsError(err) == syscall.ENOENT {
+		m.remember(param.Key, nil)
+	}
+	return resp, err
+}
+
+func (m *mirrorBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	resp, err := m.StorageBackend.ListBlobs(param)
+	if err == nil {
+		for _, item := range resp.Items {
+			m.remember(*item.Key, item.ETag)
+		}
+	}
+	return resp, err
+}
+
+func (m *mirrorBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	m.remember(param.Key, nil)
+	return m.StorageBackend.DeleteBlob(param)
+}
+
+func (m *mirrorBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	for _, key := range param.Items {
+		m.remember(key, nil)
+	}
+	return m.StorageBackend.DeleteBlobs(param)
+}
+
+func (m *mirrorBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	m.remember(param.Source, nil)
+	m.remember(param.Destination, nil)
+	return m.StorageBackend.RenameBlob(param)
+}
+
+func (m *mirrorBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	m.remember(param.Destination, nil)
+	return m.StorageBackend.CopyBlob(param)
+}
+
+func (m *mirrorBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	m.remember(param.Key, nil)
+	resp, err := m.StorageBackend.PutBlob(param)
+	if err == nil {
+		m.remember(param.Key, resp.ETag)
+	}
+	return resp, err
+}
+
+func (m *mirrorBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	m.remember(param.Key, nil)
+	return m.StorageBackend.PatchBlob(param)
+}
+
+func (m *mirrorBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	key := NilStr(param.Key)
+	m.remember(key, nil)
+	resp, err := m.StorageBackend.MultipartBlobCommit(param)
+	if err == nil {
+		m.remember(key, resp.ETag)
+	}
+	return resp, err
+}
+
+func (m *mirrorBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	etag := NilStr(param.IfMatch)
+	if etag == "" {
+		m.mu.Lock()
+		etag = m.etags[param.Key]
+		m.mu.Unlock()
+	}
+	if etag == "" || param.VersionId != nil {
+		// Versions of replicas have other IDs
+		return m.StorageBackend.GetBlob(param)
+	}
+	for i, replica := range m.replicas {
+		get := *param
+		get.IfMatch = PString(etag)
+		resp, err := replica.GetBlob(&get)
+		if err == nil && NilStr(resp.ETag) != etag {
+			// The replica ignored If-Match
+			resp.Body.Close()
+			err = syscall.EBUSY
+		}
+		if err == nil {
+			return resp, nil
+		}
+		log.Debugf("Reading %v from replica %v failed, trying the next one: %v", param.Key, i, err)
+	}
+	resp, err := m.StorageBackend.GetBlob(param)
+	if err == nil && param.IfMatch == nil && NilStr(resp.ETag) != etag {
+		m.remember(param.Key, resp.ETag)
+	}
+	return resp, err
+}
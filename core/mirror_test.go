@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"io"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type MirrorTest struct{}
+
+var _ = Suite(&MirrorTest{})
+
+func (s *MirrorTest) TestReadReplicaNoCloud(t *C) {
+	primary := NewSimConn(NewSimStore(NewSimClock()))
+	replica := NewSimConn(NewSimStore(NewSimClock()))
+	flags := cfg.DefaultFlags()
+	flags.ReadReplicas = []cfg.ReadReplica{{Bucket: "replica", Region: "eu-west-1"}}
+	var replicaFlags *cfg.FlagStorage
+	m, err := newMirrorBackend(primary, "", flags, func(bucket string, flags *cfg.FlagStorage) (StorageBackend, error) {
+		t.Assert(bucket, Equals, "replica")
+		replicaFlags = flags
+		return replica, nil
+	})
+	t.Assert(err, IsNil)
+	t.Assert(replicaFlags.Backend.(*cfg.S3Config).Region, Equals, "eu-west-1")
+
+	put := func(b StorageBackend, data string) {
+		_, err := b.PutBlob(&PutBlobInput{Key: "a", Body: bytes.NewReader([]byte(data)), Size: PUInt64(uint64(len(data)))})
+		t.Assert(err, IsNil)
+	}
+	read := func() string {
+		resp, err := m.GetBlob(&GetBlobInput{Key: "a"})
+		t.Assert(err, IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		t.Assert(err, IsNil)
+		return string(data)
+	}
+
+	// Not replicated yet
+	put(m, "1")
+	t.Assert(read(), Equals, "1")
+	t.Assert(primary.Calls("GetBlob"), Equals, 1)
+
+	// Replicated
+	put(replica, "1")
+	t.Assert(read(), Equals, "1")
+	t.Assert(primary.Calls("GetBlob"), Equals, 1)
+	t.Assert(replica.Calls("GetBlob"), Equals, 2)
+
+	// The replica lags behind
+	put(m, "2")
+	t.Assert(read(), Equals, "2")
+	t.Assert(primary.Calls("GetBlob"), Equals, 2)
+
+	// Changed without this backend, the ETag is refreshed from the primary
+	put(primary, "3")
+	put(replica, "3")
+	t.Assert(read(), Equals, "3")
+	t.Assert(primary.Calls("GetBlob"), Equals, 3)
+	_, err = m.HeadBlob(&HeadBlobInput{Key: "a"})
+	t.Assert(err, IsNil)
+	t.Assert(read(), Equals, "3")
+	t.Assert(primary.Calls("GetBlob"), Equals, 3)
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/jacobsa/fuse"
+)
+
+// Unprivileged users mount FUSE file systems with the setuid fusermount
+// helper, which is often missing in rootless containers and on HPC nodes.
+// geesefs tries the methods of --mount-methods in order and reports why each
+// of them failed:
+//
+//   - direct: mount(2) on /dev/fuse opened by geesefs itself. Works as root,
+//     with CAP_SYS_ADMIN, and in unprivileged user namespaces on Linux 4.18+,
+//     for example in rootless podman containers or under `unshare -rm`.
+//   - fusermount3, fusermount: the setuid helpers of libfuse 3 and 2.
+//   - macfuse, fuse-t: FUSE implementations of macOS.
+//
+// A FUSE descriptor opened by the caller may be passed as /dev/fd/N mount
+// point instead, then no method is needed.
+
+type fuseMountFunc func(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error)
+
+func fuseMount(methods []string, dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+	if strings.HasPrefix(dir, "/dev/fd/") {
+		return fuse.Mount(dir, server, mountCfg)
+	}
+	if len(methods) == 0 {
+		methods = defaultMountMethods
+	}
+	var failures []string
+	for _, name := range methods {
+		mount := mountMethods[name]
+		if mount == nil {
+			failures = append(failures, fmt.Sprintf("%v: not supported on %v", name, runtime.GOOS))
+			continue
+		}
+		mfs, err := mount(dir, server, mountCfg)
+		if err == nil {
+			log.Infof("Mounted %v using %v", dir, name)
+			return mfs, nil
+		}
+		log.Infof("Unable to mount %v using %v: %v", dir, name, err)
+		failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+	}
+	return nil, fmt.Errorf("all mount methods failed (--mount-methods):\n\t%v", strings.Join(failures, "\n\t"))
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"github.com/jacobsa/fuse"
+)
+
+var defaultMountMethods = []string{"macfuse", "fuse-t"}
+
+var mountMethods = map[string]fuseMountFunc{
+	"macfuse": func(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+		implCfg := *mountCfg
+		implCfg.FuseImpl = fuse.FUSEImplMacFUSE
+		return fuse.Mount(dir, server, &implCfg)
+	},
+	"fuse-t": func(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+		implCfg := *mountCfg
+		implCfg.FuseImpl = fuse.FUSEImplFuseT
+		return fuse.Mount(dir, server, &implCfg)
+	},
+}
+
+func fuseUnmount(dir string) error {
+	return fuse.Unmount(dir)
+}
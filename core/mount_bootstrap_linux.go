@@ -0,0 +1,223 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/jacobsa/fuse"
+	"golang.org/x/sys/unix"
+)
+
+var defaultMountMethods = []string{"direct", "fusermount3", "fusermount"}
+
+var mountMethods = map[string]fuseMountFunc{
+	"direct": mountDirect,
+	"fusermount3": func(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+		return mountFusermount("fusermount3", dir, server, mountCfg)
+	},
+	"fusermount": func(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+		return mountFusermount("fusermount", dir, server, mountCfg)
+	},
+}
+
+// Mount options which are mount(2) flags, the same as in fusermount
+var mountFlagOptions = map[string]struct {
+	flag  uintptr
+	clear bool
+}{
+	"rw":      {unix.MS_RDONLY, true},
+	"ro":      {unix.MS_RDONLY, false},
+	"suid":    {unix.MS_NOSUID, true},
+	"nosuid":  {unix.MS_NOSUID, false},
+	"dev":     {unix.MS_NODEV, true},
+	"nodev":   {unix.MS_NODEV, false},
+	"exec":    {unix.MS_NOEXEC, true},
+	"noexec":  {unix.MS_NOEXEC, false},
+	"async":   {unix.MS_SYNCHRONOUS, true},
+	"sync":    {unix.MS_SYNCHRONOUS, false},
+	"atime":   {unix.MS_NOATIME, true},
+	"noatime": {unix.MS_NOATIME, false},
+	"dirsync": {unix.MS_DIRSYNC, false},
+}
+
+// fuseMountOptions returns the options fuse.Mount passes to the kernel
+func fuseMountOptions(mountCfg *fuse.MountConfig) map[string]string {
+	opts := make(map[string]string)
+	if !mountCfg.DisableDefaultPermissions {
+		opts["default_permissions"] = ""
+	}
+	if mountCfg.FSName != "" {
+		opts["fsname"] = mountCfg.FSName
+	}
+	if mountCfg.Subtype != "" {
+		opts["subtype"] = mountCfg.Subtype
+	}
+	if mountCfg.ReadOnly {
+		opts["ro"] = ""
+	}
+	for k, v := range mountCfg.Options {
+		opts[k] = v
+	}
+	return opts
+}
+
+func joinMountOptions(opts map[string]string) string {
+	var parts []string
+	for k, v := range opts {
+		k = strings.ReplaceAll(strings.ReplaceAll(k, `\`, `\\`), ",", `\,`)
+		if v != "" {
+			k += "=" + v
+		}
+		parts = append(parts, k)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// directMountArgs returns the arguments of mount(2) for a FUSE descriptor
+func directMountArgs(mountCfg *fuse.MountConfig, fd, uid, gid int) (source, fstype string, flags uintptr, data string) {
+	opts := fuseMountOptions(mountCfg)
+	flags = unix.MS_NODEV | unix.MS_NOSUID
+	for k := range opts {
+		if f, ok := mountFlagOptions[k]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			delete(opts, k)
+		}
+	}
+	source = opts["fsname"]
+	fstype = "fuse"
+	if opts["subtype"] != "" {
+		fstype += "." + opts["subtype"]
+	}
+	delete(opts, "fsname")
+	delete(opts, "subtype")
+	opts["fd"] = fmt.Sprintf("%v", fd)
+	opts["rootmode"] = "40000"
+	if _, ok := opts["user_id"]; !ok {
+		opts["user_id"] = fmt.Sprintf("%v", uid)
+	}
+	if _, ok := opts["group_id"]; !ok {
+		opts["group_id"] = fmt.Sprintf("%v", gid)
+	}
+	return source, fstype, flags, joinMountOptions(opts)
+}
+
+// inUserNamespace reports if the process runs in a user namespace other
+// than the initial one
+func inUserNamespace() bool {
+	data, err := os.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	return strings.Join(strings.Fields(string(data)), " ") != "0 0 4294967295"
+}
+
+func mountDirect(dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil, errors.New("/dev/fuse does not exist, load the fuse kernel module" +
+			" or pass the device to the container (--device /dev/fuse)")
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open /dev/fuse: %v", err)
+	}
+	source, fstype, flags, data := directMountArgs(mountCfg, fd, os.Getuid(), os.Getgid())
+	err = unix.Mount(source, dir, fstype, flags, data)
+	if err != nil {
+		syscall.Close(fd)
+		if err == syscall.EPERM {
+			if inUserNamespace() {
+				return nil, fmt.Errorf("%v: the mount namespace isn't owned by our user namespace"+
+					" or the kernel doesn't support FUSE in user namespaces (Linux 4.18+)", err)
+			}
+			return nil, fmt.Errorf("%v: requires root or CAP_SYS_ADMIN, unprivileged users may run"+
+				" geesefs in their own user and mount namespace, e.g. with `unshare -rm`", err)
+		}
+		return nil, err
+	}
+	return mountFd(dir, fd, server, mountCfg)
+}
+
+func mountFusermount(name string, dir string, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not installed", name)
+	}
+	fd, err := runFusermount(path, dir, joinMountOptions(fuseMountOptions(mountCfg)))
+	if err != nil {
+		st, statErr := os.Stat(path)
+		if os.Geteuid() != 0 && statErr == nil && st.Mode()&os.ModeSetuid == 0 {
+			err = fmt.Errorf("%v (%v is not setuid root)", err, path)
+		}
+		return nil, err
+	}
+	return mountFd(dir, fd, server, mountCfg)
+}
+
+// runFusermount mounts dir with a fusermount helper and returns the FUSE
+// descriptor which it passes back over a socket
+func runFusermount(path, dir, options string) (int, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	defer syscall.Close(fds[1])
+	child := os.NewFile(uintptr(fds[0]), "fusermount-comm")
+	defer child.Close()
+	cmd := exec.Command(path, "-o", options, "--", dir)
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.ExtraFiles = []*os.File{child}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return -1, fmt.Errorf("%v: %v", err, msg)
+		}
+		return -1, err
+	}
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(fds[1], buf, oob, syscall.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("receiving FUSE descriptor from %v: %v", path, err)
+	}
+	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(scms) != 1 {
+		return -1, fmt.Errorf("%v didn't pass a FUSE descriptor", path)
+	}
+	rights, err := syscall.ParseUnixRights(&scms[0])
+	if err != nil || len(rights) != 1 {
+		return -1, fmt.Errorf("%v didn't pass a FUSE descriptor", path)
+	}
+	return rights[0], nil
+}
+
+// mountFd starts serving a FUSE descriptor already mounted on dir
+func mountFd(dir string, fd int, server fuse.Server, mountCfg *fuse.MountConfig) (*fuse.MountedFileSystem, error) {
+	mfs, err := fuse.Mount(fmt.Sprintf("/dev/fd/%v", fd), server, mountCfg)
+	if err != nil {
+		fuseUnmount(dir)
+		syscall.Close(fd)
+		return nil, err
+	}
+	return mfs, nil
+}
+
+// fuseUnmount unmounts dir with umount(2) if allowed, so that mounts made
+// without fusermount can also be unmounted without it
+func fuseUnmount(dir string) error {
+	if unix.Unmount(dir, 0) == nil {
+		return nil
+	}
+	return fuse.Unmount(dir)
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"github.com/jacobsa/fuse"
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+)
+
+type MountBootstrapTest struct{}
+
+var _ = Suite(&MountBootstrapTest{})
+
+func (s *MountBootstrapTest) TestDirectMountArgsNoCloud(t *C) {
+	source, fstype, flags, data := directMountArgs(&fuse.MountConfig{
+		FSName:  "bucket",
+		Subtype: "geesefs",
+		Options: map[string]string{"noatime": "", "allow_other": "", "user_id": "1000"},
+	}, 7, 500, 600)
+	t.Assert(source, Equals, "bucket")
+	t.Assert(fstype, Equals, "fuse.geesefs")
+	t.Assert(flags, Equals, uintptr(unix.MS_NODEV|unix.MS_NOSUID|unix.MS_NOATIME))
+	t.Assert(data, Equals, "allow_other,default_permissions,fd=7,group_id=600,rootmode=40000,user_id=1000")
+
+	_, err := fuseMount([]string{"unknown"}, t.MkDir(), nil, &fuse.MountConfig{})
+	t.Assert(err, ErrorMatches, "(?s)all mount methods failed.*unknown: not supported on linux")
+}
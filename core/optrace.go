@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// OpTracer assigns IDs to FUSE operations and allows to correlate backend
+// requests with them, so that S3 request IDs (x-amz-request-id, x-amz-id-2)
+// can be matched with the exact user-visible operation.
+//
+// The ID is passed to the backend explicitly. An operation sets it on its
+// inode and, for operations on a child name, on the child. Requests made
+// for the inode carry it in their input (OpID), the S3 backend puts it into
+// the context of the SDK request and LogRequest takes it from there.
+// Requests which an operation makes while it runs (lookups, listings, reads)
+// carry the ID of the last operation on the inode, flushes carry the ID of
+// the last operation which changed it. Requests which aren't made for an
+// inode, like the cleanup of temporary objects, are logged without an ID.
+type OpTracer struct {
+	mount string
+	next  uint64
+}
+
+// opTrace holds the IDs of the last operations on an inode
+type opTrace struct {
+	last   atomic.Pointer[string]
+	change atomic.Pointer[string]
+}
+
+// Operations which change inodes, flushes are traced to the last of them
+var changeOps = map[string]bool{
+	"CreateFile":         true,
+	"CreateLink":         true,
+	"CreateSymlink":      true,
+	"Fallocate":          true,
+	"MkDir":              true,
+	"MkNode":             true,
+	"RemoveXattr":        true,
+	"Rename":             true,
+	"RmDir":              true,
+	"SetInodeAttributes": true,
+	"SetXattr":           true,
+	"SyncFile":           true,
+	"Unlink":             true,
+	"WriteFile":          true,
+}
+
+type opIDKey struct{}
+
+func NewOpTracer() *OpTracer {
+	return &OpTracer{
+		mount: RandStringBytesMaskImprSrc(8),
+	}
+}
+
+// Begin generates a new operation ID and logs the operation with the path
+// (relative to the mount root)
+func (t *OpTracer) Begin(op string, path string) string {
+	id := fmt.Sprintf("%v-%v", t.mount, atomic.AddUint64(&t.next, 1))
+	fuseLog.Infof("op=%v %v %v", id, op, path)
+	return id
+}
+
+// traceOp sets the ID of an operation on the inode
+func (inode *Inode) traceOp(id string, change bool) {
+	inode.trace.last.Store(&id)
+	if change {
+		inode.trace.change.Store(&id)
+	}
+}
+
+// lastOp returns the ID of the last operation on the inode
+func (inode *Inode) lastOp() string {
+	if id := inode.trace.last.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// changeOp returns the ID of the last operation which changed the inode
+func (inode *Inode) changeOp() string {
+	if id := inode.trace.change.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// withOpID returns a context which carries the ID of an operation
+func withOpID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, opIDKey{}, id)
+}
+
+// opIDFromContext returns the ID of the operation carried by ctx
+func opIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(opIDKey{}).(string)
+	return id
+}
+
+// traceRequest passes the ID of the operation which made a request to
+// LogRequest
+func traceRequest(req *request.Request, opID string) {
+	if opID != "" {
+		req.SetContext(withOpID(req.Context(), opID))
+	}
+}
+
+func requestParam(params interface{}, name string) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.Elem().FieldByName(name)
+	if !f.IsValid() || f.Kind() != reflect.Ptr || f.IsNil() || f.Elem().Kind() != reflect.String {
+		return ""
+	}
+	return f.Elem().String()
+}
+
+// Complete handler for AWS SDK requests
+func (t *OpTracer) LogRequest(r *request.Request) {
+	key := requestParam(r.Params, "Key")
+	if key == "" {
+		key = requestParam(r.Params, "Prefix")
+	}
+	status, reqId, id2 := 0, "", ""
+	if r.HTTPResponse != nil {
+		status = r.HTTPResponse.StatusCode
+		reqId = r.HTTPResponse.Header.Get("x-amz-request-id")
+		id2 = r.HTTPResponse.Header.Get("x-amz-id-2")
+	}
+	s3Log.Infof("op=%v %v %v status=%v x-amz-request-id=%v x-amz-id-2=%v time=%v",
+		opIDFromContext(r.Context()), r.Operation.Name, key, status, reqId, id2, time.Since(r.Time))
+}
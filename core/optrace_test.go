@@ -0,0 +1,129 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type OpTraceTest struct{}
+
+var _ = Suite(&OpTraceTest{})
+
+// opRecorder records operation IDs of requests by operation and key
+type opRecorder struct {
+	*SimConn
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func (r *opRecorder) note(op, key, id string) {
+	r.mu.Lock()
+	r.ids[op+" "+key] = id
+	r.mu.Unlock()
+}
+
+func (r *opRecorder) get(op, key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ids[op+" "+key]
+}
+
+func (r *opRecorder) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	r.note("HeadBlob", param.Key, param.OpID)
+	return r.SimConn.HeadBlob(param)
+}
+
+func (r *opRecorder) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	r.note("GetBlob", param.Key, param.OpID)
+	return r.SimConn.GetBlob(param)
+}
+
+func (r *opRecorder) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	r.note("PutBlob", param.Key, param.OpID)
+	return r.SimConn.PutBlob(param)
+}
+
+func (s *OpTraceTest) TestOpTraceNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	store.Put("a", []byte("data"), nil)
+	rec := &opRecorder{SimConn: NewSimConn(store), ids: make(map[string]string)}
+	flags := cfg.DefaultFlags()
+	flags.TraceOps = true
+	goofys, err := newGoofys(context.Background(), "sim", flags, func(string, *cfg.FlagStorage) (StorageBackend, error) {
+		return rec, nil
+	})
+	t.Assert(err, IsNil)
+	defer goofys.Shutdown()
+	fs := NewGoofysFuse(goofys)
+	root := goofys.getInodeOrDie(fuseops.RootInodeID)
+
+	// Requests made by an operation carry its ID
+	lookup := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "a"}
+	t.Assert(fs.LookUpInode(nil, lookup), IsNil)
+	lookupID := root.lastOp()
+	t.Assert(lookupID, Not(Equals), "")
+	t.Assert(rec.get("HeadBlob", "a"), Equals, lookupID)
+	a := goofys.getInodeOrDie(lookup.Entry.Child)
+	t.Assert(a.lastOp(), Equals, lookupID)
+
+	// Flushes carry the ID of the last change, not of the last operation
+	create := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "b", Mode: 0644}
+	t.Assert(fs.CreateFile(nil, create), IsNil)
+	t.Assert(fs.WriteFile(nil, &fuseops.WriteFileOp{
+		Inode:  create.Entry.Child,
+		Handle: create.Handle,
+		Data:   []byte("hello"),
+	}), IsNil)
+	b := goofys.getInodeOrDie(create.Entry.Child)
+	writeID := b.changeOp()
+	t.Assert(writeID, Not(Equals), "")
+	t.Assert(writeID, Not(Equals), root.lastOp())
+	t.Assert(fs.GetInodeAttributes(nil, &fuseops.GetInodeAttributesOp{Inode: b.Id}), IsNil)
+	t.Assert(b.lastOp(), Not(Equals), writeID)
+	t.Assert(goofys.SyncTree(nil), IsNil)
+	t.Assert(rec.get("PutBlob", "b"), Equals, writeID)
+
+	// Operations on other files of the directory don't take over
+	t.Assert(fs.GetInodeAttributes(nil, &fuseops.GetInodeAttributesOp{Inode: a.Id}), IsNil)
+	t.Assert(a.lastOp(), Not(Equals), b.lastOp())
+}
+
+func (s *OpTraceTest) TestOpTraceRequestContextNoCloud(t *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1"`)
+	}))
+	defer srv.Close()
+	s3, err := NewS3("bucket", &cfg.FlagStorage{Endpoint: srv.URL}, &cfg.S3Config{
+		Region:    "us-east-1",
+		AccessKey: "key",
+		SecretKey: "secret",
+		NoDetect:  true,
+	})
+	t.Assert(err, IsNil)
+	var mu sync.Mutex
+	var ids []string
+	s3.S3.Handlers.Complete.PushBack(func(r *request.Request) {
+		mu.Lock()
+		ids = append(ids, r.Operation.Name+" "+opIDFromContext(r.Context()))
+		mu.Unlock()
+	})
+
+	_, err = s3.HeadBlob(&HeadBlobInput{Key: "a", OpID: "m-1"})
+	t.Assert(err, IsNil)
+	_, err = s3.PutBlob(&PutBlobInput{Key: "a", Body: strings.NewReader("x"), Size: PUInt64(1), OpID: "m-2"})
+	t.Assert(err, IsNil)
+	_, err = s3.DeleteBlob(&DeleteBlobInput{Key: "a"})
+	t.Assert(err, IsNil)
+	t.Assert(ids, DeepEquals, []string{"HeadObject m-1", "PutObject m-2", "DeleteObject "})
+}
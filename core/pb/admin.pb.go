@@ -0,0 +1,1372 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.12.4
+// source: core/pb/admin.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Symlink struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Symlink) Reset() {
+	*x = Symlink{}
+	mi := &file_core_pb_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Symlink) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Symlink) ProtoMessage() {}
+
+func (x *Symlink) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Symlink.ProtoReflect.Descriptor instead.
+func (*Symlink) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Symlink) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Symlink) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+type Xattr struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Xattr) Reset() {
+	*x = Xattr{}
+	mi := &file_core_pb_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Xattr) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Xattr) ProtoMessage() {}
+
+func (x *Xattr) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Xattr.ProtoReflect.Descriptor instead.
+func (*Xattr) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Xattr) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Xattr) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type ListSymlinksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Recursive     bool                   `protobuf:"varint,2,opt,name=recursive,proto3" json:"recursive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSymlinksRequest) Reset() {
+	*x = ListSymlinksRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSymlinksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSymlinksRequest) ProtoMessage() {}
+
+func (x *ListSymlinksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSymlinksRequest.ProtoReflect.Descriptor instead.
+func (*ListSymlinksRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListSymlinksRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ListSymlinksRequest) GetRecursive() bool {
+	if x != nil {
+		return x.Recursive
+	}
+	return false
+}
+
+type ListSymlinksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symlinks      []*Symlink             `protobuf:"bytes,1,rep,name=symlinks,proto3" json:"symlinks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSymlinksResponse) Reset() {
+	*x = ListSymlinksResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSymlinksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSymlinksResponse) ProtoMessage() {}
+
+func (x *ListSymlinksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSymlinksResponse.ProtoReflect.Descriptor instead.
+func (*ListSymlinksResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListSymlinksResponse) GetSymlinks() []*Symlink {
+	if x != nil {
+		return x.Symlinks
+	}
+	return nil
+}
+
+type GetSymlinkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSymlinkRequest) Reset() {
+	*x = GetSymlinkRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSymlinkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSymlinkRequest) ProtoMessage() {}
+
+func (x *GetSymlinkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSymlinkRequest.ProtoReflect.Descriptor instead.
+func (*GetSymlinkRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetSymlinkRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type GetSymlinkResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSymlinkResponse) Reset() {
+	*x = GetSymlinkResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSymlinkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSymlinkResponse) ProtoMessage() {}
+
+func (x *GetSymlinkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSymlinkResponse.ProtoReflect.Descriptor instead.
+func (*GetSymlinkResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetSymlinkResponse) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+type SetSymlinkRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Path   string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Target string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	// replace an existing file or symlink
+	Replace       bool `protobuf:"varint,3,opt,name=replace,proto3" json:"replace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetSymlinkRequest) Reset() {
+	*x = SetSymlinkRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetSymlinkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetSymlinkRequest) ProtoMessage() {}
+
+func (x *SetSymlinkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetSymlinkRequest.ProtoReflect.Descriptor instead.
+func (*SetSymlinkRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SetSymlinkRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetSymlinkRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *SetSymlinkRequest) GetReplace() bool {
+	if x != nil {
+		return x.Replace
+	}
+	return false
+}
+
+type SetSymlinkResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetSymlinkResponse) Reset() {
+	*x = SetSymlinkResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetSymlinkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetSymlinkResponse) ProtoMessage() {}
+
+func (x *SetSymlinkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetSymlinkResponse.ProtoReflect.Descriptor instead.
+func (*SetSymlinkResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{7}
+}
+
+type ListXattrsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListXattrsRequest) Reset() {
+	*x = ListXattrsRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListXattrsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListXattrsRequest) ProtoMessage() {}
+
+func (x *ListXattrsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListXattrsRequest.ProtoReflect.Descriptor instead.
+func (*ListXattrsRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListXattrsRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type ListXattrsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Xattrs        []*Xattr               `protobuf:"bytes,1,rep,name=xattrs,proto3" json:"xattrs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListXattrsResponse) Reset() {
+	*x = ListXattrsResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListXattrsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListXattrsResponse) ProtoMessage() {}
+
+func (x *ListXattrsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListXattrsResponse.ProtoReflect.Descriptor instead.
+func (*ListXattrsResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListXattrsResponse) GetXattrs() []*Xattr {
+	if x != nil {
+		return x.Xattrs
+	}
+	return nil
+}
+
+type GetXattrRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetXattrRequest) Reset() {
+	*x = GetXattrRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetXattrRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetXattrRequest) ProtoMessage() {}
+
+func (x *GetXattrRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetXattrRequest.ProtoReflect.Descriptor instead.
+func (*GetXattrRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetXattrRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *GetXattrRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type GetXattrResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetXattrResponse) Reset() {
+	*x = GetXattrResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetXattrResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetXattrResponse) ProtoMessage() {}
+
+func (x *GetXattrResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetXattrResponse.ProtoReflect.Descriptor instead.
+func (*GetXattrResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetXattrResponse) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type SetXattrRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Value         []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetXattrRequest) Reset() {
+	*x = SetXattrRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetXattrRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetXattrRequest) ProtoMessage() {}
+
+func (x *SetXattrRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetXattrRequest.ProtoReflect.Descriptor instead.
+func (*SetXattrRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SetXattrRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetXattrRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SetXattrRequest) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type SetXattrResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetXattrResponse) Reset() {
+	*x = SetXattrResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetXattrResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetXattrResponse) ProtoMessage() {}
+
+func (x *SetXattrResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetXattrResponse.ProtoReflect.Descriptor instead.
+func (*SetXattrResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{13}
+}
+
+type RemoveXattrRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveXattrRequest) Reset() {
+	*x = RemoveXattrRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveXattrRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveXattrRequest) ProtoMessage() {}
+
+func (x *RemoveXattrRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveXattrRequest.ProtoReflect.Descriptor instead.
+func (*RemoveXattrRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RemoveXattrRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RemoveXattrRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type RemoveXattrResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveXattrResponse) Reset() {
+	*x = RemoveXattrResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveXattrResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveXattrResponse) ProtoMessage() {}
+
+func (x *RemoveXattrResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveXattrResponse.ProtoReflect.Descriptor instead.
+func (*RemoveXattrResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{15}
+}
+
+type PinRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinRequest) Reset() {
+	*x = PinRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinRequest) ProtoMessage() {}
+
+func (x *PinRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinRequest.ProtoReflect.Descriptor instead.
+func (*PinRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *PinRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type PinResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bytes         uint64                 `protobuf:"varint,1,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinResponse) Reset() {
+	*x = PinResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinResponse) ProtoMessage() {}
+
+func (x *PinResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinResponse.ProtoReflect.Descriptor instead.
+func (*PinResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *PinResponse) GetBytes() uint64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+type UnpinRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnpinRequest) Reset() {
+	*x = UnpinRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnpinRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnpinRequest) ProtoMessage() {}
+
+func (x *UnpinRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnpinRequest.ProtoReflect.Descriptor instead.
+func (*UnpinRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *UnpinRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type UnpinResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnpinResponse) Reset() {
+	*x = UnpinResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnpinResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnpinResponse) ProtoMessage() {}
+
+func (x *UnpinResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnpinResponse.ProtoReflect.Descriptor instead.
+func (*UnpinResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{19}
+}
+
+type ListPinsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPinsRequest) Reset() {
+	*x = ListPinsRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPinsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPinsRequest) ProtoMessage() {}
+
+func (x *ListPinsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPinsRequest.ProtoReflect.Descriptor instead.
+func (*ListPinsRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{20}
+}
+
+type ListPinsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Paths         []string               `protobuf:"bytes,1,rep,name=paths,proto3" json:"paths,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPinsResponse) Reset() {
+	*x = ListPinsResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPinsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPinsResponse) ProtoMessage() {}
+
+func (x *ListPinsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPinsResponse.ProtoReflect.Descriptor instead.
+func (*ListPinsResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ListPinsResponse) GetPaths() []string {
+	if x != nil {
+		return x.Paths
+	}
+	return nil
+}
+
+type InvalidateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateRequest) Reset() {
+	*x = InvalidateRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateRequest) ProtoMessage() {}
+
+func (x *InvalidateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateRequest.ProtoReflect.Descriptor instead.
+func (*InvalidateRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *InvalidateRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type InvalidateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateResponse) Reset() {
+	*x = InvalidateResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateResponse) ProtoMessage() {}
+
+func (x *InvalidateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateResponse.ProtoReflect.Descriptor instead.
+func (*InvalidateResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{23}
+}
+
+type GetDirGenerationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDirGenerationRequest) Reset() {
+	*x = GetDirGenerationRequest{}
+	mi := &file_core_pb_admin_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDirGenerationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDirGenerationRequest) ProtoMessage() {}
+
+func (x *GetDirGenerationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDirGenerationRequest.ProtoReflect.Descriptor instead.
+func (*GetDirGenerationRequest) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *GetDirGenerationRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type GetDirGenerationResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// changes whenever an entry of the directory is added, removed or replaced
+	Generation    uint64 `protobuf:"varint,1,opt,name=generation,proto3" json:"generation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDirGenerationResponse) Reset() {
+	*x = GetDirGenerationResponse{}
+	mi := &file_core_pb_admin_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDirGenerationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDirGenerationResponse) ProtoMessage() {}
+
+func (x *GetDirGenerationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_pb_admin_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDirGenerationResponse.ProtoReflect.Descriptor instead.
+func (*GetDirGenerationResponse) Descriptor() ([]byte, []int) {
+	return file_core_pb_admin_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *GetDirGenerationResponse) GetGeneration() uint64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+var File_core_pb_admin_proto protoreflect.FileDescriptor
+
+const file_core_pb_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x13core/pb/admin.proto\"5\n" +
+	"\aSymlink\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\"1\n" +
+	"\x05Xattr\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\"G\n" +
+	"\x13ListSymlinksRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1c\n" +
+	"\trecursive\x18\x02 \x01(\bR\trecursive\"<\n" +
+	"\x14ListSymlinksResponse\x12$\n" +
+	"\bsymlinks\x18\x01 \x03(\v2\b.SymlinkR\bsymlinks\"'\n" +
+	"\x11GetSymlinkRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\",\n" +
+	"\x12GetSymlinkResponse\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\"Y\n" +
+	"\x11SetSymlinkRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12\x18\n" +
+	"\areplace\x18\x03 \x01(\bR\areplace\"\x14\n" +
+	"\x12SetSymlinkResponse\"'\n" +
+	"\x11ListXattrsRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"4\n" +
+	"\x12ListXattrsResponse\x12\x1e\n" +
+	"\x06xattrs\x18\x01 \x03(\v2\x06.XattrR\x06xattrs\"9\n" +
+	"\x0fGetXattrRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\"(\n" +
+	"\x10GetXattrResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\fR\x05value\"O\n" +
+	"\x0fSetXattrRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\"\x12\n" +
+	"\x10SetXattrResponse\"<\n" +
+	"\x12RemoveXattrRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\"\x15\n" +
+	"\x13RemoveXattrResponse\" \n" +
+	"\n" +
+	"PinRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"#\n" +
+	"\vPinResponse\x12\x14\n" +
+	"\x05bytes\x18\x01 \x01(\x04R\x05bytes\"\"\n" +
+	"\fUnpinRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"\x0f\n" +
+	"\rUnpinResponse\"\x11\n" +
+	"\x0fListPinsRequest\"(\n" +
+	"\x10ListPinsResponse\x12\x14\n" +
+	"\x05paths\x18\x01 \x03(\tR\x05paths\"'\n" +
+	"\x11InvalidateRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"\x14\n" +
+	"\x12InvalidateResponse\"-\n" +
+	"\x17GetDirGenerationRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\":\n" +
+	"\x18GetDirGenerationResponse\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x01 \x01(\x04R\n" +
+	"generation2\x80\x05\n" +
+	"\x05Admin\x12;\n" +
+	"\fListSymlinks\x12\x14.ListSymlinksRequest\x1a\x15.ListSymlinksResponse\x125\n" +
+	"\n" +
+	"GetSymlink\x12\x12.GetSymlinkRequest\x1a\x13.GetSymlinkResponse\x125\n" +
+	"\n" +
+	"SetSymlink\x12\x12.SetSymlinkRequest\x1a\x13.SetSymlinkResponse\x125\n" +
+	"\n" +
+	"ListXattrs\x12\x12.ListXattrsRequest\x1a\x13.ListXattrsResponse\x12/\n" +
+	"\bGetXattr\x12\x10.GetXattrRequest\x1a\x11.GetXattrResponse\x12/\n" +
+	"\bSetXattr\x12\x10.SetXattrRequest\x1a\x11.SetXattrResponse\x128\n" +
+	"\vRemoveXattr\x12\x13.RemoveXattrRequest\x1a\x14.RemoveXattrResponse\x12 \n" +
+	"\x03Pin\x12\v.PinRequest\x1a\f.PinResponse\x12&\n" +
+	"\x05Unpin\x12\r.UnpinRequest\x1a\x0e.UnpinResponse\x12/\n" +
+	"\bListPins\x12\x10.ListPinsRequest\x1a\x11.ListPinsResponse\x125\n" +
+	"\n" +
+	"Invalidate\x12\x12.InvalidateRequest\x1a\x13.InvalidateResponse\x12G\n" +
+	"\x10GetDirGeneration\x12\x18.GetDirGenerationRequest\x1a\x19.GetDirGenerationResponseB)Z'github.com/yandex-cloud/geesefs/core/pbb\x06proto3"
+
+var (
+	file_core_pb_admin_proto_rawDescOnce sync.Once
+	file_core_pb_admin_proto_rawDescData []byte
+)
+
+func file_core_pb_admin_proto_rawDescGZIP() []byte {
+	file_core_pb_admin_proto_rawDescOnce.Do(func() {
+		file_core_pb_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_core_pb_admin_proto_rawDesc), len(file_core_pb_admin_proto_rawDesc)))
+	})
+	return file_core_pb_admin_proto_rawDescData
+}
+
+var file_core_pb_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_core_pb_admin_proto_goTypes = []any{
+	(*Symlink)(nil),                  // 0: Symlink
+	(*Xattr)(nil),                    // 1: Xattr
+	(*ListSymlinksRequest)(nil),      // 2: ListSymlinksRequest
+	(*ListSymlinksResponse)(nil),     // 3: ListSymlinksResponse
+	(*GetSymlinkRequest)(nil),        // 4: GetSymlinkRequest
+	(*GetSymlinkResponse)(nil),       // 5: GetSymlinkResponse
+	(*SetSymlinkRequest)(nil),        // 6: SetSymlinkRequest
+	(*SetSymlinkResponse)(nil),       // 7: SetSymlinkResponse
+	(*ListXattrsRequest)(nil),        // 8: ListXattrsRequest
+	(*ListXattrsResponse)(nil),       // 9: ListXattrsResponse
+	(*GetXattrRequest)(nil),          // 10: GetXattrRequest
+	(*GetXattrResponse)(nil),         // 11: GetXattrResponse
+	(*SetXattrRequest)(nil),          // 12: SetXattrRequest
+	(*SetXattrResponse)(nil),         // 13: SetXattrResponse
+	(*RemoveXattrRequest)(nil),       // 14: RemoveXattrRequest
+	(*RemoveXattrResponse)(nil),      // 15: RemoveXattrResponse
+	(*PinRequest)(nil),               // 16: PinRequest
+	(*PinResponse)(nil),              // 17: PinResponse
+	(*UnpinRequest)(nil),             // 18: UnpinRequest
+	(*UnpinResponse)(nil),            // 19: UnpinResponse
+	(*ListPinsRequest)(nil),          // 20: ListPinsRequest
+	(*ListPinsResponse)(nil),         // 21: ListPinsResponse
+	(*InvalidateRequest)(nil),        // 22: InvalidateRequest
+	(*InvalidateResponse)(nil),       // 23: InvalidateResponse
+	(*GetDirGenerationRequest)(nil),  // 24: GetDirGenerationRequest
+	(*GetDirGenerationResponse)(nil), // 25: GetDirGenerationResponse
+}
+var file_core_pb_admin_proto_depIdxs = []int32{
+	0,  // 0: ListSymlinksResponse.symlinks:type_name -> Symlink
+	1,  // 1: ListXattrsResponse.xattrs:type_name -> Xattr
+	2,  // 2: Admin.ListSymlinks:input_type -> ListSymlinksRequest
+	4,  // 3: Admin.GetSymlink:input_type -> GetSymlinkRequest
+	6,  // 4: Admin.SetSymlink:input_type -> SetSymlinkRequest
+	8,  // 5: Admin.ListXattrs:input_type -> ListXattrsRequest
+	10, // 6: Admin.GetXattr:input_type -> GetXattrRequest
+	12, // 7: Admin.SetXattr:input_type -> SetXattrRequest
+	14, // 8: Admin.RemoveXattr:input_type -> RemoveXattrRequest
+	16, // 9: Admin.Pin:input_type -> PinRequest
+	18, // 10: Admin.Unpin:input_type -> UnpinRequest
+	20, // 11: Admin.ListPins:input_type -> ListPinsRequest
+	22, // 12: Admin.Invalidate:input_type -> InvalidateRequest
+	24, // 13: Admin.GetDirGeneration:input_type -> GetDirGenerationRequest
+	3,  // 14: Admin.ListSymlinks:output_type -> ListSymlinksResponse
+	5,  // 15: Admin.GetSymlink:output_type -> GetSymlinkResponse
+	7,  // 16: Admin.SetSymlink:output_type -> SetSymlinkResponse
+	9,  // 17: Admin.ListXattrs:output_type -> ListXattrsResponse
+	11, // 18: Admin.GetXattr:output_type -> GetXattrResponse
+	13, // 19: Admin.SetXattr:output_type -> SetXattrResponse
+	15, // 20: Admin.RemoveXattr:output_type -> RemoveXattrResponse
+	17, // 21: Admin.Pin:output_type -> PinResponse
+	19, // 22: Admin.Unpin:output_type -> UnpinResponse
+	21, // 23: Admin.ListPins:output_type -> ListPinsResponse
+	23, // 24: Admin.Invalidate:output_type -> InvalidateResponse
+	25, // 25: Admin.GetDirGeneration:output_type -> GetDirGenerationResponse
+	14, // [14:26] is the sub-list for method output_type
+	2,  // [2:14] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_core_pb_admin_proto_init() }
+func file_core_pb_admin_proto_init() {
+	if File_core_pb_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_core_pb_admin_proto_rawDesc), len(file_core_pb_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_core_pb_admin_proto_goTypes,
+		DependencyIndexes: file_core_pb_admin_proto_depIdxs,
+		MessageInfos:      file_core_pb_admin_proto_msgTypes,
+	}.Build()
+	File_core_pb_admin_proto = out.File
+	file_core_pb_admin_proto_goTypes = nil
+	file_core_pb_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,516 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.12.4
+// source: core/pb/admin.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Admin_ListSymlinks_FullMethodName     = "/Admin/ListSymlinks"
+	Admin_GetSymlink_FullMethodName       = "/Admin/GetSymlink"
+	Admin_SetSymlink_FullMethodName       = "/Admin/SetSymlink"
+	Admin_ListXattrs_FullMethodName       = "/Admin/ListXattrs"
+	Admin_GetXattr_FullMethodName         = "/Admin/GetXattr"
+	Admin_SetXattr_FullMethodName         = "/Admin/SetXattr"
+	Admin_RemoveXattr_FullMethodName      = "/Admin/RemoveXattr"
+	Admin_Pin_FullMethodName              = "/Admin/Pin"
+	Admin_Unpin_FullMethodName            = "/Admin/Unpin"
+	Admin_ListPins_FullMethodName         = "/Admin/ListPins"
+	Admin_Invalidate_FullMethodName       = "/Admin/Invalidate"
+	Admin_GetDirGeneration_FullMethodName = "/Admin/GetDirGeneration"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ListSymlinks(ctx context.Context, in *ListSymlinksRequest, opts ...grpc.CallOption) (*ListSymlinksResponse, error)
+	GetSymlink(ctx context.Context, in *GetSymlinkRequest, opts ...grpc.CallOption) (*GetSymlinkResponse, error)
+	SetSymlink(ctx context.Context, in *SetSymlinkRequest, opts ...grpc.CallOption) (*SetSymlinkResponse, error)
+	ListXattrs(ctx context.Context, in *ListXattrsRequest, opts ...grpc.CallOption) (*ListXattrsResponse, error)
+	GetXattr(ctx context.Context, in *GetXattrRequest, opts ...grpc.CallOption) (*GetXattrResponse, error)
+	SetXattr(ctx context.Context, in *SetXattrRequest, opts ...grpc.CallOption) (*SetXattrResponse, error)
+	RemoveXattr(ctx context.Context, in *RemoveXattrRequest, opts ...grpc.CallOption) (*RemoveXattrResponse, error)
+	Pin(ctx context.Context, in *PinRequest, opts ...grpc.CallOption) (*PinResponse, error)
+	Unpin(ctx context.Context, in *UnpinRequest, opts ...grpc.CallOption) (*UnpinResponse, error)
+	ListPins(ctx context.Context, in *ListPinsRequest, opts ...grpc.CallOption) (*ListPinsResponse, error)
+	Invalidate(ctx context.Context, in *InvalidateRequest, opts ...grpc.CallOption) (*InvalidateResponse, error)
+	GetDirGeneration(ctx context.Context, in *GetDirGenerationRequest, opts ...grpc.CallOption) (*GetDirGenerationResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListSymlinks(ctx context.Context, in *ListSymlinksRequest, opts ...grpc.CallOption) (*ListSymlinksResponse, error) {
+	out := new(ListSymlinksResponse)
+	err := c.cc.Invoke(ctx, Admin_ListSymlinks_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetSymlink(ctx context.Context, in *GetSymlinkRequest, opts ...grpc.CallOption) (*GetSymlinkResponse, error) {
+	out := new(GetSymlinkResponse)
+	err := c.cc.Invoke(ctx, Admin_GetSymlink_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetSymlink(ctx context.Context, in *SetSymlinkRequest, opts ...grpc.CallOption) (*SetSymlinkResponse, error) {
+	out := new(SetSymlinkResponse)
+	err := c.cc.Invoke(ctx, Admin_SetSymlink_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListXattrs(ctx context.Context, in *ListXattrsRequest, opts ...grpc.CallOption) (*ListXattrsResponse, error) {
+	out := new(ListXattrsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListXattrs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetXattr(ctx context.Context, in *GetXattrRequest, opts ...grpc.CallOption) (*GetXattrResponse, error) {
+	out := new(GetXattrResponse)
+	err := c.cc.Invoke(ctx, Admin_GetXattr_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetXattr(ctx context.Context, in *SetXattrRequest, opts ...grpc.CallOption) (*SetXattrResponse, error) {
+	out := new(SetXattrResponse)
+	err := c.cc.Invoke(ctx, Admin_SetXattr_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveXattr(ctx context.Context, in *RemoveXattrRequest, opts ...grpc.CallOption) (*RemoveXattrResponse, error) {
+	out := new(RemoveXattrResponse)
+	err := c.cc.Invoke(ctx, Admin_RemoveXattr_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Pin(ctx context.Context, in *PinRequest, opts ...grpc.CallOption) (*PinResponse, error) {
+	out := new(PinResponse)
+	err := c.cc.Invoke(ctx, Admin_Pin_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Unpin(ctx context.Context, in *UnpinRequest, opts ...grpc.CallOption) (*UnpinResponse, error) {
+	out := new(UnpinResponse)
+	err := c.cc.Invoke(ctx, Admin_Unpin_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListPins(ctx context.Context, in *ListPinsRequest, opts ...grpc.CallOption) (*ListPinsResponse, error) {
+	out := new(ListPinsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListPins_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Invalidate(ctx context.Context, in *InvalidateRequest, opts ...grpc.CallOption) (*InvalidateResponse, error) {
+	out := new(InvalidateResponse)
+	err := c.cc.Invoke(ctx, Admin_Invalidate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetDirGeneration(ctx context.Context, in *GetDirGenerationRequest, opts ...grpc.CallOption) (*GetDirGenerationResponse, error) {
+	out := new(GetDirGenerationResponse)
+	err := c.cc.Invoke(ctx, Admin_GetDirGeneration_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	ListSymlinks(context.Context, *ListSymlinksRequest) (*ListSymlinksResponse, error)
+	GetSymlink(context.Context, *GetSymlinkRequest) (*GetSymlinkResponse, error)
+	SetSymlink(context.Context, *SetSymlinkRequest) (*SetSymlinkResponse, error)
+	ListXattrs(context.Context, *ListXattrsRequest) (*ListXattrsResponse, error)
+	GetXattr(context.Context, *GetXattrRequest) (*GetXattrResponse, error)
+	SetXattr(context.Context, *SetXattrRequest) (*SetXattrResponse, error)
+	RemoveXattr(context.Context, *RemoveXattrRequest) (*RemoveXattrResponse, error)
+	Pin(context.Context, *PinRequest) (*PinResponse, error)
+	Unpin(context.Context, *UnpinRequest) (*UnpinResponse, error)
+	ListPins(context.Context, *ListPinsRequest) (*ListPinsResponse, error)
+	Invalidate(context.Context, *InvalidateRequest) (*InvalidateResponse, error)
+	GetDirGeneration(context.Context, *GetDirGenerationRequest) (*GetDirGenerationResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListSymlinks(context.Context, *ListSymlinksRequest) (*ListSymlinksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSymlinks not implemented")
+}
+func (UnimplementedAdminServer) GetSymlink(context.Context, *GetSymlinkRequest) (*GetSymlinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSymlink not implemented")
+}
+func (UnimplementedAdminServer) SetSymlink(context.Context, *SetSymlinkRequest) (*SetSymlinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSymlink not implemented")
+}
+func (UnimplementedAdminServer) ListXattrs(context.Context, *ListXattrsRequest) (*ListXattrsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListXattrs not implemented")
+}
+func (UnimplementedAdminServer) GetXattr(context.Context, *GetXattrRequest) (*GetXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetXattr not implemented")
+}
+func (UnimplementedAdminServer) SetXattr(context.Context, *SetXattrRequest) (*SetXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetXattr not implemented")
+}
+func (UnimplementedAdminServer) RemoveXattr(context.Context, *RemoveXattrRequest) (*RemoveXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveXattr not implemented")
+}
+func (UnimplementedAdminServer) Pin(context.Context, *PinRequest) (*PinResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pin not implemented")
+}
+func (UnimplementedAdminServer) Unpin(context.Context, *UnpinRequest) (*UnpinResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unpin not implemented")
+}
+func (UnimplementedAdminServer) ListPins(context.Context, *ListPinsRequest) (*ListPinsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPins not implemented")
+}
+func (UnimplementedAdminServer) Invalidate(context.Context, *InvalidateRequest) (*InvalidateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Invalidate not implemented")
+}
+func (UnimplementedAdminServer) GetDirGeneration(context.Context, *GetDirGenerationRequest) (*GetDirGenerationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDirGeneration not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListSymlinks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSymlinksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSymlinks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSymlinks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSymlinks(ctx, req.(*ListSymlinksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetSymlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSymlinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetSymlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetSymlink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetSymlink(ctx, req.(*GetSymlinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetSymlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSymlinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetSymlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_SetSymlink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetSymlink(ctx, req.(*SetSymlinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListXattrs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListXattrsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListXattrs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListXattrs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListXattrs(ctx, req.(*ListXattrsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetXattr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetXattr(ctx, req.(*GetXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_SetXattr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetXattr(ctx, req.(*SetXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemoveXattr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveXattr(ctx, req.(*RemoveXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Pin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Pin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Pin_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Pin(ctx, req.(*PinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Unpin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnpinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Unpin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Unpin_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Unpin(ctx, req.(*UnpinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListPins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPinsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListPins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListPins_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListPins(ctx, req.(*ListPinsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Invalidate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvalidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Invalidate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Invalidate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Invalidate(ctx, req.(*InvalidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetDirGeneration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDirGenerationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetDirGeneration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetDirGeneration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetDirGeneration(ctx, req.(*GetDirGenerationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSymlinks",
+			Handler:    _Admin_ListSymlinks_Handler,
+		},
+		{
+			MethodName: "GetSymlink",
+			Handler:    _Admin_GetSymlink_Handler,
+		},
+		{
+			MethodName: "SetSymlink",
+			Handler:    _Admin_SetSymlink_Handler,
+		},
+		{
+			MethodName: "ListXattrs",
+			Handler:    _Admin_ListXattrs_Handler,
+		},
+		{
+			MethodName: "GetXattr",
+			Handler:    _Admin_GetXattr_Handler,
+		},
+		{
+			MethodName: "SetXattr",
+			Handler:    _Admin_SetXattr_Handler,
+		},
+		{
+			MethodName: "RemoveXattr",
+			Handler:    _Admin_RemoveXattr_Handler,
+		},
+		{
+			MethodName: "Pin",
+			Handler:    _Admin_Pin_Handler,
+		},
+		{
+			MethodName: "Unpin",
+			Handler:    _Admin_Unpin_Handler,
+		},
+		{
+			MethodName: "ListPins",
+			Handler:    _Admin_ListPins_Handler,
+		},
+		{
+			MethodName: "Invalidate",
+			Handler:    _Admin_Invalidate_Handler,
+		},
+		{
+			MethodName: "GetDirGeneration",
+			Handler:    _Admin_GetDirGeneration_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "core/pb/admin.proto",
+}
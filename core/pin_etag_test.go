@@ -0,0 +1,102 @@
+package core
+
+import (
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type PinETagTest struct{}
+
+var _ = Suite(&PinETagTest{})
+
+func (s *PinETagTest) TestPinETagNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.PinETag = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(b.WriteAndSync("file", []byte("generation 1")), IsNil)
+
+	// Replaced before the first read
+	inode, err := a.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	fh, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	t.Assert(b.WriteAndSync("file", []byte("generation 2")), IsNil)
+	_, _, err = fh.ReadFile(0, 12)
+	t.Assert(err, Equals, syscall.ESTALE)
+	fh.Release()
+
+	// New handles read the new generation
+	data, err := a.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "generation 2")
+
+	// Replaced after reading, noticed in a listing
+	fh, err = inode.OpenFile()
+	t.Assert(err, IsNil)
+	defer fh.Release()
+	_, _, err = fh.ReadFile(0, 12)
+	t.Assert(err, IsNil)
+	t.Assert(b.WriteAndSync("file", []byte("generation 3")), IsNil)
+	listDir(t, a.fs.getInodeOrDie(1))
+	_, _, err = fh.ReadFile(0, 12)
+	t.Assert(err, Equals, syscall.ESTALE)
+	// ESTALE is returned once, then the handle reads the new version
+	bufs, _, err := fh.ReadFile(0, 12)
+	t.Assert(err, IsNil)
+	t.Assert(string(bufs[0]), Equals, "generation 3")
+
+	// Our own changes don't make handles stale
+	fh2, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	defer fh2.Release()
+	t.Assert(fh2.WriteFile(0, []byte("generation 4"), true), IsNil)
+	t.Assert(inode.SyncFile(), IsNil)
+	bufs, _, err = fh2.ReadFile(0, 12)
+	t.Assert(err, IsNil)
+	t.Assert(string(bufs[0]), Equals, "generation 4")
+}
+
+func (s *PinETagTest) TestStaleHandleVersionNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.PinETag = true
+		flags.StaleHandle = "version"
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(b.WriteAndSync("file", []byte("generation 1")), IsNil)
+
+	inode, err := a.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	fh, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	defer fh.Release()
+	bufs, _, err := fh.ReadFile(0, 5)
+	t.Assert(err, IsNil)
+	t.Assert(string(bufs[0]), Equals, "gener")
+
+	// The handle keeps reading the opened version
+	t.Assert(b.WriteAndSync("file", []byte("GENERATION 2, longer")), IsNil)
+	listDir(t, a.fs.getInodeOrDie(1))
+	bufs, n, err := fh.ReadFile(5, 100)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 7)
+	t.Assert(string(bufs[0]), Equals, "ation 1")
+	data, err := a.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "GENERATION 2, longer")
+
+	// Removed versions can't be read
+	c.Store.mu.Lock()
+	c.Store.versions = make(map[string][]simVersion)
+	c.Store.mu.Unlock()
+	fh.versionId = nil
+	_, _, err = fh.ReadFile(0, 5)
+	t.Assert(err, Equals, syscall.ESTALE)
+}
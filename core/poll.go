@@ -0,0 +1,45 @@
+package core
+
+import (
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Applications waiting in poll() or epoll() on a file or a directory are
+// woken up when a listing or a lookup notices that it was changed remotely,
+// so they don't have to poll stat() themselves. The kernel only asks again
+// after a wakeup, so kernel handles are forgotten once woken up.
+
+// addPoller registers a kernel handle to be woken up on the next change
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) addPoller(kh uint64) {
+	for _, h := range inode.pollKh {
+		if h == kh {
+			return
+		}
+	}
+	inode.pollKh = append(inode.pollKh, kh)
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) wakePollers() {
+	if len(inode.pollKh) == 0 {
+		return
+	}
+	if inode.fs.NotifyCallback != nil {
+		notifications := make([]interface{}, len(inode.pollKh))
+		for i, kh := range inode.pollKh {
+			notifications[i] = &fuseops.NotifyPollWakeup{Kh: kh}
+		}
+		inode.fs.NotifyCallback(notifications)
+	}
+	inode.pollKh = nil
+}
+
+// poll(2) event bits and FUSE_POLL_SCHEDULE_NOTIFY, as sent by the kernel
+const (
+	pollScheduleNotify = 0x1
+	pollIn             = 0x1
+	pollOut            = 0x4
+	pollRdNorm         = 0x40
+	pollWrNorm         = 0x100
+)
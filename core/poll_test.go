@@ -0,0 +1,74 @@
+//go:build !windows
+
+package core
+
+import (
+	"sync"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+)
+
+type PollTest struct{}
+
+var _ = Suite(&PollTest{})
+
+func (s *PollTest) TestPollWakeupNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	fsint := NewGoofysFuse(m.fs)
+
+	var mu sync.Mutex
+	var woken []uint64
+	m.fs.NotifyCallback = func(notifications []interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, n := range notifications {
+			if w, ok := n.(*fuseops.NotifyPollWakeup); ok {
+				woken = append(woken, w.Kh)
+			}
+		}
+	}
+	wokenKh := func() []uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		res := woken
+		woken = nil
+		return res
+	}
+
+	c.Store.Put("watched", []byte("v1"), nil)
+	inode, err := m.fs.LookupPath("watched")
+	t.Assert(err, IsNil)
+
+	op := &fuseops.PollOp{Inode: inode.Id, Kh: 10, Flags: pollScheduleNotify, Events: pollIn}
+	err = fsint.Poll(nil, op)
+	t.Assert(err, IsNil)
+	t.Assert(uint32(op.Revents), Equals, uint32(pollIn))
+	err = fsint.Poll(nil, &fuseops.PollOp{Inode: fuseops.RootInodeID, Kh: 20, Flags: pollScheduleNotify})
+	t.Assert(err, IsNil)
+
+	// Unchanged file doesn't wake anyone up
+	err = m.fs.RefreshInodeCache(inode)
+	t.Assert(err, IsNil)
+	t.Assert(wokenKh(), HasLen, 0)
+
+	c.Store.Put("watched", []byte("v2 longer"), nil)
+	err = m.fs.RefreshInodeCache(inode)
+	t.Assert(err, IsNil)
+	t.Assert(wokenKh(), DeepEquals, []uint64{10})
+
+	// Pollers are only woken up once
+	c.Store.Put("watched", []byte("v3"), nil)
+	err = m.fs.RefreshInodeCache(inode)
+	t.Assert(err, IsNil)
+	t.Assert(wokenKh(), HasLen, 0)
+
+	// New remote files wake up directory pollers
+	c.Store.Put("new", []byte("x"), nil)
+	err = m.fs.RefreshInodeCache(m.fs.getInodeOrDie(fuseops.RootInodeID))
+	t.Assert(err, IsNil)
+	t.Assert(wokenKh(), DeepEquals, []uint64{20})
+}
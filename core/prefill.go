@@ -0,0 +1,302 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A job scheduler may push a manifest of files that a starting job will read
+// so that they're loaded into the cache before the job's first read: POST
+// paths relative to the mountpoint, one per line, to /prefill on the --pprof
+// port. With ?peer=host:port, files are first requested from another node
+// mounting the same bucket[:prefix] with --serve-cache, and only files it
+// doesn't have cached, or has with another ETag, are read from the backend.
+
+type PrefillResult struct {
+	Files    int      `json:"files"`
+	Bytes    uint64   `json:"bytes"`
+	FromPeer int      `json:"from_peer"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// readManifest returns non-empty lines which are not comments
+func readManifest(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// Prefill loads files into the cache using at most jobs parallel goroutines
+func (fs *Goofys) Prefill(paths []string, peer string, jobs int) *PrefillResult {
+	res := &PrefillResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for j := 0; j < jobs || j == 0; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				size, fromPeer, err := fs.prefillFile(path, peer)
+				mu.Lock()
+				if err != nil {
+					log.Warnf("Failed to prefill %v: %v", path, err)
+					res.Failed = append(res.Failed, path)
+				} else {
+					res.Files++
+					res.Bytes += size
+					if fromPeer {
+						res.FromPeer++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+	return res
+}
+
+func (fs *Goofys) prefillFile(path, peer string) (size uint64, fromPeer bool, err error) {
+	inode, err := fs.LookupPath(strings.Trim(path, "/"))
+	if err != nil {
+		return 0, false, err
+	}
+	if inode.isDir() {
+		return 0, false, syscall.EISDIR
+	}
+	if peer != "" {
+		fromPeer, err = fs.prefillFromPeer(inode, path, peer)
+		if err != nil {
+			log.Debugf("Prefilling %v from %v failed, reading it from the backend: %v", path, peer, err)
+		}
+	}
+	// Load the rest from the server in chunks, just like reads do
+	chunk := fs.flags.ReadAheadLargeKB * 1024
+	if chunk == 0 {
+		chunk = 100 * 1024 * 1024
+	}
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	size = inode.Attributes.Size
+	for offset := uint64(0); offset < size; offset += chunk {
+		inode.LockRange(offset, chunk, false)
+		_, err = inode.CheckLoadRange(offset, chunk, 0, false)
+		inode.UnlockRange(offset, chunk, false)
+		if err != nil {
+			return 0, fromPeer, err
+		}
+	}
+	return size, fromPeer, nil
+}
+
+// prefillPeerChunk is the size of Range requests to peers. Each chunk is
+// read into memory accounted in the buffer pool, so at most this much is
+// held per file at once
+var prefillPeerChunk uint64 = 4 * 1024 * 1024
+
+// prefillFromPeer fills holes in the cached data of inode with data cached by
+// a peer node, if its ETag matches. Holes are requested in chunks with Range
+// requests, and it stops when the memory limit is reached
+func (fs *Goofys) prefillFromPeer(inode *Inode, path, peer string) (bool, error) {
+	u := peer
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	u = strings.TrimSuffix(u, "/") + "/cache?path=" + url.QueryEscape(path)
+
+	inode.mu.Lock()
+	size, etag := inode.Attributes.Size, inode.knownETag
+	holes, _, _ := inode.buffers.GetHoles(0, size)
+	inode.mu.Unlock()
+	if etag == "" {
+		return false, fmt.Errorf("ETag of %v is unknown", path)
+	}
+
+	filled := false
+	for _, h := range holes {
+		for offset := h.Start; offset < h.End; offset += prefillPeerChunk {
+			n := MinUInt64(prefillPeerChunk, h.End-offset)
+			ok, err := fs.prefillChunkFromPeer(inode, u, etag, offset, n)
+			if err != nil {
+				return filled, err
+			}
+			filled = filled || ok
+		}
+	}
+	return filled, nil
+}
+
+func (fs *Goofys) prefillChunkFromPeer(inode *Inode, u, etag string, offset, size uint64) (bool, error) {
+	err := fs.bufferPool.Use(int64(size), false)
+	if err != nil {
+		return false, err
+	}
+	allocated := int64(0)
+	defer func() {
+		fs.bufferPool.Use(allocated-int64(size), true)
+	}()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+size-1))
+	req.Header.Set("If-Match", etag)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return false, fmt.Errorf("peer returned %v", resp.Status)
+	}
+	if resp.Header.Get("ETag") != etag || resp.ContentLength != int64(size) {
+		return false, fmt.Errorf("peer has another version (%v, %v bytes)", resp.Header.Get("ETag"), resp.ContentLength)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return false, err
+	}
+
+	inode.mu.Lock()
+	// Check again, the file may have changed while we were reading it
+	if inode.CacheState == ST_CACHED && inode.knownETag == etag {
+		holes, _, _ := inode.buffers.GetHoles(offset, size)
+		for _, h := range holes {
+			allocated += inode.buffers.Add(h.Start, data[h.Start-offset:h.End-offset], BUF_CLEAN, false)
+		}
+	}
+	inode.mu.Unlock()
+	return allocated > 0, nil
+}
+
+// PrefillHandler accepts a manifest of files to load into the cache.
+// ?peer=host:port is a node started with --serve-cache to copy cached data
+// from, ?jobs=N sets the number of files loaded in parallel (default 8).
+func (fs *Goofys) PrefillHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST a list of paths", http.StatusMethodNotAllowed)
+			return
+		}
+		jobs := 8
+		if s := r.URL.Query().Get("jobs"); s != "" {
+			var err error
+			jobs, err = strconv.Atoi(s)
+			if err != nil || jobs < 1 {
+				http.Error(w, "bad jobs", http.StatusBadRequest)
+				return
+			}
+		}
+		paths, err := readManifest(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res := fs.Prefill(paths, r.URL.Query().Get("peer"), jobs)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// CacheHandler serves files which are fully cached by this node to peers,
+// with their ETag. Files which are not cached are not read from the backend.
+// A single Range and If-Match are supported. Data is copied and sent in
+// chunks of prefillPeerChunk, so the whole file is never held in one buffer
+func (fs *Goofys) CacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inode, err := fs.LookupPath(strings.Trim(r.URL.Query().Get("path"), "/"))
+		if err != nil || inode.isDir() {
+			http.NotFound(w, r)
+			return
+		}
+		inode.mu.Lock()
+		size, etag := inode.Attributes.Size, inode.knownETag
+		holes, _, _ := inode.buffers.GetHoles(0, size)
+		cached := inode.CacheState == ST_CACHED && etag != "" && len(holes) == 0
+		inode.mu.Unlock()
+		if !cached {
+			http.Error(w, "not cached", http.StatusNotFound)
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+			http.Error(w, "another version is cached", http.StatusPreconditionFailed)
+			return
+		}
+		start, end := uint64(0), size
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			var first, last uint64
+			_, err := fmt.Sscanf(rng, "bytes=%d-%d", &first, &last)
+			if err != nil || first > last || last >= size {
+				http.Error(w, "bad range", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			start, end = first, last+1
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", first, last, size))
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.FormatUint(end-start, 10))
+		w.WriteHeader(status)
+		buf := make([]byte, 0, MinUInt64(prefillPeerChunk, end-start))
+		for offset := start; offset < end; offset += prefillPeerChunk {
+			n := MinUInt64(prefillPeerChunk, end-offset)
+			buf, err = inode.copyCachedRange(buf[:0], etag, offset, n)
+			if err != nil {
+				// The client sees a short response
+				log.Warnf("Failed to serve %v to a peer: %v", inode.FullName(), err)
+				return
+			}
+			_, err = w.Write(buf)
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// copyCachedRange appends cached data of the inode to buf, if it still has
+// the ETag. Buffers evicted to the disk cache are loaded back
+func (inode *Inode) copyCachedRange(buf []byte, etag string, offset, size uint64) ([]byte, error) {
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	if inode.CacheState != ST_CACHED || inode.knownETag != etag {
+		return nil, syscall.ESTALE
+	}
+	inode.LockRange(offset, size, false)
+	defer inode.UnlockRange(offset, size, false)
+	_, err := inode.CheckLoadRange(offset, size, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	bufs, _, err := inode.buffers.GetData(offset, size, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
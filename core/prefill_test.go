@@ -0,0 +1,95 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PrefillTest struct{}
+
+var _ = Suite(&PrefillTest{})
+
+func (s *PrefillTest) TestReadManifest(t *C) {
+	paths, err := readManifest(strings.NewReader("# job 1\na/b\n\n  c  \n"))
+	t.Assert(err, IsNil)
+	t.Assert(paths, DeepEquals, []string{"a/b", "c"})
+}
+
+func (s *PrefillTest) TestPrefillFromPeerNoCloud(t *C) {
+	c, err := NewSimCluster(2, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m0, m1 := c.Mounts[0], c.Mounts[1]
+
+	c.Store.Put("cached", []byte("cached data"), nil)
+	c.Store.Put("other", []byte("other data"), nil)
+	_, err = m0.ReadFile("cached")
+	t.Assert(err, IsNil)
+
+	peer := httptest.NewServer(m0.fs.CacheHandler())
+	defer peer.Close()
+
+	res := m1.fs.Prefill([]string{"cached", "/other", "missing"}, peer.URL, 2)
+	t.Assert(res.Files, Equals, 2)
+	t.Assert(res.FromPeer, Equals, 1)
+	t.Assert(res.Bytes, Equals, uint64(len("cached data")+len("other data")))
+	t.Assert(res.Failed, DeepEquals, []string{"missing"})
+	// Only the file not cached by the peer is read from the backend
+	t.Assert(m1.Conn.Calls("GetBlob"), Equals, 1)
+
+	data, err := m1.ReadFile("cached")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "cached data")
+	data, err = m1.ReadFile("other")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "other data")
+	t.Assert(m1.Conn.Calls("GetBlob"), Equals, 1)
+}
+
+func (s *PrefillTest) TestPrefillFromPeerChunksNoCloud(t *C) {
+	c, err := NewSimCluster(2, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m0, m1 := c.Mounts[0], c.Mounts[1]
+
+	saved := prefillPeerChunk
+	prefillPeerChunk = 4
+	defer func() { prefillPeerChunk = saved }()
+
+	c.Store.Put("file", []byte("0123456789"), nil)
+	_, err = m0.ReadFile("file")
+	t.Assert(err, IsNil)
+
+	peer := httptest.NewServer(m0.fs.CacheHandler())
+	defer peer.Close()
+
+	req, err := http.NewRequest("GET", peer.URL+"/cache?path=file", nil)
+	t.Assert(err, IsNil)
+	req.Header.Set("Range", "bytes=3-8")
+	resp, err := http.DefaultClient.Do(req)
+	t.Assert(err, IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Assert(err, IsNil)
+	t.Assert(resp.StatusCode, Equals, http.StatusPartialContent)
+	t.Assert(resp.Header.Get("Content-Range"), Equals, "bytes 3-8/10")
+	t.Assert(string(body), Equals, "345678")
+
+	req.Header.Set("If-Match", "\"other\"")
+	resp, err = http.DefaultClient.Do(req)
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+	t.Assert(resp.StatusCode, Equals, http.StatusPreconditionFailed)
+
+	res := m1.fs.Prefill([]string{"file"}, peer.URL, 1)
+	t.Assert(res.FromPeer, Equals, 1)
+	t.Assert(m1.Conn.Calls("GetBlob"), Equals, 0)
+	data, err := m1.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "0123456789")
+	t.Assert(m1.Conn.Calls("GetBlob"), Equals, 0)
+}
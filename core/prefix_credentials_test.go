@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type PrefixCredentialsTest struct{}
+
+var _ = Suite(&PrefixCredentialsTest{})
+
+func (s *PrefixCredentialsTest) TestPrefixCredentialsNoCloud(t *C) {
+	sharedConfig := filepath.Join(t.MkDir(), "config")
+	err := os.WriteFile(sharedConfig, []byte("[profile ingest]\n"+
+		"aws_access_key_id = ingest\naws_secret_access_key = secret\n"), 0600)
+	t.Assert(err, IsNil)
+
+	store := NewSimStore(NewSimClock())
+	main, ingest := NewSimConn(store), NewSimConn(store)
+	flags := cfg.DefaultFlags()
+	flags.Backend = &cfg.S3Config{SharedConfig: []string{sharedConfig}}
+	flags.PrefixCredentials = []cfg.PrefixCredentials{{Path: "raw", Profile: "ingest"}}
+	fs, err := newGoofys(context.Background(), "sim", flags, func(bucket string, flags *cfg.FlagStorage) (StorageBackend, error) {
+		if flags.Backend.(*cfg.S3Config).Profile == "ingest" {
+			return ingest, nil
+		}
+		return main, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	m := &SimMount{fs: fs, Conn: main}
+
+	t.Assert(m.WriteAndSync("raw/data", []byte("1")), IsNil)
+	t.Assert(ingest.Calls("PutBlob"), Equals, 1)
+	t.Assert(m.WriteAndSync("other", []byte("2")), IsNil)
+	t.Assert(main.Calls("PutBlob"), Equals, 1)
+	t.Assert(store.Keys(), DeepEquals, []string{"other", "raw/data"})
+}
+
+func (s *PrefixCredentialsTest) TestMountBucketNoCloud(t *C) {
+	sharedConfig := filepath.Join(t.MkDir(), "config")
+	err := os.WriteFile(sharedConfig, []byte("[profile ext]\n"+
+		"aws_access_key_id = ext\naws_secret_access_key = secret\n"), 0600)
+	t.Assert(err, IsNil)
+
+	clock := NewSimClock()
+	mainStore, scratchStore, extStore := NewSimStore(clock), NewSimStore(clock), NewSimStore(clock)
+	main, scratch, ext := NewSimConn(mainStore), NewSimConn(scratchStore), NewSimConn(extStore)
+	flags := cfg.DefaultFlags()
+	flags.Backend = &cfg.S3Config{SharedConfig: []string{sharedConfig}}
+	flags.BucketMounts = []cfg.BucketMount{
+		{Path: "data/scratch", Bucket: "scratch", Prefix: "user1/"},
+		{Path: "ext", Bucket: "ext", Profile: "ext"},
+	}
+	fs, err := newGoofys(context.Background(), "sim", flags, func(bucket string, flags *cfg.FlagStorage) (StorageBackend, error) {
+		switch bucket {
+		case "scratch":
+			return scratch, nil
+		case "ext":
+			t.Assert(flags.Backend.(*cfg.S3Config).Profile, Equals, "ext")
+			return ext, nil
+		}
+		return main, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	m := &SimMount{fs: fs, Conn: main}
+
+	t.Assert(m.WriteAndSync("data/scratch/a", []byte("1")), IsNil)
+	t.Assert(m.WriteAndSync("ext/b", []byte("2")), IsNil)
+	t.Assert(m.WriteAndSync("data/c", []byte("3")), IsNil)
+	t.Assert(mainStore.Keys(), DeepEquals, []string{"data/c"})
+	t.Assert(scratchStore.Keys(), DeepEquals, []string{"user1/a"})
+	t.Assert(extStore.Keys(), DeepEquals, []string{"b"})
+
+	// Renames between buckets are left to mv
+	data, err := fs.LookupPath("data")
+	t.Assert(err, IsNil)
+	dir, err := fs.LookupPath("data/scratch")
+	t.Assert(err, IsNil)
+	t.Assert(data.Rename("c", dir, "c"), Equals, syscall.EXDEV)
+}
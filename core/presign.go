@@ -0,0 +1,66 @@
+package core
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reading this virtual xattr returns a pre-signed GET URL of the file.
+// TTL may be given as "user.geesefs.presign?ttl=3600" or "?ttl=2h".
+const presignXattr = "user.geesefs.presign"
+
+const presignDefaultTTL = time.Hour
+
+func isPresignXattr(name string) bool {
+	return name == presignXattr || strings.HasPrefix(name, presignXattr+"?")
+}
+
+func (inode *Inode) presign(name string) ([]byte, error) {
+	maxTTL := inode.fs.flags.PresignMaxTTL
+	if maxTTL <= 0 {
+		return nil, ENOATTR
+	}
+	ttl := presignDefaultTTL
+	if len(name) > len(presignXattr) {
+		query, err := url.ParseQuery(name[len(presignXattr)+1:])
+		if err != nil {
+			return nil, syscall.EINVAL
+		}
+		if s := query.Get("ttl"); s != "" {
+			secs, err := strconv.ParseUint(s, 10, 32)
+			if err == nil {
+				ttl = time.Duration(secs) * time.Second
+			} else if ttl, err = time.ParseDuration(s); err != nil {
+				return nil, syscall.EINVAL
+			}
+		}
+		if ttl <= 0 {
+			return nil, syscall.EINVAL
+		}
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	inode.mu.Lock()
+	if inode.isDir() {
+		inode.mu.Unlock()
+		return nil, ENOATTR
+	}
+	// Note that the URL only becomes valid after the file is flushed
+	cloud, key := inode.cloud()
+	inode.mu.Unlock()
+	s3, ok := cloud.Delegate().(*S3Backend)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+	presigned, err := s3.PresignGetBlob(key, ttl)
+	if err != nil {
+		log.Warnf("Failed to presign %v: %v", key, err)
+		return nil, err
+	}
+	return []byte(presigned), nil
+}
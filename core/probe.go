@@ -0,0 +1,200 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// With --probe-tuning, a temporary object is uploaded and read back on mount
+// to measure the latency and throughput of the storage, and options whose
+// best values depend on them are derived from the results instead of using
+// defaults which suit a nearby S3:
+//
+//   - the part size grows with the upload bandwidth-delay product, so that
+//     a part upload takes much longer than a round trip
+//   - readahead covers twice the download bandwidth-delay product
+//   - more parts are uploaded in parallel when parallel uploads are faster
+//     than a single one, i.e. when a single connection is the bottleneck
+//
+// Readahead and parallel parts are never tuned below the values they'd have
+// without probing: the probe can't show that less of them helps.
+
+const probeSize = 4 * 1024 * 1024
+const probeStreams = 4
+
+type ProbeResult struct {
+	Latency time.Duration
+	// bytes per second
+	Upload           float64
+	ParallelUpload   float64
+	Download         float64
+	ParallelDownload float64
+}
+
+func probePut(cloud StorageBackend, key string, data []byte) error {
+	_, err := cloud.PutBlob(&PutBlobInput{
+		Key:  key,
+		Body: bytes.NewReader(data),
+		Size: PUInt64(uint64(len(data))),
+	})
+	return err
+}
+
+func probeGet(cloud StorageBackend, key string, start, count uint64) error {
+	resp, err := cloud.GetBlob(&GetBlobInput{
+		Key:   key,
+		Start: start,
+		Count: count,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// throughput of transferring size bytes in d, excluding the request latency
+func throughput(size int, d, latency time.Duration) float64 {
+	if d > 2*latency {
+		d -= latency
+	}
+	return float64(size) / d.Seconds()
+}
+
+func probeBackend(cloud StorageBackend, key string) (*ProbeResult, error) {
+	data := make([]byte, probeSize)
+	rand.Read(data)
+	start := time.Now()
+	err := probePut(cloud, key, data)
+	if err != nil {
+		return nil, err
+	}
+	putTime := time.Since(start)
+	keys := []string{key}
+	defer func() {
+		for _, key := range keys {
+			_, err := cloud.DeleteBlob(&DeleteBlobInput{Key: key})
+			if err != nil {
+				log.Warnf("Failed to remove probe object %v: %v", key, err)
+			}
+		}
+	}()
+
+	res := &ProbeResult{}
+	for i := 0; i < 3; i++ {
+		start = time.Now()
+		err = probeGet(cloud, key, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		if d := time.Since(start); res.Latency == 0 || d < res.Latency {
+			res.Latency = d
+		}
+	}
+	res.Upload = throughput(probeSize, putTime, res.Latency)
+
+	var wg sync.WaitGroup
+	errs := make([]error, probeStreams)
+	for i := 0; i < probeStreams; i++ {
+		keys = append(keys, fmt.Sprintf("%v.%v", key, i))
+	}
+	start = time.Now()
+	for i := 0; i < probeStreams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = probePut(cloud, keys[i+1], data[i*probeSize/probeStreams:(i+1)*probeSize/probeStreams])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.ParallelUpload = throughput(probeSize, time.Since(start), res.Latency)
+
+	start = time.Now()
+	err = probeGet(cloud, key, 0, probeSize)
+	if err != nil {
+		return nil, err
+	}
+	res.Download = throughput(probeSize, time.Since(start), res.Latency)
+
+	start = time.Now()
+	for i := 0; i < probeStreams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = probeGet(cloud, key, uint64(i*probeSize/probeStreams), probeSize/probeStreams)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.ParallelDownload = throughput(probeSize, time.Since(start), res.Latency)
+	return res, nil
+}
+
+// Tune adjusts options which are not set explicitly
+func (r *ProbeResult) Tune(flags *cfg.FlagStorage) {
+	const mb = 1024 * 1024
+	if !flags.PartSizesSet {
+		part := (uint64(r.Upload*r.Latency.Seconds()*8) + mb - 1) / mb * mb
+		if part < 5*mb {
+			part = 5 * mb
+		} else if part > 100*mb {
+			part = 100 * mb
+		}
+		flags.PartSizes = []cfg.PartSizeConfig{
+			{PartSize: part, PartCount: 1000},
+			{PartSize: 5 * part, PartCount: 1000},
+			{PartSize: 25 * part, PartCount: 8000},
+		}
+	}
+	if !flags.ReadAheadSet {
+		ra := uint64(r.Download*r.Latency.Seconds()*2) / 1024
+		if ra > flags.ReadAheadLargeKB {
+			ra = flags.ReadAheadLargeKB
+		}
+		if ra > flags.ReadAheadKB {
+			flags.ReadAheadKB = ra
+		}
+	}
+	if !flags.MaxPartsSet && r.Upload > 0 {
+		scaling := r.ParallelUpload / r.Upload
+		if scaling > probeStreams {
+			scaling = probeStreams
+		}
+		parts := int(4*scaling + 0.5)
+		if parts > flags.MaxParallelParts {
+			flags.MaxParallelParts = parts
+		}
+	}
+}
+
+// tuneByProbe keeps the defaults if the probe fails, for example with
+// read-only credentials
+func tuneByProbe(cloud StorageBackend, key string, flags *cfg.FlagStorage) {
+	res, err := probeBackend(cloud, key)
+	if err != nil {
+		log.Warnf("Storage probe failed, using default part sizes and readahead: %v", err)
+		return
+	}
+	res.Tune(flags)
+	log.Infof("Storage probe: latency %v, upload %.1f MB/s (%.1f MB/s with %v requests),"+
+		" download %.1f MB/s (%.1f MB/s with %v requests)."+
+		" Using part size %v MB, readahead %v KB, %v parallel parts",
+		res.Latency.Truncate(time.Microsecond), res.Upload/1e6, res.ParallelUpload/1e6, probeStreams,
+		res.Download/1e6, res.ParallelDownload/1e6, probeStreams, flags.PartSizes[0].PartSize/1024/1024, flags.ReadAheadKB, flags.MaxParallelParts)
+}
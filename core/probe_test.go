@@ -0,0 +1,96 @@
+package core
+
+import (
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type ProbeTest struct{}
+
+var _ = Suite(&ProbeTest{})
+
+func (s *ProbeTest) TestProbeTuneNoCloud(t *C) {
+	const mb = 1024 * 1024
+	defaults := cfg.DefaultFlags()
+	for _, c := range []struct {
+		name      string
+		res       ProbeResult
+		partSize  uint64
+		readAhead uint64
+		parts     int
+	}{
+		{
+			name:      "distant storage",
+			res:       ProbeResult{Latency: 50 * time.Millisecond, Upload: 100 * mb, ParallelUpload: 300 * mb, Download: 200 * mb, ParallelDownload: 600 * mb},
+			partSize:  40 * mb,
+			readAhead: 20 * 1024,
+			parts:     12,
+		},
+		{
+			name:      "fast local storage keeps the defaults",
+			res:       ProbeResult{Latency: time.Millisecond, Upload: 500 * mb, ParallelUpload: 400 * mb, Download: 500 * mb, ParallelDownload: 400 * mb},
+			partSize:  5 * mb,
+			readAhead: defaults.ReadAheadKB,
+			parts:     defaults.MaxParallelParts,
+		},
+		{
+			name:      "low latency with a slow single upload",
+			res:       ProbeResult{Latency: time.Millisecond, Upload: 50 * mb, ParallelUpload: 200 * mb, Download: 500 * mb, ParallelDownload: 500 * mb},
+			partSize:  5 * mb,
+			readAhead: defaults.ReadAheadKB,
+			parts:     16,
+		},
+		{
+			name:      "low latency with slow parallel downloads only",
+			res:       ProbeResult{Latency: time.Millisecond, Upload: 200 * mb, ParallelUpload: 300 * mb, Download: 50 * mb, ParallelDownload: 200 * mb},
+			partSize:  5 * mb,
+			readAhead: defaults.ReadAheadKB,
+			parts:     defaults.MaxParallelParts,
+		},
+	} {
+		flags := cfg.DefaultFlags()
+		c.res.Tune(flags)
+		t.Check(flags.PartSizes, DeepEquals, []cfg.PartSizeConfig{
+			{PartSize: c.partSize, PartCount: 1000},
+			{PartSize: 5 * c.partSize, PartCount: 1000},
+			{PartSize: 25 * c.partSize, PartCount: 8000},
+		}, Commentf(c.name))
+		t.Check(flags.ReadAheadKB, Equals, c.readAhead, Commentf(c.name))
+		t.Check(flags.MaxParallelParts, Equals, c.parts, Commentf(c.name))
+	}
+
+	// Options set explicitly aren't tuned
+	res := &ProbeResult{Latency: 50 * time.Millisecond, Upload: 100 * mb, ParallelUpload: 400 * mb, Download: 200 * mb}
+	flags := cfg.DefaultFlags()
+	flags.ReadAheadSet = true
+	flags.ReadAheadKB = 12345
+	flags.MaxPartsSet = true
+	flags.MaxParallelParts = 2
+	res.Tune(flags)
+	t.Assert(flags.ReadAheadKB, Equals, uint64(12345))
+	t.Assert(flags.MaxParallelParts, Equals, 2)
+}
+
+func (s *ProbeTest) TestProbeBackendNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	conn := NewSimConn(store)
+	conn.SetLatency(5 * time.Millisecond)
+
+	res, err := probeBackend(conn, ".geesefs_tmp/probe.test")
+	t.Assert(err, IsNil)
+	t.Assert(res.Latency >= 5*time.Millisecond, Equals, true)
+	t.Assert(res.Upload > 0 && res.ParallelUpload > 0, Equals, true)
+	t.Assert(res.Download > 0 && res.ParallelDownload > 0, Equals, true)
+	t.Assert(store.Keys(), HasLen, 0)
+
+	// Defaults are kept if the probe object can't be written
+	conn.FailNext("PutBlob", 1, syscall.EACCES)
+	flags := cfg.DefaultFlags()
+	parts := flags.PartSizes
+	tuneByProbe(conn, ".geesefs_tmp/probe.test", flags)
+	t.Assert(flags.PartSizes, DeepEquals, parts)
+}
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// Directory renames are done as a copy and a delete of every object and may
+// be interrupted by a crash, leaving both trees half-populated. With
+// --rename-journal, a journal object is written under --temp-prefix before
+// any object of a renamed directory is copied, and removed when all of them
+// are moved. Mounts report journals left by interrupted renames on start,
+// and `geesefs repair-renames` finishes them or rolls them back.
+//
+// The journal only records source and destination prefixes, progress is
+// the set of objects left under the source. Journals of completed renames
+// are removed on mount.
+
+const renameJournalName = "rename"
+
+type renameJournal struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Owner string    `json:"owner"`
+	Time  time.Time `json:"time"`
+
+	key   string
+	cloud StorageBackend
+	// used is set when the rename starts copying objects
+	used bool
+	// objects not moved yet, and whether they're counted already
+	// GUARDED_BY(renameJournals.mu)
+	pending int
+	ready   bool
+}
+
+type renameJournals struct {
+	mu   sync.Mutex
+	list []*renameJournal
+}
+
+func readRenameJournal(cloud StorageBackend, key string) (*renameJournal, error) {
+	resp, err := cloud.GetBlob(&GetBlobInput{Key: key})
+	if err != nil {
+		return nil, mapAwsError(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	j := &renameJournal{key: key}
+	err = json.Unmarshal(data, j)
+	if err != nil || j.From == "" || j.To == "" {
+		return nil, fmt.Errorf("invalid rename journal %v", key)
+	}
+	return j, nil
+}
+
+func listRenameJournals(flags *cfg.FlagStorage, cloud StorageBackend, tempPrefix string) ([]string, error) {
+	items, err := listAll(flags, cloud, tempPrefix+renameJournalName+".", "")
+	if err != nil {
+		return nil, mapAwsError(err)
+	}
+	keys := make([]string, len(items))
+	for i := range items {
+		keys[i] = *items[i].Key
+	}
+	return keys, nil
+}
+
+// hasObjects reports if there are objects under prefix
+func hasObjects(cloud StorageBackend, prefix string) (bool, error) {
+	resp, err := cloud.ListBlobs(&ListBlobsInput{
+		Prefix:  PString(prefix),
+		MaxKeys: PUInt32(1),
+	})
+	if err != nil {
+		return false, mapAwsError(err)
+	}
+	return len(resp.Items) > 0, nil
+}
+
+// startRenameJournal stores the journal of a directory rename. from and to
+// are full keys of the directories with trailing slashes
+func (fs *Goofys) startRenameJournal(cloud StorageBackend, from, to string) (*renameJournal, error) {
+	host, _ := os.Hostname()
+	j := &renameJournal{
+		From:  from,
+		To:    to,
+		Owner: fmt.Sprintf("%v:%v", host, os.Getpid()),
+		Time:  clock.Now().UTC(),
+		key:   fs.newTempKey(renameJournalName),
+		cloud: cloud,
+	}
+	body, _ := json.Marshal(j)
+	_, err := cloud.PutBlob(&PutBlobInput{
+		Key:         j.key,
+		ContentType: PString("application/json"),
+		Body:        bytes.NewReader(body),
+		Size:        PUInt64(uint64(len(body))),
+	})
+	if err != nil {
+		return nil, mapAwsError(err)
+	}
+	fs.renames.mu.Lock()
+	fs.renames.list = append(fs.renames.list, j)
+	fs.renames.mu.Unlock()
+	return j, nil
+}
+
+// prepareRenameJournal stores the journal for a rename of a directory,
+// before the rename takes its locks. It returns nil if from isn't a directory
+func (parent *Inode) prepareRenameJournal(from string, newParent *Inode, to string) (*renameJournal, error) {
+	fs := parent.fs
+	if !fs.flags.RenameJournal {
+		return nil, nil
+	}
+	child := parent.findChild(from)
+	if child == nil || !child.isDir() {
+		return nil, nil
+	}
+	cloud, fromPath := parent.cloud()
+	toCloud, toPath := newParent.cloud()
+	if cloud != toCloud {
+		return nil, nil
+	}
+	fromFullName := appendChildName(fromPath, from) + "/"
+	j, err := fs.startRenameJournal(cloud, fromFullName, appendChildName(toPath, to)+"/")
+	if err != nil {
+		log.Warnf("Failed to store rename journal of %v: %v", fromFullName, err)
+		return nil, err
+	}
+	return j, nil
+}
+
+// abortRenameJournal removes the journal of a rename which failed or turned
+// out not to need it, before any object was copied
+func (fs *Goofys) abortRenameJournal(j *renameJournal) {
+	fs.renames.mu.Lock()
+	fs.removeRenameJournal(j)
+	fs.renames.mu.Unlock()
+	_, err := j.cloud.DeleteBlob(&DeleteBlobInput{Key: j.key})
+	if err != nil && mapAwsError(err) != syscall.ENOENT {
+		log.Warnf("Failed to remove rename journal %v: %v", j.key, err)
+	}
+}
+
+// renameJournalReady sets the number of objects to be moved, after the
+// renamed directory is moved in the cache
+func (fs *Goofys) renameJournalReady(cloud StorageBackend, j *renameJournal, pending int) {
+	fs.renames.mu.Lock()
+	j.pending += pending
+	j.ready = true
+	done := j.pending <= 0 && fs.removeRenameJournal(j)
+	fs.renames.mu.Unlock()
+	if done {
+		go fs.finishRenameJournal(cloud, j)
+	}
+}
+
+// LOCKS_REQUIRED(fs.renames.mu)
+func (fs *Goofys) removeRenameJournal(j *renameJournal) bool {
+	for i, other := range fs.renames.list {
+		if other == j {
+			fs.renames.list = append(fs.renames.list[:i], fs.renames.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// renameMoved is called when an object is moved away from the old key by
+// a rename, and removes journals of completed directory renames
+func (fs *Goofys) renameMoved(cloud StorageBackend, from string) {
+	var done []*renameJournal
+	fs.renames.mu.Lock()
+	for _, j := range append([]*renameJournal(nil), fs.renames.list...) {
+		if strings.HasPrefix(from, j.From) {
+			j.pending--
+			if j.ready && j.pending <= 0 && fs.removeRenameJournal(j) {
+				done = append(done, j)
+			}
+		}
+	}
+	fs.renames.mu.Unlock()
+	for _, j := range done {
+		go fs.finishRenameJournal(cloud, j)
+	}
+}
+
+func (fs *Goofys) finishRenameJournal(cloud StorageBackend, j *renameJournal) {
+	left, err := hasObjects(cloud, j.From)
+	if err != nil || left {
+		// Leave it for repair-renames or the next mount
+		return
+	}
+	_, err = cloud.DeleteBlob(&DeleteBlobInput{Key: j.key})
+	if err != nil && mapAwsError(err) != syscall.ENOENT {
+		log.Warnf("Failed to remove rename journal %v: %v", j.key, err)
+	}
+}
+
+// checkRenameJournals removes journals of completed renames and reports
+// interrupted ones
+func (fs *Goofys) checkRenameJournals(cloud StorageBackend) {
+	keys, err := listRenameJournals(fs.flags, cloud, fs.tempPrefix)
+	if err != nil {
+		log.Warnf("Failed to list rename journals: %v", err)
+		return
+	}
+	for _, key := range keys {
+		j, err := readRenameJournal(cloud, key)
+		if err != nil {
+			log.Warnf("%v", err)
+			continue
+		}
+		left, err := hasObjects(cloud, j.From)
+		if err != nil {
+			log.Warnf("Failed to check rename journal %v: %v", key, err)
+		} else if left {
+			log.Warnf("Rename of %v to %v started by %v at %v is not finished. If it was interrupted,"+
+				" run geesefs repair-renames to finish or roll it back", j.From, j.To, j.Owner, j.Time)
+		} else {
+			cloud.DeleteBlob(&DeleteBlobInput{Key: key})
+		}
+	}
+}
+
+// moveObjects moves all objects under from to to. Objects which already
+// exist at the destination are not overwritten, because they were copied
+// before the interruption and may be changed since then
+func (b *BulkOps) moveObjects(from, to string) (int, error) {
+	items, err := listAll(b.flags, b.cloud, from, "")
+	if err != nil {
+		return 0, err
+	}
+	err = b.parallel(len(items), func(i int) error {
+		src := *items[i].Key
+		dst := to + src[len(from):]
+		_, err := b.cloud.HeadBlob(&HeadBlobInput{Key: dst})
+		if err = mapAwsError(err); err == syscall.ENOENT {
+			_, err = b.cloud.CopyBlob(&CopyBlobInput{
+				Source:      src,
+				Destination: dst,
+				Size:        &items[i].Size,
+				ETag:        items[i].ETag,
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, len(items))
+	for i := range items {
+		keys[i] = *items[i].Key
+	}
+	return len(items), b.deleteKeys(keys)
+}
+
+// RepairRenames finishes directory renames interrupted by crashed mounts
+// (--rename-journal), or moves objects back if rollback is true. It must
+// not be run while the renames are still in progress. Returns the number of
+// repaired renames
+func (b *BulkOps) RepairRenames(rollback bool) (int, error) {
+	if b.flags.TempPrefix == "" {
+		return 0, fmt.Errorf("rename journals require --temp-prefix")
+	}
+	keys, err := listRenameJournals(b.flags, b.cloud, b.prefix+b.flags.TempPrefix)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		j, err := readRenameJournal(b.cloud, key)
+		if err != nil {
+			return i, err
+		}
+		from, to := j.From, j.To
+		if rollback {
+			from, to = to, from
+		}
+		n, err := b.moveObjects(from, to)
+		if err != nil {
+			return i, fmt.Errorf("moving %v to %v: %v", from, to, err)
+		}
+		log.Infof("Moved %v objects from %v to %v", n, from, to)
+		_, err = b.cloud.DeleteBlob(&DeleteBlobInput{Key: key})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// countRenaming returns the number of objects in the subtree which are
+// not moved to their new keys yet
+// LOCKS_REQUIRED(dir.mu)
+func (dir *Inode) countRenaming() (n int) {
+	for _, child := range dir.dir.Children {
+		child.mu.Lock()
+		if child.oldParent != nil {
+			n++
+		}
+		if child.isDir() {
+			n += child.countRenaming()
+		}
+		child.mu.Unlock()
+	}
+	return
+}
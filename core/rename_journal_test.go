@@ -0,0 +1,127 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type RenameJournalTest struct{}
+
+var _ = Suite(&RenameJournalTest{})
+
+func renameJournalKeys(store *SimStore) (keys []string) {
+	for _, key := range store.Keys() {
+		if strings.HasPrefix(key, ".geesefs_tmp/rename.") {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+func (s *RenameJournalTest) TestRenameJournalNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.RenameJournal = true
+		flags.RetryInterval = 50 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	c.Store.Put("src/a", []byte("a"), nil)
+	c.Store.Put("src/sub/b", []byte("b"), nil)
+
+	// The journal is stored before anything is copied and kept until
+	// everything is moved
+	m.Conn.FailNext("CopyBlob", 1, syscall.EIO)
+	root := m.fs.getInodeOrDie(1)
+	_, err = m.fs.LookupPath("src")
+	t.Assert(err, IsNil)
+	t.Assert(root.Rename("src", root, "dst"), IsNil)
+	journals := renameJournalKeys(c.Store)
+	t.Assert(len(journals), Equals, 1)
+	data, _ := c.Store.Get(journals[0])
+	var j renameJournal
+	t.Assert(json.Unmarshal(data, &j), IsNil)
+	t.Assert(j.From, Equals, "src/")
+	t.Assert(j.To, Equals, "dst/")
+
+	for i := 0; i < 300 && len(renameJournalKeys(c.Store)) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"dst/", "dst/a", "dst/sub/", "dst/sub/b"})
+}
+
+func (s *RenameJournalTest) TestRenameJournalUnlockedNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.RenameJournal = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	c.Store.Put("src/a", []byte("a"), nil)
+	c.Store.Put("busy/b", []byte("b"), nil)
+	root := m.fs.getInodeOrDie(1)
+	_, err = m.fs.LookupPath("src")
+	t.Assert(err, IsNil)
+	_, err = m.fs.LookupPath("busy")
+	t.Assert(err, IsNil)
+
+	// Directories aren't locked while the journal is stored
+	m.Conn.SetOpLatency("PutBlob", 300*time.Millisecond)
+	done := make(chan error)
+	go func() {
+		done <- root.Rename("src", root, "dst")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	root.mu.Lock()
+	root.mu.Unlock()
+	t.Assert(time.Since(start) < 200*time.Millisecond, Equals, true)
+	t.Assert(<-done, IsNil)
+	m.Conn.SetOpLatency("PutBlob", 0)
+
+	// The journal of a failed rename is removed
+	t.Assert(root.Rename("dst", root, "busy"), Equals, syscall.ENOTEMPTY)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+	t.Assert(waitUntil(func() bool {
+		return len(renameJournalKeys(c.Store)) == 0
+	}), Equals, true)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"busy/b", "dst/", "dst/a"})
+}
+
+func (s *RenameJournalTest) TestRepairRenamesNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, jobs: 4}
+	putJournal := func(from, to string) {
+		data, _ := json.Marshal(&renameJournal{From: from, To: to})
+		c.Store.Put(".geesefs_tmp/rename."+RandStringBytesMaskImprSrc(8), data, nil)
+	}
+
+	// Interrupted after copying old/x, which was changed after that
+	putJournal("old/", "new/")
+	c.Store.Put("old/x", []byte("x"), nil)
+	c.Store.Put("old/y", []byte("y"), nil)
+	c.Store.Put("new/x", []byte("x2"), nil)
+	n, err := b.RepairRenames(false)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 1)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"new/x", "new/y"})
+	data, _ := c.Store.Get("new/x")
+	t.Assert(string(data), Equals, "x2")
+
+	putJournal("new/", "other/")
+	c.Store.Put("other/y", []byte("y"), nil)
+	c.Store.delete("new/y")
+	n, err = b.RepairRenames(true)
+	t.Assert(err, IsNil)
+	t.Assert(n, Equals, 1)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"new/x", "new/y"})
+}
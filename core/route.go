@@ -0,0 +1,312 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// --route rules choose the backend and the storage class of every object by
+// its path. The first matching rule applies, objects not matching any rule
+// stay in the main backend with its default storage class. Objects routed to
+// another bucket or endpoint keep their keys there.
+//
+// Listings of all backends are merged page by page. Each backend only
+// contributes objects routed to it, so objects left in another backend
+// after the rules were changed are hidden. Directory objects always stay in
+// the main backend. Objects can't be moved between backends with a rename,
+// so renames changing the backend fail with EXDEV and mv copies the files.
+
+type route struct {
+	rule         *cfg.RoutingRule
+	cloud        StorageBackend
+	storageClass *string
+}
+
+type routeBackend struct {
+	StorageBackend
+	prefix string
+	routes []route
+	// backends of rules with another bucket or endpoint
+	others []StorageBackend
+	cap    Capabilities
+}
+
+func newRouteBackend(cloud StorageBackend, bucket, prefix string, flags *cfg.FlagStorage,
+	newBackend func(string, *cfg.FlagStorage) (StorageBackend, error)) (*routeBackend, error) {
+	r := &routeBackend{
+		StorageBackend: cloud,
+		prefix:         prefix,
+		cap:            *cloud.Capabilities(),
+	}
+	byTarget := make(map[string]StorageBackend)
+	for i := range flags.Routes {
+		rule := &flags.Routes[i]
+		rt := route{rule: rule, cloud: cloud}
+		if rule.StorageClass != "" {
+			rt.storageClass = PString(rule.StorageClass)
+		}
+		if rule.Bucket != "" || rule.Endpoint != "" {
+			target := rule.Bucket
+			if target == "" {
+				target = bucket
+			}
+			other := byTarget[rule.Endpoint+"/"+target]
+			if other == nil {
+				otherFlags := *flags
+				if rule.Endpoint != "" {
+					otherFlags.Endpoint = rule.Endpoint
+				}
+				if s3, ok := flags.Backend.(*cfg.S3Config); ok {
+					config := *s3
+					otherFlags.Backend = &config
+				}
+				var err error
+				other, err = newBackend(target, &otherFlags)
+				if err != nil {
+					return nil, err
+				}
+				err = other.Init(prefix + RandStringBytesMaskImprSrc(32))
+				if err != nil {
+					return nil, err
+				}
+				byTarget[rule.Endpoint+"/"+target] = other
+				r.others = append(r.others, other)
+				caps := other.Capabilities()
+				r.cap.ConditionalPut = r.cap.ConditionalPut && caps.ConditionalPut
+				r.cap.ServerSideCopy = r.cap.ServerSideCopy && caps.ServerSideCopy
+				r.cap.ServerSideRename = r.cap.ServerSideRename && caps.ServerSideRename
+				r.cap.Patch = r.cap.Patch && caps.Patch
+				r.cap.UnsortedList = r.cap.UnsortedList || caps.UnsortedList
+			}
+			rt.cloud = other
+		}
+		r.routes = append(r.routes, rt)
+	}
+	return r, nil
+}
+
+func (r *routeBackend) Capabilities() *Capabilities {
+	return &r.cap
+}
+
+// route returns the first rule matching key, nil if there is none
+func (r *routeBackend) route(key string) *route {
+	if strings.HasSuffix(key, "/") || !strings.HasPrefix(key, r.prefix) {
+		return nil
+	}
+	path := key[len(r.prefix):]
+	for i := range r.routes {
+		if r.routes[i].rule.Match(path) {
+			return &r.routes[i]
+		}
+	}
+	return nil
+}
+
+func (r *routeBackend) cloudOf(key string) StorageBackend {
+	if rt := r.route(key); rt != nil {
+		return rt.cloud
+	}
+	return r.StorageBackend
+}
+
+func (r *routeBackend) storageClassOf(key string, storageClass *string) *string {
+	if rt := r.route(key); rt != nil && storageClass == nil {
+		return rt.storageClass
+	}
+	return storageClass
+}
+
+// sameRoutes reports if objects stay in their backend when from is renamed
+// to to. Renamed directories can only keep them if all rules match file
+// names regardless of directories, i.e. patterns are ** and a name glob
+func (r *routeBackend) sameRoutes(from, to string, dir bool) bool {
+	if !dir {
+		return r.cloudOf(from) == r.cloudOf(to)
+	}
+	if len(r.others) == 0 {
+		return true
+	}
+	for _, rt := range r.routes {
+		pattern := rt.rule.Pattern
+		if !strings.HasPrefix(pattern, "**") || strings.Contains(pattern[2:], "/") {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *routeBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	return r.cloudOf(param.Key).HeadBlob(param)
+}
+
+// lastListed returns the largest key or prefix of a listing page
+func lastListed(resp *ListBlobsOutput) string {
+	last := ""
+	if len(resp.Items) > 0 {
+		last = *resp.Items[len(resp.Items)-1].Key
+	}
+	if len(resp.Prefixes) > 0 && *resp.Prefixes[len(resp.Prefixes)-1].Prefix > last {
+		last = *resp.Prefixes[len(resp.Prefixes)-1].Prefix
+	}
+	return last
+}
+
+// ListBlobs merges pages of all backends up to the smallest last key of
+// truncated pages. Continuation tokens are keys to start after
+func (r *routeBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	if len(r.others) == 0 {
+		return r.StorageBackend.ListBlobs(param)
+	}
+	req := *param
+	if req.ContinuationToken != nil {
+		if *req.ContinuationToken > NilStr(req.StartAfter) {
+			req.StartAfter = req.ContinuationToken
+		}
+		req.ContinuationToken = nil
+	}
+	clouds := append([]StorageBackend{r.StorageBackend}, r.others...)
+	resps := make([]*ListBlobsOutput, len(clouds))
+	limit, truncated := "", false
+	for i, cloud := range clouds {
+		resp, err := cloud.ListBlobs(&req)
+		if err != nil {
+			return nil, err
+		}
+		if last := lastListed(resp); resp.IsTruncated && last != "" && (!truncated || last < limit) {
+			limit, truncated = last, true
+		}
+		resps[i] = resp
+	}
+	out := &ListBlobsOutput{
+		Prefixes:    make([]BlobPrefixOutput, 0),
+		Items:       make([]BlobItemOutput, 0),
+		IsTruncated: truncated,
+		RequestId:   resps[0].RequestId,
+	}
+	seen := make(map[string]bool)
+	for i, resp := range resps {
+		for _, p := range resp.Prefixes {
+			if (!truncated || *p.Prefix <= limit) && !seen[*p.Prefix] {
+				seen[*p.Prefix] = true
+				out.Prefixes = append(out.Prefixes, p)
+			}
+		}
+		for _, item := range resp.Items {
+			if (!truncated || *item.Key <= limit) && r.cloudOf(*item.Key) == clouds[i] {
+				out.Items = append(out.Items, item)
+			}
+		}
+	}
+	sort.Slice(out.Prefixes, func(i, j int) bool {
+		return *out.Prefixes[i].Prefix < *out.Prefixes[j].Prefix
+	})
+	sort.Sort(sortBlobItemOutput(out.Items))
+	if truncated {
+		out.NextContinuationToken = PString(limit)
+	}
+	return out, nil
+}
+
+func (r *routeBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	return r.cloudOf(param.Key).DeleteBlob(param)
+}
+
+func (r *routeBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	byCloud := make(map[StorageBackend][]string)
+	var clouds []StorageBackend
+	for _, key := range param.Items {
+		cloud := r.cloudOf(key)
+		if byCloud[cloud] == nil {
+			clouds = append(clouds, cloud)
+		}
+		byCloud[cloud] = append(byCloud[cloud], key)
+	}
+	// Keys of other backends are still deleted if one of them fails
+	var requestIds []string
+	var firstErr error
+	for _, cloud := range clouds {
+		resp, err := cloud.DeleteBlobs(&DeleteBlobsInput{Items: byCloud[cloud]})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if resp.RequestId != "" {
+			requestIds = append(requestIds, resp.RequestId)
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return &DeleteBlobsOutput{RequestId: strings.Join(requestIds, ",")}, nil
+}
+
+func (r *routeBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	cloud := r.cloudOf(param.Source)
+	if cloud != r.cloudOf(param.Destination) {
+		return nil, syscall.EXDEV
+	}
+	return cloud.RenameBlob(param)
+}
+
+func (r *routeBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	cloud := r.cloudOf(param.Source)
+	if cloud != r.cloudOf(param.Destination) {
+		return nil, syscall.EXDEV
+	}
+	copyIn := *param
+	copyIn.StorageClass = r.storageClassOf(param.Destination, param.StorageClass)
+	return cloud.CopyBlob(&copyIn)
+}
+
+func (r *routeBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	return r.cloudOf(param.Key).GetBlob(param)
+}
+
+func (r *routeBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	put := *param
+	put.StorageClass = r.storageClassOf(param.Key, param.StorageClass)
+	return r.cloudOf(param.Key).PutBlob(&put)
+}
+
+func (r *routeBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return r.cloudOf(param.Key).PatchBlob(param)
+}
+
+func (r *routeBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	begin := *param
+	begin.StorageClass = r.storageClassOf(param.Key, param.StorageClass)
+	return r.cloudOf(param.Key).MultipartBlobBegin(&begin)
+}
+
+func (r *routeBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	return r.cloudOf(NilStr(param.Commit.Key)).MultipartBlobAdd(param)
+}
+
+func (r *routeBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	cloud := r.cloudOf(NilStr(param.Commit.Key))
+	if cloud != r.cloudOf(param.CopySource) {
+		return nil, syscall.EXDEV
+	}
+	return cloud.MultipartBlobCopy(param)
+}
+
+func (r *routeBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	return r.cloudOf(NilStr(param.Key)).MultipartBlobAbort(param)
+}
+
+func (r *routeBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	return r.cloudOf(NilStr(param.Key)).MultipartBlobCommit(param)
+}
+
+func (r *routeBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	for _, cloud := range r.others {
+		cloud.MultipartExpire(param)
+	}
+	return r.StorageBackend.MultipartExpire(param)
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type RouteTest struct{}
+
+var _ = Suite(&RouteTest{})
+
+func (s *RouteTest) TestRouteNoCloud(t *C) {
+	clock := NewSimClock()
+	mainStore, scratchStore := NewSimStore(clock), NewSimStore(clock)
+	main, scratch := NewSimConn(mainStore), NewSimConn(scratchStore)
+	tmp := cfg.NewRoutingRule("**.tmp")
+	tmp.Bucket = "scratch"
+	results := cfg.NewRoutingRule("results/**")
+	results.StorageClass = "GLACIER_IR"
+	flags := cfg.DefaultFlags()
+	flags.Routes = []cfg.RoutingRule{tmp, results}
+	fs, err := newGoofys(context.Background(), "sim", flags, func(bucket string, flags *cfg.FlagStorage) (StorageBackend, error) {
+		if bucket == "scratch" {
+			return scratch, nil
+		}
+		return main, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	m := &SimMount{fs: fs, Conn: main}
+	root := fs.getInodeOrDie(fuseops.RootInodeID)
+	for _, dir := range []string{"work", "results"} {
+		inode, err := root.MkDir(dir)
+		t.Assert(err, IsNil)
+		t.Assert(inode.SyncFile(), IsNil)
+	}
+
+	t.Assert(m.WriteAndSync("a.txt", []byte("1")), IsNil)
+	t.Assert(m.WriteAndSync("work/b.tmp", []byte("2")), IsNil)
+	t.Assert(m.WriteAndSync("results/c", []byte("3")), IsNil)
+	t.Assert(mainStore.Keys(), DeepEquals, []string{"a.txt", "results/", "results/c", "work/"})
+	t.Assert(scratchStore.Keys(), DeepEquals, []string{"work/b.tmp"})
+
+	head, err := main.HeadBlob(&HeadBlobInput{Key: "results/c"})
+	t.Assert(err, IsNil)
+	t.Assert(NilStr(head.StorageClass), Equals, "GLACIER_IR")
+	head, err = main.HeadBlob(&HeadBlobInput{Key: "a.txt"})
+	t.Assert(err, IsNil)
+	t.Assert(NilStr(head.StorageClass), Equals, "STANDARD")
+
+	list, err := root.dir.cloud.ListBlobs(&ListBlobsInput{})
+	t.Assert(err, IsNil)
+	keys := []string{}
+	for _, item := range list.Items {
+		keys = append(keys, *item.Key)
+	}
+	t.Assert(keys, DeepEquals, []string{"a.txt", "results/", "results/c", "work/", "work/b.tmp"})
+
+	// Objects can't be renamed to another bucket
+	_, err = fs.LookupPath("a.txt")
+	t.Assert(err, IsNil)
+	t.Assert(root.Rename("a.txt", root, "a.tmp"), Equals, syscall.EXDEV)
+	t.Assert(root.Rename("a.txt", root, "d.txt"), IsNil)
+
+	// Batch deletes go to all backends and fail if one of them fails
+	t.Assert(m.WriteAndSync("e.tmp", []byte("4")), IsNil)
+	main.FailNext("DeleteBlobs", 1, syscall.EIO)
+	_, err = root.dir.cloud.DeleteBlobs(&DeleteBlobsInput{Items: []string{"d.txt", "e.tmp", "work/b.tmp"}})
+	t.Assert(err, NotNil)
+	t.Assert(mainStore.Keys(), DeepEquals, []string{"d.txt", "results/", "results/c", "work/"})
+	t.Assert(scratchStore.Keys(), DeepEquals, []string{})
+	scratch.FailNext("DeleteBlobs", 1, syscall.EIO)
+	scratchStore.Put("f.tmp", []byte("5"), nil)
+	_, err = root.dir.cloud.DeleteBlobs(&DeleteBlobsInput{Items: []string{"d.txt", "f.tmp"}})
+	t.Assert(err, NotNil)
+	t.Assert(mainStore.Keys(), DeepEquals, []string{"results/", "results/c", "work/"})
+	t.Assert(scratchStore.Keys(), DeepEquals, []string{"f.tmp"})
+}
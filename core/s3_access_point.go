@@ -0,0 +1,93 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// S3 Access Points and Object Lambda Access Points may be mounted by their
+// ARNs or aliases instead of bucket names:
+//
+//   - arn:aws:s3:<region>:<account>:accesspoint/<name> and aliases ending
+//     with -s3alias are access points. They support everything GeeseFS needs
+//     except ListObjectsV1Ext.
+//   - arn:aws:s3-object-lambda:<region>:<account>:accesspoint/<name> and
+//     aliases ending with --ol-s3 are Object Lambda Access Points. They only
+//     support GetObject, HeadObject and ListObjects, so such mounts run in
+//     a degraded mode: read-only, without copies, versions, conditional
+//     writes or S3 Select, and listed with ListObjects v1.
+//
+// ARNs are sent to <name>-<account>.s3-accesspoint.<region>.amazonaws.com
+// (or s3-object-lambda) by the SDK. Their region is always used, so it isn't
+// detected. Aliases are used like bucket names, with virtual-hosted-style
+// requests on AWS.
+
+const accessPointAliasSuffix = "-s3alias"
+const objectLambdaAliasSuffix = "--ol-s3"
+
+// accessPointKind returns "accesspoint" or "object-lambda" for ARNs and
+// aliases of access points, and "" for buckets
+func accessPointKind(bucket string) string {
+	if arn.IsARN(bucket) {
+		a, err := arn.Parse(bucket)
+		if err != nil || !strings.HasPrefix(a.Resource, "accesspoint") {
+			return ""
+		}
+		if a.Service == "s3-object-lambda" {
+			return "object-lambda"
+		}
+		return "accesspoint"
+	}
+	if strings.HasSuffix(bucket, objectLambdaAliasSuffix) {
+		return "object-lambda"
+	}
+	if strings.HasSuffix(bucket, accessPointAliasSuffix) {
+		return "accesspoint"
+	}
+	return ""
+}
+
+// splitAccessPointARN splits "arn:...:accesspoint/name:prefix" after the
+// ARN, which has colons itself
+func splitAccessPointARN(spec string) (bucket, prefix string) {
+	// arn:partition:service:region:account:resource
+	n := 6
+	parts := strings.SplitN(spec, ":", 8)
+	if len(parts) > 6 && parts[5] == "accesspoint" {
+		// accesspoint:name instead of accesspoint/name
+		n = 7
+	}
+	if len(parts) <= n {
+		return spec, ""
+	}
+	return strings.Join(parts[0:n], ":"), strings.Join(parts[n:], ":")
+}
+
+// setupAccessPoint adjusts the backend to the API subset of an access point
+func (s *S3Backend) setupAccessPoint(kind string) {
+	if arn.IsARN(s.bucket) {
+		if a, err := arn.Parse(s.bucket); err == nil && !s.config.RegionSet {
+			s.awsConfig.Region = aws.String(a.Region)
+		}
+		s.awsConfig.S3UseARNRegion = aws.Bool(true)
+		s.config.NoDetect = true
+	} else if s.flags.Endpoint == "" {
+		s.awsConfig.S3ForcePathStyle = aws.Bool(false)
+	}
+	s.config.ListV1Ext = false
+	// Not supported for access points
+	s.awsConfig.S3UseAccelerate = nil
+	if kind == "object-lambda" {
+		s3Log.Infof("%v is an Object Lambda Access Point, mounting it read-only"+
+			" without copies, versions, conditional writes and ListObjectsV2", s.bucket)
+		s.objectLambda = true
+		s.config.ListV2 = false
+		s.cap.ConditionalPut = false
+		s.cap.ServerSideCopy = false
+		s.cap.Versioning = false
+		s.cap.Select = false
+		s.cap.Patch = false
+	}
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 additional checksums (--checksum-algorithm) are sent with every PUT and
+// uploaded part in x-amz-checksum-<algorithm> headers, so S3 rejects corrupted
+// uploads. Multipart uploads have either a full-object checksum, which S3
+// combines from CRCs of parts into the CRC of the whole object, or a
+// composite one, which is a checksum of checksums of parts with a "-<parts>"
+// suffix. Full-object checksums are verified when whole objects are read:
+// S3 doesn't return checksums for ranged GETs, so reads of a file from the
+// start to its end are sent without a Range.
+
+// Polynomial of CRC64NVME, reversed
+const crc64NVMEPoly = 0x9a6c9329ac4bc9b5
+
+var crc64NVMETable = crc64.MakeTable(crc64NVMEPoly)
+
+var checksumAlgorithms = []string{"CRC32", "CRC32C", "CRC64NVME", "SHA1", "SHA256"}
+
+func newS3ChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "CRC32C":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "CRC64NVME":
+		return crc64.New(crc64NVMETable)
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	}
+	return nil
+}
+
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// computeChecksum returns the base64 checksum of the body and rewinds it
+func computeChecksum(algorithm string, body io.ReadSeeker) (string, error) {
+	h := newS3ChecksumHash(algorithm)
+	if body != nil {
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// responseChecksum returns the checksum from response headers, if any
+func responseChecksum(header http.Header) *ObjectChecksum {
+	for _, algorithm := range checksumAlgorithms {
+		value := header.Get(checksumHeader(algorithm))
+		if value == "" {
+			continue
+		}
+		checksumType := header.Get("x-amz-checksum-type")
+		if checksumType == "" {
+			// Not returned by older implementations
+			checksumType = "FULL_OBJECT"
+			if strings.Contains(value, "-") {
+				checksumType = "COMPOSITE"
+			}
+		}
+		return &ObjectChecksum{Algorithm: algorithm, Type: checksumType, Value: value}
+	}
+	return nil
+}
+
+// s3PartChecksums are checksums of uploaded parts by part number, to be
+// sent with CompleteMultipartUpload
+type s3PartChecksums struct {
+	mu    sync.Mutex
+	parts map[uint32]string
+}
+
+func (c *s3PartChecksums) set(partNumber uint32, value *string) {
+	if value == nil {
+		return
+	}
+	c.mu.Lock()
+	c.parts[partNumber] = *value
+	c.mu.Unlock()
+}
+
+func (c *s3PartChecksums) get(partNumber uint32) *string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.parts[partNumber]; ok {
+		return &value
+	}
+	return nil
+}
+
+// setChecksum computes the checksum of the body and adds it to the request
+func (s *S3Backend) setChecksum(req *request.Request, body io.ReadSeeker) (*string, error) {
+	value, err := computeChecksum(s.config.ChecksumAlgorithm, body)
+	if err != nil {
+		return nil, err
+	}
+	req.HTTPRequest.Header.Set(checksumHeader(s.config.ChecksumAlgorithm), value)
+	return &value, nil
+}
+
+func setCompletedPartChecksum(part *s3.CompletedPart, algorithm string, value *string) {
+	switch algorithm {
+	case "CRC32":
+		part.ChecksumCRC32 = value
+	case "CRC32C":
+		part.ChecksumCRC32C = value
+	case "CRC64NVME":
+		part.ChecksumCRC64NVME = value
+	case "SHA1":
+		part.ChecksumSHA1 = value
+	case "SHA256":
+		part.ChecksumSHA256 = value
+	}
+}
+
+func copyPartResultChecksum(res *s3.CopyPartResult, algorithm string) *string {
+	switch algorithm {
+	case "CRC32":
+		return res.ChecksumCRC32
+	case "CRC32C":
+		return res.ChecksumCRC32C
+	case "CRC64NVME":
+		return res.ChecksumCRC64NVME
+	case "SHA1":
+		return res.ChecksumSHA1
+	case "SHA256":
+		return res.ChecksumSHA256
+	}
+	return nil
+}
+
+// checksumReader verifies the full-object checksum of the body when its
+// last byte is read, or at EOF if its size is unknown. Readers often stop
+// after the expected number of bytes without reading EOF
+type checksumReader struct {
+	io.ReadCloser
+	key      string
+	checksum *ObjectChecksum
+	hash     hash.Hash
+	// remaining is the number of bytes left to read, or -1 if unknown
+	remaining int64
+	verified  bool
+}
+
+func (r *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if r.verified {
+		return
+	}
+	r.hash.Write(p[0:n])
+	if r.remaining >= 0 {
+		r.remaining -= int64(n)
+	}
+	if r.remaining == 0 || err == io.EOF {
+		r.verified = true
+		sum := base64.StdEncoding.EncodeToString(r.hash.Sum(nil))
+		if sum != r.checksum.Value {
+			s3Log.Errorf("%v checksum mismatch for %v: expected %v, got %v",
+				r.checksum.Algorithm, r.key, r.checksum.Value, sum)
+			err = syscall.EIO
+		}
+	}
+	return
+}
+
+// coversWholeObject reports if a Content-Range covers the whole object
+func coversWholeObject(contentRange *string) bool {
+	var first, last, size uint64
+	_, err := fmt.Sscanf(NilStr(contentRange), "bytes %d-%d/%d", &first, &last, &size)
+	return err == nil && first == 0 && last+1 == size
+}
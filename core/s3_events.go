@@ -0,0 +1,204 @@
+package core
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+)
+
+// With --sqs-queue, caches are invalidated by S3 event notifications
+// (s3:ObjectCreated:* and s3:ObjectRemoved:*) delivered to an SQS queue,
+// directly or through SNS. Every mount needs its own queue because a message
+// is only received by one consumer, so several queues are usually
+// subscribed to one SNS topic. Messages are removed after they're applied.
+
+type s3EventRecord struct {
+	EventName string `json:"eventName"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key  string `json:"key"`
+			ETag string `json:"eTag"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+type s3EventMessage struct {
+	Records []s3EventRecord `json:"Records"`
+	// SNS notification envelope
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// applyS3Events invalidates keys from an SQS message body
+func (fs *Goofys) applyS3Events(body string) {
+	var msg s3EventMessage
+	err := json.Unmarshal([]byte(body), &msg)
+	if err == nil && msg.Type == "Notification" {
+		inner := msg.Message
+		msg = s3EventMessage{}
+		err = json.Unmarshal([]byte(inner), &msg)
+	}
+	if err != nil {
+		log.Warnf("Ignoring invalid S3 event notification: %v", err)
+		return
+	}
+	for _, rec := range msg.Records {
+		if rec.S3.Bucket.Name != fs.bucket {
+			continue
+		}
+		// Keys in notifications are URL-encoded
+		key, err := url.QueryUnescape(rec.S3.Object.Key)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(rec.EventName, "ObjectCreated:") {
+			fs.invalidateKey(key, rec.S3.Object.ETag, false)
+		} else if strings.HasPrefix(rec.EventName, "ObjectRemoved:") {
+			fs.invalidateKey(key, "", true)
+		}
+	}
+}
+
+// sqsClient is a minimal SQS client for the two calls we need, the SDK
+// doesn't include the SQS package
+type sqsClient struct {
+	*client.Client
+	queueURL string
+}
+
+type sqsMessage struct {
+	_             struct{} `type:"structure"`
+	Body          *string  `type:"string"`
+	MessageId     *string  `type:"string"`
+	ReceiptHandle *string  `type:"string"`
+}
+
+type sqsReceiveMessageInput struct {
+	_                   struct{} `type:"structure"`
+	QueueUrl            *string  `type:"string"`
+	MaxNumberOfMessages *int64   `type:"integer"`
+	WaitTimeSeconds     *int64   `type:"integer"`
+}
+
+type sqsReceiveMessageOutput struct {
+	_        struct{}      `type:"structure"`
+	Messages []*sqsMessage `type:"list"`
+}
+
+type sqsDeleteEntry struct {
+	_             struct{} `type:"structure"`
+	Id            *string  `type:"string"`
+	ReceiptHandle *string  `type:"string"`
+}
+
+type sqsDeleteMessageBatchInput struct {
+	_        struct{}          `type:"structure"`
+	QueueUrl *string           `type:"string"`
+	Entries  []*sqsDeleteEntry `type:"list"`
+}
+
+type sqsDeleteMessageBatchOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+// newSQSClient creates a client with credentials of the S3 backend
+func (s *S3Backend) newSQSClient(queueURL string) *sqsClient {
+	awsConfig := &aws.Config{
+		Region:      s.awsConfig.Region,
+		Credentials: s.awsConfig.Credentials,
+	}
+	// https://sqs.<region>.amazonaws.com/<account>/<queue>
+	if u, err := url.Parse(queueURL); err == nil {
+		parts := strings.Split(u.Host, ".")
+		if len(parts) > 2 && parts[0] == "sqs" {
+			awsConfig.Region = aws.String(parts[1])
+		}
+	}
+	return newSQSClient(s.config.Session, awsConfig, queueURL)
+}
+
+func newSQSClient(p client.ConfigProvider, awsConfig *aws.Config, queueURL string) *sqsClient {
+	c := p.ClientConfig("sqs", awsConfig)
+	svc := &sqsClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "sqs",
+				ServiceID:     "SQS",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				PartitionID:   c.PartitionID,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2012-11-05",
+				JSONVersion:   "1.0",
+				TargetPrefix:  "AmazonSQS",
+			},
+			c.Handlers,
+		),
+		queueURL: queueURL,
+	}
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return svc
+}
+
+func (c *sqsClient) receive() ([]*sqsMessage, error) {
+	out := &sqsReceiveMessageOutput{}
+	req := c.NewRequest(&request.Operation{Name: "ReceiveMessage", HTTPMethod: "POST", HTTPPath: "/"},
+		&sqsReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		}, out)
+	err := req.Send()
+	return out.Messages, err
+}
+
+func (c *sqsClient) delete(messages []*sqsMessage) error {
+	in := &sqsDeleteMessageBatchInput{QueueUrl: aws.String(c.queueURL)}
+	for _, m := range messages {
+		in.Entries = append(in.Entries, &sqsDeleteEntry{Id: m.MessageId, ReceiptHandle: m.ReceiptHandle})
+	}
+	req := c.NewRequest(&request.Operation{Name: "DeleteMessageBatch", HTTPMethod: "POST", HTTPPath: "/"},
+		in, &sqsDeleteMessageBatchOutput{})
+	return req.Send()
+}
+
+// S3EventListener receives S3 event notifications from --sqs-queue
+func (fs *Goofys) S3EventListener(client *sqsClient) {
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		messages, err := client.receive()
+		if err != nil {
+			log.Warnf("Failed to receive S3 events from %v: %v", client.queueURL, err)
+			select {
+			case <-clock.After(fs.flags.RetryInterval):
+			case <-fs.shutdownCh:
+			}
+			continue
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		for _, m := range messages {
+			fs.applyS3Events(aws.StringValue(m.Body))
+		}
+		err = client.delete(messages)
+		if err != nil {
+			log.Warnf("Failed to remove S3 events from %v: %v", client.queueURL, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type S3EventsTest struct{}
+
+var _ = Suite(&S3EventsTest{})
+
+func s3EventBody(bucket, event, key, etag string) string {
+	return `{"Records":[{"eventName":"` + event + `","s3":{"bucket":{"name":"` + bucket +
+		`"},"object":{"key":"` + key + `","eTag":"` + strings.Trim(etag, `"`) + `"}}}]}`
+}
+
+func (s *S3EventsTest) TestS3EventsNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = time.Hour
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(b.WriteAndSync("a file", []byte("old")), IsNil)
+	root := a.fs.getInodeOrDie(1)
+	t.Assert(listDir(t, root), DeepEquals, []string{"a file"})
+	data, err := a.ReadFile("a file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "old")
+
+	// Created and changed objects, also through SNS
+	t.Assert(b.WriteAndSync("a file", []byte("new")), IsNil)
+	c.Store.Put("dir/file", []byte("1"), nil)
+	head, err := c.Mounts[1].Conn.HeadBlob(&HeadBlobInput{Key: "a file"})
+	t.Assert(err, IsNil)
+	a.fs.applyS3Events(s3EventBody(a.fs.bucket, "ObjectCreated:Put", "a+file", NilStr(head.ETag)))
+	sns, _ := json.Marshal(map[string]string{
+		"Type":    "Notification",
+		"Message": s3EventBody(a.fs.bucket, "ObjectCreated:Put", "dir/file", ""),
+	})
+	a.fs.applyS3Events(string(sns))
+	data, err = a.ReadFile("a file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "new")
+	t.Assert(listDir(t, root), DeepEquals, []string{"a file", "dir"})
+
+	// Removed objects and other buckets
+	t.Assert(b.fs.getInodeOrDie(1).Unlink("a file"), IsNil)
+	t.Assert(b.fs.SyncTree(nil), IsNil)
+	a.fs.applyS3Events(s3EventBody("other", "ObjectRemoved:Delete", "a+file", ""))
+	_, err = a.fs.LookupPath("a file")
+	t.Assert(err, IsNil)
+	a.fs.applyS3Events(s3EventBody(a.fs.bucket, "ObjectRemoved:Delete", "a+file", ""))
+	_, err = a.fs.LookupPath("a file")
+	t.Assert(err, NotNil)
+}
+
+func (s *S3EventsTest) TestSQSClientNoCloud(t *C) {
+	var targets []string
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		json.Unmarshal(data, &body)
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if strings.HasSuffix(targets[len(targets)-1], "ReceiveMessage") {
+			w.Write([]byte(`{"Messages":[{"MessageId":"m1","ReceiptHandle":"r1","Body":"{}"}]}`))
+		} else {
+			w.Write([]byte(`{"Successful":[{"Id":"m1"}],"Failed":[]}`))
+		}
+	}))
+	defer srv.Close()
+	sess, err := session.NewSession()
+	t.Assert(err, IsNil)
+	client := newSQSClient(sess, &aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}, "https://sqs.us-east-1.amazonaws.com/1/queue")
+	messages, err := client.receive()
+	t.Assert(err, IsNil)
+	t.Assert(messages, HasLen, 1)
+	t.Assert(*messages[0].ReceiptHandle, Equals, "r1")
+	t.Assert(client.delete(messages), IsNil)
+	t.Assert(targets, DeepEquals, []string{"AmazonSQS.ReceiveMessage", "AmazonSQS.DeleteMessageBatch"})
+	t.Assert(bodies[0]["QueueUrl"], Equals, "https://sqs.us-east-1.amazonaws.com/1/queue")
+	t.Assert(bodies[0]["WaitTimeSeconds"], Equals, float64(20))
+	t.Assert(bodies[1]["Entries"], DeepEquals, []interface{}{
+		map[string]interface{}{"Id": "m1", "ReceiptHandle": "r1"},
+	})
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 Express One Zone stores objects in directory buckets named
+// <name>--<zone id>--x-s3. They differ from general purpose buckets:
+//
+//   - Requests go to a zonal endpoint and are signed with temporary
+//     credentials from CreateSession which expire in 5 minutes. The session
+//     token is sent in X-Amz-S3session-Token.
+//   - ListObjectsV2 returns keys unsorted, doesn't support StartAfter and
+//     only accepts prefixes ending with the delimiter. GeeseFS needs sorted
+//     listings, so listings are read completely and sorted here. They also
+//     include prefixes of incomplete multipart uploads.
+//   - There is no versioning, no S3 Select and a single storage class.
+//   - PUTs take a few milliseconds, so more files are flushed in parallel.
+
+const expressSuffix = "--x-s3"
+
+// Sessions are renewed this long before they expire
+const expressSessionMargin = time.Minute
+
+// Regions by the prefix of zone IDs
+var expressRegions = map[string]string{
+	"use1":  "us-east-1",
+	"use2":  "us-east-2",
+	"usw2":  "us-west-2",
+	"aps1":  "ap-south-1",
+	"apne1": "ap-northeast-1",
+	"apse1": "ap-southeast-1",
+	"apse2": "ap-southeast-2",
+	"euw1":  "eu-west-1",
+	"euc1":  "eu-central-1",
+	"eun1":  "eu-north-1",
+}
+
+type expressSession struct {
+	mu      sync.Mutex
+	creds   credentials.Value
+	expires time.Time
+}
+
+func isExpressBucket(bucket string) bool {
+	return strings.HasSuffix(bucket, expressSuffix)
+}
+
+// expressZone returns the zone ID from the name of a directory bucket,
+// "use1-az4" for "data--use1-az4--x-s3"
+func expressZone(bucket string) string {
+	name := strings.TrimSuffix(bucket, expressSuffix)
+	pos := strings.LastIndex(name, "--")
+	if pos < 0 {
+		return ""
+	}
+	return name[pos+2:]
+}
+
+// setupExpress switches the backend to the zonal endpoint and session
+// authentication of a directory bucket
+func (s *S3Backend) setupExpress() error {
+	zone := expressZone(s.bucket)
+	if zone == "" {
+		return fmt.Errorf("no zone ID in the name of directory bucket %v", s.bucket)
+	}
+	if !s.config.RegionSet {
+		area := zone
+		if pos := strings.LastIndex(zone, "-"); pos >= 0 {
+			area = zone[0:pos]
+		}
+		region := expressRegions[area]
+		if region == "" {
+			return fmt.Errorf("unknown region of zone %v, set it with --region", zone)
+		}
+		s.awsConfig.Region = aws.String(region)
+	}
+	if s.flags.Endpoint == "" {
+		// Zonal endpoints only support virtual-hosted-style requests
+		s.awsConfig.Endpoint = aws.String("https://s3express-" + zone + "." + *s.awsConfig.Region + ".amazonaws.com")
+		s.awsConfig.S3ForcePathStyle = aws.Bool(false)
+	}
+	// Zonal endpoints have neither
+	s.awsConfig.S3UseAccelerate = nil
+	s.awsConfig.UseDualStack = nil
+	if s.config.StorageClass == s3.StorageClassStandard {
+		s.config.StorageClass = s3.StorageClassExpressOnezone
+	}
+	s.config.ListV2 = true
+	s.config.ListV1Ext = false
+	s.config.NoDetect = true
+	s.cap.Versioning = false
+	s.cap.Select = false
+	s.cap.Patch = false
+	s.cap.UnsortedList = true
+	s.cap.LowLatencyPut = true
+	s.express = &expressSession{}
+	return nil
+}
+
+// expressCredentials returns credentials of the current session and
+// creates a new one when it's about to expire
+func (s *S3Backend) expressCredentials() (credentials.Value, error) {
+	s.express.mu.Lock()
+	defer s.express.mu.Unlock()
+	if s.now().Add(expressSessionMargin).Before(s.express.expires) {
+		return s.express.creds, nil
+	}
+	resp, err := s.CreateSession(&s3.CreateSessionInput{
+		Bucket:      &s.bucket,
+		SessionMode: aws.String(s3.SessionModeReadWrite),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	s.express.creds = credentials.Value{
+		AccessKeyID:     NilStr(resp.Credentials.AccessKeyId),
+		SecretAccessKey: NilStr(resp.Credentials.SecretAccessKey),
+		SessionToken:    NilStr(resp.Credentials.SessionToken),
+		ProviderName:    "CreateSession",
+	}
+	if resp.Credentials.Expiration != nil {
+		s.express.expires = *resp.Credentials.Expiration
+	} else {
+		s.express.expires = s.now().Add(5 * time.Minute)
+	}
+	s3Log.Debugf("Created S3 Express session for %v until %v", s.bucket, s.express.expires)
+	return s.express.creds, nil
+}
+
+// signExpress signs CreateSession with the credentials of the mount and
+// everything else with the session
+func (s *S3Backend) signExpress(req *request.Request) {
+	req.ClientInfo.SigningName = "s3express"
+	if req.Operation.Name != "CreateSession" {
+		creds, err := s.expressCredentials()
+		if err != nil {
+			req.Error = err
+			return
+		}
+		req.HTTPRequest.Header.Set("X-Amz-S3session-Token", creds.SessionToken)
+		req.Config.Credentials = credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, "")
+	}
+	v4.SignSDKRequestWithCurrentTime(req, s.now, func(signer *v4.Signer) {
+		signer.DisableURIPathEscaping = true
+	})
+}
+
+// Sessions may be revoked before they expire, create a new one and retry
+func (s *S3Backend) retryExpiredSession(req *request.Request) {
+	awsErr, ok := req.Error.(awserr.Error)
+	if !ok || awsErr.Code() != "ExpiredToken" && awsErr.Code() != "InvalidToken" ||
+		req.Operation.Name == "CreateSession" {
+		return
+	}
+	s.express.mu.Lock()
+	s.express.expires = time.Time{}
+	s.express.mu.Unlock()
+	req.Retryable = aws.Bool(true)
+}
+
+// listExpress reads the whole listing and returns it sorted as a single
+// page, so MaxKeys is ignored. StartAfter is applied to the result and
+// prefixes not ending with "/" are listed from the last "/"
+func (s *S3Backend) listExpress(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	prefix, after := NilStr(param.Prefix), NilStr(param.StartAfter)
+	listPrefix := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		listPrefix = prefix[0 : strings.LastIndex(prefix, "/")+1]
+	}
+	out := &ListBlobsOutput{
+		Prefixes: make([]BlobPrefixOutput, 0),
+		Items:    make([]BlobItemOutput, 0),
+	}
+	var token *string
+	for {
+		resp, reqId, err := s.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:            &s.bucket,
+			Prefix:            &listPrefix,
+			Delimiter:         param.Delimiter,
+			ContinuationToken: token,
+		}, param.OpID)
+		if err != nil {
+			return nil, err
+		}
+		out.RequestId = reqId
+		for _, p := range resp.CommonPrefixes {
+			if strings.HasPrefix(*p.Prefix, prefix) && *p.Prefix > after {
+				out.Prefixes = append(out.Prefixes, BlobPrefixOutput{Prefix: p.Prefix})
+			}
+		}
+		for _, i := range resp.Contents {
+			if strings.HasPrefix(*i.Key, prefix) && *i.Key > after {
+				out.Items = append(out.Items, s3BlobItem(i))
+			}
+		}
+		if !aws.BoolValue(resp.IsTruncated) || resp.NextContinuationToken == nil {
+			break
+		}
+		token = resp.NextContinuationToken
+	}
+	sort.Slice(out.Prefixes, func(i, j int) bool {
+		return *out.Prefixes[i].Prefix < *out.Prefixes[j].Prefix
+	})
+	sort.Slice(out.Items, func(i, j int) bool {
+		return *out.Items[i].Key < *out.Items[j].Key
+	})
+	return out, nil
+}
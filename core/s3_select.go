@@ -0,0 +1,353 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// With --s3-select, every directory has a hidden virtual .geesefs_select
+// subdirectory, and reading .geesefs_select/<file>/<query> returns the
+// result of an S3 Select SQL query on the file, so that only the matching
+// rows are downloaded:
+//
+//	cat "data/.geesefs_select/cities.csv/SELECT s.name FROM S3Object s WHERE s.country = 'CH'"
+//
+// Slashes in queries are written as %2F. The input format is chosen by the
+// extension of the file: .csv and .tsv with a header line, .json (one
+// document), .jsonl or .ndjson (one document per line) or .parquet,
+// optionally followed by .gz or .bz2. CSV and TSV results are returned in the
+// same format without a header, others as JSON lines.
+//
+// A query runs when it is looked up, and its result is kept in memory, up to
+// --s3-select-cache for all queries of a file. .geesefs_select/<file> lists
+// the queries which are kept.
+
+const selectDirName = ".geesefs_select"
+
+// selectFormat returns the S3 Select input format and compression of a file
+func selectFormat(name string) (format, compression string, ok bool) {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".gz") {
+		name, compression = name[0:len(name)-3], "gzip"
+	} else if strings.HasSuffix(name, ".bz2") {
+		name, compression = name[0:len(name)-4], "bzip2"
+	}
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		format = "csv"
+	case strings.HasSuffix(name, ".tsv"):
+		format = "tsv"
+	case strings.HasSuffix(name, ".json"):
+		format = "json"
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"):
+		format = "jsonl"
+	case strings.HasSuffix(name, ".parquet"):
+		if compression != "" {
+			// Parquet is compressed internally
+			return "", "", false
+		}
+		format = "parquet"
+	default:
+		return "", "", false
+	}
+	return format, compression, true
+}
+
+type selectResult struct {
+	data []byte
+	etag string
+	time time.Time
+}
+
+// selectBackend is a read-only view of the results of S3 Select queries on
+// one object, named by the queries
+type selectBackend struct {
+	cloud       StorageBackend
+	selects     SelectBackend
+	key         string
+	format      string
+	compression string
+	maxSize     uint64
+
+	mu      sync.Mutex
+	results map[string]*selectResult
+	size    uint64
+}
+
+// result returns the cached result of the query or runs it
+func (s *selectBackend) result(name string) (*selectResult, error) {
+	s.mu.Lock()
+	res := s.results[name]
+	s.mu.Unlock()
+	if res != nil {
+		return res, nil
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		return nil, syscall.ENOENT
+	}
+	expr := name
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		expr = unescaped
+	}
+	resp, err := s.selects.SelectBlob(&SelectBlobInput{
+		Key:         s.key,
+		Expression:  expr,
+		InputFormat: s.format,
+		Compression: s.compression,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(s.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) > s.maxSize {
+		log.Warnf("S3 Select result on %v is larger than %v bytes: %v", s.key, s.maxSize, expr)
+		return nil, syscall.EFBIG
+	}
+	log.Infof("S3 Select on %v returned %v bytes: %v", s.key, len(data), expr)
+	res = &selectResult{
+		data: data,
+		etag: fmt.Sprintf("\"%x\"", md5.Sum(data)),
+		time: clock.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev := s.results[name]; prev != nil {
+		return prev, nil
+	}
+	for other, r := range s.results {
+		if s.size+uint64(len(data)) <= s.maxSize {
+			break
+		}
+		// Evicted results are queried again when read
+		s.size -= uint64(len(r.data))
+		delete(s.results, other)
+	}
+	s.results[name] = res
+	s.size += uint64(len(data))
+	return res, nil
+}
+
+func (s *selectBackend) item(name string, res *selectResult) BlobItemOutput {
+	return BlobItemOutput{
+		Key:          PString(name),
+		ETag:         PString(res.etag),
+		LastModified: PTime(res.time),
+		Size:         uint64(len(res.data)),
+	}
+}
+
+func (s *selectBackend) Init(key string) error {
+	return nil
+}
+
+func (s *selectBackend) Capabilities() *Capabilities {
+	return s.cloud.Capabilities()
+}
+
+func (s *selectBackend) Bucket() string {
+	return s.cloud.Bucket()
+}
+
+func (s *selectBackend) Delegate() interface{} {
+	return s
+}
+
+func (s *selectBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	res, err := s.result(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &HeadBlobOutput{BlobItemOutput: s.item(param.Key, res)}, nil
+}
+
+func (s *selectBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	s.mu.Lock()
+	items := make([]BlobItemOutput, 0, len(s.results))
+	for name, res := range s.results {
+		items = append(items, s.item(name, res))
+	}
+	s.mu.Unlock()
+	sort.Sort(sortBlobItemOutput(items))
+	return listSortedItems(items, param), nil
+}
+
+func (s *selectBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	res, err := s.result(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	data := res.data
+	if param.Start >= uint64(len(data)) {
+		data = nil
+	} else {
+		data = data[param.Start:]
+		if param.Count != 0 && param.Count < uint64(len(data)) {
+			data = data[0:param.Count]
+		}
+	}
+	return &GetBlobOutput{
+		HeadBlobOutput: HeadBlobOutput{BlobItemOutput: s.item(param.Key, res)},
+		Body:           ioutil.NopCloser(bytes.NewReader(data)),
+	}, nil
+}
+
+func (s *selectBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	return &MultipartExpireOutput{}, nil
+}
+
+func (s *selectBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (s *selectBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+// inSelect reports if the inode is a .geesefs_select directory or is
+// inside one
+func (inode *Inode) inSelect() bool {
+	if !inode.fs.flags.S3Select {
+		return false
+	}
+	if inode.dir != nil && inode.dir.selectDir {
+		return true
+	}
+	cloud, _ := inode.cloud()
+	_, ok := cloud.(*selectBackend)
+	return ok
+}
+
+// lookUpSelect returns the .geesefs_select directory or a view in it, if
+// name refers to one
+// LOCKS_EXCLUDED(parent.mu)
+func (parent *Inode) lookUpSelect(name string) (inode *Inode, ok bool, err error) {
+	if parent.dir.selectDir {
+		inode, err = parent.selectView(name)
+		return inode, true, err
+	}
+	if name != selectDirName || parent.readOnlyView() || parent.inClone() != nil {
+		return nil, false, nil
+	}
+	fs := parent.fs
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	inode = parent.findChildUnlocked(name)
+	if inode != nil {
+		return inode, inode.dir != nil && inode.dir.selectDir, nil
+	}
+	inode = NewInode(fs, parent, name)
+	inode.ToDir()
+	inode.dir.selectDir = true
+	inode.dir.DirTime = TIME_MAX
+	inode.SetAttrTime(TIME_MAX)
+	inode.userMetadata = make(map[string][]byte)
+	inode.Attributes.Mode = os.ModeDir | fs.flags.DirMode&0555
+	fs.insertInode(parent, inode)
+	return inode, true, nil
+}
+
+// LOCKS_EXCLUDED(dir.mu)
+func (dir *Inode) selectView(name string) (*Inode, error) {
+	dir.mu.Lock()
+	inode := dir.findChildUnlocked(name)
+	dir.mu.Unlock()
+	if inode != nil {
+		return inode, nil
+	}
+	format, compression, ok := selectFormat(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	file, err := dir.Parent.LookUpCached(name)
+	if err != nil {
+		return nil, err
+	}
+	if file.isDir() {
+		return nil, syscall.ENOENT
+	}
+	cloud, key := file.cloud()
+	selects, ok := selectsOf(cloud)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+	dir.fs.mount(dir, &Mount{
+		name: name,
+		cloud: &selectBackend{
+			cloud:       cloud,
+			selects:     selects,
+			key:         key,
+			format:      format,
+			compression: compression,
+			maxSize:     dir.fs.flags.S3SelectCacheMB << 20,
+			results:     make(map[string]*selectResult),
+		},
+	})
+	dir.mu.Lock()
+	inode = dir.findChildUnlocked(name)
+	dir.mu.Unlock()
+	if inode == nil {
+		return nil, syscall.ENOENT
+	}
+	inode.mu.Lock()
+	inode.Attributes.Mode = os.ModeDir | dir.fs.flags.DirMode&0555
+	inode.mu.Unlock()
+	return inode, nil
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strings"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type SelectTest struct{}
+
+var _ = Suite(&SelectTest{})
+
+func (s *SelectTest) TestSelectNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.S3Select = true
+		flags.S3SelectCacheMB = 1
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("data/cities.csv", []byte("name,country\nZurich,CH\nBern,CH\nParis,FR\n"), nil)
+	c.Store.Put("data/big.csv", []byte("n\n"+strings.Repeat("x\n", 1<<20)), nil)
+	c.Store.Put("data/notes.txt", []byte("CH"), nil)
+
+	// Only the result is downloaded, and it's kept for later reads
+	data, err := m.ReadFile("data/.geesefs_select/cities.csv/CH")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "Zurich,CH\nBern,CH\n")
+	data, err = m.ReadFile("data/.geesefs_select/cities.csv/Paris%2CFR")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "Paris,FR\n")
+	_, err = m.ReadFile("data/.geesefs_select/cities.csv/CH")
+	t.Assert(err, IsNil)
+	t.Assert(m.Conn.Calls("SelectBlob"), Equals, 2)
+	t.Assert(m.Conn.Calls("GetBlob"), Equals, 0)
+
+	view, err := m.fs.LookupPath("data/.geesefs_select/cities.csv")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, view), DeepEquals, []string{"CH", "Paris%2CFR"})
+	t.Assert(view.Unlink("CH"), Equals, syscall.EROFS)
+	dir, err := m.fs.LookupPath("data")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"big.csv", "cities.csv", "notes.txt"})
+
+	_, err = m.ReadFile("data/.geesefs_select/big.csv/x")
+	t.Assert(err, Equals, syscall.EFBIG)
+	_, err = m.ReadFile("data/.geesefs_select/notes.txt/CH")
+	t.Assert(err, Equals, syscall.ENOENT)
+	_, err = m.ReadFile("data/.geesefs_select/missing.csv/CH")
+	t.Assert(err, Equals, syscall.ENOENT)
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// SimCluster runs several in-process filesystem instances over one shared
+// in-memory bucket. It's meant for testing cross-mount consistency:
+// each mount has its own caches and its own SimConn, so network faults
+// can be injected per mount.
+//
+// Note that SimClock only drives object timestamps in the bucket unless it's
+// installed with SetClock; otherwise cache TTLs of mounts use real time, so
+// tests should set small TTLs.
+type SimCluster struct {
+	Clock  *SimClock
+	Store  *SimStore
+	Mounts []*SimMount
+}
+
+type SimMount struct {
+	fs   *Goofys
+	Conn *SimConn
+}
+
+// NewSimCluster starts n mounts. setFlags, if not nil, may adjust flags of
+// each mount before it's started.
+func NewSimCluster(n int, setFlags func(i int, flags *cfg.FlagStorage)) (*SimCluster, error) {
+	clock := NewSimClock()
+	c := &SimCluster{
+		Clock: clock,
+		Store: NewSimStore(clock),
+	}
+	for i := 0; i < n; i++ {
+		flags := cfg.DefaultFlags()
+		if setFlags != nil {
+			setFlags(i, flags)
+		}
+		conn := NewSimConn(c.Store)
+		fs, err := newGoofys(context.Background(), "sim", flags, func(string, *cfg.FlagStorage) (StorageBackend, error) {
+			return conn, nil
+		})
+		if err != nil {
+			c.Shutdown()
+			return nil, err
+		}
+		c.Mounts = append(c.Mounts, &SimMount{fs: fs, Conn: conn})
+	}
+	return c, nil
+}
+
+func (c *SimCluster) Shutdown() {
+	for _, m := range c.Mounts {
+		m.fs.Shutdown()
+	}
+	c.Mounts = nil
+}
+
+// WriteFile creates or truncates the file and writes data to it, without
+// flushing. Parent directories must exist.
+func (m *SimMount) WriteFile(path string, data []byte) (*Inode, error) {
+	inode, err := m.fs.LookupPath(path)
+	var fh *FileHandle
+	if err == syscall.ENOENT {
+		parent, name, err := m.fs.LookupParent(path)
+		if err != nil {
+			return nil, err
+		}
+		inode, fh, err = parent.Create(name)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		err = inode.SetAttributes(PUInt64(0), nil, nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		fh, err = inode.OpenFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer fh.Release()
+	return inode, fh.WriteFile(0, data, true)
+}
+
+// WriteAndSync writes the file and flushes it to the shared bucket
+func (m *SimMount) WriteAndSync(path string, data []byte) error {
+	inode, err := m.WriteFile(path, data)
+	if err != nil {
+		return err
+	}
+	return inode.SyncFile()
+}
+
+func (m *SimMount) ReadFile(path string) ([]byte, error) {
+	inode, err := m.fs.LookupPath(path)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := inode.OpenFile()
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Release()
+	inode.mu.Lock()
+	size := int64(inode.Attributes.Size)
+	inode.mu.Unlock()
+	bufs, _, err := fh.ReadFile(0, size)
+	if err != nil {
+		return nil, err
+	}
+	var res []byte
+	for _, b := range bufs {
+		res = append(res, b...)
+	}
+	return res, nil
+}
+
+type SimTest struct{}
+
+var _ = Suite(&SimTest{})
+
+func (s *SimTest) TestSimCrossMountVisibilityNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = 200 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+
+	_, err = b.fs.LookupPath("file")
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	err = a.WriteAndSync("file", []byte("hello"))
+	t.Assert(err, IsNil)
+	data, ok := c.Store.Get("file")
+	t.Assert(ok, Equals, true)
+	t.Assert(string(data), Equals, "hello")
+
+	// b sees the file after its negative cache entry expires
+	time.Sleep(300 * time.Millisecond)
+	data, err = b.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "hello")
+
+	// Objects written behind the mounts' backs are visible too
+	c.Clock.Advance(time.Hour)
+	c.Store.Put("other", []byte("world"), nil)
+	time.Sleep(300 * time.Millisecond)
+	data, err = a.ReadFile("other")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "world")
+	inode, err := a.fs.LookupPath("other")
+	t.Assert(err, IsNil)
+	t.Assert(inode.Attributes.Mtime.Equal(c.Clock.Now()), Equals, true)
+}
+
+func (s *SimTest) TestSimNetworkFaultsNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.StatCacheTTL = 200 * time.Millisecond
+		flags.ReadRetryAttempts = 2
+		flags.ReadRetryInterval = 10 * time.Millisecond
+		flags.RetryInterval = 10 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+
+	c.Store.Put("file", []byte("data"), nil)
+
+	// Partitioned mount can't see the file, the other one can
+	b.Conn.Partition()
+	_, err = b.fs.LookupPath("file")
+	t.Assert(err, NotNil)
+	_, err = a.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	b.Conn.Heal()
+	_, err = b.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+
+	// Injected upload failure is retried. The failure may be hit either by
+	// the background flusher or by SyncFile itself
+	a.Conn.FailNext("PutBlob", 1, syscall.EAGAIN)
+	inode, err := a.WriteFile("file", []byte("new data"))
+	t.Assert(err, IsNil)
+	err = inode.SyncFile()
+	if err != nil {
+		t.Assert(err, Equals, syscall.EAGAIN)
+		time.Sleep(20 * time.Millisecond)
+		err = inode.SyncFile()
+	}
+	t.Assert(err, IsNil)
+	t.Assert(a.Conn.Calls("PutBlob"), Equals, 2)
+	data, _ := c.Store.Get("file")
+	t.Assert(string(data), Equals, "new data")
+}
+
+func (s *SimTest) TestSimFaultInjectionNoCloud(t *C) {
+	clock := NewSimClock()
+	store := NewSimStore(clock)
+	conn := NewSimConn(store)
+	put := func(key string) error {
+		_, err := conn.PutBlob(&PutBlobInput{Key: key, Body: bytes.NewReader([]byte(key))})
+		return err
+	}
+	list := func() (keys []string) {
+		resp, err := conn.ListBlobs(&ListBlobsInput{})
+		t.Assert(err, IsNil)
+		for _, item := range resp.Items {
+			keys = append(keys, *item.Key)
+		}
+		return
+	}
+
+	// Injected HTTP errors are mapped like real ones, requests are recorded
+	conn.Record(true)
+	conn.FailNext("PutBlob", 1, SimHTTPError(503, "SlowDown"))
+	conn.FailNext("PutBlob", 1, SimHTTPError(412, "PreconditionFailed"))
+	err := put("a")
+	t.Assert(mapAwsError(err), Equals, syscall.EAGAIN)
+	t.Assert(shouldRetry(err), Equals, true)
+	t.Assert(mapAwsError(put("a")), Equals, syscall.EBUSY)
+	t.Assert(put("a"), IsNil)
+	requests := conn.Requests()
+	t.Assert(requests, HasLen, 3)
+	t.Assert(requests[0].Op, Equals, "PutBlob")
+	t.Assert(requests[0].Key, Equals, "a")
+	t.Assert(requests[2].Err, IsNil)
+	t.Assert(conn.Requests(), HasLen, 0)
+
+	// Listings lag behind with a consistency delay, reads don't
+	store.SetListDelay(time.Minute)
+	t.Assert(list(), HasLen, 0)
+	clock.Advance(time.Minute)
+	t.Assert(list(), DeepEquals, []string{"a"})
+	t.Assert(put("b"), IsNil)
+	_, err = conn.DeleteBlob(&DeleteBlobInput{Key: "a"})
+	t.Assert(err, IsNil)
+	t.Assert(list(), DeepEquals, []string{"a"})
+	_, err = conn.HeadBlob(&HeadBlobInput{Key: "b"})
+	t.Assert(err, IsNil)
+	clock.Advance(time.Minute)
+	t.Assert(list(), DeepEquals, []string{"b"})
+}
+
+func (s *SimTest) TestSimAtimeNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.Atime = "relatime"
+		if i == 1 {
+			flags.Atime = "lazy"
+			flags.AtimeFlushInterval = 500 * time.Millisecond
+			flags.DirMetaFile = true
+			flags.EnablePerms = true
+		}
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	c.Store.Put("file", []byte("hello"), nil)
+	c.Store.Put("file2", []byte("hello"), nil)
+	atimeOf := func(m *SimMount) time.Time {
+		inode, err := m.fs.LookupPath("file")
+		t.Assert(err, IsNil)
+		inode.mu.Lock()
+		defer inode.mu.Unlock()
+		return inode.InflateAttributes().Atime
+	}
+
+	// relatime: updated on the first read, but not on the next one
+	a := c.Mounts[0]
+	before := time.Now()
+	_, err = a.ReadFile("file")
+	t.Assert(err, IsNil)
+	atime := atimeOf(a)
+	t.Assert(atime.Before(before), Equals, false)
+	_, err = a.ReadFile("file")
+	t.Assert(err, IsNil)
+	t.Assert(atimeOf(a).Equal(atime), Equals, true)
+	head, err := c.Store.head("file")
+	t.Assert(err, IsNil)
+	t.Assert(head.Metadata["atime"], IsNil)
+
+	// lazy: stored in .geesefs_meta with the next batch, one update for
+	// both files, and objects aren't changed
+	b := c.Mounts[1]
+	_, err = b.ReadFile("file")
+	t.Assert(err, IsNil)
+	_, err = b.ReadFile("file2")
+	t.Assert(err, IsNil)
+	t.Assert(waitUntil(func() bool {
+		meta, _, _, err := getDirMeta(b.Conn, dirMetaName)
+		return err == nil && len(meta.Atimes) == 2
+	}), Equals, true)
+	meta, _, _, err := getDirMeta(b.Conn, dirMetaName)
+	t.Assert(err, IsNil)
+	t.Assert(meta.Atimes["file"], Equals, atimeOf(b).Unix())
+	t.Assert(b.Conn.Calls("PutBlob"), Equals, 1)
+	t.Assert(b.Conn.Calls("CopyBlob"), Equals, 0)
+	head2, err := c.Store.head("file")
+	t.Assert(err, IsNil)
+	t.Assert(head2.ETag, DeepEquals, head.ETag)
+}
+
+func (s *SimTest) TestSimCapabilitiesNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	_, err = m.WriteFile("file", []byte("1"))
+	t.Assert(err, IsNil)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+
+	// Rename-only backends move files without copies
+	m.Conn.cap.ServerSideCopy = false
+	m.Conn.cap.ServerSideRename = true
+	root := m.fs.getInodeOrDie(1)
+	t.Assert(root.Rename("file", root, "moved"), IsNil)
+	t.Assert(m.fs.SyncTree(nil), IsNil)
+	t.Assert(m.Conn.Calls("RenameBlob"), Equals, 1)
+	t.Assert(m.Conn.Calls("CopyBlob"), Equals, 0)
+	t.Assert(m.Conn.Calls("DeleteBlob"), Equals, 0)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"moved"})
+
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, jobs: 1}
+	_, err = b.Copy("moved", "copy", false, false)
+	t.Assert(err, ErrorMatches, "sim doesn't support server-side copies")
+
+	m.Conn.cap.Versioning = false
+	_, ok := versionsOf(m.Conn)
+	t.Assert(ok, Equals, false)
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"path"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// --single-object exposes one object, for example a multi-terabyte disk
+// image or an HDF5 master file, as the only file of a read-only mount:
+// bucket:path/to/image.img is mounted as <mountpoint>/image.img. The object
+// is checked once at mount and there is no directory machinery: nothing is
+// listed, other names don't exist and metadata never expires.
+
+func (fs *Goofys) mountSingleObject(cloud StorageBackend, key string) error {
+	head, err := cloud.HeadBlob(&HeadBlobInput{Key: key})
+	if err != nil {
+		return err
+	}
+	head.Key = &key
+	root := fs.getInodeOrDie(fuseops.RootInodeID)
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.dir.mountPrefix = ""
+	if dir := path.Dir(key); dir != "." {
+		root.dir.mountPrefix = dir + "/"
+	}
+	root.insertSubTree(path.Base(key), &head.BlobItemOutput, make(map[*Inode]bool))
+	root.freezeTree()
+	return nil
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type SingleObjectTest struct{}
+
+var _ = Suite(&SingleObjectTest{})
+
+func (s *SingleObjectTest) TestSingleObjectNoCloud(t *C) {
+	store := NewSimStore(NewSimClock())
+	store.Put("images/disk.img", []byte("disk image"), nil)
+	store.Put("images/other.img", []byte("other"), nil)
+	conn := NewSimConn(store)
+	flags := cfg.DefaultFlags()
+	flags.SingleObject = true
+	flags.NoList = true
+	newBackend := func(string, *cfg.FlagStorage) (StorageBackend, error) {
+		return conn, nil
+	}
+
+	_, err := newGoofys(context.Background(), "sim:images/missing.img", flags, newBackend)
+	t.Assert(err, NotNil)
+
+	fs, err := newGoofys(context.Background(), "sim:images/disk.img", flags, newBackend)
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	heads := conn.Calls("HeadBlob")
+
+	inode, err := fs.LookupPath("disk.img")
+	t.Assert(err, IsNil)
+	t.Assert(inode.Attributes.Size, Equals, uint64(len("disk image")))
+	_, err = fs.LookupPath("other.img")
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	fh, err := inode.OpenFile()
+	t.Assert(err, IsNil)
+	data, _, err := fh.ReadFile(5, 5)
+	t.Assert(err, IsNil)
+	t.Assert(string(data[0]), Equals, "image")
+	fh.Release()
+
+	t.Assert(conn.Calls("HeadBlob"), Equals, heads)
+	t.Assert(conn.Calls("ListBlobs"), Equals, 0)
+}
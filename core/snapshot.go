@@ -0,0 +1,255 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// Directory snapshots (geesefs snapshot) capture a consistent listing of
+// a prefix with ETags, including .geesefs_meta and other metadata
+// objects, and write it as a --key-manifest file, so that the directory may
+// later be mounted read-only exactly as it was.
+//
+// S3 listings aren't atomic, so a generation marker object is written into
+// the prefix first. The listing is consistent if nothing in it is newer than
+// the marker and a second listing returns exactly the same objects. Otherwise
+// the directory was changed during the snapshot and it's retried with a new
+// marker.
+//
+// In versioned buckets, the snapshot also pins the version of every object,
+// so that `geesefs clone` can show it as it was even after it's overwritten.
+
+const snapshotMarkerPrefix = ".geesefs_snapshot."
+const snapshotAttempts = 5
+
+type Snapshot struct {
+	// Time of the generation marker, as reported by the server
+	Time   time.Time
+	Prefix string
+	Items  []BlobItemOutput
+	// Version IDs of items if the bucket is versioned
+	VersionIds map[string]string
+}
+
+func listAll(flags *cfg.FlagStorage, cloud StorageBackend, prefix string, skip string) ([]BlobItemOutput, error) {
+	var items []BlobItemOutput
+	var startAfter *string
+	for {
+		resp, err := RetryListBlobs(flags, cloud, &ListBlobsInput{
+			Prefix:     PString(prefix),
+			StartAfter: startAfter,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Items {
+			if *item.Key != skip {
+				items = append(items, item)
+			}
+		}
+		if !resp.IsTruncated || len(resp.Items) == 0 {
+			break
+		}
+		startAfter = resp.Items[len(resp.Items)-1].Key
+	}
+	return items, nil
+}
+
+// listAllVersions returns all versions of objects under prefix, newest
+// first for every key
+func listAllVersions(flags *cfg.FlagStorage, versions VersionedBackend, prefix string) ([]BlobVersionOutput, error) {
+	var res []BlobVersionOutput
+	req := &ListBlobVersionsInput{Prefix: PString(prefix)}
+	for {
+		var resp *ListBlobVersionsOutput
+		err := ReadBackoff(flags, func(attempt int) (err error) {
+			resp, err = versions.ListBlobVersions(req)
+			if err != nil && shouldRetry(err) {
+				s3Log.Warnf("Error listing object versions with prefix=%v (attempt %v): %v",
+					prefix, attempt, err)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resp.Versions...)
+		if !resp.IsTruncated {
+			break
+		}
+		req.KeyMarker = resp.NextKeyMarker
+		req.VersionIdMarker = resp.NextVersionIdMarker
+	}
+	return res, nil
+}
+
+func sameListing(a, b []BlobItemOutput) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i].Key != *b[i].Key || NilStr(a[i].ETag) != NilStr(b[i].ETag) || a[i].Size != b[i].Size {
+			return false
+		}
+	}
+	return true
+}
+
+// SnapshotDirectory returns a consistent listing of all objects under prefix
+func SnapshotDirectory(flags *cfg.FlagStorage, cloud StorageBackend, prefix string) (*Snapshot, error) {
+	for attempt := 1; attempt <= snapshotAttempts; attempt++ {
+		marker := prefix + snapshotMarkerPrefix + RandStringBytesMaskImprSrc(16)
+		_, err := cloud.PutBlob(&PutBlobInput{Key: marker, Size: PUInt64(0)})
+		if err != nil {
+			return nil, mapAwsError(err)
+		}
+		snap, err := snapshotGeneration(flags, cloud, prefix, marker)
+		_, delErr := cloud.DeleteBlob(&DeleteBlobInput{Key: marker})
+		if delErr != nil {
+			log.Warnf("Failed to remove snapshot marker %v: %v", marker, delErr)
+		}
+		if err != nil || snap != nil {
+			if versions, ok := versionsOf(cloud); ok && snap != nil {
+				snap.pinVersions(flags, versions)
+			}
+			return snap, err
+		}
+		log.Infof("%v changed during snapshot, retrying (attempt %v)", prefix, attempt)
+	}
+	return nil, fmt.Errorf("%v changes too often to take a consistent snapshot", prefix)
+}
+
+// snapshotGeneration returns nil without error if the listing changes
+func snapshotGeneration(flags *cfg.FlagStorage, cloud StorageBackend, prefix, marker string) (*Snapshot, error) {
+	head, err := cloud.HeadBlob(&HeadBlobInput{Key: marker})
+	if err != nil {
+		return nil, mapAwsError(err)
+	}
+	var generation time.Time
+	if head.LastModified != nil {
+		generation = *head.LastModified
+	}
+	items, err := listAll(flags, cloud, prefix, marker)
+	if err != nil {
+		return nil, err
+	}
+	if !generation.IsZero() {
+		for _, item := range items {
+			if item.LastModified != nil && item.LastModified.After(generation) {
+				return nil, nil
+			}
+		}
+	}
+	check, err := listAll(flags, cloud, prefix, marker)
+	if err != nil {
+		return nil, err
+	}
+	if !sameListing(items, check) {
+		return nil, nil
+	}
+	return &Snapshot{Time: generation, Prefix: prefix, Items: items}, nil
+}
+
+// pinVersions finds the versions of snapshot items by their ETags. The
+// snapshot is still usable without them, so errors are only logged
+func (s *Snapshot) pinVersions(flags *cfg.FlagStorage, versions VersionedBackend) {
+	list, err := listAllVersions(flags, versions, s.Prefix)
+	if err != nil {
+		log.Warnf("Failed to list object versions of %v, the snapshot isn't pinned to them: %v", s.Prefix, err)
+		return
+	}
+	etags := make(map[string]string, len(s.Items))
+	for _, item := range s.Items {
+		etags[*item.Key] = NilStr(item.ETag)
+	}
+	s.VersionIds = make(map[string]string)
+	for _, v := range list {
+		etag, ok := etags[*v.Key]
+		// "null" is the version of objects written without versioning
+		if !ok || v.DeleteMarker || v.VersionId == "" || v.VersionId == "null" || NilStr(v.ETag) != etag {
+			continue
+		}
+		if _, ok := s.VersionIds[*v.Key]; !ok {
+			s.VersionIds[*v.Key] = v.VersionId
+		}
+	}
+}
+
+// WriteManifest writes the snapshot in the --key-manifest format
+func (s *Snapshot) WriteManifest(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# snapshot of %v at %v\n", s.Prefix, s.Time.UTC().Format(time.RFC3339))
+	for _, item := range s.Items {
+		mtime := ""
+		if item.LastModified != nil {
+			mtime = item.LastModified.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%v\t%v\t%v\t%v", *item.Key, item.Size, NilStr(item.ETag), mtime)
+		if version := s.VersionIds[*item.Key]; version != "" {
+			fmt.Fprintf(out, "\t%v", version)
+		}
+		fmt.Fprintf(out, "\n")
+	}
+	return out.Flush()
+}
+
+// ReadSnapshot reads a manifest written by WriteManifest or any other
+// --key-manifest file, name is used in errors
+func ReadSnapshot(r io.Reader, name string) (*Snapshot, error) {
+	snap := &Snapshot{VersionIds: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if header, ok := strings.CutPrefix(line, "# snapshot of "); ok {
+			if pos := strings.LastIndex(header, " at "); pos >= 0 {
+				snap.Prefix = header[0:pos]
+				snap.Time, _ = time.Parse(time.RFC3339, header[pos+4:])
+			}
+			continue
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || len(fields) > 5 || fields[0] == "" {
+			return nil, fmt.Errorf("%v:%v: expected key, size, [etag], [mtime], [version] separated with tabs", name, n)
+		}
+		item := BlobItemOutput{Key: PString(fields[0])}
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: invalid size %v", name, n, fields[1])
+		}
+		item.Size = size
+		if len(fields) > 2 && fields[2] != "" {
+			item.ETag = PString(fields[2])
+		}
+		if len(fields) > 3 && fields[3] != "" {
+			mtime, err := time.Parse(time.RFC3339, fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: invalid mtime %v", name, n, fields[3])
+			}
+			item.LastModified = &mtime
+		}
+		if len(fields) > 4 && fields[4] != "" {
+			snap.VersionIds[fields[0]] = fields[4]
+		}
+		snap.Items = append(snap.Items, item)
+	}
+	return snap, scanner.Err()
+}
+
+// Snapshot takes a snapshot of path, or of the whole bucket[:prefix] if
+// path is empty
+func (b *BulkOps) Snapshot(path string) (*Snapshot, error) {
+	prefix := b.prefix
+	if key := b.key(path); key != b.prefix {
+		prefix = key + "/"
+	}
+	return SnapshotDirectory(b.flags, b.cloud, prefix)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SnapshotTest struct{}
+
+var _ = Suite(&SnapshotTest{})
+
+func (s *SnapshotTest) TestSnapshotNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	b := &BulkOps{flags: m.fs.flags, cloud: m.Conn, prefix: "pre/", jobs: 1}
+
+	c.Store.Put("pre/run/a", []byte("a"), nil)
+	c.Store.Put("pre/run/sub/b", []byte("bb"), nil)
+	c.Store.Put("pre/runs", []byte("c"), nil)
+
+	snap, err := b.Snapshot("run")
+	t.Assert(err, IsNil)
+	t.Assert(snap.Prefix, Equals, "pre/run/")
+	t.Assert(len(snap.Items), Equals, 2)
+	// The generation marker is removed
+	t.Assert(c.Store.Keys(), DeepEquals, []string{"pre/run/a", "pre/run/sub/b", "pre/runs"})
+
+	var buf bytes.Buffer
+	t.Assert(snap.WriteManifest(&buf), IsNil)
+	manifest := filepath.Join(t.MkDir(), "manifest")
+	t.Assert(os.WriteFile(manifest, buf.Bytes(), 0600), IsNil)
+	items, err := readKeyManifest(manifest)
+	t.Assert(err, IsNil)
+	t.Assert(len(items), Equals, 2)
+	t.Assert(*items[1].Key, Equals, "pre/run/sub/b")
+	t.Assert(items[1].Size, Equals, uint64(2))
+	t.Assert(*items[1].ETag, Equals, *snap.Items[1].ETag)
+
+	// Objects newer than the generation marker were changed during the
+	// snapshot. Make one look like it's changed every time
+	c.Clock.Advance(time.Hour)
+	c.Store.Put("pre/run/a", []byte("a2"), nil)
+	c.Clock.Advance(-time.Hour)
+	_, err = b.Snapshot("run")
+	t.Assert(err, NotNil)
+	t.Assert(m.Conn.Calls("PutBlob"), Equals, 1+snapshotAttempts)
+	t.Assert(len(c.Store.Keys()), Equals, 3)
+}
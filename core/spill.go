@@ -0,0 +1,106 @@
+package core
+
+import (
+	"hash/crc32"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// With --spill-dirty, dirty buffers are written to the disk cache when the
+// memory limit is reached and there are no clean buffers left to evict, so
+// that bursts of writes faster than the uplink don't make the flusher upload
+// small parts early or block writers. Spilled buffers are split at part
+// boundaries and checksummed, then streamed from the disk when their part is
+// flushed. A part whose data doesn't match the checksum is never uploaded.
+
+// spillDirtyBuffers spills buffers of the oldest dirty inodes first and
+// returns the amount of freed memory
+// LOCKS_REQUIRED(fs.bufferPool.mu)
+func (fs *Goofys) spillDirtyBuffers(size int64) (freed int64) {
+	var inodeID, queueID uint64
+	for freed < size {
+		inodeID, queueID = fs.inodeQueue.Next(queueID)
+		if inodeID == 0 {
+			break
+		}
+		fs.mu.RLock()
+		inode := fs.inodes[fuseops.InodeID(inodeID)]
+		fs.mu.RUnlock()
+		if inode == nil {
+			continue
+		}
+		inode.mu.Lock()
+		spilled, ok := inode.spillDirty(size - freed)
+		inode.mu.Unlock()
+		freed += spilled
+		if !ok {
+			// Disk cache is not writable
+			break
+		}
+	}
+	return
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) canSpill(b *FileBuffer) bool {
+	return b.state == BUF_DIRTY && b.data != nil && b.ptr != nil && !b.loading &&
+		(!b.onDisk || b.checksum() != nil) &&
+		!inode.IsRangeLocked(b.offset, b.length, false)
+}
+
+// LOCKS_REQUIRED(inode.mu)
+// LOCKS_REQUIRED(fs.bufferPool.mu)
+func (inode *Inode) spillDirty(size int64) (freed int64, ok bool) {
+	fs := inode.fs
+	if inode.CacheState != ST_CREATED && inode.CacheState != ST_MODIFIED {
+		return 0, true
+	}
+	// Checksums are kept per buffer, so split buffers at part boundaries
+	// to not invalidate them when parts are flushed
+	var bounds, ends []uint64
+	inode.buffers.Ascend(0, func(end uint64, b *FileBuffer) (cont bool, changed bool) {
+		if inode.canSpill(b) {
+			for p := fs.partNum(b.offset); ; p++ {
+				partOffset, partSize := fs.partRange(p)
+				if partOffset+partSize >= end {
+					break
+				}
+				bounds = append(bounds, partOffset+partSize)
+			}
+		}
+		return true, false
+	})
+	for _, offset := range bounds {
+		inode.buffers.SplitAt(offset)
+	}
+	inode.buffers.Ascend(0, func(end uint64, b *FileBuffer) (cont bool, changed bool) {
+		if inode.canSpill(b) {
+			ends = append(ends, end)
+		}
+		return true, false
+	})
+	toFs := -1
+	for _, end := range ends {
+		if freed >= size {
+			break
+		}
+		buf := inode.buffers.Get(end)
+		if buf == nil || !inode.canSpill(buf) {
+			continue
+		}
+		if !buf.onDisk {
+			crc := crc32.Checksum(buf.data, crcTable)
+			fs.tryEvictToDisk(inode, buf, &toFs)
+			if !buf.onDisk {
+				return freed, false
+			}
+			buf.diskSum = &diskChecksum{offset: buf.offset, length: buf.length, crc: crc}
+		}
+		allocated, _ := inode.buffers.EvictFromMemory(buf)
+		if allocated != 0 {
+			fs.bufferPool.UseUnlocked(allocated, false)
+			freed -= allocated
+		}
+	}
+	return freed, true
+}
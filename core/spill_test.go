@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type SpillTest struct{}
+
+var _ = Suite(&SpillTest{})
+
+func spillAll(fs *Goofys, inode *Inode) int64 {
+	fs.bufferPool.mu.Lock()
+	defer fs.bufferPool.mu.Unlock()
+	inode.mu.Lock()
+	defer inode.mu.Unlock()
+	freed, _ := inode.spillDirty(1 << 40)
+	return freed
+}
+
+func (s *SpillTest) TestSpillDirtyNoCloud(t *C) {
+	cacheDir := t.MkDir()
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.CachePath = cacheDir
+		flags.SpillDirty = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	root := m.fs.getInodeOrDie(1)
+
+	// 2.5 parts, spilled buffers are split at part boundaries
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12*1024*1024/16+1000)
+	inode, fh, err := root.Create("big")
+	t.Assert(err, IsNil)
+	err = fh.WriteFile(0, data, true)
+	t.Assert(err, IsNil)
+	t.Assert(spillAll(m.fs, inode) > 0, Equals, true)
+	inode.mu.Lock()
+	spilled := 0
+	inode.buffers.Ascend(0, func(end uint64, b *FileBuffer) (cont bool, changed bool) {
+		if b.state == BUF_DIRTY && b.data == nil {
+			t.Assert(b.checksum(), NotNil)
+			t.Assert(m.fs.partNum(b.offset), Equals, m.fs.partNum(end-1))
+			spilled++
+		}
+		return true, false
+	})
+	inode.mu.Unlock()
+	t.Assert(spilled >= 3, Equals, true)
+
+	fh.Release()
+	err = inode.SyncFile()
+	t.Assert(err, IsNil)
+	stored, ok := c.Store.Get("big")
+	t.Assert(ok, Equals, true)
+	t.Assert(bytes.Equal(stored, data), Equals, true)
+
+	// Corrupted spilled data is never returned. Keep the inode locked
+	// so that the flusher doesn't load it back in the meantime
+	inode, fh, err = root.Create("small")
+	t.Assert(err, IsNil)
+	defer fh.Release()
+	err = fh.WriteFile(0, []byte("small file data"), true)
+	t.Assert(err, IsNil)
+	m.fs.bufferPool.mu.Lock()
+	inode.mu.Lock()
+	freed, _ := inode.spillDirty(1 << 40)
+	m.fs.bufferPool.mu.Unlock()
+	t.Assert(freed > 0, Equals, true)
+	err = os.WriteFile(cacheDir+"/small", []byte("smell file data"), 0600)
+	t.Assert(err, IsNil)
+
+	reader, _, file, err := inode.getDiskMultiReader(0, 15)
+	t.Assert(err, IsNil)
+	_, err = io.ReadAll(reader)
+	file.Close()
+	t.Assert(err, Equals, ErrChecksumMismatch)
+	_, err = inode.LoadRange(0, 15, 0, false)
+	t.Assert(err, Equals, syscall.EIO)
+	inode.mu.Unlock()
+
+	// The flush fails instead of being retried silently
+	t.Assert(inode.SyncFile(), Equals, syscall.EIO)
+	_, ok = c.Store.Get("small")
+	t.Assert(ok, Equals, false)
+}
+
+// rangeLockConn records whether uploaded parts are guarded against spills
+type rangeLockConn struct {
+	*SimConn
+	inode  *Inode
+	locked []bool
+}
+
+func (c *rangeLockConn) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	c.inode.mu.Lock()
+	c.locked = append(c.locked, c.inode.IsRangeLocked(param.Offset, param.Size, false))
+	c.inode.mu.Unlock()
+	return c.SimConn.MultipartBlobAdd(param)
+}
+
+func (s *SpillTest) TestSpilledPartLockedNoCloud(t *C) {
+	flags := cfg.DefaultFlags()
+	flags.CachePath = t.MkDir()
+	flags.SpillDirty = true
+	conn := &rangeLockConn{SimConn: NewSimConn(NewSimStore(NewSimClock()))}
+	fs, err := newGoofys(context.Background(), "sim", flags, func(string, *cfg.FlagStorage) (StorageBackend, error) {
+		return conn, nil
+	})
+	t.Assert(err, IsNil)
+	defer fs.Shutdown()
+	root := fs.getInodeOrDie(1)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12*1024*1024/16)
+	inode, fh, err := root.Create("big")
+	t.Assert(err, IsNil)
+	defer fh.Release()
+	conn.inode = inode
+	t.Assert(fh.WriteFile(0, data, true), IsNil)
+	t.Assert(spillAll(fs, inode) > 0, Equals, true)
+
+	// Parts flushed synchronously stay locked while they're streamed from the disk
+	cloud, key := inode.cloud()
+	inode.mu.Lock()
+	params := inode.multipartBeginInput(key)
+	inode.mu.Unlock()
+	inode.beginMultipartUpload(cloud, params)
+	t.Assert(inode.mpu, NotNil)
+	t.Assert(inode.syncFlushPartsUpTo(2), Equals, true)
+	t.Assert(len(conn.locked) >= 2, Equals, true)
+	for _, locked := range conn.locked {
+		t.Assert(locked, Equals, true)
+	}
+	inode.abortMultipart()
+	inode.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"io"
+	"syscall"
+)
+
+// Reads from a --pin-etag handle of an object which was replaced after open
+// are handled according to --stale-handle:
+//
+//   - estale: the read fails with ESTALE once, then the handle continues
+//     with the new version of the object
+//   - version: the handle keeps reading the version it has opened with
+//     versioned GETs, bypassing the cache which now holds the new version.
+//     The version is found by its ETag in the list of object versions, if
+//     it's already removed, the read fails like with estale
+
+// LOCKS_REQUIRED(fh.inode.mu)
+func (fh *FileHandle) staleRead(offset, size uint64) (data [][]byte, bytesRead int, err error) {
+	if fh.inode.fs.flags.StaleHandle == "version" {
+		fh.inode.mu.Unlock()
+		data, bytesRead, err = fh.readVersion(offset, size)
+		fh.inode.mu.Lock()
+		if err != syscall.ESTALE {
+			return
+		}
+	}
+	fh.remoteGen = fh.inode.remoteGen
+	fh.etag = fh.inode.knownETag
+	fh.versionId = nil
+	return nil, 0, syscall.ESTALE
+}
+
+// findVersion finds the version of the object with the ETag pinned at open
+func (fh *FileHandle) findVersion(cloud StorageBackend, key string) error {
+	versions, ok := versionsOf(cloud)
+	if !ok {
+		return syscall.ESTALE
+	}
+	req := &ListBlobVersionsInput{Prefix: PString(key)}
+	for {
+		var resp *ListBlobVersionsOutput
+		err := ReadBackoff(fh.inode.fs.flags, func(attempt int) (err error) {
+			resp, err = versions.ListBlobVersions(req)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, v := range resp.Versions {
+			if *v.Key == key && !v.DeleteMarker && NilStr(v.ETag) == fh.etag {
+				fh.versionId = PString(v.VersionId)
+				fh.versionSize = v.Size
+				return nil
+			}
+		}
+		if !resp.IsTruncated {
+			return syscall.ESTALE
+		}
+		req.KeyMarker = resp.NextKeyMarker
+		req.VersionIdMarker = resp.NextVersionIdMarker
+	}
+}
+
+// LOCKS_EXCLUDED(fh.inode.mu)
+func (fh *FileHandle) readVersion(offset, size uint64) (data [][]byte, bytesRead int, err error) {
+	cloud, key := fh.inode.cloud()
+	if fh.versionId == nil {
+		if fh.etag == "" {
+			return nil, 0, syscall.ESTALE
+		}
+		err = fh.findVersion(cloud, key)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if offset >= fh.versionSize {
+		return nil, 0, nil
+	}
+	if offset+size > fh.versionSize {
+		size = fh.versionSize - offset
+	}
+	buf := make([]byte, size)
+	err = ReadBackoff(fh.inode.fs.flags, func(attempt int) error {
+		resp, err := cloud.GetBlob(&GetBlobInput{
+			Key:       key,
+			Start:     offset,
+			Count:     size,
+			VersionId: fh.versionId,
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadFull(resp.Body, buf)
+		return err
+	})
+	if err != nil {
+		if mapAwsError(err) == syscall.ENOENT {
+			// The version is removed
+			err = syscall.ESTALE
+		}
+		return nil, 0, err
+	}
+	return [][]byte{buf}, int(size), nil
+}
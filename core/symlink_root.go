@@ -0,0 +1,47 @@
+package core
+
+import (
+	"path"
+	"strings"
+)
+
+// Absolute symlink targets pointing inside the mountpoint break when the
+// bucket is mounted at another path or with another prefix. With
+// --symlink-root-attr, such targets are additionally stored relative to the
+// bucket root, and translated back to the current mountpoint and prefix at
+// readlink time. The original target is still stored in --symlink-attr for
+// other clients.
+
+// symlinkRootPath returns the target relative to the bucket root, starting
+// with "/", or "" if the target doesn't point inside the mountpoint
+func (fs *Goofys) symlinkRootPath(target string) string {
+	mnt := fs.mountPoint
+	if mnt == "" || !path.IsAbs(target) {
+		return ""
+	}
+	target = path.Clean(target)
+	if target != mnt && !strings.HasPrefix(target, strings.TrimSuffix(mnt, "/")+"/") {
+		return ""
+	}
+	rel := strings.TrimPrefix(target[len(mnt):], "/")
+	return path.Clean("/" + fs.getInodeOrDie(1).dir.mountPrefix + rel)
+}
+
+// translateSymlinkRoot converts a path relative to the bucket root to an
+// absolute path under the current mountpoint, if it's inside the mounted prefix
+func (fs *Goofys) translateSymlinkRoot(rootPath string) (string, bool) {
+	if fs.mountPoint == "" {
+		return "", false
+	}
+	key := strings.TrimPrefix(rootPath, "/")
+	prefix := fs.getInodeOrDie(1).dir.mountPrefix
+	if !strings.HasPrefix(key+"/", prefix) {
+		return "", false
+	}
+	if len(key) < len(prefix) {
+		key = ""
+	} else {
+		key = key[len(prefix):]
+	}
+	return path.Join(fs.mountPoint, key), true
+}
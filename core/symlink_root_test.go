@@ -0,0 +1,68 @@
+package core
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type SymlinkRootTest struct{}
+
+var _ = Suite(&SymlinkRootTest{})
+
+func (s *SymlinkRootTest) TestSymlinkRootNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.MountPoint = "/mnt/a"
+		flags.SymlinkRootAttr = "--symlink-root"
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	fs := m.fs
+	t.Assert(fs.mountPoint, Equals, "/mnt/a")
+
+	t.Assert(fs.symlinkRootPath("relative/target"), Equals, "")
+	t.Assert(fs.symlinkRootPath("/mnt/ab/x"), Equals, "")
+	t.Assert(fs.symlinkRootPath("/mnt/a"), Equals, "/")
+	t.Assert(fs.symlinkRootPath("/mnt/a/exp1/./run/x"), Equals, "/exp1/run/x")
+
+	root := fs.getInodeOrDie(1)
+	link, err := root.CreateSymlink("link", "/mnt/a/exp1/run/x")
+	t.Assert(err, IsNil)
+	outside, err := root.CreateSymlink("outside", "/etc/passwd")
+	t.Assert(err, IsNil)
+	err = fs.SyncTree(nil)
+	t.Assert(err, IsNil)
+	head, err := m.Conn.HeadBlob(&HeadBlobInput{Key: "link"})
+	t.Assert(err, IsNil)
+	t.Assert(*head.Metadata["--symlink-root"], Equals, "/exp1/run/x")
+	t.Assert(*head.Metadata["--symlink-target"], Equals, "/mnt/a/exp1/run/x")
+
+	// The root path isn't a user xattr
+	_, err = link.GetXattr("user.--symlink-root")
+	t.Assert(err, Equals, ENOATTR)
+	t.Assert(link.SetXattr("user.--symlink-root", []byte("/x"), 0), IsNil)
+	t.Assert(string(link.userMetadata["--symlink-root"]), Equals, "/exp1/run/x")
+	xattrs, err := link.ListXattr()
+	t.Assert(err, IsNil)
+	for _, name := range xattrs {
+		t.Assert(name, Not(Equals), "user.--symlink-root")
+	}
+
+	// Same bucket mounted elsewhere with a prefix
+	fs.mountPoint = "/data/exp1"
+	root.dir.mountPrefix = "exp1/"
+	target, err := link.ReadSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(target, Equals, "/data/exp1/run/x")
+	target, err = outside.ReadSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(target, Equals, "/etc/passwd")
+
+	// Target outside of the mounted prefix is returned as is
+	root.dir.mountPrefix = "exp2/"
+	target, err = link.ReadSymlink()
+	t.Assert(err, IsNil)
+	t.Assert(target, Equals, "/mnt/a/exp1/run/x")
+	root.dir.mountPrefix = ""
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Internal temporary objects are stored under --temp-prefix relative to the
+// mount root. They are never shown to the user and the ones abandoned by
+// crashed mounts are removed on mount if --temp-cleanup-age is set.
+
+func (fs *Goofys) isTempKey(key string) bool {
+	return fs.tempPrefix != "" && strings.HasPrefix(key, fs.tempPrefix)
+}
+
+// Names of internal temporary objects, as in <temp-prefix><name>...
+var tempKeyNames = []string{"probe.", "lease.", renameJournalName + ".", writeIntentName, invalLogName}
+
+// foreignTempKeys returns up to limit keys under --temp-prefix which weren't
+// created by GeeseFS. They're hidden from listings and lookups like ours,
+// which may be a surprise if the bucket had them before the prefix was used.
+func (fs *Goofys) foreignTempKeys(cloud StorageBackend, limit int) ([]string, error) {
+	resp, err := RetryListBlobs(fs.flags, cloud, &ListBlobsInput{
+		Prefix:  PString(fs.tempPrefix),
+		MaxKeys: PUInt32(1000),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, item := range resp.Items {
+		name := (*item.Key)[len(fs.tempPrefix):]
+		ours := false
+		for _, prefix := range tempKeyNames {
+			if strings.HasPrefix(name, prefix) {
+				ours = true
+				break
+			}
+		}
+		if !ours && len(keys) < limit {
+			keys = append(keys, *item.Key)
+		}
+	}
+	return keys, nil
+}
+
+func (fs *Goofys) checkTempKeys(cloud StorageBackend) {
+	keys, err := fs.foreignTempKeys(cloud, 5)
+	if err != nil {
+		log.Warnf("Failed to list temporary objects under %v: %v", fs.tempPrefix, err)
+		return
+	}
+	if len(keys) > 0 {
+		log.Warnf("Objects under --temp-prefix %v which weren't created by GeeseFS are hidden"+
+			" from listings and lookups: %v. Use another --temp-prefix to see them",
+			fs.tempPrefix, strings.Join(keys, ", "))
+	}
+}
+
+// newTempKey returns a unique key for a new temporary object
+func (fs *Goofys) newTempKey(name string) string {
+	return fs.tempPrefix + name + "." + RandStringBytesMaskImprSrc(16)
+}
+
+func (fs *Goofys) cleanupTempKeys(cloud StorageBackend) {
+	cutoff := clock.Now().Add(-fs.flags.TempCleanupAge)
+	var startAfter *string
+	removed := 0
+	for atomic.LoadInt32(&fs.shutdown) == 0 {
+		resp, err := RetryListBlobs(fs.flags, cloud, &ListBlobsInput{
+			Prefix:     PString(fs.tempPrefix),
+			StartAfter: startAfter,
+		})
+		if err != nil {
+			log.Warnf("Failed to list temporary objects under %v: %v", fs.tempPrefix, err)
+			return
+		}
+		var keys []string
+		for _, item := range resp.Items {
+			// Leases may be held for longer than the cleanup age, removing
+			// them would let two clients hold the same lease. Rename journals
+			// are removed by repair-renames
+			if strings.HasPrefix(*item.Key, fs.tempPrefix+"lease.") ||
+				strings.HasPrefix(*item.Key, fs.tempPrefix+renameJournalName+".") {
+				continue
+			}
+			if item.LastModified != nil && item.LastModified.Before(cutoff) {
+				keys = append(keys, *item.Key)
+			}
+		}
+		if len(keys) > 0 {
+			_, err = cloud.DeleteBlobs(&DeleteBlobsInput{Items: keys})
+			if err != nil {
+				log.Warnf("Failed to remove temporary objects under %v: %v", fs.tempPrefix, err)
+				return
+			}
+			removed += len(keys)
+		}
+		if !resp.IsTruncated || len(resp.Items) == 0 {
+			break
+		}
+		// NextContinuationToken is not returned when delimiter is empty
+		startAfter = resp.Items[len(resp.Items)-1].Key
+	}
+	if removed > 0 {
+		log.Infof("Removed %v abandoned temporary objects under %v", removed, fs.tempPrefix)
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type TempKeysTest struct{}
+
+var _ = Suite(&TempKeysTest{})
+
+func (s *TempKeysTest) TestTempKeysHiddenNoCloud(t *C) {
+	c, err := NewSimCluster(1, nil)
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("file", []byte("data"), nil)
+	c.Store.Put(".geesefs_tmp/old", []byte("old"), nil)
+	// Objects in SimStore are dated 2020, so move the clock to the future
+	c.Clock.Advance(time.Since(c.Clock.Now()) + time.Hour)
+	c.Store.Put(".geesefs_tmp/new", []byte("new"), nil)
+
+	_, err = m.fs.LookupPath(".geesefs_tmp")
+	t.Assert(err, Equals, syscall.ENOENT)
+
+	dh := m.fs.getInodeOrDie(1).OpenDir()
+	dh.mu.Lock()
+	var names []string
+	for {
+		en, err := dh.ReadDir()
+		t.Assert(err, IsNil)
+		if en == nil {
+			break
+		}
+		if dh.lastInternalOffset >= 2 {
+			names = append(names, en.Name)
+		}
+		dh.Next(en.Name)
+	}
+	dh.mu.Unlock()
+	dh.CloseDir()
+	t.Assert(names, DeepEquals, []string{"file"})
+
+	// User objects under the prefix are reported
+	c.Store.Put(".geesefs_tmp/lease.x", []byte{}, nil)
+	foreign, err := m.fs.foreignTempKeys(m.Conn, 1)
+	t.Assert(err, IsNil)
+	t.Assert(foreign, DeepEquals, []string{".geesefs_tmp/new"})
+
+	m.fs.flags.TempCleanupAge = time.Minute
+	m.fs.cleanupTempKeys(m.Conn)
+	t.Assert(c.Store.Keys(), DeepEquals, []string{".geesefs_tmp/lease.x", ".geesefs_tmp/new", "file"})
+}
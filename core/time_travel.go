@@ -0,0 +1,339 @@
+package core
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// With --time-travel, every directory of a versioned bucket has a hidden
+// virtual .geesefs subdirectory, and .geesefs/@<time> shows the directory as
+// it was at the given time. Views are read-only and resolved from a listing
+// of all object versions under the directory, which is done once per view
+// and kept in memory. Views are not listed in the parent directory, but
+// .geesefs lists the views which were already opened. A real .geesefs
+// directory in the bucket takes precedence.
+//
+// The time may be given as RFC 3339 (@2024-05-01T12:00:00Z), as local time
+// (@2024-05-01T12:00:00 or @2024-05-01) or as Unix time (@1714564800).
+
+const timeTravelDirName = ".geesefs"
+
+func parseTimeTravel(name string) (time.Time, bool) {
+	if len(name) < 2 || name[0] != '@' {
+		return time.Time{}, false
+	}
+	s := name[1:]
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// timeTravelBackend is a read-only view of objects under prefix as they
+// were at the given time
+type timeTravelBackend struct {
+	cloud    StorageBackend
+	versions VersionedBackend
+	flags    *cfg.FlagStorage
+	prefix   string
+	at       time.Time
+
+	mu         sync.Mutex
+	loaded     bool
+	items      []BlobItemOutput
+	versionIds map[string]string
+}
+
+func (t *timeTravelBackend) load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.loaded {
+		return nil
+	}
+	list, err := listAllVersions(t.flags, t.versions, t.prefix)
+	if err != nil {
+		return err
+	}
+	latest := make(map[string]BlobVersionOutput)
+	for _, v := range list {
+		if v.LastModified == nil || v.LastModified.After(t.at) {
+			continue
+		}
+		// Versions of a key are listed newest first
+		if prev, ok := latest[*v.Key]; ok && !prev.LastModified.Before(*v.LastModified) {
+			continue
+		}
+		latest[*v.Key] = v
+	}
+	t.versionIds = make(map[string]string, len(latest))
+	for key, v := range latest {
+		if !v.DeleteMarker {
+			t.items = append(t.items, v.BlobItemOutput)
+			t.versionIds[key] = v.VersionId
+		}
+	}
+	sort.Sort(sortBlobItemOutput(t.items))
+	t.loaded = true
+	log.Infof("Loaded %v objects of %v as of %v", len(t.items), t.prefix, t.at)
+	return nil
+}
+
+func (t *timeTravelBackend) find(key string) (*BlobItemOutput, error) {
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(t.items), func(i int) bool { return *t.items[i].Key >= key })
+	if i >= len(t.items) || *t.items[i].Key != key {
+		return nil, syscall.ENOENT
+	}
+	return &t.items[i], nil
+}
+
+func (t *timeTravelBackend) Init(key string) error {
+	return nil
+}
+
+func (t *timeTravelBackend) Capabilities() *Capabilities {
+	return t.cloud.Capabilities()
+}
+
+func (t *timeTravelBackend) Bucket() string {
+	return t.cloud.Bucket()
+}
+
+func (t *timeTravelBackend) Delegate() interface{} {
+	return t
+}
+
+func (t *timeTravelBackend) HeadBlob(param *HeadBlobInput) (*HeadBlobOutput, error) {
+	item, err := t.find(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &HeadBlobOutput{
+		BlobItemOutput: *item,
+		IsDirBlob:      strings.HasSuffix(param.Key, "/"),
+	}, nil
+}
+
+func (t *timeTravelBackend) ListBlobs(param *ListBlobsInput) (*ListBlobsOutput, error) {
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return listSortedItems(t.items, param), nil
+}
+
+// listSortedItems lists a sorted in-memory set of objects like ListBlobs
+func listSortedItems(items []BlobItemOutput, param *ListBlobsInput) *ListBlobsOutput {
+	prefix, delim, after := NilStr(param.Prefix), NilStr(param.Delimiter), NilStr(param.StartAfter)
+	if param.ContinuationToken != nil && *param.ContinuationToken > after {
+		after = *param.ContinuationToken
+	}
+	maxKeys := 1000
+	if param.MaxKeys != nil && *param.MaxKeys < 1000 {
+		maxKeys = int(*param.MaxKeys)
+	}
+	start := prefix
+	if after > start {
+		start = after
+	}
+	res := &ListBlobsOutput{}
+	last := ""
+	for i := sort.Search(len(items), func(i int) bool { return *items[i].Key >= start }); i < len(items); i++ {
+		key := *items[i].Key
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if key <= after || key <= last {
+			// skip keys rolled up into the last common prefix
+			continue
+		}
+		if len(res.Items)+len(res.Prefixes) >= maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = PString(last)
+			break
+		}
+		if delim != "" {
+			if pos := strings.Index(key[len(prefix):], delim); pos >= 0 {
+				common := key[0 : len(prefix)+pos+len(delim)]
+				res.Prefixes = append(res.Prefixes, BlobPrefixOutput{Prefix: PString(common)})
+				last = common + "\xFF"
+				continue
+			}
+		}
+		res.Items = append(res.Items, items[i])
+		last = key
+	}
+	return res
+}
+
+func (t *timeTravelBackend) GetBlob(param *GetBlobInput) (*GetBlobOutput, error) {
+	_, err := t.find(param.Key)
+	if err != nil {
+		return nil, err
+	}
+	get := *param
+	get.VersionId = PString(t.versionIds[param.Key])
+	return t.cloud.GetBlob(&get)
+}
+
+func (t *timeTravelBackend) DeleteBlob(param *DeleteBlobInput) (*DeleteBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) DeleteBlobs(param *DeleteBlobsInput) (*DeleteBlobsOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) RenameBlob(param *RenameBlobInput) (*RenameBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) CopyBlob(param *CopyBlobInput) (*CopyBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) PutBlob(param *PutBlobInput) (*PutBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) PatchBlob(param *PatchBlobInput) (*PatchBlobOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartBlobBegin(param *MultipartBlobBeginInput) (*MultipartBlobCommitInput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartBlobAdd(param *MultipartBlobAddInput) (*MultipartBlobAddOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartBlobCopy(param *MultipartBlobCopyInput) (*MultipartBlobCopyOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartBlobAbort(param *MultipartBlobCommitInput) (*MultipartBlobAbortOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartBlobCommit(param *MultipartBlobCommitInput) (*MultipartBlobCommitOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MultipartExpire(param *MultipartExpireInput) (*MultipartExpireOutput, error) {
+	return &MultipartExpireOutput{}, nil
+}
+
+func (t *timeTravelBackend) RemoveBucket(param *RemoveBucketInput) (*RemoveBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+func (t *timeTravelBackend) MakeBucket(param *MakeBucketInput) (*MakeBucketOutput, error) {
+	return nil, syscall.EROFS
+}
+
+// inTimeTravel reports if the inode is a read-only time travel view or
+// is inside one
+func (inode *Inode) inTimeTravel() bool {
+	if !inode.fs.flags.TimeTravel {
+		return false
+	}
+	if inode.dir != nil && inode.dir.timeTravel {
+		return true
+	}
+	cloud, _ := inode.cloud()
+	_, ok := cloud.(*timeTravelBackend)
+	return ok
+}
+
+// lookUpTimeTravel returns the .geesefs directory or a view in it, if
+// name refers to one
+// LOCKS_EXCLUDED(parent.mu)
+func (parent *Inode) lookUpTimeTravel(name string) (inode *Inode, ok bool, err error) {
+	if parent.dir.timeTravel {
+		inode, err = parent.timeTravelView(name)
+		return inode, true, err
+	}
+	if name != timeTravelDirName || parent.inTimeTravel() {
+		return nil, false, nil
+	}
+	fs := parent.fs
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	inode = parent.findChildUnlocked(name)
+	if inode != nil {
+		return inode, inode.dir != nil && inode.dir.timeTravel, nil
+	}
+	inode = NewInode(fs, parent, name)
+	inode.ToDir()
+	inode.dir.timeTravel = true
+	inode.dir.DirTime = TIME_MAX
+	inode.SetAttrTime(TIME_MAX)
+	inode.userMetadata = make(map[string][]byte)
+	inode.Attributes.Mode = os.ModeDir | fs.flags.DirMode&0555
+	fs.insertInode(parent, inode)
+	return inode, true, nil
+}
+
+// LOCKS_EXCLUDED(dir.mu)
+func (dir *Inode) timeTravelView(name string) (*Inode, error) {
+	dir.mu.Lock()
+	inode := dir.findChildUnlocked(name)
+	dir.mu.Unlock()
+	if inode != nil {
+		return inode, nil
+	}
+	at, ok := parseTimeTravel(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	cloud, key := dir.Parent.cloud()
+	versions, ok := versionsOf(cloud)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+	if key != "" {
+		key += "/"
+	}
+	dir.fs.mount(dir, &Mount{
+		name: name,
+		cloud: &timeTravelBackend{
+			cloud:    cloud,
+			versions: versions,
+			flags:    dir.fs.flags,
+			prefix:   key,
+			at:       at,
+		},
+		prefix: key,
+	})
+	dir.mu.Lock()
+	inode = dir.findChildUnlocked(name)
+	dir.mu.Unlock()
+	if inode == nil {
+		return nil, syscall.ENOENT
+	}
+	inode.mu.Lock()
+	inode.Attributes.Mode = os.ModeDir | dir.fs.flags.DirMode&0555
+	inode.Attributes.Mtime = at
+	inode.Attributes.Ctime = at
+	inode.mu.Unlock()
+	return inode, nil
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type TimeTravelTest struct{}
+
+var _ = Suite(&TimeTravelTest{})
+
+func (s *TimeTravelTest) TestTimeTravelNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.TimeTravel = true
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("run/a", []byte("v1"), nil)
+	c.Store.Put("run/b", []byte("b"), nil)
+	c.Store.Put("run/sub/d", []byte("d"), nil)
+	before := c.Clock.Now()
+	c.Clock.Advance(time.Hour)
+	c.Store.Put("run/a", []byte("v2"), nil)
+	c.Store.delete("run/b")
+	c.Store.Put("run/c", []byte("c"), nil)
+
+	view := "run/.geesefs/@" + before.Format(time.RFC3339)
+	dir, err := m.fs.LookupPath(view)
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, dir), DeepEquals, []string{"a", "b", "sub"})
+	data, err := m.ReadFile(view + "/a")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "v1")
+	data, err = m.ReadFile(view + "/sub/d")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "d")
+	data, err = m.ReadFile("run/a")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "v2")
+
+	// Views are read-only and hidden from listings
+	_, err = m.WriteFile(view+"/new", []byte("x"))
+	t.Assert(err, Equals, syscall.EROFS)
+	t.Assert(dir.Unlink("a"), Equals, syscall.EROFS)
+	run, err := m.fs.LookupPath("run")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, run), DeepEquals, []string{"a", "c", "sub"})
+	geesefs, err := m.fs.LookupPath("run/.geesefs")
+	t.Assert(err, IsNil)
+	t.Assert(listDir(t, geesefs), DeepEquals, []string{"@" + before.Format(time.RFC3339)})
+
+	// Unix time, resolved once per view
+	calls := m.Conn.Calls("ListBlobVersions")
+	view = fmt.Sprintf("run/.geesefs/@%v", before.Add(30*time.Minute).Unix())
+	data, err = m.ReadFile(view + "/b")
+	t.Assert(err, IsNil)
+	t.Assert(string(data), Equals, "b")
+	_, err = m.fs.LookupPath(view + "/c")
+	t.Assert(err, Equals, syscall.ENOENT)
+	t.Assert(m.Conn.Calls("ListBlobVersions"), Equals, calls+1)
+
+	_, err = m.fs.LookupPath("run/.geesefs/@yesterday")
+	t.Assert(err, Equals, syscall.ENOENT)
+}
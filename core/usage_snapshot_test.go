@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type UsageSnapshotTest struct{}
+
+var _ = Suite(&UsageSnapshotTest{})
+
+func (s *UsageSnapshotTest) TestUsagePrefix(t *C) {
+	t.Assert(usagePrefix("file", 1), Equals, "")
+	t.Assert(usagePrefix("exp1/file", 1), Equals, "exp1/")
+	t.Assert(usagePrefix("exp1/run1/file", 1), Equals, "exp1/")
+	t.Assert(usagePrefix("exp1/run1/file", 2), Equals, "exp1/run1/")
+	t.Assert(usagePrefix("exp1/file", 2), Equals, "exp1/")
+	t.Assert(usagePrefix("exp1/", 1), Equals, "exp1/")
+}
+
+func (s *UsageSnapshotTest) TestUsageSnapshotNoCloud(t *C) {
+	path := filepath.Join(t.MkDir(), "usage")
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.UsageSnapshot = path
+		flags.UsageDepth = 1
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+
+	c.Store.Put("top", []byte("1"), nil)
+	c.Store.Put("exp1/a", []byte("22"), nil)
+	c.Store.Put("exp1/run/b", []byte("333"), nil)
+	c.Store.Put("exp2/c", []byte("4444"), nil)
+	c.Store.Put(m.fs.tempPrefix+"tmp", []byte("55555"), nil)
+
+	usage, err := m.fs.takeUsageSnapshot(m.Conn, "")
+	t.Assert(err, IsNil)
+	t.Assert(len(usage), Equals, 3)
+	t.Assert(usage[0].Prefix, Equals, "")
+	t.Assert(usage[0].Objects, Equals, uint64(1))
+	t.Assert(usage[1].Prefix, Equals, "exp1/")
+	t.Assert(usage[1].Objects, Equals, uint64(2))
+	t.Assert(usage[1].Bytes, Equals, uint64(5))
+	t.Assert(usage[2].Prefix, Equals, "exp2/")
+	t.Assert(usage[2].Bytes, Equals, uint64(4))
+
+	err = appendUsageSnapshot(path, usage)
+	t.Assert(err, IsNil)
+	file, err := os.Open(path)
+	t.Assert(err, IsNil)
+	defer file.Close()
+	var lines []PrefixUsage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var u PrefixUsage
+		t.Assert(json.Unmarshal(scanner.Bytes(), &u), IsNil)
+		lines = append(lines, u)
+	}
+	t.Assert(len(lines), Equals, 3)
+	t.Assert(lines[1].Prefix, Equals, "exp1/")
+}
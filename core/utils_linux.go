@@ -15,6 +15,9 @@
 package core
 
 import (
+	"fmt"
+	"syscall"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -22,4 +25,15 @@ const (
 	XATTR_CREATE  = unix.XATTR_CREATE
 	XATTR_REPLACE = unix.XATTR_REPLACE
 	ENOATTR       = unix.ENODATA
+
+	O_SYNC    = unix.O_SYNC
+	O_DSYNC   = unix.O_DSYNC
+	O_NOATIME = unix.O_NOATIME
 )
+
+func errnoName(e syscall.Errno) string {
+	if name := unix.ErrnoName(e); name != "" {
+		return name
+	}
+	return fmt.Sprintf("errno %d", int(e))
+}
@@ -17,6 +17,9 @@
 package core
 
 import (
+	"fmt"
+	"syscall"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -24,4 +27,16 @@ const (
 	XATTR_CREATE  = unix.XATTR_CREATE
 	XATTR_REPLACE = unix.XATTR_REPLACE
 	ENOATTR       = unix.ENOATTR
+
+	O_SYNC  = unix.O_SYNC
+	O_DSYNC = unix.O_DSYNC
+	// not supported
+	O_NOATIME = 0
 )
+
+func errnoName(e syscall.Errno) string {
+	if name := unix.ErrnoName(e); name != "" {
+		return name
+	}
+	return fmt.Sprintf("errno %d", int(e))
+}
@@ -15,6 +15,7 @@
 package core
 
 import (
+	"fmt"
 	"syscall"
 )
 
@@ -22,4 +23,13 @@ const (
 	XATTR_CREATE  = 0x1
 	XATTR_REPLACE = 0x2
 	ENOATTR       = syscall.ENODATA
+
+	// not passed by WinFsp
+	O_SYNC    = 0
+	O_DSYNC   = 0
+	O_NOATIME = 0
 )
+
+func errnoName(e syscall.Errno) string {
+	return fmt.Sprintf("errno %d", int(e))
+}
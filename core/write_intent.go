@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// With --write-intent, a mount writes a short-lived marker object before
+// flushing a modified file:
+//
+//	<temp-prefix>intent.<hash of the key>.<mount id>
+//
+// and checks markers of other mounts for the same key. A marker younger than
+// --write-intent-ttl means that another mount is writing the same object at
+// the moment, and one of the writes will be lost. With --write-intent=warn the
+// conflict is logged, with --write-intent=ebusy the flush fails with EBUSY
+// (returned from fsync) and is retried later. When both mounts see each
+// other's markers only the later one fails, so one of them always proceeds.
+//
+// Markers are compared by their server modification times, so clocks of
+// different clients don't have to agree. They are removed after the flush,
+// including failed and aborted ones, markers left by crashed mounts expire after the TTL and are removed by
+// --temp-cleanup-age.
+
+const writeIntentName = "intent."
+
+type WriteIntents struct {
+	fs      *Goofys
+	cloud   StorageBackend
+	prefix  string
+	mountId string
+	ttl     time.Duration
+	ebusy   bool
+}
+
+func (fs *Goofys) newWriteIntents(cloud StorageBackend) *WriteIntents {
+	host, _ := os.Hostname()
+	return &WriteIntents{
+		fs:      fs,
+		cloud:   cloud,
+		prefix:  fs.tempPrefix + writeIntentName,
+		mountId: fmt.Sprintf("%v-%v-%v", host, os.Getpid(), RandStringBytesMaskImprSrc(8)),
+		ttl:     fs.flags.WriteIntentTTL,
+		ebusy:   fs.flags.WriteIntent == "ebusy",
+	}
+}
+
+// keyPrefix returns the common prefix of markers of all mounts for key
+func (w *WriteIntents) keyPrefix(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return w.prefix + hex.EncodeToString(sum[:16]) + "."
+}
+
+// Begin writes our marker for key and checks markers of other mounts.
+// Returns EBUSY if another mount writes key and the policy is ebusy. Failures
+// to write or list markers don't prevent the flush. Does nothing when
+// --write-intent is disabled and w is nil
+func (w *WriteIntents) Begin(key string) error {
+	if w == nil {
+		return nil
+	}
+	prefix := w.keyPrefix(key)
+	marker := prefix + w.mountId
+	resp, err := w.cloud.PutBlob(&PutBlobInput{
+		Key:  marker,
+		Body: bytes.NewReader([]byte{}),
+		Size: PUInt64(0),
+	})
+	if err != nil {
+		log.Warnf("Failed to write intent marker for %v: %v", key, err)
+		return nil
+	}
+	ours := clock.Now()
+	if resp.LastModified != nil {
+		ours = *resp.LastModified
+	}
+	items, err := listAll(w.fs.flags, w.cloud, prefix, "")
+	if err != nil {
+		log.Warnf("Failed to check intent markers for %v: %v", key, err)
+		return nil
+	}
+	yield := false
+	var others []string
+	for _, item := range items {
+		if *item.Key == marker || item.LastModified == nil {
+			continue
+		}
+		theirs := *item.LastModified
+		if ours.Sub(theirs) >= w.ttl {
+			// Left by a crashed mount or by a very long flush
+			continue
+		}
+		others = append(others, strings.TrimPrefix(*item.Key, prefix))
+		// The earlier marker wins, ties are broken by mount id
+		if theirs.Before(ours) || theirs.Equal(ours) && *item.Key < marker {
+			yield = true
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	if w.ebusy && yield {
+		s3Log.Warnf("Conflict detected: %v is being written by %v, postponing the flush",
+			key, strings.Join(others, ", "))
+		w.End(key)
+		return syscall.EBUSY
+	}
+	s3Log.Warnf("Conflict detected: %v is also being written by %v, one of the changes will be lost",
+		key, strings.Join(others, ", "))
+	return nil
+}
+
+// End removes our marker for key
+func (w *WriteIntents) End(key string) {
+	if w == nil {
+		return
+	}
+	marker := w.keyPrefix(key) + w.mountId
+	_, err := w.cloud.DeleteBlob(&DeleteBlobInput{Key: marker})
+	if err != nil && mapAwsError(err) != syscall.ENOENT {
+		log.Debugf("Failed to remove intent marker %v: %v", marker, err)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"strings"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type WriteIntentTest struct{}
+
+var _ = Suite(&WriteIntentTest{})
+
+func (s *WriteIntentTest) TestWriteIntentNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.WriteIntent = "ebusy"
+		flags.RetryInterval = 20 * time.Millisecond
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+
+	// a is writing the file, so b has to wait
+	t.Assert(a.fs.writeIntents.Begin("file"), IsNil)
+	c.Clock.Advance(time.Second)
+	inode, err := b.WriteFile("file", []byte("b"))
+	t.Assert(err, IsNil)
+	t.Assert(inode.SyncFile(), Equals, syscall.EBUSY)
+	_, ok := c.Store.Get("file")
+	t.Assert(ok, Equals, false)
+
+	// and flushes the file when a is done
+	a.fs.writeIntents.End("file")
+	t.Assert(waitUntil(func() bool {
+		data, ok := c.Store.Get("file")
+		return ok && string(data) == "b"
+	}), Equals, true)
+	t.Assert(inode.SyncFile(), IsNil)
+
+	// Markers of crashed mounts expire
+	t.Assert(a.fs.writeIntents.Begin("file"), IsNil)
+	c.Clock.Advance(2 * time.Minute)
+	t.Assert(b.WriteAndSync("file", []byte("b2")), IsNil)
+
+	// Markers are temporary objects
+	t.Assert(b.fs.isTempKey(b.fs.writeIntents.keyPrefix("file")), Equals, true)
+}
+
+func (s *WriteIntentTest) TestWriteIntentAbortNoCloud(t *C) {
+	c, err := NewSimCluster(1, func(i int, flags *cfg.FlagStorage) {
+		flags.WriteIntent = "ebusy"
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	m := c.Mounts[0]
+	markers := func() (n int) {
+		for _, key := range c.Store.Keys() {
+			if strings.HasPrefix(key, m.fs.writeIntents.keyPrefix("file")) {
+				n++
+			}
+		}
+		return
+	}
+	inode, err := m.WriteFile("file", []byte("data"))
+	t.Assert(err, IsNil)
+	cloud, key := inode.cloud()
+
+	// Markers of uploads which failed to start are removed
+	m.Conn.FailNext("MultipartBlobBegin", 1, syscall.EIO)
+	inode.mu.Lock()
+	params := inode.multipartBeginInput(key)
+	inode.mu.Unlock()
+	inode.beginMultipartUpload(cloud, params)
+	t.Assert(inode.mpu, IsNil)
+	inode.mu.Unlock()
+	t.Assert(markers(), Equals, 0)
+
+	// and so are markers of aborted uploads
+	inode.beginMultipartUpload(cloud, params)
+	t.Assert(inode.mpu, NotNil)
+	t.Assert(markers(), Equals, 1)
+	inode.abortMultipart()
+	inode.mu.Unlock()
+	t.Assert(waitUntil(func() bool { return markers() == 0 }), Equals, true)
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// With --writer-stamp, objects uploaded by the mount carry its identity in
+// x-amz-meta-geesefs-writer:
+//
+//	<mount name>:<mount UUID>:<pid>
+//
+// so that the mount which produced an object can be found when several
+// mounts write to the same bucket. The mount name is the host name unless
+// set with --mount-name, the UUID is new on every mount. The last writer of a
+// file is shown in the user.geesefs.writer xattr.
+
+const writerMetaKey = "geesefs-writer"
+const writerXattr = "user.geesefs.writer"
+
+func mountIdentity(flags *cfg.FlagStorage) string {
+	name := flags.MountName
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return fmt.Sprintf("%v:%v:%v", name, uuid.New(), os.Getpid())
+}
+
+// stampWriter adds the identity of the mount to the metadata of an upload
+func (fs *Goofys) stampWriter(meta map[string]*string) map[string]*string {
+	if fs.writer == "" {
+		return meta
+	}
+	if meta == nil {
+		meta = make(map[string]*string)
+	}
+	meta[writerMetaKey] = PString(fs.writer)
+	return meta
+}
+
+// LOCKS_REQUIRED(inode.mu)
+func (inode *Inode) lastWriter() ([]byte, error) {
+	err := inode.fillXattr()
+	if err != nil {
+		return nil, err
+	}
+	writer := inode.userMetadata[writerMetaKey]
+	if writer == nil {
+		return nil, ENOATTR
+	}
+	return writer, nil
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+type WriterStampTest struct{}
+
+var _ = Suite(&WriterStampTest{})
+
+func (s *WriterStampTest) TestWriterStampNoCloud(t *C) {
+	c, err := NewSimCluster(2, func(i int, flags *cfg.FlagStorage) {
+		flags.WriterStamp = true
+		flags.MountName = fmt.Sprintf("mount%v", i)
+	})
+	t.Assert(err, IsNil)
+	defer c.Shutdown()
+	a, b := c.Mounts[0], c.Mounts[1]
+	t.Assert(strings.HasPrefix(a.fs.writer, "mount0:"), Equals, true)
+	t.Assert(strings.HasSuffix(a.fs.writer, fmt.Sprintf(":%v", os.Getpid())), Equals, true)
+
+	t.Assert(a.WriteAndSync("file", []byte("a")), IsNil)
+	inode, err := a.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	writer, err := inode.GetXattr(writerXattr)
+	t.Assert(err, IsNil)
+	t.Assert(string(writer), Equals, a.fs.writer)
+
+	inode, err = b.fs.LookupPath("file")
+	t.Assert(err, IsNil)
+	writer, err = inode.GetXattr(writerXattr)
+	t.Assert(err, IsNil)
+	t.Assert(string(writer), Equals, a.fs.writer)
+
+	// Objects written by other clients have no writer
+	c.Store.Put("other", []byte("x"), nil)
+	inode, err = b.fs.LookupPath("other")
+	t.Assert(err, IsNil)
+	_, err = inode.GetXattr(writerXattr)
+	t.Assert(err, Equals, ENOATTR)
+}
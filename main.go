@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"strings"
 	"time"
 
 	"context"
@@ -64,15 +63,39 @@ func registerSIGINTHandler(fs *core.Goofys, mfs core.MountedFS, flags *cfg.FlagS
 	}()
 }
 
+// newApp returns the application for the command line. Bulk commands are
+// only recognized with --bulk: otherwise buckets named like them (for example
+// in fstab) would stop being mounted
+func newApp(args []string) *cli.App {
+	app := cfg.NewApp()
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+		if arg == "--bulk" || arg == "-bulk" {
+			app.Commands = bulkCommands()
+			break
+		}
+	}
+	return app
+}
+
 func main() {
 	messagePath()
 
-	app := cfg.NewApp()
+	args := cfg.MessageMountFlags(os.Args)
+	app := newApp(args)
 
 	var flags *cfg.FlagStorage
 	var child *os.Process
 
 	app.Action = func(c *cli.Context) (err error) {
+		if c.Bool("bulk") {
+			fmt.Fprintf(os.Stderr, "Error: --bulk requires a command.\n\n")
+			cli.ShowAppHelp(c)
+			os.Exit(1)
+		}
+
 		// We should get two arguments exactly. Otherwise error out.
 		if len(c.Args()) != 2 {
 			fmt.Fprintf(
@@ -96,6 +119,15 @@ func main() {
 			flags.Cleanup()
 		}()
 
+		var httpAuth *core.HTTPAuth
+		if flags.HTTPAuth != "" {
+			httpAuth, err = core.LoadHTTPAuth(flags.HTTPAuth)
+			if err != nil {
+				err = fmt.Errorf("Unable to load --http-auth: %v", err)
+				return
+			}
+		}
+
 		var daemonizer *Daemonizer
 		if !canDaemonize {
 			flags.Foreground = true
@@ -128,11 +160,7 @@ func main() {
 		}
 		if pprof != "" {
 			go func() {
-				addr := pprof
-				if strings.Index(addr, ":") == -1 {
-					addr = "127.0.0.1:"+addr
-				}
-				log.Println(http.ListenAndServe(addr, nil))
+				log.Println(serveHTTP(pprof, httpAuth))
 			}()
 		}
 
@@ -158,6 +186,19 @@ func main() {
 			// Let the user unmount with Ctrl-C (SIGINT)
 			registerSIGINTHandler(fs, mfs, flags)
 
+			if pprof != "" {
+				// Export latency histograms, the change journal, open handles, cache prefill and metadata
+				// migrations on the same port as pprof
+				http.Handle("/metrics", fs.LatencyHandler())
+				http.Handle("/changes", fs.ChangesHandler())
+				http.Handle("/handles", fs.HandlesHandler())
+				http.Handle("/prefill", fs.PrefillHandler())
+				http.Handle("/migrations", fs.MetaMigrationsHandler())
+				if flags.ServeCache {
+					http.Handle("/cache", fs.CacheHandler())
+				}
+			}
+
 			// Drop root privileges
 			if flags.Setuid != 0 {
 				setuid(flags.Setuid)
@@ -179,7 +220,7 @@ func main() {
 		return
 	}
 
-	err := app.Run(cfg.MessageMountFlags(os.Args))
+	err := app.Run(args)
 	if err != nil {
 		if flags != nil && !flags.Foreground && child != nil {
 			log.Fatalln("Unable to mount file system, see syslog for details")
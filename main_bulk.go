@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/yandex-cloud/geesefs/core"
+	"github.com/yandex-cloud/geesefs/core/cfg"
+)
+
+// Bulk operations bypass FUSE and work directly with the bucket:
+// geesefs --bulk [global options] cp|mv|rm [options] bucket[:prefix] paths...
+var bulkFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "r, recursive",
+		Usage: "Operate on everything under the given paths",
+	},
+	cli.IntFlag{
+		Name:  "j, jobs",
+		Value: 64,
+		Usage: "Number of parallel requests",
+	},
+	cli.StringSliceFlag{
+		Name: "refresh",
+		Usage: "Refresh caches of affected directories in this mountpoint of the same bucket[:prefix]" +
+			" after the operation. May be repeated.",
+	},
+}
+
+func bulkCommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:      "cp",
+			Usage:     "Copy objects with server-side copies",
+			ArgsUsage: "bucket[:prefix] SRC DST",
+			Flags:     bulkFlags,
+			Action:    bulkAction("cp", 3),
+		},
+		{
+			Name:      "mv",
+			Usage:     "Move objects with server-side copies and batch deletes",
+			ArgsUsage: "bucket[:prefix] SRC DST",
+			Flags:     bulkFlags,
+			Action:    bulkAction("mv", 3),
+		},
+		{
+			Name:      "rm",
+			Usage:     "Remove objects with batch deletes",
+			ArgsUsage: "bucket[:prefix] PATH...",
+			Flags:     bulkFlags,
+			Action:    bulkAction("rm", 2),
+		},
+		{
+			Name: "snapshot",
+			Usage: "Write a consistent listing of a directory with ETags as a --key-manifest file," +
+				" to mount it later as it was",
+			ArgsUsage: "bucket[:prefix] [PATH]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "o, output",
+					Usage: "Write the manifest to this file instead of stdout",
+				},
+			},
+			Action: snapshotAction,
+		},
+		{
+			Name: "clone",
+			Usage: "Show a snapshot under a new directory without copying data, in mounts with --clones." +
+				" Snapshots of versioned buckets keep showing the snapshotted versions",
+			ArgsUsage: "bucket[:prefix] MANIFEST PATH",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "cow",
+					Usage: "Allow changes in the clone, stored under PATH, instead of making it read-only",
+				},
+			},
+			Action: cloneAction,
+		},
+		{
+			Name:      "repair-renames",
+			Usage:     "Finish or roll back directory renames interrupted by crashed mounts with --rename-journal",
+			ArgsUsage: "bucket[:prefix]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "rollback",
+					Usage: "Move objects back to the old directory instead of finishing the rename",
+				},
+				cli.IntFlag{
+					Name:  "j, jobs",
+					Value: 64,
+					Usage: "Number of parallel requests",
+				},
+			},
+			Action: repairRenamesAction,
+		},
+		{
+			Name: "attest",
+			Usage: "Write a signed report of keys, sizes, ETags, version IDs and checksums of metadata objects" +
+				" for archival, or verify a report against the bucket",
+			ArgsUsage: "bucket[:prefix] [PATH]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "Ed25519 key in PEM format: private to sign reports, private or public to verify them",
+				},
+				cli.BoolFlag{
+					Name:  "hash",
+					Usage: "Compute SHA-256 checksums of all objects, not only of metadata objects",
+				},
+				cli.StringFlag{
+					Name:  "o, output",
+					Usage: "Write the report to this file instead of stdout",
+				},
+				cli.StringFlag{
+					Name:  "verify",
+					Usage: "Verify this report instead of writing a new one",
+				},
+				cli.IntFlag{
+					Name:  "j, jobs",
+					Value: 64,
+					Usage: "Number of parallel requests",
+				},
+			},
+			Action: attestAction,
+		},
+	}
+}
+
+func bulkAction(op string, minArgs int) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if len(c.Args()) < minArgs || op != "rm" && len(c.Args()) != minArgs {
+			fmt.Fprintf(os.Stderr, "Usage: %s --bulk [global options] %s [options] %s\n\nOptions:\n",
+				c.App.Name, op, c.Command.ArgsUsage)
+			for _, f := range bulkFlags {
+				fmt.Fprintf(os.Stderr, "   %v\n", f)
+			}
+			os.Exit(1)
+		}
+		flags := cfg.PopulateFlags(c.Parent())
+		if flags == nil {
+			return fmt.Errorf("invalid arguments")
+		}
+		defer flags.Cleanup()
+		cfg.InitLoggers("stderr")
+
+		args := c.Args()
+		bulk, err := core.NewBulkOps(args[0], flags, c.Int("jobs"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+		var changed []string
+		var n int
+		if op == "rm" {
+			for _, p := range args[1:] {
+				var removed int
+				removed, err = bulk.Remove(p, c.Bool("recursive"))
+				n += removed
+				changed = append(changed, p)
+				if err != nil {
+					break
+				}
+			}
+		} else {
+			n, err = bulk.Copy(args[1], args[2], c.Bool("recursive"), op == "mv")
+			changed = append(changed, args[2])
+			if op == "mv" {
+				changed = append(changed, args[1])
+			}
+		}
+		refreshMountDirs(c.StringSlice("refresh"), changed, flags)
+		if err != nil {
+			log.Errorf("%v %v: %v", op, strings.Join(args[1:], " "), err)
+			return err
+		}
+		log.Infof("%v: %v objects", op, n)
+		return nil
+	}
+}
+
+func snapshotAction(c *cli.Context) error {
+	if len(c.Args()) < 1 || len(c.Args()) > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s --bulk [global options] snapshot [-o FILE] %s\n",
+			c.App.Name, c.Command.ArgsUsage)
+		os.Exit(1)
+	}
+	flags := cfg.PopulateFlags(c.Parent())
+	if flags == nil {
+		return fmt.Errorf("invalid arguments")
+	}
+	defer flags.Cleanup()
+	cfg.InitLoggers("stderr")
+
+	bulk, err := core.NewBulkOps(c.Args()[0], flags, 1)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	snap, err := bulk.Snapshot(c.Args().Get(1))
+	if err != nil {
+		log.Errorf("snapshot %v: %v", c.Args().Get(1), err)
+		return err
+	}
+	out := os.Stdout
+	if c.String("output") != "" {
+		out, err = os.Create(c.String("output"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+		defer out.Close()
+	}
+	err = snap.WriteManifest(out)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	log.Infof("snapshot: %v objects at %v", len(snap.Items), snap.Time)
+	return nil
+}
+
+func cloneAction(c *cli.Context) error {
+	if len(c.Args()) != 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %s --bulk [global options] clone [--cow] %s\n",
+			c.App.Name, c.Command.ArgsUsage)
+		os.Exit(1)
+	}
+	flags := cfg.PopulateFlags(c.Parent())
+	if flags == nil {
+		return fmt.Errorf("invalid arguments")
+	}
+	defer flags.Cleanup()
+	cfg.InitLoggers("stderr")
+
+	args := c.Args()
+	f, err := os.Open(args[1])
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	snap, err := core.ReadSnapshot(f, args[1])
+	f.Close()
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	bulk, err := core.NewBulkOps(args[0], flags, 1)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	err = bulk.Clone(snap, args[2], c.Bool("cow"))
+	if err != nil {
+		log.Errorf("clone %v: %v", args[2], err)
+		return err
+	}
+	log.Infof("clone: %v objects of %v", len(snap.Items), snap.Prefix)
+	return nil
+}
+
+func repairRenamesAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s --bulk [global options] repair-renames [--rollback] %s\n",
+			c.App.Name, c.Command.ArgsUsage)
+		os.Exit(1)
+	}
+	flags := cfg.PopulateFlags(c.Parent())
+	if flags == nil {
+		return fmt.Errorf("invalid arguments")
+	}
+	defer flags.Cleanup()
+	cfg.InitLoggers("stderr")
+
+	bulk, err := core.NewBulkOps(c.Args()[0], flags, c.Int("jobs"))
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	n, err := bulk.RepairRenames(c.Bool("rollback"))
+	if err != nil {
+		log.Errorf("repair-renames: %v", err)
+		return err
+	}
+	log.Infof("repair-renames: %v renames repaired", n)
+	return nil
+}
+
+// refreshMountDirs makes running mounts notice changes made through the backend
+func refreshMountDirs(mountpoints []string, changed []string, flags *cfg.FlagStorage) {
+	seen := make(map[string]bool)
+	for _, mnt := range mountpoints {
+		for _, p := range changed {
+			dir := path.Dir("/" + strings.Trim(p, "/"))
+			full := filepath.Join(mnt, filepath.FromSlash(dir))
+			if seen[full] {
+				continue
+			}
+			seen[full] = true
+			err := refreshMountDir(full, flags)
+			if err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to refresh %v: %v", full, err)
+			}
+		}
+	}
+}
+
+func attestAction(c *cli.Context) error {
+	if len(c.Args()) < 1 || len(c.Args()) > 2 || c.String("key") == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s --bulk [global options] attest --key KEY [--hash] [-o FILE | --verify REPORT] %s\n",
+			c.App.Name, c.Command.ArgsUsage)
+		os.Exit(1)
+	}
+	flags := cfg.PopulateFlags(c.Parent())
+	if flags == nil {
+		return fmt.Errorf("invalid arguments")
+	}
+	defer flags.Cleanup()
+	cfg.InitLoggers("stderr")
+
+	priv, pub, err := core.LoadAttestKey(c.String("key"))
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	bulk, err := core.NewBulkOps(c.Args()[0], flags, c.Int("jobs"))
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+
+	if c.String("verify") != "" {
+		data, err := os.ReadFile(c.String("verify"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+		report, err := core.ReadAttestReport(data, pub)
+		if err != nil {
+			log.Errorf("%v: %v", c.String("verify"), err)
+			return err
+		}
+		diffs, err := bulk.VerifyAttestReport(report)
+		if err != nil {
+			log.Errorf("attest --verify: %v", err)
+			return err
+		}
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+		if len(diffs) > 0 {
+			return fmt.Errorf("%v differences from the report of %v", len(diffs), report.Time)
+		}
+		log.Infof("attest: %v objects match the report of %v", len(report.Objects), report.Time)
+		return nil
+	}
+
+	if priv == nil {
+		err = fmt.Errorf("%v: a private key is required to sign reports", c.String("key"))
+		log.Errorf("%v", err)
+		return err
+	}
+	report, err := bulk.Attest(c.Args().Get(1), c.Bool("hash"))
+	if err != nil {
+		log.Errorf("attest %v: %v", c.Args().Get(1), err)
+		return err
+	}
+	data, err := report.Sign(priv)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	out := os.Stdout
+	if c.String("output") != "" {
+		out, err = os.Create(c.String("output"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+		defer out.Close()
+	}
+	_, err = out.Write(append(data, '\n'))
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	log.Infof("attest: %v objects", len(report.Objects))
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/yandex-cloud/geesefs/core"
+)
+
+// serveHTTP serves pprof and geesefs endpoints on a port, host:port or
+// unix:/path/to/socket. Operations exposing file data need the admin role.
+func serveHTTP(addr string, auth *core.HTTPAuth) error {
+	var l net.Listener
+	var err error
+	if strings.HasPrefix(addr, "unix:") {
+		path := addr[len("unix:"):]
+		// Remove the socket left by a previous run
+		os.Remove(path)
+		l, err = net.Listen("unix", path)
+	} else {
+		if strings.Index(addr, ":") == -1 {
+			addr = "127.0.0.1:" + addr
+		}
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler: auth.Handler(http.DefaultServeMux, core.HTTPAdminPaths...),
+	}
+	if auth != nil {
+		srv.ConnContext = auth.ConnContext
+	}
+	return srv.Serve(l)
+}
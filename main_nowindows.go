@@ -28,6 +28,7 @@ import (
 
 	"github.com/kardianos/osext"
 	daemon "github.com/sevlyar/go-daemon"
+	"golang.org/x/sys/unix"
 
 	"github.com/yandex-cloud/geesefs/core/cfg"
 	"github.com/yandex-cloud/geesefs/core"
@@ -163,3 +164,8 @@ func setuid(uid int) error {
 func setgid(gid int) error {
 	return syscall.Setgid(gid)
 }
+
+// refreshMountDir refreshes the cache of a directory in a running mount
+func refreshMountDir(dir string, flags *cfg.FlagStorage) error {
+	return unix.Setxattr(dir, flags.RefreshAttr, []byte{}, 0)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func runApp(t *testing.T, args ...string) (command, bucket string) {
+	app := newApp(args)
+	app.Action = func(c *cli.Context) error {
+		bucket = c.Args().First()
+		return nil
+	}
+	for i := range app.Commands {
+		app.Commands[i].Action = func(c *cli.Context) error {
+			command, bucket = c.Command.Name, c.Args().First()
+			return nil
+		}
+	}
+	if err := app.Run(args); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestBucketNamedLikeCommand(t *testing.T) {
+	for _, name := range []string{"cp", "mv", "rm", "snapshot", "clone", "repair-renames", "attest"} {
+		command, bucket := runApp(t, "geesefs", name, "/mnt")
+		if command != "" || bucket != name {
+			t.Errorf("%v /mnt ran command %q with bucket %q instead of mounting", name, command, bucket)
+		}
+		command, bucket = runApp(t, "geesefs", "--bulk", name, "bucket")
+		if command != name || bucket != "bucket" {
+			t.Errorf("--bulk %v bucket ran command %q with bucket %q", name, command, bucket)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"syscall"
 
 	"github.com/yandex-cloud/geesefs/core/cfg"
@@ -74,3 +75,12 @@ func setuid(uid int) error {
 func setgid(gid int) error {
 	return nil
 }
+
+// refreshMountDir refreshes the cache of a directory in a running mount
+func refreshMountDir(dir string, flags *cfg.FlagStorage) error {
+	f, err := os.Open(filepath.Join(dir, flags.RefreshFilename))
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
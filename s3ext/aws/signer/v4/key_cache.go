@@ -0,0 +1,50 @@
+package v4
+
+import (
+	"sync"
+	"time"
+)
+
+// Derived signing keys only depend on the secret key, region, service and
+// date, so they are cached instead of computing 4 HMACs for every request.
+var signingKeys = derivedKeyCache{
+	values: make(map[string]derivedKey),
+}
+
+// Cache is cleared when it grows above this size, which only happens when
+// credentials are rotated or many regions are used
+const maxDerivedKeys = 64
+
+type derivedKey struct {
+	date      string
+	secretKey string
+	key       []byte
+}
+
+type derivedKeyCache struct {
+	mu     sync.RWMutex
+	values map[string]derivedKey
+}
+
+func (c *derivedKeyCache) get(accessKey, region, service, secretKey string, dt time.Time) []byte {
+	date := formatShortTime(dt)
+	lookup := accessKey + "/" + region + "/" + service
+	c.mu.RLock()
+	cached, ok := c.values[lookup]
+	c.mu.RUnlock()
+	if ok && cached.date == date && cached.secretKey == secretKey {
+		return cached.key
+	}
+	key := deriveSigningKey(region, service, secretKey, dt)
+	c.mu.Lock()
+	if len(c.values) >= maxDerivedKeys {
+		c.values = make(map[string]derivedKey)
+	}
+	c.values[lookup] = derivedKey{
+		date:      date,
+		secretKey: secretKey,
+		key:       key,
+	}
+	c.mu.Unlock()
+	return key
+}
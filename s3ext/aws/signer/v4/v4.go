@@ -680,7 +680,8 @@ func (ctx *signingCtx) buildStringToSign() {
 }
 
 func (ctx *signingCtx) buildSignature() {
-	creds := deriveSigningKey(ctx.Region, ctx.ServiceName, ctx.credValues.SecretAccessKey, ctx.Time)
+	creds := signingKeys.get(ctx.credValues.AccessKeyID, ctx.Region, ctx.ServiceName,
+		ctx.credValues.SecretAccessKey, ctx.Time)
 	signature := hmacSHA256(creds, []byte(ctx.stringToSign))
 	ctx.signature = hex.EncodeToString(signature)
 }
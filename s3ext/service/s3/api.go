@@ -853,6 +853,83 @@ func (c *S3) CreateMultipartUploadWithContext(ctx aws.Context, input *CreateMult
 	return out, req.Send()
 }
 
+const opCreateSession = "CreateSession"
+
+// CreateSessionRequest generates a "aws/request.Request" representing the
+// client's request for the CreateSession operation. The "output" return
+// value will be populated with the request's response once the request completes
+// successfully.
+//
+// Use "Send" method on the returned Request to send the API call to the service.
+// the "output" return value is not valid until after Send returns without error.
+//
+// See CreateSession for more information on using the CreateSession
+// API call, and error handling.
+//
+// This method is useful when you want to inject custom logic or configuration
+// into the SDK's request lifecycle. Such as custom headers, or retry logic.
+//
+//	// Example sending a request using the CreateSessionRequest method.
+//	req, resp := client.CreateSessionRequest(params)
+//
+//	err := req.Send()
+//	if err == nil { // resp is now filled
+//	    fmt.Println(resp)
+//	}
+//
+// See also, https://docs.aws.amazon.com/goto/WebAPI/s3-2006-03-01/CreateSession
+func (c *S3) CreateSessionRequest(input *CreateSessionInput) (req *request.Request, output *CreateSessionOutput) {
+	op := &request.Operation{
+		Name:       opCreateSession,
+		HTTPMethod: "GET",
+		HTTPPath:   "/{Bucket}?session",
+	}
+
+	if input == nil {
+		input = &CreateSessionInput{}
+	}
+
+	output = &CreateSessionOutput{}
+	req = c.newRequest(op, input, output)
+	return
+}
+
+// CreateSession API operation for Amazon Simple Storage Service.
+//
+// Creates a session that establishes temporary security credentials for
+// requests to a directory bucket of S3 Express One Zone. The credentials
+// expire after 5 minutes. Requests with them must be signed with the
+// "s3express" service name and carry the session token in the
+// X-Amz-S3session-Token header instead of X-Amz-Security-Token.
+//
+// Returns awserr.Error for service API and SDK errors. Use runtime type assertions
+// with awserr.Error's Code and Message methods to get detailed information about
+// the error.
+//
+// See the AWS API reference guide for Amazon Simple Storage Service's
+// API operation CreateSession for usage and error information.
+// See also, https://docs.aws.amazon.com/goto/WebAPI/s3-2006-03-01/CreateSession
+func (c *S3) CreateSession(input *CreateSessionInput) (*CreateSessionOutput, error) {
+	req, out := c.CreateSessionRequest(input)
+	return out, req.Send()
+}
+
+// CreateSessionWithContext is the same as CreateSession with the addition of
+// the ability to pass a context and additional request options.
+//
+// See CreateSession for details on how to use this API operation.
+//
+// The context must be non-nil and will be used for request cancellation. If
+// the context is nil a panic will occur. In the future the SDK may create
+// sub-contexts for http.Requests. See https://golang.org/pkg/context/
+// for more information on using Contexts.
+func (c *S3) CreateSessionWithContext(ctx aws.Context, input *CreateSessionInput, opts ...request.Option) (*CreateSessionOutput, error) {
+	req, out := c.CreateSessionRequest(input)
+	req.SetContext(ctx)
+	req.ApplyOptions(opts...)
+	return out, req.Send()
+}
+
 const opDeleteBucket = "DeleteBucket"
 
 // DeleteBucketRequest generates a "aws/request.Request" representing the
@@ -12494,6 +12571,18 @@ func (s *CompletedMultipartUpload) SetParts(v []*CompletedPart) *CompletedMultip
 type CompletedPart struct {
 	_ struct{} `type:"structure"`
 
+	// Base64-encoded checksums of the part. Only the one of the algorithm
+	// of the upload is used.
+	ChecksumCRC32 *string `type:"string"`
+
+	ChecksumCRC32C *string `type:"string"`
+
+	ChecksumCRC64NVME *string `type:"string"`
+
+	ChecksumSHA1 *string `type:"string"`
+
+	ChecksumSHA256 *string `type:"string"`
+
 	// Entity tag returned when the part was uploaded.
 	ETag *string `type:"string"`
 
@@ -13324,6 +13413,18 @@ func (s *CopyObjectResult) SetLastModified(v time.Time) *CopyObjectResult {
 type CopyPartResult struct {
 	_ struct{} `type:"structure"`
 
+	// Base64-encoded checksums of the part. Returned for uploads with a
+	// checksum algorithm.
+	ChecksumCRC32 *string `type:"string"`
+
+	ChecksumCRC32C *string `type:"string"`
+
+	ChecksumCRC64NVME *string `type:"string"`
+
+	ChecksumSHA1 *string `type:"string"`
+
+	ChecksumSHA256 *string `type:"string"`
+
 	// Entity tag of the object.
 	ETag *string `type:"string"`
 
@@ -14092,6 +14193,135 @@ func (s *CreateMultipartUploadOutput) SetUploadId(v string) *CreateMultipartUplo
 	return s
 }
 
+type CreateSessionInput struct {
+	_ struct{} `locationName:"CreateSessionRequest" type:"structure"`
+
+	// Bucket is a required field
+	Bucket *string `location:"uri" locationName:"Bucket" type:"string" required:"true"`
+
+	// ReadWrite (default) or ReadOnly
+	SessionMode *string `location:"header" locationName:"x-amz-create-session-mode" type:"string" enum:"SessionMode"`
+}
+
+// String returns the string representation
+func (s CreateSessionInput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s CreateSessionInput) GoString() string {
+	return s.String()
+}
+
+// Validate inspects the fields of the type to determine if they are valid.
+func (s *CreateSessionInput) Validate() error {
+	invalidParams := request.ErrInvalidParams{Context: "CreateSessionInput"}
+	if s.Bucket == nil {
+		invalidParams.Add(request.NewErrParamRequired("Bucket"))
+	}
+	if s.Bucket != nil && len(*s.Bucket) < 1 {
+		invalidParams.Add(request.NewErrParamMinLen("Bucket", 1))
+	}
+
+	if invalidParams.Len() > 0 {
+		return invalidParams
+	}
+	return nil
+}
+
+// SetBucket sets the Bucket field's value.
+func (s *CreateSessionInput) SetBucket(v string) *CreateSessionInput {
+	s.Bucket = &v
+	return s
+}
+
+func (s *CreateSessionInput) getBucket() (v string) {
+	if s.Bucket == nil {
+		return v
+	}
+	return *s.Bucket
+}
+
+// SetSessionMode sets the SessionMode field's value.
+func (s *CreateSessionInput) SetSessionMode(v string) *CreateSessionInput {
+	s.SessionMode = &v
+	return s
+}
+
+type CreateSessionOutput struct {
+	_ struct{} `type:"structure"`
+
+	// Credentials is a required field
+	Credentials *SessionCredentials `locationName:"Credentials" type:"structure" required:"true"`
+}
+
+// String returns the string representation
+func (s CreateSessionOutput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s CreateSessionOutput) GoString() string {
+	return s.String()
+}
+
+// SetCredentials sets the Credentials field's value.
+func (s *CreateSessionOutput) SetCredentials(v *SessionCredentials) *CreateSessionOutput {
+	s.Credentials = v
+	return s
+}
+
+// The temporary credentials returned by CreateSession
+type SessionCredentials struct {
+	_ struct{} `type:"structure"`
+
+	// AccessKeyId is a required field
+	AccessKeyId *string `locationName:"AccessKeyId" type:"string" required:"true"`
+
+	// Expiration is a required field
+	Expiration *time.Time `locationName:"Expiration" type:"timestamp" required:"true"`
+
+	// SecretAccessKey is a required field
+	SecretAccessKey *string `locationName:"SecretAccessKey" type:"string" required:"true" sensitive:"true"`
+
+	// SessionToken is a required field
+	SessionToken *string `locationName:"SessionToken" type:"string" required:"true" sensitive:"true"`
+}
+
+// String returns the string representation
+func (s SessionCredentials) String() string {
+	return awsutil.Prettify(s)
+}
+
+// GoString returns the string representation
+func (s SessionCredentials) GoString() string {
+	return s.String()
+}
+
+// SetAccessKeyId sets the AccessKeyId field's value.
+func (s *SessionCredentials) SetAccessKeyId(v string) *SessionCredentials {
+	s.AccessKeyId = &v
+	return s
+}
+
+// SetExpiration sets the Expiration field's value.
+func (s *SessionCredentials) SetExpiration(v time.Time) *SessionCredentials {
+	s.Expiration = &v
+	return s
+}
+
+// SetSecretAccessKey sets the SecretAccessKey field's value.
+func (s *SessionCredentials) SetSecretAccessKey(v string) *SessionCredentials {
+	s.SecretAccessKey = &v
+	return s
+}
+
+// SetSessionToken sets the SessionToken field's value.
+func (s *SessionCredentials) SetSessionToken(v string) *SessionCredentials {
+	s.SessionToken = &v
+	return s
+}
+
 // The container element for specifying the default Object Lock retention settings
 // for new objects placed in the specified bucket.
 //
@@ -37115,6 +37345,22 @@ func ServerSideEncryption_Values() []string {
 	}
 }
 
+const (
+	// SessionModeReadOnly is a SessionMode enum value
+	SessionModeReadOnly = "ReadOnly"
+
+	// SessionModeReadWrite is a SessionMode enum value
+	SessionModeReadWrite = "ReadWrite"
+)
+
+// SessionMode_Values returns all elements of the SessionMode enum
+func SessionMode_Values() []string {
+	return []string{
+		SessionModeReadOnly,
+		SessionModeReadWrite,
+	}
+}
+
 const (
 	// SseKmsEncryptedObjectsStatusEnabled is a SseKmsEncryptedObjectsStatus enum value
 	SseKmsEncryptedObjectsStatusEnabled = "Enabled"
@@ -37155,6 +37401,9 @@ const (
 
 	// StorageClassOutposts is a StorageClass enum value
 	StorageClassOutposts = "OUTPOSTS"
+
+	// StorageClassExpressOnezone is a StorageClass enum value
+	StorageClassExpressOnezone = "EXPRESS_ONEZONE"
 )
 
 // StorageClass_Values returns all elements of the StorageClass enum
@@ -37168,6 +37417,7 @@ func StorageClass_Values() []string {
 		StorageClassGlacier,
 		StorageClassDeepArchive,
 		StorageClassOutposts,
+		StorageClassExpressOnezone,
 	}
 }
 